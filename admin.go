@@ -0,0 +1,228 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultAdminMaxMessageSize = 1 << 20
+
+// ConnInfo describes a connection for the Admin endpoint.
+type ConnInfo struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	IP          string    `json:"ip,omitempty"`
+	Subprotocol string    `json:"subprotocol,omitempty"`
+	Keys        []string  `json:"keys,omitempty"`
+	Rooms       []string  `json:"rooms,omitempty"`
+	OpenedAt    time.Time `json:"opened_at"`
+	Uptime      float64   `json:"uptime_seconds"`
+	LastRead    time.Time `json:"last_read"`
+	LastWrite   time.Time `json:"last_write"`
+
+	// WriteQueue is the number of messages in the write queue of the
+	// connection, and SendQueue the number of messages queued for the
+	// connection by the hub.
+	WriteQueue int `json:"write_queue"`
+	SendQueue  int `json:"send_queue"`
+}
+
+// AdminStats summarizes the connections for the Admin endpoint.
+type AdminStats struct {
+	Connections int            `json:"connections"`
+	Rooms       map[string]int `json:"rooms,omitempty"`
+}
+
+// Admin is an http.Handler that lets operators inspect and act on the live
+// connections of a server. Mount it under a prefix with http.StripPrefix:
+//
+//	admin := &websocket.Admin{Registry: registry, Hub: hub, Auth: requireOperator}
+//	mux.Handle("/admin/", http.StripPrefix("/admin", admin))
+//
+// Admin serves the following requests, answering with JSON:
+//
+//	GET  /                 AdminStats of the connections
+//	GET  /conns            ConnInfo of each connection, ordered by ID
+//	GET  /conns/{id}       ConnInfo of a connection
+//	POST /conns/{id}/close closes a connection with the code and reason
+//	                       query parameters, by default CloseGoingAway
+//	POST /broadcast        broadcasts the request body to the room query
+//	                       parameter, or to every connection of the hub
+//	                       without a room; type=binary sends a binary
+//	                       message
+type Admin struct {
+	// Registry lists the connections.
+	Registry *Registry
+
+	// Hub, if not nil, reports the rooms and the send queues of its
+	// connections and serves the broadcast requests.
+	Hub *Hub
+
+	// Auth wraps the handler to authenticate and authorize the requests,
+	// such as a middleware checking an operator token. If nil, every
+	// request is rejected with status 403 so that the endpoint is never
+	// exposed by accident.
+	Auth func(next http.Handler) http.Handler
+
+	// MaxMessageSize is the maximum size in bytes of a broadcast message.
+	// If zero, a default of 1 MiB is used.
+	MaxMessageSize int64
+
+	once    sync.Once
+	handler http.Handler
+}
+
+// ServeHTTP implements http.Handler.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.once.Do(a.init)
+	a.handler.ServeHTTP(w, r)
+}
+
+func (a *Admin) init() {
+	if a.Auth == nil {
+		a.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", a.serveStats)
+	mux.HandleFunc("GET /conns", a.serveConns)
+	mux.HandleFunc("GET /conns/{id}", a.serveConn)
+	mux.HandleFunc("POST /conns/{id}/close", a.serveClose)
+	mux.HandleFunc("POST /broadcast", a.serveBroadcast)
+	a.handler = a.Auth(mux)
+}
+
+// info returns the description of a connection.
+func (a *Admin) info(c *Conn, now time.Time) ConnInfo {
+	ci := ConnInfo{
+		ID:          c.ID(),
+		Subprotocol: c.Subprotocol(),
+		Keys:        a.Registry.Keys(c),
+		OpenedAt:    c.OpenedAt(),
+		Uptime:      now.Sub(c.OpenedAt()).Seconds(),
+		LastRead:    c.LastRead(),
+		LastWrite:   c.LastWrite(),
+		WriteQueue:  c.WriteQueueLen(),
+	}
+	sort.Strings(ci.Keys)
+	if addr := c.RemoteAddr(); addr != nil {
+		ci.RemoteAddr = addr.String()
+	}
+	if ip := c.RealIP(); ip.IsValid() {
+		ci.IP = ip.String()
+	}
+	if a.Hub != nil {
+		ci.Rooms = a.Hub.RoomsOf(c)
+		ci.SendQueue = a.Hub.sendQueueLen(c)
+	}
+	return ci
+}
+
+func (a *Admin) serveStats(w http.ResponseWriter, r *http.Request) {
+	stats := AdminStats{Connections: a.Registry.Len()}
+	if a.Hub != nil {
+		stats.Rooms = make(map[string]int)
+		for _, room := range a.Hub.Rooms() {
+			stats.Rooms[room] = a.Hub.Len(room)
+		}
+	}
+	writeAdminJSON(w, stats)
+}
+
+func (a *Admin) serveConns(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	conns := []ConnInfo{}
+	a.Registry.Range(func(c *Conn) bool {
+		conns = append(conns, a.info(c, now))
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	writeAdminJSON(w, conns)
+}
+
+func (a *Admin) serveConn(w http.ResponseWriter, r *http.Request) {
+	c := a.Registry.Get(r.PathValue("id"))
+	if c == nil {
+		http.Error(w, "websocket: unknown connection", http.StatusNotFound)
+		return
+	}
+	writeAdminJSON(w, a.info(c, time.Now()))
+}
+
+func (a *Admin) serveClose(w http.ResponseWriter, r *http.Request) {
+	c := a.Registry.Get(r.PathValue("id"))
+	if c == nil {
+		http.Error(w, "websocket: unknown connection", http.StatusNotFound)
+		return
+	}
+	code := CloseGoingAway
+	if s := r.URL.Query().Get("code"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || !isValidReceivedCloseCode(n) {
+			http.Error(w, "websocket: invalid close code", http.StatusBadRequest)
+			return
+		}
+		code = n
+	}
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(code, r.URL.Query().Get("reason")), time.Now().Add(writeWait))
+	_ = c.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) serveBroadcast(w http.ResponseWriter, r *http.Request) {
+	if a.Hub == nil {
+		http.Error(w, "websocket: no hub", http.StatusNotFound)
+		return
+	}
+	limit := a.MaxMessageSize
+	if limit <= 0 {
+		limit = defaultAdminMaxMessageSize
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	messageType := TextMessage
+	if r.URL.Query().Get("type") == "binary" {
+		messageType = BinaryMessage
+	}
+	if room := r.URL.Query().Get("room"); room != "" {
+		err = a.Hub.Broadcast(room, messageType, data)
+	} else {
+		err = a.Hub.BroadcastAll(messageType, data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// sendQueueLen returns the number of messages queued for the connection.
+func (h *Hub) sendQueueLen(c *Conn) int {
+	s := h.shard(c)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if hc, ok := s.clients[c]; ok {
+		return len(hc.send)
+	}
+	return 0
+}
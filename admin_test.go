@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmin(t *testing.T) {
+	var registry Registry
+	hub := &Hub{}
+	defer hub.Close()
+	u := Upgrader{Registry: &registry}
+	joined := make(chan *Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := hub.Join("lobby", c); err != nil {
+			t.Error(err)
+			return
+		}
+		joined <- c
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				hub.Remove(c)
+				return
+			}
+		}
+	}))
+	defer s.Close()
+	client, _, err := DefaultDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-joined
+
+	admin := &Admin{Registry: &registry, Hub: hub, Auth: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}}
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/conns", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated request returned status %d", w.Code)
+	}
+
+	w = do("GET", "/", "")
+	var stats AdminStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || stats.Connections != 1 || stats.Rooms["lobby"] != 1 {
+		t.Fatalf("GET / = %d %s", w.Code, w.Body)
+	}
+
+	w = do("GET", "/conns", "")
+	var conns []ConnInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &conns); err != nil || len(conns) != 1 {
+		t.Fatalf("GET /conns = %d %s", w.Code, w.Body)
+	}
+	ci := conns[0]
+	if ci.ID != server.ID() || ci.IP != "127.0.0.1" || len(ci.Rooms) != 1 || ci.Rooms[0] != "lobby" || ci.OpenedAt.IsZero() {
+		t.Fatalf("connection info %+v", ci)
+	}
+	if w := do("GET", "/conns/"+ci.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET /conns/{id} returned status %d", w.Code)
+	}
+	if w := do("GET", "/conns/unknown", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET of an unknown connection returned status %d", w.Code)
+	}
+
+	if w := do("POST", "/broadcast?room=lobby", "hello"); w.Code != http.StatusNoContent {
+		t.Fatalf("POST /broadcast = %d %s", w.Code, w.Body)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, p, err := client.ReadMessage(); err != nil || string(p) != "hello" {
+		t.Fatalf("ReadMessage() = %q, %v, want the broadcast", p, err)
+	}
+
+	if w := do("POST", "/conns/"+ci.ID+"/close?code=4000&reason=kicked", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("POST close = %d %s", w.Code, w.Body)
+	}
+	if _, _, err := client.ReadMessage(); !IsCloseError(err, 4000) {
+		t.Fatalf("ReadMessage() returned %v, want close code 4000", err)
+	}
+}
+
+func TestAdminWithoutAuth(t *testing.T) {
+	admin := &Admin{Registry: &Registry{}}
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/conns", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("request without Auth returned status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
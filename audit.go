@@ -0,0 +1,342 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of audit events.
+const (
+	// AuditConnect is recorded when a connection is upgraded.
+	AuditConnect = "connect"
+
+	// AuditAuthenticate is recorded when the Authenticate function of the
+	// upgrader accepts or rejects a handshake. The Reason of a rejection
+	// is the error of Authenticate.
+	AuditAuthenticate = "authenticate"
+
+	// AuditJoin and AuditLeave are recorded when a connection joins or
+	// leaves a room of a hub.
+	AuditJoin  = "join"
+	AuditLeave = "leave"
+
+	// AuditClose is recorded when a connection is closed, with the first
+	// close code sent or received and its reason.
+	AuditClose = "close"
+
+	// AuditViolation is recorded when a handshake is rejected by the
+	// origin or remote address policy, and when a connection is closed
+	// for a protocol error, a read limit, a rate limit or a slow consumer.
+	AuditViolation = "violation"
+)
+
+// AuditEvent is a structured record of the lifecycle of a connection.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+
+	// ConnID is the ID of the connection. ConnID is empty for the events
+	// of rejected handshakes.
+	ConnID string `json:"conn_id,omitempty"`
+
+	// RemoteAddr is the address of the client.
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	// User is the principal returned by Authenticate, if it is a string or
+	// implements fmt.Stringer.
+	User string `json:"user,omitempty"`
+
+	// Room is the room of join and leave events.
+	Room string `json:"room,omitempty"`
+
+	// Code is the close code of close and violation events.
+	Code int `json:"code,omitempty"`
+
+	// Reason describes a close, a violation or a failed authentication.
+	Reason string `json:"reason,omitempty"`
+}
+
+// EventSink receives the audit events of connections. Set the EventSink
+// field of Upgrader or FastHTTPUpgrader to record the events of the
+// connections it upgrades; hubs record the joins and leaves of these
+// connections in the same sink.
+//
+// Record is called from the goroutines of the upgrader, the connections and
+// the hubs, sometimes with hub locks held, and must return quickly. Record
+// must not retain e after it returns.
+type EventSink interface {
+	Record(e *AuditEvent)
+}
+
+// auditUser returns the user recorded for a principal.
+func auditUser(principal interface{}) string {
+	switch p := principal.(type) {
+	case string:
+		return p
+	case fmt.Stringer:
+		return p.String()
+	}
+	return ""
+}
+
+// setEventSink sets the sink of the audit events of the connection.
+func (c *Conn) setEventSink(s EventSink) {
+	if s == nil {
+		return
+	}
+	c.eventSink = s
+	c.eventUser = auditUser(c.value)
+}
+
+// audit records an event of the connection, if it has an event sink.
+func (c *Conn) audit(kind string, room string, code int, reason string) {
+	if c.eventSink == nil {
+		return
+	}
+	e := AuditEvent{
+		Time:   time.Now(),
+		Kind:   kind,
+		ConnID: c.id,
+		User:   c.eventUser,
+		Room:   room,
+		Code:   code,
+		Reason: reason,
+	}
+	if ip := c.RealIP(); ip.IsValid() {
+		e.RemoteAddr = ip.String()
+	} else if addr := c.RemoteAddr(); addr != nil {
+		e.RemoteAddr = addr.String()
+	}
+	c.eventSink.Record(&e)
+}
+
+// auditRequest records an event of a handshake that did not create a
+// connection.
+func auditRequest(s EventSink, remoteAddr, kind, reason string) {
+	if s == nil {
+		return
+	}
+	s.Record(&AuditEvent{Time: time.Now(), Kind: kind, RemoteAddr: remoteAddr, Reason: reason})
+}
+
+// auditRequest records an event of a handshake that did not create a
+// connection, with the client address of the request.
+func (u *Upgrader) auditRequest(r *http.Request, kind, reason string) {
+	if u.EventSink == nil {
+		return
+	}
+	remoteAddr := r.RemoteAddr
+	if ip := u.RemoteAddrPolicy.ClientIP(r); ip.IsValid() {
+		remoteAddr = ip.String()
+	}
+	auditRequest(u.EventSink, remoteAddr, kind, reason)
+}
+
+// auditHandshake records the authentication and the connect events of an
+// upgraded connection.
+func (c *Conn) auditHandshake(authenticated bool) {
+	if authenticated {
+		c.audit(AuditAuthenticate, "", 0, "")
+	}
+	c.audit(AuditConnect, "", 0, "")
+}
+
+const defaultEventSinkBufferSize = 1024
+
+// ErrEventLogTampered is returned by VerifyEventLog when the chain of the
+// log is broken, because a record was altered, removed or inserted.
+var ErrEventLogTampered = errors.New("websocket: event log chain broken")
+
+// JSONEventSink is an EventSink that writes the events to an io.Writer as
+// JSON lines from a goroutine, so that Record does not wait for the writes.
+// Record is called by the hub with its locks held, so it never blocks: when
+// the buffer of events is full, the event is dropped and counted, see
+// Dropped.
+//
+// Each line holds a "chain" field, the hex SHA-256 of the chain of the
+// previous line followed by the JSON encoding of the event. VerifyEventLog
+// detects the corruption of a line and the lines lost or duplicated before
+// the last line. The chain is not keyed: it does not protect the log from
+// someone able to write the file, who can recompute the chain, nor detect
+// the lines removed from the end of the log. Ship the log to a store the
+// writers of the file cannot modify for that.
+type JSONEventSink struct {
+	events chan AuditEvent
+	done   chan struct{}
+	w      *bufio.Writer
+	closer io.Closer
+	chain  [sha256.Size]byte
+
+	mu      sync.RWMutex // guards closed against Record
+	closed  bool
+	err     error // first write error, set by the writing goroutine
+	dropped atomic.Int64
+}
+
+var _ EventSink = (*JSONEventSink)(nil)
+
+// NewJSONEventSink returns a sink writing to w with a buffer of bufferSize
+// events. If bufferSize is zero, a default of 1024 is used.
+func NewJSONEventSink(w io.Writer, bufferSize int) *JSONEventSink {
+	return newJSONEventSink(w, nil, bufferSize, [sha256.Size]byte{})
+}
+
+func newJSONEventSink(w io.Writer, closer io.Closer, bufferSize int, chain [sha256.Size]byte) *JSONEventSink {
+	if bufferSize <= 0 {
+		bufferSize = defaultEventSinkBufferSize
+	}
+	s := &JSONEventSink{
+		events: make(chan AuditEvent, bufferSize),
+		done:   make(chan struct{}),
+		w:      bufio.NewWriter(w),
+		closer: closer,
+		chain:  chain,
+	}
+	go s.run()
+	return s
+}
+
+// OpenEventLog opens the named file for appending audit events, creating
+// it if needed with mode 0600. The chain of the events continues from the
+// last line of an existing file; OpenEventLog returns ErrEventLogTampered
+// if the chain of the file is broken.
+func OpenEventLog(name string, bufferSize int) (*JSONEventSink, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	_, chain, err := verifyEventLog(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return newJSONEventSink(f, f, bufferSize, chain), nil
+}
+
+// Record implements EventSink. Events recorded after Close are discarded.
+func (s *JSONEventSink) Record(e *AuditEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- *e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer of events
+// was full.
+func (s *JSONEventSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+func (s *JSONEventSink) run() {
+	defer close(s.done)
+	for e := range s.events {
+		s.write(&e)
+		if len(s.events) == 0 && s.err == nil {
+			s.err = s.w.Flush()
+		}
+	}
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+}
+
+func (s *JSONEventSink) write(e *AuditEvent) {
+	if s.err != nil {
+		return
+	}
+	p, err := json.Marshal(e)
+	if err != nil {
+		s.err = err
+		return
+	}
+	s.chain = nextEventChain(s.chain, p)
+	var line []byte
+	line = append(line, `{"chain":"`...)
+	line = hex.AppendEncode(line, s.chain[:])
+	line = append(line, `",`...)
+	line = append(line, p[1:]...)
+	line = append(line, '\n')
+	_, s.err = s.w.Write(line)
+}
+
+func nextEventChain(prev [sha256.Size]byte, event []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(event)
+	var chain [sha256.Size]byte
+	h.Sum(chain[:0])
+	return chain
+}
+
+// Close writes the recorded events, closes the file opened by OpenEventLog
+// and returns the first error writing the events.
+func (s *JSONEventSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.done
+		return s.err
+	}
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+	<-s.done
+	if s.closer != nil {
+		if err := s.closer.Close(); s.err == nil {
+			s.err = err
+		}
+	}
+	return s.err
+}
+
+// VerifyEventLog checks the chain of an event log written by a
+// JSONEventSink. It returns the number of valid records and, if the chain is
+// broken, an error matching ErrEventLogTampered that gives the line of the
+// first invalid record.
+func VerifyEventLog(r io.Reader) (int, error) {
+	n, _, err := verifyEventLog(r)
+	return n, err
+}
+
+func verifyEventLog(r io.Reader) (int, [sha256.Size]byte, error) {
+	var chain [sha256.Size]byte
+	prefix := []byte(`{"chain":"`)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	n := 0
+	for sc.Scan() {
+		line := sc.Bytes()
+		rest, ok := bytes.CutPrefix(line, prefix)
+		if !ok || len(rest) < 2*sha256.Size+2 || string(rest[2*sha256.Size:2*sha256.Size+2]) != `",` {
+			return n, chain, fmt.Errorf("%w at line %d", ErrEventLogTampered, n+1)
+		}
+		var want [sha256.Size]byte
+		if _, err := hex.Decode(want[:], rest[:2*sha256.Size]); err != nil {
+			return n, chain, fmt.Errorf("%w at line %d", ErrEventLogTampered, n+1)
+		}
+		event := append([]byte{'{'}, rest[2*sha256.Size+2:]...)
+		if nextEventChain(chain, event) != want {
+			return n, chain, fmt.Errorf("%w at line %d", ErrEventLogTampered, n+1)
+		}
+		chain = want
+		n++
+	}
+	return n, chain, sc.Err()
+}
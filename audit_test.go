@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder is an EventSink keeping the events in memory.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *eventRecorder) Record(e *AuditEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, *e)
+	r.mu.Unlock()
+}
+
+func (r *eventRecorder) kinds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kinds := make([]string, len(r.events))
+	for i, e := range r.events {
+		kinds[i] = e.Kind
+	}
+	return kinds
+}
+
+func TestAuditEvents(t *testing.T) {
+	var sink eventRecorder
+	hub := &Hub{}
+	defer hub.Close()
+	u := Upgrader{
+		EventSink: &sink,
+		Authenticate: func(r *http.Request) (interface{}, error) {
+			if user := BearerToken(r); user != "" {
+				return user, nil
+			}
+			return nil, errors.New("missing token")
+		},
+	}
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer close(done)
+		c.SetReadLimit(4)
+		if err := hub.Join("lobby", c); err != nil {
+			t.Error(err)
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+		hub.Remove(c)
+		c.Close()
+	}))
+	defer s.Close()
+
+	if _, _, err := DefaultDialer.Dial(makeWsProto(s.URL), nil); err == nil {
+		t.Fatal("Dial without a token succeeded")
+	}
+	client, _, err := DefaultDialer.Dial(makeWsProto(s.URL), http.Header{"Authorization": {"Bearer alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.WriteMessage(TextMessage, []byte("too long")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	want := []string{AuditAuthenticate, AuditAuthenticate, AuditConnect, AuditJoin, AuditViolation, AuditLeave, AuditClose}
+	if got := sink.kinds(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("recorded %v, want %v", got, want)
+	}
+	events := sink.events
+	if e := events[0]; e.ConnID != "" || e.Reason != "missing token" || e.RemoteAddr != "127.0.0.1" {
+		t.Errorf("rejected authentication recorded as %+v", e)
+	}
+	id := events[1].ConnID
+	for _, e := range events[1:] {
+		if e.ConnID != id || id == "" || e.User != "alice" || e.RemoteAddr != "127.0.0.1" {
+			t.Errorf("event %+v does not identify the connection of alice", e)
+		}
+	}
+	if e := events[3]; e.Room != "lobby" {
+		t.Errorf("join recorded as %+v", e)
+	}
+	if e := events[4]; e.Code != CloseMessageTooBig {
+		t.Errorf("violation recorded as %+v", e)
+	}
+	if e := events[6]; e.Code != CloseMessageTooBig {
+		t.Errorf("close recorded as %+v", e)
+	}
+}
+
+func TestJSONEventSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONEventSink(&buf, 0)
+	for _, kind := range []string{AuditConnect, AuditJoin, AuditClose} {
+		sink.Record(&AuditEvent{Time: time.Now(), Kind: kind, ConnID: "c1", Room: "lobby"})
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Events recorded after Close are discarded.
+	sink.Record(&AuditEvent{Kind: AuditLeave})
+	log := buf.String()
+	if n, err := VerifyEventLog(strings.NewReader(log)); n != 3 || err != nil {
+		t.Fatalf("VerifyEventLog() = %d, %v, want 3 valid records", n, err)
+	}
+
+	lines := strings.SplitAfter(log, "\n")
+	for name, tampered := range map[string]string{
+		"altered": lines[0] + strings.Replace(lines[1], "lobby", "other", 1) + lines[2],
+		"removed": lines[0] + lines[2],
+		"added":   lines[0] + lines[0] + lines[1],
+	} {
+		if n, err := VerifyEventLog(strings.NewReader(tampered)); !errors.Is(err, ErrEventLogTampered) || n != 1 {
+			t.Errorf("VerifyEventLog of a log with a record %s = %d, %v, want 1 valid record and %v", name, n, err, ErrEventLogTampered)
+		}
+	}
+}
+
+// blockedWriter blocks the writes until unblock is closed.
+type blockedWriter struct {
+	unblock chan struct{}
+	bytes.Buffer
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return w.Buffer.Write(p)
+}
+
+func TestJSONEventSinkDropped(t *testing.T) {
+	w := &blockedWriter{unblock: make(chan struct{})}
+	sink := NewJSONEventSink(w, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			sink.Record(&AuditEvent{Kind: AuditConnect})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record blocked on a full buffer")
+	}
+	dropped := sink.Dropped()
+	if dropped < 8 {
+		t.Errorf("%d events dropped, want at least 8", dropped)
+	}
+	close(w.unblock)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := VerifyEventLog(&w.Buffer); err != nil || int64(n)+dropped != 10 {
+		t.Errorf("VerifyEventLog() = %d, %v, want %d records", n, err, 10-dropped)
+	}
+}
+
+func TestOpenEventLog(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < 2; i++ {
+		sink, err := OpenEventLog(name, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.Record(&AuditEvent{Time: time.Now(), Kind: AuditConnect})
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := VerifyEventLog(f); n != 2 || err != nil {
+		t.Fatalf("VerifyEventLog() = %d, %v, want the chain continued across opens", n, err)
+	}
+}
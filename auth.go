@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// AuthError is returned by an Authenticate function to reject the handshake
+// with a specific HTTP response.
+type AuthError struct {
+	// Status is the HTTP status code of the response. If zero, status 401
+	// Unauthorized is used.
+	Status int
+
+	// Body is the response body. If empty, the status text is used.
+	Body string
+
+	// Header specifies additional response headers, for example
+	// WWW-Authenticate.
+	Header http.Header
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	msg := "websocket: authentication failed"
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+func (e *AuthError) status() int {
+	if e.Status == 0 {
+		return http.StatusUnauthorized
+	}
+	return e.Status
+}
+
+func (e *AuthError) body() string {
+	if e.Body == "" {
+		return http.StatusText(e.status())
+	}
+	return e.Body
+}
+
+// rejectAuth writes the response for a failed Authenticate call.
+func (u *Upgrader) rejectAuth(w http.ResponseWriter, r *http.Request, err error) (*Conn, error) {
+	u.auditRequest(r, AuditAuthenticate, err.Error())
+	var ae *AuthError
+	if !errors.As(err, &ae) {
+		_, _ = u.returnError(w, r, http.StatusUnauthorized, "websocket: authentication failed: "+err.Error())
+		return nil, err
+	}
+	if u.Metrics != nil {
+		u.Metrics.HandshakeFailed(ae.status())
+	}
+	if u.Logger != nil {
+		u.Logger.Log(slog.LevelWarn, "websocket: handshake failed", "remote_addr", r.RemoteAddr, "status", ae.status(), "error", err.Error())
+	}
+	for k, v := range ae.Header {
+		w.Header()[k] = v
+	}
+	http.Error(w, ae.body(), ae.status())
+	return nil, err
+}
+
+// rejectAuth writes the response for a failed Authenticate call.
+func (u *FastHTTPUpgrader) rejectAuth(ctx *fasthttp.RequestCtx, err error) error {
+	auditRequest(u.EventSink, ctx.RemoteAddr().String(), AuditAuthenticate, err.Error())
+	var ae *AuthError
+	if !errors.As(err, &ae) {
+		_ = u.responseError(ctx, fasthttp.StatusUnauthorized, "websocket: authentication failed: "+err.Error())
+		return err
+	}
+	if u.Metrics != nil {
+		u.Metrics.HandshakeFailed(ae.status())
+	}
+	if u.Logger != nil {
+		u.Logger.Log(slog.LevelWarn, "websocket: handshake failed", "remote_addr", ctx.RemoteAddr().String(), "status", ae.status(), "error", err.Error())
+	}
+	for k, v := range ae.Header {
+		for _, vv := range v {
+			ctx.Response.Header.Add(k, vv)
+		}
+	}
+	ctx.Error(ae.body(), ae.status())
+	return err
+}
+
+// BearerToken returns the token from an Authorization header using the
+// Bearer scheme, or the empty string if there is none.
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !equalASCIIFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// RequestToken returns the first non-empty token found in the request, in
+// order: an Authorization header using the Bearer scheme, the named cookie
+// and the named query parameter. An empty name skips the corresponding
+// source.
+//
+// Browsers cannot set headers on WebSocket handshakes, so browser clients
+// typically send tokens in a cookie or in the query string.
+func RequestToken(r *http.Request, cookie, query string) string {
+	if token := BearerToken(r); token != "" {
+		return token
+	}
+	if cookie != "" {
+		if c, err := r.Cookie(cookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	if query != "" {
+		return r.URL.Query().Get(query)
+	}
+	return ""
+}
+
+// FastHTTPRequestToken is like RequestToken for fasthttp requests.
+func FastHTTPRequestToken(ctx *fasthttp.RequestCtx, cookie, query string) string {
+	scheme, token, ok := strings.Cut(string(ctx.Request.Header.Peek("Authorization")), " ")
+	if ok && equalASCIIFold(scheme, "Bearer") {
+		if token = strings.TrimSpace(token); token != "" {
+			return token
+		}
+	}
+	if cookie != "" {
+		if v := ctx.Request.Header.Cookie(cookie); len(v) > 0 {
+			return string(v)
+		}
+	}
+	if query != "" {
+		return string(ctx.QueryArgs().Peek(query))
+	}
+	return ""
+}
+
+// Value returns the value attached to the connection with SetValue. For
+// server connections, the value is initialized to the principal returned by
+// the upgrader's Authenticate function.
+func (c *Conn) Value() interface{} {
+	if c == nil {
+		return nil
+	}
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.value
+}
+
+// SetValue attaches a value, such as an authenticated principal, to the
+// connection. It is safe to call SetValue and Value concurrently with all
+// other methods.
+func (c *Conn) SetValue(v interface{}) {
+	if c == nil {
+		return
+	}
+	c.metaMu.Lock()
+	c.value = v
+	c.metaMu.Unlock()
+}
@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpgraderAuthenticate(t *testing.T) {
+	u := Upgrader{
+		Authenticate: func(r *http.Request) (interface{}, error) {
+			switch RequestToken(r, "session", "token") {
+			case "good":
+				return "alice", nil
+			case "":
+				return nil, errors.New("missing token")
+			default:
+				return nil, &AuthError{
+					Status: http.StatusForbidden,
+					Body:   "bad token",
+					Header: http.Header{"Www-Authenticate": {`Bearer error="invalid_token"`}},
+				}
+			}
+		},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_ = c.WriteMessage(TextMessage, []byte(c.Value().(string)))
+	}))
+	defer s.Close()
+	wsURL := makeWsProto(s.URL)
+
+	for _, h := range []http.Header{
+		{"Authorization": {"Bearer good"}},
+		{"Cookie": {"session=good"}},
+	} {
+		c, _, err := DefaultDialer.Dial(wsURL, h)
+		if err != nil {
+			t.Fatalf("Dial(%v): %v", h, err)
+		}
+		if got := readString(t, c); got != "alice" {
+			t.Fatalf("Value() = %q, want %q", got, "alice")
+		}
+		c.Close()
+	}
+	c, _, err := DefaultDialer.Dial(wsURL+"?token=good", nil)
+	if err != nil {
+		t.Fatalf("Dial with query token: %v", err)
+	}
+	c.Close()
+
+	_, resp, err := DefaultDialer.Dial(wsURL+"?token=bad", nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil {
+		t.Fatalf("Dial with bad token returned %v, %v", resp, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden || strings.TrimSpace(string(body)) != "bad token" ||
+		resp.Header.Get("Www-Authenticate") == "" {
+		t.Fatalf("got status %d, body %q, header %v", resp.StatusCode, body, resp.Header)
+	}
+
+	_, resp, err = DefaultDialer.Dial(wsURL, nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Dial without token returned %v, %v", resp, err)
+	}
+}
+
+func TestConnValue(t *testing.T) {
+	c := newTestConn(nil, nil, true)
+	if v := c.Value(); v != nil {
+		t.Fatalf("Value() = %v, want nil", v)
+	}
+	c.SetValue(42)
+	if v := c.Value(); v != 42 {
+		t.Fatalf("Value() = %v, want 42", v)
+	}
+}
@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultBatchMaxEvents = 64
+
+// eventBatcher holds the events emitted within the batching window.
+type eventBatcher struct {
+	mu        sync.Mutex
+	window    time.Duration
+	maxEvents int
+	events    [][]byte
+	timer     *time.Timer
+	err       error // of the last write by the timer, returned by the next write
+}
+
+// SetEventBatching packs the events written with Emit and Channel.Send
+// within window of each other into a single text message holding a JSON
+// array of the events, up to maxEvents. The pending events are written when
+// window has elapsed since the first of them, when they reach maxEvents, on
+// FlushEvents and on Close. An event pending alone is written as a single
+// event. If maxEvents is zero, a default of 64 is used. A window of zero or
+// less disables batching and writes the pending events.
+//
+// Batching trades latency for fewer messages and frames, for example for a
+// stream of frequent updates. Router.Serve and ReadEvents unpack the batches
+// transparently. Replies, calls and the messages written with the other
+// write methods are not delayed and may overtake the pending events. The
+// error of a write at the end of the window is returned by the next event
+// write.
+func (c *Conn) SetEventBatching(window time.Duration, maxEvents int) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	var err error
+	if b := c.batcher.Swap(nil); b != nil {
+		err = b.stop(c)
+	}
+	if window <= 0 {
+		return err
+	}
+	if maxEvents <= 0 {
+		maxEvents = defaultBatchMaxEvents
+	}
+	c.batcher.Store(&eventBatcher{window: window, maxEvents: maxEvents})
+	return err
+}
+
+// FlushEvents writes the events pending under SetEventBatching.
+func (c *Conn) FlushEvents() error {
+	if c == nil {
+		return ErrNilConn
+	}
+	b := c.batcher.Load()
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush(c)
+}
+
+// writeEvent writes an encoded event, or adds it to the pending batch.
+func (c *Conn) writeEvent(p []byte) error {
+	b := c.batcher.Load()
+	if b == nil {
+		return c.WriteMessage(TextMessage, p)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.err; err != nil {
+		b.err = nil
+		return err
+	}
+	b.events = append(b.events, p)
+	if len(b.events) >= b.maxEvents {
+		return b.flush(c)
+	}
+	if len(b.events) == 1 {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.window, func() { b.flushTimer(c) })
+		} else {
+			b.timer.Reset(b.window)
+		}
+	}
+	return nil
+}
+
+// flushTimer writes the events left at the end of the window.
+func (b *eventBatcher) flushTimer(c *Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.flush(c); err != nil {
+		b.err = err
+	}
+}
+
+// flush writes the pending events. The batcher lock must be held.
+func (b *eventBatcher) flush(c *Conn) error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if len(b.events) == 0 {
+		return nil
+	}
+	var p []byte
+	if len(b.events) == 1 {
+		p = b.events[0]
+	} else {
+		p = make([]byte, 0, 2+len(b.events)*(len(b.events[0])+1))
+		p = append(p, '[')
+		for i, e := range b.events {
+			if i > 0 {
+				p = append(p, ',')
+			}
+			p = append(p, e...)
+		}
+		p = append(p, ']')
+	}
+	clear(b.events)
+	b.events = b.events[:0]
+	return c.WriteMessage(TextMessage, p)
+}
+
+// stop writes the pending events of a batcher no longer used by the
+// connection.
+func (b *eventBatcher) stop(c *Conn) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.flush(c)
+	if err == nil {
+		err = b.err
+	}
+	b.err = nil
+	return err
+}
+
+// flushEventsOnClose writes the pending events before the connection is
+// closed.
+func (c *Conn) flushEventsOnClose() {
+	if b := c.batcher.Swap(nil); b != nil {
+		_ = b.stop(c)
+	}
+}
+
+// UnpackEvents decodes a message holding an event, or a batch of events
+// written under SetEventBatching.
+func UnpackEvents(p []byte) ([]*Event, error) {
+	if p = bytes.TrimLeft(p, " \t\r\n"); len(p) > 0 && p[0] == '[' {
+		var events []*Event
+		if err := json.Unmarshal(p, &events); err != nil {
+			return nil, err
+		}
+		return slices.DeleteFunc(events, func(e *Event) bool { return e == nil }), nil
+	}
+	e := new(Event)
+	if err := json.Unmarshal(p, e); err != nil {
+		return nil, err
+	}
+	return []*Event{e}, nil
+}
+
+// ReadEvents reads the next message and returns the events it holds, for
+// the clients not using a Router. The message is an event or a batch of
+// events written under SetEventBatching.
+func (c *Conn) ReadEvents() ([]*Event, error) {
+	if c == nil {
+		return nil, ErrNilConn
+	}
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return UnpackEvents(p)
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBatching(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	if err := s.SetEventBatching(50*time.Millisecond, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reaching maxEvents writes the batch at once.
+	go func() {
+		for i := range 3 {
+			_ = s.Emit("tick", i)
+		}
+	}()
+	if got, want := readString(t, c), `[{"event":"tick","data":0},{"event":"tick","data":1},{"event":"tick","data":2}]`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// The events pending at the end of the window are written together.
+	start := time.Now()
+	if err := s.Emit("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Emit("b", nil); err != nil {
+		t.Fatal(err)
+	}
+	events, err := c.ReadEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 40*time.Millisecond {
+		t.Error("batch written before the end of the window")
+	}
+	if len(events) != 2 || events[0].Event != "a" || events[1].Event != "b" {
+		t.Fatalf("ReadEvents returned %v, want events a and b", events)
+	}
+
+	// An event pending alone is written as a single event.
+	if err := s.Emit("single", nil); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.FlushEvents() }()
+	if got, want := readString(t, c), `{"event":"single"}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// Disabling batching writes the pending events.
+	_ = s.Emit("last", nil)
+	go func() { _ = s.SetEventBatching(0, 0) }()
+	if got, want := readString(t, c), `{"event":"last"}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnpackEvents(t *testing.T) {
+	events, err := UnpackEvents([]byte(` [{"event":"a"},null,{"event":"b","id":"1"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Event != "a" || events[1].ID != "1" {
+		t.Fatalf("UnpackEvents returned %v", events)
+	}
+	events, err = UnpackEvents([]byte(`{"event":"a"}`))
+	if err != nil || len(events) != 1 || events[0].Event != "a" {
+		t.Fatalf("UnpackEvents returned %v, %v", events, err)
+	}
+	if _, err := UnpackEvents([]byte(`[1]`)); err == nil {
+		t.Fatal("UnpackEvents returned nil error for an array of numbers")
+	}
+}
+
+func TestRouterServeBatch(t *testing.T) {
+	var r Router
+	var got []string
+	r.Handle("a", func(c *Conn, e *Event) error {
+		got = append(got, "a"+string(e.Data))
+		return nil
+	})
+	r.OnError = func(c *Conn, e *Event, err error) { got = append(got, "error "+e.Event) }
+
+	s, c := newPipeConns()
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(s) }()
+	if err := c.WriteMessage(TextMessage, []byte(`[{"event":"a","data":1},{"event":"nope"},{"event":"a","data":2}]`)); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	<-done
+	if strings.Join(got, ",") != "a1,error nope,a2" {
+		t.Fatalf("dispatched %v, want [a1 error nope a2]", got)
+	}
+}
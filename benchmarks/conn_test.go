@@ -0,0 +1,126 @@
+package benchmarks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gflydev/websocket"
+)
+
+// memConn is a network connection that performs the opening handshake over a
+// pipe, then reads a recorded stream of frames in a loop and discards or
+// records the frames written.
+type memConn struct {
+	net.Conn // the end of the pipe used for the handshake
+
+	handshaken bool
+	loop       []byte        // frames read in a loop after the handshake
+	pos        int           // position of the next read in loop
+	record     *bytes.Buffer // if not nil, receives the frames written
+}
+
+func (c *memConn) Read(p []byte) (int, error) {
+	if !c.handshaken {
+		return c.Conn.Read(p)
+	}
+	if len(c.loop) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.loop[c.pos:])
+	c.pos = (c.pos + n) % len(c.loop)
+	return n, nil
+}
+
+func (c *memConn) Write(p []byte) (int, error) {
+	if !c.handshaken {
+		return c.Conn.Write(p)
+	}
+	if c.record != nil {
+		return c.record.Write(p)
+	}
+	return len(p), nil
+}
+
+// newConns returns a client and a server connection of the configuration,
+// with their network connections.
+func newConns(tb testing.TB, cfg config) (client, server *websocket.Conn, cc, sc *memConn) {
+	tb.Helper()
+	p1, p2 := net.Pipe()
+	cc, sc = &memConn{Conn: p1}, &memConn{Conn: p2}
+	u := websocket.Upgrader{EnableCompression: cfg.compress}
+	upgraded := make(chan error, 1)
+	go func() {
+		var err error
+		server, _, err = u.UpgradeNetConn(sc, nil)
+		upgraded <- err
+	}()
+	d := websocket.Dialer{
+		EnableCompression: cfg.compress,
+		NetDial:           func(network, addr string) (net.Conn, error) { return cc, nil },
+	}
+	client, _, err := d.Dial("ws://bench/", nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := <-upgraded; err != nil {
+		tb.Fatal(err)
+	}
+	cc.handshaken, sc.handshaken = true, true
+	tb.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	for _, c := range []*websocket.Conn{client, server} {
+		c.EnableWriteCompression(cfg.compress)
+		if err := c.SetWriteFrameSize(cfg.frameSize); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return client, server, cc, sc
+}
+
+// record returns the frames written to nc by write.
+func record(tb testing.TB, nc *memConn, write func() error) []byte {
+	tb.Helper()
+	var b bytes.Buffer
+	nc.record = &b
+	defer func() { nc.record = nil }()
+	if err := write(); err != nil {
+		tb.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// recordMessage returns the frames of a message of the configuration
+// written by c to nc.
+func recordMessage(tb testing.TB, c *websocket.Conn, nc *memConn, cfg config) []byte {
+	tb.Helper()
+	return record(tb, nc, func() error { return c.WriteMessage(cfg.messageType(), cfg.data()) })
+}
+
+// countFrames returns the number of frames in p.
+func countFrames(tb testing.TB, p []byte) int {
+	tb.Helper()
+	n := 0
+	for len(p) > 0 {
+		if len(p) < 2 {
+			tb.Fatal("truncated frame header")
+		}
+		length, header := uint64(p[1]&0x7f), 2
+		switch length {
+		case 126:
+			length, header = uint64(binary.BigEndian.Uint16(p[2:])), 4
+		case 127:
+			length, header = binary.BigEndian.Uint64(p[2:]), 10
+		}
+		if p[1]&0x80 != 0 {
+			header += 4
+		}
+		p = p[header+int(length):]
+		n++
+	}
+	return n
+}
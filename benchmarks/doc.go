@@ -0,0 +1,13 @@
+// Package benchmarks measures the framing layer of the websocket package:
+// the frames per second and the allocations of reading and writing messages
+// for common configurations of message size, role, fragmentation and
+// compression.
+//
+// The connections read from and write to memory after the opening
+// handshake, so the results exclude the network and the scheduling of a
+// peer. Run them with:
+//
+//	go test -run NONE -bench . -benchmem ./benchmarks
+//
+// Compare the results of two revisions with benchstat to catch regressions.
+package benchmarks
@@ -0,0 +1,158 @@
+package benchmarks
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/gflydev/websocket"
+)
+
+// config is a configuration of the connections and of the messages.
+type config struct {
+	name      string
+	size      int
+	text      bool
+	frameSize int // see Conn.SetWriteFrameSize
+	compress  bool
+}
+
+var configs = []config{
+	{name: "Binary64", size: 64},
+	{name: "Binary1K", size: 1 << 10},
+	{name: "Binary64K", size: 64 << 10},
+	{name: "Text1K", size: 1 << 10, text: true},
+	{name: "Fragmented64K", size: 64 << 10, frameSize: 4 << 10},
+	{name: "Compressed1K", size: 1 << 10, text: true, compress: true},
+}
+
+func (cfg config) messageType() int {
+	if cfg.text {
+		return websocket.TextMessage
+	}
+	return websocket.BinaryMessage
+}
+
+// data returns the payload of the messages: random bytes for binary
+// messages and compressible ASCII text for text messages.
+func (cfg config) data() []byte {
+	if cfg.text {
+		return bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), cfg.size/44+1)[:cfg.size]
+	}
+	p := make([]byte, cfg.size)
+	rand.New(rand.NewSource(1)).Read(p)
+	return p
+}
+
+// report reports the frames per second of b.N messages of frames frames
+// each.
+func report(b *testing.B, frames int) {
+	b.ReportMetric(float64(frames)*float64(b.N)/b.Elapsed().Seconds(), "frames/s")
+}
+
+// BenchmarkWrite measures writing messages, unmasked by the server and
+// masked by the client.
+func BenchmarkWrite(b *testing.B) {
+	for _, role := range []string{"Server", "Client"} {
+		for _, cfg := range configs {
+			b.Run(role+"/"+cfg.name, func(b *testing.B) {
+				client, server, cc, sc := newConns(b, cfg)
+				c, nc := server, sc
+				if role == "Client" {
+					c, nc = client, cc
+				}
+				frames := countFrames(b, recordMessage(b, c, nc, cfg))
+				data := cfg.data()
+				b.SetBytes(int64(cfg.size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := c.WriteMessage(cfg.messageType(), data); err != nil {
+						b.Fatal(err)
+					}
+				}
+				report(b, frames)
+			})
+		}
+	}
+}
+
+// BenchmarkWritePrepared measures writing a prepared message, as the hub
+// does for broadcasts.
+func BenchmarkWritePrepared(b *testing.B) {
+	for _, cfg := range configs {
+		b.Run(cfg.name, func(b *testing.B) {
+			_, server, _, sc := newConns(b, cfg)
+			pm, err := websocket.NewPreparedMessage(cfg.messageType(), cfg.data())
+			if err != nil {
+				b.Fatal(err)
+			}
+			frames := countFrames(b, record(b, sc, func() error { return server.WritePreparedMessage(pm) }))
+			b.SetBytes(int64(cfg.size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := server.WritePreparedMessage(pm); err != nil {
+					b.Fatal(err)
+				}
+			}
+			report(b, frames)
+		})
+	}
+}
+
+// BenchmarkRead measures reading messages, masked for the server and
+// unmasked for the client. The messages are read with NextReader into
+// io.Discard, so that the allocations are those of the connection.
+func BenchmarkRead(b *testing.B) {
+	for _, role := range []string{"Server", "Client"} {
+		for _, cfg := range configs {
+			b.Run(role+"/"+cfg.name, func(b *testing.B) {
+				client, server, cc, sc := newConns(b, cfg)
+				// The reader reads in a loop the frames of a message written
+				// by its peer.
+				c, nc, stream := server, sc, recordMessage(b, client, cc, cfg)
+				if role == "Client" {
+					c, nc, stream = client, cc, recordMessage(b, server, sc, cfg)
+				}
+				nc.loop = stream
+				frames := countFrames(b, stream)
+				b.SetBytes(int64(cfg.size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, r, err := c.NextReader()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if n, err := io.Copy(io.Discard, r); err != nil || n != int64(cfg.size) {
+						b.Fatalf("read %d bytes, %v", n, err)
+					}
+				}
+				report(b, frames)
+			})
+		}
+	}
+}
+
+func TestCountFrames(t *testing.T) {
+	for _, cfg := range configs {
+		client, server, cc, sc := newConns(t, cfg)
+		for _, tc := range []struct {
+			role string
+			c    *websocket.Conn
+			nc   *memConn
+		}{{"server", server, sc}, {"client", client, cc}} {
+			want := 1
+			if cfg.frameSize > 0 {
+				want = (cfg.size + cfg.frameSize - 1) / cfg.frameSize
+			}
+			if got := countFrames(t, recordMessage(t, tc.c, tc.nc, cfg)); cfg.frameSize > 0 && got != want {
+				t.Errorf("%s %s: %d frames, want %d", cfg.name, tc.role, got, want)
+			} else if got < want {
+				t.Errorf("%s %s: %d frames, want at least %d", cfg.name, tc.role, got, want)
+			}
+		}
+	}
+}
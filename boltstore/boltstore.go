@@ -0,0 +1,184 @@
+// Package boltstore persists the messages of a websocket Spool in a BoltDB
+// file, so that the messages kept for offline users survive restarts:
+//
+//	db, err := bolt.Open("spool.db", 0600, nil)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	spool := &websocket.Spool{Registry: registry, Store: boltstore.New(db)}
+//
+// The messages of each user are kept in a bucket nested in the bucket named
+// by Store.Bucket, keyed by their sequence number.
+package boltstore
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/gflydev/websocket"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultBucket is the bucket used when Store.Bucket is empty.
+const DefaultBucket = "websocket.spool"
+
+// Store is a websocket.MessageStore backed by a BoltDB database.
+type Store struct {
+	// DB is the database holding the messages.
+	DB *bolt.DB
+
+	// Bucket is the name of the top-level bucket holding the messages. If
+	// empty, DefaultBucket is used.
+	Bucket string
+}
+
+// New returns a store keeping the messages in the database.
+func New(db *bolt.DB) *Store {
+	return &Store{DB: db}
+}
+
+func (s *Store) bucket() []byte {
+	if s.Bucket != "" {
+		return []byte(s.Bucket)
+	}
+	return []byte(DefaultBucket)
+}
+
+// A message is stored as its type in one byte, its expiry in Unix
+// nanoseconds and its data.
+const headerSize = 9
+
+func encode(m websocket.SpooledMessage) []byte {
+	v := make([]byte, headerSize+len(m.Data))
+	v[0] = byte(m.Type)
+	binary.BigEndian.PutUint64(v[1:], uint64(m.ExpiresAt.UnixNano()))
+	copy(v[headerSize:], m.Data)
+	return v
+}
+
+func decode(k, v []byte) (websocket.SpooledMessage, bool) {
+	if len(k) != 8 || len(v) < headerSize {
+		return websocket.SpooledMessage{}, false
+	}
+	return websocket.SpooledMessage{
+		Seq:       binary.BigEndian.Uint64(k),
+		Type:      int(v[0]),
+		ExpiresAt: time.Unix(0, int64(binary.BigEndian.Uint64(v[1:]))),
+		Data:      append([]byte(nil), v[headerSize:]...),
+	}, true
+}
+
+func key(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, seq)
+}
+
+// Push implements websocket.MessageStore.
+func (s *Store) Push(ctx context.Context, user string, m websocket.SpooledMessage, max int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(s.bucket())
+		if err != nil {
+			return err
+		}
+		b, err := root.CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key(seq), encode(m)); err != nil {
+			return err
+		}
+		if max <= 0 {
+			return nil
+		}
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		if len(keys) <= max {
+			return nil
+		}
+		return deleteKeys(b, keys[:len(keys)-max])
+	})
+}
+
+// deleteKeys deletes the keys collected while iterating over the bucket; a
+// cursor may skip keys when deleting while iterating.
+func deleteKeys(b *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if err := b.Delete(append([]byte(nil), k...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch implements websocket.MessageStore.
+func (s *Store) Fetch(ctx context.Context, user string, now time.Time) ([]websocket.SpooledMessage, error) {
+	var msgs []websocket.SpooledMessage
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := s.userBucket(tx, user)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if m, ok := decode(k, v); ok && now.Before(m.ExpiresAt) {
+				msgs = append(msgs, m)
+			}
+			return nil
+		})
+	})
+	return msgs, err
+}
+
+func (s *Store) userBucket(tx *bolt.Tx, user string) *bolt.Bucket {
+	root := tx.Bucket(s.bucket())
+	if root == nil {
+		return nil
+	}
+	return root.Bucket([]byte(user))
+}
+
+// Remove implements websocket.MessageStore.
+func (s *Store) Remove(ctx context.Context, user string, seq uint64) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b := s.userBucket(tx, user)
+		if b == nil {
+			return nil
+		}
+		var drop [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= seq; k, _ = c.Next() {
+			drop = append(drop, k)
+		}
+		return deleteKeys(b, drop)
+	})
+}
+
+// Purge implements websocket.MessageStore.
+func (s *Store) Purge(ctx context.Context, now time.Time) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(s.bucket())
+		if root == nil {
+			return nil
+		}
+		return root.ForEachBucket(func(user []byte) error {
+			b := root.Bucket(user)
+			var drop [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				if m, ok := decode(k, v); !ok || !now.Before(m.ExpiresAt) {
+					drop = append(drop, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return deleteKeys(b, drop)
+		})
+	})
+}
@@ -0,0 +1,29 @@
+package websocket
+
+// WriteBroadcast writes the prepared message to each of the connections and
+// returns the errors of the writes in the order of conns. The error of a
+// successful write is nil. As with WritePreparedMessage, the application
+// must not write to the connections concurrently with WriteBroadcast unless
+// they use a write queue.
+//
+// On Linux, when the package is built with the wsiouring build tag,
+// WriteBroadcast submits the frames to the connections in batches through
+// io_uring: one system call writes to up to 256 connections instead of one
+// system call per connection. The io_uring path is experimental. It applies
+// to connections over a socket without a write queue or outbound
+// interceptors; other connections, and all connections when io_uring is not
+// available, are written with WritePreparedMessage. A frame the socket does
+// not accept at once is completed with a regular write bounded by the write
+// deadline of the connection.
+func WriteBroadcast(conns []*Conn, pm *PreparedMessage) []error {
+	errs := make([]error, len(conns))
+	writeBroadcast(conns, pm, errs)
+	return errs
+}
+
+// writeEach writes pm to each connection with WritePreparedMessage.
+func writeEach(conns []*Conn, pm *PreparedMessage, errs []error) {
+	for i, c := range conns {
+		errs[i] = c.WritePreparedMessage(pm)
+	}
+}
@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTCPConnSet returns n server connections over loopback TCP and their
+// clients.
+func newTCPConnSet(tb testing.TB, n int) (servers, clients []*Conn) {
+	tb.Helper()
+	accepted := make(chan *Conn)
+	var upgrader Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- c
+	}))
+	tb.Cleanup(s.Close)
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+	for i := 0; i < n; i++ {
+		c, _, err := DefaultDialer.Dial(url, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		sc := <-accepted
+		tb.Cleanup(func() {
+			c.Close()
+			sc.Close()
+		})
+		servers = append(servers, sc)
+		clients = append(clients, c)
+	}
+	return servers, clients
+}
+
+func TestWriteBroadcast(t *testing.T) {
+	servers, clients := newTCPConnSet(t, 4)
+	ps, pc := newPipeConns()
+	defer ps.Close()
+	defer pc.Close()
+	if err := ps.EnableWriteQueue(4, OverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+
+	pm, err := NewPreparedMessage(TextMessage, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A connection listed twice gets the message twice.
+	conns := append([]*Conn{servers[0]}, servers...)
+	conns = append(conns, ps)
+	errs := WriteBroadcast(conns, pm)
+	if len(errs) != len(conns) {
+		t.Fatalf("got %d errors for %d connections", len(errs), len(conns))
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("write %d: %v", i, err)
+		}
+	}
+	for i, c := range append(clients, clients[0], pc) {
+		if got := readString(t, c); got != "hello" {
+			t.Errorf("client %d got %q", i, got)
+		}
+	}
+
+	// A closed connection fails without affecting the others.
+	servers[1].Close()
+	errs = WriteBroadcast(servers, pm)
+	for i, err := range errs {
+		if (err != nil) != (i == 1) {
+			t.Errorf("write %d: %v", i, err)
+		}
+	}
+	if got := readString(t, clients[2]); got != "hello" {
+		t.Errorf("got %q", got)
+	}
+	if errs := WriteBroadcast([]*Conn{nil}, pm); errs[0] != ErrNilConn {
+		t.Errorf("nil connection: %v", errs[0])
+	}
+}
+
+// BenchmarkWriteBroadcast compares WriteBroadcast with a loop calling
+// WritePreparedMessage. Build with -tags wsiouring on Linux to measure the
+// io_uring path.
+func BenchmarkWriteBroadcast(b *testing.B) {
+	servers, clients := newTCPConnSet(b, 256)
+	for _, c := range clients {
+		go func(c *Conn) {
+			for {
+				_, r, err := c.NextReader()
+				if err != nil {
+					return
+				}
+				_, _ = io.Copy(io.Discard, r)
+			}
+		}(c)
+	}
+	pm, err := NewPreparedMessage(TextMessage, []byte(strings.Repeat("x", 128)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("WritePreparedMessage", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, c := range servers {
+				if err := c.WritePreparedMessage(pm); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("WriteBroadcast", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, err := range WriteBroadcast(servers, pm) {
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func TestWriteBroadcastFaults(t *testing.T) {
+	servers, clients := newTCPConnSet(t, 1)
+	pm, err := NewPreparedMessage(TextMessage, []byte("dropped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = servers[0].SetFaultInjector(&FaultInjector{DropRate: 1})
+	for _, err := range WriteBroadcast(servers, pm) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = servers[0].SetFaultInjector(nil)
+	if err := servers[0].WriteMessage(TextMessage, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, clients[0]); got != "next" {
+		t.Fatalf("got %q, want the broadcast dropped by the fault injector", got)
+	}
+}
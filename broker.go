@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// defaultBrokerRetryDelay is the delay before a hub subscribes to its broker
+// again after the subscription failed.
+const defaultBrokerRetryDelay = time.Second
+
+var errInvalidBrokerMessage = errors.New("websocket: invalid broker message")
+
+// Broker relays hub broadcasts between hubs, typically running in different
+// processes behind a load balancer. Set the Broker field of each Hub to
+// brokers connected to the same backend to fan out broadcasts to the members
+// of a room on every node.
+//
+// Implementations must be safe for concurrent use.
+type Broker interface {
+	// Publish sends the message to every hub subscribed to the broker,
+	// including the publishing hub.
+	Publish(ctx context.Context, m *BrokerMessage) error
+
+	// Subscribe calls f for each message published to the broker until ctx
+	// is done or the subscription fails. Subscribe returns nil when ctx
+	// is done. Messages may be delivered to f concurrently.
+	Subscribe(ctx context.Context, f func(m *BrokerMessage)) error
+}
+
+// BrokerMessage is a broadcast relayed by a Broker.
+type BrokerMessage struct {
+	// Origin identifies the hub that published the message. A hub ignores
+	// the messages it published itself.
+	Origin string
+
+	// Room is the room the message is broadcast to. Room is ignored if All
+	// is true.
+	Room string
+
+	// All is true for messages broadcast to every connection of a hub.
+	All bool
+
+	// Presence is true for updates of the hub's presence records. The
+	// hub encodes the update in Data; presence updates are not delivered
+	// to connections.
+	Presence bool
+
+	// MessageType and Data are the message sent to the connections.
+	MessageType int
+	Data        []byte
+}
+
+// brokerMessageVersion is the first byte of the binary encoding of a
+// BrokerMessage.
+const brokerMessageVersion = 1
+
+// Flags of the binary encoding of a BrokerMessage.
+const (
+	brokerFlagAll      = 1 << 0
+	brokerFlagPresence = 1 << 1
+)
+
+// MarshalBinary encodes the message for the wire. Broker implementations
+// can use MarshalBinary and UnmarshalBinary to share a format.
+func (m *BrokerMessage) MarshalBinary() ([]byte, error) {
+	p := make([]byte, 0, 3+2*binary.MaxVarintLen64+len(m.Origin)+len(m.Room)+len(m.Data))
+	p = append(p, brokerMessageVersion)
+	var flags byte
+	if m.All {
+		flags |= brokerFlagAll
+	}
+	if m.Presence {
+		flags |= brokerFlagPresence
+	}
+	p = append(p, flags, byte(m.MessageType))
+	p = binary.AppendUvarint(p, uint64(len(m.Origin)))
+	p = append(p, m.Origin...)
+	p = binary.AppendUvarint(p, uint64(len(m.Room)))
+	p = append(p, m.Room...)
+	return append(p, m.Data...), nil
+}
+
+// UnmarshalBinary decodes a message encoded with MarshalBinary. The Data
+// field of the message shares the memory of p.
+func (m *BrokerMessage) UnmarshalBinary(p []byte) error {
+	if len(p) < 3 || p[0] != brokerMessageVersion {
+		return errInvalidBrokerMessage
+	}
+	m.All = p[1]&brokerFlagAll != 0
+	m.Presence = p[1]&brokerFlagPresence != 0
+	m.MessageType = int(p[2])
+	p = p[3:]
+	var ok bool
+	if m.Origin, p, ok = readBrokerString(p); !ok {
+		return errInvalidBrokerMessage
+	}
+	if m.Room, p, ok = readBrokerString(p); !ok {
+		return errInvalidBrokerMessage
+	}
+	m.Data = p
+	return nil
+}
+
+// readBrokerString reads a length-prefixed string from p.
+func readBrokerString(p []byte) (string, []byte, bool) {
+	n, k := binary.Uvarint(p)
+	if k <= 0 || n > uint64(len(p)-k) {
+		return "", nil, false
+	}
+	p = p[k:]
+	return string(p[:n]), p[n:], true
+}
+
+// startBroker subscribes the hub to its broker once. The hub lock must be
+// held.
+func (h *Hub) startBroker() {
+	if h.Broker == nil || h.brokerCancel != nil {
+		return
+	}
+	h.initID()
+	ctx, cancel := context.WithCancel(context.Background())
+	h.brokerCancel = cancel
+	go h.subscribe(ctx, h.Broker, h.id)
+}
+
+// subscribe delivers the messages published by other hubs until ctx is done,
+// subscribing again after failures.
+func (h *Hub) subscribe(ctx context.Context, b Broker, id string) {
+	for {
+		err := b.Subscribe(ctx, func(m *BrokerMessage) {
+			if m.Origin != id {
+				h.deliver(m)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			h.log(slog.LevelError, "websocket: broker subscription failed", "error", err.Error())
+			if h.OnBrokerError != nil {
+				h.OnBrokerError(err)
+			}
+		}
+		t := time.NewTimer(defaultBrokerRetryDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// deliver queues a message received from the broker for the local members.
+func (h *Hub) deliver(m *BrokerMessage) {
+	if m.Presence {
+		h.deliverPresence(m)
+		return
+	}
+	hm, err := newBroadcastMessage(m.MessageType, m.Data)
+	if err != nil {
+		return
+	}
+	if m.All {
+		_ = h.fanoutAll(hm)
+	} else {
+		_ = h.fanout(m.Room, nil, hm)
+	}
+}
+
+// publish sends a broadcast to the other hubs using the broker.
+func (h *Hub) publish(room string, all bool, messageType int, data []byte) error {
+	return h.publishMessage(&BrokerMessage{
+		Room:        room,
+		All:         all,
+		MessageType: messageType,
+		Data:        data,
+	})
+}
+
+// publishMessage sends m to the other hubs using the broker.
+func (h *Hub) publishMessage(m *BrokerMessage) error {
+	if h.Broker == nil {
+		return nil
+	}
+	h.mu.RLock()
+	started := h.brokerCancel != nil
+	h.mu.RUnlock()
+	if !started {
+		h.mu.Lock()
+		if h.closed.Load() {
+			h.mu.Unlock()
+			return ErrHubClosed
+		}
+		h.startBroker()
+		h.mu.Unlock()
+	}
+	m.Origin = h.id
+	timeout := h.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultHubWriteTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return h.Broker.Publish(ctx, m)
+}
@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memBroker is an in-memory Broker shared by the hubs of a test.
+type memBroker struct {
+	mu    sync.Mutex
+	subs  map[int]func(*BrokerMessage)
+	next  int
+	ready chan struct{}
+	fail  error
+}
+
+func newMemBroker() *memBroker {
+	return &memBroker{subs: make(map[int]func(*BrokerMessage)), ready: make(chan struct{}, 16)}
+}
+
+func (b *memBroker) Publish(ctx context.Context, m *BrokerMessage) error {
+	p, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	subs := make([]func(*BrokerMessage), 0, len(b.subs))
+	for _, f := range b.subs {
+		subs = append(subs, f)
+	}
+	b.mu.Unlock()
+	for _, f := range subs {
+		var m BrokerMessage
+		if err := m.UnmarshalBinary(p); err != nil {
+			return err
+		}
+		f(&m)
+	}
+	return nil
+}
+
+func (b *memBroker) Subscribe(ctx context.Context, f func(*BrokerMessage)) error {
+	b.mu.Lock()
+	if err := b.fail; err != nil {
+		b.fail = nil
+		b.mu.Unlock()
+		return err
+	}
+	id := b.next
+	b.next++
+	b.subs[id] = f
+	b.mu.Unlock()
+	b.ready <- struct{}{}
+
+	<-ctx.Done()
+	b.mu.Lock()
+	delete(b.subs, id)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *memBroker) waitReady(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-b.ready:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for subscription")
+		}
+	}
+}
+
+func TestBrokerMessageBinary(t *testing.T) {
+	in := BrokerMessage{Origin: "node-1", Room: "lobby", All: true, MessageType: BinaryMessage, Data: []byte{0, 1, 2}}
+	p, err := in.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out BrokerMessage
+	if err := out.UnmarshalBinary(p); err != nil {
+		t.Fatal(err)
+	}
+	if out.Origin != in.Origin || out.Room != in.Room || out.All != in.All || out.MessageType != in.MessageType || !bytes.Equal(out.Data, in.Data) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+
+	for _, p := range [][]byte{nil, {2, 0, 1}, {1, 0, 1, 5, 'a'}, {1, 0, 1, 0, 0xff}} {
+		if err := out.UnmarshalBinary(p); !errors.Is(err, errInvalidBrokerMessage) {
+			t.Errorf("UnmarshalBinary(%v) = %v, want %v", p, err, errInvalidBrokerMessage)
+		}
+	}
+}
+
+func TestHubBroker(t *testing.T) {
+	b := newMemBroker()
+	h1 := &Hub{Broker: b}
+	defer h1.Close()
+	h2 := &Hub{Broker: b}
+	defer h2.Close()
+
+	s1, c1 := newPipeConns()
+	s2, c2 := newPipeConns()
+	s3, c3 := newPipeConns()
+	if err := h1.Join("a", s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h2.Join("a", s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h2.Join("b", s3); err != nil {
+		t.Fatal(err)
+	}
+	b.waitReady(t, 2)
+
+	if err := h1.Broadcast("a", TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c1); got != "hello" {
+		t.Fatalf("local member got %q", got)
+	}
+	if got := readString(t, c2); got != "hello" {
+		t.Fatalf("remote member got %q", got)
+	}
+
+	if err := h1.BroadcastAll(TextMessage, []byte("all")); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Conn{c1, c2, c3} {
+		if got := readString(t, c); got != "all" {
+			t.Fatalf("got %q, want %q", got, "all")
+		}
+	}
+
+	// The publishing hub does not deliver its own messages twice.
+	if err := h1.Broadcast("a", TextMessage, []byte("once")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c1); got != "once" {
+		t.Fatalf("got %q", got)
+	}
+	_ = c1.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, p, err := c1.ReadMessage(); err == nil {
+		t.Fatalf("duplicate message %q", p)
+	}
+}
+
+func TestHubBrokerRetry(t *testing.T) {
+	b := newMemBroker()
+	b.fail = errors.New("unavailable")
+	errs := make(chan error, 1)
+	h := &Hub{Broker: b, OnBrokerError: func(err error) { errs <- err }}
+	defer h.Close()
+
+	s, _ := newPipeConns()
+	if err := h.Join("a", s); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err.Error() != "unavailable" {
+			t.Fatalf("OnBrokerError(%v)", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnBrokerError not called")
+	}
+	b.waitReady(t, 1)
+}
+
+func TestHubBrokerClose(t *testing.T) {
+	b := newMemBroker()
+	h := &Hub{Broker: b}
+	s, _ := newPipeConns()
+	if err := h.Join("a", s); err != nil {
+		t.Fatal(err)
+	}
+	b.waitReady(t, 1)
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		n := len(b.subs)
+		b.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription not cancelled by Close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
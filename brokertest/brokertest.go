@@ -0,0 +1,499 @@
+// Package brokertest provides a conformance suite for implementations of
+// websocket.Broker.
+//
+// Run the suite from a test of the implementation, giving it a function that
+// returns brokers connected to a new backend:
+//
+//	func TestConformance(t *testing.T) {
+//		brokertest.Run(t, brokertest.Suite{
+//			NewBrokers: func(t *testing.T, n int) ([]websocket.Broker, func()) {
+//				channel := "test-" + strconv.Itoa(rand.Int())
+//				brokers := make([]websocket.Broker, n)
+//				for i := range brokers {
+//					brokers[i] = mybroker.New(client(t), channel)
+//				}
+//				return brokers, nil
+//			},
+//		})
+//	}
+//
+// The suite checks that the messages published are delivered intact to every
+// subscriber, in order and at least once, that Subscribe returns when its
+// context is done, that the subscriptions survive a restart of the backend,
+// and that hubs sharing the brokers keep their rooms apart.
+package brokertest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+	"github.com/gflydev/websocket/wstest"
+)
+
+// DefaultTimeout is the time a message is waited for when Suite.Timeout is
+// zero.
+const DefaultTimeout = 5 * time.Second
+
+// syncRoom is the room of the messages published to wait for the
+// subscriptions to be established. They are hidden from the checks.
+const syncRoom = "brokertest.sync"
+
+// Suite configures the conformance suite.
+type Suite struct {
+	// NewBrokers returns n brokers connected to a new backend, so that the
+	// messages published by the brokers are delivered to the subscriptions
+	// of the n brokers only. Each test of the suite calls NewBrokers; use
+	// t.Cleanup to release the brokers and the backend.
+	//
+	// If restart is not nil, it interrupts the connections of the brokers to
+	// the backend, for example by restarting the backend, and returns once
+	// the backend accepts connections again. The tests using restart are
+	// skipped if it is nil.
+	NewBrokers func(t *testing.T, n int) (brokers []websocket.Broker, restart func())
+
+	// Unordered is true if the broker does not deliver the messages of a
+	// publisher in the order they were published. The ordering test is then
+	// skipped.
+	Unordered bool
+
+	// Timeout is the time a message is waited for before the test fails. If
+	// zero, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+func (s *Suite) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return s.Timeout
+}
+
+// Run runs the conformance suite as subtests of t.
+func Run(t *testing.T, s Suite) {
+	if s.NewBrokers == nil {
+		t.Fatal("brokertest: Suite.NewBrokers is nil")
+	}
+	t.Run("Delivery", s.testDelivery)
+	t.Run("Ordering", s.testOrdering)
+	t.Run("AtLeastOnce", s.testAtLeastOnce)
+	t.Run("Cancel", s.testCancel)
+	t.Run("Reconnect", s.testReconnect)
+	t.Run("RoomIsolation", s.testRoomIsolation)
+}
+
+func (s *Suite) newBrokers(t *testing.T, n int) ([]websocket.Broker, func()) {
+	t.Helper()
+	brokers, restart := s.NewBrokers(t, n)
+	if len(brokers) != n {
+		t.Fatalf("NewBrokers returned %d brokers, want %d", len(brokers), n)
+	}
+	return brokers, restart
+}
+
+// recorder records the messages delivered to a subscription. The messages
+// may be delivered concurrently.
+type recorder struct {
+	mu   sync.Mutex
+	msgs []websocket.BrokerMessage
+	sync int // number of messages in syncRoom
+}
+
+func (r *recorder) receive(m *websocket.BrokerMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m.Room == syncRoom && !m.All {
+		r.sync++
+		return
+	}
+	// The data of a message may be reused after f returns.
+	c := *m
+	c.Data = append([]byte(nil), m.Data...)
+	r.msgs = append(r.msgs, c)
+}
+
+func (r *recorder) messages() []websocket.BrokerMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]websocket.BrokerMessage(nil), r.msgs...)
+}
+
+func (r *recorder) synced() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sync > 0
+}
+
+// subscribe subscribes to b until the test ends, subscribing again if the
+// subscription fails as a hub does.
+func subscribe(t *testing.T, b websocket.Broker) *recorder {
+	r := &recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			err := b.Subscribe(ctx, r.receive)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				t.Logf("Subscribe: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return r
+}
+
+// waitFor waits for cond to be true.
+func (s *Suite) waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(s.timeout())
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// sync publishes messages with b until every recorder received one, so that
+// the subscriptions are known to be established.
+func (s *Suite) sync(t *testing.T, b websocket.Broker, recs ...*recorder) {
+	t.Helper()
+	deadline := time.Now().Add(s.timeout())
+	for i := 0; ; i++ {
+		// Publish errors are retried: the backend may still be starting.
+		_ = publish(b, &websocket.BrokerMessage{Room: syncRoom, MessageType: websocket.TextMessage, Data: []byte(strconv.Itoa(i))})
+		wait := time.Now().Add(20 * time.Millisecond)
+		for time.Now().Before(wait) {
+			if allSynced(recs) {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the subscriptions")
+		}
+	}
+}
+
+func allSynced(recs []*recorder) bool {
+	for _, r := range recs {
+		if !r.synced() {
+			return false
+		}
+	}
+	return true
+}
+
+func publish(b websocket.Broker, m *websocket.BrokerMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return b.Publish(ctx, m)
+}
+
+// testDelivery checks that a message is delivered intact to every
+// subscriber, including the publisher.
+func (s *Suite) testDelivery(t *testing.T) {
+	brokers, _ := s.newBrokers(t, 2)
+	recs := []*recorder{subscribe(t, brokers[0]), subscribe(t, brokers[1])}
+	s.sync(t, brokers[0], recs...)
+
+	want := []websocket.BrokerMessage{
+		{Origin: "hub-1", Room: "room", MessageType: websocket.TextMessage, Data: []byte("hello")},
+		{Origin: "hub-1", All: true, MessageType: websocket.BinaryMessage, Data: []byte{0, 1, 2, 0xff}},
+		{Origin: "hub-1", Room: "room", Presence: true, MessageType: websocket.BinaryMessage, Data: []byte("presence")},
+		{Origin: "hub-1", Room: "empty", MessageType: websocket.TextMessage},
+	}
+	for i := range want {
+		m := want[i]
+		if err := publish(brokers[0], &m); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	for i, r := range recs {
+		s.waitFor(t, fmt.Sprintf("the messages on broker %d", i), func() bool {
+			return distinct(r.messages()) >= len(want)
+		})
+		for _, m := range want {
+			if !contains(r.messages(), m) {
+				t.Errorf("broker %d did not receive %+v, got %+v", i, m, r.messages())
+			}
+		}
+	}
+}
+
+// testOrdering checks that the messages of a publisher are delivered in the
+// order they were published, ignoring redeliveries.
+func (s *Suite) testOrdering(t *testing.T) {
+	if s.Unordered {
+		t.Skip("the broker does not preserve the order of the messages")
+	}
+	const n = 100
+	brokers, _ := s.newBrokers(t, 2)
+	recs := []*recorder{subscribe(t, brokers[0]), subscribe(t, brokers[1])}
+	s.sync(t, brokers[0], recs...)
+
+	for i := 0; i < n; i++ {
+		if err := publish(brokers[0], sequenced("a", i)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	for i, r := range recs {
+		s.waitFor(t, fmt.Sprintf("%d messages on broker %d", n, i), func() bool {
+			return distinct(r.messages()) >= n
+		})
+		next := 0
+		for _, m := range r.messages() {
+			seq, _ := strconv.Atoi(string(m.Data))
+			switch {
+			case seq == next:
+				next++
+			case seq > next:
+				t.Fatalf("broker %d received message %d before message %d", i, seq, next)
+			}
+		}
+	}
+}
+
+// testAtLeastOnce checks that every message published concurrently by
+// several publishers reaches every subscriber.
+func (s *Suite) testAtLeastOnce(t *testing.T) {
+	const n = 100
+	brokers, _ := s.newBrokers(t, 3)
+	var recs []*recorder
+	for _, b := range brokers {
+		recs = append(recs, subscribe(t, b))
+	}
+	for _, b := range brokers {
+		s.sync(t, b, recs...)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(brokers))
+	for i, b := range brokers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if err := publish(b, sequenced("p"+strconv.Itoa(i), j)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Publish: %v", err)
+	}
+	for i, r := range recs {
+		s.waitFor(t, fmt.Sprintf("%d messages on broker %d", n*len(brokers), i), func() bool {
+			return distinct(r.messages()) >= n*len(brokers)
+		})
+	}
+}
+
+// testCancel checks that Subscribe returns nil when its context is done.
+func (s *Suite) testCancel(t *testing.T) {
+	brokers, _ := s.newBrokers(t, 1)
+	r := &recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- brokers[0].Subscribe(ctx, r.receive) }()
+	s.sync(t, brokers[0], r)
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Subscribe returned %v after the context was canceled, want nil", err)
+		}
+	case <-time.After(s.timeout()):
+		t.Fatal("Subscribe did not return after the context was canceled")
+	}
+}
+
+// testReconnect checks that the messages published after a restart of the
+// backend are delivered, either by the subscription recovering or by a new
+// subscription after Subscribe returned an error.
+func (s *Suite) testReconnect(t *testing.T) {
+	brokers, restart := s.newBrokers(t, 2)
+	if restart == nil {
+		t.Skip("Suite.NewBrokers returned no restart function")
+	}
+	recs := []*recorder{subscribe(t, brokers[0]), subscribe(t, brokers[1])}
+	s.sync(t, brokers[0], recs...)
+
+	restart()
+	for _, r := range recs {
+		r.mu.Lock()
+		r.sync = 0
+		r.mu.Unlock()
+	}
+	s.sync(t, brokers[0], recs...)
+	if err := publish(brokers[0], sequenced("a", 0)); err != nil {
+		t.Fatalf("Publish after restart: %v", err)
+	}
+	for i, r := range recs {
+		s.waitFor(t, fmt.Sprintf("the message on broker %d after restart", i), func() bool {
+			return len(r.messages()) > 0
+		})
+	}
+}
+
+// testRoomIsolation checks with hubs sharing the brokers that a broadcast
+// reaches the members of its room on every hub, once, and no other member.
+func (s *Suite) testRoomIsolation(t *testing.T) {
+	brokers, _ := s.newBrokers(t, 2)
+	hubs := make([]*websocket.Hub, len(brokers))
+	for i, b := range brokers {
+		hubs[i] = &websocket.Hub{Broker: b}
+		t.Cleanup(func() { _ = hubs[i].Close() })
+	}
+	local := join(t, hubs[0], "a")
+	remote := join(t, hubs[1], "a")
+	other := join(t, hubs[1], "b")
+
+	// Broadcast probes to both rooms until the hubs are subscribed.
+	deadline := time.Now().Add(s.timeout())
+	pending := map[<-chan string]bool{remote: true, other: true}
+	for i := 0; len(pending) > 0; i++ {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the hubs to subscribe")
+		}
+		probe := []byte(syncRoom + " " + strconv.Itoa(i))
+		_ = hubs[0].Broadcast("a", websocket.TextMessage, probe)
+		_ = hubs[0].Broadcast("b", websocket.TextMessage, probe)
+		time.Sleep(20 * time.Millisecond)
+		for c := range pending {
+			select {
+			case <-c:
+				delete(pending, c)
+			default:
+			}
+		}
+	}
+
+	if err := hubs[0].Broadcast("a", websocket.TextMessage, []byte("to a")); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	if err := hubs[0].Broadcast("b", websocket.TextMessage, []byte("to b")); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		c    <-chan string
+		want string
+	}{
+		{"local member of a", local, "to a"},
+		{"remote member of a", remote, "to a"},
+		{"remote member of b", other, "to b"},
+	} {
+		if got, ok := s.next(tc.c); !ok {
+			t.Fatalf("%s: no message, want %q", tc.name, tc.want)
+		} else if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	// The hub ignores its own messages coming back from the broker.
+	if err := hubs[0].Broadcast("a", websocket.TextMessage, []byte("again")); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	if got, _ := s.next(local); got != "again" {
+		t.Errorf("local member of a: got %q after a single broadcast, want %q", got, "again")
+	}
+}
+
+// join adds a connection to room of h and returns the messages received by
+// its peer.
+func join(t *testing.T, h *websocket.Hub, room string) <-chan string {
+	t.Helper()
+	client, server := wstest.NewPipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	go func() {
+		for {
+			if _, _, err := server.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	msgs := make(chan string, 1024)
+	go func() {
+		defer close(msgs)
+		for {
+			_, p, err := client.ReadMessage()
+			if err != nil {
+				return
+			}
+			msgs <- string(p)
+		}
+	}()
+	if err := h.Join(room, server); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	return msgs
+}
+
+// next returns the next message of msgs that is not a probe of the hub
+// subscriptions.
+func (s *Suite) next(msgs <-chan string) (string, bool) {
+	timeout := time.After(s.timeout())
+	for {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				return "", false
+			}
+			if !strings.HasPrefix(m, syncRoom+" ") {
+				return m, true
+			}
+		case <-timeout:
+			return "", false
+		}
+	}
+}
+
+// sequenced returns the message number i of a sequence published to room.
+func sequenced(room string, i int) *websocket.BrokerMessage {
+	return &websocket.BrokerMessage{Origin: "brokertest", Room: room, MessageType: websocket.TextMessage, Data: []byte(strconv.Itoa(i))}
+}
+
+// distinct returns the number of messages without their redeliveries.
+func distinct(msgs []websocket.BrokerMessage) int {
+	seen := make(map[string]bool, len(msgs))
+	for _, m := range msgs {
+		seen[fmt.Sprintf("%q %q %t %t %d %q", m.Origin, m.Room, m.All, m.Presence, m.MessageType, m.Data)] = true
+	}
+	return len(seen)
+}
+
+func contains(msgs []websocket.BrokerMessage, m websocket.BrokerMessage) bool {
+	for _, other := range msgs {
+		if other.Origin == m.Origin && other.Room == m.Room && other.All == m.All &&
+			other.Presence == m.Presence && other.MessageType == m.MessageType && bytes.Equal(other.Data, m.Data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package brokertest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gflydev/websocket"
+)
+
+var errRestarted = errors.New("backend restarted")
+
+// memBackend relays messages in memory between its brokers. A restart ends
+// the subscriptions with an error.
+type memBackend struct {
+	mu      sync.Mutex
+	subs    map[*memSub]bool
+	stopped chan struct{}
+}
+
+type memSub struct {
+	f func(m *websocket.BrokerMessage)
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{subs: make(map[*memSub]bool), stopped: make(chan struct{})}
+}
+
+func (b *memBackend) restart() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.stopped)
+	b.stopped = make(chan struct{})
+	b.subs = make(map[*memSub]bool)
+}
+
+func (b *memBackend) Publish(ctx context.Context, m *websocket.BrokerMessage) error {
+	p, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		var c websocket.BrokerMessage
+		if err := c.UnmarshalBinary(p); err != nil {
+			return err
+		}
+		s.f(&c)
+	}
+	return nil
+}
+
+func (b *memBackend) Subscribe(ctx context.Context, f func(m *websocket.BrokerMessage)) error {
+	s := &memSub{f: f}
+	b.mu.Lock()
+	b.subs[s] = true
+	stopped := b.stopped
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, s)
+		b.mu.Unlock()
+	}()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-stopped:
+		return errRestarted
+	}
+}
+
+func TestRun(t *testing.T) {
+	Run(t, Suite{
+		NewBrokers: func(t *testing.T, n int) ([]websocket.Broker, func()) {
+			b := newMemBackend()
+			brokers := make([]websocket.Broker, n)
+			for i := range brokers {
+				brokers[i] = b
+			}
+			return brokers, b.restart
+		},
+	})
+}
@@ -0,0 +1,274 @@
+package websocket
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Endpoint configures a WebSocket endpoint with chained method calls and
+// produces the handler of its route, so that each route declares its own
+// upgrade options and connection settings instead of sharing an Upgrader:
+//
+//	router.Handle("/chat", websocket.New().
+//		MaxMessageSize(64<<10).
+//		Subprotocols("chat.v2").
+//		Compression(flate.BestSpeed).
+//		OnConnect(join).
+//		Handler(chat))
+//
+// The methods modify and return the endpoint. The handlers produced by
+// Handler and FastHTTPHandler use the configuration at the time of the call.
+type Endpoint struct {
+	upgrader  Upgrader
+	configure []func(u *Upgrader)
+	settings  endpointSettings
+}
+
+// New returns an endpoint with the default upgrade options.
+func New() *Endpoint {
+	return &Endpoint{}
+}
+
+// MaxMessageSize sets the maximum size in bytes of the messages read from
+// the connections. See Conn.SetReadLimit.
+func (e *Endpoint) MaxMessageSize(n int64) *Endpoint {
+	e.settings.readLimit = n
+	return e
+}
+
+// BufferSizes sets the I/O buffer sizes of the connections. See
+// Upgrader.ReadBufferSize and Upgrader.WriteBufferSize.
+func (e *Endpoint) BufferSizes(read, write int) *Endpoint {
+	e.upgrader.ReadBufferSize = read
+	e.upgrader.WriteBufferSize = write
+	return e
+}
+
+// HandshakeTimeout sets the duration for the handshake to complete.
+func (e *Endpoint) HandshakeTimeout(d time.Duration) *Endpoint {
+	e.upgrader.HandshakeTimeout = d
+	return e
+}
+
+// Subprotocols sets the server's supported protocols in order of
+// preference.
+func (e *Endpoint) Subprotocols(protocols ...string) *Endpoint {
+	e.upgrader.Subprotocols = protocols
+	return e
+}
+
+// Compression enables the negotiation of per message compression with the
+// flate compression level. A level of zero selects the default level. See
+// Upgrader.EnableCompression.
+func (e *Endpoint) Compression(level int) *Endpoint {
+	e.upgrader.EnableCompression = true
+	e.upgrader.CompressionLevel = level
+	return e
+}
+
+// Origins allows the handshakes from the origin patterns. See
+// OriginPolicy.
+func (e *Endpoint) Origins(patterns ...string) *Endpoint {
+	e.upgrader.OriginPolicy = &OriginPolicy{AllowedOrigins: patterns}
+	return e
+}
+
+// Authenticate sets the function authenticating the handshakes. See
+// Upgrader.Authenticate.
+func (e *Endpoint) Authenticate(f func(r *http.Request) (principal interface{}, err error)) *Endpoint {
+	e.upgrader.Authenticate = f
+	return e
+}
+
+// Keepalive pings the connections every interval and closes them if no pong
+// is received within timeout. See Conn.EnableKeepalive.
+func (e *Endpoint) Keepalive(interval, timeout time.Duration) *Endpoint {
+	e.settings.keepaliveInterval = interval
+	e.settings.keepaliveTimeout = timeout
+	return e
+}
+
+// TimeSync estimates the offset between the clocks of the connections and
+// their peers, pinging them every interval if it is positive. See
+// Conn.EnableTimeSync.
+func (e *Endpoint) TimeSync(interval time.Duration) *Endpoint {
+	e.settings.timeSync = true
+	e.settings.timeSyncInterval = interval
+	return e
+}
+
+// WriteQueue makes the write methods of the connections safe to call from
+// multiple goroutines. See Conn.EnableWriteQueue.
+func (e *Endpoint) WriteQueue(size int, policy OverflowPolicy) *Endpoint {
+	e.settings.writeQueueSize = size
+	e.settings.writeQueuePolicy = policy
+	return e
+}
+
+// Hub joins the connections to a room of the hub before the handler is
+// called.
+func (e *Endpoint) Hub(h *Hub, room string) *Endpoint {
+	e.settings.hub = h
+	e.settings.room = room
+	return e
+}
+
+// OnConnect adds a function called with each connection before the
+// handler, in the order added. If f returns an error, the connection is
+// closed with status 1011 (internal server error) and the handler is not
+// called.
+func (e *Endpoint) OnConnect(f func(c *Conn) error) *Endpoint {
+	e.settings.onConnect = append(e.settings.onConnect, f)
+	return e
+}
+
+// OnDisconnect adds a function called with each connection when the
+// handler returns, before the connection is closed.
+func (e *Endpoint) OnDisconnect(f func(c *Conn)) *Endpoint {
+	e.settings.onDisconnect = append(e.settings.onDisconnect, f)
+	return e
+}
+
+// Configure adds a function modifying the Upgrader of the endpoint, for the
+// options without a method. The functions run when a handler is produced.
+func (e *Endpoint) Configure(f func(u *Upgrader)) *Endpoint {
+	e.configure = append(e.configure, f)
+	return e
+}
+
+// Upgrader returns the Upgrader of the endpoint's configuration.
+func (e *Endpoint) Upgrader() *Upgrader {
+	u := e.upgrader
+	for _, f := range e.configure {
+		f(&u)
+	}
+	return &u
+}
+
+// FastHTTPUpgrader returns the FastHTTPUpgrader of the endpoint's
+// configuration. The request functions of the Upgrader, such as
+// Authenticate and CheckOrigin, take a net/http request and are not carried
+// over; set them on the returned upgrader.
+func (e *Endpoint) FastHTTPUpgrader() *FastHTTPUpgrader {
+	u := e.Upgrader()
+	return &FastHTTPUpgrader{
+		HandshakeTimeout:        u.HandshakeTimeout,
+		ReadBufferSize:          u.ReadBufferSize,
+		WriteBufferSize:         u.WriteBufferSize,
+		WriteBufferPool:         u.WriteBufferPool,
+		ReadBufferPool:          u.ReadBufferPool,
+		Subprotocols:            u.Subprotocols,
+		OriginPolicy:            u.OriginPolicy,
+		EnableCompression:       u.EnableCompression,
+		CompressionLevel:        u.CompressionLevel,
+		CompressionThreshold:    u.CompressionThreshold,
+		CompressionDictionaries: u.CompressionDictionaries,
+		Extensions:              u.Extensions,
+		StrictUTF8:              u.StrictUTF8,
+		ReadLimits:              u.ReadLimits,
+		TCP:                     u.TCP,
+		ServerContextTakeover:   u.ServerContextTakeover,
+		ClientContextTakeover:   u.ClientContextTakeover,
+		ConnManager:             u.ConnManager,
+		Registry:                u.Registry,
+		Metrics:                 u.Metrics,
+		Logger:                  u.Logger,
+		EventSink:               u.EventSink,
+	}
+}
+
+// Handler returns an http.Handler upgrading the requests and calling fn with
+// each connection in the request goroutine. The connection is closed when fn
+// returns.
+func (e *Endpoint) Handler(fn func(c *Conn)) http.Handler {
+	u := e.Upgrader()
+	s := e.settings.clone()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.serve(c, fn)
+	})
+}
+
+// FastHTTPHandler is like Handler for fasthttp servers, such as the routes
+// of a gFly application. The handler is called with each connection in the
+// goroutine of the hijacked connection.
+func (e *Endpoint) FastHTTPHandler(fn func(c *Conn)) fasthttp.RequestHandler {
+	u := e.FastHTTPUpgrader()
+	s := e.settings.clone()
+	return func(ctx *fasthttp.RequestCtx) {
+		_ = u.Upgrade(ctx, func(c *Conn) { s.serve(c, fn) })
+	}
+}
+
+// endpointSettings are the connection settings of an endpoint.
+type endpointSettings struct {
+	readLimit         int64
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	timeSync          bool
+	timeSyncInterval  time.Duration
+	writeQueueSize    int
+	writeQueuePolicy  OverflowPolicy
+	hub               *Hub
+	room              string
+	onConnect         []func(c *Conn) error
+	onDisconnect      []func(c *Conn)
+}
+
+// clone returns a copy of the settings not sharing the hooks.
+func (s *endpointSettings) clone() *endpointSettings {
+	c := *s
+	c.onConnect = slices.Clone(s.onConnect)
+	c.onDisconnect = slices.Clone(s.onDisconnect)
+	return &c
+}
+
+// serve applies the settings to a new connection and calls fn.
+func (s *endpointSettings) serve(c *Conn, fn func(c *Conn)) {
+	defer c.Close()
+	if s.readLimit > 0 {
+		c.SetReadLimit(s.readLimit)
+	}
+	if s.writeQueueSize > 0 {
+		_ = c.EnableWriteQueue(s.writeQueueSize, s.writeQueuePolicy)
+	}
+	if s.keepaliveInterval > 0 {
+		_ = c.EnableKeepalive(s.keepaliveInterval, s.keepaliveTimeout)
+	}
+	if s.timeSync {
+		_ = c.EnableTimeSync(s.timeSyncInterval)
+	}
+	if err := s.connect(c); err != nil {
+		c.log(slog.LevelWarn, "websocket: connect hook failed", "error", err.Error())
+		_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseInternalServerErr, ""), time.Now().Add(writeWait))
+		return
+	}
+	defer func() {
+		for _, f := range s.onDisconnect {
+			f(c)
+		}
+	}()
+	fn(c)
+}
+
+// connect joins the connection to the hub and runs the connect hooks.
+func (s *endpointSettings) connect(c *Conn) error {
+	if s.hub != nil {
+		if err := s.hub.Join(s.room, c); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.onConnect {
+		if err := f(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestEndpoint(t *testing.T) {
+	hub := &Hub{}
+	defer hub.Close()
+	disconnected := make(chan *Conn, 1)
+	e := New().
+		MaxMessageSize(8).
+		Subprotocols("chat.v2").
+		Compression(0).
+		Hub(hub, "lobby").
+		OnConnect(func(c *Conn) error {
+			c.Set("greeting", "hi")
+			return nil
+		}).
+		OnDisconnect(func(c *Conn) { disconnected <- c }).
+		Configure(func(u *Upgrader) { u.CompressionThreshold = 16 })
+	if u := e.Upgrader(); !u.EnableCompression || u.CompressionThreshold != 16 {
+		t.Fatalf("Upgrader returned %+v", u)
+	}
+	s := httptest.NewServer(e.Handler(func(c *Conn) {
+		v, _ := c.Get("greeting")
+		_ = c.WriteMessage(TextMessage, []byte(v.(string)+" "+strings.Join(hub.RoomsOf(c), ",")))
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	d := Dialer{Subprotocols: []string{"chat.v2"}, EnableCompression: true}
+	c, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Subprotocol() != "chat.v2" {
+		t.Errorf("Subprotocol returned %q, want chat.v2", c.Subprotocol())
+	}
+	if got := readString(t, c); got != "hi lobby" {
+		t.Fatalf("read %q, want hi lobby", got)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("too long message")); err != nil {
+		t.Fatal(err)
+	}
+	var closeErr *CloseError
+	if _, _, err := c.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseMessageTooBig {
+		t.Fatalf("ReadMessage returned %v, want close 1009", err)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+}
+
+func TestEndpointConnectError(t *testing.T) {
+	called := false
+	s := httptest.NewServer(New().
+		OnConnect(func(c *Conn) error { return errors.New("room full") }).
+		Handler(func(c *Conn) { called = true }))
+	defer s.Close()
+	c, _, err := DefaultDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var closeErr *CloseError
+	if _, _, err := c.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseInternalServerErr {
+		t.Fatalf("ReadMessage returned %v, want close 1011", err)
+	}
+	if called {
+		t.Error("handler called after a connect error")
+	}
+}
+
+func TestEndpointFastHTTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	h := New().Subprotocols("chat.v2").FastHTTPHandler(func(c *Conn) {
+		_ = c.WriteMessage(TextMessage, []byte(c.Subprotocol()))
+	})
+	go func() { _ = (&fasthttp.Server{Handler: h}).Serve(ln) }()
+
+	d := Dialer{Subprotocols: []string{"chat.v2"}}
+	c, resp, err := d.Dial("ws://"+ln.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if got := readString(t, c); got != "chat.v2" {
+		t.Fatalf("read %q, want chat.v2", got)
+	}
+}
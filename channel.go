@@ -0,0 +1,245 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrChannelClosed is returned by the methods of a Channel after the channel
+// is closed.
+var ErrChannelClosed = errors.New("websocket: channel closed")
+
+// channelEndpoint is the part of a Channel used by Router.dispatch.
+type channelEndpoint interface {
+	deliver(e *Event) error
+	stop(err error)
+}
+
+// Channel is a typed channel of values of type T, sent and received as the
+// events of the same name on a connection. The values are encoded with a
+// codec as the data of the events.
+//
+// The events are received by Router.Serve, so the connection must be served
+// by a Router; the events of a channel are not passed to the handlers of the
+// router. The payloads of binary codecs are carried as base64 strings in
+// the JSON event envelope.
+//
+// The channel is closed by Close, when the connection stops being served or
+// when a write fails. It is safe to call Channel's methods concurrently.
+type Channel[T any] struct {
+	conn  *Conn
+	event string
+	codec Codec
+
+	in        chan T
+	out       chan T
+	startOut  sync.Once
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex // held by deliver; Close waits for it to close in
+	err       error        // set before done is closed
+}
+
+var _ channelEndpoint = (*Channel[int])(nil)
+
+// NewChannel returns a channel for the event name on the connection. The
+// buffer is the number of received values queued for Receive; when the queue
+// is full, Serve waits for the application to receive a value before it
+// dispatches the next message of the connection.
+//
+// NewChannel returns an error if the connection has an open channel for the
+// event.
+func NewChannel[T any](c *Conn, event string, codec Codec, buffer int) (*Channel[T], error) {
+	if c == nil {
+		return nil, ErrNilConn
+	}
+	ch := &Channel[T]{
+		conn:  c,
+		event: event,
+		codec: codec,
+		in:    make(chan T, buffer),
+		out:   make(chan T),
+		done:  make(chan struct{}),
+	}
+	c.channelMu.Lock()
+	defer c.channelMu.Unlock()
+	if _, ok := c.channels[event]; ok {
+		return nil, fmt.Errorf("websocket: channel for event %q already open", event)
+	}
+	if c.channels == nil {
+		c.channels = make(map[string]channelEndpoint)
+	}
+	c.channels[event] = ch
+	return ch, nil
+}
+
+// Send encodes v and writes it as an event of the channel.
+//
+// Send writes the event with WriteMessage, or batches it under
+// SetEventBatching. Sending from goroutines other
+// than the one serving the connection writes concurrently with the handlers;
+// use EnableWriteQueue to make the writes safe.
+func (ch *Channel[T]) Send(v T) error {
+	select {
+	case <-ch.done:
+		return ErrChannelClosed
+	default:
+	}
+	p, err := ch.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if ch.codec.MessageType() == BinaryMessage {
+		if p, err = json.Marshal(p); err != nil {
+			return err
+		}
+	}
+	e, err := json.Marshal(&Event{Event: ch.event, Data: p})
+	if err != nil {
+		return err
+	}
+	return ch.conn.writeEvent(e)
+}
+
+// Receive returns the next value received on the channel. Receive returns
+// the error that closed the channel, or ErrChannelClosed, once the queued
+// values are received.
+func (ch *Channel[T]) Receive(ctx context.Context) (T, error) {
+	select {
+	case v, ok := <-ch.in:
+		if !ok {
+			var zero T
+			return zero, ch.Err()
+		}
+		return v, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// In returns the Go channel of the received values. The Go channel is closed
+// with the channel, after the queued values.
+func (ch *Channel[T]) In() <-chan T { return ch.in }
+
+// Out returns a Go channel whose values are sent with Send by a goroutine
+// of the channel. After the channel is closed, the values are no longer
+// received from the Go channel; select on Done to stop sending.
+func (ch *Channel[T]) Out() chan<- T {
+	ch.startOut.Do(func() { go ch.sendOut() })
+	return ch.out
+}
+
+func (ch *Channel[T]) sendOut() {
+	for {
+		select {
+		case v := <-ch.out:
+			if err := ch.Send(v); err != nil {
+				if err != ErrChannelClosed {
+					ch.stop(err)
+				}
+				return
+			}
+		case <-ch.done:
+			return
+		}
+	}
+}
+
+// Done returns a Go channel that is closed when the channel is closed.
+func (ch *Channel[T]) Done() <-chan struct{} { return ch.done }
+
+// Err returns the error that closed the channel: ErrChannelClosed after
+// Close, the read error of the connection or the error of a failed write.
+// Err returns nil while the channel is open.
+func (ch *Channel[T]) Err() error {
+	select {
+	case <-ch.done:
+		return ch.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the channel. The events received later for the channel are
+// passed to the handlers of the router.
+func (ch *Channel[T]) Close() error {
+	ch.stop(ErrChannelClosed)
+	return nil
+}
+
+// stop closes the channel with err and unregisters it from the connection.
+func (ch *Channel[T]) stop(err error) {
+	ch.closeOnce.Do(func() {
+		ch.err = err
+		close(ch.done)
+		ch.conn.channelMu.Lock()
+		if ch.conn.channels[ch.event] == channelEndpoint(ch) {
+			delete(ch.conn.channels, ch.event)
+		}
+		ch.conn.channelMu.Unlock()
+		// Wait for deliver to return before closing the Go channel.
+		ch.mu.Lock()
+		close(ch.in)
+		ch.mu.Unlock()
+	})
+}
+
+// deliver decodes the data of e and queues the value for Receive.
+func (ch *Channel[T]) deliver(e *Event) error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	select {
+	case <-ch.done:
+		return nil
+	default:
+	}
+	var v T
+	if len(e.Data) > 0 {
+		data := []byte(e.Data)
+		if ch.codec.MessageType() == BinaryMessage {
+			if err := json.Unmarshal(e.Data, &data); err != nil {
+				return fmt.Errorf("websocket: decoding data of event %q: %w", e.Event, err)
+			}
+		}
+		var target any = &v
+		if t := reflect.TypeFor[T](); t.Kind() == reflect.Pointer {
+			// Decode into a new value, as required by ProtobufCodec.
+			v = reflect.New(t.Elem()).Interface().(T)
+			target = v
+		}
+		if err := ch.codec.Unmarshal(data, target); err != nil {
+			return fmt.Errorf("websocket: decoding data of event %q: %w", e.Event, err)
+		}
+	}
+	select {
+	case ch.in <- v:
+	case <-ch.done:
+	}
+	return nil
+}
+
+// channel returns the open channel for the event, or nil.
+func (c *Conn) channel(event string) channelEndpoint {
+	c.channelMu.Lock()
+	defer c.channelMu.Unlock()
+	return c.channels[event]
+}
+
+// stopChannels closes the channels with the error that stopped serving the
+// connection.
+func (c *Conn) stopChannels(err error) {
+	c.channelMu.Lock()
+	channels := make([]channelEndpoint, 0, len(c.channels))
+	for _, ch := range c.channels {
+		channels = append(channels, ch)
+	}
+	c.channelMu.Unlock()
+	for _, ch := range channels {
+		ch.stop(err)
+	}
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChannel(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MsgPackCodec} {
+		s, c := newPipeConns()
+		var r Router
+		handled := make(chan *Event, 1)
+		r.Handle("chat", func(c *Conn, e *Event) error {
+			handled <- e
+			return nil
+		})
+		in, err := NewChannel[chatMessage](s, "chat", codec, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewChannel[chatMessage](s, "chat", codec, 4); err == nil {
+			t.Error("second channel for the event returned no error")
+		}
+		done := make(chan error, 1)
+		go func() { done <- r.Serve(s) }()
+
+		// Out sends from another goroutine.
+		_ = c.EnableWriteQueue(8, OverflowBlock)
+		out, _ := NewChannel[*chatMessage](c, "chat", codec, 0)
+		if err := out.Send(&chatMessage{Room: "a", Text: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if msg, err := in.Receive(ctx); err != nil || msg != (chatMessage{Room: "a", Text: "hi"}) {
+			t.Errorf("Receive = %+v, %v", msg, err)
+		}
+		out.Out() <- &chatMessage{Text: "again"}
+		if msg := <-in.In(); msg.Text != "again" {
+			t.Errorf("received %+v", msg)
+		}
+		cancel()
+
+		// After Close, the events go to the router.
+		_ = in.Close()
+		if _, err := in.Receive(context.Background()); err != ErrChannelClosed {
+			t.Errorf("Receive after Close returned %v", err)
+		}
+		_ = out.Send(&chatMessage{Text: "routed"})
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Error("event not routed after Close")
+		}
+		c.Close()
+		if err := <-done; err == nil {
+			t.Error("Serve returned nil")
+		}
+	}
+}
+
+func TestChannelStopsWithServe(t *testing.T) {
+	s, c := newPipeConns()
+	var r Router
+	r.OnError = func(*Conn, *Event, error) {}
+	ch, _ := NewChannel[int](s, "n", JSONCodec, 1)
+	go func() { _ = r.Serve(s) }()
+	_ = c.WriteMessage(TextMessage, []byte(`{"event":"n","data":"x"}`))
+	_ = c.WriteMessage(TextMessage, []byte(`{"event":"n","data":1}`))
+	if v := <-ch.In(); v != 1 {
+		t.Errorf("received %d", v)
+	}
+	c.Close()
+	select {
+	case <-ch.Done():
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed")
+	}
+	if _, ok := <-ch.In(); ok {
+		t.Error("In not closed")
+	}
+	if err := ch.Err(); err == nil || errors.Is(err, ErrChannelClosed) {
+		t.Errorf("Err() = %v, want read error", err)
+	}
+	if err := ch.Send(2); err != ErrChannelClosed {
+		t.Errorf("Send returned %v", err)
+	}
+
+	if _, err := NewChannel[int](nil, "n", JSONCodec, 0); err != ErrNilConn {
+		t.Errorf("nil Conn returned %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrPayloadAuthentication is returned when reading a data message that
+// cannot be decrypted or authenticated by the payload cipher of the
+// connection.
+var ErrPayloadAuthentication = errors.New("websocket: message authentication failed")
+
+// PayloadCipher encrypts and authenticates the payloads of data messages.
+// Seal and Open append their output to dst and must be safe to call
+// concurrently.
+type PayloadCipher interface {
+	Seal(dst, payload []byte, messageType int) ([]byte, error)
+	Open(dst, sealed []byte, messageType int) ([]byte, error)
+}
+
+// SetPayloadCipher encrypts the payloads of the data messages written to
+// the connection and decrypts the payloads of the data messages read, so that
+// the payloads are private to the endpoints when TLS terminates at an
+// untrusted proxy. A nil cipher disables encryption. Both peers must use the
+// same cipher.
+//
+// The payloads are encrypted after compression and decrypted before
+// decompression, and the messages are framed as usual. With a cipher, the
+// writers returned by NextWriter and the readers returned by NextReader
+// buffer the entire message. Text messages are validated as UTF-8 after
+// decryption; proxies that validate the text messages they forward reject
+// encrypted text messages, so use binary messages through such proxies.
+//
+// SetPayloadCipher must be called before the first message is read or
+// written.
+func (c *Conn) SetPayloadCipher(pc PayloadCipher) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	c.cipher = pc
+	return nil
+}
+
+// sealWriter buffers a message and writes its sealed payload to the frames
+// of the message on Close.
+type sealWriter struct {
+	c           *Conn
+	mw          *messageWriter
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *sealWriter) Write(p []byte) (int, error) {
+	if w.mw.err != nil {
+		return 0, w.mw.err
+	}
+	return w.buf.Write(p)
+}
+
+func (w *sealWriter) Close() error {
+	if w.mw.err != nil {
+		return w.mw.err
+	}
+	sealed, err := w.c.cipher.Seal(nil, w.buf.Bytes(), w.messageType)
+	if err != nil {
+		return w.mw.endMessage(err)
+	}
+	if _, err := w.mw.Write(sealed); err != nil {
+		return err
+	}
+	return w.mw.Close()
+}
+
+// openReader reads a message and returns its opened payload.
+type openReader struct {
+	c           *Conn
+	r           io.ReadCloser
+	messageType int
+	opened      *bytes.Reader
+}
+
+func (r *openReader) Read(p []byte) (int, error) {
+	if r.opened == nil {
+		sealed, err := io.ReadAll(r.r)
+		if err != nil {
+			return 0, err
+		}
+		payload, err := r.c.cipher.Open(nil, sealed, r.messageType)
+		if err != nil {
+			return 0, err
+		}
+		r.opened = bytes.NewReader(payload)
+	}
+	return r.opened.Read(p)
+}
+
+func (r *openReader) Close() error { return r.r.Close() }
+
+// AEADAlgorithm identifies the AEAD of a payload cipher.
+type AEADAlgorithm int
+
+const (
+	// AESGCM is AES in Galois/Counter Mode with 16, 24 or 32 byte keys.
+	AESGCM AEADAlgorithm = iota
+
+	// XChaCha20Poly1305 is ChaCha20-Poly1305 with 32 byte keys and the
+	// extended nonces that are safe to pick at random for any number of
+	// messages.
+	XChaCha20Poly1305
+)
+
+// AEADConfig specifies the keys of an AEAD payload cipher.
+type AEADConfig struct {
+	// Algorithm specifies the AEAD. The zero value is AESGCM.
+	Algorithm AEADAlgorithm
+
+	// SealKey returns the ID and the key used to seal the next message.
+	// Rotate keys by returning a new ID and key: the ID is sent with each
+	// message for the peer to open it. An ID must always identify the same
+	// key.
+	SealKey func() (id uint32, key []byte, err error)
+
+	// OpenKey returns the key with the ID to open a message. During a key
+	// rotation, OpenKey must return the previous key until the peer seals
+	// messages with the new key.
+	OpenKey func(id uint32) (key []byte, err error)
+}
+
+// aeadCipher is the PayloadCipher returned by NewAEADCipher. A sealed
+// payload is the key ID, a random nonce and the ciphertext. The message type
+// and the key ID are authenticated as additional data.
+type aeadCipher struct {
+	config AEADConfig
+
+	mu    sync.Mutex
+	aeads map[uint32]cipher.AEAD
+}
+
+// maxCachedKeys bounds the AEADs cached across key rotations.
+const maxCachedKeys = 16
+
+// NewAEADCipher returns a PayloadCipher that seals payloads with an AEAD
+// and the keys of the configuration.
+func NewAEADCipher(config AEADConfig) (PayloadCipher, error) {
+	if config.SealKey == nil || config.OpenKey == nil {
+		return nil, errors.New("websocket: AEADConfig requires SealKey and OpenKey")
+	}
+	if config.Algorithm != AESGCM && config.Algorithm != XChaCha20Poly1305 {
+		return nil, errors.New("websocket: unknown AEAD algorithm")
+	}
+	return &aeadCipher{config: config, aeads: make(map[uint32]cipher.AEAD)}, nil
+}
+
+// aead returns the AEAD of the key with the ID.
+func (a *aeadCipher) aead(id uint32, key func() ([]byte, error)) (cipher.AEAD, error) {
+	a.mu.Lock()
+	aead := a.aeads[id]
+	a.mu.Unlock()
+	if aead != nil {
+		return aead, nil
+	}
+	k, err := key()
+	if err != nil {
+		return nil, err
+	}
+	if a.config.Algorithm == XChaCha20Poly1305 {
+		aead, err = chacha20poly1305.NewX(k)
+	} else {
+		var block cipher.Block
+		block, err = aes.NewCipher(k)
+		if err == nil {
+			aead, err = cipher.NewGCM(block)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	if len(a.aeads) >= maxCachedKeys {
+		clear(a.aeads)
+	}
+	a.aeads[id] = aead
+	a.mu.Unlock()
+	return aead, nil
+}
+
+func (a *aeadCipher) Seal(dst, payload []byte, messageType int) ([]byte, error) {
+	id, key, err := a.config.SealKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := a.aead(id, func() ([]byte, error) { return key, nil })
+	if err != nil {
+		return nil, err
+	}
+	var ad [5]byte
+	binary.BigEndian.PutUint32(ad[:4], id)
+	ad[4] = byte(messageType)
+
+	dst = append(dst, ad[:4]...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, payload, ad[:]), nil
+}
+
+func (a *aeadCipher) Open(dst, sealed []byte, messageType int) ([]byte, error) {
+	if len(sealed) < 4 {
+		return nil, ErrPayloadAuthentication
+	}
+	id := binary.BigEndian.Uint32(sealed)
+	aead, err := a.aead(id, func() ([]byte, error) { return a.config.OpenKey(id) })
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[4:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrPayloadAuthentication
+	}
+	var ad [5]byte
+	binary.BigEndian.PutUint32(ad[:4], id)
+	ad[4] = byte(messageType)
+	out, err := aead.Open(dst, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], ad[:])
+	if err != nil {
+		return nil, ErrPayloadAuthentication
+	}
+	return out, nil
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testKeys returns a cipher with the algorithm and keys of the given IDs,
+// sealing with the key of the ID in seal.
+func testKeys(t *testing.T, algorithm AEADAlgorithm, seal *atomic.Uint32, ids ...uint32) PayloadCipher {
+	t.Helper()
+	keys := make(map[uint32][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(id)}, 32)
+	}
+	pc, err := NewAEADCipher(AEADConfig{
+		Algorithm: algorithm,
+		SealKey: func() (uint32, []byte, error) {
+			id := seal.Load()
+			return id, keys[id], nil
+		},
+		OpenKey: func(id uint32) ([]byte, error) {
+			if key, ok := keys[id]; ok {
+				return key, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+func TestPayloadCipher(t *testing.T) {
+	for _, algorithm := range []AEADAlgorithm{AESGCM, XChaCha20Poly1305} {
+		var seal atomic.Uint32
+		seal.Store(1)
+		pc := testKeys(t, algorithm, &seal, 1, 2)
+
+		var connBuf bytes.Buffer
+		wc := newTestConn(nil, &connBuf, true)
+		rc := newTestConn(&connBuf, nil, false)
+		wc.setupDeflate(deflateParams{})
+		rc.setupDeflate(deflateParams{})
+		_ = wc.SetPayloadCipher(pc)
+		_ = rc.SetPayloadCipher(pc)
+		rc.SetStrictUTF8(true)
+
+		// The payload is compressed before it is encrypted.
+		text := []byte(strings.Repeat("private text ", 100))
+		if err := wc.WriteMessage(TextMessage, text); err != nil {
+			t.Fatal(err)
+		}
+		if n := connBuf.Len(); n >= len(text)/2 || bytes.Contains(connBuf.Bytes(), []byte("private")) {
+			t.Fatalf("%d bytes on the wire for %d bytes of text", n, len(text))
+		}
+		if mt, p, err := rc.ReadMessage(); err != nil || mt != TextMessage || !bytes.Equal(p, text) {
+			t.Fatalf("ReadMessage = %d, %d bytes, %v", mt, len(p), err)
+		}
+
+		// Streaming writers, prepared messages and a key rotation.
+		w, _ := wc.NextWriter(BinaryMessage)
+		_, _ = io.WriteString(w, "part one, ")
+		_, _ = io.WriteString(w, "part two")
+		_ = w.Close()
+		seal.Store(2)
+		pm, _ := NewPreparedMessage(BinaryMessage, []byte("prepared"))
+		_ = wc.WritePreparedMessage(pm)
+		for _, want := range []string{"part one, part two", "prepared"} {
+			if _, p, err := rc.ReadMessage(); err != nil || string(p) != want {
+				t.Fatalf("ReadMessage = %q, %v, want %q", p, err, want)
+			}
+		}
+
+		// Control messages are not encrypted.
+		if err := wc.WriteMessage(PingMessage, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(connBuf.Bytes(), []byte("ping")) {
+			t.Error("ping payload not in clear")
+		}
+	}
+}
+
+func TestPayloadCipherAuthentication(t *testing.T) {
+	var seal atomic.Uint32
+	seal.Store(1)
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, true)
+	rc := newTestConn(&connBuf, nil, false)
+	_ = wc.SetPayloadCipher(testKeys(t, AESGCM, &seal, 1, 3))
+	_ = rc.SetPayloadCipher(testKeys(t, AESGCM, &seal, 1))
+
+	// A modified payload is rejected and the next message is readable.
+	_ = wc.WriteMessage(BinaryMessage, []byte("hello"))
+	connBuf.Bytes()[connBuf.Len()-1] ^= 1
+	if _, _, err := rc.ReadMessage(); err != ErrPayloadAuthentication {
+		t.Fatalf("ReadMessage of a modified message returned %v", err)
+	}
+	_ = wc.WriteMessage(BinaryMessage, []byte("hello"))
+	if _, p, err := rc.ReadMessage(); err != nil || string(p) != "hello" {
+		t.Fatalf("ReadMessage = %q, %v", p, err)
+	}
+
+	// The message type is authenticated.
+	_ = wc.WriteMessage(BinaryMessage, []byte("hello"))
+	connBuf.Bytes()[0] = connBuf.Bytes()[0]&^0xf | TextMessage
+	if _, _, err := rc.ReadMessage(); err != ErrPayloadAuthentication {
+		t.Fatalf("ReadMessage of a retyped message returned %v", err)
+	}
+
+	// A key unknown to the reader fails the read.
+	seal.Store(3)
+	_ = wc.WriteMessage(BinaryMessage, []byte("hello"))
+	if _, _, err := rc.ReadMessage(); err == nil || err.Error() != "unknown key" {
+		t.Fatalf("ReadMessage with an unknown key returned %v", err)
+	}
+
+	if _, err := NewAEADCipher(AEADConfig{}); err == nil {
+		t.Error("NewAEADCipher without keys returned no error")
+	}
+	var nilConn *Conn
+	if err := nilConn.SetPayloadCipher(nil); err != ErrNilConn {
+		t.Errorf("nil Conn returned %v", err)
+	}
+}
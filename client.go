@@ -19,8 +19,11 @@ import (
 	"time"
 )
 
-// ErrBadHandshake is returned when the server response to opening handshake is
-// invalid.
+// ErrBadHandshake is matched by the error returned when the server response
+// to opening handshake is invalid. Use errors.Is to test for it and
+// errors.As to get the HandshakeError describing the response. The error
+// returned is a HandshakeError, not ErrBadHandshake itself: callers
+// comparing with err == ErrBadHandshake must switch to errors.Is.
 var ErrBadHandshake = errors.New("websocket: bad handshake")
 
 var errInvalidCompression = errors.New("websocket: invalid compression negotiation")
@@ -31,9 +34,9 @@ var errInvalidCompression = errors.New("websocket: invalid compression negotiati
 // (Cookie). Use the response.Header to get the selected subprotocol
 // (Sec-WebSocket-Protocol) and cookies (Set-Cookie).
 //
-// If the WebSocket handshake fails, ErrBadHandshake is returned along with a
-// non-nil *http.Response so that callers can handle redirects, authentication,
-// etc.
+// If the WebSocket handshake fails, a HandshakeError matching ErrBadHandshake
+// is returned along with a non-nil *http.Response so that callers can handle
+// redirects, authentication, etc.
 //
 // Deprecated: Use Dialer instead.
 func NewClient(netConn net.Conn, u *url.URL, requestHeader http.Header, readBufSize, writeBufSize int) (c *Conn, response *http.Response, err error) {
@@ -47,6 +50,48 @@ func NewClient(netConn net.Conn, u *url.URL, requestHeader http.Header, readBufS
 	return d.Dial(u.String(), requestHeader)
 }
 
+// Client creates a new client connection over the pre-established network
+// connection netConn, for example a connection from a tunnel or a unix
+// socket. The handshake is performed with the DefaultDialer settings; use
+// Dialer.Client to customize it.
+func Client(netConn net.Conn, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return DefaultDialer.Client(context.Background(), netConn, urlStr, requestHeader)
+}
+
+// Client creates a new client connection by performing the WebSocket
+// handshake over the pre-established network connection netConn. The host
+// in urlStr is used for the Host header and, for wss URLs, the TLS server
+// name; the dial functions and Proxy of the dialer are not used.
+//
+// If the URL scheme is wss and netConn is not a *tls.Conn, the TLS handshake
+// is performed over netConn using TLSClientConfig. If the handshake fails,
+// netConn is closed.
+//
+// Redirects are not followed over netConn, whatever FollowRedirects: a
+// redirect response is returned with an error matching ErrBadHandshake.
+func (d *Dialer) Client(ctx context.Context, netConn net.Conn, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &nilDialer
+	}
+	dd := *d
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return netConn, nil
+	}
+	dd.NetDial = nil
+	dd.NetDialContext = dial
+	dd.NetDialTLSContext = nil
+	if _, ok := netConn.(*tls.Conn); ok {
+		dd.NetDialTLSContext = dial
+	}
+	dd.Proxy = nil
+	dd.HTTP2Transport = nil
+	dd.UnixSocket = ""
+	// The location of a redirect cannot be dialed: the handshake closes
+	// netConn when the response is not a switch of protocols.
+	dd.FollowRedirects = false
+	return dd.DialContext(ctx, urlStr, requestHeader)
+}
+
 // A Dialer contains options for connecting to WebSocket server.
 //
 // It is safe to call Dialer's methods concurrently.
@@ -65,18 +110,78 @@ type Dialer struct {
 	// TLSClientConfig is ignored.
 	NetDialTLSContext func(ctx context.Context, network, addr string) (net.Conn, error)
 
+	// UnixSocket, if not empty, specifies the path of a unix domain socket
+	// to connect to instead of the host in the URL. The URL host is still
+	// used for the Host header and, for wss URLs, the TLS server name. The
+	// dial functions receive the network "unix" and the socket path. Proxy
+	// is not used.
+	UnixSocket string
+
 	// Proxy specifies a function to return a proxy for a given
 	// Request. If the function returns a non-nil error, the
 	// request is aborted with the provided error.
 	// If Proxy is nil or returns a nil *URL, no proxy is used.
+	//
+	// Proxies with the http and https schemes are used with the CONNECT
+	// method. The socks5 and socks5h schemes are also supported. Use
+	// http.ProxyFromEnvironment to honor the HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY environment variables.
 	Proxy func(*http.Request) (*url.URL, error)
 
+	// ProxyConnectHeader specifies headers to send to HTTP and HTTPS proxies
+	// with the CONNECT request.
+	ProxyConnectHeader http.Header
+
+	// ProxyTLSClientConfig specifies the TLS configuration to use when
+	// connecting to an HTTPS proxy. If nil, the default configuration is used.
+	ProxyTLSClientConfig *tls.Config
+
+	// Resolver specifies the resolver used to look up host names when the
+	// default dial function is used. If nil, net.DefaultResolver is used.
+	Resolver *net.Resolver
+
+	// DialTimeout specifies the maximum amount of time for each attempt to
+	// establish a network connection, including connections to proxies. The
+	// overall dial is also bounded by HandshakeTimeout and the context passed
+	// to DialContext. If zero, there is no per-attempt timeout.
+	DialTimeout time.Duration
+
+	// HTTP2Transport, if not nil, is used to bootstrap connections over
+	// HTTP/2 with the extended CONNECT method (RFC 8441). DialContext sends
+	// the handshake with HTTP2Transport first and falls back to the HTTP/1.1
+	// upgrade when the transport does not use HTTP/2 for the URL or the
+	// server does not support extended CONNECT. The transport is responsible
+	// for dialing, TLS and proxies on the HTTP/2 path.
+	//
+	// The transport must pass the :protocol pseudo-header in the request
+	// header to the server. The *http2.Transport type in the
+	// golang.org/x/net/http2 package does; the net/http Transport rejects the
+	// request and the dialer falls back to HTTP/1.1.
+	HTTP2Transport http.RoundTripper
+
 	// TLSClientConfig specifies the TLS configuration to use with tls.Client.
 	// If nil, the default configuration is used.
 	// If either NetDialTLS or NetDialTLSContext are set, Dial assumes the TLS handshake
 	// is done there and TLSClientConfig is ignored.
 	TLSClientConfig *tls.Config
 
+	// TLSServerName, if not empty, overrides the server name used for SNI
+	// and certificate verification, including the ServerName field of
+	// TLSClientConfig. By default the host from the URL is used.
+	TLSServerName string
+
+	// GetClientCertificate, if not nil, is called during the TLS handshake
+	// when the server requests a client certificate. Use it to present
+	// certificates that are rotated without changing the dialer. See
+	// tls.Config.GetClientCertificate.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	// VerifyConnection, if not nil, is called after normal certificate
+	// verification of the TLS handshake. If it returns an error, the dial
+	// fails with that error. It runs after the VerifyConnection function of
+	// TLSClientConfig, if any. See tls.Config.VerifyConnection.
+	VerifyConnection func(tls.ConnectionState) error
+
 	// HandshakeTimeout specifies the duration for the handshake to complete.
 	HandshakeTimeout time.Duration
 
@@ -96,19 +201,108 @@ type Dialer struct {
 	// WriteBufferSize.
 	WriteBufferPool BufferPool
 
+	// ReadBufferPool is a pool of buffers for read operations. If the value
+	// is set, then the connection holds a read buffer only while a frame is
+	// available to read and returns the buffer to the pool while waiting for
+	// the next frame. If the value is not set, then read buffers are
+	// allocated to the connection for the lifetime of the connection.
+	//
+	// A pool greatly reduces memory use of a large number of mostly idle
+	// connections. Applications should use a single pool for each unique
+	// value of ReadBufferSize.
+	ReadBufferPool BufferPool
+
 	// Subprotocols specifies the client's requested subprotocols.
 	Subprotocols []string
 
 	// EnableCompression specifies if the client should attempt to negotiate
 	// per message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
 
+	// CompressionLevel specifies the initial flate compression level for
+	// connections where compression is negotiated. If zero, a fast default
+	// level is used. See Conn.SetCompressionLevel.
+	CompressionLevel int
+
+	// CompressionThreshold specifies the minimum payload size in bytes for a
+	// message to be compressed. See Conn.SetCompressionThreshold.
+	CompressionThreshold int
+
+	// CompressionDictionary, if not nil, is the preset dictionary offered
+	// with compression, see CompressionDictionary. Compression without the
+	// dictionary is offered too, for servers that do not accept it.
+	CompressionDictionary *CompressionDictionary
+
+	// Extensions specifies the extensions offered to the server in addition
+	// to permessage-deflate, see Extension.
+	Extensions []Extension
+
+	// StrictUTF8 specifies whether the connections validate that text
+	// messages are valid UTF-8. See Conn.SetStrictUTF8.
+	StrictUTF8 bool
+
+	// ReadLimits specifies the limits on the frames and messages read by the
+	// connections. See Conn.SetReadLimits.
+	ReadLimits ReadLimits
+
+	// TCP specifies the socket options of the TCP connections. The zero
+	// value keeps the defaults of the net package. The options are best
+	// effort: errors setting them are ignored. See Conn.SetTCPOptions.
+	TCP TCPOptions
+
+	// ServerContextTakeover specifies whether the client permits the server
+	// to retain the compression context across the messages it writes. If
+	// false, the client offers server_no_context_takeover.
+	ServerContextTakeover bool
+
+	// ClientContextTakeover specifies whether the client should retain the
+	// compression context across the messages it writes when the server
+	// allows it. If false, the client offers client_no_context_takeover.
+	ClientContextTakeover bool
+
+	// Metrics, if not nil, receives events about the connections created by
+	// the dialer and about failed handshakes.
+	Metrics Metrics
+
+	// Logger, if not nil, receives log records about failed handshakes and
+	// about the connections created by the dialer.
+	Logger Logger
+
+	// FollowRedirects specifies whether the dialer follows redirect
+	// responses (301, 302, 303, 307 and 308) to the handshake by dialing
+	// the URL in the Location header. HTTP and HTTPS locations are dialed
+	// with the ws and wss schemes. The Authorization and Cookie headers
+	// passed to Dial are not sent to a different host. HandshakeTimeout
+	// applies to the whole chain of handshakes. Redirects are not followed
+	// by Dialer.Client, which has a single network connection.
+	FollowRedirects bool
+
+	// MaxRedirects specifies the maximum number of redirects followed when
+	// FollowRedirects is set. If zero, a default of 10 is used.
+	MaxRedirects int
+
+	// CheckRedirect, if not nil, is called before following a redirect with
+	// the request of the next handshake and the requests already made,
+	// oldest first. CheckRedirect may modify the header of req. If
+	// CheckRedirect returns an error, the dial fails with the error, or with
+	// the HandshakeError of the redirect response if the error is
+	// http.ErrUseLastResponse.
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+
 	// Jar specifies the cookie jar.
 	// If Jar is nil, cookies are not sent in requests and ignored
-	// in responses.
+	// in responses. The cookies set by the handshake response are stored
+	// in the jar, including when the server rejects the handshake.
 	Jar http.CookieJar
+
+	// RequestHeader, if not nil, returns headers added to the handshake
+	// request of each dial, such as an Authorization header with a freshly
+	// refreshed token. The headers replace the headers of the same name
+	// passed to Dial and the same restrictions apply. If RequestHeader
+	// returns an error, the dial fails with the error. RequestHeader is
+	// called for each redial of a ReconnectingConn.
+	RequestHeader func(ctx context.Context, u *url.URL) (http.Header, error)
 }
 
 // Dial creates a new client connection by calling DialContext with a background context.
@@ -189,6 +383,24 @@ func (d *Dialer) createHandshakeRequest(ctx context.Context, u *url.URL, challen
 		}
 	}
 
+	// Merge the dynamic headers of the dialer
+	if d.RequestHeader != nil {
+		h, err := d.RequestHeader(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		if len(h) > 0 {
+			merged := requestHeader.Clone()
+			if merged == nil {
+				merged = make(http.Header, len(h))
+			}
+			for k, vs := range h {
+				merged[http.CanonicalHeaderKey(k)] = vs
+			}
+			requestHeader = merged
+		}
+	}
+
 	// Set the request headers using the capitalization for names and values in
 	// RFC examples. Although the capitalization shouldn't matter, there are
 	// servers that depend on it. The Header.Set method is not used because the
@@ -220,13 +432,35 @@ func (d *Dialer) createHandshakeRequest(ctx context.Context, u *url.URL, challen
 		}
 	}
 
+	exts := d.Extensions
 	if d.EnableCompression {
-		req.Header["Sec-WebSocket-Extensions"] = []string{"permessage-deflate; server_no_context_takeover; client_no_context_takeover"}
+		exts = d.extensions()
+	}
+	if len(exts) > 0 {
+		req.Header["Sec-WebSocket-Extensions"] = []string{offerExtensions(exts)}
 	}
 
 	return req, nil
 }
 
+// deflateOffer returns the permessage-deflate parameters offered by the
+// client.
+func (d *Dialer) deflateOffer() deflateParams {
+	return deflateParams{
+		serverNoContextTakeover: !d.ServerContextTakeover,
+		clientNoContextTakeover: !d.ClientContextTakeover,
+		dict:                    d.CompressionDictionary,
+	}
+}
+
+// extensions returns the extensions of the client, starting with
+// permessage-deflate.
+func (d *Dialer) extensions() []Extension {
+	exts := make([]Extension, 0, 1+len(d.Extensions))
+	exts = append(exts, &deflateExtension{offer: d.deflateOffer()})
+	return append(exts, d.Extensions...)
+}
+
 // setupNetDial configures the network dialer function based on dialer settings.
 func (d *Dialer) setupNetDial(ctx context.Context, u *url.URL, req *http.Request) (netDialerFunc, error) {
 	var netDial netDialerFunc
@@ -240,7 +474,17 @@ func (d *Dialer) setupNetDial(ctx context.Context, u *url.URL, req *http.Request
 			return d.NetDial(net, addr)
 		}
 	default:
-		netDial = (&net.Dialer{}).DialContext
+		netDial = (&net.Dialer{Timeout: d.DialTimeout, Resolver: d.Resolver}).DialContext
+	}
+
+	// If needed, wrap a custom dial function to bound each attempt.
+	if d.DialTimeout > 0 && (d.NetDialContext != nil || d.NetDial != nil || d.NetDialTLSContext != nil) {
+		forwardDial := netDial
+		netDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, d.DialTimeout)
+			defer cancel()
+			return forwardDial(ctx, network, addr)
+		}
 	}
 
 	// If needed, wrap the dial function to set the connection deadline.
@@ -261,13 +505,13 @@ func (d *Dialer) setupNetDial(ctx context.Context, u *url.URL, req *http.Request
 	}
 
 	// If needed, wrap the dial function to connect through a proxy.
-	if d.Proxy != nil {
+	if d.Proxy != nil && d.UnixSocket == "" {
 		proxyURL, err := d.Proxy(req)
 		if err != nil {
 			return nil, err
 		}
 		if proxyURL != nil {
-			netDial, err = proxyFromURL(proxyURL, netDial)
+			netDial, err = proxyFromURL(proxyURL, netDial, d.ProxyTLSClientConfig, d.ProxyConnectHeader)
 			if err != nil {
 				return nil, err
 			}
@@ -286,10 +530,7 @@ func (d *Dialer) setupTLSConnection(ctx context.Context, u *url.URL, netConn net
 	}
 
 	// Upgrade to TLS
-	cfg := cloneTLSConfig(d.TLSClientConfig)
-	if cfg.ServerName == "" {
-		cfg.ServerName = hostNoPort
-	}
+	cfg := d.clientTLSConfig(hostNoPort)
 	tlsConn := tls.Client(netConn, cfg)
 
 	if trace != nil && trace.TLSHandshakeStart != nil {
@@ -352,33 +593,47 @@ func (d *Dialer) performHandshake(conn *Conn, req *http.Request, challengeKey st
 		// Before closing the network connection on return from this
 		// function, slurp up some of the response to aid application
 		// debugging.
-		buf := make([]byte, 1024)
-		n, _ := io.ReadFull(resp.Body, buf)
-		resp.Body = io.NopCloser(bytes.NewReader(buf[:n]))
-		return resp, ErrBadHandshake
+		return resp, newHandshakeError(resp)
 	}
 
-	// Setup compression if negotiated
-	for _, ext := range parseExtensions(resp.Header) {
-		if ext[""] != "permessage-deflate" {
-			continue
-		}
-		_, snct := ext["server_no_context_takeover"]
-		_, cnct := ext["client_no_context_takeover"]
-		if !snct || !cnct {
-			return resp, errInvalidCompression
-		}
-		conn.newCompressionWriter = compressNoContextTakeover
-		conn.newDecompressionReader = decompressNoContextTakeover
-		break
+	if err := d.acceptResponse(conn, resp); err != nil {
+		return resp, err
 	}
-
 	resp.Body = io.NopCloser(bytes.NewReader([]byte{}))
-	conn.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
-
 	return resp, nil
 }
 
+// newHandshakeError slurps up some of the body of a rejected handshake response
+// to aid application debugging, replacing the body of resp, and returns the
+// HandshakeError describing the response.
+func newHandshakeError(resp *http.Response) error {
+	buf := make([]byte, 1024)
+	n, _ := io.ReadFull(resp.Body, buf)
+	resp.Body = io.NopCloser(bytes.NewReader(buf[:n]))
+	return HandshakeError{
+		message:    ErrBadHandshake.Error(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       buf[:n],
+	}
+}
+
+// acceptResponse applies the extensions and subprotocol negotiated in a
+// successful handshake response to the connection.
+func (d *Dialer) acceptResponse(conn *Conn, resp *http.Response) error {
+	exts, err := configureExtensions(resp.Header, d.extensions())
+	if err != nil {
+		return err
+	}
+	conn.setupExtensions(exts)
+	if conn.newCompressionWriter != nil {
+		conn.setCompressionOptions(d.CompressionLevel, d.CompressionThreshold)
+	}
+
+	conn.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
+	return nil
+}
+
 // DialContext creates a new client connection. Use requestHeader to specify the
 // origin (Origin), subprotocols (Sec-WebSocket-Protocol) and cookies (Cookie).
 // Use the response.Header to get the selected subprotocol
@@ -386,9 +641,9 @@ func (d *Dialer) performHandshake(conn *Conn, req *http.Request, challengeKey st
 //
 // The context will be used in the request and in the Dialer.
 //
-// If the WebSocket handshake fails, ErrBadHandshake is returned along with a
-// non-nil *http.Response so that callers can handle redirects, authentication,
-// etcetera. The response body may not contain the entire response and does not
+// If the WebSocket handshake fails, a HandshakeError matching ErrBadHandshake
+// is returned along with a non-nil *http.Response so that callers can handle
+// redirects, authentication, etcetera. The response body may not contain the entire response and does not
 // need to be closed by the application.
 func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
 	if d == nil {
@@ -402,6 +657,14 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 		defer cancel()
 	}
 
+	if d.FollowRedirects {
+		return d.dialRedirects(ctx, urlStr, requestHeader)
+	}
+	return d.dial(ctx, urlStr, requestHeader)
+}
+
+// dial performs a single handshake with the server at urlStr.
+func (d *Dialer) dial(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
 	// Generate challenge key for the handshake
 	challengeKey, err := generateChallengeKey()
 	if err != nil {
@@ -414,6 +677,13 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 		return nil, nil, err
 	}
 
+	if d.HTTP2Transport != nil && d.UnixSocket == "" {
+		conn, resp, err := d.dialHTTP2(ctx, u, requestHeader)
+		if err != errHTTP2Unavailable {
+			return conn, resp, err
+		}
+	}
+
 	// Create the handshake request
 	req, err := d.createHandshakeRequest(ctx, u, challengeKey, requestHeader)
 	if err != nil {
@@ -436,7 +706,11 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 	}
 
 	// Establish the network connection
-	netConn, err := netDial(ctx, "tcp", hostPort)
+	network, addr := "tcp", hostPort
+	if d.UnixSocket != "" {
+		network, addr = "unix", d.UnixSocket
+	}
+	netConn, err := netDial(ctx, network, addr)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -464,10 +738,24 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 
 	// Create the WebSocket connection
 	conn := newConn(netConn, false, d.ReadBufferSize, d.WriteBufferSize, d.WriteBufferPool, nil, nil)
+	if d.ReadBufferPool != nil {
+		conn.setReadPool(d.ReadBufferPool)
+	}
+	conn.strictUTF8 = d.StrictUTF8
+	conn.SetReadLimits(d.ReadLimits)
+	conn.applyTCPOptions(d.TCP)
 
 	// Perform the WebSocket handshake
 	resp, err := d.performHandshake(conn, req, challengeKey, trace)
 	if err != nil {
+		if d.Metrics != nil {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			d.Metrics.HandshakeFailed(status)
+		}
+		d.logDialError(req, resp, err)
 		return nil, resp, err
 	}
 
@@ -480,9 +768,37 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 	// closing the network connection.
 	netConn = nil
 
+	conn.logger = d.Logger
+	conn.setMetrics(d.Metrics)
 	return conn, resp, nil
 }
 
+// clientTLSConfig returns the TLS configuration for a connection to host.
+func (d *Dialer) clientTLSConfig(host string) *tls.Config {
+	cfg := cloneTLSConfig(d.TLSClientConfig)
+	if d.TLSServerName != "" {
+		cfg.ServerName = d.TLSServerName
+	} else if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	if d.GetClientCertificate != nil {
+		cfg.GetClientCertificate = d.GetClientCertificate
+	}
+	if verify := d.VerifyConnection; verify != nil {
+		if prev := cfg.VerifyConnection; prev != nil {
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				if err := prev(cs); err != nil {
+					return err
+				}
+				return verify(cs)
+			}
+		} else {
+			cfg.VerifyConnection = verify
+		}
+	}
+	return cfg
+}
+
 func cloneTLSConfig(cfg *tls.Config) *tls.Config {
 	if cfg == nil {
 		return &tls.Config{}
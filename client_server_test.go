@@ -23,6 +23,7 @@ import (
 	"net/http/httptrace"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -242,6 +243,99 @@ func TestProxyAuthorizationDial(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestProxyConnectHeaderDial(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	surl, _ := url.Parse(s.Server.URL)
+
+	cstDialer := cstDialer // make local copy for modification on next line.
+	cstDialer.Proxy = http.ProxyURL(surl)
+	cstDialer.ProxyConnectHeader = http.Header{"X-Proxy-Token": {"secret"}}
+
+	connect := false
+	origHandler := s.Server.Config.Handler
+
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodConnect && r.Header.Get("X-Proxy-Token") == "secret" {
+				connect = true
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if !connect {
+				t.Log("connect with proxy header not received")
+				http.Error(w, "connect with proxy header not received", http.StatusMethodNotAllowed)
+				return
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	ws, _, err := cstDialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+}
+
+func TestHTTPSProxyDial(t *testing.T) {
+	s := newTLSServer(t)
+	defer s.Close()
+
+	surl, _ := url.Parse(s.Server.URL)
+
+	cstDialer := cstDialer // make local copy for modification on next line.
+	cstDialer.Proxy = http.ProxyURL(surl)
+	cstDialer.ProxyTLSClientConfig = s.Server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	connect := false
+	origHandler := s.Server.Config.Handler
+
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodConnect {
+				connect = true
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if !connect {
+				t.Log("connect not received")
+				http.Error(w, "connect not received", http.StatusMethodNotAllowed)
+				return
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	// The tunnel through the TLS connection to the proxy carries the
+	// plain text handshake.
+	ws, _, err := cstDialer.Dial("ws://"+surl.Host+cstRequestURI, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+}
+
+func TestDialResolver(t *testing.T) {
+	errResolve := errors.New("resolver called")
+	d := Dialer{
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errResolve
+			},
+		},
+		DialTimeout: time.Second,
+	}
+	_, _, err := d.Dial("ws://websocket.example.invalid/", nil)
+	if err == nil || !strings.Contains(err.Error(), errResolve.Error()) {
+		t.Fatalf("Dial returned %v, want error from resolver", err)
+	}
+}
+
 func TestDial(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -302,6 +396,73 @@ func TestDialCookieJar(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestDialRequestHeader(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		auth []string
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth = append(auth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		if r.Header.Get("Authorization") != "Bearer 2" {
+			http.Error(w, "expired token", http.StatusUnauthorized)
+			return
+		}
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	defer s.Close()
+
+	jar, _ := cookiejar.New(nil)
+	token := 0
+	d := cstDialer
+	d.Jar = jar
+	d.RequestHeader = func(ctx context.Context, u *url.URL) (http.Header, error) {
+		token++
+		return http.Header{"authorization": {"Bearer " + strconv.Itoa(token)}}, nil
+	}
+	wsURL := makeWsProto(s.URL)
+	header := http.Header{"Authorization": {"Bearer 0"}}
+
+	// The first dial is rejected but the cookie is stored.
+	_, resp, err := d.Dial(wsURL, header)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Dial returned %v, %v", resp, err)
+	}
+	u, _ := url.Parse(s.URL)
+	if cookies := jar.Cookies(u); len(cookies) != 1 || cookies[0].Value != "abc" {
+		t.Errorf("jar cookies %v", cookies)
+	}
+
+	// The second dial uses a refreshed token.
+	ws, _, err := d.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("Dial with refreshed token: %v", err)
+	}
+	ws.Close()
+	mu.Lock()
+	if len(auth) != 2 || auth[0] != "Bearer 1" || auth[1] != "Bearer 2" {
+		t.Errorf("Authorization headers %q", auth)
+	}
+	mu.Unlock()
+	if header.Get("Authorization") != "Bearer 0" {
+		t.Error("Dial modified the request header")
+	}
+
+	errToken := errors.New("token unavailable")
+	d.RequestHeader = func(ctx context.Context, u *url.URL) (http.Header, error) {
+		return nil, errToken
+	}
+	if _, _, err := d.Dial(wsURL, nil); err != errToken {
+		t.Errorf("Dial returned %v, want %v", err, errToken)
+	}
+}
+
 func rootCAs(t *testing.T, s *httptest.Server) *x509.CertPool {
 	certs := x509.NewCertPool()
 	for _, c := range s.TLS.Certificates {
@@ -330,6 +491,72 @@ func TestDialTLS(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestDialTLSServerName(t *testing.T) {
+	s := newTLSServer(t)
+	defer s.Close()
+
+	// The test certificate is valid for example.com.
+	d := cstDialer
+	d.TLSClientConfig = &tls.Config{RootCAs: rootCAs(t, s.Server), ServerName: "invalid.test"}
+	d.TLSServerName = "example.com"
+	ws, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if name := ws.NetConn().(*tls.Conn).ConnectionState().ServerName; name != "example.com" {
+		t.Errorf("ServerName = %q, want example.com", name)
+	}
+	sendRecv(t, ws)
+
+	d.TLSServerName = "invalid.test"
+	if ws, _, err := d.Dial(s.URL, nil); err == nil {
+		ws.Close()
+		t.Fatal("Dial with a server name not in the certificate succeeded")
+	}
+}
+
+func TestDialTLSClientCertificate(t *testing.T) {
+	var s cstServer
+	s.Server = httptest.NewUnstartedServer(cstHandler{T: t, s: &s})
+	s.Server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	s.Server.StartTLS()
+	s.Server.URL += cstRequestURI
+	s.URL = makeWsProto(s.Server.URL)
+	defer s.Close()
+
+	var requested, verified int
+	d := cstDialer
+	d.TLSClientConfig = &tls.Config{RootCAs: rootCAs(t, s.Server)}
+	d.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		requested++
+		return &tls.Certificate{}, nil
+	}
+	d.VerifyConnection = func(cs tls.ConnectionState) error {
+		verified++
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no peer certificates")
+		}
+		return nil
+	}
+	ws, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+	if requested != 1 || verified != 1 {
+		t.Fatalf("GetClientCertificate called %d times, VerifyConnection called %d times, want 1 and 1", requested, verified)
+	}
+
+	errRejected := errors.New("rejected")
+	d.TLSClientConfig.VerifyConnection = func(tls.ConnectionState) error { return nil }
+	d.VerifyConnection = func(tls.ConnectionState) error { return errRejected }
+	if _, _, err := d.Dial(s.URL, nil); !errors.Is(err, errRejected) {
+		t.Fatalf("Dial returned %v, want %v", err, errRejected)
+	}
+}
+
 func TestDialTimeout(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -811,6 +1038,89 @@ func TestDialCompression(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestDialHandshakeError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, strings.Repeat("slow down ", 200))
+	}))
+	defer s.Close()
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("Dial returned %v, want ErrBadHandshake", err)
+	}
+	var he HandshakeError
+	if !errors.As(err, &he) {
+		t.Fatalf("Dial returned %T, want HandshakeError", err)
+	}
+	if he.StatusCode != http.StatusTooManyRequests || he.Header.Get("Retry-After") != "30" {
+		t.Errorf("HandshakeError status %d, header %v", he.StatusCode, he.Header)
+	}
+	if len(he.Body) != 1024 || !strings.HasPrefix(string(he.Body), "slow down") {
+		t.Errorf("HandshakeError body of %d bytes", len(he.Body))
+	}
+	if want := "websocket: bad handshake (429 Too Many Requests)"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if p, _ := io.ReadAll(resp.Body); !bytes.Equal(p, he.Body) {
+		t.Error("response body differs from the error body")
+	}
+	if errors.Is(HandshakeError{message: "upgrade"}, ErrBadHandshake) {
+		t.Error("server HandshakeError matches ErrBadHandshake")
+	}
+}
+
+func TestDialCompressionContextTakeover(t *testing.T) {
+	upgrader := Upgrader{
+		EnableCompression:     true,
+		ServerContextTakeover: true,
+		ClientContextTakeover: true,
+		CompressionLevel:      9,
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		for {
+			mt, p, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	dialer := Dialer{
+		EnableCompression:     true,
+		ServerContextTakeover: true,
+		ClientContextTakeover: true,
+	}
+	ws, resp, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if got := resp.Header.Get("Sec-Websocket-Extensions"); got != "permessage-deflate" {
+		t.Errorf("Sec-WebSocket-Extensions = %q, want %q", got, "permessage-deflate")
+	}
+	if got := ws.Extensions(); got != "permessage-deflate" {
+		t.Errorf("Extensions() = %q, want %q", got, "permessage-deflate")
+	}
+	if !ws.writeContextTakeover {
+		t.Error("client did not negotiate context takeover")
+	}
+	for i := 0; i < 10; i++ {
+		sendRecv(t, ws)
+	}
+}
+
 func TestSocksProxyDial(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -0,0 +1,156 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrCloseTimeout is returned by CloseWithCode, and by the read methods after
+// CloseWrite, when the peer does not reply to the close message before the
+// timeout.
+var ErrCloseTimeout = errors.New("websocket: timeout waiting for close message")
+
+// setPeerClose records the close message received from the peer.
+func (c *Conn) setPeerClose(code int, text string) {
+	if c.peerClosed == nil {
+		return
+	}
+	c.peerCloseOnce.Do(func() {
+		c.peerCloseErr = &CloseError{Code: code, Text: text}
+		close(c.peerClosed)
+	})
+}
+
+// CloseWithCode performs the closing handshake. CloseWithCode sends a close
+// message with the code and reason, waits up to timeout for the peer's close
+// message and closes the network connection. It returns the peer's close
+// message. If the peer already sent a close message, CloseWithCode replies
+// and returns it without waiting.
+//
+// If another goroutine is blocked reading from the connection, CloseWithCode
+// waits for the reader to receive the peer's close message. The reader gets
+// the *CloseError from the read methods as usual. Otherwise, CloseWithCode
+// reads from the connection itself and discards data messages received
+// before the close message.
+//
+// If the peer does not reply before the timeout, CloseWithCode returns
+// ErrCloseTimeout.
+func (c *Conn) CloseWithCode(code int, reason string, timeout time.Duration) (*CloseError, error) {
+	if c == nil {
+		return nil, ErrNilConn
+	}
+	deadline := time.Now().Add(timeout)
+	err := c.WriteControl(CloseMessage, FormatCloseMessage(code, reason), deadline)
+	if err != nil && err != ErrCloseSent {
+		_ = c.Close()
+		return nil, err
+	}
+	peer, err := c.awaitPeerClose(deadline)
+	_ = c.Close()
+	return peer, err
+}
+
+// awaitPeerClose waits for the peer's close message until the deadline.
+func (c *Conn) awaitPeerClose(deadline time.Time) (*CloseError, error) {
+	select {
+	case <-c.peerClosed:
+		return c.peerCloseErr, nil
+	default:
+	}
+
+	if c.readMu.TryLock() {
+		defer c.readMu.Unlock()
+		if c.conn != nil {
+			_ = c.conn.SetReadDeadline(deadline)
+		}
+		for {
+			_, _, err := c.nextReader()
+			if err != nil {
+				select {
+				case <-c.peerClosed:
+					return c.peerCloseErr, nil
+				default:
+				}
+				var ne interface{ Timeout() bool }
+				if errors.As(err, &ne) && ne.Timeout() {
+					return nil, ErrCloseTimeout
+				}
+				return nil, err
+			}
+			// Read errors are returned by the next call to nextReader.
+			_, _ = io.Copy(io.Discard, c.reader)
+		}
+	}
+
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-c.peerClosed:
+		return c.peerCloseErr, nil
+	case <-t.C:
+		return nil, ErrCloseTimeout
+	}
+}
+
+// CloseWrite sends a close message with the code and reason, after which
+// writes fail with ErrCloseSent, but leaves the read side of the connection
+// open until the peer replies, for protocols where the peer sends final
+// messages such as a goodbye payload before closing. The application keeps
+// reading: the read methods return the messages sent by the peer before its
+// close message, then the peer's *CloseError, and the network connection is
+// closed when the close message is read.
+//
+// If the peer's close message is not read before the timeout, the network
+// connection is closed and the read methods return ErrCloseTimeout once the
+// messages already buffered are read. If the peer already sent a close
+// message, CloseWrite replies and closes the network connection.
+//
+// After a close message is received, whether or not CloseWrite was called,
+// the read methods return the same *CloseError without reading from the
+// network.
+func (c *Conn) CloseWrite(code int, reason string, timeout time.Duration) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	err := c.WriteControl(CloseMessage, FormatCloseMessage(code, reason), time.Now().Add(timeout))
+	if err != nil && err != ErrCloseSent {
+		_ = c.Close()
+		return err
+	}
+	c.halfClosed.Store(true)
+	select {
+	case <-c.peerClosed:
+		return c.Close()
+	default:
+	}
+	time.AfterFunc(timeout, func() {
+		select {
+		case <-c.peerClosed:
+		case <-c.closed:
+		default:
+			c.closeTimedOut.Store(true)
+			_ = c.Close()
+		}
+	})
+	return nil
+}
+
+// halfCloseError returns the error of a read from the network after
+// CloseWrite.
+func (c *Conn) halfCloseError(err error) error {
+	if err == nil || !c.halfClosed.Load() {
+		return err
+	}
+	if _, ok := err.(*CloseError); ok {
+		return err
+	}
+	if c.closeTimedOut.Load() {
+		return ErrCloseTimeout
+	}
+	var ne interface{ Timeout() bool }
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrCloseTimeout
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTCPConns returns connected server and client connections over loopback
+// TCP. Unlike net.Pipe, writes are buffered by the kernel.
+func newTCPConns(t *testing.T) (server, client *Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newConn(sc, true, 1024, 1024, nil, nil, nil), newConn(cc, false, 1024, 1024, nil, nil, nil)
+}
+
+func TestCloseWithCode(t *testing.T) {
+	server, client := newTCPConns(t)
+	peerErr := make(chan error, 1)
+	go func() {
+		// Send a data message that the closing side discards, then reply to
+		// the close message with the default close handler.
+		_ = client.WriteMessage(TextMessage, []byte("late"))
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				peerErr <- err
+				return
+			}
+		}
+	}()
+
+	ce, err := server.CloseWithCode(CloseGoingAway, "bye", time.Second)
+	if err != nil {
+		t.Fatalf("CloseWithCode returned error %v", err)
+	}
+	if ce == nil || ce.Code != CloseGoingAway {
+		t.Fatalf("CloseWithCode returned %v, want echoed code %d", ce, CloseGoingAway)
+	}
+	var got *CloseError
+	if err := <-peerErr; !errors.As(err, &got) || got.Code != CloseGoingAway || got.Text != "bye" {
+		t.Fatalf("peer read returned %v", err)
+	}
+}
+
+func TestCloseWithCodeConcurrentReader(t *testing.T) {
+	server, client := newPipeConns()
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := server.ReadMessage(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+	// Let the reader block in ReadMessage.
+	time.Sleep(10 * time.Millisecond)
+
+	ce, err := server.CloseWithCode(CloseNormalClosure, "", time.Second)
+	if err != nil || ce == nil || ce.Code != CloseNormalClosure {
+		t.Fatalf("CloseWithCode returned %v, %v", ce, err)
+	}
+	if err := <-readErr; !IsCloseError(err, CloseNormalClosure) {
+		t.Fatalf("reader returned %v, want close error", err)
+	}
+}
+
+func TestCloseWithCodeTimeout(t *testing.T) {
+	server, client := newPipeConns()
+	client.SetCloseHandler(func(int, string) error { return nil })
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	if _, err := server.CloseWithCode(CloseNormalClosure, "", 50*time.Millisecond); err != ErrCloseTimeout {
+		t.Fatalf("CloseWithCode returned %v, want %v", err, ErrCloseTimeout)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("CloseWithCode took %v", d)
+	}
+}
+
+func TestCloseWrite(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	client.SetCloseHandler(func(code int, text string) error {
+		// Reply to the close message with final messages, then close.
+		_ = client.WriteMessage(TextMessage, []byte("goodbye"))
+		return client.WriteControl(CloseMessage, FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := server.CloseWrite(CloseGoingAway, "shutdown", time.Second); err != nil {
+		t.Fatalf("CloseWrite returned %v", err)
+	}
+	if err := server.WriteMessage(TextMessage, []byte("late")); err != ErrCloseSent {
+		t.Fatalf("WriteMessage after CloseWrite returned %v, want %v", err, ErrCloseSent)
+	}
+	if got := readString(t, server); got != "goodbye" {
+		t.Fatalf("read %q, want the final message goodbye", got)
+	}
+	for range 2 {
+		// Reads after the close message return the same error.
+		if _, _, err := server.ReadMessage(); !IsCloseError(err, CloseGoingAway) {
+			t.Fatalf("ReadMessage returned %v, want the peer's close error", err)
+		}
+	}
+	select {
+	case <-server.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after the closing handshake")
+	}
+}
+
+func TestCloseWriteTimeout(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	client.SetCloseHandler(func(int, string) error { return nil })
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	if err := server.CloseWrite(CloseNormalClosure, "", 50*time.Millisecond); err != nil {
+		t.Fatalf("CloseWrite returned %v", err)
+	}
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := server.ReadMessage(); err != ErrCloseTimeout {
+		t.Fatalf("ReadMessage returned %v, want %v", err, ErrCloseTimeout)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("ReadMessage took %v to time out", d)
+	}
+}
+
+func TestWriteControlCloseFailed(t *testing.T) {
+	c := newTestConn(nil, errorWriter{}, true)
+	msg := FormatCloseMessage(CloseGoingAway, "bye")
+	if err := c.WriteControl(CloseMessage, msg, time.Now().Add(time.Second)); err == nil {
+		t.Fatal("WriteControl to a failing writer succeeded")
+	}
+	// The code of a close message that was not sent is not recorded.
+	if code := c.closeCode.Load(); code != 0 || c.closeReason.Load() != nil {
+		t.Fatalf("close code %d recorded for a failed write", code)
+	}
+
+	var buf bytes.Buffer
+	c = newTestConn(nil, &buf, true)
+	if err := c.WriteControl(CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if code, p := c.closeCode.Load(), c.closeReason.Load(); code != CloseGoingAway || p == nil || *p != "bye" {
+		t.Fatalf("close code %d recorded for a close message with code %d", code, CloseGoingAway)
+	}
+}
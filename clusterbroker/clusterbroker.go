@@ -0,0 +1,486 @@
+// Package clusterbroker relays websocket hub broadcasts and presence between
+// processes over direct WebSocket links between the nodes, without an
+// external message server.
+//
+// Every node serves the broker's Handler on an internal address and lists
+// the cluster endpoints of the nodes, statically or from DNS:
+//
+//	b := &clusterbroker.Broker{
+//		Self:   "ws://10.0.0.1:7946/cluster",
+//		Peers:  clusterbroker.DNSPeers("chat.internal", 7946, "/cluster"),
+//		Secret: os.Getenv("CLUSTER_SECRET"),
+//	}
+//	go http.ListenAndServe(":7946", b.Handler())
+//	hub := &websocket.Hub{Broker: b}
+//
+// The nodes form a full mesh: each node links to every other node and sends
+// its broadcasts on every link. A node delivers the messages received from a
+// link to its hubs and never relays them, so a message crosses at most one
+// link and cannot loop; when two nodes link to each other at once, the
+// duplicate link is closed. Links are checked with pings and replaced when a
+// node stops replying. Broadcasts published while a node is unreachable are
+// lost for that node.
+package clusterbroker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// Defaults of the Broker fields.
+const (
+	DefaultDiscoveryInterval = 10 * time.Second
+	DefaultHealthInterval    = 5 * time.Second
+	DefaultHealthTimeout     = 15 * time.Second
+	DefaultQueueSize         = 1024
+)
+
+// Headers of the link handshake.
+const (
+	nodeHeader   = "Cluster-Node"
+	secretHeader = "Cluster-Secret"
+)
+
+// ErrClosed is returned by the broker after Close.
+var ErrClosed = errors.New("clusterbroker: broker closed")
+
+// PeerSource returns the URLs of the cluster endpoints of the nodes. The
+// list may include the node itself.
+type PeerSource func(ctx context.Context) ([]string, error)
+
+// StaticPeers returns a PeerSource returning urls.
+func StaticPeers(urls ...string) PeerSource {
+	return func(context.Context) ([]string, error) { return urls, nil }
+}
+
+// DNSPeers returns a PeerSource resolving the addresses of host, such as
+// the headless service of the nodes, to ws:// URLs with the port and path.
+func DNSPeers(host string, port int, path string) PeerSource {
+	return func(ctx context.Context) ([]string, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		urls := make([]string, len(addrs))
+		for i, a := range addrs {
+			urls[i] = "ws://" + net.JoinHostPort(a, strconv.Itoa(port)) + path
+		}
+		sort.Strings(urls)
+		return urls, nil
+	}
+}
+
+// Peer describes a node linked to the broker.
+type Peer struct {
+	// Node is the random ID of the broker of the node.
+	Node string
+
+	// URL is the cluster endpoint of the node, empty when the node linked
+	// to this node.
+	URL string
+
+	// Latency is the round trip time of the last health check.
+	Latency time.Duration
+}
+
+// Broker is a websocket.Broker relaying the broadcasts over WebSocket links
+// between the nodes of a cluster. The broker starts linking to the nodes
+// when it is first used by a hub.
+type Broker struct {
+	// Self is the URL of the cluster endpoint of this node, excluded from
+	// the URLs returned by Peers. Nodes that resolve to themselves are
+	// detected when linking, so Self is optional.
+	Self string
+
+	// Peers discovers the cluster endpoints of the nodes. If nil, the
+	// node is only linked to by other nodes.
+	Peers PeerSource
+
+	// Secret, if not empty, must be shared by the nodes. The links of
+	// nodes presenting a different secret are rejected. Serve the Handler
+	// on an internal network or over TLS: the secret is sent in the
+	// handshake.
+	Secret string
+
+	// Dialer links to the nodes. If nil, websocket.DefaultDialer is used.
+	Dialer *websocket.Dialer
+
+	// DiscoveryInterval is the interval between the lookups of Peers. If
+	// zero, a default of 10 seconds is used.
+	DiscoveryInterval time.Duration
+
+	// HealthInterval and HealthTimeout are the interval of the pings sent
+	// on the links and the time without a pong after which a link is
+	// closed. If zero, defaults of 5 and 15 seconds are used.
+	HealthInterval, HealthTimeout time.Duration
+
+	// QueueSize is the number of messages queued for each link. A link
+	// whose queue is full is closed and linked again. If zero, a default
+	// of 1024 is used.
+	QueueSize int
+
+	// OnPeerUp and OnPeerDown, if not nil, are called when a link to a
+	// node is established and closed.
+	OnPeerUp, OnPeerDown func(p Peer)
+
+	// OnError, if not nil, is called with the errors of the discovery and
+	// of the links to the nodes.
+	OnError func(err error)
+
+	startOnce sync.Once
+	id        string
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mu      sync.Mutex
+	closed  bool
+	links   map[string]*link // by node ID
+	dialing map[string]bool  // URLs being linked
+	self    map[string]bool  // URLs found to be this node
+	urlNode map[string]string
+	subs    map[*subscriber]struct{}
+}
+
+var _ websocket.Broker = (*Broker)(nil)
+
+type link struct {
+	peer     Peer
+	conn     *websocket.Conn
+	replaced bool // replaced by a preferred link to the node, guarded by mu
+}
+
+// preferred reports whether l is preferred to other, a link to the same
+// node. When two nodes link to each other at once, both keep the link
+// dialed by the node with the smaller ID.
+func (b *Broker) preferred(l, other *link) bool {
+	return l.dialer(b.id) < other.dialer(b.id)
+}
+
+// dialer returns the ID of the node that dialed the link.
+func (l *link) dialer(self string) string {
+	if l.peer.URL != "" {
+		return self
+	}
+	return l.peer.Node
+}
+
+type subscriber struct {
+	f func(m *websocket.BrokerMessage)
+}
+
+// start initializes the broker and starts the discovery once.
+func (b *Broker) start() {
+	b.startOnce.Do(func() {
+		var id [8]byte
+		_, _ = rand.Read(id[:])
+		b.id = hex.EncodeToString(id[:])
+		b.ctx, b.cancel = context.WithCancel(context.Background())
+		b.mu.Lock()
+		b.links = make(map[string]*link)
+		b.dialing = make(map[string]bool)
+		b.self = make(map[string]bool)
+		b.urlNode = make(map[string]string)
+		b.subs = make(map[*subscriber]struct{})
+		if b.Self != "" {
+			b.self[b.Self] = true
+		}
+		b.mu.Unlock()
+		if b.Peers != nil {
+			go b.discover()
+		}
+	})
+}
+
+// Publish delivers m to the subscribers of this node and sends it to the
+// linked nodes.
+func (b *Broker) Publish(ctx context.Context, m *websocket.BrokerMessage) error {
+	b.start()
+	p, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	subs := make([]*subscriber, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	links := make([]*link, 0, len(b.links))
+	for _, l := range b.links {
+		links = append(links, l)
+	}
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.f(m)
+	}
+	for _, l := range links {
+		// A failed link is closed by the queue and linked again.
+		_ = l.conn.WriteMessage(websocket.BinaryMessage, p)
+	}
+	return nil
+}
+
+// Subscribe calls f for the messages published on this node and received
+// from the linked nodes until ctx is done.
+func (b *Broker) Subscribe(ctx context.Context, f func(m *websocket.BrokerMessage)) error {
+	b.start()
+	s := &subscriber{f: f}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-b.ctx.Done():
+	}
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+	if ctx.Err() == nil {
+		return ErrClosed
+	}
+	return nil
+}
+
+// PeerList returns the linked nodes, sorted by node ID.
+func (b *Broker) PeerList() []Peer {
+	b.start()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	peers := make([]Peer, 0, len(b.links))
+	for _, l := range b.links {
+		p := l.peer
+		p.Latency = l.conn.Latency()
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Node < peers[j].Node })
+	return peers
+}
+
+// Close closes the links and stops the discovery.
+func (b *Broker) Close() error {
+	b.start()
+	b.mu.Lock()
+	b.closed = true
+	links := b.links
+	b.links = make(map[string]*link)
+	b.mu.Unlock()
+	b.cancel()
+	for _, l := range links {
+		_ = l.conn.Close()
+	}
+	return nil
+}
+
+// Handler returns the handler of the cluster endpoint of the node, which
+// accepts the links of the other nodes.
+func (b *Broker) Handler() http.Handler {
+	u := &websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.start()
+		if !b.authorized(r.Header) {
+			http.Error(w, "clusterbroker: invalid secret", http.StatusForbidden)
+			return
+		}
+		node := r.Header.Get(nodeHeader)
+		switch {
+		case node == "":
+			http.Error(w, "clusterbroker: missing node", http.StatusBadRequest)
+			return
+		case node == b.id:
+			// The node dialed itself.
+			http.Error(w, "clusterbroker: self link", http.StatusConflict)
+			return
+		}
+		c, err := u.Upgrade(w, r, http.Header{nodeHeader: {b.id}})
+		if err != nil {
+			return
+		}
+		b.serve(Peer{Node: node}, c)
+	})
+}
+
+func (b *Broker) authorized(h http.Header) bool {
+	if b.Secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(h.Get(secretHeader)), []byte(b.Secret)) == 1
+}
+
+func (b *Broker) reportError(err error) {
+	if b.OnError != nil {
+		b.OnError(err)
+	}
+}
+
+// discover links to the nodes returned by Peers until the broker is closed.
+func (b *Broker) discover() {
+	interval := b.DiscoveryInterval
+	if interval <= 0 {
+		interval = DefaultDiscoveryInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		urls, err := b.Peers(b.ctx)
+		if err != nil && b.ctx.Err() == nil {
+			b.reportError(err)
+		}
+		for _, url := range urls {
+			b.connect(url)
+		}
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// connect links to the node at url unless it is this node, is linked or is
+// being linked.
+func (b *Broker) connect(url string) {
+	b.mu.Lock()
+	if b.closed || b.self[url] || b.dialing[url] {
+		b.mu.Unlock()
+		return
+	}
+	if node, ok := b.urlNode[url]; ok && b.links[node] != nil {
+		b.mu.Unlock()
+		return
+	}
+	b.dialing[url] = true
+	b.mu.Unlock()
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.dialing, url)
+			b.mu.Unlock()
+		}()
+		d := b.Dialer
+		if d == nil {
+			d = websocket.DefaultDialer
+		}
+		h := http.Header{nodeHeader: {b.id}}
+		if b.Secret != "" {
+			h.Set(secretHeader, b.Secret)
+		}
+		c, resp, err := d.DialContext(b.ctx, url, h)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusConflict {
+				b.mu.Lock()
+				b.self[url] = true
+				b.mu.Unlock()
+				return
+			}
+			if b.ctx.Err() == nil {
+				b.reportError(err)
+			}
+			return
+		}
+		node := resp.Header.Get(nodeHeader)
+		if node == "" {
+			_ = c.Close()
+			b.reportError(errors.New("clusterbroker: " + url + " is not a cluster endpoint"))
+			return
+		}
+		b.serve(Peer{Node: node, URL: url}, c)
+	}()
+}
+
+// serve registers the link and delivers the messages received from it until
+// it fails.
+func (b *Broker) serve(p Peer, c *websocket.Conn) {
+	defer c.Close()
+	size := b.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	_ = c.EnableWriteQueue(size, websocket.OverflowClose)
+	interval, timeout := b.HealthInterval, b.HealthTimeout
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	_ = c.EnableKeepalive(interval, timeout)
+
+	l := &link{peer: p, conn: c}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	if p.URL != "" {
+		b.urlNode[p.URL] = p.Node
+	}
+	old := b.links[p.Node]
+	if old != nil && !b.preferred(l, old) {
+		b.mu.Unlock()
+		return
+	}
+	if old != nil {
+		old.replaced = true
+	}
+	b.links[p.Node] = l
+	b.mu.Unlock()
+	if old != nil {
+		_ = old.conn.Close()
+	} else if b.OnPeerUp != nil {
+		b.OnPeerUp(p)
+	}
+	defer func() {
+		b.mu.Lock()
+		if b.links[p.Node] == l {
+			delete(b.links, p.Node)
+		}
+		replaced := l.replaced
+		b.mu.Unlock()
+		if !replaced && b.OnPeerDown != nil {
+			b.OnPeerDown(p)
+		}
+	}()
+
+	for {
+		mt, data, err := c.ReadMessage()
+		if err != nil {
+			if b.ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				b.reportError(err)
+			}
+			return
+		}
+		var m websocket.BrokerMessage
+		if mt != websocket.BinaryMessage || m.UnmarshalBinary(data) != nil {
+			continue
+		}
+		b.mu.Lock()
+		subs := make([]*subscriber, 0, len(b.subs))
+		for s := range b.subs {
+			subs = append(subs, s)
+		}
+		b.mu.Unlock()
+		for _, s := range subs {
+			s.f(&m)
+		}
+	}
+}
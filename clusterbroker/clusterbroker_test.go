@@ -0,0 +1,152 @@
+package clusterbroker
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+	"github.com/gflydev/websocket/brokertest"
+	"github.com/gflydev/websocket/wstest"
+)
+
+// cluster starts n nodes discovering each other from a static list that
+// includes the nodes themselves.
+func cluster(t *testing.T, n int, secret func(i int) string) []*Broker {
+	t.Helper()
+	var urls []string
+	var mu sync.Mutex
+	peers := func(context.Context) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return urls, nil
+	}
+	brokers := make([]*Broker, n)
+	for i := range brokers {
+		b := &Broker{
+			Peers:             peers,
+			Secret:            secret(i),
+			DiscoveryInterval: 20 * time.Millisecond,
+			HealthInterval:    50 * time.Millisecond,
+			HealthTimeout:     time.Second,
+		}
+		s := httptest.NewServer(b.Handler())
+		t.Cleanup(func() {
+			_ = b.Close()
+			s.Close()
+		})
+		mu.Lock()
+		urls = append(urls, "ws"+strings.TrimPrefix(s.URL, "http"))
+		mu.Unlock()
+		brokers[i] = b
+	}
+	return brokers
+}
+
+func waitPeers(t *testing.T, b *Broker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.PeerList()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d peers, want %d", len(b.PeerList()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	brokers := cluster(t, 3, func(int) string { return "s3cret" })
+	hubs := make([]*websocket.Hub, len(brokers))
+	members := make([]*websocket.Conn, len(brokers))
+	for i, b := range brokers {
+		hubs[i] = &websocket.Hub{Broker: b}
+		t.Cleanup(func() { _ = hubs[i].Close() })
+		client, server := wstest.NewPipe()
+		if err := hubs[i].Join("room", server); err != nil {
+			t.Fatal(err)
+		}
+		members[i] = client
+	}
+	for _, b := range brokers {
+		waitPeers(t, b, 2)
+	}
+	// Let the duplicate links of nodes that linked to each other at once
+	// close.
+	time.Sleep(100 * time.Millisecond)
+	for _, b := range brokers {
+		waitPeers(t, b, 2)
+	}
+
+	if err := hubs[0].Broadcast("room", websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range members {
+		wstest.ExpectMessage(t, c, websocket.TextMessage, "hello")
+		// The message is delivered once.
+		_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, p, err := c.ReadMessage(); err == nil {
+			t.Errorf("member %d received %q twice", i, p)
+		}
+	}
+}
+
+func TestClusterSecret(t *testing.T) {
+	var rejected sync.Once
+	errs := make(chan error, 1)
+	brokers := cluster(t, 2, func(i int) string { return []string{"a", "b"}[i] })
+	brokers[0].OnError = func(err error) {
+		rejected.Do(func() { errs <- err })
+	}
+	brokers[0].start()
+	brokers[1].start()
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "bad handshake") {
+			t.Errorf("error %v, want bad handshake", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no link error")
+	}
+	if peers := brokers[0].PeerList(); len(peers) != 0 {
+		t.Errorf("linked to %v with a wrong secret", peers)
+	}
+}
+
+func TestBrokerPeerDown(t *testing.T) {
+	brokers := cluster(t, 2, func(int) string { return "" })
+	down := make(chan Peer, 2)
+	brokers[0].OnPeerDown = func(p Peer) { down <- p }
+	brokers[0].start()
+	waitPeers(t, brokers[0], 1)
+	time.Sleep(100 * time.Millisecond)
+	node := brokers[0].PeerList()[0].Node
+	_ = brokers[1].Close()
+	select {
+	case p := <-down:
+		if p.Node != node {
+			t.Errorf("peer %s down, want %s", p.Node, node)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnPeerDown not called")
+	}
+	if err := brokers[1].Publish(context.Background(), &websocket.BrokerMessage{}); err != ErrClosed {
+		t.Errorf("Publish after Close returned %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, brokertest.Suite{
+		NewBrokers: func(t *testing.T, n int) ([]websocket.Broker, func()) {
+			nodes := cluster(t, n, func(int) string { return "s3cret" })
+			brokers := make([]websocket.Broker, n)
+			for i, b := range nodes {
+				waitPeers(t, b, n-1)
+				brokers[i] = b
+			}
+			return brokers, nil
+		},
+	})
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"time"
+)
+
+const defaultCoalesceMaxBytes = 16 << 10
+
+// writeCoalescer holds the frames written within the coalescing window.
+// The fields are guarded by the write lock of the connection.
+type writeCoalescer struct {
+	window   time.Duration
+	maxBytes int
+	buf      []byte
+	deadline time.Time // of the last buffered write
+	timer    *time.Timer
+	armed    bool
+}
+
+// SetWriteCoalescing packs the frames written within window of each other
+// into a single write to the network connection, up to maxBytes. Each
+// message keeps its own frames. The coalesced frames are written when
+// window has elapsed since the first of them, when they reach maxBytes,
+// before a control message and on Close. If maxBytes is zero, a default of
+// 16 KiB is used. A window of zero or less disables coalescing and writes
+// the pending frames.
+//
+// Coalescing trades latency for fewer system calls and packets, for
+// example when a feed writes many small messages per tick. A message
+// returned from a write method without error may still be pending; the
+// error of a later coalesced write is returned by the next write.
+func (c *Conn) SetWriteCoalescing(window time.Duration, maxBytes int) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	<-c.mu
+	defer func() { c.mu <- struct{}{} }()
+	var err error
+	if cw := c.coalescer.Load(); cw != nil {
+		err = c.flushCoalesced(cw, cw.deadline)
+		c.coalescer.Store(nil)
+	}
+	if window <= 0 {
+		return err
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultCoalesceMaxBytes
+	}
+	c.coalescer.Store(&writeCoalescer{window: window, maxBytes: maxBytes})
+	return err
+}
+
+// coalesce buffers a frame. The write lock must be held.
+func (c *Conn) coalesce(cw *writeCoalescer, deadline time.Time, buf0, buf1 []byte) error {
+	cw.buf = append(cw.buf, buf0...)
+	cw.buf = append(cw.buf, buf1...)
+	cw.deadline = deadline
+	if len(cw.buf) >= cw.maxBytes {
+		return c.flushCoalesced(cw, deadline)
+	}
+	if !cw.armed {
+		cw.armed = true
+		if cw.timer == nil {
+			cw.timer = time.AfterFunc(cw.window, func() { c.flushTimer(cw) })
+		} else {
+			cw.timer.Reset(cw.window)
+		}
+	}
+	return nil
+}
+
+// flushTimer writes the frames left at the end of the window.
+func (c *Conn) flushTimer(cw *writeCoalescer) {
+	<-c.mu
+	defer func() { c.mu <- struct{}{} }()
+	if c.coalescer.Load() == cw {
+		_ = c.flushCoalesced(cw, cw.deadline)
+	}
+}
+
+// flushCoalesced writes the buffered frames. The write lock must be held.
+func (c *Conn) flushCoalesced(cw *writeCoalescer, deadline time.Time) error {
+	if cw.armed {
+		cw.timer.Stop()
+		cw.armed = false
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = cw.buf[:0]
+	conn := c.conn
+	if conn == nil {
+		return ErrNilNetConn
+	}
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return c.writeFatal(err)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return c.writeFatal(err)
+	}
+	return nil
+}
+
+// flushOnClose writes the coalesced frames before the connection is closed,
+// waiting for the write lock and the write for at most writeWait.
+func (c *Conn) flushOnClose() {
+	cw := c.coalescer.Load()
+	if cw == nil {
+		return
+	}
+	timer := time.NewTimer(writeWait)
+	defer timer.Stop()
+	select {
+	case <-c.mu:
+	case <-timer.C:
+		return
+	}
+	defer func() { c.mu <- struct{}{} }()
+	if c.coalescer.Load() != cw {
+		return
+	}
+	c.writeErrMu.Lock()
+	err := c.writeErr
+	c.writeErrMu.Unlock()
+	if err == nil || err == ErrCloseSent {
+		_ = c.flushCoalesced(cw, time.Now().Add(writeWait))
+	}
+	if cw.timer != nil {
+		cw.timer.Stop()
+	}
+}
@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingWriter records the writes to a connection.
+type countingWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func (w *countingWriter) stats() (writes int, data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes, append([]byte(nil), w.buf.Bytes()...)
+}
+
+// readAll returns the messages in the frames written by a server.
+func readAll(t *testing.T, p []byte) []string {
+	t.Helper()
+	rc := newTestConn(bytes.NewReader(p), io.Discard, false)
+	var msgs []string
+	for {
+		_, m, err := rc.ReadMessage()
+		if err != nil {
+			return msgs
+		}
+		msgs = append(msgs, string(m))
+	}
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	var w countingWriter
+	c := newTestConn(nil, &w, true)
+	if err := c.SetWriteCoalescing(20*time.Millisecond, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"a", "b", "c"} {
+		if err := c.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := w.stats(); n != 0 {
+		t.Fatalf("%d writes within the window, want 0", n)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if n, _ := w.stats(); n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	n, p := w.stats()
+	if n != 1 {
+		t.Fatalf("%d writes after the window, want 1", n)
+	}
+	if got := readAll(t, p); len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Fatalf("messages %q", got)
+	}
+}
+
+func TestWriteCoalescingFlush(t *testing.T) {
+	var w countingWriter
+	c := newTestConn(nil, &w, true)
+	_ = c.SetWriteCoalescing(time.Hour, 8)
+
+	// The frames are written when they reach maxBytes.
+	_ = c.WriteMessage(TextMessage, []byte("abc"))
+	_ = c.WriteMessage(TextMessage, []byte("def"))
+	if n, _ := w.stats(); n != 1 {
+		t.Fatalf("%d writes at maxBytes, want 1", n)
+	}
+
+	// A control message is written after the pending frames.
+	_ = c.WriteMessage(TextMessage, []byte("g"))
+	if err := c.WriteControl(PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := w.stats(); n != 3 {
+		t.Fatalf("%d writes after a ping, want 3", n)
+	}
+
+	// Disabling coalescing and Close write the pending frames.
+	_ = c.WriteMessage(TextMessage, []byte("h"))
+	if err := c.SetWriteCoalescing(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetWriteCoalescing(time.Hour, 0)
+	_ = c.WriteMessage(TextMessage, []byte("i"))
+	_ = c.Close()
+	n, p := w.stats()
+	if n != 5 {
+		t.Fatalf("%d writes after Close, want 5", n)
+	}
+	got := readAll(t, p)
+	want := []string{"abc", "def", "g", "h", "i"}
+	if len(got) != len(want) {
+		t.Fatalf("messages %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("messages %q, want %q", got, want)
+		}
+	}
+
+	var nilConn *Conn
+	if err := nilConn.SetWriteCoalescing(time.Second, 0); err != ErrNilConn {
+		t.Errorf("nil Conn returned %v", err)
+	}
+}
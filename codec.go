@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes and decodes values to and from message payloads.
+type Codec interface {
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data and stores the result in the value pointed to
+	// by v.
+	Unmarshal(data []byte, v interface{}) error
+
+	// MessageType returns the type of message written by WriteCodec,
+	// TextMessage or BinaryMessage.
+	MessageType() int
+}
+
+var (
+	// JSONCodec encodes values with encoding/json in text messages.
+	JSONCodec Codec = jsonCodec{}
+
+	// ProtobufCodec encodes values implementing proto.Message in binary
+	// messages.
+	ProtobufCodec Codec = protobufCodec{}
+
+	// MsgPackCodec encodes values with MessagePack in binary messages.
+	MsgPackCodec Codec = msgpackCodec{}
+)
+
+// errNotProtoMessage is returned by ProtobufCodec for values that do not
+// implement proto.Message.
+var errNotProtoMessage = errors.New("websocket: value does not implement proto.Message")
+
+// WriteCodec writes the encoding of v as a message of the codec's message
+// type.
+func (c *Conn) WriteCodec(codec Codec, v interface{}) error {
+	p, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(codec.MessageType(), p)
+}
+
+// ReadCodec reads the next message from the connection and decodes it with
+// the codec into the value pointed to by v. The message type of the received
+// message is not checked.
+func (c *Conn) ReadCodec(codec Codec, v interface{}) error {
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(p, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) MessageType() int                           { return TextMessage }
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errNotProtoMessage
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errNotProtoMessage
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protobufCodec) MessageType() int { return BinaryMessage }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+func (msgpackCodec) MessageType() int                           { return BinaryMessage }
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodecs(t *testing.T) {
+	type T struct {
+		A int
+		B string
+	}
+	for _, tt := range []struct {
+		name     string
+		codec    Codec
+		in, out  interface{}
+		wantType int
+	}{
+		{"json", JSONCodec, &T{1, "hello"}, &T{}, TextMessage},
+		{"msgpack", MsgPackCodec, &T{1, "hello"}, &T{}, BinaryMessage},
+		{"protobuf", ProtobufCodec, wrapperspb.String("hello"), &wrapperspb.StringValue{}, BinaryMessage},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			wc := newTestConn(nil, &buf, true)
+			rc := newTestConn(&buf, nil, false)
+			if err := wc.WriteCodec(tt.codec, tt.in); err != nil {
+				t.Fatalf("WriteCodec: %v", err)
+			}
+			if op := buf.Bytes()[0] & 0xf; int(op) != tt.wantType {
+				t.Fatalf("message type %d, want %d", op, tt.wantType)
+			}
+			if err := rc.ReadCodec(tt.codec, tt.out); err != nil {
+				t.Fatalf("ReadCodec: %v", err)
+			}
+			if m, ok := tt.in.(*wrapperspb.StringValue); ok {
+				if got := tt.out.(*wrapperspb.StringValue).GetValue(); got != m.GetValue() {
+					t.Fatalf("got %q, want %q", got, m.GetValue())
+				}
+				return
+			}
+			if !reflect.DeepEqual(tt.in, tt.out) {
+				t.Fatalf("got %+v, want %+v", tt.out, tt.in)
+			}
+		})
+	}
+}
+
+func TestProtobufCodecNotMessage(t *testing.T) {
+	if _, err := ProtobufCodec.Marshal(struct{}{}); err != errNotProtoMessage {
+		t.Fatalf("Marshal returned %v, want %v", err, errNotProtoMessage)
+	}
+}
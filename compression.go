@@ -10,6 +10,7 @@ package websocket
 import (
 	"errors"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -45,6 +46,12 @@ var (
 // The context takeover is disabled, which means that each message is compressed
 // independently without using the compression state from previous messages.
 func decompressNoContextTakeover(r io.Reader) io.ReadCloser {
+	return decompressDict(r, nil)
+}
+
+// decompressDict is like decompressNoContextTakeover, but decompresses the
+// message with a preset dictionary.
+func decompressDict(r io.Reader, dict []byte) io.ReadCloser {
 	// The tail bytes are necessary for the decompression to work correctly:
 	// - First 4 bytes (\x00\x00\xff\xff) are added as specified in RFC 7692
 	// - Second 5 bytes (\x01\x00\x00\xff\xff) add a final block to prevent
@@ -58,9 +65,9 @@ func decompressNoContextTakeover(r io.Reader) io.ReadCloser {
 	mr := io.MultiReader(r, strings.NewReader(tail))
 
 	// Try to reset the reader to reuse it
-	if err := fr.(flate.Resetter).Reset(mr, nil); err != nil {
+	if err := fr.(flate.Resetter).Reset(mr, dict); err != nil {
 		// Reset never fails, but handle error in case that changes in future versions
-		fr = flate.NewReader(mr)
+		fr = flate.NewReaderDict(mr, dict)
 	}
 
 	// Wrap the reader to handle proper cleanup when closed
@@ -238,3 +245,264 @@ func (r *flateReadWrapper) Close() error {
 
 	return err
 }
+
+// maxDeflateWindowBits is the base-2 logarithm of the LZ77 sliding window
+// used by the flate package. The window size cannot be reduced, so offers
+// that require a smaller window for the compressor are declined.
+const maxDeflateWindowBits = 15
+
+// maxDeflateWindowSize is the number of bytes of history retained by a
+// decompressor that uses context takeover.
+const maxDeflateWindowSize = 1 << maxDeflateWindowBits
+
+// deflateParams holds the negotiated parameters of the permessage-deflate
+// extension as described in RFC 7692, section 7.1.
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	serverMaxWindowBits     bool                   // server_max_window_bits=15 was offered and is echoed
+	dict                    *CompressionDictionary // preset dictionary, see CompressionDictionary
+}
+
+// String returns the parameters formatted as a Sec-WebSocket-Extensions
+// header value.
+func (p deflateParams) String() string {
+	s := "permessage-deflate"
+	if p.serverNoContextTakeover {
+		s += "; server_no_context_takeover"
+	}
+	if p.clientNoContextTakeover {
+		s += "; client_no_context_takeover"
+	}
+	if p.serverMaxWindowBits {
+		s += "; server_max_window_bits=" + strconv.Itoa(maxDeflateWindowBits)
+	}
+	if p.dict != nil {
+		s += "; " + dictionaryParam + "=" + p.dict.id
+	}
+	return s
+}
+
+// negotiateDeflate selects the first acceptable permessage-deflate offer
+// from the client. The serverContextTakeover and clientContextTakeover
+// arguments specify whether the server permits context takeover in each
+// direction, and dicts the preset dictionaries the server accepts.
+func negotiateDeflate(offers []map[string]string, serverContextTakeover, clientContextTakeover bool, dicts []*CompressionDictionary) (deflateParams, bool) {
+	for _, ext := range offers {
+		if ext[""] != "permessage-deflate" {
+			continue
+		}
+		// The compressor does not support a reduced window. A server
+		// accepts server_max_window_bits by including it in the response
+		// (RFC 7692, section 7.1.2.1).
+		bits, smwb := ext["server_max_window_bits"]
+		if smwb && bits != strconv.Itoa(maxDeflateWindowBits) {
+			continue
+		}
+		var dict *CompressionDictionary
+		if id, ok := ext[dictionaryParam]; ok {
+			if dict = findCompressionDictionary(dicts, id); dict == nil {
+				// Decline the offer for the next one, as for an unknown
+				// parameter.
+				continue
+			}
+		}
+		_, snct := ext["server_no_context_takeover"]
+		_, cnct := ext["client_no_context_takeover"]
+		return deflateParams{
+			serverNoContextTakeover: snct || !serverContextTakeover,
+			clientNoContextTakeover: cnct || !clientContextTakeover,
+			serverMaxWindowBits:     smwb,
+			dict:                    dict,
+		}, true
+	}
+	return deflateParams{}, false
+}
+
+// acceptDeflate validates the permessage-deflate response from the server
+// against the offer made by the client.
+func acceptDeflate(ext map[string]string, offer deflateParams) (deflateParams, error) {
+	_, snct := ext["server_no_context_takeover"]
+	_, cnct := ext["client_no_context_takeover"]
+	if offer.serverNoContextTakeover && !snct {
+		return deflateParams{}, errInvalidCompression
+	}
+	if _, ok := ext["client_max_window_bits"]; ok {
+		// The client did not offer client_max_window_bits.
+		return deflateParams{}, errInvalidCompression
+	}
+	var dict *CompressionDictionary
+	if id, ok := ext[dictionaryParam]; ok {
+		if offer.dict == nil || offer.dict.id != id {
+			return deflateParams{}, errInvalidCompression
+		}
+		dict = offer.dict
+	}
+	return deflateParams{
+		serverNoContextTakeover: snct,
+		clientNoContextTakeover: cnct || offer.clientNoContextTakeover,
+		dict:                    dict,
+	}, nil
+}
+
+// setupDeflate configures the connection to compress and decompress
+// messages with the negotiated parameters.
+func (c *Conn) setupDeflate(p deflateParams) {
+	c.extensions = p.String()
+	writeNoContextTakeover, readNoContextTakeover := p.serverNoContextTakeover, p.clientNoContextTakeover
+	if !c.isServer {
+		writeNoContextTakeover, readNoContextTakeover = readNoContextTakeover, writeNoContextTakeover
+	}
+
+	var dict []byte
+	if p.dict != nil {
+		dict = p.dict.data
+	}
+
+	switch {
+	case !writeNoContextTakeover:
+		c.writeContextTakeover = true
+		c.newCompressionWriter = (&contextTakeoverCompressor{dict: dict}).newWriter
+	case p.dict != nil:
+		c.newCompressionWriter = p.dict.newWriter
+	default:
+		c.newCompressionWriter = compressNoContextTakeover
+	}
+
+	switch {
+	case !readNoContextTakeover:
+		c.newDecompressionReader = (&contextTakeoverDecompressor{window: append([]byte(nil), dict...)}).newReader
+	case p.dict != nil:
+		c.newDecompressionReader = p.dict.newReader
+	default:
+		c.newDecompressionReader = decompressNoContextTakeover
+	}
+}
+
+// contextTakeoverCompressor retains a flate writer across messages so that
+// later messages can refer to data in earlier messages.
+type contextTakeoverCompressor struct {
+	fw    *flate.Writer
+	tw    truncWriter
+	level int
+	dict  []byte // preset dictionary of the first message
+}
+
+func (ct *contextTakeoverCompressor) newWriter(w io.WriteCloser, level int) io.WriteCloser {
+	ct.tw = truncWriter{w: w}
+	switch {
+	case ct.fw == nil && len(ct.dict) > 0:
+		ct.fw, _ = flate.NewWriterDict(&ct.tw, level, ct.dict)
+		ct.level = level
+	case ct.fw == nil || ct.level != level:
+		// Starting a new compressor drops the history, which is permitted
+		// because the peer's window is only used for back references. A
+		// new compressor starts without the dictionary, which the peer's
+		// window no longer ends with.
+		ct.fw, _ = flate.NewWriter(&ct.tw, level)
+		ct.level = level
+	}
+	return &contextTakeoverWriter{ct: ct}
+}
+
+// contextTakeoverWriter writes one message using a contextTakeoverCompressor.
+type contextTakeoverWriter struct {
+	ct *contextTakeoverCompressor
+}
+
+func (w *contextTakeoverWriter) Write(p []byte) (int, error) {
+	if w.ct == nil {
+		return 0, errWriteClosed
+	}
+	return w.ct.fw.Write(p)
+}
+
+func (w *contextTakeoverWriter) Close() error {
+	if w.ct == nil {
+		return errWriteClosed
+	}
+	ct := w.ct
+	w.ct = nil
+
+	err1 := ct.fw.Flush()
+	if ct.tw.p != [4]byte{0, 0, 0xff, 0xff} {
+		return errors.New("websocket: internal error, unexpected bytes at end of flate stream")
+	}
+	err2 := ct.tw.w.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// contextTakeoverDecompressor retains the most recent decompressed bytes
+// and uses them as the preset dictionary for the next message.
+type contextTakeoverDecompressor struct {
+	fr     io.ReadCloser
+	window []byte
+}
+
+func (d *contextTakeoverDecompressor) newReader(r io.Reader) io.ReadCloser {
+	// See decompressNoContextTakeover for a description of the tail.
+	const tail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+
+	mr := io.MultiReader(r, strings.NewReader(tail))
+	if d.fr == nil {
+		d.fr = flate.NewReaderDict(mr, d.window)
+	} else if err := d.fr.(flate.Resetter).Reset(mr, d.window); err != nil {
+		d.fr = flate.NewReaderDict(mr, d.window)
+	}
+	return &contextTakeoverReader{d: d}
+}
+
+// contextTakeoverReader reads one message using a
+// contextTakeoverDecompressor.
+type contextTakeoverReader struct {
+	d *contextTakeoverDecompressor
+}
+
+func (r *contextTakeoverReader) Read(p []byte) (int, error) {
+	if r.d == nil {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := r.d.fr.Read(p)
+	r.d.appendWindow(p[:n])
+	if err == io.EOF {
+		r.d = nil
+	}
+	return n, err
+}
+
+// Close consumes the remainder of the message so that the window is
+// complete for the next message.
+func (r *contextTakeoverReader) Close() error {
+	if r.d == nil {
+		return nil
+	}
+	_, err := io.Copy(io.Discard, r)
+	if err == io.EOF {
+		err = nil
+	}
+	r.d = nil
+	return err
+}
+
+func (d *contextTakeoverDecompressor) appendWindow(p []byte) {
+	if len(p) >= maxDeflateWindowSize {
+		d.window = append(d.window[:0], p[len(p)-maxDeflateWindowSize:]...)
+		return
+	}
+	if n := len(d.window) + len(p) - maxDeflateWindowSize; n > 0 {
+		d.window = append(d.window[:0], d.window[n:]...)
+	}
+	d.window = append(d.window, p...)
+}
+
+// setCompressionOptions applies the compression level and threshold
+// configured on an Upgrader or Dialer. A zero level selects the default.
+func (c *Conn) setCompressionOptions(level, threshold int) {
+	if level != 0 && isValidCompressionLevel(level) {
+		c.compressionLevel = level
+	}
+	c.compressionThreshold = threshold
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// dictionaryParam is the permessage-deflate extension parameter naming the
+// preset dictionary of a CompressionDictionary.
+const dictionaryParam = "x_dictionary"
+
+// CompressionDictionary is a preset dictionary for permessage-deflate
+// compression. Seeding the compressor with samples of the payloads of an
+// application, such as the field names and common values of ticks or
+// telemetry JSON, lets even small messages refer to them and compress far
+// better.
+//
+// The dictionary is negotiated with the x_dictionary parameter of the
+// extension, whose value is the ID of the dictionary. A client using a
+// dictionary offers the extension with the parameter first and without it
+// second, so that servers unaware of the dictionary, which decline offers
+// with unknown parameters, negotiate plain compression. Both peers must use
+// the same data for an ID; publish a new dictionary under a new ID.
+//
+// The fast compression levels, up to 6, do not match messages of less than
+// about a hundred bytes against the dictionary; use a higher level, see
+// Conn.SetCompressionLevel, for the smallest messages.
+type CompressionDictionary struct {
+	id          string
+	data        []byte
+	writerPools [maxCompressionLevel - minCompressionLevel + 1]sync.Pool
+}
+
+// NewCompressionDictionary returns the dictionary with the ID and data. The
+// ID must be a valid HTTP token. Only the last 32 KiB of data, the window of
+// the compressor, are used; put the most common strings at the end.
+func NewCompressionDictionary(id string, data []byte) (*CompressionDictionary, error) {
+	if id == "" {
+		return nil, errors.New("websocket: empty compression dictionary ID")
+	}
+	for i := 0; i < len(id); i++ {
+		if !isTokenOctet[id[i]] {
+			return nil, errors.New("websocket: invalid compression dictionary ID " + id)
+		}
+	}
+	if len(data) > maxDeflateWindowSize {
+		data = data[len(data)-maxDeflateWindowSize:]
+	}
+	return &CompressionDictionary{id: id, data: append([]byte(nil), data...)}, nil
+}
+
+// ID returns the ID of the dictionary.
+func (d *CompressionDictionary) ID() string {
+	return d.id
+}
+
+// newWriter creates a compressor seeded with the dictionary for each
+// message, without context takeover.
+func (d *CompressionDictionary) newWriter(w io.WriteCloser, level int) io.WriteCloser {
+	p := &d.writerPools[level-minCompressionLevel]
+	tw := &truncWriter{w: w}
+	fw, _ := p.Get().(*flate.Writer)
+	if fw == nil {
+		fw, _ = flate.NewWriterDict(tw, level, d.data)
+	} else {
+		// Reset restores the dictionary of the writer.
+		fw.Reset(tw)
+	}
+	return &flateWriteWrapper{fw: fw, tw: tw, p: p}
+}
+
+// newReader creates a decompressor seeded with the dictionary for each
+// message, without context takeover.
+func (d *CompressionDictionary) newReader(r io.Reader) io.ReadCloser {
+	return decompressDict(r, d.data)
+}
+
+// findCompressionDictionary returns the dictionary with the ID, or nil.
+func findCompressionDictionary(dicts []*CompressionDictionary, id string) *CompressionDictionary {
+	for _, d := range dicts {
+		if d != nil && d.id == id {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDictionaryTick = `{"symbol":"EURUSD","bid":1.08512,"ask":1.08514,"venue":"LMAX"}`
+
+func newTestDictionary(t *testing.T, id string) *CompressionDictionary {
+	t.Helper()
+	d, err := NewCompressionDictionary(id, []byte(strings.Repeat(testDictionaryTick, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestCompressionDictionary(t *testing.T) {
+	d := newTestDictionary(t, "ticks-v1")
+	compress := func(newWriter func(io.WriteCloser, int) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(nopCloser{&buf}, defaultCompressionLevel)
+		_, _ = io.WriteString(w, strings.Repeat(testDictionaryTick, 3))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	plain := compress(compressNoContextTakeover)
+	withDict := compress(d.newWriter)
+	if len(withDict) >= len(plain)/2 {
+		t.Errorf("compressed %d bytes with the dictionary, %d without", len(withDict), len(plain))
+	}
+	r := d.newReader(bytes.NewReader(withDict))
+	p, err := io.ReadAll(r)
+	if err != nil || string(p) != strings.Repeat(testDictionaryTick, 3) {
+		t.Fatalf("decompressed %q, %v", p, err)
+	}
+
+	for _, id := range []string{"", "a b", "a,b"} {
+		if _, err := NewCompressionDictionary(id, nil); err == nil {
+			t.Errorf("NewCompressionDictionary(%q) succeeded", id)
+		}
+	}
+}
+
+func TestCompressionDictionaryNegotiation(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		server, offer string
+		contextTO     bool
+		want          string
+	}{
+		{name: "accepted", server: "ticks-v1", offer: "ticks-v1", want: "permessage-deflate; server_no_context_takeover; client_no_context_takeover; x_dictionary=ticks-v1"},
+		{name: "context takeover", server: "ticks-v1", offer: "ticks-v1", contextTO: true, want: "permessage-deflate; x_dictionary=ticks-v1"},
+		{name: "unknown", server: "ticks-v2", offer: "ticks-v1", want: "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Upgrader{
+				EnableCompression:       true,
+				ServerContextTakeover:   tt.contextTO,
+				ClientContextTakeover:   tt.contextTO,
+				CompressionDictionaries: []*CompressionDictionary{newTestDictionary(t, tt.server)},
+			}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c, err := u.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				for {
+					mt, p, err := c.ReadMessage()
+					if err != nil {
+						return
+					}
+					if err := c.WriteMessage(mt, p); err != nil {
+						return
+					}
+				}
+			}))
+			defer s.Close()
+
+			d := Dialer{
+				EnableCompression:     true,
+				ServerContextTakeover: tt.contextTO,
+				ClientContextTakeover: tt.contextTO,
+				CompressionDictionary: newTestDictionary(t, tt.offer),
+			}
+			c, resp, err := d.Dial(makeWsProto(s.URL), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != tt.want {
+				t.Fatalf("negotiated %q, want %q", got, tt.want)
+			}
+			for i := 0; i < 3; i++ {
+				if err := c.WriteMessage(TextMessage, []byte(testDictionaryTick)); err != nil {
+					t.Fatal(err)
+				}
+				if s := readString(t, c); s != testDictionaryTick {
+					t.Fatalf("echo %d = %q", i, s)
+				}
+			}
+		})
+	}
+}
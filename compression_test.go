@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
 	"testing"
 )
 
@@ -77,3 +78,137 @@ func TestValidCompressionLevel(t *testing.T) {
 		}
 	}
 }
+
+var negotiateDeflateTests = []struct {
+	header                string
+	serverCT, clientCT    bool
+	ok                    bool
+	wantServerNoCT, wantC bool
+}{
+	{"permessage-deflate", false, false, true, true, true},
+	{"permessage-deflate", true, true, true, false, false},
+	{"permessage-deflate; server_no_context_takeover", true, true, true, true, false},
+	{"permessage-deflate; client_no_context_takeover", true, true, true, false, true},
+	{"permessage-deflate; server_max_window_bits=10", true, true, false, false, false},
+	{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true, true, true, false, false},
+	{"x-webkit-deflate-frame", true, true, false, false, false},
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	for _, tt := range negotiateDeflateTests {
+		offers := parseExtensions(map[string][]string{"Sec-Websocket-Extensions": {tt.header}})
+		p, ok := negotiateDeflate(offers, tt.serverCT, tt.clientCT, nil)
+		if ok != tt.ok {
+			t.Errorf("negotiateDeflate(%q) ok=%v, want %v", tt.header, ok, tt.ok)
+			continue
+		}
+		if ok && (p.serverNoContextTakeover != tt.wantServerNoCT || p.clientNoContextTakeover != tt.wantC) {
+			t.Errorf("negotiateDeflate(%q) = %+v", tt.header, p)
+		}
+	}
+}
+
+func TestNegotiateDeflateResponse(t *testing.T) {
+	for _, tt := range []struct{ offer, response string }{
+		{"permessage-deflate", "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=15", "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=15"},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate; server_max_window_bits=15", "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=15"},
+	} {
+		header := http.Header{"Sec-Websocket-Extensions": {tt.offer}}
+		if got := joinExtensionHeaders(acceptExtensions(header, []Extension{&deflateExtension{}})); got != tt.response {
+			t.Errorf("response to %q is %q, want %q", tt.offer, got, tt.response)
+		}
+	}
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	offer := deflateParams{serverNoContextTakeover: true}
+	if _, err := acceptDeflate(map[string]string{"": "permessage-deflate"}, offer); err != errInvalidCompression {
+		t.Errorf("missing server_no_context_takeover: err=%v, want %v", err, errInvalidCompression)
+	}
+	ext := map[string]string{"": "permessage-deflate", "server_no_context_takeover": "", "client_max_window_bits": "10"}
+	if _, err := acceptDeflate(ext, offer); err != errInvalidCompression {
+		t.Errorf("unexpected client_max_window_bits: err=%v, want %v", err, errInvalidCompression)
+	}
+	p, err := acceptDeflate(map[string]string{"": "permessage-deflate", "server_no_context_takeover": ""}, offer)
+	if err != nil || !p.serverNoContextTakeover || p.clientNoContextTakeover {
+		t.Errorf("acceptDeflate() = %+v, %v", p, err)
+	}
+}
+
+func TestContextTakeover(t *testing.T) {
+	for _, isServer := range []bool{true, false} {
+		var connBuf bytes.Buffer
+		wc := newTestConn(nil, &connBuf, isServer)
+		rc := newTestConn(&connBuf, nil, !isServer)
+		wc.setupDeflate(deflateParams{})
+		rc.setupDeflate(deflateParams{})
+
+		// The fast flate levels do not retain history for blocks smaller
+		// than 128 bytes, so use messages that are larger than that.
+		messages := textMessages(100)
+		for i, m := range messages {
+			messages[i] = bytes.Repeat(m, 3)
+		}
+		var size int
+		for i, m := range messages {
+			before := connBuf.Len()
+			if err := wc.WriteMessage(TextMessage, m); err != nil {
+				t.Fatalf("WriteMessage: %v", err)
+			}
+			if i > 0 {
+				size += connBuf.Len() - before
+			}
+			// Read only part of some messages to check that the window is
+			// completed when the reader is discarded.
+			_, r, err := rc.NextReader()
+			if err != nil {
+				t.Fatalf("NextReader: %v", err)
+			}
+			if i%3 == 0 {
+				var p [4]byte
+				_, _ = io.ReadFull(r, p[:])
+				continue
+			}
+			p, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if !bytes.Equal(p, m) {
+				t.Fatalf("server=%v message %d = %q, want %q", isServer, i, p, m)
+			}
+		}
+
+		// Repeated messages should compress better than in isolation.
+		var plain bytes.Buffer
+		pc := newTestConn(nil, &plain, isServer)
+		pc.setupDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
+		for _, m := range messages[1:] {
+			_ = pc.WriteMessage(TextMessage, m)
+		}
+		if size >= plain.Len() {
+			t.Errorf("server=%v context takeover size %d, want less than %d", isServer, size, plain.Len())
+		}
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, true)
+	wc.setupDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
+	wc.SetCompressionThreshold(64)
+
+	if err := wc.WriteMessage(TextMessage, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if b := connBuf.Bytes()[0]; b&rsv1Bit != 0 {
+		t.Errorf("small message compressed, header byte %x", b)
+	}
+	connBuf.Reset()
+	if err := wc.WriteMessage(TextMessage, bytes.Repeat([]byte("large"), 20)); err != nil {
+		t.Fatal(err)
+	}
+	if b := connBuf.Bytes()[0]; b&rsv1Bit == 0 {
+		t.Errorf("large message not compressed, header byte %x", b)
+	}
+}
@@ -10,10 +10,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
@@ -148,7 +151,8 @@ func (e *CloseError) Error() string {
 // IsCloseError returns boolean indicating whether the error is a *CloseError
 // with one of the specified codes.
 func IsCloseError(err error, codes ...int) bool {
-	if e, ok := err.(*CloseError); ok {
+	var e *CloseError
+	if errors.As(err, &e) {
 		for _, code := range codes {
 			if e.Code == code {
 				return true
@@ -161,7 +165,8 @@ func IsCloseError(err error, codes ...int) bool {
 // IsUnexpectedCloseError returns boolean indicating whether the error is a
 // *CloseError with a code not in the list of expected codes.
 func IsUnexpectedCloseError(err error, expectedCodes ...int) bool {
-	if e, ok := err.(*CloseError); ok {
+	var e *CloseError
+	if errors.As(err, &e) {
 		for _, code := range expectedCodes {
 			if e.Code == code {
 				return false
@@ -173,7 +178,6 @@ func IsUnexpectedCloseError(err error, expectedCodes ...int) bool {
 }
 
 var (
-	errWriteTimeout        = &netError{msg: "websocket: write timeout", timeout: true, temporary: true}
 	errUnexpectedEOF       = &CloseError{Code: CloseAbnormalClosure, Text: io.ErrUnexpectedEOF.Error()}
 	errBadWriteOpCode      = errors.New("websocket: bad write message type")
 	errWriteClosed         = errors.New("websocket: write closed")
@@ -237,47 +241,124 @@ type BufferPool interface {
 // added to the pool.
 type writePoolData struct{ buf []byte }
 
+// readPoolData is the type added to the read buffer pool.
+type readPoolData struct{ br *bufio.Reader }
+
 // The Conn type represents a WebSocket connection.
 type Conn struct {
 	conn        net.Conn
 	isServer    bool
 	subprotocol string
+	extensions  string              // negotiated extensions, see Extensions
+	transport   string              // name of the fallback transport, empty for WebSocket
+	datagrams   WebTransportSession // session of a WebTransport connection
+	realIP      netip.Addr
+
+	closed    chan struct{} // closed by Close to stop background goroutines
+	id        string        // see ID
+	closeOnce sync.Once
+	release   func()       // releases the connection slot, see Upgrader.MaxConnections
+	metrics   Metrics      // non-nil when metrics are collected
+	closeCode atomic.Int32 // first close code sent or received
+
+	eventSink   EventSink              // non-nil when audit events are recorded
+	eventUser   string                 // user recorded in audit events
+	closeReason atomic.Pointer[string] // reason sent or received with closeCode
+
+	inbound, outbound interceptorChain // see UseInbound and UseOutbound
+
+	metaMu  sync.RWMutex           // protects value, meta, labels and session
+	value   interface{}            // see SetValue
+	meta    map[string]interface{} // see Set
+	labels  map[string]string      // see SetLabel
+	session *Session               // see SetSession
+
+	callMu    sync.Mutex
+	calls     map[string]chan *Event // pending calls by ID, see Call
+	callSeq   uint64
+	callCache *callCache // see CacheCalls
+
+	channelMu sync.Mutex
+	channels  map[string]channelEndpoint // typed channels by event, see NewChannel
 
 	// Write fields
-	mu            chan struct{} // used as mutex to protect write to conn
-	writeBuf      []byte        // frame is constructed in this buffer.
-	writePool     BufferPool
-	writeBufSize  int
-	writeDeadline time.Time
-	writer        io.WriteCloser // the current writer returned to the application
-	isWriting     bool           // for best-effort concurrent write detection
+	mu             chan struct{} // used as mutex to protect write to conn
+	writeBuf       []byte        // frame is constructed in this buffer.
+	writePool      BufferPool
+	writeBufSize   int
+	writeFrameSize int // maximum payload of a data frame, zero for the buffer size
+	writeDeadline  time.Time
+	writer         io.WriteCloser // the current writer returned to the application
+	msgWriter      *messageWriter // the message writer under writer, see AbortMessage
+	isWriting      bool           // for best-effort concurrent write detection
+	writeQueue     *writeQueue    // non-nil when writes are queued, see EnableWriteQueue
+	keepalive      *keepalive     // non-nil when keepalive is enabled
+	timeSync       *timeSync      // non-nil when time sync is enabled
+
+	coalescer atomic.Pointer[writeCoalescer] // non-nil when writes are coalesced, see SetWriteCoalescing
+	batcher   atomic.Pointer[eventBatcher]   // non-nil when events are batched, see SetEventBatching
+	cipher    PayloadCipher                  // non-nil when payloads are encrypted, see SetPayloadCipher
+	recorder  atomic.Pointer[Recorder]       // non-nil when messages are recorded, see Recorder.Tap
+	faults    atomic.Pointer[FaultInjector]  // non-nil when faults are injected, see SetFaultInjector
+	inspector atomic.Pointer[FrameInspector] // non-nil when frames are inspected, see SetFrameInspector
 
 	writeErrMu sync.Mutex
 	writeErr   error
 
+	lastRead  atomic.Int64 // time of the last data frame read, in Unix nanoseconds
+	lastWrite atomic.Int64 // time of the last data frame written, in Unix nanoseconds
+	opened    time.Time
+
 	enableWriteCompression bool
 	compressionLevel       int
+	compressionThreshold   int  // minimum payload size for compression
+	writeContextTakeover   bool // whether the compressor retains state across messages
 	newCompressionWriter   func(io.WriteCloser, int) io.WriteCloser
+	extTransforms          []ExtensionTransform // transforms of the extensions other than permessage-deflate
+	extRSV                 byte                 // reserved bits of extTransforms
 
 	// Read fields
-	reader  io.ReadCloser // the current reader returned to the application
-	readErr error
-	br      *bufio.Reader
+	reader      io.ReadCloser // the current reader returned to the application
+	readErr     error
+	br          *bufio.Reader // nil between messages when readPool is set
+	readPool    BufferPool
+	readBufSize int
+	readSource  pooledReadSource // source of br when readPool is set
 	// bytes remaining in current frame.
 	// set setReadRemaining to safely update this value and prevent overflow
 	readRemaining int64
 	readFinal     bool  // true the current message has more frames.
 	readLength    int64 // Message size.
 	readLimit     int64 // Maximum message size.
+	readFrames    int   // Number of frames of the message.
+	readFrameMax  int64 // Maximum frame size.
+	readFramesMax int   // Maximum number of frames of a message.
 	readMaskPos   int
 	readMaskKey   [4]byte
 	handlePong    func(string) error
 	handlePing    func(string) error
 	handleClose   func(int, string) error
+	handleControl ControlHandler // see SetControlHandler
+	readMu        sync.Mutex     // held while reading from the network, see CloseWithCode
+	peerClosed    chan struct{}  // closed when the peer's close message is received
+	peerCloseOnce sync.Once
+	peerCloseErr  *CloseError
+	halfClosed    atomic.Bool // see CloseWrite
+	closeTimedOut atomic.Bool // CloseWrite timed out waiting for the peer
 	readErrCount  int
 	messageReader *messageReader // the current low-level reader
-
-	readDecompress         bool // whether last read frame had RSV1 set
+	tryBuf        []byte         // scratch buffer of TryReadMessage
+	stringBuf     []byte         // buffer of the string views of ReadString
+	spareReader   *messageReader // reader used by ReadString, reused by nextReader
+	unsafeStrings bool           // see SetUnsafeStrings
+	migrating     atomic.Bool    // see Export
+	awaitingFrame atomic.Bool    // the reader waits for a frame at a message boundary
+
+	readLimiter            *rateLimiter // non-nil when reads are rate limited
+	readDecompress         bool         // whether last read frame had RSV1 set
+	readRSV                byte         // reserved bits of extTransforms set in the last read frame
+	strictUTF8             bool         // whether text messages are validated, see SetStrictUTF8
+	logger                 Logger
 	newDecompressionReader func(io.Reader) io.ReadCloser
 }
 
@@ -292,6 +373,7 @@ func newConn(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int,
 		}
 		br = bufio.NewReaderSize(conn, readBufferSize)
 	}
+	readBufferSize = br.Size()
 
 	if writeBufferSize <= 0 {
 		writeBufferSize = defaultWriteBufferSize
@@ -307,7 +389,11 @@ func newConn(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int,
 	c := &Conn{
 		isServer:               isServer,
 		br:                     br,
+		readBufSize:            readBufferSize,
 		conn:                   conn,
+		closed:                 make(chan struct{}),
+		id:                     newConnID(),
+		peerClosed:             make(chan struct{}),
 		mu:                     mu,
 		readFinal:              true,
 		writeBuf:               writeBuf,
@@ -316,6 +402,10 @@ func newConn(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int,
 		enableWriteCompression: true,
 		compressionLevel:       defaultCompressionLevel,
 	}
+	c.opened = time.Now()
+	now := c.opened.UnixNano()
+	c.lastRead.Store(now)
+	c.lastWrite.Store(now)
 	c.SetCloseHandler(nil)
 	c.SetPingHandler(nil)
 	c.SetPongHandler(nil)
@@ -341,6 +431,17 @@ func (c *Conn) Subprotocol() string {
 	return c.subprotocol
 }
 
+// Extensions returns the extensions negotiated for the connection in the
+// format of the Sec-WebSocket-Extensions header, such as
+// "permessage-deflate; client_no_context_takeover". It returns the empty
+// string if no extension was negotiated.
+func (c *Conn) Extensions() string {
+	if c == nil {
+		return ""
+	}
+	return c.extensions
+}
+
 // Close closes the underlying network connection without sending or waiting
 // for a close message.
 func (c *Conn) Close() error {
@@ -351,6 +452,36 @@ func (c *Conn) Close() error {
 		return ErrNilNetConn
 	}
 
+	if c.closed != nil {
+		c.closeOnce.Do(func() {
+			close(c.closed)
+			if c.release != nil {
+				c.release()
+			}
+			code := int(c.closeCode.Load())
+			if code == 0 {
+				code = CloseAbnormalClosure
+			}
+			if m := c.metrics; m != nil {
+				m.ConnClosed(code)
+			}
+			c.log(slog.LevelInfo, "websocket: connection closed", "code", code)
+			if c.eventSink != nil {
+				var reason string
+				if p := c.closeReason.Load(); p != nil {
+					reason = *p
+				}
+				c.audit(AuditClose, "", code, reason)
+			}
+			c.saveSession()
+		})
+	}
+	c.flushEventsOnClose()
+	if q := c.writeQueue; q != nil {
+		q.stop()
+	}
+	c.flushOnClose()
+
 	// Use a local variable to avoid race condition
 	conn := c.conn
 	if conn == nil {
@@ -360,6 +491,14 @@ func (c *Conn) Close() error {
 	return conn.Close()
 }
 
+// Done returns a channel that is closed when Close is called.
+func (c *Conn) Done() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.closed
+}
+
 // LocalAddr returns the local network address.
 func (c *Conn) LocalAddr() net.Addr {
 	if c == nil || c.conn == nil {
@@ -400,6 +539,7 @@ func (c *Conn) writeFatal(err error) error {
 	if c == nil {
 		return ErrNilConn
 	}
+	err = c.classifyWrite(err)
 	c.writeErrMu.Lock()
 	if c.writeErr == nil {
 		c.writeErr = err
@@ -439,6 +579,27 @@ func (c *Conn) write(frameType int, deadline time.Time, buf0, buf1 []byte) error
 		return ErrNilNetConn
 	}
 
+	if fi := c.faults.Load(); fi != nil {
+		var done bool
+		if buf0, done, err = c.injectFaults(fi, frameType, deadline, buf0, buf1); done {
+			return err
+		}
+		buf1 = nil
+	}
+
+	if fi := c.inspector.Load(); fi != nil {
+		c.inspectWrite(fi, buf0, buf1)
+	}
+
+	if cw := c.coalescer.Load(); cw != nil {
+		if frameType != CloseMessage {
+			return c.coalesce(cw, deadline, buf0, buf1)
+		}
+		if err := c.flushCoalesced(cw, deadline); err != nil {
+			return err
+		}
+	}
+
 	// Use a local variable to avoid race condition where c.conn becomes nil
 	// between the check above and the SetWriteDeadline call
 	conn := c.conn
@@ -490,7 +651,6 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 	if len(data) > maxControlFramePayloadSize {
 		return errInvalidControlFrame
 	}
-
 	b0 := byte(messageType) | finalBit
 	b1 := byte(len(data))
 	if !c.isServer {
@@ -515,7 +675,7 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 	} else {
 		d := time.Until(deadline)
 		if d < 0 {
-			return errWriteTimeout
+			return ErrWriteTimeout
 		}
 		select {
 		case <-c.mu:
@@ -525,7 +685,7 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 			case <-c.mu:
 				timer.Stop()
 			case <-timer.C:
-				return errWriteTimeout
+				return ErrWriteTimeout
 			}
 		}
 	}
@@ -549,13 +709,41 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 		return ErrNilNetConn
 	}
 
+	if fi := c.faults.Load(); fi != nil {
+		var done bool
+		if buf, done, err = c.injectFaults(fi, messageType, deadline, buf, nil); done {
+			return err
+		}
+	}
+	if fi := c.inspector.Load(); fi != nil {
+		c.inspectWrite(fi, buf, nil)
+	}
+
+	if cw := c.coalescer.Load(); cw != nil {
+		if err := c.flushCoalesced(cw, deadline); err != nil {
+			return err
+		}
+	}
 	if err := conn.SetWriteDeadline(deadline); err != nil {
 		return c.writeFatal(err)
 	}
 	if _, err = conn.Write(buf); err != nil {
 		return c.writeFatal(err)
 	}
+	if r := c.recorder.Load(); r != nil {
+		r.record(c, RecordOut, messageType, data)
+	}
 	if messageType == CloseMessage {
+		// Record the code once the close message is sent, so that a failed
+		// write does not report a code the peer never received.
+		code := CloseNoStatusReceived
+		if len(data) >= 2 {
+			code = int(binary.BigEndian.Uint16(data))
+		}
+		if c.closeCode.CompareAndSwap(0, int32(code)) && len(data) > 2 {
+			reason := string(data[2:])
+			c.closeReason.Store(&reason)
+		}
 		_ = c.writeFatal(ErrCloseSent)
 	}
 	return err
@@ -587,6 +775,7 @@ func (c *Conn) beginMessage(mw *messageWriter, messageType int) error {
 
 	mw.c = c
 	mw.frameType = messageType
+	mw.text = messageType == TextMessage
 	mw.pos = maxFrameHeaderSize
 
 	if c.writeBuf == nil {
@@ -612,14 +801,33 @@ func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
 	if c == nil {
 		return nil, ErrNilConn
 	}
+	if c.writeQueue != nil {
+		return nil, ErrWriteQueueEnabled
+	}
+	if c.outbound.enabled() && isData(messageType) {
+		return &interceptWriter{c: c, messageType: messageType}, nil
+	}
+	return c.nextWriter(messageType, true)
+}
+
+// nextWriter returns a writer for the next message. The message is
+// compressed when allowCompression is true and compression is enabled.
+func (c *Conn) nextWriter(messageType int, allowCompression bool) (io.WriteCloser, error) {
 	var mw messageWriter
 	if err := c.beginMessage(&mw, messageType); err != nil {
 		return nil, err
 	}
 	c.writer = &mw
-	if c.newCompressionWriter != nil && c.enableWriteCompression && isData(messageType) {
+	c.msgWriter = &mw
+	if c.cipher != nil && isData(messageType) {
+		c.writer = &sealWriter{c: c, mw: &mw, messageType: messageType}
+	}
+	if len(c.extTransforms) > 0 && isData(messageType) {
+		c.writer, mw.rsv = c.wrapExtensionWriter(c.writer, messageType)
+	}
+	if allowCompression && c.newCompressionWriter != nil && c.enableWriteCompression && isData(messageType) {
 		w := c.newCompressionWriter(c.writer, c.compressionLevel)
-		mw.compress = true
+		mw.rsv |= rsv1Bit
 		c.writer = w
 	}
 	return c.writer, nil
@@ -627,10 +835,16 @@ func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
 
 type messageWriter struct {
 	c         *Conn
-	compress  bool // whether next call to flushFrame should set RSV1
+	rsv       byte // reserved bits set by the next call to flushFrame
 	pos       int  // end of data in writeBuf.
 	frameType int  // type of the current frame.
 	err       error
+
+	// The last bytes sent of a text message, to tell if the message can be
+	// truncated without cutting a rune, see TruncateMessage.
+	text  bool
+	tail  [utf8.UTFMax - 1]byte
+	ntail int
 }
 
 func (w *messageWriter) endMessage(err error) error {
@@ -640,6 +854,7 @@ func (w *messageWriter) endMessage(err error) error {
 	c := w.c
 	w.err = err
 	c.writer = nil
+	c.msgWriter = nil
 	if c.writePool != nil {
 		c.writePool.Put(writePoolData{buf: c.writeBuf})
 		c.writeBuf = nil
@@ -666,10 +881,8 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 	if final {
 		b0 |= finalBit
 	}
-	if w.compress {
-		b0 |= rsv1Bit
-	}
-	w.compress = false
+	b0 |= w.rsv
+	w.rsv = 0
 
 	b1 := byte(0)
 	if !c.isServer {
@@ -699,6 +912,10 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 		c.writeBuf[framePos+1] = b1 | byte(length)
 	}
 
+	if w.text && !final {
+		w.trackTail(c.writeBuf[maxFrameHeaderSize:w.pos], extra)
+	}
+
 	if !c.isServer {
 		key := newMaskKey()
 		copy(c.writeBuf[maxFrameHeaderSize-4:], key[:])
@@ -728,6 +945,16 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 		return w.endMessage(err)
 	}
 
+	if !isControl(w.frameType) {
+		c.lastWrite.Store(time.Now().UnixNano())
+		if m := c.metrics; m != nil {
+			if w.frameType != continuationFrame {
+				m.MessageSent(w.frameType)
+			}
+			m.BytesSent(length)
+		}
+	}
+
 	if final {
 		_ = w.endMessage(errWriteClosed)
 		return nil
@@ -739,13 +966,22 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 	return nil
 }
 
+// bufEnd returns the end of the frame being constructed in writeBuf.
+func (w *messageWriter) bufEnd() int {
+	end := len(w.c.writeBuf)
+	if fs := w.c.writeFrameSize; fs > 0 && maxFrameHeaderSize+fs < end {
+		end = maxFrameHeaderSize + fs
+	}
+	return end
+}
+
 func (w *messageWriter) ncopy(max int) (int, error) {
-	n := len(w.c.writeBuf) - w.pos
+	n := w.bufEnd() - w.pos
 	if n <= 0 {
 		if err := w.flushFrame(false, nil); err != nil {
 			return 0, err
 		}
-		n = len(w.c.writeBuf) - w.pos
+		n = w.bufEnd() - w.pos
 	}
 	if n > max {
 		n = max
@@ -758,7 +994,7 @@ func (w *messageWriter) Write(p []byte) (int, error) {
 		return 0, w.err
 	}
 
-	if len(p) > 2*len(w.c.writeBuf) && w.c.isServer {
+	if len(p) > 2*len(w.c.writeBuf) && w.c.isServer && w.c.writeFrameSize == 0 {
 		// Don't buffer large messages.
 		err := w.flushFrame(false, p)
 		if err != nil {
@@ -803,14 +1039,14 @@ func (w *messageWriter) ReadFrom(r io.Reader) (nn int64, err error) {
 		return 0, w.err
 	}
 	for {
-		if w.pos == len(w.c.writeBuf) {
+		if w.pos >= w.bufEnd() {
 			err = w.flushFrame(false, nil)
 			if err != nil {
 				break
 			}
 		}
 		var n int
-		n, err = r.Read(w.c.writeBuf[w.pos:])
+		n, err = r.Read(w.c.writeBuf[w.pos:w.bufEnd()])
 		w.pos += n
 		nn += int64(n)
 		if err != nil {
@@ -835,14 +1071,44 @@ func (c *Conn) WritePreparedMessage(pm *PreparedMessage) error {
 	if c == nil {
 		return ErrNilConn
 	}
-	frameType, frameData, err := pm.frame(prepareKey{
+	if c.outbound.enabled() {
+		return c.WriteMessage(pm.messageType, pm.data)
+	}
+	if q := c.writeQueue; q != nil {
+		return q.enqueue(queuedMessage{pm: pm})
+	}
+	return c.writePreparedMessage(pm)
+}
+
+// preparedFrame returns the frame of pm encoded for the configuration of c.
+// It returns ok false if the frame cannot be shared with other connections.
+func (c *Conn) preparedFrame(pm *PreparedMessage) (frameType int, frame []byte, ok bool, err error) {
+	if c.cipher != nil || len(c.extTransforms) > 0 {
+		return 0, nil, false, nil
+	}
+	compress := c.newCompressionWriter != nil && c.enableWriteCompression && isData(pm.messageType) &&
+		len(pm.data) >= c.compressionThreshold
+	if compress && c.writeContextTakeover {
+		// The compressed representation depends on the state of this
+		// connection's compressor and cannot be shared.
+		return 0, nil, false, nil
+	}
+	frameType, frame, err = pm.frame(prepareKey{
 		isServer:         c.isServer,
-		compress:         c.newCompressionWriter != nil && c.enableWriteCompression && isData(pm.messageType),
+		compress:         compress,
 		compressionLevel: c.compressionLevel,
 	})
+	return frameType, frame, true, err
+}
+
+func (c *Conn) writePreparedMessage(pm *PreparedMessage) error {
+	frameType, frameData, ok, err := c.preparedFrame(pm)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		return c.WriteMessage(pm.messageType, pm.data)
+	}
 	if c.isWriting {
 		panic("concurrent write to websocket connection")
 	}
@@ -852,16 +1118,38 @@ func (c *Conn) WritePreparedMessage(pm *PreparedMessage) error {
 		panic("concurrent write to websocket connection")
 	}
 	c.isWriting = false
+	if err == nil {
+		c.lastWrite.Store(time.Now().UnixNano())
+		if m := c.metrics; m != nil {
+			m.MessageSent(pm.messageType)
+			m.BytesSent(framePayloadLen(frameData))
+		}
+	}
 	return err
 }
 
 // WriteMessage is a helper method for getting a writer using NextWriter,
 // writing the message and closing the writer.
+//
+// If the write queue is enabled, WriteMessage copies data to the queue and
+// returns without waiting for the message to be written.
 func (c *Conn) WriteMessage(messageType int, data []byte) error {
 	if c == nil {
 		return ErrNilConn
 	}
-	if c.isServer && (c.newCompressionWriter == nil || !c.enableWriteCompression) {
+	data, ok, err := c.interceptWrite(messageType, data)
+	if !ok {
+		return err
+	}
+	if q := c.writeQueue; q != nil {
+		return q.enqueue(queuedMessage{messageType: messageType, data: append([]byte(nil), data...)})
+	}
+	return c.writeMessage(messageType, data)
+}
+
+func (c *Conn) writeMessage(messageType int, data []byte) error {
+	compress := len(data) >= c.compressionThreshold
+	if c.isServer && c.writeFrameSize == 0 && c.cipher == nil && len(c.extTransforms) == 0 && (c.newCompressionWriter == nil || !c.enableWriteCompression || !compress) {
 		// Fast path with no allocations and single frame.
 
 		var mw messageWriter
@@ -874,7 +1162,7 @@ func (c *Conn) WriteMessage(messageType int, data []byte) error {
 		return mw.flushFrame(true, data)
 	}
 
-	w, err := c.NextWriter(messageType)
+	w, err := c.nextWriter(messageType, compress)
 	if err != nil {
 		return err
 	}
@@ -892,6 +1180,10 @@ func (c *Conn) SetWriteDeadline(t time.Time) error {
 	if c == nil {
 		return ErrNilConn
 	}
+	if q := c.writeQueue; q != nil {
+		q.setDeadline(t)
+		return nil
+	}
 	c.writeDeadline = t
 	return nil
 }
@@ -922,20 +1214,33 @@ func (c *Conn) readFrameHeader() (frameType int, final bool, mask bool, err erro
 
 	frameType = int(p[0] & 0xf)
 	final = p[0]&finalBit != 0
-	rsv1 := p[0]&rsv1Bit != 0
-	rsv2 := p[0]&rsv2Bit != 0
-	rsv3 := p[0]&rsv3Bit != 0
+	extRSV := p[0] & c.extRSV
+	rsv1 := p[0]&rsv1Bit&^c.extRSV != 0
+	rsv2 := p[0]&rsv2Bit&^c.extRSV != 0
+	rsv3 := p[0]&rsv3Bit&^c.extRSV != 0
 	mask = p[1]&maskBit != 0
 	_ = c.setReadRemaining(int64(p[1] & 0x7f)) // will not fail because argument is >= 0
 
-	// Handle compression
+	// Handle compression. RSV1 marks a compressed message and is only valid
+	// on the first frame of a data message.
 	c.readDecompress = false
+	c.readRSV = 0
 	var errorList []string
-	if rsv1 {
-		if c.newDecompressionReader != nil {
-			c.readDecompress = true
+	if extRSV != 0 {
+		if frameType == TextMessage || frameType == BinaryMessage {
+			c.readRSV = extRSV
 		} else {
+			errorList = append(errorList, "extension RSV bits set on non-first frame")
+		}
+	}
+	if rsv1 {
+		switch {
+		case c.newDecompressionReader == nil:
 			errorList = append(errorList, "RSV1 set")
+		case frameType == TextMessage || frameType == BinaryMessage:
+			c.readDecompress = true
+		default:
+			errorList = append(errorList, "RSV1 set on non-first frame")
 		}
 	}
 
@@ -1037,15 +1342,19 @@ func (c *Conn) handleFrameMasking(mask bool) error {
 func (c *Conn) enforceReadLimit(frameType int) (bool, error) {
 	if frameType == continuationFrame || frameType == TextMessage || frameType == BinaryMessage {
 		c.readLength += c.readRemaining
+		c.readFrames++
 		// Don't allow readLength to overflow in the presence of a large readRemaining counter.
 		if c.readLength < 0 {
 			return false, ErrReadLimit
 		}
 
-		if c.readLimit > 0 && c.readLength > c.readLimit {
-			// Make a best effort to send a close message describing the problem.
-			_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(writeWait))
-			return false, ErrReadLimit
+		switch {
+		case c.readLimit > 0 && c.readLength > c.readLimit:
+			return false, c.handleReadLimit("")
+		case c.readFrameMax > 0 && c.readRemaining > c.readFrameMax:
+			return false, c.handleReadLimit("frame too large")
+		case c.readFramesMax > 0 && c.readFrames > c.readFramesMax:
+			return false, c.handleReadLimit("too many fragments")
 		}
 		return true, nil
 	}
@@ -1073,18 +1382,45 @@ func (c *Conn) readControlFramePayload() ([]byte, error) {
 // processControlFrame processes a control frame
 // 7. Process control frame payload.
 func (c *Conn) processControlFrame(frameType int, payload []byte) (int, error) {
+	if r := c.recorder.Load(); r != nil {
+		r.record(c, RecordIn, frameType, payload)
+	}
 	switch frameType {
 	case PongMessage:
-		if err := c.handlePong(string(payload)); err != nil {
+		if ka := c.keepalive; ka != nil {
+			ka.receivePong(payload)
+		}
+		if ts := c.timeSync; ts != nil {
+			ts.receivePong(payload)
+		}
+		handled, err := c.controlHandled(frameType, payload)
+		if err != nil {
 			return noFrame, err
 		}
+		if !handled {
+			if err := c.handlePong(string(payload)); err != nil {
+				return noFrame, err
+			}
+		}
 	case PingMessage:
-		if err := c.handlePing(string(payload)); err != nil {
+		if ts := c.timeSync; ts != nil && ts.receivePing(payload) {
+			break
+		}
+		handled, err := c.controlHandled(frameType, payload)
+		if err != nil {
 			return noFrame, err
 		}
+		if !handled {
+			if err := c.handlePing(string(payload)); err != nil {
+				return noFrame, err
+			}
+		}
 	case CloseMessage:
 		closeCode := CloseNoStatusReceived
 		closeText := ""
+		if len(payload) == 1 {
+			return noFrame, c.handleProtocolError("bad close payload length")
+		}
 		if len(payload) >= 2 {
 			closeCode = int(binary.BigEndian.Uint16(payload))
 			if !isValidReceivedCloseCode(closeCode) {
@@ -1095,9 +1431,23 @@ func (c *Conn) processControlFrame(frameType int, payload []byte) (int, error) {
 				return noFrame, c.handleProtocolError("invalid utf8 payload in close frame")
 			}
 		}
-		if err := c.handleClose(closeCode, closeText); err != nil {
+		if c.closeCode.CompareAndSwap(0, int32(closeCode)) {
+			c.closeReason.Store(&closeText)
+		}
+		c.setPeerClose(closeCode, closeText)
+		handled, err := c.controlHandled(frameType, payload)
+		if err != nil {
 			return noFrame, err
 		}
+		if !handled {
+			if err := c.handleClose(closeCode, closeText); err != nil {
+				return noFrame, err
+			}
+		}
+		if c.halfClosed.Load() {
+			// The closing handshake started by CloseWrite is complete.
+			_ = c.Close()
+		}
 		return noFrame, &CloseError{Code: closeCode, Text: closeText}
 	}
 	return frameType, nil
@@ -1109,8 +1459,14 @@ func (c *Conn) advanceFrame() (int, error) {
 		return noFrame, err
 	}
 
+	if c.readPool != nil {
+		if err := c.awaitReadBuffer(); err != nil {
+			return noFrame, err
+		}
+	}
+
 	// 2. Read and parse frame header.
-	frameType, _, mask, err := c.readFrameHeader()
+	frameType, final, mask, err := c.readFrameHeader()
 	if err != nil {
 		return noFrame, err
 	}
@@ -1125,12 +1481,24 @@ func (c *Conn) advanceFrame() (int, error) {
 		return noFrame, err
 	}
 
+	fi := c.inspector.Load()
+	if fi != nil && !isControl(frameType) {
+		c.inspectRead(fi, frameType, final, mask, nil)
+	}
+
 	// 5. For text and binary messages, enforce read limit and return.
 	isDataFrame, err := c.enforceReadLimit(frameType)
 	if err != nil {
 		return noFrame, err
 	}
 	if isDataFrame {
+		c.lastRead.Store(time.Now().UnixNano())
+		if m := c.metrics; m != nil {
+			if frameType != continuationFrame {
+				m.MessageReceived(frameType)
+			}
+			m.BytesReceived(int(c.readRemaining))
+		}
 		return frameType, nil
 	}
 
@@ -1139,6 +1507,9 @@ func (c *Conn) advanceFrame() (int, error) {
 	if err != nil {
 		return noFrame, err
 	}
+	if fi != nil {
+		c.inspectRead(fi, frameType, final, mask, payload)
+	}
 
 	// 7. Process control frame payload.
 	return c.processControlFrame(frameType, payload)
@@ -1148,13 +1519,15 @@ func (c *Conn) handleProtocolError(message string) error {
 	if c == nil {
 		return ErrNilConn
 	}
+	c.log(slog.LevelWarn, "websocket: protocol error", "error", message)
+	c.audit(AuditViolation, "", CloseProtocolError, message)
 	data := FormatCloseMessage(CloseProtocolError, message)
 	if len(data) > maxControlFramePayloadSize {
 		data = data[:maxControlFramePayloadSize]
 	}
 	// Make a best effor to send a close message describing the problem.
 	_ = c.WriteControl(CloseMessage, data, time.Now().Add(writeWait))
-	return errors.New("websocket: " + message)
+	return &ProtocolError{Reason: message}
 }
 
 // NextReader returns the next data message received from the peer. The
@@ -1171,6 +1544,21 @@ func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
 	if c == nil {
 		return 0, nil, ErrNilConn
 	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	messageType, r, err = c.nextReader()
+	if err != nil {
+		return messageType, nil, err
+	}
+	if c.inbound.enabled() {
+		return c.interceptRead(messageType)
+	}
+	return messageType, lockedReader{c, r}, nil
+}
+
+// nextReader advances to the next data message and returns its reader. The
+// caller must hold c.readMu.
+func (c *Conn) nextReader() (messageType int, r io.Reader, err error) {
 	// Close previous reader, only relevant for decompression.
 	if c.reader != nil {
 		c.reader.Close()
@@ -1179,19 +1567,48 @@ func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
 
 	c.messageReader = nil
 	c.readLength = 0
+	c.readFrames = 0
 
 	for c.readErr == nil {
+		if err := c.awaitMessage(); err != nil {
+			if err != ErrConnMigrated {
+				err = c.readError(err)
+			}
+			c.readErr = err
+			break
+		}
 		frameType, err := c.advanceFrame()
 		if err != nil {
-			c.readErr = err
+			c.readErr = c.readError(err)
 			break
 		}
 
 		if frameType == TextMessage || frameType == BinaryMessage {
-			c.messageReader = &messageReader{c}
+			if c.spareReader != nil {
+				c.messageReader, c.spareReader = c.spareReader, nil
+			} else {
+				c.messageReader = &messageReader{c}
+			}
+			if c.readLimiter != nil {
+				ok, err := c.admitMessage(int(c.readRemaining))
+				if err == nil && !ok {
+					err = c.discardMessage(frameType)
+					if err == nil {
+						continue
+					}
+				}
+				if err != nil {
+					c.readErr = err
+					break
+				}
+			}
 			c.reader = c.messageReader
-			if c.readDecompress {
-				c.reader = c.newDecompressionReader(c.reader)
+			if c.cipher != nil {
+				c.reader = &openReader{c: c, r: c.reader, messageType: frameType}
+			}
+			c.reader = c.wrapExtensionReader(c.reader, frameType)
+			if c.strictUTF8 && frameType == TextMessage {
+				c.reader = &utf8Reader{c: c, r: c.reader}
 			}
 			return frameType, c.reader, nil
 		}
@@ -1210,6 +1627,19 @@ func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
 
 type messageReader struct{ c *Conn }
 
+// lockedReader is the reader returned to the application by NextReader. It
+// holds c.readMu while reading.
+type lockedReader struct {
+	c *Conn
+	r io.Reader
+}
+
+func (r lockedReader) Read(b []byte) (int, error) {
+	r.c.readMu.Lock()
+	defer r.c.readMu.Unlock()
+	return r.r.Read(b)
+}
+
 func (r *messageReader) Read(b []byte) (int, error) {
 	c := r.c
 	if c == nil {
@@ -1226,7 +1656,7 @@ func (r *messageReader) Read(b []byte) (int, error) {
 				b = b[:c.readRemaining]
 			}
 			n, err := c.br.Read(b)
-			c.readErr = err
+			c.readErr = c.readError(err)
 			if c.isServer {
 				c.readMaskPos = maskBytes(c.readMaskKey, c.readMaskPos, b[:n])
 			}
@@ -1247,9 +1677,13 @@ func (r *messageReader) Read(b []byte) (int, error) {
 		frameType, err := c.advanceFrame()
 		switch {
 		case err != nil:
-			c.readErr = err
+			c.readErr = c.readError(err)
 		case frameType == TextMessage || frameType == BinaryMessage:
 			c.readErr = errors.New("websocket: internal error, unexpected text or binary in Reader")
+		case c.readLimiter != nil:
+			if _, err := c.chargeRead(0, int(c.readRemaining)); err != nil {
+				c.readErr = err
+			}
 		}
 	}
 
@@ -1403,7 +1837,8 @@ func (c *Conn) SetPongHandler(h func(appData string) error) {
 
 // NetConn returns the underlying connection that is wrapped by c.
 // Note that writing to or reading from this connection directly will corrupt the
-// WebSocket connection.
+// WebSocket connection. To exchange a byte stream with the peer through the
+// WebSocket connection, use UnderlyingStream.
 func (c *Conn) NetConn() net.Conn {
 	if c == nil {
 		return nil
@@ -1446,6 +1881,19 @@ func (c *Conn) SetCompressionLevel(level int) error {
 	return nil
 }
 
+// SetCompressionThreshold sets the minimum payload size in bytes for a message
+// written with WriteMessage or WritePreparedMessage to be compressed. Smaller
+// messages are sent uncompressed because compression adds overhead that is
+// not recovered for small payloads. Messages written with NextWriter are not
+// subject to the threshold. This function is a noop if compression was not
+// negotiated with the peer.
+func (c *Conn) SetCompressionThreshold(n int) {
+	if c == nil {
+		return
+	}
+	c.compressionThreshold = n
+}
+
 // FormatCloseMessage formats closeCode and text as a WebSocket close message.
 // An empty message is returned for code CloseNoStatusReceived.
 func FormatCloseMessage(closeCode int, text string) []byte {
@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxPooledMessageSize is the capacity above which message buffers are not
+// returned to the pool, so that an occasional large message does not pin
+// memory for the lifetime of the process.
+const maxPooledMessageSize = 1 << 20
+
+// minReadGrowth is the minimum number of bytes by which ReadMessageInto grows
+// a buffer that is too small for the message.
+const minReadGrowth = 512
+
+var messageBufferPool = sync.Pool{
+	New: func() interface{} { return &MessageBuffer{buf: make([]byte, 0, 4096)} },
+}
+
+// ReadMessageInto is like ReadMessage, but reads the message into buf. If
+// the message fits in the capacity of buf, then p shares the underlying
+// array of buf and no memory is allocated; otherwise buf is grown as with
+// append. Applications typically pass the p returned by the previous call to
+// reuse the buffer across messages.
+//
+// The contents of buf are overwritten even if an error is returned.
+func (c *Conn) ReadMessageInto(buf []byte) (messageType int, p []byte, err error) {
+	if c == nil {
+		return 0, nil, ErrNilConn
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	var r io.Reader
+	messageType, r, err = c.nextReader()
+	if err != nil {
+		return messageType, buf[:0], err
+	}
+	if c.inbound.enabled() {
+		if messageType, r, err = c.interceptRead(messageType); err != nil {
+			return messageType, buf[:0], err
+		}
+	}
+	if mr := c.messageReader; r == io.Reader(mr) {
+		// The reader is not returned to the application and can be reused
+		// for the next message.
+		defer func() { c.spareReader = mr }()
+	}
+	p, err = readAppend(r, buf[:0])
+	return messageType, p, err
+}
+
+// ReadMessageBuffer is like ReadMessage, but reads the message into a buffer
+// taken from a pool shared by all connections. The application must call
+// Release on the returned buffer when done with the data, and must not use
+// the data after that.
+func (c *Conn) ReadMessageBuffer() (messageType int, b *MessageBuffer, err error) {
+	if c == nil {
+		return 0, nil, ErrNilConn
+	}
+	b = messageBufferPool.Get().(*MessageBuffer)
+	b.released = false
+	messageType, b.buf, err = c.ReadMessageInto(b.buf)
+	if err != nil {
+		b.Release()
+		return messageType, nil, err
+	}
+	return messageType, b, nil
+}
+
+// MessageBuffer holds a message read with ReadMessageBuffer.
+type MessageBuffer struct {
+	buf      []byte
+	released bool
+}
+
+// Bytes returns the message data. The slice is valid until Release is
+// called.
+func (b *MessageBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// Len returns the length of the message data.
+func (b *MessageBuffer) Len() int {
+	return len(b.buf)
+}
+
+// Release returns the buffer to the pool. Calling Release more than once has
+// no effect. The application must not use the buffer after calling Release.
+func (b *MessageBuffer) Release() {
+	if b == nil || b.released {
+		return
+	}
+	b.released = true
+	if cap(b.buf) > maxPooledMessageSize {
+		return
+	}
+	b.buf = b.buf[:0]
+	messageBufferPool.Put(b)
+}
+
+// readAppend reads from r until EOF and appends the data to p.
+func readAppend(r io.Reader, p []byte) ([]byte, error) {
+	for {
+		if len(p) == cap(p) {
+			p = append(p, make([]byte, minReadGrowth)...)[:len(p)]
+		}
+		n, err := r.Read(p[len(p):cap(p)])
+		p = p[:len(p)+n]
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+}
+
+// pooledReadSource is the reader wrapped by a read buffer taken from the
+// pool. It returns the byte read by awaitReadBuffer before reading from the
+// network connection.
+type pooledReadSource struct {
+	conn    net.Conn
+	b       [1]byte
+	pending bool
+}
+
+func (r *pooledReadSource) Read(p []byte) (int, error) {
+	if r.pending && len(p) > 0 {
+		p[0] = r.b[0]
+		r.pending = false
+		return 1, nil
+	}
+	return r.conn.Read(p)
+}
+
+// setReadPool makes the connection hold a read buffer only while a frame is
+// available to read. The current buffer is released before the next frame.
+func (c *Conn) setReadPool(p BufferPool) {
+	c.readPool = p
+	c.readSource.conn = c.conn
+}
+
+// awaitReadBuffer releases the read buffer if it holds no data and waits for
+// the next frame from the peer without holding a buffer. The caller must be
+// between frames.
+func (c *Conn) awaitReadBuffer() error {
+	if c.br != nil {
+		if c.br.Buffered() > 0 {
+			return nil
+		}
+		c.br.Reset(nil)
+		c.readPool.Put(readPoolData{br: c.br})
+		c.br = nil
+	}
+	if _, err := io.ReadFull(c.conn, c.readSource.b[:]); err != nil {
+		if err == io.EOF {
+			err = errUnexpectedEOF
+		}
+		return err
+	}
+	c.readSource.pending = true
+	if rpd, ok := c.readPool.Get().(readPoolData); ok {
+		c.br = rpd.br
+		c.br.Reset(&c.readSource)
+	} else {
+		c.br = bufio.NewReaderSize(&c.readSource, c.readBufSize)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReadMessageInto(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+
+	large := strings.Repeat("x", 10000)
+	for _, m := range []string{"hello", large, "bye"} {
+		if err := wc.WriteMessage(BinaryMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := make([]byte, 0, 64)
+	base := &p[:1][0]
+	var err error
+	var mt int
+	mt, p, err = rc.ReadMessageInto(p)
+	if err != nil || mt != BinaryMessage || string(p) != "hello" {
+		t.Fatalf("ReadMessageInto() = %d, %q, %v", mt, p, err)
+	}
+	if &p[0] != base {
+		t.Error("ReadMessageInto allocated for a message that fits the buffer")
+	}
+	_, p, err = rc.ReadMessageInto(p)
+	if err != nil || string(p) != large {
+		t.Fatalf("ReadMessageInto() returned %d bytes, %v", len(p), err)
+	}
+	_, p, err = rc.ReadMessageInto(p)
+	if err != nil || string(p) != "bye" {
+		t.Fatalf("ReadMessageInto() = %q, %v", p, err)
+	}
+	if _, p, err = rc.ReadMessageInto(p); err == nil || len(p) != 0 {
+		t.Fatalf("ReadMessageInto() at EOF = %q, %v", p, err)
+	}
+}
+
+func TestReadMessageIntoAllocs(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+	msg := strings.Repeat("x", 512)
+	buf.Grow(64 << 10)
+	p := make([]byte, 0, 1024)
+	read := func() {
+		buf.Reset()
+		wc.WriteString(TextMessage, msg)
+		var err error
+		if _, p, err = rc.ReadMessageInto(p); err != nil || len(p) != len(msg) {
+			t.Fatalf("ReadMessageInto() returned %d bytes, %v", len(p), err)
+		}
+	}
+	read()
+	if n := testing.AllocsPerRun(100, read); n != 0 {
+		t.Errorf("ReadMessageInto allocated %v times per message", n)
+	}
+}
+
+func TestReadMessageBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+
+	for _, m := range []string{"one", "two"} {
+		if err := wc.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"one", "two"} {
+		mt, b, err := rc.ReadMessageBuffer()
+		if err != nil || mt != TextMessage {
+			t.Fatalf("ReadMessageBuffer() = %d, %v", mt, err)
+		}
+		if string(b.Bytes()) != want || b.Len() != len(want) {
+			t.Fatalf("got %q, want %q", b.Bytes(), want)
+		}
+		b.Release()
+		b.Release()
+	}
+	if _, b, err := rc.ReadMessageBuffer(); err == nil || b != nil {
+		t.Fatalf("ReadMessageBuffer() at EOF = %v, %v", b, err)
+	}
+}
+
+func TestReadBufferPool(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	for _, m := range []string{"hello", strings.Repeat("x", 3000), "bye"} {
+		if err := wc.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var pool simpleBufferPool
+	rc := newConn(fakeNetConn{Reader: &buf}, false, 1024, 1024, nil, nil, nil)
+	rc.setReadPool(&pool)
+	initial := rc.br
+
+	for _, want := range []string{"hello", strings.Repeat("x", 3000), "bye"} {
+		_, p, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() returned %v", err)
+		}
+		if string(p) != want {
+			t.Fatalf("got %d bytes, want %d", len(p), len(want))
+		}
+		if rc.br != initial {
+			t.Fatal("read buffer not reused from the pool")
+		}
+	}
+
+	if _, _, err := rc.ReadMessage(); err == nil {
+		t.Fatal("ReadMessage() at EOF returned nil error")
+	}
+	if rc.br != nil {
+		t.Fatal("read buffer held while waiting for the next frame")
+	}
+	if rpd, ok := pool.v.(readPoolData); !ok || rpd.br != initial {
+		t.Fatal("read buffer not returned to the pool")
+	}
+}
+
+func TestReadBufferPoolServer(t *testing.T) {
+	var pool sync.Pool
+	s := newServer(t)
+	defer s.Close()
+
+	d := cstDialer
+	d.ReadBufferPool = &pool
+	ws, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+}
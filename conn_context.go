@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// aLongTimeAgo is a non-zero time in the past used to interrupt blocked
+// network operations.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ReadMessageContext is like ReadMessage, but uses the deadline of ctx as the
+// read deadline and interrupts the read when ctx is done. The read deadline
+// set with SetReadDeadline is cleared when ReadMessageContext returns.
+//
+// If ctx is done before the message is read, ReadMessageContext returns
+// ctx.Err(). As with read timeouts, the connection state is corrupt after an
+// interrupted read and all future reads will return an error.
+func (c *Conn) ReadMessageContext(ctx context.Context) (messageType int, p []byte, err error) {
+	if c == nil {
+		return 0, nil, ErrNilConn
+	}
+	if err := ctx.Err(); err != nil {
+		return noFrame, nil, err
+	}
+	if c.conn == nil {
+		return noFrame, nil, ErrNilNetConn
+	}
+	conn := c.conn
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return noFrame, nil, err
+		}
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetReadDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+
+	messageType, p, err = c.ReadMessage()
+
+	if !stop() {
+		<-interrupted
+		if err != nil {
+			return messageType, nil, ctx.Err()
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return messageType, p, deadlineErr(ctx, err)
+}
+
+// WriteMessageContext is like WriteMessage, but uses the deadline of ctx as
+// the write deadline and interrupts the write when ctx is done. The write
+// deadline set with SetWriteDeadline is restored when WriteMessageContext
+// returns.
+//
+// If ctx is done before the message is written, WriteMessageContext returns
+// ctx.Err(). As with write timeouts, the connection state is corrupt after an
+// interrupted write and all future writes will return an error.
+//
+// If the write queue is enabled, WriteMessageContext waits for room in the
+// queue until ctx is done and does not wait for the message to be written.
+func (c *Conn) WriteMessageContext(ctx context.Context, messageType int, data []byte) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, ok, err := c.interceptWrite(messageType, data)
+	if !ok {
+		return err
+	}
+	if q := c.writeQueue; q != nil {
+		return q.enqueueContext(ctx, queuedMessage{messageType: messageType, data: append([]byte(nil), data...)})
+	}
+	if c.conn == nil {
+		return ErrNilNetConn
+	}
+	conn := c.conn
+
+	prevDeadline := c.writeDeadline
+	if deadline, ok := ctx.Deadline(); ok && (prevDeadline.IsZero() || deadline.Before(prevDeadline)) {
+		c.writeDeadline = deadline
+	}
+	defer func() { c.writeDeadline = prevDeadline }()
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetWriteDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+
+	err = c.writeMessage(messageType, data)
+
+	if !stop() {
+		<-interrupted
+		if err != nil {
+			return ctx.Err()
+		}
+	}
+	return deadlineErr(ctx, err)
+}
+
+// deadlineErr returns context.DeadlineExceeded if err is a timeout caused by
+// the deadline of ctx. The network deadline can expire slightly before the
+// context is done.
+func deadlineErr(ctx context.Context, err error) error {
+	var ne net.Error
+	if err == nil || !errors.As(err, &ne) || !ne.Timeout() {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadMessageContext(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	go func() { _ = c.WriteMessage(TextMessage, []byte("hello")) }()
+	_, p, err := s.ReadMessageContext(context.Background())
+	if err != nil || string(p) != "hello" {
+		t.Fatalf("ReadMessageContext() = %q, %v", p, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, _, err := s.ReadMessageContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadMessageContext() returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReadMessageContextDeadline(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := s.ReadMessageContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReadMessageContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWriteMessageContext(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	go func() { _, _, _ = c.ReadMessage() }()
+	if err := s.WriteMessageContext(context.Background(), TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("WriteMessageContext() returned %v", err)
+	}
+
+	// Nobody reads from the pipe, so the write blocks until ctx is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := s.WriteMessageContext(ctx, TextMessage, []byte("blocked")); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteMessageContext() returned %v, want %v", err, context.Canceled)
+	}
+}
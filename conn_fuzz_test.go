@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// frame returns a frame with the header byte b0 and the payload masked with
+// a zero key when mask is set.
+func frame(b0 byte, mask bool, payload []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(b0)
+	var m byte
+	if mask {
+		m = maskBit
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b.WriteByte(m | byte(n))
+	case n <= 65535:
+		b.WriteByte(m | 126)
+		_ = binary.Write(&b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(m | 127)
+		_ = binary.Write(&b, binary.BigEndian, uint64(n))
+	}
+	if mask {
+		b.Write([]byte{0, 0, 0, 0})
+	}
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// FuzzReadMessage reads the messages of arbitrary input on a server
+// connection with compression negotiated. The parser must fail cleanly
+// rather than panic or loop.
+func FuzzReadMessage(f *testing.F) {
+	for _, seed := range [][]byte{
+		frame(finalBit|TextMessage, true, []byte("hello")),
+		append(frame(TextMessage, true, []byte("he")), frame(finalBit|continuationFrame, true, []byte("llo"))...),
+		frame(finalBit|BinaryMessage, true, bytes.Repeat([]byte{1}, 200)),
+		frame(finalBit|PingMessage, true, []byte("ping")),
+		frame(finalBit|CloseMessage, true, FormatCloseMessage(CloseNormalClosure, "bye")),
+		frame(finalBit|rsv1Bit|TextMessage, true, []byte{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00}),
+		{finalBit | BinaryMessage, maskBit | 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := newTestConn(bytes.NewReader(data), io.Discard, true)
+		c.newDecompressionReader = decompressNoContextTakeover
+		c.SetReadLimit(1 << 20)
+		for i := 0; i < 100; i++ {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// FuzzRoundTrip writes a message in fragments and checks that it reads back
+// unchanged.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(true, []byte("hello"), uint16(2))
+	f.Add(false, bytes.Repeat([]byte{0xff}, 70000), uint16(4096))
+	f.Add(false, []byte{}, uint16(0))
+	f.Fuzz(func(t *testing.T, text bool, payload []byte, chunk uint16) {
+		mt := BinaryMessage
+		if text {
+			mt = TextMessage
+		}
+		var b bytes.Buffer
+		wc := newTestConn(nil, &b, false)
+		w, err := wc.NextWriter(mt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p := payload; len(p) > 0; {
+			n := len(p)
+			if chunk > 0 && int(chunk) < n {
+				n = int(chunk)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rc := newTestConn(&b, nil, true)
+		gotType, got, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotType != mt || !bytes.Equal(got, payload) {
+			t.Fatalf("read type %d, %d bytes; want type %d, %d bytes", gotType, len(got), mt, len(payload))
+		}
+	})
+}
+
+// TestStrictFraming checks the framing errors required by RFC 6455 section
+// 5 and exercised by the Autobahn test suite.
+func TestStrictFraming(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		compress bool
+		input    []byte
+		err      string
+	}{
+		{"RSV1 without compression", false, frame(finalBit|rsv1Bit|TextMessage, true, nil), "RSV1 set"},
+		{"RSV1 on ping", true, frame(finalBit|rsv1Bit|PingMessage, true, nil), "RSV1 set on non-first frame"},
+		{"RSV1 on continuation", true, append(frame(BinaryMessage, true, []byte("a")), frame(finalBit|rsv1Bit|continuationFrame, true, []byte("b"))...), "RSV1 set on non-first frame"},
+		{"RSV2", false, frame(finalBit|rsv2Bit|BinaryMessage, true, nil), "RSV2 set"},
+		{"RSV3", false, frame(finalBit|rsv3Bit|BinaryMessage, true, nil), "RSV3 set"},
+		{"long ping", false, frame(finalBit|PingMessage, true, make([]byte, 126)), "len > 125 for control"},
+		{"fragmented ping", false, frame(PingMessage, true, nil), "FIN not set on control"},
+		{"one byte close", false, frame(finalBit|CloseMessage, true, []byte{3}), "bad close payload length"},
+		{"invalid close code", false, frame(finalBit|CloseMessage, true, []byte{3, 237}), "bad close code 1005"},
+		{"invalid utf8 close text", false, frame(finalBit|CloseMessage, true, []byte{3, 232, 0xff}), "invalid utf8 payload in close frame"},
+		{"bad opcode", false, frame(finalBit|3, true, nil), "bad opcode 3"},
+		{"unmasked", false, frame(finalBit|TextMessage, false, nil), "bad MASK"},
+	} {
+		var out bytes.Buffer
+		c := newTestConn(bytes.NewReader(tc.input), &out, true)
+		if tc.compress {
+			c.newDecompressionReader = decompressNoContextTakeover
+		}
+		var err error
+		for err == nil {
+			_, _, err = c.ReadMessage()
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+			continue
+		}
+		// The connection fails with a protocol error close message.
+		if p := out.Bytes(); len(p) < 4 || p[0] != finalBit|CloseMessage || binary.BigEndian.Uint16(p[2:]) != CloseProtocolError {
+			t.Errorf("%s: wrote % x, want protocol error close", tc.name, p)
+		}
+	}
+}
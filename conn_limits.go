@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"sync"
+)
+
+var (
+	// ErrTooManyConnections is reported to Upgrader.OnConnectionLimit when an
+	// upgrade is rejected because of MaxConnections.
+	ErrTooManyConnections = errors.New("websocket: too many connections")
+
+	// ErrTooManyConnectionsPerIP is reported to Upgrader.OnConnectionLimit
+	// when an upgrade is rejected because of MaxConnectionsPerIP.
+	ErrTooManyConnectionsPerIP = errors.New("websocket: too many connections from client")
+)
+
+// connCounter counts the connections of an upgrader for MaxConnections and
+// MaxConnectionsPerIP. A slot is taken before the handshake and released
+// when the handshake fails or the connection is closed.
+type connCounter struct {
+	mu     sync.Mutex
+	total  int
+	perKey map[string]int
+}
+
+// counterMu guards the creation of the counter of each upgrader, so that
+// Upgrader has no lock and can be copied before use.
+var counterMu sync.Mutex
+
+func (u *Upgrader) counter() *connCounter {
+	counterMu.Lock()
+	defer counterMu.Unlock()
+	if u.conns == nil {
+		u.conns = &connCounter{perKey: make(map[string]int)}
+	}
+	return u.conns
+}
+
+// reserveConn takes a connection slot for the request from the client
+// address ip. It returns a nil release function when the upgrader has no
+// connection limits.
+func (u *Upgrader) reserveConn(r *http.Request, ip netip.Addr) (release func(), err error) {
+	if u.MaxConnections <= 0 && u.MaxConnectionsPerIP <= 0 {
+		return nil, nil
+	}
+	key := ""
+	if u.MaxConnectionsPerIP > 0 {
+		if u.ConnectionKey != nil {
+			key = u.ConnectionKey(r)
+		} else if ip.IsValid() {
+			key = ip.String()
+		} else {
+			key = r.RemoteAddr
+		}
+	}
+	cc := u.counter()
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if u.MaxConnections > 0 && cc.total >= u.MaxConnections {
+		return nil, ErrTooManyConnections
+	}
+	if key != "" && cc.perKey[key] >= u.MaxConnectionsPerIP {
+		return nil, ErrTooManyConnectionsPerIP
+	}
+	cc.total++
+	if key != "" {
+		cc.perKey[key]++
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cc.mu.Lock()
+			defer cc.mu.Unlock()
+			cc.total--
+			if key != "" {
+				if cc.perKey[key]--; cc.perKey[key] <= 0 {
+					delete(cc.perKey, key)
+				}
+			}
+		})
+	}, nil
+}
+
+// Connections returns the number of connections counted against
+// MaxConnections, including the handshakes in progress. It returns zero if
+// the upgrader has no connection limits.
+func (u *Upgrader) Connections() int {
+	counterMu.Lock()
+	cc := u.conns
+	counterMu.Unlock()
+	if cc == nil {
+		return 0
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.total
+}
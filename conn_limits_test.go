@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLimitServer returns a server upgrading with u and the WebSocket URL
+// of the server. The server connections echo until closed by the client.
+func newLimitServer(t *testing.T, u *Upgrader) string {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// waitConnections waits for the upgrader to count n connections.
+func waitConnections(t *testing.T, u *Upgrader, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for u.Connections() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections, want %d", u.Connections(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		limits []error
+	)
+	u := &Upgrader{
+		MaxConnections: 2,
+		OnConnectionLimit: func(r *http.Request, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			limits = append(limits, err)
+		},
+	}
+	url := newLimitServer(t, u)
+
+	var conns []*Conn
+	for i := 0; i < 2; i++ {
+		c, _, err := DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial %d: %v", i, err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	_, resp, err := DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Dial over the limit returned %v, %v", resp, err)
+	}
+	mu.Lock()
+	if len(limits) != 1 || limits[0] != ErrTooManyConnections {
+		t.Errorf("OnConnectionLimit got %v", limits)
+	}
+	mu.Unlock()
+
+	// Closing a connection frees its slot.
+	conns[0].Close()
+	waitConnections(t, u, 1)
+	c, _, err := DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial after Close: %v", err)
+	}
+	c.Close()
+
+	// Failed handshakes do not hold a slot.
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	waitConnections(t, u, 1)
+}
+
+func TestMaxConnectionsReleasedByClose(t *testing.T) {
+	u := &Upgrader{MaxConnections: 1}
+	counts := make(chan [2]int, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		before := u.Connections()
+		c.Close()
+		counts <- [2]int{before, u.Connections()}
+	}))
+	defer s.Close()
+
+	c, _, err := DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// The slot is released by Close itself, not by a goroutine waiting for
+	// the connection to close.
+	if n := <-counts; n != [2]int{1, 0} {
+		t.Fatalf("%d connections before Close and %d after, want 1 and 0", n[0], n[1])
+	}
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	u := &Upgrader{
+		MaxConnectionsPerIP: 1,
+		ConnectionKey: func(r *http.Request) string {
+			return r.Header.Get("X-Forwarded-For")
+		},
+	}
+	url := newLimitServer(t, u)
+
+	a := http.Header{"X-Forwarded-For": {"192.0.2.1"}}
+	b := http.Header{"X-Forwarded-For": {"192.0.2.2"}}
+	c1, _, err := DefaultDialer.Dial(url, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, _, err := DefaultDialer.Dial(url, b)
+	if err != nil {
+		t.Fatalf("Dial from another client: %v", err)
+	}
+	defer c2.Close()
+	_, resp, err := DefaultDialer.Dial(url, a)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Dial over the client limit returned %v, %v", resp, err)
+	}
+
+	// Requests without a key are not limited per client.
+	for i := 0; i < 2; i++ {
+		c, _, err := DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial without key: %v", err)
+		}
+		defer c.Close()
+	}
+}
+
+func TestReserveConnDefaultKey(t *testing.T) {
+	u := &Upgrader{MaxConnectionsPerIP: 1}
+	r1 := &http.Request{RemoteAddr: "192.0.2.1:1000"}
+	r2 := &http.Request{RemoteAddr: "192.0.2.1:2000"}
+	ip := parseRemoteIP(r1.RemoteAddr)
+	release, err := u.reserveConn(r1, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.reserveConn(r2, ip); err != ErrTooManyConnectionsPerIP {
+		t.Fatalf("reserveConn from the same IP returned %v", err)
+	}
+	release()
+	release()
+	if n := u.Connections(); n != 0 {
+		t.Fatalf("%d connections after release, want 0", n)
+	}
+	if _, err := u.reserveConn(r2, ip); err != nil {
+		t.Fatalf("reserveConn after release returned %v", err)
+	}
+
+	var unlimited Upgrader
+	if release, err := unlimited.reserveConn(r1, ip); release != nil || err != nil {
+		t.Errorf("reserveConn without limits returned %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package websocket
+
+// Set stores a value under the key in the connection's metadata. The metadata
+// methods are safe to call concurrently with all other methods.
+func (c *Conn) Set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.metaMu.Lock()
+	if c.meta == nil {
+		c.meta = make(map[string]interface{})
+	}
+	c.meta[key] = value
+	c.metaMu.Unlock()
+}
+
+// Get returns the value stored under the key and whether the key is present.
+func (c *Conn) Get(key string) (value interface{}, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.metaMu.RLock()
+	value, ok = c.meta[key]
+	c.metaMu.RUnlock()
+	return value, ok
+}
+
+// Delete removes the key from the connection's metadata.
+func (c *Conn) Delete(key string) {
+	if c == nil {
+		return
+	}
+	c.metaMu.Lock()
+	delete(c.meta, key)
+	c.metaMu.Unlock()
+}
+
+// Range calls f for each key and value in the connection's metadata. If f
+// returns false, Range stops the iteration. Range iterates over a snapshot of
+// the metadata, so f may call the other metadata methods.
+func (c *Conn) Range(f func(key string, value interface{}) bool) {
+	if c == nil {
+		return
+	}
+	c.metaMu.RLock()
+	keys := make([]string, 0, len(c.meta))
+	values := make([]interface{}, 0, len(c.meta))
+	for k, v := range c.meta {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	c.metaMu.RUnlock()
+	for i, k := range keys {
+		if !f(k, values[i]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConnMetadata(t *testing.T) {
+	c := newTestConn(nil, nil, true)
+	if _, ok := c.Get("user"); ok {
+		t.Fatal("Get on empty metadata returned ok")
+	}
+	c.Set("user", "alice")
+	c.Set("room", "lobby")
+	if v, ok := c.Get("user"); !ok || v != "alice" {
+		t.Fatalf("Get(user) = %v, %v", v, ok)
+	}
+
+	seen := map[string]interface{}{}
+	c.Range(func(k string, v interface{}) bool {
+		seen[k] = v
+		// Modifying the metadata from f must not deadlock.
+		c.Set(k+"-seen", true)
+		return true
+	})
+	if len(seen) != 2 || seen["room"] != "lobby" {
+		t.Fatalf("Range saw %v", seen)
+	}
+
+	c.Delete("user")
+	if _, ok := c.Get("user"); ok {
+		t.Fatal("Get after Delete returned ok")
+	}
+
+	n := 0
+	c.Range(func(string, interface{}) bool { n++; return false })
+	if n != 1 {
+		t.Fatalf("Range called f %d times after returning false", n)
+	}
+}
+
+func TestConnMetadataConcurrent(t *testing.T) {
+	c := newTestConn(nil, nil, true)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprint(i)
+			for j := 0; j < 100; j++ {
+				c.Set(key, j)
+				c.Get(key)
+				c.Range(func(string, interface{}) bool { return true })
+			}
+			c.Delete(key)
+		}(i)
+	}
+	wg.Wait()
+}
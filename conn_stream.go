@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var errTextInStream = errors.New("websocket: text message in binary stream")
+
+// UnderlyingStream returns a net.Conn that exchanges a byte stream with the
+// peer over binary messages. Use UnderlyingStream to run stream protocols
+// such as MQTT over WebSocket with libraries that expect a net.Conn; MQTT
+// clients negotiate the "mqtt" subprotocol. The stream also tunnels other
+// protocols, such as SSH or a database protocol, through proxies and
+// firewalls that only pass HTTP, by copying it to and from a TCP connection.
+//
+// Each Write is sent as one binary message, and Read returns the data of the
+// received binary messages in order, ignoring the message boundaries. A text
+// message fails Read. Read returns io.EOF when the peer closes the connection
+// with CloseNormalClosure or CloseGoingAway, or without a status code as
+// browsers do by default. Close sends a close message and closes the
+// connection. As with c, a read timeout is permanent.
+//
+// Unlike c, the returned net.Conn supports one concurrent Read and any
+// number of concurrent Writes. The application must not read from or write
+// to c directly while using the stream.
+func (c *Conn) UnderlyingStream() net.Conn {
+	if c == nil {
+		return nil
+	}
+	return &streamConn{c: c}
+}
+
+// streamConn is the net.Conn returned by UnderlyingStream.
+type streamConn struct {
+	c *Conn
+
+	readMu sync.Mutex
+	r      io.Reader
+	err    error
+
+	writeMu sync.Mutex
+}
+
+func (s *streamConn) Read(p []byte) (int, error) {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	for s.err == nil {
+		if s.r == nil {
+			mt, r, err := s.c.NextReader()
+			switch {
+			case IsCloseError(err, CloseNormalClosure, CloseGoingAway, CloseNoStatusReceived):
+				s.err = io.EOF
+			case err != nil:
+				s.err = err
+			case mt != BinaryMessage:
+				s.err = errTextInStream
+			default:
+				s.r = r
+			}
+			continue
+		}
+		n, err := s.r.Read(p)
+		if err == io.EOF {
+			s.r = nil
+			err = nil
+		}
+		if n > 0 || err != nil || len(p) == 0 {
+			return n, err
+		}
+	}
+	return 0, s.err
+}
+
+func (s *streamConn) Write(p []byte) (int, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.c.WriteMessage(BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *streamConn) Close() error {
+	_ = s.c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return s.c.Close()
+}
+
+func (s *streamConn) LocalAddr() net.Addr  { return s.c.LocalAddr() }
+func (s *streamConn) RemoteAddr() net.Addr { return s.c.RemoteAddr() }
+
+func (s *streamConn) SetDeadline(t time.Time) error {
+	if err := s.c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.c.SetWriteDeadline(t)
+}
+
+func (s *streamConn) SetReadDeadline(t time.Time) error  { return s.c.SetReadDeadline(t) }
+func (s *streamConn) SetWriteDeadline(t time.Time) error { return s.c.SetWriteDeadline(t) }
@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func TestUnderlyingStream(t *testing.T) {
+	s, c := newPipeConns()
+	ss, cs := s.UnderlyingStream(), c.UnderlyingStream()
+
+	go func() {
+		for _, p := range []string{"ab", "", "cde"} {
+			if _, err := ss.Write([]byte(p)); err != nil {
+				t.Error(err)
+			}
+		}
+		_ = ss.Close()
+	}()
+
+	p := make([]byte, 4)
+	if _, err := io.ReadFull(cs, p); err != nil || string(p) != "abcd" {
+		t.Fatalf("ReadFull = %q, %v", p, err)
+	}
+	if b, err := io.ReadAll(cs); err != nil || string(b) != "e" {
+		t.Fatalf("ReadAll = %q, %v", b, err)
+	}
+	if n, err := cs.Read(p); n != 0 || err != io.EOF {
+		t.Fatalf("Read after close = %d, %v", n, err)
+	}
+
+	s, c = newPipeConns()
+	go func() { _ = s.WriteMessage(TextMessage, []byte("text")) }()
+	if _, err := c.UnderlyingStream().Read(p); err != errTextInStream {
+		t.Fatalf("Read of text message returned %v, want %v", err, errTextInStream)
+	}
+
+	var nilConn *Conn
+	if nilConn.UnderlyingStream() != nil {
+		t.Fatal("UnderlyingStream of nil Conn is not nil")
+	}
+}
+
+func TestUnderlyingStreamTunnel(t *testing.T) {
+	// The backend is a TCP echo server reached through the tunnel.
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			nc, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				_, _ = io.Copy(nc, nc)
+			}()
+		}
+	}()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		stream := c.UnderlyingStream()
+		defer stream.Close()
+		nc, err := net.Dial("tcp", backend.Addr().String())
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		go func() { _, _ = io.Copy(nc, stream) }()
+		_, _ = io.Copy(stream, nc)
+	}))
+	defer s.Close()
+
+	c, _, err := DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := c.UnderlyingStream()
+	defer stream.Close()
+	_ = stream.SetDeadline(time.Now().Add(5 * time.Second))
+	want := strings.Repeat("tunnel ", 10000)
+	go func() { _, _ = io.WriteString(stream, want) }()
+	p := make([]byte, len(want))
+	if _, err := io.ReadFull(stream, p); err != nil || string(p) != want {
+		t.Fatalf("ReadFull returned %d bytes, %v", len(p), err)
+	}
+
+	// A close without status code ends the stream.
+	ss, cc := newPipeConns()
+	go func() {
+		_ = ss.WriteMessage(CloseMessage, nil)
+		_, _, _ = ss.ReadMessage()
+	}()
+	if _, err := cc.UnderlyingStream().Read(p); err != io.EOF {
+		t.Fatalf("Read after close without status returned %v, want io.EOF", err)
+	}
+}
+
+// mqttBroker serves a single MQTT client over WebSocket, acknowledging
+// subscriptions and sending QoS 0 publications back to the client.
+func mqttBroker(t *testing.T) *httptest.Server {
+	u := Upgrader{Subprotocols: []string{"mqtt"}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn := c.UnderlyingStream()
+		defer conn.Close()
+		for {
+			cp, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			var reply packets.ControlPacket
+			switch p := cp.(type) {
+			case *packets.ConnectPacket:
+				reply = packets.NewControlPacket(packets.Connack)
+			case *packets.SubscribePacket:
+				ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+				ack.MessageID = p.MessageID
+				ack.ReturnCodes = make([]byte, len(p.Topics))
+				reply = ack
+			case *packets.PublishPacket:
+				reply = p
+			case *packets.PingreqPacket:
+				reply = packets.NewControlPacket(packets.Pingresp)
+			case *packets.DisconnectPacket:
+				return
+			}
+			if reply != nil {
+				if err := reply.Write(conn); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}))
+}
+
+func TestUnderlyingStreamMQTT(t *testing.T) {
+	s := mqttBroker(t)
+	defer s.Close()
+
+	opts := mqtt.NewClientOptions().
+		AddBroker("ws" + strings.TrimPrefix(s.URL, "http")).
+		SetClientID("test").
+		SetAutoReconnect(false).
+		SetCustomOpenConnectionFn(func(u *url.URL, _ mqtt.ClientOptions) (net.Conn, error) {
+			d := Dialer{Subprotocols: []string{"mqtt"}}
+			c, _, err := d.Dial(u.String(), nil)
+			if err != nil {
+				return nil, err
+			}
+			return c.UnderlyingStream(), nil
+		})
+	client := mqtt.NewClient(opts)
+	if tok := client.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("Connect: %v", tok.Error())
+	}
+	defer client.Disconnect(0)
+
+	received := make(chan string, 1)
+	tok := client.Subscribe("sensors/1", 0, func(_ mqtt.Client, m mqtt.Message) {
+		received <- m.Topic() + " " + string(m.Payload())
+	})
+	if !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("Subscribe: %v", tok.Error())
+	}
+	if tok := client.Publish("sensors/1", 0, false, "21.5"); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("Publish: %v", tok.Error())
+	}
+	select {
+	case m := <-received:
+		if m != "sensors/1 21.5" {
+			t.Fatalf("received %q", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
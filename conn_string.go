@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"io"
+	"unsafe"
+)
+
+// ReadString is like ReadMessage, but returns the message data as a string.
+// A message read in a single uncompressed frame is read directly into the
+// memory of the string; other messages are read into a pooled buffer and
+// copied once. Empty messages do not allocate.
+//
+// When unsafe strings are enabled with SetUnsafeStrings, the returned string
+// is a view of a buffer owned by the connection instead. See
+// SetUnsafeStrings for the rules that apply to the view.
+func (c *Conn) ReadString() (messageType int, s string, err error) {
+	if c == nil {
+		return 0, "", ErrNilConn
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	var r io.Reader
+	messageType, r, err = c.nextReader()
+	if err != nil {
+		return messageType, "", err
+	}
+	if c.inbound.enabled() {
+		if messageType, r, err = c.interceptRead(messageType); err != nil {
+			return messageType, "", err
+		}
+	}
+	if mr := c.messageReader; r == io.Reader(mr) {
+		// The reader is not returned to the application and can be reused
+		// for the next message.
+		defer func() { c.spareReader = mr }()
+	}
+	switch {
+	case c.unsafeStrings:
+		clear(c.stringBuf)
+		var p []byte
+		p, err = readAppend(r, c.stringBuf[:0])
+		c.stringBuf = p
+		if cap(p) > maxPooledMessageSize {
+			c.stringBuf = nil
+		}
+		return messageType, bytesToString(p), err
+	case c.exactMessageSize(r):
+		if c.readRemaining == 0 {
+			var eof [1]byte
+			_, err = readAppend(r, eof[:0])
+			return messageType, "", err
+		}
+		// The extra byte of capacity lets readAppend see io.EOF without
+		// growing the buffer.
+		var p []byte
+		p, err = readAppend(r, make([]byte, 0, c.readRemaining+1))
+		return messageType, bytesToString(p), err
+	}
+	b := messageBufferPool.Get().(*MessageBuffer)
+	b.released = false
+	b.buf, err = readAppend(r, b.buf[:0])
+	s = string(b.buf)
+	b.Release()
+	return messageType, s, err
+}
+
+// exactMessageSize reports whether r reads a message sent in a single frame
+// without transformation and of bounded size, so that the message can be
+// read into a buffer of size c.readRemaining. The caller must hold c.readMu.
+func (c *Conn) exactMessageSize(r io.Reader) bool {
+	if c.messageReader == nil || r != io.Reader(c.messageReader) || !c.readFinal {
+		return false
+	}
+	return c.readLimit > 0 || c.readRemaining <= maxPooledMessageSize
+}
+
+// SetUnsafeStrings sets whether ReadString returns views of a buffer owned by
+// the connection instead of newly allocated strings, for parsers that
+// tokenize the payload immediately and do not retain the string. Reading a
+// message then allocates nothing once the buffer has grown to the size of the
+// messages.
+//
+// A view is valid only until the next call to a read method of the
+// connection. The next call to ReadString overwrites the bytes of the string,
+// which breaks the immutability of Go strings: the application must not keep
+// the string or substrings of it, for example as map keys, without copying
+// them with strings.Clone. To make misuse apparent rather than silently
+// corrupting data, the bytes of the previous view are zeroed before the next
+// message is read. The buffer is not retained after a message larger than
+// 1 MiB.
+//
+// By default unsafe strings are disabled. SetUnsafeStrings must not be
+// called concurrently with the read methods.
+func (c *Conn) SetUnsafeStrings(enabled bool) {
+	if c == nil {
+		return
+	}
+	c.unsafeStrings = enabled
+	if !enabled {
+		c.stringBuf = nil
+	}
+}
+
+// WriteString is like WriteMessage with the data given as a string. The data
+// is written without being copied to a []byte unless outbound interceptors
+// are registered, since an interceptor may modify the bytes it is given.
+func (c *Conn) WriteString(messageType int, data string) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if c.outbound.enabled() {
+		return c.WriteMessage(messageType, []byte(data))
+	}
+	return c.WriteMessage(messageType, stringToBytes(data))
+}
+
+// bytesToString returns a string sharing the memory of b. The bytes must not
+// be modified while the string is in use.
+func bytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+// stringToBytes returns a slice sharing the memory of s. The bytes must not
+// be modified.
+func stringToBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadWriteString(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+
+	large := strings.Repeat("x", 10000)
+	for _, m := range []string{"hello", "", large} {
+		if err := wc.WriteString(TextMessage, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, _ := wc.NextWriter(BinaryMessage)
+	w.Write([]byte("frag"))
+	w.(*messageWriter).flushFrame(false, nil)
+	w.Write([]byte("mented"))
+	w.Close()
+
+	for _, want := range []struct {
+		messageType int
+		data        string
+	}{{TextMessage, "hello"}, {TextMessage, ""}, {TextMessage, large}, {BinaryMessage, "fragmented"}} {
+		mt, s, err := rc.ReadString()
+		if err != nil || mt != want.messageType || s != want.data {
+			t.Fatalf("ReadString() = %d, %d bytes, %v, want %d, %d bytes", mt, len(s), err, want.messageType, len(want.data))
+		}
+	}
+}
+
+func TestWriteStringOutboundInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+	wc.UseOutbound(func(messageType int, data []byte) ([]byte, error) {
+		for i := range data {
+			data[i]++
+		}
+		return data, nil
+	})
+	s := strings.Repeat("a", 3)
+	if err := wc.WriteString(TextMessage, s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "aaa" {
+		t.Fatalf("WriteString modified its argument to %q", s)
+	}
+	if _, got, _ := rc.ReadString(); got != "bbb" {
+		t.Fatalf("ReadString() = %q, want bbb", got)
+	}
+}
+
+func TestUnsafeStrings(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+	rc.SetUnsafeStrings(true)
+	for _, m := range []string{"first", "second"} {
+		wc.WriteString(TextMessage, m)
+	}
+	_, first, err := rc.ReadString()
+	if err != nil || first != "first" {
+		t.Fatalf("ReadString() = %q, %v", first, err)
+	}
+	if _, s, _ := rc.ReadString(); s != "second" {
+		t.Fatalf("ReadString() = %q, want second", s)
+	}
+	if first == "first" {
+		t.Fatal("view of the previous message not overwritten")
+	}
+}
+
+func TestReadStringAllocs(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+	rc.SetUnsafeStrings(true)
+	msg := strings.Repeat("x", 512)
+	buf.Grow(64 << 10)
+	read := func() {
+		buf.Reset()
+		wc.WriteString(TextMessage, msg)
+		if _, s, err := rc.ReadString(); err != nil || len(s) != len(msg) {
+			t.Fatalf("ReadString() returned %d bytes, %v", len(s), err)
+		}
+	}
+	read()
+	if n := testing.AllocsPerRun(100, read); n != 0 {
+		t.Errorf("ReadString with unsafe strings allocated %v times per message", n)
+	}
+	rc.SetUnsafeStrings(false)
+	if n := testing.AllocsPerRun(100, read); n != 1 {
+		t.Errorf("ReadString allocated %v times per message, want 1", n)
+	}
+}
+
+func BenchmarkReadString(b *testing.B) {
+	msg := strings.Repeat("x", 512)
+	for _, bm := range []struct {
+		name string
+		read func(c *Conn) (int, error)
+	}{
+		{"ReadMessage", func(c *Conn) (int, error) {
+			_, p, err := c.ReadMessage()
+			return len(p), err
+		}},
+		{"ReadString", func(c *Conn) (int, error) {
+			_, s, err := c.ReadString()
+			return len(s), err
+		}},
+		{"ReadStringUnsafe", func(c *Conn) (int, error) {
+			c.SetUnsafeStrings(true)
+			_, s, err := c.ReadString()
+			return len(s), err
+		}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var buf bytes.Buffer
+			wc := newTestConn(nil, &buf, true)
+			rc := newTestConn(&buf, nil, false)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(msg)))
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				wc.WriteString(TextMessage, msg)
+				if _, err := bm.read(rc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
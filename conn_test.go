@@ -18,7 +18,7 @@ import (
 	"time"
 )
 
-var _ net.Error = errWriteTimeout
+var _ net.Error = ErrWriteTimeout
 
 type fakeNetConn struct {
 	io.Reader
@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrConnManagerClosed is returned by ConnManager.Add after Shutdown was
+// called.
+var ErrConnManagerClosed = errors.New("websocket: connection manager shut down")
+
+// ConnManager tracks open connections so that they can be drained when the
+// server stops.
+//
+// Connections are added with Add or by setting the ConnManager field of
+// Upgrader or FastHTTPUpgrader. A connection is removed when it is closed with
+// Conn.Close or when Remove is called.
+//
+// It is safe to call ConnManager's methods concurrently. The zero value is
+// ready to use.
+type ConnManager struct {
+	// CloseCode specifies the close code sent to each connection by Shutdown.
+	// If zero, CloseGoingAway is used.
+	CloseCode int
+
+	// CloseReason specifies the close reason sent to each connection by
+	// Shutdown.
+	CloseReason string
+
+	mu       sync.Mutex
+	conns    map[*Conn]chan struct{}
+	shutdown bool
+	idle     chan struct{}
+}
+
+// Add starts tracking the connection. Add returns ErrConnManagerClosed if
+// Shutdown was called.
+func (m *ConnManager) Add(c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shutdown {
+		return ErrConnManagerClosed
+	}
+	if _, ok := m.conns[c]; ok {
+		return nil
+	}
+	if m.conns == nil {
+		m.conns = make(map[*Conn]chan struct{})
+	}
+	removed := make(chan struct{})
+	m.conns[c] = removed
+	if c.closed != nil {
+		go func() {
+			select {
+			case <-c.closed:
+				m.Remove(c)
+			case <-removed:
+			}
+		}()
+	}
+	return nil
+}
+
+// Remove stops tracking the connection. Remove does not close the
+// connection.
+func (m *ConnManager) Remove(c *Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed, ok := m.conns[c]
+	if !ok {
+		return
+	}
+	delete(m.conns, c)
+	close(removed)
+	if len(m.conns) == 0 && m.idle != nil {
+		close(m.idle)
+		m.idle = nil
+	}
+}
+
+// Len returns the number of tracked connections.
+func (m *ConnManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
+
+// isShutdown reports whether Shutdown was called.
+func (m *ConnManager) isShutdown() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.shutdown
+}
+
+// Shutdown gracefully closes all tracked connections. Shutdown sends a close
+// message to each connection and waits for the connections to close. The
+// application's read loop receives the peer's close message as a *CloseError
+// and is expected to close the connection in response.
+//
+// If the context expires before all connections are closed, Shutdown closes
+// the remaining network connections and returns the context's error.
+// Otherwise, Shutdown returns nil.
+//
+// Once Shutdown has been called, Add returns ErrConnManagerClosed and
+// upgraders using the manager reject new connections.
+func (m *ConnManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.shutdown = true
+	conns := make([]*Conn, 0, len(m.conns))
+	for c := range m.conns {
+		conns = append(conns, c)
+	}
+	idle := m.idle
+	if idle == nil {
+		idle = make(chan struct{})
+		if len(m.conns) == 0 {
+			close(idle)
+		} else {
+			m.idle = idle
+		}
+	}
+	m.mu.Unlock()
+
+	code := m.CloseCode
+	if code == 0 {
+		code = CloseGoingAway
+	}
+	msg := FormatCloseMessage(code, m.CloseReason)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(writeWait)
+	}
+	for _, c := range conns {
+		go func(c *Conn) {
+			if err := c.WriteControl(CloseMessage, msg, deadline); err != nil {
+				// The close handshake cannot complete.
+				_ = c.Close()
+				m.Remove(c)
+			}
+		}(c)
+	}
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	conns = conns[:0]
+	for c := range m.conns {
+		conns = append(conns, c)
+	}
+	m.mu.Unlock()
+	for _, c := range conns {
+		_ = c.Close()
+		m.Remove(c)
+	}
+	return ctx.Err()
+}
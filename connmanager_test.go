@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newConnManagerServer(t *testing.T, m *ConnManager) *httptest.Server {
+	u := Upgrader{ConnManager: m}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestConnManagerShutdown(t *testing.T) {
+	var m ConnManager
+	m.CloseReason = "bye"
+	s := newConnManagerServer(t, &m)
+	defer s.Close()
+
+	var clients []*Conn
+	for i := 0; i < 3; i++ {
+		c, _, err := DefaultDialer.Dial(makeWsProto(s.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+	}
+	for deadline := time.Now().Add(time.Second); m.Len() != 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %d, want 3", m.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- m.Shutdown(ctx)
+	}()
+
+	for _, c := range clients {
+		_, _, err := c.ReadMessage()
+		var ce *CloseError
+		if !errors.As(err, &ce) || ce.Code != CloseGoingAway || ce.Text != "bye" {
+			t.Fatalf("ReadMessage returned %v, want close error", err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("Len() = %d after Shutdown", m.Len())
+	}
+
+	_, resp, err := DefaultDialer.Dial(makeWsProto(s.URL), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Dial after Shutdown returned %v, %v", resp, err)
+	}
+}
+
+func TestConnManagerShutdownTimeout(t *testing.T) {
+	var m ConnManager
+	server, client := newPipeConns()
+	if err := m.Add(server); err != nil {
+		t.Fatal(err)
+	}
+	// Read the close message on the client but never respond.
+	client.SetCloseHandler(func(int, string) error { return nil })
+	go func() {
+		for {
+			if _, _, err := client.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("Len() = %d after Shutdown", m.Len())
+	}
+	if err := m.Add(server); err != ErrConnManagerClosed {
+		t.Fatalf("Add returned %v, want %v", err, ErrConnManagerClosed)
+	}
+}
+
+func TestConnManagerRemoveOnClose(t *testing.T) {
+	var m ConnManager
+	server, _ := newPipeConns()
+	_ = m.Add(server)
+	server.Close()
+	for deadline := time.Now().Add(time.Second); m.Len() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not removed after Close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,61 @@
+package websocket
+
+// ControlHandler observes or answers a control message read from the peer.
+// The messageType argument is PingMessage, PongMessage or CloseMessage, and
+// data is the application data of the message, valid only during the call.
+//
+// If the handler returns handled false, the message is then passed to the
+// handler set for its type with SetPingHandler, SetPongHandler or
+// SetCloseHandler, whose defaults answer pings with pongs and close messages
+// with close messages. If the handler returns handled true, that handler is
+// not called and answering the message, if at all, is up to the application.
+// A non-nil error is returned by the read method that read the message.
+type ControlHandler func(messageType int, data []byte) (handled bool, err error)
+
+// SetControlHandler sets a handler called with every control message read
+// from the peer before the handler set for its type, so that applications can
+// implement their own liveness or heartbeat schemes. A nil handler restores
+// the default behavior.
+//
+// Pings can carry up to 125 bytes of application data, such as a sequence
+// number or a timestamp, that the peer returns in its pong:
+//
+//	c.SetControlHandler(func(messageType int, data []byte) (bool, error) {
+//		if messageType == websocket.PongMessage {
+//			heartbeat.Ack(data)
+//		}
+//		return false, nil
+//	})
+//	err := c.WriteControl(websocket.PingMessage, heartbeat.Next(), deadline)
+//
+// Close messages are validated before the handler is called, and the read
+// methods return a CloseError whether or not the handler handles the message.
+// A handler that handles a close message should send the close message back
+// to the peer as the default close handler does.
+//
+// The handler is called from the NextReader, ReadMessage and message reader
+// Read methods. The application must read the connection to process control
+// messages as described in the section on Control Messages above.
+func (c *Conn) SetControlHandler(h ControlHandler) {
+	if c == nil {
+		return
+	}
+	c.handleControl = h
+}
+
+// ControlHandler returns the current control handler, nil if none is set.
+func (c *Conn) ControlHandler() ControlHandler {
+	if c == nil {
+		return nil
+	}
+	return c.handleControl
+}
+
+// controlHandled runs the control handler on a control message and reports
+// whether the message was handled.
+func (c *Conn) controlHandled(messageType int, data []byte) (bool, error) {
+	if c.handleControl == nil {
+		return false, nil
+	}
+	return c.handleControl(messageType, data)
+}
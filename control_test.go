@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// readControl reads c until the handler receives a control message of the
+// given type or the read fails.
+func readControl(c *Conn, messageType int) chan string {
+	got := make(chan string, 1)
+	c.SetControlHandler(func(mt int, data []byte) (bool, error) {
+		if mt == messageType {
+			got <- string(data)
+		}
+		return false, nil
+	})
+	go func() {
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				close(got)
+				return
+			}
+		}
+	}()
+	return got
+}
+
+func TestControlHandlerObserves(t *testing.T) {
+	s, c := newTCPConns(t)
+	defer s.Close()
+	defer c.Close()
+	pings := readControl(s, PingMessage)
+	pongs := readControl(c, PongMessage)
+
+	if err := c.WriteControl(PingMessage, []byte("seq-1"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-pings; p != "seq-1" {
+		t.Fatalf("server observed ping %q, want seq-1", p)
+	}
+	// The default ping handler still answers the ping.
+	if p := <-pongs; p != "seq-1" {
+		t.Fatalf("client observed pong %q, want seq-1", p)
+	}
+}
+
+func TestControlHandlerAnswers(t *testing.T) {
+	s, c := newTCPConns(t)
+	defer s.Close()
+	defer c.Close()
+	s.SetControlHandler(func(messageType int, data []byte) (bool, error) {
+		if messageType != PingMessage {
+			return false, nil
+		}
+		reply := append([]byte("ack:"), data...)
+		return true, s.WriteControl(PongMessage, reply, time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := s.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	pongs := readControl(c, PongMessage)
+
+	for _, seq := range []string{"1", "2"} {
+		if err := c.WriteControl(PingMessage, []byte(seq), time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if p := <-pongs; p != "ack:"+seq {
+			t.Fatalf("client read pong %q, want only the application's answer ack:%s", p, seq)
+		}
+	}
+}
+
+func TestControlHandlerError(t *testing.T) {
+	s, c := newTCPConns(t)
+	defer s.Close()
+	defer c.Close()
+	errStale := errors.New("stale heartbeat")
+	s.SetControlHandler(func(messageType int, data []byte) (bool, error) {
+		return false, errStale
+	})
+	if err := c.WriteControl(PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := s.ReadMessage(); err != errStale {
+		t.Fatalf("ReadMessage returned %v, want %v", err, errStale)
+	}
+}
+
+func TestControlHandlerClose(t *testing.T) {
+	s, c := newTCPConns(t)
+	defer s.Close()
+	defer c.Close()
+	var closeData string
+	s.SetControlHandler(func(messageType int, data []byte) (bool, error) {
+		if messageType == CloseMessage {
+			closeData = string(data)
+			return true, nil
+		}
+		return false, nil
+	})
+	s.SetCloseHandler(func(int, string) error {
+		t.Error("close handler called for a handled close message")
+		return nil
+	})
+	msg := FormatCloseMessage(CloseGoingAway, "bye")
+	if err := c.WriteControl(CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := s.ReadMessage()
+	if !IsCloseError(err, CloseGoingAway) {
+		t.Fatalf("ReadMessage returned %v, want a close error", err)
+	}
+	if closeData != string(msg) {
+		t.Fatalf("control handler read %q, want %q", closeData, msg)
+	}
+}
+
+func TestControlHandlerNil(t *testing.T) {
+	s, c := newTCPConns(t)
+	defer s.Close()
+	defer c.Close()
+	s.SetControlHandler(func(int, []byte) (bool, error) { return true, nil })
+	if s.ControlHandler() == nil {
+		t.Fatal("ControlHandler returned nil after SetControlHandler")
+	}
+	s.SetControlHandler(nil)
+	go func() {
+		for {
+			if _, _, err := s.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	pongs := readControl(c, PongMessage)
+	if err := c.WriteControl(PingMessage, []byte("x"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-pongs; p != "x" {
+		t.Fatalf("client read pong %q, want the default answer", p)
+	}
+}
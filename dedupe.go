@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDedupeWindow = time.Minute
+	defaultDedupeSize   = 10000
+)
+
+// Dedupe drops the inbound messages whose client-supplied message ID was
+// seen within a time and count window, such as the messages a client sends
+// again after reconnecting because it did not receive their replies. The
+// window is kept by the Dedupe rather than by a connection, so that a
+// duplicate sent on the new connection of a client is dropped too.
+//
+// The IDs are remembered per scope, typically the user or session of the
+// client, so that the IDs of different clients do not collide:
+//
+//	var dedupe websocket.Dedupe
+//
+//	c.UseInbound(dedupe.Interceptor(sessionID))
+//
+// It is safe to call Dedupe's methods concurrently. The zero value is ready
+// to use.
+type Dedupe struct {
+	// Window is how long a message ID is remembered. If zero, a default of
+	// one minute is used.
+	Window time.Duration
+
+	// Size is the maximum number of message IDs remembered across the
+	// scopes. The oldest IDs are forgotten first. If zero, a default of
+	// 10000 is used.
+	Size int
+
+	// ID returns the ID of a message, or the empty string for messages
+	// without an ID, which are never dropped. If nil, EventID is used.
+	ID func(messageType int, data []byte) string
+
+	// OnDuplicate, if not nil, is called with the scope and the ID of each
+	// dropped message.
+	OnDuplicate func(scope, id string)
+
+	mu    sync.Mutex
+	seen  map[dedupeKey]*list.Element
+	order list.List // of *dedupeEntry, oldest first
+}
+
+type dedupeKey struct {
+	scope, id string
+}
+
+type dedupeEntry struct {
+	key dedupeKey
+	at  time.Time
+}
+
+// EventID returns the ID of a text message holding an event, or the empty
+// string. It is the default Dedupe.ID function.
+func EventID(messageType int, data []byte) string {
+	if messageType != TextMessage || len(data) == 0 || data[0] != '{' {
+		return ""
+	}
+	var e struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(data, &e) != nil {
+		return ""
+	}
+	return e.ID
+}
+
+// Seen records the message ID in the scope and reports whether it was
+// already recorded within the window.
+func (d *Dedupe) Seen(scope, id string) bool {
+	return d.seenAt(scope, id, time.Now())
+}
+
+func (d *Dedupe) seenAt(scope, id string, now time.Time) bool {
+	window := d.Window
+	if window <= 0 {
+		window = defaultDedupeWindow
+	}
+	size := d.Size
+	if size <= 0 {
+		size = defaultDedupeSize
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now.Add(-window))
+	key := dedupeKey{scope, id}
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+	if d.seen == nil {
+		d.seen = make(map[dedupeKey]*list.Element)
+	}
+	d.seen[key] = d.order.PushBack(&dedupeEntry{key: key, at: now})
+	for d.order.Len() > size {
+		d.remove(d.order.Front())
+	}
+	return false
+}
+
+// expire forgets the IDs recorded before the time. The caller must hold
+// d.mu.
+func (d *Dedupe) expire(before time.Time) {
+	for e := d.order.Front(); e != nil && e.Value.(*dedupeEntry).at.Before(before); e = d.order.Front() {
+		d.remove(e)
+	}
+}
+
+func (d *Dedupe) remove(e *list.Element) {
+	delete(d.seen, e.Value.(*dedupeEntry).key)
+	d.order.Remove(e)
+}
+
+// Len returns the number of message IDs remembered.
+func (d *Dedupe) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.order.Len()
+}
+
+// Interceptor returns an inbound interceptor dropping the duplicate messages
+// of the scope. See Conn.UseInbound.
+func (d *Dedupe) Interceptor(scope string) Interceptor {
+	return func(messageType int, data []byte) ([]byte, error) {
+		idOf := d.ID
+		if idOf == nil {
+			idOf = EventID
+		}
+		id := idOf(messageType, data)
+		if id == "" || !d.Seen(scope, id) {
+			return data, nil
+		}
+		if d.OnDuplicate != nil {
+			d.OnDuplicate(scope, id)
+		}
+		return nil, ErrDropMessage
+	}
+}
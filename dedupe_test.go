@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDedupeWindow(t *testing.T) {
+	d := Dedupe{Window: time.Minute, Size: 3}
+	now := time.Now()
+	if d.seenAt("a", "1", now) {
+		t.Fatal("first message reported as a duplicate")
+	}
+	if !d.seenAt("a", "1", now.Add(time.Second)) {
+		t.Fatal("duplicate not reported")
+	}
+	if d.seenAt("b", "1", now) {
+		t.Fatal("ID of another scope reported as a duplicate")
+	}
+	if d.seenAt("a", "1", now.Add(2*time.Minute)) {
+		t.Fatal("ID reported as a duplicate after the window")
+	}
+	for _, id := range []string{"2", "3", "4"} {
+		d.seenAt("a", id, now.Add(2*time.Minute))
+	}
+	if d.Len() != 3 {
+		t.Fatalf("Len returned %d, want 3", d.Len())
+	}
+	if d.seenAt("a", "1", now.Add(2*time.Minute)) {
+		t.Fatal("ID reported as a duplicate after being evicted by the size")
+	}
+}
+
+func TestDedupeInterceptor(t *testing.T) {
+	var dups []string
+	d := Dedupe{OnDuplicate: func(scope, id string) { dups = append(dups, scope+"/"+id) }}
+
+	// The second connection of the client receives the retried message.
+	for _, tc := range []struct{ send, want []string }{
+		{
+			send: []string{`{"event":"send","id":"m1"}`, `{"event":"send","id":"m2"}`},
+			want: []string{`{"event":"send","id":"m1"}`, `{"event":"send","id":"m2"}`},
+		},
+		{
+			send: []string{`{"event":"send","id":"m2"}`, `plain`, `plain`, `{"event":"send","id":"m3"}`},
+			want: []string{`plain`, `plain`, `{"event":"send","id":"m3"}`},
+		},
+	} {
+		s, c := newPipeConns()
+		s.UseInbound(d.Interceptor("alice"))
+		go func() {
+			for _, m := range tc.send {
+				_ = c.WriteMessage(TextMessage, []byte(m))
+			}
+			_ = c.WriteMessage(TextMessage, []byte("end"))
+		}()
+		var got []string
+		for m := readString(t, s); m != "end"; m = readString(t, s) {
+			got = append(got, m)
+		}
+		s.Close()
+		c.Close()
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("read %q, want %q", got, tc.want)
+		}
+	}
+	if len(dups) != 1 || dups[0] != "alice/m2" {
+		t.Fatalf("OnDuplicate called with %v, want alice/m2", dups)
+	}
+}
@@ -0,0 +1,232 @@
+package websocket
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+const defaultOutboxSize = 1024
+
+// ErrOutboxFull is returned by Outbox.Send when the replay buffer is full of
+// unacknowledged messages.
+var ErrOutboxFull = errors.New("websocket: outbox full")
+
+// The messages of the at-least-once delivery mode carry a sequence number:
+//
+//	<seq>:<payload>
+//
+// and the peer acknowledges all the messages up to a sequence number with
+// the text message
+//
+//	ack:<seq>
+var ackPrefix = []byte("ack:")
+
+// outboxMessage is a message waiting for its acknowledgment.
+type outboxMessage struct {
+	seq         uint64
+	messageType int
+	data        []byte // encoded with the sequence number
+}
+
+// Outbox delivers messages at least once to a session that may span several
+// connections. Each message gets a sequence number and is kept in a bounded
+// replay buffer until the peer acknowledges it. When the peer reconnects,
+// Attach retransmits the unacknowledged messages on the new connection.
+//
+// The peer acknowledges messages with ack messages, which the application
+// passes to HandleAck from its read loop; a Go peer reads the messages with
+// an Inbox. Keep the outbox of each session, for example by the session ID
+// of StickySessions, for as long as the peer may reconnect.
+//
+// The outbox writes to the attached connection with WriteMessage. All the
+// data messages written to the connection must go through the outbox, and
+// other writes must not be concurrent with the outbox unless the write queue
+// of the connection is enabled.
+//
+// It is safe to call Outbox's methods concurrently. The zero value is ready
+// to use.
+type Outbox struct {
+	// Size is the maximum number of unacknowledged messages. If zero, a
+	// default of 1024 is used.
+	Size int
+
+	mu      sync.Mutex
+	conn    *Conn
+	seq     uint64 // sequence number of the last message
+	pending []outboxMessage
+}
+
+// Send assigns the next sequence number to the message, keeps it until it is
+// acknowledged and writes it to the attached connection, if any. Errors
+// writing the message detach the connection; the message is retransmitted
+// by the next Attach. Send returns ErrOutboxFull if the replay buffer is
+// full.
+func (o *Outbox) Send(messageType int, data []byte) (seq uint64, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	size := o.Size
+	if size <= 0 {
+		size = defaultOutboxSize
+	}
+	if len(o.pending) >= size {
+		return 0, ErrOutboxFull
+	}
+	o.seq++
+	p := strconv.AppendUint(make([]byte, 0, 21+len(data)), o.seq, 10)
+	p = append(p, ':')
+	p = append(p, data...)
+	m := outboxMessage{seq: o.seq, messageType: messageType, data: p}
+	o.pending = append(o.pending, m)
+	if o.conn != nil {
+		if err := o.conn.WriteMessage(m.messageType, m.data); err != nil {
+			o.conn = nil
+		}
+	}
+	return m.seq, nil
+}
+
+// Attach makes c the connection of the outbox and retransmits the
+// unacknowledged messages on it in order. Attach returns the error writing
+// the messages, and the connection is then detached.
+func (o *Outbox) Attach(c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.conn = c
+	for _, m := range o.pending {
+		if err := c.WriteMessage(m.messageType, m.data); err != nil {
+			o.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// Detach detaches the connection if it is c. Messages sent while no
+// connection is attached are kept for the next Attach.
+func (o *Outbox) Detach(c *Conn) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == c {
+		o.conn = nil
+	}
+}
+
+// Ack discards the messages with sequence numbers up to seq.
+func (o *Outbox) Ack(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	i := 0
+	for i < len(o.pending) && o.pending[i].seq <= seq {
+		i++
+	}
+	o.pending = append(o.pending[:0], o.pending[i:]...)
+}
+
+// HandleAck acknowledges the messages if p is an ack message and reports
+// whether it is. Call HandleAck with each message read from the peer.
+func (o *Outbox) HandleAck(messageType int, p []byte) bool {
+	seq, ok := parseAck(messageType, p)
+	if ok {
+		o.Ack(seq)
+	}
+	return ok
+}
+
+// Pending returns the number of unacknowledged messages.
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+func parseAck(messageType int, p []byte) (uint64, bool) {
+	if messageType != TextMessage || len(p) <= len(ackPrefix) || string(p[:len(ackPrefix)]) != string(ackPrefix) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(string(p[len(ackPrefix):]), 10, 64)
+	return seq, err == nil
+}
+
+// Inbox receives the messages delivered by an Outbox on the peer. It drops
+// the retransmitted messages already received and acknowledges the others.
+// Use the same Inbox for all the connections of the session.
+//
+// It is safe to call Inbox's methods concurrently. The zero value is ready
+// to use.
+type Inbox struct {
+	mu   sync.Mutex
+	last uint64 // sequence number of the last message received
+}
+
+// ReadMessage reads the next message delivered on c that was not received
+// before, acknowledges it and returns its payload. Messages without a
+// sequence number are returned as they are.
+func (in *Inbox) ReadMessage(c *Conn) (messageType int, p []byte, err error) {
+	if c == nil {
+		return noFrame, nil, ErrNilConn
+	}
+	for {
+		messageType, p, err = c.ReadMessage()
+		if err != nil {
+			return messageType, p, err
+		}
+		seq, payload, ok := parseDelivery(p)
+		if !ok {
+			return messageType, p, nil
+		}
+		dup, last := in.receive(seq)
+		// Acknowledge duplicates too: the ack of the original may be lost.
+		if err := c.WriteMessage(TextMessage, strconv.AppendUint(append([]byte(nil), ackPrefix...), last, 10)); err != nil {
+			return noFrame, nil, err
+		}
+		if !dup {
+			return messageType, payload, nil
+		}
+	}
+}
+
+// receive records the message with the sequence number. It reports whether
+// the message was received before and returns the sequence number to
+// acknowledge.
+func (in *Inbox) receive(seq uint64) (dup bool, last uint64) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	dup = seq <= in.last
+	if !dup {
+		in.last = seq
+	}
+	return dup, in.last
+}
+
+// reset forgets the messages received, for a new session.
+func (in *Inbox) reset() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.last = 0
+}
+
+// Last returns the sequence number of the last message received.
+func (in *Inbox) Last() uint64 {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.last
+}
+
+func parseDelivery(p []byte) (seq uint64, payload []byte, ok bool) {
+	i := 0
+	for i < len(p) && i < 20 && '0' <= p[i] && p[i] <= '9' {
+		i++
+	}
+	if i == 0 || i >= len(p) || p[i] != ':' {
+		return 0, nil, false
+	}
+	seq, err := strconv.ParseUint(string(p[:i]), 10, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	return seq, p[i+1:], true
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+// attachOutbox attaches a new server connection to the outbox, serves its
+// acks and returns the client connection.
+func attachOutbox(t *testing.T, o *Outbox) (s, c *Conn) {
+	t.Helper()
+	s, c = newPipeConns()
+	t.Cleanup(func() {
+		s.Close()
+		c.Close()
+	})
+	if err := s.EnableWriteQueue(16, OverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer o.Detach(s)
+		for {
+			mt, p, err := s.ReadMessage()
+			if err != nil {
+				return
+			}
+			if !o.HandleAck(mt, p) {
+				t.Errorf("unexpected message %q", p)
+			}
+		}
+	}()
+	if err := o.Attach(s); err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func readDelivery(t *testing.T, in *Inbox, c *Conn) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	_, p, err := in.ReadMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(p)
+}
+
+func waitPending(t *testing.T, o *Outbox, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for o.Pending() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d pending messages, want %d", o.Pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOutboxRedelivery(t *testing.T) {
+	var o Outbox
+	var in Inbox
+	s, c := attachOutbox(t, &o)
+	for i, m := range []string{"a", "b", "c"} {
+		if seq, err := o.Send(TextMessage, []byte(m)); err != nil || seq != uint64(i+1) {
+			t.Fatalf("Send returned %d, %v", seq, err)
+		}
+	}
+	if got := readDelivery(t, &in, c); got != "a" {
+		t.Fatalf("read %q, want a", got)
+	}
+	waitPending(t, &o, 2)
+
+	// The connection drops before b and c are acknowledged, and d is sent
+	// while the peer is away.
+	s.Close()
+	c.Close()
+	if _, err := o.Send(BinaryMessage, []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, c = attachOutbox(t, &o)
+	for _, want := range []string{"b", "c", "d"} {
+		if got := readDelivery(t, &in, c); got != want {
+			t.Fatalf("read %q, want %q", got, want)
+		}
+	}
+	waitPending(t, &o, 0)
+	if in.Last() != 4 {
+		t.Fatalf("last sequence number %d", in.Last())
+	}
+
+	// Retransmitted messages already received are dropped.
+	_ = s.WriteMessage(TextMessage, []byte("3:c"))
+	_ = s.WriteMessage(TextMessage, []byte("plain"))
+	if got := readDelivery(t, &in, c); got != "plain" {
+		t.Fatalf("read %q, want the message without sequence number", got)
+	}
+}
+
+func TestOutboxFull(t *testing.T) {
+	o := Outbox{Size: 2}
+	for i := 0; i < 2; i++ {
+		if _, err := o.Send(TextMessage, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := o.Send(TextMessage, []byte("x")); err != ErrOutboxFull {
+		t.Fatalf("Send returned %v, want %v", err, ErrOutboxFull)
+	}
+	o.Ack(1)
+	if _, err := o.Send(TextMessage, []byte("x")); err != nil {
+		t.Fatalf("Send after ack returned %v", err)
+	}
+}
+
+func TestParseDelivery(t *testing.T) {
+	for _, tc := range []struct {
+		p       string
+		seq     uint64
+		payload string
+		ok      bool
+	}{
+		{"1:a", 1, "a", true},
+		{"42:", 42, "", true},
+		{"7:x:y", 7, "x:y", true},
+		{":a", 0, "", false},
+		{"a:1", 0, "", false},
+		{"12", 0, "", false},
+		{"99999999999999999999:a", 0, "", false},
+	} {
+		seq, payload, ok := parseDelivery([]byte(tc.p))
+		if seq != tc.seq || string(payload) != tc.payload || ok != tc.ok {
+			t.Errorf("parseDelivery(%q) = %d, %q, %v", tc.p, seq, payload, ok)
+		}
+	}
+	if seq, ok := parseAck(TextMessage, []byte("ack:5")); !ok || seq != 5 {
+		t.Errorf("parseAck returned %d, %v", seq, ok)
+	}
+	if _, ok := parseAck(BinaryMessage, []byte("ack:5")); ok {
+		t.Error("binary ack accepted")
+	}
+}
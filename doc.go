@@ -117,7 +117,13 @@
 // Connections handle received pong messages by calling the handler function
 // set with the SetPongHandler method. The default pong handler does nothing.
 // If an application sends ping messages, then the application should set a
-// pong handler to receive the corresponding pong.
+// pong handler to receive the corresponding pong. Alternatively, call the
+// connection EnableKeepalive method to send pings periodically, measure the
+// round trip time and close connections when the peer stops replying.
+//
+// A handler set with the SetControlHandler method is called with every control
+// message before the handler for its type, and can observe the message or
+// answer it itself instead of the handler for its type.
 //
 // The control message handler functions are called from the NextReader,
 // ReadMessage and message reader Read methods. The default close and ping
@@ -152,6 +158,11 @@
 // The Close and WriteControl methods can be called concurrently with all other
 // methods.
 //
+// Applications that write from many goroutines can call EnableWriteQueue
+// before the first write. The WriteMessage, WriteJSON, WriteCodec and
+// WritePreparedMessage methods are then safe to call concurrently; messages are
+// queued and written in order by a goroutine owned by the connection.
+//
 // # Origin Considerations
 //
 // Web browsers allow Javascript applications to open a WebSocket connection to
@@ -166,6 +177,17 @@
 // the handshake if the Origin request header is present and the Origin host is
 // not equal to the Host request header.
 //
+// Applications that accept connections from other origins can set the
+// OriginPolicy field instead of writing a CheckOrigin function. An
+// OriginPolicy allows a list of origins, including wildcard subdomains, and
+// can restrict the origin scheme:
+//
+//	upgrader := websocket.Upgrader{
+//		OriginPolicy: &websocket.OriginPolicy{
+//			AllowedOrigins: []string{"https://example.com", "https://*.example.com"},
+//		},
+//	}
+//
 // The deprecated package-level Upgrade function does not perform origin
 // checking. The application is responsible for checking the Origin header
 // before calling the Upgrade function.
@@ -192,7 +214,9 @@
 //
 // Buffers are held for the lifetime of the connection by default. If the
 // Dialer or Upgrader WriteBufferPool field is set, then a connection holds the
-// write buffer only when writing a message.
+// write buffer only when writing a message. If the ReadBufferPool field is
+// set, then a connection holds the read buffer only while frames are
+// available to read; a connection waiting for the peer holds no buffer.
 //
 // Applications should tune the buffer sizes to balance memory use and
 // performance. Increasing the buffer size uses more memory, but can reduce the
@@ -215,7 +239,8 @@
 // A write buffer pool is useful when the application has a modest number
 // writes over a large number of connections. when buffers are pooled, a larger
 // buffer size has a reduced impact on total memory use and has the benefit of
-// reducing system calls and frame overhead.
+// reducing system calls and frame overhead. A read buffer pool is useful
+// when most of a large number of connections are idle.
 //
 // # Compression EXPERIMENTAL
 //
@@ -237,10 +262,24 @@
 //
 //	conn.EnableWriteCompression(false)
 //
-// Currently this package does not support compression with "context takeover".
-// This means that messages must be compressed and decompressed in isolation,
-// without retaining sliding window or dictionary state across messages. For
-// more details refer to RFC 7692.
+// By default, compression is negotiated without "context takeover". This
+// means that messages are compressed and decompressed in isolation, without
+// retaining sliding window state across messages. Set the
+// ServerContextTakeover and ClientContextTakeover options to let the peers
+// retain the sliding window, which greatly improves the compression ratio of
+// repetitive messages at the cost of holding compression state for the
+// lifetime of the connection:
+//
+//	var upgrader = websocket.Upgrader{
+//	    EnableCompression:     true,
+//	    ServerContextTakeover: true,
+//	    ClientContextTakeover: true,
+//	    CompressionLevel:      flate.BestCompression,
+//	    CompressionThreshold:  256,
+//	}
+//
+// Messages smaller than CompressionThreshold are sent uncompressed. For more
+// details refer to RFC 7692.
 //
 // Use of compression is experimental and may result in decreased performance.
 package websocket
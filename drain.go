@@ -0,0 +1,237 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SetLabel tags the connection with the label key=value, replacing the value
+// of a label with the same key. Labels such as "version" or "region" select
+// connections for Registry.Drain and Hub.Drain. The label methods are safe to
+// call concurrently with all other methods.
+func (c *Conn) SetLabel(key, value string) {
+	if c == nil {
+		return
+	}
+	c.metaMu.Lock()
+	if c.labels == nil {
+		c.labels = make(map[string]string)
+	}
+	c.labels[key] = value
+	c.metaMu.Unlock()
+}
+
+// Label returns the value of the label with the key and whether the
+// connection has the label.
+func (c *Conn) Label(key string) (value string, ok bool) {
+	if c == nil {
+		return "", false
+	}
+	c.metaMu.RLock()
+	value, ok = c.labels[key]
+	c.metaMu.RUnlock()
+	return value, ok
+}
+
+// DeleteLabel removes the label with the key.
+func (c *Conn) DeleteLabel(key string) {
+	if c == nil {
+		return
+	}
+	c.metaMu.Lock()
+	delete(c.labels, key)
+	c.metaMu.Unlock()
+}
+
+// Labels returns a copy of the labels of the connection.
+func (c *Conn) Labels() map[string]string {
+	if c == nil {
+		return nil
+	}
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	labels := make(map[string]string, len(c.labels))
+	for k, v := range c.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Selector selects the connections having all of its labels. The empty
+// selector selects every connection.
+type Selector map[string]string
+
+// ParseSelector parses a selector of comma separated labels, such as
+// "version=v12,region=eu".
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, term := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(term, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, errors.New("websocket: invalid selector term " + term)
+		}
+		sel[key] = strings.TrimSpace(value)
+	}
+	return sel, nil
+}
+
+// Matches reports whether the connection has all the labels of the
+// selector.
+func (sel Selector) Matches(c *Conn) bool {
+	if c == nil {
+		return false
+	}
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	for k, v := range sel {
+		if got, ok := c.labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// DrainOptions configures the draining of connections by Registry.Drain and
+// Hub.Drain.
+type DrainOptions struct {
+	// Code and Reason are sent in the close message of each connection. If
+	// Code is zero, CloseServiceRestart is used, asking the clients to
+	// reconnect, possibly to another server.
+	Code   int
+	Reason string
+
+	// Interval is the delay between the close messages of successive
+	// batches of connections, spreading the reconnections of the clients
+	// over time. If zero, all the close messages are sent at once.
+	Interval time.Duration
+
+	// BatchSize is the number of connections closed at each interval. If
+	// zero, a default of 1 is used.
+	BatchSize int
+}
+
+// Select returns the connections of the registry matching the selector, in
+// no particular order.
+func (r *Registry) Select(sel Selector) []*Conn {
+	var conns []*Conn
+	r.Range(func(c *Conn) bool {
+		if sel.Matches(c) {
+			conns = append(conns, c)
+		}
+		return true
+	})
+	return conns
+}
+
+// Drain gracefully closes the connections of the registry matching the
+// selector, as in a targeted rollout or the evacuation of a region. Drain
+// sends a close message to the connections, with the pacing set by opts,
+// and waits for the connections to close. The application's read loop
+// receives the peer's close message as a *CloseError and is expected to
+// close the connection in response. A nil opts uses the defaults.
+//
+// If ctx is done before all the connections are closed, Drain closes the
+// remaining network connections, including those not sent a close message
+// yet, and returns the context's error. Drain returns the number of
+// connections selected.
+func (r *Registry) Drain(ctx context.Context, sel Selector, opts *DrainOptions) (int, error) {
+	conns := r.Select(sel)
+	return len(conns), drainConns(ctx, conns, opts)
+}
+
+// Select returns the connections of the hub matching the selector, in no
+// particular order.
+func (h *Hub) Select(sel Selector) []*Conn {
+	var conns []*Conn
+	for _, s := range h.shardList() {
+		s.mu.RLock()
+		for c := range s.clients {
+			if sel.Matches(c) {
+				conns = append(conns, c)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return conns
+}
+
+// Drain gracefully closes the connections of the hub matching the selector.
+// See Registry.Drain.
+func (h *Hub) Drain(ctx context.Context, sel Selector, opts *DrainOptions) (int, error) {
+	conns := h.Select(sel)
+	return len(conns), drainConns(ctx, conns, opts)
+}
+
+// drainConns sends a close message to the connections in batches and waits
+// for them to close.
+func drainConns(ctx context.Context, conns []*Conn, opts *DrainOptions) error {
+	if opts == nil {
+		opts = &DrainOptions{}
+	}
+	code := opts.Code
+	if code == 0 {
+		code = CloseServiceRestart
+	}
+	batch := opts.BatchSize
+	if batch <= 0 {
+		batch = 1
+	}
+	msg := FormatCloseMessage(code, opts.Reason)
+
+	var err error
+	var t *time.Timer
+	for i := 0; i < len(conns) && err == nil; i += batch {
+		if i > 0 && opts.Interval > 0 {
+			if t == nil {
+				t = time.NewTimer(opts.Interval)
+				defer t.Stop()
+			} else {
+				t.Reset(opts.Interval)
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				err = ctx.Err()
+				continue
+			}
+		}
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(writeWait)
+		}
+		for _, c := range conns[i:min(i+batch, len(conns))] {
+			go func() {
+				if err := c.WriteControl(CloseMessage, msg, deadline); err != nil {
+					// The close handshake cannot complete.
+					_ = c.Close()
+				}
+			}()
+		}
+	}
+
+	for _, c := range conns {
+		if err != nil {
+			break
+		}
+		if c.closed == nil {
+			continue
+		}
+		select {
+		case <-c.closed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}
+	return err
+}
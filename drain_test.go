@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSelector(t *testing.T) {
+	sel, err := ParseSelector("version=v12, region = eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sel) != 2 || sel["version"] != "v12" || sel["region"] != "eu" {
+		t.Errorf("ParseSelector() = %v", sel)
+	}
+	if _, err := ParseSelector("version"); err == nil {
+		t.Error("ParseSelector accepted a term without a value")
+	}
+
+	c := newTestConn(nil, nil, true)
+	c.SetLabel("version", "v12")
+	c.SetLabel("region", "eu")
+	if !sel.Matches(c) || !(Selector{}).Matches(c) {
+		t.Error("selector does not match the labels of the connection")
+	}
+	c.DeleteLabel("region")
+	if sel.Matches(c) {
+		t.Error("selector matches a connection missing a label")
+	}
+	if v, ok := c.Label("version"); v != "v12" || !ok {
+		t.Errorf("Label() = %q, %v", v, ok)
+	}
+}
+
+func TestRegistryDrain(t *testing.T) {
+	var r Registry
+	type pair struct {
+		server, client *Conn
+		closeErr       chan error
+	}
+	var pairs []pair
+	for i, region := range []string{"eu", "us", "eu", "eu"} {
+		server, client := newTCPConns(t)
+		defer client.Close()
+		server.SetLabel("region", region)
+		if i == 3 {
+			server.SetLabel("version", "v11")
+		}
+		r.Add(server)
+		p := pair{server: server, client: client, closeErr: make(chan error, 1)}
+		pairs = append(pairs, p)
+		go func() {
+			// The client replies to the close message with the default close
+			// handler, and the server closes when it reads the reply.
+			for {
+				if _, _, err := client.ReadMessage(); err != nil {
+					p.closeErr <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			defer server.Close()
+			for {
+				if _, _, err := server.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	opts := &DrainOptions{Reason: "evacuating eu", Interval: 20 * time.Millisecond}
+	n, err := r.Drain(context.Background(), Selector{"region": "eu"}, opts)
+	if n != 3 || err != nil {
+		t.Fatalf("Drain() = %d, %v, want 3 connections drained", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*opts.Interval {
+		t.Errorf("Drain() returned after %v, want the close messages paced", elapsed)
+	}
+	for i, p := range pairs {
+		if i == 1 {
+			continue
+		}
+		var ce *CloseError
+		if err := <-p.closeErr; !errors.As(err, &ce) || ce.Code != CloseServiceRestart || ce.Text != opts.Reason {
+			t.Errorf("client %d read %v, want a service restart close", i, err)
+		}
+	}
+
+	// The registry removes the closed connections in the background.
+	deadline := time.Now().Add(time.Second)
+	for r.Len() > 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if r.Len() != 1 || r.Select(Selector{"region": "us"})[0] != pairs[1].server {
+		t.Errorf("registry holds %d connections after the drain, want the us connection", r.Len())
+	}
+}
+
+func TestHubDrainTimeout(t *testing.T) {
+	h := &Hub{}
+	defer h.Close()
+	server, client := newTCPConns(t)
+	defer client.Close()
+	server.SetLabel("version", "v11")
+	if err := h.Join("lobby", server); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads the connections, so the close handshake never completes.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n, err := h.Drain(ctx, Selector{"version": "v11"}, nil)
+	if n != 1 || err != context.DeadlineExceeded {
+		t.Fatalf("Drain() = %d, %v, want 1 connection and %v", n, err, context.DeadlineExceeded)
+	}
+	select {
+	case <-server.closed:
+	default:
+		t.Error("Drain did not close the connection after the timeout")
+	}
+}
@@ -1,9 +1,124 @@
 package websocket
 
-import "errors"
+import (
+	"errors"
+	"net"
+)
 
 var (
 	ErrNilConn                   = errors.New("nil *Conn")
 	ErrNilNetConn                = errors.New("nil net.Conn")
 	ErrResponseHijackUnsupported = errors.New("websocket: response does not implement http.Hijacker")
 )
+
+// The errors returned by the connections belong to a few classes that
+// applications can test for with errors.Is and errors.As instead of matching
+// the text of the errors:
+//
+//   - A rejected or invalid handshake returned by Dial is a HandshakeError
+//     matching ErrBadHandshake.
+//   - A message larger than the read limit is reported as ErrReadLimit.
+//   - A violation of the protocol by the peer is a *ProtocolError matching
+//     ErrProtocolViolation.
+//   - A close message from the peer, or the abnormal closure of the
+//     connection, is a *CloseError. To test for a close code, use
+//     IsCloseError, IsUnexpectedCloseError or errors.Is with a *CloseError
+//     holding the code.
+//   - A write not completed before the write deadline matches
+//     ErrWriteTimeout, and the error implements net.Error with a Timeout
+//     method returning true.
+//   - A read or write after Close matches ErrConnClosed.
+//
+// Errors wrapped with a class still match the underlying error, such as
+// net.ErrClosed or os.ErrDeadlineExceeded, with errors.Is.
+var (
+	// ErrProtocolViolation is matched by the errors returned when the peer
+	// violates the WebSocket protocol.
+	ErrProtocolViolation = errors.New("websocket: protocol violation")
+
+	// ErrWriteTimeout is matched by the errors returned when a write does
+	// not complete before the write deadline.
+	ErrWriteTimeout net.Error = &netError{msg: "websocket: write timeout", timeout: true, temporary: true}
+
+	// ErrConnClosed is matched by the errors returned when reading from or
+	// writing to a connection after Close.
+	ErrConnClosed = errors.New("websocket: use of closed connection")
+)
+
+// ProtocolError describes a violation of the WebSocket protocol by the peer.
+// The connection sends a close message with the code CloseProtocolError
+// before returning the error. ProtocolError matches ErrProtocolViolation with
+// errors.Is.
+type ProtocolError struct {
+	// Reason describes the violation.
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return "websocket: " + e.Reason
+}
+
+// Is reports whether target is ErrProtocolViolation.
+func (e *ProtocolError) Is(target error) bool {
+	return target == ErrProtocolViolation
+}
+
+// Is reports whether target is a *CloseError with the same code and, if the
+// text of target is not empty, the same text.
+func (e *CloseError) Is(target error) bool {
+	t, ok := target.(*CloseError)
+	return ok && t.Code == e.Code && (t.Text == "" || t.Text == e.Text)
+}
+
+// classError adds an error class to an error.
+type classError struct {
+	err   error
+	class error
+}
+
+func (e *classError) Error() string   { return e.err.Error() }
+func (e *classError) Unwrap() []error { return []error{e.err, e.class} }
+
+func (e *classError) Timeout() bool {
+	ne, ok := e.class.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func (e *classError) Temporary() bool {
+	return e.Timeout()
+}
+
+// classify adds the class of an error returned by the network connection,
+// if any.
+func (c *Conn) classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ce *CloseError
+	if errors.As(err, &ce) || errors.Is(err, ErrConnClosed) || errors.Is(err, ErrWriteTimeout) {
+		return err
+	}
+	select {
+	case <-c.closed:
+		return &classError{err: err, class: ErrConnClosed}
+	default:
+	}
+	return err
+}
+
+// classifyWrite is like classify for the errors of writes.
+func (c *Conn) classifyWrite(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() && !errors.Is(err, ErrWriteTimeout) {
+		return &classError{err: err, class: ErrWriteTimeout}
+	}
+	return c.classify(err)
+}
+
+// readError returns the error of a read from the network connection.
+func (c *Conn) readError(err error) error {
+	return c.halfCloseError(c.classify(err))
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProtocolError(t *testing.T) {
+	sc, cc := newTCPPair(t)
+	defer cc.Close()
+	server := newConn(sc, true, 1024, 1024, nil, nil, nil)
+	defer server.Close()
+	// Clients must mask their frames.
+	if _, err := cc.Write([]byte{0x81, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	_ = server.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := server.ReadMessage()
+	if !errors.Is(err, ErrProtocolViolation) {
+		t.Fatalf("ReadMessage returned %v, want a protocol violation", err)
+	}
+	var pe *ProtocolError
+	if !errors.As(err, &pe) || pe.Reason == "" || err.Error() != "websocket: "+pe.Reason {
+		t.Fatalf("ReadMessage returned %#v, want a *ProtocolError with a reason", err)
+	}
+}
+
+func TestReadLimitError(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer server.Close()
+	defer client.Close()
+	server.SetReadLimit(4)
+	if err := client.WriteMessage(TextMessage, []byte("too long")); err != nil {
+		t.Fatal(err)
+	}
+	_ = server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := server.ReadMessage(); !errors.Is(err, ErrReadLimit) {
+		t.Fatalf("ReadMessage returned %v, want %v", err, ErrReadLimit)
+	}
+}
+
+func TestCloseErrorIs(t *testing.T) {
+	err := fmt.Errorf("session ended: %w", &CloseError{Code: CloseGoingAway, Text: "restart"})
+	for _, tt := range []struct {
+		target error
+		want   bool
+	}{
+		{&CloseError{Code: CloseGoingAway}, true},
+		{&CloseError{Code: CloseGoingAway, Text: "restart"}, true},
+		{&CloseError{Code: CloseGoingAway, Text: "other"}, false},
+		{&CloseError{Code: CloseNormalClosure}, false},
+	} {
+		if got := errors.Is(err, tt.target); got != tt.want {
+			t.Errorf("errors.Is(%v, %v) = %v, want %v", err, tt.target, got, tt.want)
+		}
+	}
+	if !IsCloseError(err, CloseGoingAway) {
+		t.Error("IsCloseError does not match a wrapped close error")
+	}
+	if !IsUnexpectedCloseError(err, CloseNormalClosure) {
+		t.Error("IsUnexpectedCloseError does not match a wrapped close error")
+	}
+}
+
+func TestWriteTimeoutError(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer server.Close()
+	defer client.Close()
+	_ = server.SetWriteDeadline(time.Now().Add(-time.Second))
+	err := server.WriteMessage(TextMessage, []byte("late"))
+	if !errors.Is(err, ErrWriteTimeout) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("WriteMessage returned %v, want a write timeout", err)
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("WriteMessage returned %v, want a net.Error timeout", err)
+	}
+}
+
+func TestConnClosedError(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	server.Close()
+	if err := server.WriteMessage(TextMessage, []byte("x")); !errors.Is(err, ErrConnClosed) || !errors.Is(err, net.ErrClosed) {
+		t.Errorf("WriteMessage after Close returned %v, want %v", err, ErrConnClosed)
+	}
+	if _, _, err := server.ReadMessage(); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("ReadMessage after Close returned %v, want %v", err, ErrConnClosed)
+	}
+}
+
+func TestHandshakeRejectedError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer s.Close()
+	_, _, err := DefaultDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("Dial returned %v, want %v", err, ErrBadHandshake)
+	}
+	var he HandshakeError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial returned %#v, want a HandshakeError with status 403", err)
+	}
+}
@@ -0,0 +1,411 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The reserved bits of the frame header, see ExtensionTransform.RSV.
+const (
+	RSV1 = rsv1Bit
+	RSV2 = rsv2Bit
+	RSV3 = rsv3Bit
+)
+
+// ExtensionParams are the parameters of an extension in the
+// Sec-WebSocket-Extensions header, by name. A parameter without a value has
+// the empty string as value.
+type ExtensionParams map[string]string
+
+// Extension is a WebSocket extension negotiated with the
+// Sec-WebSocket-Extensions header (RFC 6455, section 9). Set the Extensions
+// field of Upgrader, FastHTTPUpgrader or Dialer to negotiate extensions
+// beyond permessage-deflate, which is configured with the compression
+// fields and is always negotiated first when enabled. permessage-deflate is
+// itself an Extension, but its transform configures the compression of the
+// framing code, with the compression level, threshold and prepared
+// messages, instead of wrapping the messages.
+//
+// The extensions are negotiated in the order of the Extensions field. An
+// extension is declined if the reserved bits of its transform are used by an
+// extension negotiated before it.
+type Extension interface {
+	// Name returns the extension token, such as "permessage-deflate".
+	Name() string
+
+	// Offers returns the parameters of the offers made by a client, in
+	// order of preference.
+	Offers() []ExtensionParams
+
+	// Accept is called by a server with the offers of the client for the
+	// extension, in the order of the request. Accept returns the
+	// parameters of the response and the transform of the connection, or
+	// false to decline the extension.
+	Accept(offers []ExtensionParams) (ExtensionParams, ExtensionTransform, bool)
+
+	// Configure is called by a client with the parameters of the response
+	// of the server. Configure returns the transform of the connection, or
+	// an error failing the handshake if the response is not acceptable.
+	Configure(response ExtensionParams) (ExtensionTransform, error)
+}
+
+// ExtensionTransform transforms the data messages of a connection for a
+// negotiated extension. The transforms of the extensions are applied in the
+// order of negotiation to the messages written, and in the reverse order to
+// the messages read.
+//
+// The methods are called from the goroutines writing and reading the
+// connection; NewWriter and NewReader may be called concurrently.
+type ExtensionTransform interface {
+	// RSV returns the reserved bits of the frame header used by the
+	// extension, a combination of RSV1, RSV2 and RSV3. The bits are set in
+	// the first frame of the messages transformed by the extension.
+	RSV() byte
+
+	// NewWriter returns a writer transforming a data message to w. Close
+	// must flush the message and close w. NewWriter returns nil to write
+	// the message as is, without the reserved bits.
+	NewWriter(w io.WriteCloser, messageType int) io.WriteCloser
+
+	// NewReader returns a reader of a data message received with the
+	// reserved bits of the extension set, reading the transformed message
+	// from r. Close is called when the application is done with the
+	// message and must not close r.
+	NewReader(r io.Reader, messageType int) io.ReadCloser
+}
+
+// errExtensionConflict is returned by a client when the server accepts
+// extensions using the same reserved bits.
+var errExtensionConflict = errors.New("websocket: server accepted extensions with conflicting reserved bits")
+
+// negotiatedExtension is an extension selected in a handshake.
+type negotiatedExtension struct {
+	header string // element of the Sec-WebSocket-Extensions header
+	t      ExtensionTransform
+}
+
+// The built-in permessage-deflate extension is negotiated through Extension
+// and ExtensionTransform like the extensions of the applications, and
+// implements the optional interfaces below for what the interfaces cannot
+// express.
+
+// connSetup is implemented by the transforms of the built-in extensions,
+// which configure the connection directly instead of wrapping its messages.
+type connSetup interface {
+	setup(c *Conn)
+}
+
+// headerFormatter is implemented by the transforms of the built-in
+// extensions formatting their element of the Sec-WebSocket-Extensions
+// header, such as with the parameters in the order of their specification.
+type headerFormatter interface {
+	header() string
+}
+
+// offerFormatter is implemented by the built-in extensions formatting the
+// offers of a client in the Sec-WebSocket-Extensions header.
+type offerFormatter interface {
+	offerHeader() string
+}
+
+// extensionHeader returns the element of the Sec-WebSocket-Extensions
+// header for the extension negotiated with the parameters and transform.
+func extensionHeader(name string, params ExtensionParams, t ExtensionTransform) string {
+	if f, ok := t.(headerFormatter); ok {
+		return f.header()
+	}
+	return formatExtension(name, params)
+}
+
+// formatExtension returns the element of the Sec-WebSocket-Extensions header
+// for the extension and its parameters, sorted by name.
+func formatExtension(name string, params ExtensionParams) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteString("; ")
+		b.WriteString(k)
+		if v := params[k]; v != "" {
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// extensionOffers returns the offers in the header for each extension name,
+// without the name.
+func extensionOffers(header http.Header) map[string][]ExtensionParams {
+	offers := make(map[string][]ExtensionParams)
+	for _, ext := range parseExtensions(header) {
+		name := ext[""]
+		params := make(ExtensionParams, len(ext)-1)
+		for k, v := range ext {
+			if k != "" {
+				params[k] = v
+			}
+		}
+		offers[name] = append(offers[name], params)
+	}
+	return offers
+}
+
+// acceptExtensions selects the extensions of a server from the offers of
+// the request header.
+func acceptExtensions(header http.Header, exts []Extension) []negotiatedExtension {
+	if len(exts) == 0 || len(header["Sec-Websocket-Extensions"]) == 0 {
+		return nil
+	}
+	offers := extensionOffers(header)
+	var negotiated []negotiatedExtension
+	var rsv byte
+	for _, ext := range exts {
+		o := offers[ext.Name()]
+		if len(o) == 0 {
+			continue
+		}
+		params, t, ok := ext.Accept(o)
+		if !ok || t.RSV()&rsv != 0 || t.RSV()&^(RSV1|RSV2|RSV3) != 0 {
+			continue
+		}
+		rsv |= t.RSV()
+		negotiated = append(negotiated, negotiatedExtension{header: extensionHeader(ext.Name(), params, t), t: t})
+	}
+	return negotiated
+}
+
+// offerExtensions returns the Sec-WebSocket-Extensions header of a client
+// offering the extensions.
+func offerExtensions(exts []Extension) string {
+	var offers []string
+	for _, ext := range exts {
+		if f, ok := ext.(offerFormatter); ok {
+			offers = append(offers, f.offerHeader())
+			continue
+		}
+		for _, params := range ext.Offers() {
+			offers = append(offers, formatExtension(ext.Name(), params))
+		}
+	}
+	return strings.Join(offers, ", ")
+}
+
+// configureExtensions applies the extensions accepted in the response
+// header of the server to the offered extensions.
+func configureExtensions(header http.Header, exts []Extension) ([]negotiatedExtension, error) {
+	var negotiated []negotiatedExtension
+	var rsv byte
+	for _, accepted := range parseExtensions(header) {
+		var ext Extension
+		for _, e := range exts {
+			if e.Name() == accepted[""] {
+				ext = e
+				break
+			}
+		}
+		if ext == nil {
+			// Ignore unknown extensions, as before the extension framework.
+			continue
+		}
+		params := make(ExtensionParams, len(accepted)-1)
+		for k, v := range accepted {
+			if k != "" {
+				params[k] = v
+			}
+		}
+		t, err := ext.Configure(params)
+		if err != nil {
+			return nil, err
+		}
+		if t.RSV()&rsv != 0 {
+			return nil, errExtensionConflict
+		}
+		rsv |= t.RSV()
+		negotiated = append(negotiated, negotiatedExtension{header: extensionHeader(ext.Name(), params, t), t: t})
+	}
+	return negotiated, nil
+}
+
+// joinExtensionHeaders returns the Sec-WebSocket-Extensions header of the
+// negotiated extensions.
+func joinExtensionHeaders(exts []negotiatedExtension) string {
+	headers := make([]string, len(exts))
+	for i, e := range exts {
+		headers[i] = e.header
+	}
+	return strings.Join(headers, ", ")
+}
+
+// setupExtensions configures the connection for the negotiated extensions.
+func (c *Conn) setupExtensions(exts []negotiatedExtension) {
+	if len(exts) == 0 {
+		return
+	}
+	for _, e := range exts {
+		if s, ok := e.t.(connSetup); ok {
+			s.setup(c)
+			continue
+		}
+		c.extTransforms = append(c.extTransforms, e.t)
+		c.extRSV |= e.t.RSV()
+	}
+	c.extensions = joinExtensionHeaders(exts)
+}
+
+// wrapExtensionWriter wraps the writer of a data message with the writers of
+// the transforms and returns the reserved bits of the first frame.
+func (c *Conn) wrapExtensionWriter(w io.WriteCloser, messageType int) (io.WriteCloser, byte) {
+	var rsv byte
+	for i := len(c.extTransforms) - 1; i >= 0; i-- {
+		t := c.extTransforms[i]
+		if tw := t.NewWriter(w, messageType); tw != nil {
+			w = tw
+			rsv |= t.RSV()
+		}
+	}
+	return w, rsv
+}
+
+// wrapExtensionReader wraps the reader of a data message with the readers of
+// the transforms whose reserved bits are set in the first frame, and with the
+// decompressor of permessage-deflate.
+func (c *Conn) wrapExtensionReader(r io.ReadCloser, messageType int) io.ReadCloser {
+	if c.readRSV != 0 {
+		for i := len(c.extTransforms) - 1; i >= 0; i-- {
+			t := c.extTransforms[i]
+			if c.readRSV&t.RSV() != 0 {
+				r = &extensionReader{ReadCloser: t.NewReader(r, messageType), r: r}
+			}
+		}
+	}
+	if c.readDecompress {
+		r = c.newDecompressionReader(r)
+	}
+	return r
+}
+
+// extensionReader closes the reader of a transform and the reader it reads.
+type extensionReader struct {
+	io.ReadCloser
+	r io.ReadCloser
+}
+
+func (r *extensionReader) Close() error {
+	err := r.ReadCloser.Close()
+	if err2 := r.r.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// deflateExtension is the permessage-deflate extension (RFC 7692).
+type deflateExtension struct {
+	// Server configuration.
+	serverContextTakeover, clientContextTakeover bool
+	dicts                                        []*CompressionDictionary
+
+	// Client configuration.
+	offer deflateParams
+}
+
+func (e *deflateExtension) Name() string { return "permessage-deflate" }
+
+func (e *deflateExtension) Offers() []ExtensionParams {
+	offers := []deflateParams{e.offer}
+	if e.offer.dict != nil {
+		plain := e.offer
+		plain.dict = nil
+		offers = append(offers, plain)
+	}
+	params := make([]ExtensionParams, len(offers))
+	for i, p := range offers {
+		params[i] = deflateExtensionParams(p)
+	}
+	return params
+}
+
+// offerHeader returns the offers of the client in the order of the
+// parameters of RFC 7692.
+func (e *deflateExtension) offerHeader() string {
+	if e.offer.dict == nil {
+		return e.offer.String()
+	}
+	plain := e.offer
+	plain.dict = nil
+	return e.offer.String() + ", " + plain.String()
+}
+
+func (e *deflateExtension) Accept(offers []ExtensionParams) (ExtensionParams, ExtensionTransform, bool) {
+	exts := make([]map[string]string, len(offers))
+	for i, o := range offers {
+		ext := map[string]string{"": e.Name()}
+		for k, v := range o {
+			ext[k] = v
+		}
+		exts[i] = ext
+	}
+	p, ok := negotiateDeflate(exts, e.serverContextTakeover, e.clientContextTakeover, e.dicts)
+	if !ok {
+		return nil, nil, false
+	}
+	return deflateExtensionParams(p), &deflateTransform{params: p}, true
+}
+
+func (e *deflateExtension) Configure(response ExtensionParams) (ExtensionTransform, error) {
+	ext := map[string]string{"": e.Name()}
+	for k, v := range response {
+		ext[k] = v
+	}
+	p, err := acceptDeflate(ext, e.offer)
+	if err != nil {
+		return nil, err
+	}
+	return &deflateTransform{params: p}, nil
+}
+
+// deflateExtensionParams returns the parameters of permessage-deflate.
+func deflateExtensionParams(p deflateParams) ExtensionParams {
+	params := ExtensionParams{}
+	if p.serverNoContextTakeover {
+		params["server_no_context_takeover"] = ""
+	}
+	if p.clientNoContextTakeover {
+		params["client_no_context_takeover"] = ""
+	}
+	if p.serverMaxWindowBits {
+		params["server_max_window_bits"] = strconv.Itoa(maxDeflateWindowBits)
+	}
+	if p.dict != nil {
+		params[dictionaryParam] = p.dict.id
+	}
+	return params
+}
+
+// deflateTransform is the transform of permessage-deflate. The compressor
+// and decompressor are configured on the connection by setup, and handled
+// by the framing code along with the compression level, the threshold and
+// prepared messages.
+type deflateTransform struct {
+	params deflateParams
+}
+
+func (t *deflateTransform) RSV() byte { return RSV1 }
+
+func (t *deflateTransform) NewWriter(w io.WriteCloser, messageType int) io.WriteCloser { return nil }
+
+func (t *deflateTransform) NewReader(r io.Reader, messageType int) io.ReadCloser {
+	return io.NopCloser(r)
+}
+
+func (t *deflateTransform) setup(c *Conn) { c.setupDeflate(t.params) }
+
+func (t *deflateTransform) header() string { return t.params.String() }
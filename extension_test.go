@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// xorExtension is a test extension masking the data messages with a key
+// byte, using RSV2.
+type xorExtension struct {
+	key byte
+	rsv byte
+}
+
+func (e *xorExtension) Name() string { return "x-xor" }
+
+func (e *xorExtension) Offers() []ExtensionParams {
+	return []ExtensionParams{{"key": strconv.Itoa(int(e.key))}}
+}
+
+func (e *xorExtension) Accept(offers []ExtensionParams) (ExtensionParams, ExtensionTransform, bool) {
+	key, err := strconv.Atoi(offers[0]["key"])
+	if err != nil {
+		return nil, nil, false
+	}
+	return offers[0], e.transform(byte(key)), true
+}
+
+func (e *xorExtension) Configure(response ExtensionParams) (ExtensionTransform, error) {
+	if response["key"] != strconv.Itoa(int(e.key)) {
+		return nil, errors.New("unexpected key")
+	}
+	return e.transform(e.key), nil
+}
+
+func (e *xorExtension) transform(key byte) *xorTransform {
+	rsv := e.rsv
+	if rsv == 0 {
+		rsv = RSV2
+	}
+	return &xorTransform{key: key, rsv: rsv}
+}
+
+type xorTransform struct {
+	key, rsv byte
+}
+
+func (t *xorTransform) RSV() byte { return t.rsv }
+
+func (t *xorTransform) NewWriter(w io.WriteCloser, messageType int) io.WriteCloser {
+	return &xorWriter{w: w, key: t.key}
+}
+
+func (t *xorTransform) NewReader(r io.Reader, messageType int) io.ReadCloser {
+	return io.NopCloser(&xorReader{r: r, key: t.key})
+}
+
+type xorWriter struct {
+	w   io.WriteCloser
+	key byte
+}
+
+func (w *xorWriter) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i, b := range p {
+		q[i] = b ^ w.key
+	}
+	return w.w.Write(q)
+}
+
+func (w *xorWriter) Close() error { return w.w.Close() }
+
+type xorReader struct {
+	r   io.Reader
+	key byte
+}
+
+func (r *xorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= r.key
+	}
+	return n, err
+}
+
+func TestExtensions(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		u := Upgrader{EnableCompression: compress, Extensions: []Extension{&xorExtension{}}}
+		var serverExtensions string
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := u.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			serverExtensions = c.Extensions()
+			for {
+				mt, p, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err := c.WriteMessage(mt, p); err != nil {
+					return
+				}
+			}
+		}))
+
+		d := Dialer{EnableCompression: compress, Extensions: []Extension{&xorExtension{key: 42}}}
+		c, _, err := d.Dial(makeWsProto(s.URL), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "x-xor; key=42"
+		if compress {
+			want = "permessage-deflate; server_no_context_takeover; client_no_context_takeover, " + want
+		}
+		if got := c.Extensions(); got != want {
+			t.Errorf("compress=%v: client extensions %q, want %q", compress, got, want)
+		}
+		for _, msg := range []string{"hello", "", string(make([]byte, 5000))} {
+			if err := c.WriteMessage(TextMessage, []byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+			if got := readString(t, c); got != msg {
+				t.Errorf("compress=%v: echoed %d bytes, want %d", compress, len(got), len(msg))
+			}
+		}
+		if serverExtensions != want {
+			t.Errorf("compress=%v: server extensions %q, want %q", compress, serverExtensions, want)
+		}
+		c.Close()
+		s.Close()
+	}
+}
+
+func TestAcceptExtensions(t *testing.T) {
+	header := http.Header{"Sec-Websocket-Extensions": {"x-xor; key=1, x-other, x-xor; key=2"}}
+	exts := acceptExtensions(header, []Extension{&xorExtension{}})
+	if got := joinExtensionHeaders(exts); got != "x-xor; key=1" {
+		t.Errorf("accepted %q, want the first offer", got)
+	}
+
+	// An extension using the bits of an earlier one is declined.
+	header = http.Header{"Sec-Websocket-Extensions": {"permessage-deflate, x-xor; key=1"}}
+	exts = acceptExtensions(header, []Extension{&deflateExtension{}, &xorExtension{rsv: RSV1}})
+	if got := joinExtensionHeaders(exts); got != "permessage-deflate; server_no_context_takeover; client_no_context_takeover" {
+		t.Errorf("accepted %q, want only permessage-deflate", got)
+	}
+
+	// A client fails a response with conflicting extensions.
+	header = http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; server_no_context_takeover; client_no_context_takeover, x-xor; key=0"}}
+	_, err := configureExtensions(header, []Extension{&deflateExtension{offer: deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true}}, &xorExtension{rsv: RSV1}})
+	if err != errExtensionConflict {
+		t.Errorf("configureExtensions() = %v, want %v", err, errExtensionConflict)
+	}
+}
+
+func TestExtensionRSVOnControlFrame(t *testing.T) {
+	var buf bytes.Buffer
+	c := newTestConn(&buf, io.Discard, false)
+	c.extTransforms = []ExtensionTransform{&xorTransform{rsv: RSV2}}
+	c.extRSV = RSV2
+	buf.Write([]byte{0x80 | RSV2 | PingMessage, 0})
+	if _, _, err := c.NextReader(); err == nil {
+		t.Fatal("NextReader accepted a ping with an extension bit")
+	}
+}
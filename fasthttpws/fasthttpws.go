@@ -0,0 +1,68 @@
+// Package fasthttpws serves WebSocket connections from fasthttp servers with
+// the same *websocket.Conn type and handlers used with net/http.
+//
+// A handler written against *websocket.Conn runs unchanged on either server:
+//
+//	func echo(c *websocket.Conn) {
+//		defer c.Close()
+//		for {
+//			mt, p, err := c.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			if err := c.WriteMessage(mt, p); err != nil {
+//				return
+//			}
+//		}
+//	}
+//
+//	http.Handle("/ws", fasthttpws.HTTPHandler(&websocket.Upgrader{}, echo))
+//	fasthttp.ListenAndServe(":8080", fasthttpws.RequestHandler(&websocket.FastHTTPUpgrader{}, echo))
+package fasthttpws
+
+import (
+	"net/http"
+
+	"github.com/gflydev/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultUpgrader is the upgrader used by UpgradeFastHTTP.
+var DefaultUpgrader = &websocket.FastHTTPUpgrader{}
+
+// Handler handles a WebSocket connection after the handshake. The handler
+// owns the connection and must close it when done.
+type Handler func(c *websocket.Conn)
+
+// UpgradeFastHTTP upgrades the fasthttp request to the WebSocket protocol
+// with DefaultUpgrader and returns the connection. See
+// FastHTTPUpgrader.UpgradeConn for details.
+func UpgradeFastHTTP(ctx *fasthttp.RequestCtx) (*websocket.Conn, error) {
+	return DefaultUpgrader.UpgradeConn(ctx)
+}
+
+// RequestHandler returns a fasthttp request handler that upgrades requests
+// with u and runs h in a new goroutine. If the upgrade fails, the upgrader
+// replies to the client with an HTTP error response.
+func RequestHandler(u *websocket.FastHTTPUpgrader, h Handler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		c, err := u.UpgradeConn(ctx)
+		if err != nil {
+			return
+		}
+		go h(c)
+	}
+}
+
+// HTTPHandler returns a net/http handler that upgrades requests with u and
+// runs h. If the upgrade fails, the upgrader replies to the client with an
+// HTTP error response.
+func HTTPHandler(u *websocket.Upgrader, h Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		h(c)
+	})
+}
@@ -0,0 +1,111 @@
+package fasthttpws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+func echo(c *websocket.Conn) {
+	defer c.Close()
+	for {
+		mt, p, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(mt, p); err != nil {
+			return
+		}
+	}
+}
+
+func roundTrip(t *testing.T, c *websocket.Conn) {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := c.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	mt, p, err := c.ReadMessage()
+	if err != nil || mt != websocket.TextMessage || string(p) != "hello" {
+		t.Fatalf("ReadMessage() = %d, %q, %v", mt, p, err)
+	}
+}
+
+func serveFastHTTP(t *testing.T, h fasthttp.RequestHandler) *websocket.Dialer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fasthttp.Server{Handler: h}
+	go func() { _ = s.Serve(ln) }()
+	t.Cleanup(func() { _ = ln.Close() })
+	return &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", ln.Addr().String())
+		},
+	}
+}
+
+func TestRequestHandler(t *testing.T) {
+	d := serveFastHTTP(t, RequestHandler(&websocket.FastHTTPUpgrader{}, echo))
+	c, resp, err := d.Dial("ws://example.com/", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	roundTrip(t, c)
+	roundTrip(t, c)
+}
+
+func TestUpgradeFastHTTP(t *testing.T) {
+	d := serveFastHTTP(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("X-Test", "1")
+		c, err := UpgradeFastHTTP(ctx)
+		if err != nil {
+			return
+		}
+		go echo(c)
+	})
+	c, resp, err := d.Dial("ws://example.com/", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if resp.Header.Get("X-Test") != "1" {
+		t.Error("response header set by the handler not sent")
+	}
+	roundTrip(t, c)
+}
+
+func TestUpgradeFastHTTPError(t *testing.T) {
+	d := serveFastHTTP(t, RequestHandler(&websocket.FastHTTPUpgrader{
+		CheckOrigin: func(*fasthttp.RequestCtx) bool { return false },
+	}, echo))
+	_, resp, err := d.Dial("ws://example.com/", nil)
+	if !errors.Is(err, websocket.ErrBadHandshake) || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial() returned %v, %v", resp, err)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	s := httptest.NewServer(HTTPHandler(&websocket.Upgrader{}, echo))
+	defer s.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	roundTrip(t, c)
+}
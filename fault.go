@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrFaultDisconnect is returned by the write methods when a FaultInjector
+// closed the connection in the middle of a frame.
+var ErrFaultDisconnect = errors.New("websocket: connection closed by fault injector")
+
+// FaultInjector injects network faults into the frames written to a
+// connection, to test how peers handle unreliable networks such as mobile
+// networks. The probabilities are in the range from 0 to 1 and apply to each
+// frame independently. The zero value injects no faults.
+//
+// Close frames are delayed but not otherwise altered, so that the closing
+// handshake completes. A FaultInjector is safe for concurrent use by several
+// connections; do not change the fields while it is in use.
+type FaultInjector struct {
+	// Seed seeds the pseudo-random decisions, so that a failing run can be
+	// repeated. If zero, a random seed is used.
+	Seed uint64
+
+	// Delay and Jitter delay each frame by Delay plus a random duration up
+	// to Jitter before it is written. The delay holds the write lock, so
+	// that the frames keep their order.
+	Delay, Jitter time.Duration
+
+	// DropRate is the probability that a frame is discarded. The write
+	// reports success.
+	DropRate float64
+
+	// CorruptRate is the probability that a bit of the payload of a frame
+	// is flipped.
+	CorruptRate float64
+
+	// DisconnectRate is the probability that the connection is closed after
+	// writing half of a frame. The write returns ErrFaultDisconnect.
+	DisconnectRate float64
+
+	// DuplicatePingRate is the probability that a ping frame is written
+	// twice.
+	DuplicatePingRate float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// SetFaultInjector injects the faults of fi into the frames written to the
+// connection. A nil fault injector stops the injection. Use fault injection
+// in tests only.
+//
+// SetFaultInjector is safe to call concurrently with all other methods.
+func (c *Conn) SetFaultInjector(fi *FaultInjector) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	c.faults.Store(fi)
+	return nil
+}
+
+// faults are the faults injected into a frame.
+type faults struct {
+	delay      time.Duration
+	drop       bool
+	corrupt    bool
+	disconnect bool
+	duplicate  bool
+	pos        uint64 // random position of the corrupted bit
+}
+
+func (fi *FaultInjector) next(frameType int) faults {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.rng == nil {
+		seed := fi.Seed
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+		fi.rng = rand.New(rand.NewPCG(seed, seed))
+	}
+	f := faults{delay: fi.Delay}
+	if fi.Jitter > 0 {
+		f.delay += time.Duration(fi.rng.Int64N(int64(fi.Jitter)))
+	}
+	if frameType == CloseMessage {
+		return f
+	}
+	f.drop = fi.rng.Float64() < fi.DropRate
+	f.corrupt = fi.rng.Float64() < fi.CorruptRate
+	f.disconnect = fi.rng.Float64() < fi.DisconnectRate
+	f.duplicate = frameType == PingMessage && fi.rng.Float64() < fi.DuplicatePingRate
+	f.pos = fi.rng.Uint64()
+	return f
+}
+
+// injectFaults applies the fault injector to a frame about to be written.
+// It returns the bytes to write instead of the frame, or done if the write
+// is complete. The caller must hold the write lock.
+func (c *Conn) injectFaults(fi *FaultInjector, frameType int, deadline time.Time, buf0, buf1 []byte) (frame []byte, done bool, err error) {
+	f := fi.next(frameType)
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	if f.drop {
+		return nil, true, nil
+	}
+
+	// Copy the frame: buf1 and prepared frames belong to the caller.
+	frame = make([]byte, 0, len(buf0)+len(buf1))
+	frame = append(append(frame, buf0...), buf1...)
+	if f.disconnect {
+		if cw := c.coalescer.Load(); cw != nil {
+			_ = c.flushCoalesced(cw, deadline)
+		}
+		if conn := c.conn; conn != nil {
+			_ = conn.SetWriteDeadline(deadline)
+			_, _ = conn.Write(frame[:len(frame)/2])
+			_ = conn.Close()
+		}
+		return nil, true, c.writeFatal(ErrFaultDisconnect)
+	}
+	if n := framePayloadLen(frame); f.corrupt && n > 0 {
+		i := len(frame) - n + int(f.pos%uint64(n))
+		frame[i] ^= 1 << (f.pos / uint64(n) % 8)
+	}
+	if f.duplicate {
+		frame = append(frame, frame...)
+	}
+	return frame, false, nil
+}
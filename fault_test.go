@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultInjector(t *testing.T) {
+	payload := []byte(strings.Repeat("payload ", 16))
+	for _, tt := range []struct {
+		name string
+		fi   *FaultInjector
+		want func(t *testing.T, rc *Conn, wire []byte)
+	}{
+		{"drop", &FaultInjector{DropRate: 1}, func(t *testing.T, rc *Conn, wire []byte) {
+			if len(wire) != 0 {
+				t.Errorf("%d bytes written", len(wire))
+			}
+		}},
+		{"corrupt", &FaultInjector{CorruptRate: 1, Seed: 1}, func(t *testing.T, rc *Conn, wire []byte) {
+			_, p, err := rc.ReadMessage()
+			if err != nil || len(p) != len(payload) || bytes.Equal(p, payload) {
+				t.Errorf("ReadMessage = %q, %v", p, err)
+			}
+		}},
+		{"none", &FaultInjector{}, func(t *testing.T, rc *Conn, wire []byte) {
+			if _, p, err := rc.ReadMessage(); err != nil || !bytes.Equal(p, payload) {
+				t.Errorf("ReadMessage = %q, %v", p, err)
+			}
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			wc := newTestConn(nil, &wire, false)
+			rc := newTestConn(&wire, nil, true)
+			_ = wc.SetFaultInjector(tt.fi)
+			if err := wc.WriteMessage(BinaryMessage, payload); err != nil {
+				t.Fatal(err)
+			}
+			tt.want(t, rc, wire.Bytes())
+		})
+	}
+}
+
+func TestFaultInjectorControl(t *testing.T) {
+	var wire bytes.Buffer
+	wc := newTestConn(nil, &wire, true)
+	rc := newTestConn(&wire, io.Discard, false)
+	_ = wc.SetFaultInjector(&FaultInjector{DuplicatePingRate: 1, Delay: 20 * time.Millisecond})
+
+	start := time.Now()
+	_ = wc.WriteControl(PingMessage, []byte("p"), time.Now().Add(time.Second))
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("ping written after %v, want delay", d)
+	}
+	_ = wc.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+	var pings int
+	rc.SetPingHandler(func(string) error { pings++; return nil })
+	if _, _, err := rc.ReadMessage(); !IsCloseError(err, CloseNormalClosure) {
+		t.Fatalf("ReadMessage returned %v, want close", err)
+	}
+	if pings != 2 {
+		t.Errorf("%d pings, want 2", pings)
+	}
+}
+
+func TestFaultDisconnect(t *testing.T) {
+	s, c := newTCPConns(t)
+	_ = c.SetFaultInjector(&FaultInjector{DisconnectRate: 1})
+	if err := c.WriteMessage(TextMessage, []byte("hello, world")); err != ErrFaultDisconnect {
+		t.Fatalf("WriteMessage returned %v, want %v", err, ErrFaultDisconnect)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("again")); err != ErrFaultDisconnect {
+		t.Errorf("second WriteMessage returned %v", err)
+	}
+	if _, _, err := s.ReadMessage(); !IsCloseError(err, CloseAbnormalClosure) {
+		t.Errorf("peer ReadMessage returned %v, want abnormal closure", err)
+	}
+
+	var nilConn *Conn
+	if err := nilConn.SetFaultInjector(nil); err != ErrNilConn {
+		t.Errorf("nil Conn returned %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package gflyws connects gFly applications to WebSocket connections: it
+// upgrades the requests of gFly handlers and attaches the values of their
+// gFly session to the connections, so that the handlers of the connections
+// are session aware without looking the session up again.
+//
+//	sessions := &gflyws.Sessions{Keys: []string{"user_id", "cart"}, CookieName: "session_id", Save: saveToRedis}
+//
+//	func (h *ChatHandler) Handle(c *core.Ctx) error {
+//		conn, err := gflyws.Upgrade(c, upgrader, sessions)
+//		if err != nil {
+//			return nil // the upgrader replied to the client
+//		}
+//		go chat(conn) // conn.Session().Get("user_id")
+//		return nil
+//	}
+package gflyws
+
+import (
+	"github.com/gflydev/core"
+	"github.com/gflydev/websocket"
+)
+
+// SessionReader reads the session and the cookies of a request. *core.Ctx
+// implements SessionReader.
+type SessionReader interface {
+	GetSession(key string) any
+	GetCookie(key string) string
+}
+
+// Sessions loads the gFly session of upgrading requests into the sessions
+// of the connections. The gFly session manager, registered with
+// core.RegisterSession, must be set up.
+type Sessions struct {
+	// Keys lists the session keys whose values are copied to the session of
+	// the connection. The keys without a value are left out.
+	Keys []string
+
+	// CookieName, if not empty, names the cookie holding the session ID,
+	// used as the ID of the session of the connection.
+	CookieName string
+
+	// Save, if not nil, persists a session changed during a connection when
+	// the connection is closed. The gFly session manager reads and writes
+	// sessions during a request only, so Save typically writes to the store
+	// behind the session manager, such as Redis, under the session ID.
+	Save func(s *websocket.Session) error
+}
+
+// Load returns the session of the request read by c.
+func (s *Sessions) Load(c SessionReader) *websocket.Session {
+	var id string
+	if s.CookieName != "" {
+		id = c.GetCookie(s.CookieName)
+	}
+	values := make(map[string]interface{}, len(s.Keys))
+	for _, key := range s.Keys {
+		if v := c.GetSession(key); v != nil {
+			values[key] = v
+		}
+	}
+	return websocket.NewSession(id, values, s.Save)
+}
+
+// Upgrade upgrades the request of a gFly handler with u and attaches the
+// session loaded by s to the connection, if s is not nil. If u is nil, a
+// zero FastHTTPUpgrader is used. If the upgrade fails, the upgrader replies
+// to the client with an HTTP error response.
+func Upgrade(c *core.Ctx, u *websocket.FastHTTPUpgrader, s *Sessions) (*websocket.Conn, error) {
+	if u == nil {
+		u = &websocket.FastHTTPUpgrader{}
+	}
+	var session *websocket.Session
+	if s != nil {
+		// The session is read before the request is hijacked.
+		session = s.Load(c)
+	}
+	conn, err := u.UpgradeConn(c.Root())
+	if err != nil {
+		return nil, err
+	}
+	if session != nil {
+		conn.SetSession(session)
+	}
+	return conn, nil
+}
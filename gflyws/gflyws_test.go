@@ -0,0 +1,47 @@
+package gflyws
+
+import (
+	"testing"
+
+	"github.com/gflydev/websocket"
+)
+
+type fakeRequest struct {
+	session map[string]any
+	cookies map[string]string
+}
+
+func (r *fakeRequest) GetSession(key string) any   { return r.session[key] }
+func (r *fakeRequest) GetCookie(key string) string { return r.cookies[key] }
+
+func TestSessionsLoad(t *testing.T) {
+	var saved *websocket.Session
+	s := &Sessions{
+		Keys:       []string{"user_id", "cart", "missing"},
+		CookieName: "session_id",
+		Save: func(sess *websocket.Session) error {
+			saved = sess
+			return nil
+		},
+	}
+	r := &fakeRequest{
+		session: map[string]any{"user_id": 42, "cart": []string{"a"}, "other": true},
+		cookies: map[string]string{"session_id": "abc"},
+	}
+	sess := s.Load(r)
+	if sess.ID() != "abc" {
+		t.Errorf("ID returned %q, want abc", sess.ID())
+	}
+	values := sess.Values()
+	if len(values) != 2 || values["user_id"] != 42 {
+		t.Fatalf("Values returned %v, want user_id and cart", values)
+	}
+
+	sess.Set("user_id", 7)
+	if err := sess.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if saved != sess {
+		t.Fatal("Save not called with the session")
+	}
+}
@@ -0,0 +1,502 @@
+// Package graphqlws serves GraphQL over WebSocket with the graphql-ws
+// library's graphql-transport-ws subprotocol and the legacy graphql-ws
+// subprotocol of subscriptions-transport-ws.
+//
+// The package implements the message flow of the protocols and leaves the
+// execution of operations to an Executor, so any GraphQL implementation can
+// be plugged in:
+//
+//	srv := &graphqlws.Server{
+//		Executor: graphqlws.ExecutorFunc(func(ctx context.Context, req *graphqlws.Request) (<-chan *graphqlws.Result, error) {
+//			return schema.Subscribe(ctx, req.Query, req.OperationName, req.Variables)
+//		}),
+//	}
+//	http.Handle("/graphql", srv)
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// Subprotocol names.
+const (
+	// TransportWS is the graphql-transport-ws subprotocol of the graphql-ws
+	// library.
+	TransportWS = "graphql-transport-ws"
+
+	// LegacyWS is the graphql-ws subprotocol of the deprecated
+	// subscriptions-transport-ws library.
+	LegacyWS = "graphql-ws"
+)
+
+// Close codes of the graphql-transport-ws subprotocol.
+const (
+	CloseBadRequest          = 4400
+	CloseUnauthorized        = 4401
+	CloseForbidden           = 4403
+	CloseInitTimeout         = 4408
+	CloseSubscriberExists    = 4409
+	CloseTooManyInitRequests = 4429
+)
+
+const (
+	defaultInitTimeout = 3 * time.Second
+	defaultKeepAlive   = 12 * time.Second
+	writeWait          = 10 * time.Second
+)
+
+// Request is a GraphQL operation requested by the client.
+type Request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Result is a GraphQL execution result sent to the client.
+type Result struct {
+	Data       interface{}            `json:"data,omitempty"`
+	Errors     []*Error               `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Error is a GraphQL error.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Location is a position in the query of an operation.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Errors is a list of GraphQL errors returned by an Executor before the
+// operation starts, such as validation errors.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	if len(e) == 0 {
+		return "graphqlws: no errors"
+	}
+	return e[0].Message
+}
+
+// Executor executes GraphQL operations.
+type Executor interface {
+	// Execute starts the operation and returns a channel of results. The
+	// executor sends a single result for queries and mutations and one
+	// result per event for subscriptions, and closes the channel when the
+	// operation completes. The executor must stop and close the channel
+	// when ctx is done, which happens when the client cancels the
+	// operation or the connection closes.
+	//
+	// An error returned by Execute, such as a validation error, is
+	// reported to the client as the errors of the operation. Return an
+	// Errors or *Error to control the reported errors.
+	Execute(ctx context.Context, req *Request) (<-chan *Result, error)
+}
+
+// ExecutorFunc adapts a function to the Executor interface.
+type ExecutorFunc func(ctx context.Context, req *Request) (<-chan *Result, error)
+
+// Execute calls f(ctx, req).
+func (f ExecutorFunc) Execute(ctx context.Context, req *Request) (<-chan *Result, error) {
+	return f(ctx, req)
+}
+
+// Server serves GraphQL over WebSocket. It implements both subprotocols and
+// uses the one selected during the handshake.
+type Server struct {
+	// Executor executes the operations.
+	Executor Executor
+
+	// Upgrader upgrades the HTTP requests to WebSocket connections. If
+	// Upgrader.Subprotocols is empty, both subprotocols are offered, with
+	// TransportWS preferred.
+	Upgrader websocket.Upgrader
+
+	// InitTimeout specifies how long the server waits for the
+	// connection_init message before closing the connection. If zero, a
+	// default of 3 seconds is used.
+	InitTimeout time.Duration
+
+	// KeepAlive specifies how often the server sends ping messages, or ka
+	// messages with LegacyWS. If zero, a default of 12 seconds is used. If
+	// negative, no keepalive messages are sent.
+	KeepAlive time.Duration
+
+	// OnInit, if not nil, is called with the payload of the
+	// connection_init message. It returns the context used for the
+	// operations of the connection, typically carrying the authenticated
+	// user, and the payload of the connection_ack message. If OnInit
+	// returns an error, the connection is rejected.
+	OnInit func(ctx context.Context, payload map[string]interface{}) (context.Context, map[string]interface{}, error)
+}
+
+// ServeHTTP upgrades the request and serves the connection until it closes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := s.Upgrader
+	if len(u.Subprotocols) == 0 {
+		u.Subprotocols = []string{TransportWS, LegacyWS}
+	}
+	c, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.ServeConn(r.Context(), c)
+}
+
+// ServeConn serves GraphQL on an upgraded connection until the connection
+// closes, then closes it. The subprotocol is the one negotiated on the
+// connection; connections without subprotocol use TransportWS. The
+// operations of the connection are canceled when ctx is done.
+func (s *Server) ServeConn(ctx context.Context, c *websocket.Conn) {
+	proto := c.Subprotocol()
+	if proto != LegacyWS {
+		proto = TransportWS
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	conn := &conn{
+		server: s,
+		ws:     c,
+		legacy: proto == LegacyWS,
+		ctx:    ctx,
+		cancel: cancel,
+		ops:    make(map[string]context.CancelFunc),
+	}
+	conn.serve()
+}
+
+// message is a message of either subprotocol.
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// conn is a GraphQL WebSocket connection.
+type conn struct {
+	server *Server
+	ws     *websocket.Conn
+	legacy bool
+
+	ctx    context.Context // context of the operations, set by OnInit
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	ops   map[string]context.CancelFunc // guarded by mu
+	acked bool                          // guarded by mu
+	wg    sync.WaitGroup
+}
+
+func (c *conn) write(m *message) error {
+	p, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(websocket.TextMessage, p)
+}
+
+func (c *conn) writePayload(id, typ string, payload interface{}) error {
+	m := &message{ID: id, Type: typ}
+	if payload != nil {
+		p, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		m.Payload = p
+	}
+	return c.write(m)
+}
+
+// closeWith sends a close message and closes the connection.
+func (c *conn) closeWith(code int, reason string) {
+	c.writeMu.Lock()
+	_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	c.writeMu.Unlock()
+	_ = c.ws.Close()
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.cancel()
+		c.wg.Wait()
+		_ = c.ws.Close()
+	}()
+
+	initTimeout := c.server.InitTimeout
+	if initTimeout <= 0 {
+		initTimeout = defaultInitTimeout
+	}
+	timer := time.AfterFunc(initTimeout, func() {
+		c.mu.Lock()
+		acked := c.acked
+		c.mu.Unlock()
+		if !acked {
+			c.closeWith(CloseInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+
+	for {
+		mt, p, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var m message
+		if mt != websocket.TextMessage || json.Unmarshal(p, &m) != nil || m.Type == "" {
+			c.closeWith(CloseBadRequest, "Invalid message received")
+			return
+		}
+		if !c.handle(&m) {
+			return
+		}
+	}
+}
+
+// handle handles a message and reports whether the connection should stay
+// open.
+func (c *conn) handle(m *message) bool {
+	switch m.Type {
+	case "connection_init":
+		return c.init(m)
+	case "ping":
+		if c.legacy {
+			break
+		}
+		return c.write(&message{Type: "pong", Payload: m.Payload}) == nil
+	case "pong":
+		return !c.legacy
+	case "subscribe", "start":
+		if (m.Type == "start") != c.legacy {
+			break
+		}
+		return c.subscribe(m)
+	case "complete", "stop":
+		if (m.Type == "stop") != c.legacy {
+			break
+		}
+		c.cancelOp(m.ID)
+		return true
+	case "connection_terminate":
+		if !c.legacy {
+			break
+		}
+		c.closeWith(websocket.CloseNormalClosure, "")
+		return false
+	}
+	if c.legacy {
+		_ = c.writePayload(m.ID, "error", &Error{Message: "unknown message type " + m.Type})
+		return true
+	}
+	c.closeWith(CloseBadRequest, "Invalid message received")
+	return false
+}
+
+// init handles the connection_init message.
+func (c *conn) init(m *message) bool {
+	c.mu.Lock()
+	if c.acked {
+		c.mu.Unlock()
+		if c.legacy {
+			return true
+		}
+		c.closeWith(CloseTooManyInitRequests, "Too many initialisation requests")
+		return false
+	}
+	c.mu.Unlock()
+
+	var payload map[string]interface{}
+	if len(m.Payload) > 0 {
+		if err := json.Unmarshal(m.Payload, &payload); err != nil {
+			c.closeWith(CloseBadRequest, "Invalid message received")
+			return false
+		}
+	}
+	var ack map[string]interface{}
+	if c.server.OnInit != nil {
+		ctx, a, err := c.server.OnInit(c.ctx, payload)
+		if err != nil {
+			if c.legacy {
+				_ = c.writePayload("", "connection_error", &Error{Message: err.Error()})
+				c.closeWith(websocket.CloseNormalClosure, err.Error())
+			} else {
+				c.closeWith(CloseForbidden, "Forbidden")
+			}
+			return false
+		}
+		if ctx != nil {
+			c.ctx = ctx
+		}
+		ack = a
+	}
+
+	c.mu.Lock()
+	c.acked = true
+	c.mu.Unlock()
+	var ackPayload interface{}
+	if ack != nil {
+		ackPayload = ack
+	}
+	if err := c.writePayload("", "connection_ack", ackPayload); err != nil {
+		return false
+	}
+	c.startKeepAlive()
+	return true
+}
+
+// startKeepAlive sends keepalive messages until the connection closes.
+func (c *conn) startKeepAlive() {
+	interval := c.server.KeepAlive
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultKeepAlive
+	}
+	typ := "ping"
+	if c.legacy {
+		typ = "ka"
+		if err := c.write(&message{Type: typ}); err != nil {
+			return
+		}
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-t.C:
+				if err := c.write(&message{Type: typ}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// subscribe starts an operation.
+func (c *conn) subscribe(m *message) bool {
+	c.mu.Lock()
+	acked := c.acked
+	c.mu.Unlock()
+	if !acked {
+		if c.legacy {
+			_ = c.writePayload(m.ID, "error", &Error{Message: "connection not initialised"})
+			return true
+		}
+		c.closeWith(CloseUnauthorized, "Unauthorized")
+		return false
+	}
+
+	var req Request
+	if m.ID == "" || json.Unmarshal(m.Payload, &req) != nil {
+		if c.legacy {
+			_ = c.writePayload(m.ID, "error", &Error{Message: "invalid operation"})
+			return true
+		}
+		c.closeWith(CloseBadRequest, "Invalid message received")
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	if _, ok := c.ops[m.ID]; ok {
+		c.mu.Unlock()
+		cancel()
+		if c.legacy {
+			_ = c.writePayload(m.ID, "error", &Error{Message: "subscriber for " + m.ID + " already exists"})
+			return true
+		}
+		c.closeWith(CloseSubscriberExists, "Subscriber for "+m.ID+" already exists")
+		return false
+	}
+	c.ops[m.ID] = cancel
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	go c.run(ctx, m.ID, &req)
+	return true
+}
+
+// run executes an operation and sends its results.
+func (c *conn) run(ctx context.Context, id string, req *Request) {
+	defer c.wg.Done()
+	defer c.cancelOp(id)
+
+	results, err := c.server.Executor.Execute(ctx, req)
+	if err != nil {
+		// Remove the operation first so that the client can reuse the ID
+		// as soon as it receives the final message.
+		c.cancelOp(id)
+		var errs Errors
+		var e *Error
+		switch {
+		case errors.As(err, &errs):
+		case errors.As(err, &e):
+			errs = Errors{e}
+		default:
+			errs = Errors{{Message: err.Error()}}
+		}
+		if c.legacy {
+			// The legacy protocol reports errors as a data message.
+			_ = c.writePayload(id, "data", &Result{Errors: errs})
+			_ = c.writePayload(id, "complete", nil)
+			return
+		}
+		_ = c.writePayload(id, "error", []*Error(errs))
+		return
+	}
+
+	next := "next"
+	if c.legacy {
+		next = "data"
+	}
+	for r := range results {
+		if ctx.Err() != nil {
+			continue // drain until the executor closes the channel
+		}
+		if err := c.writePayload(id, next, r); err != nil {
+			return
+		}
+	}
+	canceled := ctx.Err() != nil
+	c.cancelOp(id)
+	if !canceled {
+		_ = c.writePayload(id, "complete", nil)
+	}
+}
+
+// cancelOp removes an operation and cancels its context, if it is still
+// running.
+func (c *conn) cancelOp(id string) {
+	c.mu.Lock()
+	cancel, ok := c.ops[id]
+	delete(c.ops, id)
+	c.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
@@ -0,0 +1,234 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+type userKey struct{}
+
+// testExecutor answers "query" with a single result, streams the numbers
+// 1 to 3 for "counter", blocks until canceled for "forever" and fails
+// validation otherwise.
+func testExecutor(canceled chan<- string) Executor {
+	return ExecutorFunc(func(ctx context.Context, req *Request) (<-chan *Result, error) {
+		ch := make(chan *Result)
+		switch req.Query {
+		case "query":
+			user, _ := ctx.Value(userKey{}).(string)
+			go func() {
+				defer close(ch)
+				ch <- &Result{Data: map[string]interface{}{"user": user, "n": req.Variables["n"]}}
+			}()
+		case "counter":
+			go func() {
+				defer close(ch)
+				for i := 1; i <= 3; i++ {
+					select {
+					case ch <- &Result{Data: map[string]int{"count": i}}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		case "forever":
+			go func() {
+				defer close(ch)
+				<-ctx.Done()
+				canceled <- req.OperationName
+			}()
+		default:
+			return nil, Errors{{Message: "syntax error", Locations: []Location{{Line: 1, Column: 1}}}}
+		}
+		return ch, nil
+	})
+}
+
+func dial(t *testing.T, srv *Server, proto string) *websocket.Conn {
+	t.Helper()
+	s := httptest.NewServer(srv)
+	t.Cleanup(s.Close)
+	d := websocket.Dialer{Subprotocols: []string{proto}}
+	c, _, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if c.Subprotocol() != proto {
+		t.Fatalf("subprotocol %q, want %q", c.Subprotocol(), proto)
+	}
+	return c
+}
+
+func send(t *testing.T, c *websocket.Conn, s string) {
+	t.Helper()
+	if err := c.WriteMessage(websocket.TextMessage, []byte(s)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect reads a message, skipping keepalives, and compares it with want
+// as JSON.
+func expect(t *testing.T, c *websocket.Conn, want string) {
+	t.Helper()
+	for {
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, p, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v, want %s", err, want)
+		}
+		var got, w interface{}
+		if err := json.Unmarshal(p, &got); err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := got.(map[string]interface{}); ok && (m["type"] == "ka" || m["type"] == "ping") && !strings.Contains(want, `"`+m["type"].(string)+`"`) {
+			continue
+		}
+		if err := json.Unmarshal([]byte(want), &w); err != nil {
+			t.Fatal(err)
+		}
+		gj, _ := json.Marshal(got)
+		wj, _ := json.Marshal(w)
+		if string(gj) != string(wj) {
+			t.Fatalf("got %s, want %s", p, want)
+		}
+		return
+	}
+}
+
+func expectClose(t *testing.T, c *websocket.Conn, code int) {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, p, err := c.ReadMessage()
+		if err == nil {
+			t.Logf("skipping %s", p)
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != code {
+			t.Fatalf("got %v, want close %d", err, code)
+		}
+		return
+	}
+}
+
+func newServer(canceled chan<- string) *Server {
+	return &Server{
+		Executor: testExecutor(canceled),
+		OnInit: func(ctx context.Context, payload map[string]interface{}) (context.Context, map[string]interface{}, error) {
+			token, _ := payload["token"].(string)
+			if token == "" {
+				return nil, nil, errors.New("missing token")
+			}
+			return context.WithValue(ctx, userKey{}, token), map[string]interface{}{"hello": token}, nil
+		},
+	}
+}
+
+func TestTransportWS(t *testing.T) {
+	canceled := make(chan string, 1)
+	c := dial(t, newServer(canceled), TransportWS)
+
+	send(t, c, `{"type":"connection_init","payload":{"token":"alice"}}`)
+	expect(t, c, `{"type":"connection_ack","payload":{"hello":"alice"}}`)
+
+	send(t, c, `{"type":"ping","payload":{"x":1}}`)
+	expect(t, c, `{"type":"pong","payload":{"x":1}}`)
+
+	send(t, c, `{"id":"1","type":"subscribe","payload":{"query":"query","variables":{"n":7}}}`)
+	expect(t, c, `{"id":"1","type":"next","payload":{"data":{"user":"alice","n":7}}}`)
+	expect(t, c, `{"id":"1","type":"complete"}`)
+
+	send(t, c, `{"id":"2","type":"subscribe","payload":{"query":"counter"}}`)
+	for i := 1; i <= 3; i++ {
+		expect(t, c, `{"id":"2","type":"next","payload":{"data":{"count":`+string(rune('0'+i))+`}}}`)
+	}
+	expect(t, c, `{"id":"2","type":"complete"}`)
+
+	send(t, c, `{"id":"3","type":"subscribe","payload":{"query":"bad"}}`)
+	expect(t, c, `{"id":"3","type":"error","payload":[{"message":"syntax error","locations":[{"line":1,"column":1}]}]}`)
+
+	send(t, c, `{"id":"4","type":"subscribe","payload":{"query":"forever","operationName":"op4"}}`)
+	send(t, c, `{"id":"4","type":"complete"}`)
+	if name := <-canceled; name != "op4" {
+		t.Fatalf("canceled %q", name)
+	}
+
+	send(t, c, `{"id":"5","type":"subscribe","payload":{"query":"forever"}}`)
+	send(t, c, `{"id":"5","type":"subscribe","payload":{"query":"forever"}}`)
+	expectClose(t, c, CloseSubscriberExists)
+	<-canceled
+}
+
+func TestTransportWSErrors(t *testing.T) {
+	srv := newServer(nil)
+
+	c := dial(t, srv, TransportWS)
+	send(t, c, `{"id":"1","type":"subscribe","payload":{"query":"query"}}`)
+	expectClose(t, c, CloseUnauthorized)
+
+	c = dial(t, srv, TransportWS)
+	send(t, c, `{"type":"connection_init"}`)
+	expectClose(t, c, CloseForbidden)
+
+	c = dial(t, srv, TransportWS)
+	send(t, c, `{"type":"connection_init","payload":{"token":"a"}}`)
+	send(t, c, `{"type":"connection_init","payload":{"token":"a"}}`)
+	expectClose(t, c, CloseTooManyInitRequests)
+
+	c = dial(t, srv, TransportWS)
+	send(t, c, `not json`)
+	expectClose(t, c, CloseBadRequest)
+
+	srv.InitTimeout = 10 * time.Millisecond
+	c = dial(t, srv, TransportWS)
+	expectClose(t, c, CloseInitTimeout)
+}
+
+func TestTransportWSKeepAlive(t *testing.T) {
+	srv := newServer(nil)
+	srv.KeepAlive = 10 * time.Millisecond
+	c := dial(t, srv, TransportWS)
+	send(t, c, `{"type":"connection_init","payload":{"token":"a"}}`)
+	expect(t, c, `{"type":"connection_ack","payload":{"hello":"a"}}`)
+	expect(t, c, `{"type":"ping"}`)
+}
+
+func TestLegacyWS(t *testing.T) {
+	canceled := make(chan string, 1)
+	srv := newServer(canceled)
+	c := dial(t, srv, LegacyWS)
+
+	send(t, c, `{"type":"connection_init","payload":{"token":"bob"}}`)
+	expect(t, c, `{"type":"connection_ack","payload":{"hello":"bob"}}`)
+	expect(t, c, `{"type":"ka"}`)
+
+	send(t, c, `{"id":"1","type":"start","payload":{"query":"query"}}`)
+	expect(t, c, `{"id":"1","type":"data","payload":{"data":{"user":"bob","n":null}}}`)
+	expect(t, c, `{"id":"1","type":"complete"}`)
+
+	send(t, c, `{"id":"2","type":"start","payload":{"query":"bad"}}`)
+	expect(t, c, `{"id":"2","type":"data","payload":{"errors":[{"message":"syntax error","locations":[{"line":1,"column":1}]}]}}`)
+	expect(t, c, `{"id":"2","type":"complete"}`)
+
+	send(t, c, `{"id":"3","type":"start","payload":{"query":"forever","operationName":"op3"}}`)
+	send(t, c, `{"id":"3","type":"stop"}`)
+	if name := <-canceled; name != "op3" {
+		t.Fatalf("canceled %q", name)
+	}
+
+	send(t, c, `{"type":"connection_terminate"}`)
+	expectClose(t, c, websocket.CloseNormalClosure)
+
+	c = dial(t, srv, LegacyWS)
+	send(t, c, `{"type":"connection_init"}`)
+	expect(t, c, `{"type":"connection_error","payload":{"message":"missing token"}}`)
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+var (
+	// ErrHandshakeTimeout is reported to Upgrader.OnHandshakeLimit when a
+	// handshake does not complete within the HandshakeTimeout, and is
+	// returned by UpgradeNetConn when the request is not read in time.
+	ErrHandshakeTimeout = errors.New("websocket: handshake timeout")
+
+	// ErrRequestHeaderTooLarge is reported to Upgrader.OnHandshakeLimit and
+	// returned by UpgradeNetConn when the upgrade request is larger than
+	// the MaxHeaderBytes of the upgrader.
+	ErrRequestHeaderTooLarge = errors.New("websocket: request header too large")
+)
+
+// requestHeaderSize returns the size of the request line and headers of r
+// as sent by the client.
+func requestHeaderSize(r *http.Request) int {
+	n := len(r.Method) + 1 + len(r.RequestURI) + 1 + len(r.Proto) + 2
+	n += len("Host: \r\n") + len(r.Host)
+	for k, vs := range r.Header {
+		for _, v := range vs {
+			n += len(k) + len(": \r\n") + len(v)
+		}
+	}
+	return n + 2
+}
+
+// handshakeLimit reports a handshake that exceeded a limit.
+func (u *Upgrader) handshakeLimit(remoteAddr string, err error) {
+	if u.Logger != nil {
+		u.Logger.Log(slog.LevelWarn, "websocket: handshake limit exceeded", "remote_addr", remoteAddr, "error", err.Error())
+	}
+	if u.OnHandshakeLimit != nil {
+		u.OnHandshakeLimit(remoteAddr, err)
+	}
+}
+
+// isTimeout reports whether err is a network timeout.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// limitedHeaderReader limits the bytes read from a connection until the
+// request headers are read. A negative n removes the limit.
+type limitedHeaderReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedHeaderReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return l.r.Read(p)
+	}
+	if l.n == 0 {
+		l.exceeded = true
+		return 0, ErrRequestHeaderTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// limitRecorder records the calls to OnHandshakeLimit.
+type limitRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *limitRecorder) record(remoteAddr string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, err)
+}
+
+func (l *limitRecorder) get() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errs...)
+}
+
+func TestUpgradeNetConnTimeout(t *testing.T) {
+	var limits limitRecorder
+	u := Upgrader{HandshakeTimeout: 20 * time.Millisecond, OnHandshakeLimit: limits.record}
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	if _, _, err := u.UpgradeNetConn(sc, nil); err != ErrHandshakeTimeout {
+		t.Fatalf("UpgradeNetConn returned %v, want %v", err, ErrHandshakeTimeout)
+	}
+	if errs := limits.get(); len(errs) != 1 || errs[0] != ErrHandshakeTimeout {
+		t.Fatalf("OnHandshakeLimit got %v", errs)
+	}
+}
+
+func TestUpgradeNetConnHeaderLimit(t *testing.T) {
+	var limits limitRecorder
+	u := Upgrader{MaxHeaderBytes: 512, OnHandshakeLimit: limits.record}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		_, _, err = u.UpgradeNetConn(nc, nil)
+		done <- err
+	}()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	req := "GET /ws HTTP/1.1\r\nHost: example.com\r\nX-Big: " + strings.Repeat("x", 1024) + "\r\n\r\n"
+	if _, err := nc.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(nc), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusRequestHeaderFieldsTooLarge)
+	}
+	if err := <-done; err != ErrRequestHeaderTooLarge {
+		t.Errorf("UpgradeNetConn returned %v, want %v", err, ErrRequestHeaderTooLarge)
+	}
+	if errs := limits.get(); len(errs) != 1 || errs[0] != ErrRequestHeaderTooLarge {
+		t.Errorf("OnHandshakeLimit got %v", errs)
+	}
+}
+
+func TestUpgradeHeaderLimit(t *testing.T) {
+	var limits limitRecorder
+	u := Upgrader{MaxHeaderBytes: 1024, OnHandshakeLimit: limits.record}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c.Close()
+	}))
+	defer s.Close()
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	c, _, err := DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial within the limit: %v", err)
+	}
+	c.Close()
+
+	_, resp, err := DefaultDialer.Dial(url, http.Header{"X-Big": {strings.Repeat("x", 1024)}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("Dial returned %v, %v", resp, err)
+	}
+	if errs := limits.get(); len(errs) != 1 || errs[0] != ErrRequestHeaderTooLarge {
+		t.Errorf("OnHandshakeLimit got %v", errs)
+	}
+}
@@ -0,0 +1,324 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errHTTP2Unavailable is returned by dialHTTP2 when the handshake cannot be
+// performed over HTTP/2 and the dialer should fall back to HTTP/1.1.
+var errHTTP2Unavailable = errors.New("websocket: extended CONNECT unavailable")
+
+// isExtendedConnect reports whether r is an HTTP/2 extended CONNECT request
+// for the websocket protocol (RFC 8441).
+func isExtendedConnect(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodConnect && r.Header.Get(":protocol") == "websocket"
+}
+
+// upgradeHTTP2 accepts an RFC 8441 handshake. The stream of the request
+// carries the WebSocket connection, so the connection lives only as long as
+// the handler that called Upgrade.
+func (u *Upgrader) upgradeHTTP2(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if c, err := u.checkRequest(w, r, responseHeader); err != nil {
+		return c, err
+	}
+
+	adm, err := u.admit(w, r)
+	if err != nil {
+		return nil, err
+	}
+	defer adm.cancel()
+
+	subprotocol := u.selectSubprotocol(r, responseHeader)
+	exts := u.negotiateExtensions(r)
+
+	h := w.Header()
+	for k, vs := range responseHeader {
+		if k == "Sec-Websocket-Protocol" {
+			continue
+		}
+		h[k] = vs
+	}
+	if subprotocol != "" {
+		h.Set("Sec-Websocket-Protocol", subprotocol)
+	}
+	if len(exts) > 0 {
+		h.Set("Sec-Websocket-Extensions", joinExtensionHeaders(exts))
+	}
+
+	rc := http.NewResponseController(w)
+	if u.HandshakeTimeout > 0 {
+		_ = rc.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+	if u.HandshakeTimeout > 0 {
+		_ = rc.SetWriteDeadline(time.Time{})
+	}
+
+	netConn := &http2ServerConn{
+		body:   r.Body,
+		w:      w,
+		rc:     rc,
+		remote: streamAddr(r.RemoteAddr),
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		netConn.local = addr
+	}
+
+	c := u.createWebSocketConnection(netConn, subprotocol, exts, nil, nil)
+	if err := u.upgradeSetup().complete(c, adm); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialHTTP2 performs an RFC 8441 handshake with d.HTTP2Transport. It returns
+// errHTTP2Unavailable if the transport does not use HTTP/2 for the request
+// or the server does not support extended CONNECT.
+func (d *Dialer) dialHTTP2(ctx context.Context, u *url.URL, requestHeader http.Header) (*Conn, *http.Response, error) {
+	// The challenge key is not used with HTTP/2.
+	req, err := d.createHandshakeRequest(ctx, u, "", requestHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	delete(req.Header, "Upgrade")
+	delete(req.Header, "Connection")
+	delete(req.Header, "Sec-WebSocket-Key")
+	req.Method = http.MethodConnect
+	req.Header[":protocol"] = []string{"websocket"}
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+
+	// The stream outlives the context passed to DialContext, which only
+	// bounds the handshake.
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	netConn := &http2ClientConn{cancel: cancel}
+	streamCtx = httptrace.WithClientTrace(streamCtx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			netConn.local = info.Conn.LocalAddr()
+			netConn.remote = info.Conn.RemoteAddr()
+		},
+	})
+	pr, pw := io.Pipe()
+	netConn.pw = pw
+	req = req.WithContext(streamCtx)
+	req.Body = pr
+	req.ContentLength = -1
+
+	resp, err := d.HTTP2Transport.RoundTrip(req)
+	if err != nil {
+		cancel()
+		_ = pw.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+		return nil, nil, errHTTP2Unavailable
+	}
+	if resp.ProtoMajor != 2 {
+		cancel()
+		_ = pw.Close()
+		_ = resp.Body.Close()
+		return nil, nil, errHTTP2Unavailable
+	}
+
+	if d.Jar != nil {
+		if rc := resp.Cookies(); len(rc) > 0 {
+			d.Jar.SetCookies(req.URL, rc)
+		}
+	}
+
+	fail := func(err error) (*Conn, *http.Response, error) {
+		if d.Metrics != nil {
+			d.Metrics.HandshakeFailed(resp.StatusCode)
+		}
+		d.logDialError(req, resp, err)
+		cancel()
+		_ = pw.Close()
+		return nil, resp, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body := resp.Body
+		err := newHandshakeError(resp)
+		_ = body.Close()
+		return fail(err)
+	}
+
+	netConn.body = resp.Body
+	conn := newConn(netConn, false, d.ReadBufferSize, d.WriteBufferSize, d.WriteBufferPool, nil, nil)
+	if d.ReadBufferPool != nil {
+		conn.setReadPool(d.ReadBufferPool)
+	}
+	conn.strictUTF8 = d.StrictUTF8
+	conn.SetReadLimits(d.ReadLimits)
+	if err := d.acceptResponse(conn, resp); err != nil {
+		_ = resp.Body.Close()
+		return fail(err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader([]byte{}))
+	conn.logger = d.Logger
+	conn.setMetrics(d.Metrics)
+	return conn, resp, nil
+}
+
+// streamAddr is the address of the peer of an HTTP/2 stream.
+type streamAddr string
+
+func (a streamAddr) Network() string { return "tcp" }
+func (a streamAddr) String() string  { return string(a) }
+
+// http2ServerConn adapts the request body and response writer of an HTTP/2
+// stream to a net.Conn.
+type http2ServerConn struct {
+	body          io.ReadCloser
+	w             http.ResponseWriter
+	rc            *http.ResponseController
+	local, remote net.Addr
+	closed        atomic.Bool
+}
+
+func (c *http2ServerConn) Read(p []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	return c.body.Read(p)
+}
+
+func (c *http2ServerConn) Write(p []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+// Close closes the request body. The stream is closed when the handler
+// returns.
+func (c *http2ServerConn) Close() error {
+	if c.closed.Swap(true) {
+		return net.ErrClosed
+	}
+	return c.body.Close()
+}
+
+func (c *http2ServerConn) LocalAddr() net.Addr  { return c.local }
+func (c *http2ServerConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *http2ServerConn) SetDeadline(t time.Time) error {
+	if err := c.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (c *http2ServerConn) SetReadDeadline(t time.Time) error  { return c.rc.SetReadDeadline(t) }
+func (c *http2ServerConn) SetWriteDeadline(t time.Time) error { return c.rc.SetWriteDeadline(t) }
+
+// http2ClientConn adapts the request and response bodies of an HTTP/2
+// stream to a net.Conn.
+//
+// The stream has no notion of per-direction deadlines. An expired read or
+// write deadline resets the stream, and the pending and future operations in
+// both directions fail with a timeout error. As with a network connection,
+// the WebSocket connection is unusable after a timeout.
+type http2ClientConn struct {
+	body          io.ReadCloser
+	pw            *io.PipeWriter
+	cancel        context.CancelFunc
+	local, remote net.Addr
+
+	timedOut              atomic.Bool
+	deadlineMu            sync.Mutex
+	readTimer, writeTimer *time.Timer
+	closeOnce             sync.Once
+}
+
+func (c *http2ClientConn) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	if err != nil && c.timedOut.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *http2ClientConn) Write(p []byte) (int, error) {
+	if c.timedOut.Load() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err := c.pw.Write(p)
+	if err != nil && c.timedOut.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *http2ClientConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.pw.Close()
+		_ = c.body.Close()
+		c.cancel()
+	})
+	return nil
+}
+
+func (c *http2ClientConn) LocalAddr() net.Addr  { return c.local }
+func (c *http2ClientConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *http2ClientConn) SetDeadline(t time.Time) error {
+	c.setDeadline(&c.readTimer, t)
+	c.setDeadline(&c.writeTimer, t)
+	return nil
+}
+
+func (c *http2ClientConn) SetReadDeadline(t time.Time) error {
+	c.setDeadline(&c.readTimer, t)
+	return nil
+}
+
+func (c *http2ClientConn) SetWriteDeadline(t time.Time) error {
+	c.setDeadline(&c.writeTimer, t)
+	return nil
+}
+
+// setDeadline replaces the timer that resets the stream at t.
+func (c *http2ClientConn) setDeadline(timer **time.Timer, t time.Time) {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	expire := func() {
+		c.timedOut.Store(true)
+		c.cancel()
+		_ = c.pw.CloseWithError(os.ErrDeadlineExceeded)
+	}
+	if d := time.Until(t); d > 0 {
+		*timer = time.AfterFunc(d, expire)
+	} else {
+		expire()
+	}
+}
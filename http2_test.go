@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// newHTTP2Server returns a TLS test server with HTTP/2 enabled.
+func newHTTP2Server(t *testing.T) (*cstServer, *http.Transport) {
+	var s cstServer
+	s.Server = httptest.NewUnstartedServer(cstHandler{T: t, s: &s})
+	s.Server.EnableHTTP2 = true
+	s.Server.StartTLS()
+	s.Server.URL += cstRequestURI
+	s.URL = makeWsProto(s.Server.URL)
+	return &s, s.Server.Client().Transport.(*http.Transport)
+}
+
+func TestHTTP2Dial(t *testing.T) {
+	// The net/http server reads the setting enabling extended CONNECT from
+	// the environment at init, so run the test in a child process.
+	if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHTTP2Dial$", "-test.v")
+		cmd.Env = append(os.Environ(), "GODEBUG=http2xconnect=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil || !strings.Contains(string(out), "--- PASS: TestHTTP2Dial") {
+			t.Fatalf("child process failed: %v\n%s", err, out)
+		}
+		return
+	}
+
+	s, _ := newHTTP2Server(t)
+	defer s.Close()
+
+	d := cstDialer
+	d.HTTP2Transport = &http2.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs(t, s.Server)}}
+	ws, resp, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("response protocol is %s, want HTTP/2.0", resp.Proto)
+	}
+	if _, ok := ws.NetConn().(*http2ClientConn); !ok {
+		t.Fatalf("NetConn() is %T, want *http2ClientConn", ws.NetConn())
+	}
+	if ws.RemoteAddr() == nil || ws.LocalAddr() == nil {
+		t.Error("stream addresses not set")
+	}
+	sendRecv(t, ws)
+}
+
+func TestHTTP2DialFallback(t *testing.T) {
+	if strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		t.Skip("extended CONNECT enabled")
+	}
+	s, tr := newHTTP2Server(t)
+	defer s.Close()
+
+	d := cstDialer
+	d.HTTP2Transport = tr
+	d.TLSClientConfig = &tls.Config{RootCAs: rootCAs(t, s.Server)}
+	ws, resp, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status is %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	sendRecv(t, ws)
+}
@@ -0,0 +1,813 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hash/maphash"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHubSendBufferSize = 256
+	defaultHubWriteTimeout   = 10 * time.Second
+)
+
+var (
+	// ErrHubClosed is returned when using a hub after Close was called.
+	ErrHubClosed = errors.New("websocket: hub closed")
+
+	// ErrNotHubMember is returned by Hub.Send when the connection is not
+	// registered with the hub.
+	ErrNotHubMember = errors.New("websocket: connection is not registered with the hub")
+)
+
+// Hub maintains a set of connections grouped into named rooms and
+// broadcasts messages to them.
+//
+// Each connection that joins a hub gets a send queue and a goroutine that
+// writes queued messages to the connection, or shares a fixed pool of
+// writing goroutines when Workers is set. Once a connection is added to a
+// hub, the application must not write data messages to the connection
+// directly; use Send to write to a single connection through the queue. The
+// application remains responsible for reading from the connection and should
+// call Remove when the read loop exits.
+//
+// A connection whose send queue is full is considered too slow to keep up.
+// The hub removes the connection, sends a close message with code
+// CloseTryAgainLater and closes the network connection.
+//
+// Data messages broadcast to a room are encoded, and compressed where
+// negotiated, once per frame configuration with a PreparedMessage; the
+// encoded frames are shared by all members.
+//
+// Hubs in different processes can share broadcasts through a Broker, such
+// as the Redis broker in the redisbroker package. Each hub delivers the
+// broadcasts it receives from the broker to its local members.
+//
+// With a PresenceStore, the hub records the members of each room on every
+// node for Presence and reports changes to OnJoin and OnLeave.
+//
+// It is safe to call Hub's methods concurrently. The zero value is ready to
+// use.
+type Hub struct {
+	// SendBufferSize specifies the number of messages queued for each
+	// connection. If zero, a default of 256 is used.
+	SendBufferSize int
+
+	// WriteTimeout specifies the write deadline for each queued message. If
+	// zero, a default of 10 seconds is used.
+	WriteTimeout time.Duration
+
+	// Workers, if positive, specifies the number of goroutines writing the
+	// queued messages of all connections, instead of one goroutine per
+	// connection. A worker writes a batch of the messages queued for a
+	// connection before moving on to the next one, so a connection blocked
+	// in a write holds a worker for at most WriteTimeout. Use enough
+	// workers to cover the connections expected to be slow at once. The
+	// Workers field must not be changed after the hub is first used.
+	Workers int
+
+	// OnSlowClient is called after a connection is removed from the hub
+	// because its send queue is full.
+	OnSlowClient func(c *Conn)
+
+	// Logger, if not nil, receives log records about evicted clients and
+	// about broker and presence errors.
+	Logger Logger
+
+	// SlowConsumer, if not nil, detects the connections whose send queue
+	// stays above a threshold before it is full. Under SlowConsumerClose
+	// the connection is removed and closed as when its send queue is full,
+	// and OnSlowClient is called.
+	SlowConsumer *SlowConsumerPolicy
+
+	// StaleTimeout, if positive with OnStale, enables application-level
+	// liveness: OnStale is called with a connection that has not received
+	// a data message for StaleTimeout, even if its keepalive pongs still
+	// arrive. Clients meant to stay quiet send an application heartbeat
+	// message more often than StaleTimeout, so that a stale connection
+	// tells a frozen client application from a broken network. OnStale is
+	// called once per period of silence, from a goroutine of the hub, and
+	// the connection stays in the hub. The StaleTimeout field must not be
+	// changed after the hub is first used.
+	StaleTimeout time.Duration
+	OnStale      func(c *Conn)
+
+	// BroadcastRateLimit limits the rate of messages and payload bytes
+	// broadcast to each room, so that a busy room cannot starve the others.
+	// Bytes are charged once per broadcast regardless of the number of
+	// members. Under RateLimitDelay, Broadcast and BroadcastExcept wait for
+	// the rate; under the other policies they return ErrRateLimited without
+	// queueing the message. BroadcastAll is not limited.
+	BroadcastRateLimit RateLimit
+
+	// Broker, if not nil, relays broadcasts to the hubs of other processes
+	// using the same broker and delivers their broadcasts to the members
+	// of this hub. Send is not relayed, and the except argument of
+	// BroadcastExcept applies to local connections only. The Broker field
+	// must not be changed after the hub is first used.
+	Broker Broker
+
+	// OnBrokerError is called when the subscription to the broker fails.
+	// The hub subscribes again after a delay.
+	OnBrokerError func(err error)
+
+	// PresenceStore, if not nil, records the members of each room for
+	// Presence. With a Broker, hubs exchange their members through the
+	// broker, so the store of each hub holds the members of all nodes. Use
+	// a MemoryPresenceStore unless the members must be kept elsewhere.
+	PresenceStore PresenceStore
+
+	// PresenceInfo returns the user and metadata recorded for a
+	// connection in its presence records. PresenceInfo is called once
+	// when the connection joins its first room.
+	PresenceInfo func(c *Conn) (user string, meta map[string]string)
+
+	// PresenceTTL, if positive, enables heartbeat-based liveness. The hub
+	// refreshes the presence records of its connections every third of
+	// PresenceTTL and removes the records not refreshed within
+	// PresenceTTL, such as those of a node that stopped. Hubs sharing a
+	// broker should use the same PresenceTTL.
+	PresenceTTL time.Duration
+
+	// OnJoin and OnLeave are called when a member, local or on another
+	// node, joins or leaves a room, or its record expires. Calls are
+	// serialized. The functions must not call Join, Leave, Remove or
+	// Close.
+	OnJoin  func(room string, m Member)
+	OnLeave func(room string, m Member)
+
+	// OnPresenceError is called when the presence store or the broker
+	// fails to record or relay a presence update.
+	OnPresenceError func(err error)
+
+	// Shards specifies the number of shards the connections of the hub are
+	// spread over by a hash of their ID. Each shard has its own lock, so
+	// that joins, leaves and broadcasts touching different shards do not
+	// contend. A broadcast visits every shard, so use more shards for hubs
+	// with many connections joining and leaving, such as GOMAXPROCS or a
+	// multiple of it. If zero, a default of 1 is used. The Shards field
+	// must not be changed after the hub is first used.
+	Shards int
+
+	// ProfilerLabels, if true, labels the writes of the queued messages
+	// with LabelConn and, for the messages of a room broadcast, LabelRoom,
+	// so that profiles attribute the write cost to connections and rooms.
+	// Labeling costs a few allocations per message.
+	ProfilerLabels bool
+
+	id           string // identifies the hub in broker messages and presence
+	brokerCancel func() // stops the broker subscription, guarded by mu
+	staleCancel  func() // stops the stale check, guarded by mu
+	pool         *hubPool
+	presence     presenceState
+
+	limitMu  sync.Mutex
+	limiters map[string]*rateLimiter
+
+	outbound interceptorChain
+	inbound  []Interceptor // registered on each connection, guarded by all shard locks
+
+	shardOnce sync.Once
+	shards    []*hubShard
+
+	mu      sync.RWMutex // guards the start and the stop of the hub goroutines
+	started atomic.Bool
+	closed  atomic.Bool
+}
+
+// hubShard holds the connections hashed to a shard of a hub and their room
+// memberships.
+type hubShard struct {
+	mu      sync.RWMutex
+	clients map[*Conn]*hubClient
+	rooms   map[string]map[*Conn]*hubClient
+}
+
+// hubShardSeed seeds the hash assigning connections to shards.
+var hubShardSeed = maphash.MakeSeed()
+
+// shardList returns the shards of the hub, creating them on first use.
+func (h *Hub) shardList() []*hubShard {
+	h.shardOnce.Do(func() {
+		h.shards = make([]*hubShard, max(h.Shards, 1))
+		for i := range h.shards {
+			h.shards[i] = &hubShard{
+				clients: make(map[*Conn]*hubClient),
+				rooms:   make(map[string]map[*Conn]*hubClient),
+			}
+		}
+	})
+	return h.shards
+}
+
+// shard returns the shard of the connection.
+func (h *Hub) shard(c *Conn) *hubShard {
+	shards := h.shardList()
+	if len(shards) == 1 {
+		return shards[0]
+	}
+	return shards[maphash.String(hubShardSeed, c.ID())%uint64(len(shards))]
+}
+
+// lockShards locks every shard of the hub, in order.
+func (h *Hub) lockShards() {
+	for _, s := range h.shardList() {
+		s.mu.Lock()
+	}
+}
+
+func (h *Hub) unlockShards() {
+	for _, s := range h.shardList() {
+		s.mu.Unlock()
+	}
+}
+
+// hubMessage is a message queued for a connection. Broadcast data messages
+// are written from pm so that the frames are encoded once for all members.
+// The tracker, if any, collects the results of the writes for BroadcastWait.
+type hubMessage struct {
+	messageType int
+	data        []byte
+	pm          *PreparedMessage
+	tracker     *broadcastTracker
+	room        string // the room broadcast to, if any, see ProfilerLabels
+}
+
+// hubClient holds the hub state for a connection.
+type hubClient struct {
+	conn   *Conn
+	send   chan hubMessage
+	done   chan struct{}
+	rooms  map[string]struct{}
+	member Member               // presence record, if the hub tracks presence
+	joined map[string]time.Time // time of joining each room, for presence
+	stale  int64                // LastRead of the connection when reported stale, see checkStale
+	views  map[string]ViewFunc  // view of each room joined with JoinWithView
+
+	pool      *hubPool    // the workers writing the queue, if any
+	scheduled atomic.Bool // on the ready list of the pool or being written
+}
+
+// initID generates the hub ID once. The hub lock must be held.
+func (h *Hub) initID() {
+	if h.id != "" {
+		return
+	}
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	h.id = hex.EncodeToString(id[:])
+}
+
+// start generates the hub ID and starts the broker subscription, the
+// presence heartbeat and the write pool once. It returns ErrHubClosed after
+// Close.
+func (h *Hub) start() error {
+	if h.started.Load() {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+	if h.started.Load() {
+		return nil
+	}
+	h.initID()
+	h.startBroker()
+	h.startPresence()
+	h.startStaleCheck()
+	if h.Workers > 0 {
+		h.pool = newHubPool(h, h.Workers)
+	}
+	h.started.Store(true)
+	return nil
+}
+
+// client returns the hub client for c, registering c with the shard if
+// needed. The member is the presence record used for a new client. The shard
+// lock must be held and the hub must be started.
+func (h *Hub) client(s *hubShard, c *Conn, member Member) *hubClient {
+	if hc, ok := s.clients[c]; ok {
+		return hc
+	}
+	size := h.SendBufferSize
+	if size <= 0 {
+		size = defaultHubSendBufferSize
+	}
+	hc := &hubClient{
+		conn:   c,
+		send:   make(chan hubMessage, size),
+		done:   make(chan struct{}),
+		rooms:  make(map[string]struct{}),
+		member: member,
+		joined: make(map[string]time.Time),
+	}
+	s.clients[c] = hc
+	if len(h.inbound) > 0 {
+		c.UseInbound(h.inbound...)
+	}
+	if h.pool != nil {
+		hc.pool = h.pool
+	} else {
+		go h.writePump(hc)
+	}
+	if h.SlowConsumer != nil {
+		go h.slowMonitor(hc, size).run()
+	}
+	return hc
+}
+
+// slowMonitor returns the monitor of the send queue of a client.
+func (h *Hub) slowMonitor(hc *hubClient, size int) *slowMonitor {
+	m := newSlowMonitor(h.SlowConsumer, hc.conn, size, hc.done)
+	m.queued = func() int { return len(hc.send) }
+	m.drop = func() {
+		for {
+			select {
+			case <-hc.send:
+			default:
+				return
+			}
+		}
+	}
+	m.send = func(p []byte) { hc.enqueue(hubMessage{messageType: TextMessage, data: p}) }
+	m.close = func() { h.evict([]*hubClient{hc}) }
+	return m
+}
+
+// writePump writes queued messages to the connection until the client is
+// removed from the hub.
+func (h *Hub) writePump(hc *hubClient) {
+	for {
+		select {
+		case m := <-hc.send:
+			if err := h.write(hc, m); err != nil {
+				h.Remove(hc.conn)
+				hc.discard()
+				return
+			}
+		case <-hc.done:
+			hc.discard()
+			return
+		}
+	}
+}
+
+// Join adds the connection to the named room. A connection can be a member
+// of any number of rooms. Joining a room again removes the view set with
+// JoinWithView.
+func (h *Hub) Join(room string, c *Conn) error {
+	return h.join(room, c, nil)
+}
+
+// join adds the connection to the room with the view, if not nil.
+func (h *Hub) join(room string, c *Conn, view ViewFunc) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if err := h.start(); err != nil {
+		return err
+	}
+	s := h.shard(c)
+	var member Member
+	if h.PresenceStore != nil {
+		s.mu.RLock()
+		hc, ok := s.clients[c]
+		s.mu.RUnlock()
+		if ok {
+			member = hc.member
+		} else {
+			member = h.presenceMember(c)
+		}
+	}
+	s.mu.Lock()
+	// Close marks the hub closed before removing the connections of each
+	// shard, so the connection is either removed by Close or not added.
+	if h.closed.Load() {
+		s.mu.Unlock()
+		return ErrHubClosed
+	}
+	hc := h.client(s, c, member)
+	members := s.rooms[room]
+	if members == nil {
+		members = make(map[*Conn]*hubClient)
+		s.rooms[room] = members
+	}
+	_, ok := members[c]
+	members[c] = hc
+	hc.rooms[room] = struct{}{}
+	hc.setView(room, view)
+	if !ok {
+		hc.joined[room] = time.Now()
+		h.queuePresence(presenceJoin, room, hc)
+		c.audit(AuditJoin, room, 0, "")
+	}
+	s.mu.Unlock()
+	h.applyPresence()
+	return nil
+}
+
+// Leave removes the connection from the named room. The connection stays
+// registered with the hub until Remove is called.
+func (h *Hub) Leave(room string, c *Conn) {
+	if c == nil {
+		return
+	}
+	s := h.shard(c)
+	var empty bool
+	s.mu.Lock()
+	if hc, ok := s.clients[c]; ok {
+		empty = h.leave(s, room, hc)
+	}
+	s.mu.Unlock()
+	if empty {
+		h.forgetEmptyRoom(room)
+	}
+	h.applyPresence()
+}
+
+// leave removes hc from room. It reports whether the room has no members
+// left in the shard. The shard lock must be held.
+func (h *Hub) leave(s *hubShard, room string, hc *hubClient) bool {
+	if _, ok := hc.rooms[room]; !ok {
+		return false
+	}
+	h.queuePresence(presenceLeave, room, hc)
+	hc.conn.audit(AuditLeave, room, 0, "")
+	delete(hc.rooms, room)
+	delete(hc.joined, room)
+	delete(hc.views, room)
+	if members := s.rooms[room]; members != nil {
+		delete(members, hc.conn)
+		if len(members) == 0 {
+			delete(s.rooms, room)
+			return true
+		}
+	}
+	return false
+}
+
+// forgetEmptyRoom discards the rate limiter state of room, left without
+// members in a shard, if the room has no members left in any shard.
+func (h *Hub) forgetEmptyRoom(room string) {
+	if l := h.BroadcastRateLimit; l.Messages <= 0 && l.Bytes <= 0 {
+		return
+	}
+	if len(h.shardList()) > 1 && h.Len(room) > 0 {
+		return
+	}
+	h.forgetRoom(room)
+}
+
+// Remove removes the connection from all rooms and stops writing queued
+// messages to it. Remove does not close the connection.
+func (h *Hub) Remove(c *Conn) {
+	if c == nil {
+		return
+	}
+	h.remove(c)
+	h.applyPresence()
+}
+
+// remove unregisters c. It reports whether c was registered.
+func (h *Hub) remove(c *Conn) bool {
+	s := h.shard(c)
+	s.mu.Lock()
+	empty, ok := h.removeLocked(s, c)
+	s.mu.Unlock()
+	for _, room := range empty {
+		h.forgetEmptyRoom(room)
+	}
+	return ok
+}
+
+// removeLocked unregisters c from the shard. It returns the rooms left
+// without members in the shard and whether c was registered. The shard lock
+// must be held.
+func (h *Hub) removeLocked(s *hubShard, c *Conn) ([]string, bool) {
+	hc, ok := s.clients[c]
+	if !ok {
+		return nil, false
+	}
+	var empty []string
+	for room := range hc.rooms {
+		if h.leave(s, room, hc) {
+			empty = append(empty, room)
+		}
+	}
+	delete(s.clients, c)
+	close(hc.done)
+	return empty, true
+}
+
+// Send queues a message for a single connection registered with the hub.
+func (h *Hub) Send(c *Conn, messageType int, data []byte) error {
+	data, ok, err := h.intercept(messageType, data)
+	if !ok {
+		return err
+	}
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+	if c == nil {
+		return ErrNotHubMember
+	}
+	s := h.shard(c)
+	s.mu.RLock()
+	hc, ok := s.clients[c]
+	var slow []*hubClient
+	if ok && !hc.enqueue(hubMessage{messageType: messageType, data: data}) {
+		slow = append(slow, hc)
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return ErrNotHubMember
+	}
+	h.evict(slow)
+	return nil
+}
+
+// Broadcast queues a message for every connection in the named room.
+func (h *Hub) Broadcast(room string, messageType int, data []byte) error {
+	return h.BroadcastExcept(room, nil, messageType, data)
+}
+
+// BroadcastExcept queues a message for every connection in the named room
+// except the given connection. The except argument is typically the sender
+// of the message.
+func (h *Hub) BroadcastExcept(room string, except *Conn, messageType int, data []byte) error {
+	data, ok, err := h.intercept(messageType, data)
+	if !ok {
+		return err
+	}
+	m, err := newBroadcastMessage(messageType, data)
+	if err != nil {
+		return err
+	}
+	if err := h.broadcast(room, except, m); err != nil {
+		return err
+	}
+	return h.publish(room, false, messageType, data)
+}
+
+// BroadcastPrepared queues a prepared message for every connection in the
+// named room. Use BroadcastPrepared to send the same message to several rooms
+// while encoding and compressing it once.
+func (h *Hub) BroadcastPrepared(room string, pm *PreparedMessage) error {
+	if h.outbound.enabled() {
+		// The interceptors may rewrite the payload.
+		return h.BroadcastExcept(room, nil, pm.messageType, pm.data)
+	}
+	if err := h.broadcast(room, nil, hubMessage{messageType: pm.messageType, data: pm.data, pm: pm}); err != nil {
+		return err
+	}
+	return h.publish(room, false, pm.messageType, pm.data)
+}
+
+// newBroadcastMessage returns a hub message for a payload sent to many
+// connections. Data messages are prepared once for all connections.
+func newBroadcastMessage(messageType int, data []byte) (hubMessage, error) {
+	m := hubMessage{messageType: messageType, data: data}
+	if isData(messageType) {
+		pm, err := NewPreparedMessage(messageType, data)
+		if err != nil {
+			return m, err
+		}
+		m.pm = pm
+	}
+	return m, nil
+}
+
+// broadcast queues m for the members of room except the given connection
+// subject to the broadcast rate limit.
+func (h *Hub) broadcast(room string, except *Conn, m hubMessage) error {
+	if err := h.throttle(room, len(m.data)); err != nil {
+		return err
+	}
+	return h.fanout(room, except, m)
+}
+
+// fanout queues m for the members of room except the given connection.
+func (h *Hub) fanout(room string, except *Conn, m hubMessage) error {
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+	var slow []*hubClient
+	for _, s := range h.shardList() {
+		s.mu.RLock()
+		for c, hc := range s.rooms[room] {
+			if c == except {
+				continue
+			}
+			msg := m
+			if hc.views != nil {
+				var ok bool
+				if msg, ok = hc.view(room, m); !ok {
+					continue
+				}
+			}
+			msg.room = room
+			msg.tracker.add(c)
+			if !hc.enqueue(msg) {
+				msg.tracker.finish(c, ErrWriteQueueFull)
+				slow = append(slow, hc)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	h.evict(slow)
+	return nil
+}
+
+// BroadcastAll queues a message for every connection registered with the
+// hub, regardless of room membership.
+func (h *Hub) BroadcastAll(messageType int, data []byte) error {
+	data, ok, err := h.intercept(messageType, data)
+	if !ok {
+		return err
+	}
+	m, err := newBroadcastMessage(messageType, data)
+	if err != nil {
+		return err
+	}
+	if err := h.fanoutAll(m); err != nil {
+		return err
+	}
+	return h.publish("", true, messageType, data)
+}
+
+// fanoutAll queues m for every connection registered with the hub.
+func (h *Hub) fanoutAll(m hubMessage) error {
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+	var slow []*hubClient
+	for _, s := range h.shardList() {
+		s.mu.RLock()
+		for _, hc := range s.clients {
+			if !hc.enqueue(m) {
+				slow = append(slow, hc)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	h.evict(slow)
+	return nil
+}
+
+// enqueue adds m to the send queue without blocking. It returns false if the
+// queue is full.
+func (hc *hubClient) enqueue(m hubMessage) bool {
+	select {
+	case hc.send <- m:
+		if hc.pool != nil {
+			hc.pool.schedule(hc)
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// evict removes and closes connections that cannot keep up with the hub.
+func (h *Hub) evict(slow []*hubClient) {
+	for _, hc := range slow {
+		removed := h.remove(hc.conn)
+		h.applyPresence()
+		if !removed {
+			continue
+		}
+		h.log(slog.LevelWarn, "websocket: slow client evicted", hc.conn.logArgs()...)
+		hc.conn.audit(AuditViolation, "", CloseTryAgainLater, "slow consumer")
+		_ = hc.conn.WriteControl(CloseMessage, FormatCloseMessage(CloseTryAgainLater, "slow consumer"), time.Now().Add(writeWait))
+		_ = hc.conn.Close()
+		if h.OnSlowClient != nil {
+			h.OnSlowClient(hc.conn)
+		}
+	}
+}
+
+// Rooms returns the names of the rooms with at least one member in sorted
+// order.
+func (h *Hub) Rooms() []string {
+	shards := h.shardList()
+	var rooms []string
+	for _, s := range shards {
+		s.mu.RLock()
+		for room := range s.rooms {
+			rooms = append(rooms, room)
+		}
+		s.mu.RUnlock()
+	}
+	sort.Strings(rooms)
+	if len(shards) > 1 {
+		rooms = slices.Compact(rooms)
+	}
+	if rooms == nil {
+		rooms = []string{}
+	}
+	return rooms
+}
+
+// RoomsOf returns the names of the rooms the connection is a member of in
+// sorted order.
+func (h *Hub) RoomsOf(c *Conn) []string {
+	if c == nil {
+		return nil
+	}
+	s := h.shard(c)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hc, ok := s.clients[c]
+	if !ok {
+		return nil
+	}
+	rooms := make([]string, 0, len(hc.rooms))
+	for room := range hc.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Len returns the number of connections in the named room.
+func (h *Hub) Len(room string) int {
+	n := 0
+	for _, s := range h.shardList() {
+		s.mu.RLock()
+		n += len(s.rooms[room])
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Count returns the number of connections registered with the hub.
+func (h *Hub) Count() int {
+	n := 0
+	for _, s := range h.shardList() {
+		s.mu.RLock()
+		n += len(s.clients)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Range calls f for each connection in the named room. If f returns false,
+// Range stops the iteration. Range iterates over a snapshot of the room, so f
+// may call other Hub methods.
+func (h *Hub) Range(room string, f func(c *Conn) bool) {
+	var members []*Conn
+	for _, s := range h.shardList() {
+		s.mu.RLock()
+		for c := range s.rooms[room] {
+			members = append(members, c)
+		}
+		s.mu.RUnlock()
+	}
+	for _, c := range members {
+		if !f(c) {
+			return
+		}
+	}
+}
+
+// Close removes all connections from the hub and stops their write
+// goroutines. Close does not close the connections. Subsequent calls to Join,
+// Send and the broadcast methods return ErrHubClosed.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	h.closed.Store(true)
+	if h.brokerCancel != nil {
+		h.brokerCancel()
+	}
+	if h.presence.cancel != nil {
+		h.presence.cancel()
+	}
+	if h.staleCancel != nil {
+		h.staleCancel()
+	}
+	if h.pool != nil {
+		h.pool.stop()
+	}
+	h.mu.Unlock()
+	for _, s := range h.shardList() {
+		s.mu.Lock()
+		for c := range s.clients {
+			h.removeLocked(s, c)
+		}
+		s.mu.Unlock()
+	}
+	h.limitMu.Lock()
+	h.limiters = nil
+	h.limitMu.Unlock()
+	h.applyPresence()
+	return nil
+}
@@ -0,0 +1,296 @@
+package websocket
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// hubWorkerBatch is the number of queued messages a worker writes to a
+// connection before moving on to the next ready connection.
+const hubWorkerBatch = 64
+
+// hubPool is a fixed set of goroutines writing the send queues of the hub
+// clients. A client with queued messages is put on the ready list once; the
+// worker taking it writes up to hubWorkerBatch messages and puts it back at
+// the end of the list if more remain.
+type hubPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ready  []*hubClient
+	closed bool
+}
+
+// newHubPool starts n workers writing for the clients of h.
+func newHubPool(h *Hub, n int) *hubPool {
+	p := &hubPool{}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < n; i++ {
+		go p.run(h)
+	}
+	return p
+}
+
+// schedule puts hc on the ready list unless it is already there or being
+// written by a worker.
+func (p *hubPool) schedule(hc *hubClient) {
+	if !hc.scheduled.CompareAndSwap(false, true) {
+		return
+	}
+	p.mu.Lock()
+	p.ready = append(p.ready, hc)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// stop stops the workers once the ready list is empty.
+func (p *hubPool) stop() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// next returns the next ready client, or nil when the pool is stopped.
+func (p *hubPool) next() *hubClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.ready) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if len(p.ready) == 0 {
+		return nil
+	}
+	hc := p.ready[0]
+	p.ready[0] = nil
+	p.ready = p.ready[1:]
+	return hc
+}
+
+func (p *hubPool) run(h *Hub) {
+	for hc := p.next(); hc != nil; hc = p.next() {
+		if !h.writeBatch(hc) {
+			continue
+		}
+		hc.scheduled.Store(false)
+		// Messages queued after the last receive did not schedule hc.
+		if len(hc.send) > 0 {
+			p.schedule(hc)
+		}
+	}
+}
+
+// writeBatch writes up to hubWorkerBatch queued messages to hc. It returns
+// false if the client was removed from the hub.
+func (h *Hub) writeBatch(hc *hubClient) bool {
+	for i := 0; i < hubWorkerBatch; i++ {
+		select {
+		case <-hc.done:
+			hc.discard()
+			return false
+		default:
+		}
+		select {
+		case m := <-hc.send:
+			if err := h.write(hc, m); err != nil {
+				h.Remove(hc.conn)
+				hc.discard()
+				return false
+			}
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// write writes a queued message to the connection with the write timeout
+// of the hub and reports the result to the broadcast waiting for it.
+func (h *Hub) write(hc *hubClient, m hubMessage) error {
+	timeout := h.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultHubWriteTimeout
+	}
+	_ = hc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	var err error
+	if h.ProfilerLabels {
+		labels := pprof.Labels(LabelConn, hc.conn.ID())
+		if m.room != "" {
+			labels = pprof.Labels(LabelConn, hc.conn.ID(), LabelRoom, m.room)
+		}
+		// The write goroutines belong to the hub, so their labels are
+		// reset after the write.
+		pprof.Do(context.Background(), labels, func(context.Context) {
+			err = hc.writeMessage(m)
+		})
+	} else {
+		err = hc.writeMessage(m)
+	}
+	m.tracker.finish(hc.conn, err)
+	return err
+}
+
+func (hc *hubClient) writeMessage(m hubMessage) error {
+	if m.pm != nil {
+		return hc.conn.WritePreparedMessage(m.pm)
+	}
+	return hc.conn.WriteMessage(m.messageType, m.data)
+}
+
+// discard drops the messages left in the send queue of a removed client.
+// No message is queued once the client is removed, so the broadcasts
+// waiting for the dropped messages are reported a failure now.
+func (hc *hubClient) discard() {
+	for {
+		select {
+		case m := <-hc.send:
+			m.tracker.finish(hc.conn, ErrNotHubMember)
+		default:
+			return
+		}
+	}
+}
+
+// BroadcastError is returned by Hub.BroadcastWait when the message was not
+// written to some of the connections.
+type BroadcastError struct {
+	// Failed maps each connection the message was not written to to the
+	// reason: the write error, ErrWriteQueueFull for a connection evicted
+	// because its send queue was full, ErrNotHubMember for a connection
+	// removed from the hub before the write, or the context error for a
+	// connection still waiting when the context was done.
+	Failed map[*Conn]error
+}
+
+func (e *BroadcastError) Error() string {
+	return "websocket: broadcast failed for " + strconv.Itoa(len(e.Failed)) + " connections"
+}
+
+// Unwrap returns the distinct errors of the failed connections, so that
+// errors.Is reports whether any connection failed with a given error.
+func (e *BroadcastError) Unwrap() []error {
+	var errs []error
+	seen := make(map[error]bool)
+	for _, err := range e.Failed {
+		if !seen[err] {
+			seen[err] = true
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// BroadcastWait queues a message for every connection in the named room
+// and waits until it is written to each of them. Each write is bounded by
+// the WriteTimeout of the hub. If the message is not written to some of the
+// connections, BroadcastWait returns a *BroadcastError listing them. When
+// ctx is done, BroadcastWait stops waiting and reports the remaining
+// connections with the context error; the message stays queued for them.
+//
+// A Broker relays the message to the other hubs as with Broadcast, but the
+// delivery to their members is not awaited.
+func (h *Hub) BroadcastWait(ctx context.Context, room string, messageType int, data []byte) error {
+	data, ok, err := h.intercept(messageType, data)
+	if !ok {
+		return err
+	}
+	m, err := newBroadcastMessage(messageType, data)
+	if err != nil {
+		return err
+	}
+	m.tracker = newBroadcastTracker()
+	if err := h.broadcast(room, nil, m); err != nil {
+		return err
+	}
+	m.tracker.seal()
+	failed := m.tracker.wait(ctx)
+	if err := h.publish(room, false, messageType, data); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &BroadcastError{Failed: failed}
+	}
+	return nil
+}
+
+// broadcastTracker collects the results of writing a broadcast to its
+// targets. The methods of a nil tracker do nothing.
+type broadcastTracker struct {
+	mu      sync.Mutex
+	pending map[*Conn]struct{}
+	failed  map[*Conn]error
+	sealed  bool
+	done    chan struct{}
+}
+
+func newBroadcastTracker() *broadcastTracker {
+	return &broadcastTracker{
+		pending: make(map[*Conn]struct{}),
+		failed:  make(map[*Conn]error),
+		done:    make(chan struct{}),
+	}
+}
+
+// add registers a target before the message is queued for it.
+func (t *broadcastTracker) add(c *Conn) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.pending[c] = struct{}{}
+	t.mu.Unlock()
+}
+
+// finish records the result of the write to c.
+func (t *broadcastTracker) finish(c *Conn, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pending[c]; !ok {
+		return
+	}
+	delete(t.pending, c)
+	if err != nil {
+		t.failed[c] = err
+	}
+	t.check()
+}
+
+// seal marks the end of the targets. The tracker is done once it is
+// sealed and no write is pending.
+func (t *broadcastTracker) seal() {
+	t.mu.Lock()
+	t.sealed = true
+	t.check()
+	t.mu.Unlock()
+}
+
+// check closes done if the tracker is done. The tracker lock must be held.
+func (t *broadcastTracker) check() {
+	if t.sealed && len(t.pending) == 0 {
+		close(t.done)
+	}
+}
+
+// wait waits until the tracker is done or ctx is done and returns the
+// failed targets.
+func (t *broadcastTracker) wait(ctx context.Context) map[*Conn]error {
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	failed := make(map[*Conn]error, len(t.failed)+len(t.pending))
+	for c, err := range t.failed {
+		failed[c] = err
+	}
+	for c := range t.pending {
+		failed[c] = ctx.Err()
+	}
+	return failed
+}
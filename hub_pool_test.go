@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHubWorkers(t *testing.T) {
+	h := Hub{Workers: 2}
+	defer h.Close()
+
+	const n = 50
+	before := runtime.NumGoroutine()
+	clients := make([]*Conn, n)
+	for i := range clients {
+		s, c := newPipeConns()
+		clients[i] = c
+		if err := h.Join("room", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g := runtime.NumGoroutine() - before; g > h.Workers {
+		t.Errorf("joining %d connections started %d goroutines, want at most %d", n, g, h.Workers)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Conn) {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				if got, want := readString(t, c), "m"+strconv.Itoa(i); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		}(c)
+	}
+	for i := 0; i < 3; i++ {
+		if err := h.Broadcast("room", TextMessage, []byte("m"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestHubBroadcastWait(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		t.Run("workers="+strconv.Itoa(workers), func(t *testing.T) {
+			h := Hub{Workers: workers, WriteTimeout: 50 * time.Millisecond}
+			defer h.Close()
+
+			s1, c1 := newPipeConns()
+			s2, _ := newPipeConns() // never read
+			_ = h.Join("room", s1)
+			_ = h.Join("room", s2)
+
+			go func() { readString(t, c1) }()
+			err := h.BroadcastWait(context.Background(), "room", TextMessage, []byte("hello"))
+			var be *BroadcastError
+			if !errors.As(err, &be) {
+				t.Fatalf("BroadcastWait returned %v, want a *BroadcastError", err)
+			}
+			if len(be.Failed) != 1 || be.Failed[s2] == nil {
+				t.Fatalf("Failed = %v, want the unread connection only", be.Failed)
+			}
+			if h.Len("room") != 1 {
+				t.Errorf("Len = %d after the failed write, want 1", h.Len("room"))
+			}
+
+			go func() { readString(t, c1) }()
+			if err := h.BroadcastWait(context.Background(), "room", TextMessage, []byte("again")); err != nil {
+				t.Fatalf("BroadcastWait returned %v", err)
+			}
+			if err := h.BroadcastWait(context.Background(), "empty", TextMessage, []byte("x")); err != nil {
+				t.Fatalf("BroadcastWait to an empty room returned %v", err)
+			}
+		})
+	}
+}
+
+func TestHubBroadcastWaitContext(t *testing.T) {
+	var h Hub
+	defer h.Close()
+	s, _ := newPipeConns()
+	_ = h.Join("room", s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := h.BroadcastWait(ctx, "room", TextMessage, []byte("hello"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("BroadcastWait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestHubBroadcastWaitQueueFull(t *testing.T) {
+	h := Hub{SendBufferSize: 1}
+	defer h.Close()
+	s, _ := newPipeConns()
+	_ = h.Join("room", s)
+
+	// The first message blocks the writer, the second fills the queue.
+	_ = h.Broadcast("room", TextMessage, []byte("a"))
+	sh := h.shard(s)
+	sh.mu.RLock()
+	hc := sh.clients[s]
+	sh.mu.RUnlock()
+	for len(hc.send) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_ = h.Broadcast("room", TextMessage, []byte("b"))
+	err := h.BroadcastWait(context.Background(), "room", TextMessage, []byte("c"))
+	var be *BroadcastError
+	if !errors.As(err, &be) || be.Failed[s] != ErrWriteQueueFull {
+		t.Fatalf("BroadcastWait returned %v, want %v for the connection", err, ErrWriteQueueFull)
+	}
+}
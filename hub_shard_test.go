@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHubShards(t *testing.T) {
+	h := Hub{Shards: 8}
+	var servers, clients []*Conn
+	for range 16 {
+		s, c := newPipeConns()
+		servers = append(servers, s)
+		clients = append(clients, c)
+		if err := h.Join("room", s); err != nil {
+			t.Fatalf("Join returned %v", err)
+		}
+	}
+	_ = h.Join("other", servers[0])
+
+	if n := h.Len("room"); n != 16 {
+		t.Errorf("Len returned %d, want 16", n)
+	}
+	if n := h.Count(); n != 16 {
+		t.Errorf("Count returned %d, want 16", n)
+	}
+	if rooms := h.Rooms(); strings.Join(rooms, ",") != "other,room" {
+		t.Errorf("Rooms returned %v, want [other room]", rooms)
+	}
+	if rooms := h.RoomsOf(servers[0]); strings.Join(rooms, ",") != "other,room" {
+		t.Errorf("RoomsOf returned %v, want [other room]", rooms)
+	}
+
+	if err := h.Broadcast("room", TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("Broadcast returned %v", err)
+	}
+	for _, c := range clients {
+		if got := readString(t, c); got != "hello" {
+			t.Fatalf("client received %q, want %q", got, "hello")
+		}
+	}
+
+	for _, s := range servers[:8] {
+		h.Leave("room", s)
+	}
+	if n := h.Len("room"); n != 8 {
+		t.Errorf("Len after Leave returned %d, want 8", n)
+	}
+	n := 0
+	h.Range("room", func(c *Conn) bool {
+		n++
+		return true
+	})
+	if n != 8 {
+		t.Errorf("Range visited %d connections, want 8", n)
+	}
+
+	_ = h.Close()
+	if n := h.Count(); n != 0 {
+		t.Errorf("Count after Close returned %d, want 0", n)
+	}
+	if err := h.Join("room", servers[0]); err != ErrHubClosed {
+		t.Errorf("Join after Close returned %v, want %v", err, ErrHubClosed)
+	}
+	if err := h.BroadcastAll(TextMessage, []byte("x")); err != ErrHubClosed {
+		t.Errorf("BroadcastAll after Close returned %v, want %v", err, ErrHubClosed)
+	}
+}
+
+func TestHubShardsSpread(t *testing.T) {
+	h := Hub{Shards: 4, Workers: 1}
+	defer h.Close()
+	for range 400 {
+		_ = h.Join("room", newTestConn(nil, io.Discard, true))
+	}
+	for i, s := range h.shardList() {
+		if len(s.clients) == 0 {
+			t.Errorf("shard %d has no connections", i)
+		}
+	}
+	if n := h.Count(); n != 400 {
+		t.Errorf("Count returned %d, want 400", n)
+	}
+}
+
+const benchHubConns = 100_000
+
+// newBenchHub returns a hub with benchHubConns connections spread over 100
+// rooms.
+func newBenchHub(b *testing.B, shards int) (*Hub, []*Conn) {
+	h := &Hub{Shards: shards, Workers: runtime.GOMAXPROCS(0), SendBufferSize: 8}
+	conns := make([]*Conn, benchHubConns)
+	for i := range conns {
+		conns[i] = newConn(fakeNetConn{Writer: io.Discard}, true, 128, 128, nil, nil, nil)
+		if err := h.Join(fmt.Sprintf("room%d", i%100), conns[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return h, conns
+}
+
+func benchHubShards() []int {
+	return []int{1, runtime.GOMAXPROCS(0) * 4}
+}
+
+func BenchmarkHubJoinLeave(b *testing.B) {
+	for _, shards := range benchHubShards() {
+		h, conns := newBenchHub(b, shards)
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			var next atomic.Uint64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c := conns[next.Add(1)%benchHubConns]
+					_ = h.Join("extra", c)
+					h.Leave("extra", c)
+				}
+			})
+		})
+		_ = h.Close()
+	}
+}
+
+func BenchmarkHubJoinBroadcast(b *testing.B) {
+	for _, shards := range benchHubShards() {
+		h, conns := newBenchHub(b, shards)
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			var next atomic.Uint64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := next.Add(1)
+					c := conns[i%benchHubConns]
+					if i%16 == 0 {
+						// A small room, as broadcasting to a room of
+						// the bench hub fills the send queues.
+						_ = h.Broadcast("extra", TextMessage, []byte("hello"))
+						continue
+					}
+					_ = h.Join("extra", c)
+					h.Leave("extra", c)
+				}
+			})
+		})
+		_ = h.Close()
+	}
+}
+
+func BenchmarkHubSend(b *testing.B) {
+	for _, shards := range benchHubShards() {
+		h, conns := newBenchHub(b, shards)
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			var next atomic.Uint64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = h.Send(conns[next.Add(1)%benchHubConns], TextMessage, []byte("hello"))
+				}
+			})
+		})
+		_ = h.Close()
+	}
+}
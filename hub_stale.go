@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// startStaleCheck starts checking the liveness of the connections if
+// OnStale is set. The hub lock must be held.
+func (h *Hub) startStaleCheck() {
+	if h.StaleTimeout <= 0 || h.OnStale == nil || h.staleCancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.staleCancel = cancel
+	go h.staleLoop(ctx)
+}
+
+// minStaleInterval is the minimum interval of the stale checks.
+const minStaleInterval = time.Millisecond
+
+// staleLoop checks the connections every quarter of StaleTimeout, so that a
+// stale connection is reported at most a quarter late, and at most every
+// millisecond.
+func (h *Hub) staleLoop(ctx context.Context) {
+	t := time.NewTicker(max(h.StaleTimeout/4, minStaleInterval))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			h.checkStale(ctx, now)
+		}
+	}
+}
+
+// checkStale calls OnStale for the connections that became stale since the
+// last check. A connection is reported again only after it received a data
+// message. checkStale is called from the stale check goroutine only, which
+// owns the stale field of the clients.
+func (h *Hub) checkStale(ctx context.Context, now time.Time) {
+	var stale []*Conn
+	for _, s := range h.shardList() {
+		s.mu.RLock()
+		for c, hc := range s.clients {
+			last := c.lastRead.Load()
+			if last != hc.stale && now.Sub(time.Unix(0, last)) > h.StaleTimeout {
+				hc.stale = last
+				stale = append(stale, c)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	for _, c := range stale {
+		if ctx.Err() != nil {
+			return
+		}
+		h.OnStale(c)
+	}
+}
@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHubOnStale(t *testing.T) {
+	stale := make(chan *Conn, 10)
+	h := &Hub{StaleTimeout: 40 * time.Millisecond, OnStale: func(c *Conn) { stale <- c }}
+	defer h.Close()
+
+	frozen, frozenClient := newPipeConns()
+	active, activeClient := newPipeConns()
+	defer frozenClient.Close()
+	defer activeClient.Close()
+	for _, c := range []*Conn{frozen, active, frozenClient} {
+		go func() {
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	// The frozen client answers the keepalive pings but sends no message.
+	if err := frozen.EnableKeepalive(5*time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Conn{frozen, active} {
+		if err := h.Join("game", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				if err := activeClient.WriteMessage(TextMessage, []byte("heartbeat")); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	expectStale := func() {
+		t.Helper()
+		select {
+		case c := <-stale:
+			if c != frozen {
+				t.Fatal("OnStale called for the active connection")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnStale not called for the frozen connection")
+		}
+	}
+	expectStale()
+	select {
+	case <-stale:
+		t.Fatal("OnStale called twice for the same period of silence")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if frozen.Latency() == 0 {
+		t.Error("no pong received from the frozen client")
+	}
+
+	// A message ends the period of silence.
+	if err := frozenClient.WriteMessage(TextMessage, []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	expectStale()
+}
+
+func TestHubOnStaleTinyTimeout(t *testing.T) {
+	stale := make(chan *Conn, 1)
+	h := &Hub{StaleTimeout: 3, OnStale: func(c *Conn) { stale <- c }}
+	defer h.Close()
+	server, client := newPipeConns()
+	defer client.Close()
+	if err := h.Join("game", server); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stale:
+	case <-time.After(time.Second):
+		t.Fatal("OnStale not called")
+	}
+}
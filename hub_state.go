@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Types of the messages sent by a StateChannel.
+const (
+	StateSnapshot = "snapshot"
+	StatePatch    = "patch"
+)
+
+// StateMessage is a JSON text message sent by a StateChannel to the members
+// of its room.
+type StateMessage struct {
+	// Type is StateSnapshot or StatePatch.
+	Type string `json:"type"`
+
+	// Version is the version of the state after the message is applied.
+	// Versions increase by one with each patch, so that a client can detect
+	// a missing patch and ask the application for a new snapshot.
+	Version uint64 `json:"version"`
+
+	// State is the document of a snapshot.
+	State json.RawMessage `json:"state,omitempty"`
+
+	// Format and Patch are the format and the delta of a patch.
+	Format string          `json:"format,omitempty"`
+	Patch  json.RawMessage `json:"patch,omitempty"`
+}
+
+// Apply applies the message to the state received by a client and returns
+// the new state.
+func (m *StateMessage) Apply(state json.RawMessage) (json.RawMessage, error) {
+	switch m.Type {
+	case StateSnapshot:
+		return m.State, nil
+	case StatePatch:
+		switch m.Format {
+		case JSONPatch.String():
+			return ApplyJSONPatch(state, m.Patch)
+		case MergePatch.String():
+			return ApplyMergePatch(state, m.Patch)
+		}
+		return nil, fmt.Errorf("websocket: unknown patch format %q", m.Format)
+	}
+	return nil, fmt.Errorf("websocket: unknown state message type %q", m.Type)
+}
+
+// StateChannel maintains a JSON document for a room of a Hub. When the
+// document is updated, the channel broadcasts the delta between the old and
+// the new document to the members of the room; connections joining the room
+// through the channel receive a snapshot of the document first. Clients
+// apply the messages, StateMessage values, in the order received.
+//
+// The document is held in memory by the channel, and only the connections
+// joined with the channel's Join method receive a snapshot. Hubs sharing the
+// room through a Broker relay the deltas to the members on other nodes, which
+// do not have the document; use a StateChannel with rooms local to a hub.
+//
+// It is safe to call StateChannel's methods concurrently.
+type StateChannel struct {
+	hub    *Hub
+	room   string
+	format PatchFormat
+
+	mu      sync.Mutex // serializes the updates and the snapshots
+	doc     any
+	raw     json.RawMessage
+	version uint64
+}
+
+// NewStateChannel returns a state channel for the named room of the hub. The
+// document is initially null.
+func NewStateChannel(h *Hub, room string, format PatchFormat) *StateChannel {
+	return &StateChannel{hub: h, room: room, format: format, raw: json.RawMessage("null")}
+}
+
+// Join adds the connection to the room and sends it a snapshot of the
+// document. The connection receives the deltas of the later updates.
+func (s *StateChannel) Join(c *Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.hub.Join(s.room, c); err != nil {
+		return err
+	}
+	return s.sendSnapshot(c)
+}
+
+// Leave removes the connection from the room.
+func (s *StateChannel) Leave(c *Conn) {
+	s.hub.Leave(s.room, c)
+}
+
+// Sync sends a snapshot of the document to a member of the room, such as a
+// client that detected a missing patch.
+func (s *StateChannel) Sync(c *Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sendSnapshot(c)
+}
+
+func (s *StateChannel) sendSnapshot(c *Conn) error {
+	p, err := json.Marshal(StateMessage{Type: StateSnapshot, Version: s.version, State: s.raw})
+	if err != nil {
+		return err
+	}
+	return s.hub.Send(c, TextMessage, p)
+}
+
+// State returns the JSON encoding of the document and its version.
+func (s *StateChannel) State() (json.RawMessage, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.raw, s.version
+}
+
+// Set replaces the document with the JSON encoding of v and broadcasts the
+// delta to the room. Set does nothing if the document is unchanged.
+func (s *StateChannel) Set(v any) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeJSON(p)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(doc)
+}
+
+// Patch applies a delta in the format of the channel to the document, such
+// as an edit received from a client, and broadcasts the resulting delta to
+// the room, including the sender of the edit.
+func (s *StateChannel) Patch(patch []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apply := ApplyJSONPatch
+	if s.format == MergePatch {
+		apply = ApplyMergePatch
+	}
+	p, err := apply(s.raw, patch)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeJSON(p)
+	if err != nil {
+		return err
+	}
+	return s.update(doc)
+}
+
+// update replaces the document with doc and broadcasts the delta. The lock
+// must be held.
+func (s *StateChannel) update(doc any) error {
+	var delta any
+	if s.format == MergePatch {
+		patch, changed := diffMergePatch(s.doc, doc)
+		if !changed {
+			return nil
+		}
+		delta = patch
+	} else {
+		ops := diffJSONPatch("", s.doc, doc, nil)
+		if len(ops) == 0 {
+			return nil
+		}
+		delta = ops
+	}
+	patch, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	s.doc, s.raw = doc, raw
+	s.version++
+	m, err := json.Marshal(StateMessage{Type: StatePatch, Version: s.version, Format: s.format.String(), Patch: patch})
+	if err != nil {
+		return err
+	}
+	return s.hub.Broadcast(s.room, TextMessage, m)
+}
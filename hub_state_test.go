@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func readStateMessage(t *testing.T, c *Conn) StateMessage {
+	t.Helper()
+	var m StateMessage
+	if err := json.Unmarshal([]byte(readString(t, c)), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestStateChannel(t *testing.T) {
+	for _, format := range []PatchFormat{JSONPatch, MergePatch} {
+		t.Run(format.String(), func(t *testing.T) {
+			var h Hub
+			defer h.Close()
+			sc := NewStateChannel(&h, "doc", format)
+			if err := sc.Set(map[string]any{"title": "draft", "tags": []string{"a"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			s, c := newPipeConns()
+			defer s.Close()
+			if err := sc.Join(s); err != nil {
+				t.Fatal(err)
+			}
+			m := readStateMessage(t, c)
+			if m.Type != StateSnapshot || m.Version != 1 {
+				t.Fatalf("first message %+v, want snapshot of version 1", m)
+			}
+			state, err := m.Apply(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_ = sc.Set(map[string]any{"title": "draft", "tags": []string{"a"}})
+			_ = sc.Set(map[string]any{"title": "final", "tags": []string{"a", "b"}})
+			edit := `[{"op":"remove","path":"/title"}]`
+			if format == MergePatch {
+				edit = `{"title":null}`
+			}
+			if err := sc.Patch([]byte(edit)); err != nil {
+				t.Fatal(err)
+			}
+			for version := uint64(2); version <= 3; version++ {
+				m := readStateMessage(t, c)
+				if m.Type != StatePatch || m.Version != version || m.Format != format.String() {
+					t.Fatalf("got %+v, want %s patch of version %d", m, format, version)
+				}
+				if state, err = m.Apply(state); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, version := sc.State()
+			if version != 3 || !jsonEqual(t, string(state), string(want)) || !jsonEqual(t, string(want), `{"tags":["a","b"]}`) {
+				t.Errorf("client state %s, channel state %s version %d", state, want, version)
+			}
+
+			if err := sc.Sync(s); err != nil {
+				t.Fatal(err)
+			}
+			if m := readStateMessage(t, c); m.Type != StateSnapshot || m.Version != 3 {
+				t.Errorf("Sync sent %+v", m)
+			}
+		})
+	}
+}
+
+func TestStateChannelInvalidPatch(t *testing.T) {
+	var h Hub
+	defer h.Close()
+	sc := NewStateChannel(&h, "doc", JSONPatch)
+	if err := sc.Patch([]byte(`[{"op":"remove","path":"/missing"}]`)); err == nil {
+		t.Error("invalid patch returned no error")
+	}
+	if _, version := sc.State(); version != 0 {
+		t.Errorf("version %d after invalid patch", version)
+	}
+}
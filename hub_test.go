@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newPipeConns returns a server and client connection connected with an
+// in-memory pipe.
+func newPipeConns() (server, client *Conn) {
+	sc, cc := net.Pipe()
+	return newConn(sc, true, 1024, 1024, nil, nil, nil), newConn(cc, false, 1024, 1024, nil, nil, nil)
+}
+
+func readString(t *testing.T, c *Conn) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	return string(p)
+}
+
+func TestHubBroadcast(t *testing.T) {
+	var h Hub
+	defer h.Close()
+
+	s1, c1 := newPipeConns()
+	s2, c2 := newPipeConns()
+	s3, c3 := newPipeConns()
+	for _, c := range []*Conn{s1, s2} {
+		if err := h.Join("a", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = h.Join("b", s3)
+	_ = h.Join("b", s1)
+
+	if got, want := h.Rooms(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rooms() = %v, want %v", got, want)
+	}
+	if got, want := h.RoomsOf(s1), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RoomsOf() = %v, want %v", got, want)
+	}
+	if h.Len("a") != 2 || h.Count() != 3 {
+		t.Errorf("Len(a)=%d Count()=%d, want 2, 3", h.Len("a"), h.Count())
+	}
+
+	_ = h.Broadcast("a", TextMessage, []byte("hello a"))
+	if got := readString(t, c1); got != "hello a" {
+		t.Errorf("c1 got %q", got)
+	}
+	if got := readString(t, c2); got != "hello a" {
+		t.Errorf("c2 got %q", got)
+	}
+
+	_ = h.BroadcastExcept("b", s1, TextMessage, []byte("hello b"))
+	if got := readString(t, c3); got != "hello b" {
+		t.Errorf("c3 got %q", got)
+	}
+
+	_ = h.Send(s2, TextMessage, []byte("direct"))
+	if got := readString(t, c2); got != "direct" {
+		t.Errorf("c2 got %q", got)
+	}
+
+	h.Leave("a", s2)
+	h.Remove(s3)
+	if h.Len("a") != 1 || h.Len("b") != 1 || h.Count() != 2 {
+		t.Errorf("Len(a)=%d Len(b)=%d Count()=%d, want 1, 1, 2", h.Len("a"), h.Len("b"), h.Count())
+	}
+	if err := h.Send(s3, TextMessage, nil); err != ErrNotHubMember {
+		t.Errorf("Send after Remove returned %v, want %v", err, ErrNotHubMember)
+	}
+
+	var n int
+	h.Range("a", func(c *Conn) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Errorf("Range visited %d connections, want 1", n)
+	}
+}
+
+func TestHubSlowClient(t *testing.T) {
+	var mu sync.Mutex
+	var slow *Conn
+	h := Hub{
+		SendBufferSize: 1,
+		OnSlowClient: func(c *Conn) {
+			mu.Lock()
+			slow = c
+			mu.Unlock()
+		},
+	}
+	defer h.Close()
+
+	// The client never reads, so the write goroutine blocks on the pipe and
+	// the queue fills up.
+	s, _ := newPipeConns()
+	_ = h.Join("a", s)
+	for i := 0; i < 3; i++ {
+		_ = h.Broadcast("a", TextMessage, []byte("x"))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if slow != s {
+		t.Fatal("slow client was not evicted")
+	}
+	if h.Count() != 0 {
+		t.Errorf("Count() = %d, want 0", h.Count())
+	}
+}
+
+func TestHubClosed(t *testing.T) {
+	var h Hub
+	s, _ := newPipeConns()
+	_ = h.Join("a", s)
+	_ = h.Close()
+	if err := h.Join("a", s); err != ErrHubClosed {
+		t.Errorf("Join returned %v, want %v", err, ErrHubClosed)
+	}
+	if err := h.Broadcast("a", TextMessage, nil); err != ErrHubClosed {
+		t.Errorf("Broadcast returned %v, want %v", err, ErrHubClosed)
+	}
+}
+
+func TestHubBroadcastPrepared(t *testing.T) {
+	var h Hub
+	defer h.Close()
+
+	params := deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true}
+	var clients []*Conn
+	for i := 0; i < 3; i++ {
+		s, c := newPipeConns()
+		s.setupDeflate(params)
+		c.setupDeflate(params)
+		_ = h.Join("room", s)
+		clients = append(clients, c)
+	}
+
+	msg := strings.Repeat("prepared ", 100)
+	pm, err := NewPreparedMessage(TextMessage, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.BroadcastPrepared("room", pm); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if got := readString(t, c); got != msg {
+			t.Fatalf("got %q, want %q", got, msg)
+		}
+	}
+
+	// One plain frame from NewPreparedMessage and one compressed frame
+	// shared by all members.
+	pm.mu.Lock()
+	n := len(pm.frames)
+	pm.mu.Unlock()
+	if n != 2 {
+		t.Fatalf("prepared %d frames, want 2", n)
+	}
+
+	if err := h.Broadcast("room", TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if got := readString(t, c); got != msg {
+			t.Fatalf("got %q, want %q", got, msg)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package websocket
+
+// ViewFunc returns the view of a data message broadcast to a room for one
+// of its members, such as the message with the fields the member may not see
+// redacted. ViewFunc returns false to not send the message to the member.
+// ViewFunc must not modify msg, which is shared by all the members.
+type ViewFunc func(c *Conn, msg []byte) ([]byte, bool)
+
+// JoinWithView adds the connection to the named room as Join does, with a
+// view applied to the data messages broadcast to the room for this
+// connection. The view is called at broadcast time, so that each member can
+// receive a personalized view of the same event; the messages returned by
+// the view are encoded for each member instead of being shared. Send and
+// BroadcastAll are not subject to views.
+//
+// The view is called with the lock of the hub shard of the connection held
+// and must not call Join, JoinWithView, Leave, Remove or Close.
+func (h *Hub) JoinWithView(room string, c *Conn, view ViewFunc) error {
+	return h.join(room, c, view)
+}
+
+// setView sets the view of the client for room, or removes it if view is
+// nil. The shard lock must be held.
+func (hc *hubClient) setView(room string, view ViewFunc) {
+	if view == nil {
+		delete(hc.views, room)
+		return
+	}
+	if hc.views == nil {
+		hc.views = make(map[string]ViewFunc)
+	}
+	hc.views[room] = view
+}
+
+// view returns the message broadcast to room as seen by the client, or false
+// if the view drops it. The shard lock must be held.
+func (hc *hubClient) view(room string, m hubMessage) (hubMessage, bool) {
+	view := hc.views[room]
+	if view == nil || !isData(m.messageType) {
+		return m, true
+	}
+	data, ok := view(hc.conn, m.data)
+	if !ok {
+		return m, false
+	}
+	if len(data) == len(m.data) && (len(data) == 0 || &data[0] == &m.data[0]) {
+		// The view returned the message as is; keep the prepared frames.
+		return m, true
+	}
+	return hubMessage{messageType: m.messageType, data: data, tracker: m.tracker}, true
+}
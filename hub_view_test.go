@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHubJoinWithView(t *testing.T) {
+	var h Hub
+	defer h.Close()
+
+	// The admin sees the event as is, the user a redacted view, and the
+	// guest does not receive it.
+	admin, adminClient := newPipeConns()
+	user, userClient := newPipeConns()
+	guest, guestClient := newPipeConns()
+	if err := h.JoinWithView("orders", admin, func(c *Conn, msg []byte) ([]byte, bool) {
+		return msg, true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.JoinWithView("orders", user, func(c *Conn, msg []byte) ([]byte, bool) {
+		return bytes.ReplaceAll(msg, []byte("4242"), []byte("****")), true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.JoinWithView("orders", guest, func(*Conn, []byte) ([]byte, bool) {
+		return nil, false
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = h.Broadcast("orders", TextMessage, []byte("card 4242"))
+	if got := readString(t, adminClient); got != "card 4242" {
+		t.Errorf("admin got %q", got)
+	}
+	if got := readString(t, userClient); got != "card ****" {
+		t.Errorf("user got %q", got)
+	}
+
+	// Send is not subject to the view, and joining again removes it.
+	_ = h.Send(guest, TextMessage, []byte("direct"))
+	if got := readString(t, guestClient); got != "direct" {
+		t.Errorf("guest got %q", got)
+	}
+	if err := h.Join("orders", guest); err != nil {
+		t.Fatal(err)
+	}
+	_ = h.Broadcast("orders", TextMessage, []byte("card 1"))
+	for _, c := range []*Conn{adminClient, userClient, guestClient} {
+		if got := readString(t, c); got != "card 1" {
+			t.Errorf("got %q after the guest joined without a view", got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package websocket
+
+import "encoding/binary"
+
+// FrameInfo describes a frame read from or written to the network.
+type FrameInfo struct {
+	Opcode           int // TextMessage, BinaryMessage, a control message type or 0 for a continuation frame
+	Fin              bool
+	RSV1, RSV2, RSV3 bool
+	Masked           bool
+	Length           int64 // of the payload
+
+	// Payload holds the start of the unmasked payload as on the wire, or the
+	// compressed payload of compressed messages, when requested with
+	// FrameInspector.MaxPayload. Payload is only valid during the callback.
+	Payload []byte
+}
+
+// FrameInspector receives the frames read from and written to a connection,
+// for debugging the protocol in production without capturing the traffic.
+// The callbacks run in the goroutines reading and writing the connection and
+// must not block.
+type FrameInspector struct {
+	// OnFrameRead, if not nil, is called with each frame read, after its
+	// header is read and before its payload is returned to the application.
+	// Frames rejected for invalid reserved bits or opcodes are not reported.
+	OnFrameRead func(c *Conn, f *FrameInfo)
+
+	// OnFrameWrite, if not nil, is called with each frame written, before
+	// the frame is written to the network.
+	OnFrameWrite func(c *Conn, f *FrameInfo)
+
+	// MaxPayload is the maximum number of payload bytes copied to
+	// FrameInfo.Payload. If zero, the payloads are not copied. Copying the
+	// payload of a data frame read waits for its first MaxPayload bytes.
+	MaxPayload int
+}
+
+// SetFrameInspector sets the inspector of the frames of the connection. A
+// nil inspector stops the inspection. SetFrameInspector is safe to call
+// concurrently with the other methods.
+func (c *Conn) SetFrameInspector(fi *FrameInspector) {
+	if c == nil {
+		return
+	}
+	c.inspector.Store(fi)
+}
+
+// inspectRead reports the frame whose header was just read. For control
+// frames, payload is the unmasked payload.
+func (c *Conn) inspectRead(fi *FrameInspector, frameType int, final, masked bool, payload []byte) {
+	if fi.OnFrameRead == nil {
+		return
+	}
+	f := FrameInfo{
+		Opcode: frameType,
+		Fin:    final,
+		RSV1:   c.readDecompress,
+		Masked: masked,
+		Length: c.readRemaining,
+	}
+	if isControl(frameType) {
+		f.Length = int64(len(payload))
+	}
+	if n := fi.MaxPayload; n > 0 {
+		if isControl(frameType) {
+			f.Payload = payload[:min(n, len(payload))]
+		} else if n = int(min(int64(n), c.readRemaining)); n > 0 && c.br != nil {
+			n = min(n, c.br.Size())
+			if p, err := c.br.Peek(n); err == nil {
+				f.Payload = append([]byte(nil), p...)
+				if masked {
+					maskBytes(c.readMaskKey, c.readMaskPos, f.Payload)
+				}
+			}
+		}
+	}
+	fi.OnFrameRead(c, &f)
+}
+
+// inspectWrite reports the frame encoded in buf0 followed by buf1.
+func (c *Conn) inspectWrite(fi *FrameInspector, buf0, buf1 []byte) {
+	if fi.OnFrameWrite == nil || len(buf0) < 2 {
+		return
+	}
+	f := FrameInfo{
+		Opcode: int(buf0[0] & 0xf),
+		Fin:    buf0[0]&finalBit != 0,
+		RSV1:   buf0[0]&rsv1Bit != 0,
+		RSV2:   buf0[0]&rsv2Bit != 0,
+		RSV3:   buf0[0]&rsv3Bit != 0,
+		Masked: buf0[1]&maskBit != 0,
+		Length: int64(buf0[1] & 0x7f),
+	}
+	header := 2
+	switch f.Length {
+	case 126:
+		if len(buf0) < 4 {
+			return
+		}
+		f.Length = int64(binary.BigEndian.Uint16(buf0[2:]))
+		header = 4
+	case 127:
+		if len(buf0) < 10 {
+			return
+		}
+		f.Length = int64(binary.BigEndian.Uint64(buf0[2:]))
+		header = 10
+	}
+	var key [4]byte
+	if f.Masked {
+		if len(buf0) < header+4 {
+			return
+		}
+		copy(key[:], buf0[header:])
+		header += 4
+	}
+	if n := fi.MaxPayload; n > 0 {
+		p := make([]byte, 0, min(int64(n), f.Length))
+		p = append(p, buf0[header:min(len(buf0), header+cap(p))]...)
+		p = append(p, buf1[:min(len(buf1), cap(p)-len(p))]...)
+		if f.Masked {
+			maskBytes(key, 0, p)
+		}
+		f.Payload = p
+	}
+	fi.OnFrameWrite(c, &f)
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// frameLog collects the frames reported by an inspector.
+type frameLog struct {
+	read, written chan FrameInfo
+}
+
+func inspectFrames(c *Conn, maxPayload int) *frameLog {
+	l := &frameLog{read: make(chan FrameInfo, 16), written: make(chan FrameInfo, 16)}
+	record := func(ch chan FrameInfo) func(c *Conn, f *FrameInfo) {
+		return func(c *Conn, f *FrameInfo) {
+			fc := *f
+			fc.Payload = bytes.Clone(f.Payload)
+			ch <- fc
+		}
+	}
+	c.SetFrameInspector(&FrameInspector{OnFrameRead: record(l.read), OnFrameWrite: record(l.written), MaxPayload: maxPayload})
+	return l
+}
+
+func checkFrame(t *testing.T, ch chan FrameInfo, opcode int, fin, masked bool, length int64, payload string) {
+	t.Helper()
+	select {
+	case f := <-ch:
+		if f.Opcode != opcode || f.Fin != fin || f.Masked != masked || f.Length != length || string(f.Payload) != payload {
+			t.Errorf("frame %+v, want opcode %d, fin %v, masked %v, length %d, payload %q", f, opcode, fin, masked, length, payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame not reported")
+	}
+}
+
+func TestFrameInspector(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer server.Close()
+	defer client.Close()
+	sl := inspectFrames(server, 4)
+	cl := inspectFrames(client, 4)
+
+	if err := client.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, cl.written, TextMessage, true, true, 5, "hell")
+	if got := readString(t, server); got != "hello" {
+		t.Fatalf("read %q, want hello", got)
+	}
+	checkFrame(t, sl.read, TextMessage, true, true, 5, "hell")
+
+	// A message longer than the write buffer is fragmented.
+	w, err := server.NextWriter(BinaryMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 15 {
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 100))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WriteControl(PingMessage, []byte("p"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	first := <-sl.written
+	if first.Opcode != BinaryMessage || first.Fin || first.Masked || string(first.Payload) != "xxxx" {
+		t.Fatalf("first frame %+v", first)
+	}
+	checkFrame(t, sl.written, continuationFrame, true, false, 1500-first.Length, "xxxx")
+	checkFrame(t, sl.written, PingMessage, true, false, 1, "p")
+	if _, p, err := client.ReadMessage(); err != nil || len(p) != 1500 {
+		t.Fatalf("ReadMessage returned %d bytes, %v", len(p), err)
+	}
+	checkFrame(t, cl.read, BinaryMessage, false, false, first.Length, "xxxx")
+	checkFrame(t, cl.read, continuationFrame, true, false, 1500-first.Length, "xxxx")
+
+	server.SetFrameInspector(nil)
+	go func() { _ = client.WriteMessage(TextMessage, []byte("quiet")) }()
+	readString(t, server)
+	checkFrame(t, cl.written, TextMessage, true, true, 5, "quie")
+	select {
+	case f := <-sl.read:
+		t.Fatalf("frame %+v reported after the inspector was removed", f)
+	default:
+	}
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// ErrDropMessage is returned by an Interceptor to drop the message.
+var ErrDropMessage = errors.New("websocket: drop message")
+
+// Interceptor inspects or rewrites a data message. An interceptor returns the
+// message payload to pass on, which may be data itself. If an interceptor
+// returns ErrDropMessage, the message is dropped and the following
+// interceptors are not called. If an interceptor returns another error, the
+// message is dropped and the error is returned to the application.
+//
+// Interceptors can be used for logging, tracing, encryption or schema
+// validation.
+type Interceptor func(messageType int, data []byte) ([]byte, error)
+
+// interceptorChain is an immutable list of interceptors that can be replaced
+// while in use.
+type interceptorChain struct {
+	p atomic.Pointer[[]Interceptor]
+}
+
+func (ic *interceptorChain) add(interceptors ...Interceptor) {
+	for {
+		old := ic.p.Load()
+		var chain []Interceptor
+		if old != nil {
+			chain = append(chain, *old...)
+		}
+		chain = append(chain, interceptors...)
+		if ic.p.CompareAndSwap(old, &chain) {
+			return
+		}
+	}
+}
+
+func (ic *interceptorChain) enabled() bool {
+	p := ic.p.Load()
+	return p != nil && len(*p) > 0
+}
+
+// run runs the interceptors in order.
+func (ic *interceptorChain) run(messageType int, data []byte) ([]byte, error) {
+	p := ic.p.Load()
+	if p == nil {
+		return data, nil
+	}
+	for _, i := range *p {
+		var err error
+		data, err = i(messageType, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// UseInbound appends interceptors to the chain run on data messages read from
+// the connection. When inbound interceptors are registered, NextReader reads
+// the entire message into memory before returning it. Messages dropped with
+// ErrDropMessage are skipped; for other errors, the read methods return the
+// error and the next read continues with the next message.
+//
+// UseInbound is safe to call concurrently with all other methods.
+func (c *Conn) UseInbound(interceptors ...Interceptor) {
+	if c == nil {
+		return
+	}
+	c.inbound.add(interceptors...)
+}
+
+// UseOutbound appends interceptors to the chain run on data messages written
+// to the connection. When outbound interceptors are registered, the writer
+// returned by NextWriter buffers the entire message and prepared messages are
+// written as regular messages. Messages dropped with ErrDropMessage are
+// discarded and the write methods report success.
+//
+// UseOutbound is safe to call concurrently with all other methods.
+func (c *Conn) UseOutbound(interceptors ...Interceptor) {
+	if c == nil {
+		return
+	}
+	c.outbound.add(interceptors...)
+}
+
+// interceptRead runs the inbound interceptors on the message returned by
+// nextReader. The caller must hold c.readMu.
+func (c *Conn) interceptRead(messageType int) (int, io.Reader, error) {
+	for {
+		p, err := io.ReadAll(c.reader)
+		if err != nil {
+			return noFrame, nil, err
+		}
+		p, err = c.inbound.run(messageType, p)
+		if err == nil {
+			return messageType, bytes.NewReader(p), nil
+		}
+		if err != ErrDropMessage {
+			return noFrame, nil, err
+		}
+		messageType, _, err = c.nextReader()
+		if err != nil {
+			return messageType, nil, err
+		}
+	}
+}
+
+// interceptWrite runs the outbound interceptors on a data message. It
+// returns false if the message must not be written.
+func (c *Conn) interceptWrite(messageType int, data []byte) ([]byte, bool, error) {
+	if !isData(messageType) {
+		return data, true, nil
+	}
+	data, err := c.outbound.run(messageType, data)
+	switch err {
+	case nil:
+		return data, true, nil
+	case ErrDropMessage:
+		return nil, false, nil
+	default:
+		return nil, false, err
+	}
+}
+
+// interceptWriter buffers a message written with NextWriter for the outbound
+// interceptors.
+type interceptWriter struct {
+	c           *Conn
+	messageType int
+	buf         bytes.Buffer
+	closed      bool
+}
+
+func (w *interceptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriteClosed
+	}
+	return w.buf.Write(p)
+}
+
+func (w *interceptWriter) Close() error {
+	if w.closed {
+		return errWriteClosed
+	}
+	w.closed = true
+	return w.c.WriteMessage(w.messageType, w.buf.Bytes())
+}
+
+// UseOutbound appends interceptors to the chain run once on each message sent
+// through the hub, before the message is queued for the recipients. A message
+// dropped with ErrDropMessage is not sent; other errors are returned by the
+// send and broadcast methods.
+//
+// Interceptors registered on the connections with Conn.UseOutbound run
+// additionally for each recipient.
+func (h *Hub) UseOutbound(interceptors ...Interceptor) {
+	h.outbound.add(interceptors...)
+}
+
+// UseInbound registers interceptors on every connection registered with the
+// hub, now or later, as with Conn.UseInbound. The interceptors stay
+// registered on connections removed from the hub.
+func (h *Hub) UseInbound(interceptors ...Interceptor) {
+	h.lockShards()
+	defer h.unlockShards()
+	h.inbound = append(h.inbound, interceptors...)
+	for _, s := range h.shards {
+		for c := range s.clients {
+			c.UseInbound(interceptors...)
+		}
+	}
+}
+
+// intercept runs the hub's outbound interceptors. It returns false if the
+// message must not be sent.
+func (h *Hub) intercept(messageType int, data []byte) ([]byte, bool, error) {
+	data, err := h.outbound.run(messageType, data)
+	switch err {
+	case nil:
+		return data, true, nil
+	case ErrDropMessage:
+		return nil, false, nil
+	default:
+		return nil, false, err
+	}
+}
@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+
+	var logged []string
+	wc.UseOutbound(
+		func(mt int, p []byte) ([]byte, error) {
+			if string(p) == "secret" {
+				return nil, ErrDropMessage
+			}
+			return p, nil
+		},
+		func(mt int, p []byte) ([]byte, error) {
+			return []byte(strings.ToUpper(string(p))), nil
+		},
+	)
+	rc.UseInbound(func(mt int, p []byte) ([]byte, error) {
+		logged = append(logged, string(p))
+		if string(p) == "SKIP" {
+			return nil, ErrDropMessage
+		}
+		return append(p, '!'), nil
+	})
+
+	for _, m := range []string{"hello", "secret", "skip", "world"} {
+		if err := wc.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatalf("WriteMessage(%q): %v", m, err)
+		}
+	}
+	w, err := wc.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "stream")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, _ := NewPreparedMessage(TextMessage, []byte("prepared"))
+	if err := wc.WritePreparedMessage(pm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"HELLO!", "WORLD!", "STREAM!", "PREPARED!"} {
+		_, p, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if string(p) != want {
+			t.Fatalf("got %q, want %q", p, want)
+		}
+	}
+	if got := strings.Join(logged, ","); got != "HELLO,SKIP,WORLD,STREAM,PREPARED" {
+		t.Fatalf("inbound interceptor saw %s", got)
+	}
+}
+
+func TestInterceptorError(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	rc := newTestConn(&buf, nil, false)
+	errInvalid := errors.New("invalid")
+
+	_ = wc.WriteMessage(TextMessage, []byte("bad"))
+	_ = wc.WriteMessage(TextMessage, []byte("good"))
+	rc.UseInbound(func(mt int, p []byte) ([]byte, error) {
+		if string(p) == "bad" {
+			return nil, errInvalid
+		}
+		return p, nil
+	})
+	if _, _, err := rc.ReadMessage(); err != errInvalid {
+		t.Fatalf("ReadMessage returned %v, want %v", err, errInvalid)
+	}
+	if _, p, err := rc.ReadMessage(); err != nil || string(p) != "good" {
+		t.Fatalf("ReadMessage after error returned %q, %v", p, err)
+	}
+
+	wc.UseOutbound(func(mt int, p []byte) ([]byte, error) { return nil, errInvalid })
+	if err := wc.WriteMessage(TextMessage, []byte("x")); err != errInvalid {
+		t.Fatalf("WriteMessage returned %v, want %v", err, errInvalid)
+	}
+}
+
+func TestHubInterceptors(t *testing.T) {
+	var h Hub
+	defer h.Close()
+	s1, c1 := newPipeConns()
+	_ = h.Join("room", s1)
+
+	calls := 0
+	h.UseOutbound(func(mt int, p []byte) ([]byte, error) {
+		calls++
+		if string(p) == "drop" {
+			return nil, ErrDropMessage
+		}
+		return append([]byte("hub:"), p...), nil
+	})
+	_ = h.Broadcast("room", TextMessage, []byte("drop"))
+	_ = h.Broadcast("room", TextMessage, []byte("hi"))
+	if got := readString(t, c1); got != "hub:hi" {
+		t.Fatalf("got %q, want %q", got, "hub:hi")
+	}
+	if calls != 2 {
+		t.Fatalf("interceptor called %d times, want 2", calls)
+	}
+
+	h.UseInbound(func(mt int, p []byte) ([]byte, error) { return append(p, '?'), nil })
+	go c1.WriteMessage(TextMessage, []byte("ping"))
+	if got := readString(t, s1); got != "ping?" {
+		t.Fatalf("got %q, want %q", got, "ping?")
+	}
+}
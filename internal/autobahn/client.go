@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/gflydev/websocket"
+)
+
+var dialer = websocket.Dialer{
+	ReadBufferSize:    4096,
+	WriteBufferSize:   4096,
+	EnableCompression: true,
+	StrictUTF8:        true,
+}
+
+// runClient runs the cases of the fuzzingserver at base, or only the given
+// case if it is not empty, and updates the reports of the agent.
+func runClient(base, agent, only string) error {
+	var first, last int
+	if only != "" {
+		n, err := strconv.Atoi(only)
+		if err != nil {
+			return err
+		}
+		first, last = n, n
+	} else {
+		n, err := caseCount(base)
+		if err != nil {
+			return err
+		}
+		first, last = 1, n
+	}
+	for i := first; i <= last; i++ {
+		if err := runCase(base, agent, i); err != nil {
+			log.Printf("case %d: %v", i, err)
+		}
+	}
+	c, _, err := dialer.Dial(base+"/updateReports?agent="+url.QueryEscape(agent), nil)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+func caseCount(base string) (int, error) {
+	c, _, err := dialer.Dial(base+"/getCaseCount", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(p))
+}
+
+func runCase(base, agent string, n int) error {
+	c, _, err := dialer.Dial(base+"/runCase?case="+strconv.Itoa(n)+"&agent="+url.QueryEscape(agent), nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	err = echo(c)
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+// Command autobahn runs this package against the Autobahn|Testsuite
+// (https://github.com/crossbario/autobahn-testsuite).
+//
+// In server mode it serves an echo handler for the fuzzingclient of the
+// test suite:
+//
+//	go run ./internal/autobahn -server :9000
+//	docker run -it --rm --network host -v "$PWD/internal/autobahn/config:/config" \
+//	    -v "$PWD/reports:/reports" crossbario/autobahn-testsuite \
+//	    wstest -m fuzzingclient -s /config/fuzzingclient.json
+//
+// In client mode it runs all cases against a fuzzingserver of the test suite
+// and updates its reports:
+//
+//	docker run -it --rm --network host -v "$PWD/internal/autobahn/config:/config" \
+//	    -v "$PWD/reports:/reports" crossbario/autobahn-testsuite \
+//	    wstest -m fuzzingserver -s /config/fuzzingserver.json
+//	go run ./internal/autobahn -client ws://localhost:9001
+//
+// The reports are written to the reports directory.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+)
+
+func main() {
+	server := flag.String("server", "", "serve the echo handler on this address")
+	client := flag.String("client", "", "run the cases of the fuzzingserver at this URL")
+	agent := flag.String("agent", "gflydev/websocket", "agent name in the reports")
+	cases := flag.String("cases", "", "run only the case with this number")
+	flag.Parse()
+
+	switch {
+	case *server != "":
+		log.Printf("serving on %s", *server)
+		log.Fatal(http.ListenAndServe(*server, echoHandler()))
+	case *client != "":
+		if err := runClient(*client, *agent, *cases); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+func TestEcho(t *testing.T) {
+	s := httptest.NewServer(echoHandler())
+	defer s.Close()
+	c, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, m := range []struct {
+		mt int
+		p  string
+	}{
+		{websocket.TextMessage, "hello, 世界"},
+		{websocket.BinaryMessage, "\xff\x00"},
+	} {
+		if err := c.WriteMessage(m.mt, []byte(m.p)); err != nil {
+			t.Fatal(err)
+		}
+		mt, p, err := c.ReadMessage()
+		if err != nil || mt != m.mt || string(p) != m.p {
+			t.Fatalf("got %d %q %v, want %d %q", mt, p, err, m.mt, m.p)
+		}
+	}
+
+	if err := c.WriteMessage(websocket.TextMessage, []byte("\xce\xba\xe1\xbd")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseInvalidFramePayloadData) {
+		t.Fatalf("invalid UTF-8 returned %v, want close %d", err, websocket.CloseInvalidFramePayloadData)
+	}
+}
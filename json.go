@@ -14,6 +14,13 @@ import (
 // See the documentation for encoding/json Marshal for details about the
 // conversion of Go values to JSON.
 func (c *Conn) WriteJSON(v interface{}) error {
+	if c != nil && c.writeQueue != nil {
+		p, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return c.WriteMessage(TextMessage, p)
+	}
 	w, err := c.NextWriter(TextMessage)
 	if err != nil {
 		return err
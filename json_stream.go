@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+)
+
+// jsonStreamBufferSize is the size of the buffer of WriteJSONStream between
+// the encoder and the message writer.
+const jsonStreamBufferSize = 4096
+
+// ReadJSONStream is like ReadJSON, but decodes a JSON array into the slice
+// pointed to by v one element at a time, as the frames of the message are
+// received. Unlike ReadJSON, which buffers the encoding of the whole value
+// before decoding it, ReadJSONStream holds the encoding of a single element
+// at a time, so that the peak memory of a large message is about the size of
+// the decoded value. Values other than a pointer to a slice are decoded like
+// ReadJSON.
+//
+// Decoded elements are appended to the slice, which is reset to zero length
+// first. ReadJSONStream returns an error if the message holds data after the
+// value.
+func (c *Conn) ReadJSONStream(v interface{}) error {
+	_, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || !streamableJSON(rv.Elem().Type(), reflect.Slice) {
+		err = dec.Decode(v)
+	} else {
+		err = decodeJSONArray(dec, rv.Elem())
+	}
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return nil
+		} else if err == nil {
+			err = errors.New("websocket: data after JSON value")
+		}
+	}
+	if err == io.EOF {
+		// One value is expected in the message.
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// jsonCodingTypes are the interfaces of the types with their own JSON
+// encoding.
+var jsonCodingTypes = []reflect.Type{
+	reflect.TypeFor[json.Marshaler](),
+	reflect.TypeFor[json.Unmarshaler](),
+	reflect.TypeFor[encoding.TextMarshaler](),
+	reflect.TypeFor[encoding.TextUnmarshaler](),
+}
+
+// streamableJSON reports whether values of type t, of one of the kinds, are
+// encoded as a JSON array element by element: byte slices are encoded as
+// base64 strings and types with their own encoding are left to it.
+func streamableJSON(t reflect.Type, kinds ...reflect.Kind) bool {
+	ok := false
+	for _, k := range kinds {
+		ok = ok || t.Kind() == k
+	}
+	if !ok || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8) {
+		return false
+	}
+	for _, it := range jsonCodingTypes {
+		if t.Implements(it) || reflect.PointerTo(t).Implements(it) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeJSONArray decodes a JSON array element by element into the slice s.
+func decodeJSONArray(dec *json.Decoder, s reflect.Value) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		s.SetZero()
+		return nil
+	}
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return &json.UnmarshalTypeError{Value: jsonTokenKind(t), Type: s.Type(), Offset: dec.InputOffset()}
+	}
+	s.SetLen(0)
+	for dec.More() {
+		n := s.Len()
+		if n == s.Cap() {
+			s.Grow(1)
+		}
+		s.SetLen(n + 1)
+		if err := dec.Decode(s.Index(n).Addr().Interface()); err != nil {
+			s.SetLen(n)
+			return err
+		}
+	}
+	_, err = dec.Token() // ']'
+	return err
+}
+
+// jsonTokenKind returns the kind of JSON value starting with token t, as
+// reported by json.UnmarshalTypeError.
+func jsonTokenKind(t json.Token) string {
+	switch t := t.(type) {
+	case json.Delim:
+		if t == '{' {
+			return "object"
+		}
+		return "array"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	default:
+		return "number"
+	}
+}
+
+// WriteJSONStream is like WriteJSON, but encodes a slice or an array one
+// element at a time into the frames of the message, so that the encoding of
+// the whole value is never held in memory. Other values are encoded like
+// WriteJSON.
+//
+// With a write queue, see EnableWriteQueue, messages cannot be streamed and
+// WriteJSONStream encodes the value like WriteJSON.
+func (c *Conn) WriteJSONStream(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if c == nil || c.writeQueue != nil || !rv.IsValid() ||
+		!streamableJSON(rv.Type(), reflect.Slice, reflect.Array) || (rv.Kind() == reflect.Slice && rv.IsNil()) {
+		return c.WriteJSON(v)
+	}
+	w, err := c.NextWriter(TextMessage)
+	if err != nil {
+		return err
+	}
+	err1 := encodeJSONArray(w, rv)
+	err2 := w.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// encodeJSONArray writes the JSON encoding of the elements of the slice or
+// array s to w.
+func encodeJSONArray(w io.Writer, s reflect.Value) error {
+	bw := bufio.NewWriterSize(w, jsonStreamBufferSize)
+	enc := json.NewEncoder(bw)
+	_ = bw.WriteByte('[')
+	for i := 0; i < s.Len(); i++ {
+		if i > 0 {
+			_ = bw.WriteByte(',')
+		}
+		// Encode appends a newline, which is valid whitespace in an array.
+		if err := enc.Encode(s.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	_ = bw.WriteByte(']')
+	return bw.Flush()
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type jsonStreamItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestJSONStream(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer server.Close()
+	defer client.Close()
+
+	items := make([]jsonStreamItem, 2000)
+	for i := range items {
+		items[i] = jsonStreamItem{ID: i, Name: strings.Repeat("x", i%10)}
+	}
+	values := []interface{}{items, map[string]int{"a": 1}, []byte("bytes"), [2]int{1, 2}}
+	errs := make(chan error, 1)
+	go func() {
+		for _, v := range values {
+			if err := client.WriteJSONStream(v); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	got := []jsonStreamItem{{ID: -1}}
+	if err := server.ReadJSONStream(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, items) {
+		t.Fatalf("ReadJSONStream decoded %d items, want %d", len(got), len(items))
+	}
+	var m map[string]int
+	if err := server.ReadJSONStream(&m); err != nil || m["a"] != 1 {
+		t.Fatalf("ReadJSONStream() = %v, %v", m, err)
+	}
+	var b []byte
+	if err := server.ReadJSONStream(&b); err != nil || string(b) != "bytes" {
+		t.Fatalf("ReadJSONStream() = %q, %v, want the base64 decoded bytes", b, err)
+	}
+	var a []int
+	if err := server.ReadJSONStream(&a); err != nil || !reflect.DeepEqual(a, []int{1, 2}) {
+		t.Fatalf("ReadJSONStream() = %v, %v", a, err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadJSONStreamErrors(t *testing.T) {
+	for _, message := range []string{`{"id":1}`, `[1, 2] 3`, `[1, 2`} {
+		var buf bytes.Buffer
+		c := newTestConn(nil, &buf, false)
+		if err := c.WriteMessage(TextMessage, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		c = newTestConn(&buf, nil, true)
+		var v []int
+		if err := c.ReadJSONStream(&v); err == nil {
+			t.Errorf("ReadJSONStream of %s succeeded", message)
+		}
+	}
+
+	var buf bytes.Buffer
+	c := newTestConn(nil, &buf, false)
+	if err := c.WriteJSONStream([]json.RawMessage{[]byte(`{"a":1}`), []byte(`null`)}); err != nil {
+		t.Fatal(err)
+	}
+	c = newTestConn(&buf, nil, true)
+	_, p, err := c.ReadMessage()
+	if err != nil || !json.Valid(p) {
+		t.Fatalf("WriteJSONStream wrote %q, %v", p, err)
+	}
+}
@@ -0,0 +1,414 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchFormat specifies the format of the deltas sent by a StateChannel.
+type PatchFormat int
+
+const (
+	// JSONPatch deltas are JSON Patch documents as specified in RFC 6902.
+	JSONPatch PatchFormat = iota
+
+	// MergePatch deltas are JSON Merge Patch documents as specified in RFC
+	// 7396. A merge patch cannot set a member to null: setting a member to
+	// null in the state removes the member for the clients.
+	MergePatch
+)
+
+// String returns the name of the format used in state messages.
+func (f PatchFormat) String() string {
+	switch f {
+	case JSONPatch:
+		return "json-patch"
+	case MergePatch:
+		return "merge-patch"
+	default:
+		return "PatchFormat(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+var errInvalidPatch = errors.New("websocket: invalid patch")
+
+// patchOperation is an operation of a JSON Patch document.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	From  string `json:"from,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// MarshalJSON writes the value of the operations that take a value, even if
+// the value is null.
+func (op patchOperation) MarshalJSON() ([]byte, error) {
+	type operation patchOperation
+	switch op.Op {
+	case "add", "replace", "test":
+		return json.Marshal(struct {
+			operation
+			Value any `json:"value"`
+		}{operation(op), op.Value})
+	}
+	return json.Marshal(operation(op))
+}
+
+// decodeJSON decodes p keeping the numbers as json.Number, so that the
+// documents are compared and written back without loss of precision.
+func decodeJSON(p []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("websocket: invalid JSON document")
+	}
+	return v, nil
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to the JSON document doc and
+// returns the patched document. The patch is applied entirely or not at all.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	v, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []struct {
+		Op    string          `json:"op"`
+		Path  *string         `json:"path"`
+		From  *string         `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if op.Path == nil {
+			return nil, fmt.Errorf("%w: operation %d has no path", errInvalidPatch, i)
+		}
+		path, err := pointerTokens(*op.Path)
+		if err != nil {
+			return nil, err
+		}
+		var value, from any
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%w: operation %d has no value", errInvalidPatch, i)
+			}
+			if value, err = decodeJSON(op.Value); err != nil {
+				return nil, err
+			}
+		case "move", "copy":
+			if op.From == nil {
+				return nil, fmt.Errorf("%w: operation %d has no from", errInvalidPatch, i)
+			}
+			fromPath, err := pointerTokens(*op.From)
+			if err != nil {
+				return nil, err
+			}
+			if from, err = pointerGet(v, fromPath); err != nil {
+				return nil, err
+			}
+			if op.Op == "move" {
+				if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+					return nil, fmt.Errorf("%w: cannot move %s into itself", errInvalidPatch, *op.From)
+				}
+				if v, err = pointerRemove(v, fromPath); err != nil {
+					return nil, err
+				}
+			} else {
+				from = copyJSON(from)
+			}
+		}
+		switch op.Op {
+		case "add":
+			v, err = pointerAdd(v, path, value)
+		case "remove":
+			v, err = pointerRemove(v, path)
+		case "replace":
+			if v, err = pointerRemove(v, path); err == nil {
+				v, err = pointerAdd(v, path, value)
+			}
+		case "move", "copy":
+			v, err = pointerAdd(v, path, from)
+		case "test":
+			var got any
+			if got, err = pointerGet(v, path); err == nil && !reflect.DeepEqual(got, value) {
+				err = fmt.Errorf("%w: test of %s failed", errInvalidPatch, *op.Path)
+			}
+		default:
+			err = fmt.Errorf("%w: unknown operation %q", errInvalidPatch, op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(v)
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to the JSON document
+// doc and returns the patched document.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	v, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(v, p))
+}
+
+func mergePatch(v, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		m = make(map[string]any, len(p))
+	}
+	for k, pv := range p {
+		if pv == nil {
+			delete(m, k)
+		} else {
+			m[k] = mergePatch(m[k], pv)
+		}
+	}
+	return m
+}
+
+// diffJSONPatch returns the JSON Patch operations transforming a into b.
+func diffJSONPatch(path string, a, b any, ops []patchOperation) []patchOperation {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			for _, k := range sortedKeys(a) {
+				if _, ok := b[k]; !ok {
+					ops = append(ops, patchOperation{Op: "remove", Path: path + "/" + escapePointer(k)})
+				}
+			}
+			for _, k := range sortedKeys(b) {
+				p := path + "/" + escapePointer(k)
+				if av, ok := a[k]; ok {
+					ops = diffJSONPatch(p, av, b[k], ops)
+				} else {
+					ops = append(ops, patchOperation{Op: "add", Path: p, Value: b[k]})
+				}
+			}
+			return ops
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			n := min(len(a), len(b))
+			for i := 0; i < n; i++ {
+				ops = diffJSONPatch(path+"/"+strconv.Itoa(i), a[i], b[i], ops)
+			}
+			// Remove from the end so that the indices stay valid.
+			for i := len(a) - 1; i >= n; i-- {
+				ops = append(ops, patchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+			}
+			for _, v := range b[n:] {
+				ops = append(ops, patchOperation{Op: "add", Path: path + "/-", Value: v})
+			}
+			return ops
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		ops = append(ops, patchOperation{Op: "replace", Path: path, Value: b})
+	}
+	return ops
+}
+
+// diffMergePatch returns the JSON Merge Patch transforming a into b, or
+// false if a and b are equal.
+func diffMergePatch(a, b any) (any, bool) {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if !aok || !bok {
+		return b, !reflect.DeepEqual(a, b)
+	}
+	patch := make(map[string]any)
+	for k := range am {
+		if _, ok := bm[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, bv := range bm {
+		av, ok := am[k]
+		if !ok {
+			patch[k] = bv
+		} else if p, changed := diffMergePatch(av, bv); changed {
+			patch[k] = p
+		}
+	}
+	return patch, len(patch) > 0
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyJSON returns a deep copy of a decoded document.
+func copyJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = copyJSON(e)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, e := range v {
+			s[i] = copyJSON(e)
+		}
+		return s
+	}
+	return v
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapePointer(s string) string { return pointerEscaper.Replace(s) }
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// pointerTokens splits an RFC 6901 JSON Pointer into its reference tokens.
+func pointerTokens(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("%w: invalid pointer %q", errInvalidPatch, p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = pointerUnescaper.Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex returns the array index of token. The index may equal n, the
+// length of the array, when end is set.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if end && token == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') || i > n || (i == n && !end) {
+		return 0, fmt.Errorf("%w: invalid array index %q", errInvalidPatch, token)
+	}
+	return i, nil
+}
+
+func pointerGet(v any, tokens []string) (any, error) {
+	for _, t := range tokens {
+		switch c := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = c[t]; !ok {
+				return nil, fmt.Errorf("%w: member %q not found", errInvalidPatch, t)
+			}
+		case []any:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			v = c[i]
+		default:
+			return nil, fmt.Errorf("%w: cannot index %T with %q", errInvalidPatch, v, t)
+		}
+	}
+	return v, nil
+}
+
+// pointerAdd adds value at the location and returns the updated document.
+func pointerAdd(v any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	t := tokens[0]
+	switch c := v.(type) {
+	case map[string]any:
+		if len(tokens) == 1 {
+			c[t] = value
+			return c, nil
+		}
+		child, ok := c[t]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q not found", errInvalidPatch, t)
+		}
+		child, err := pointerAdd(child, tokens[1:], value)
+		c[t] = child
+		return c, err
+	case []any:
+		if len(tokens) == 1 {
+			i, err := arrayIndex(t, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		i, err := arrayIndex(t, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		c[i], err = pointerAdd(c[i], tokens[1:], value)
+		return c, err
+	}
+	return nil, fmt.Errorf("%w: cannot index %T with %q", errInvalidPatch, v, t)
+}
+
+// pointerRemove removes the value at the location and returns the updated
+// document.
+func pointerRemove(v any, tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	t := tokens[0]
+	switch c := v.(type) {
+	case map[string]any:
+		child, ok := c[t]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q not found", errInvalidPatch, t)
+		}
+		if len(tokens) == 1 {
+			delete(c, t)
+			return c, nil
+		}
+		child, err := pointerRemove(child, tokens[1:])
+		c[t] = child
+		return c, err
+	case []any:
+		i, err := arrayIndex(t, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 1 {
+			return append(c[:i], c[i+1:]...), nil
+		}
+		c[i], err = pointerRemove(c[i], tokens[1:])
+		return c, err
+	}
+	return nil, fmt.Errorf("%w: cannot index %T with %q", errInvalidPatch, v, t)
+}
@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func jsonEqual(t *testing.T, got, want string) bool {
+	t.Helper()
+	g, err := decodeJSON([]byte(got))
+	if err != nil {
+		t.Fatalf("decode %s: %v", got, err)
+	}
+	w, err := decodeJSON([]byte(want))
+	if err != nil {
+		t.Fatalf("decode %s: %v", want, err)
+	}
+	return reflect.DeepEqual(g, w)
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	for _, tt := range []struct {
+		doc, patch, want string
+	}{
+		// Examples from RFC 6902, appendix A.
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":1,"~":2}`, `[{"op":"copy","from":"/~1","path":"/~0"}]`, `{"/":1,"~":1}`},
+		{`{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	} {
+		got, err := ApplyJSONPatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("ApplyJSONPatch(%s, %s) returned %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !jsonEqual(t, string(got), tt.want) {
+			t.Errorf("ApplyJSONPatch(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		doc, patch string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+		{`{"foo":[1]}`, `[{"op":"add","path":"/foo/2","value":2}]`},
+		{`{"foo":[1]}`, `[{"op":"remove","path":"/foo/01"}]`},
+		{`{"foo":1}`, `[{"op":"remove","path":"/bar"}]`},
+		{`{"foo":{}}`, `[{"op":"move","from":"/foo","path":"/foo/bar"}]`},
+		{`{"foo":1}`, `[{"op":"invalid","path":"/foo"}]`},
+		{`{"foo":1}`, `[{"op":"add","path":"/bar"}]`},
+	} {
+		if got, err := ApplyJSONPatch([]byte(tt.doc), []byte(tt.patch)); err == nil {
+			t.Errorf("ApplyJSONPatch(%s, %s) = %s, want error", tt.doc, tt.patch, got)
+		}
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// Examples from RFC 7396, appendix A.
+	for _, tt := range []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		got, err := ApplyMergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("ApplyMergePatch(%s, %s) returned %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !jsonEqual(t, string(got), tt.want) {
+			t.Errorf("ApplyMergePatch(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	docs := []string{
+		`null`,
+		`{"a":1,"b":[1,2,3],"c":{"d":"e"}}`,
+		`{"a":1,"b":[1,2,3],"c":{"d":"e"}}`,
+		`{"a":2,"b":[1,4],"c":{"f":null},"a/b~":true}`,
+		`{"a":2,"b":[1,4,5,6],"c":"x"}`,
+		`[1,{"a":1.50}]`,
+		`"text"`,
+	}
+	for _, format := range []PatchFormat{JSONPatch, MergePatch} {
+		for i := 1; i < len(docs); i++ {
+			a, _ := decodeJSON([]byte(docs[i-1]))
+			b, _ := decodeJSON([]byte(docs[i]))
+			var delta any
+			changed := true
+			apply := ApplyJSONPatch
+			if format == MergePatch {
+				delta, changed = diffMergePatch(a, b)
+				apply = ApplyMergePatch
+			} else {
+				ops := diffJSONPatch("", a, b, nil)
+				delta, changed = ops, len(ops) > 0
+			}
+			if changed != (docs[i-1] != docs[i]) {
+				t.Errorf("%v: changed = %v from %s to %s", format, changed, docs[i-1], docs[i])
+			}
+			if !changed {
+				continue
+			}
+			patch, err := json.Marshal(delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := apply([]byte(docs[i-1]), patch)
+			if err != nil {
+				t.Errorf("%v: applying %s to %s returned %v", format, patch, docs[i-1], err)
+				continue
+			}
+			want := docs[i]
+			if format == MergePatch && i == 3 {
+				// A merge patch cannot set a member to null.
+				want = `{"a":2,"b":[1,4],"c":{},"a/b~":true}`
+			}
+			if !jsonEqual(t, string(got), want) {
+				t.Errorf("%v: applying %s to %s = %s, want %s", format, patch, docs[i-1], got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,560 @@
+// Package jsonrpc implements JSON-RPC 2.0 over WebSocket connections.
+//
+// Each WebSocket text message carries a request, a notification, a
+// response, or a batch of them, as specified at
+// https://www.jsonrpc.org/specification. A Conn is symmetric: both ends of a
+// WebSocket connection can serve requests with a Handler and make calls, so
+// the package works with client and server connections alike.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gflydev/websocket"
+)
+
+// Error codes defined by the specification.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// CodeServerBusy is the code of the server error answering the requests
+// received over Conn.MaxConcurrent.
+const CodeServerBusy = -32000
+
+// ErrClosed is returned by the calls of a Conn that stopped running.
+var ErrClosed = errors.New("jsonrpc: connection closed")
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return "jsonrpc: " + e.Message + " (" + strconv.Itoa(e.Code) + ")"
+}
+
+var (
+	errParse          = &Error{Code: CodeParseError, Message: "Parse error"}
+	errInvalidRequest = &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}
+	errServerBusy     = &Error{Code: CodeServerBusy, Message: "Server busy"}
+)
+
+const defaultMaxConcurrent = 64
+
+// Request is a request or notification received by a Handler.
+type Request struct {
+	Method string
+	Params json.RawMessage // nil if the params are omitted
+
+	// ID is the raw JSON ID of the request, nil for notifications.
+	ID json.RawMessage
+}
+
+// IsNotification reports whether the request is a notification, which gets
+// no response.
+func (r *Request) IsNotification() bool { return r.ID == nil }
+
+// Handler responds to requests. The error returned by ServeJSONRPC is sent
+// as is if it is an *Error, or as an internal error otherwise. The response
+// of a notification is discarded.
+//
+// Handlers are called concurrently, each in its own goroutine, with a
+// context that is canceled when the connection stops running.
+type Handler interface {
+	ServeJSONRPC(ctx context.Context, c *Conn, req *Request) (result interface{}, err error)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, c *Conn, req *Request) (interface{}, error)
+
+// ServeJSONRPC returns f(ctx, c, req).
+func (f HandlerFunc) ServeJSONRPC(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+	return f(ctx, c, req)
+}
+
+// Func returns a Handler that decodes the params into a value of type P and
+// returns the result of f. Params that cannot be decoded are answered with
+// an invalid params error.
+func Func[P, R any](f func(ctx context.Context, params P) (R, error)) Handler {
+	return HandlerFunc(func(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+		var params P
+		if req.Params != nil {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: "Invalid params", Data: errorData(err)}
+			}
+		}
+		return f(ctx, params)
+	})
+}
+
+func errorData(err error) json.RawMessage {
+	p, _ := json.Marshal(err.Error())
+	return p
+}
+
+// Mux dispatches requests to handlers by method name. Requests for other
+// methods are answered with a method not found error. The zero value is
+// ready to use.
+type Mux struct {
+	mu sync.RWMutex
+	m  map[string]Handler
+}
+
+// Handle registers the handler for the method.
+func (m *Mux) Handle(method string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.m == nil {
+		m.m = make(map[string]Handler)
+	}
+	m.m[method] = h
+}
+
+// HandleFunc registers the handler function for the method.
+func (m *Mux) HandleFunc(method string, f func(ctx context.Context, c *Conn, req *Request) (interface{}, error)) {
+	m.Handle(method, HandlerFunc(f))
+}
+
+// ServeJSONRPC dispatches the request to the handler of its method.
+func (m *Mux) ServeJSONRPC(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+	m.mu.RLock()
+	h := m.m[req.Method]
+	m.mu.RUnlock()
+	if h == nil {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "Method not found"}
+	}
+	return h.ServeJSONRPC(ctx, c, req)
+}
+
+// message is a request, notification or response on the wire.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// response is a response on the wire. Unlike message, it always has an ID
+// and, on success, a result.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Conn is a JSON-RPC peer over a WebSocket connection. Run must be running
+// for the Conn to serve requests and receive responses.
+type Conn struct {
+	// MaxConcurrent limits the number of requests and notifications of the
+	// remote peer served concurrently, each by a goroutine. Requests
+	// received over the limit are answered with a CodeServerBusy error and
+	// notifications are dropped; the elements of a batch being served are
+	// served one after the other once the limit is reached. If zero, a
+	// default of 64 is used. Set MaxConcurrent before calling Run.
+	MaxConcurrent int
+
+	ws      *websocket.Conn
+	handler Handler
+	sem     chan struct{} // a slot for each handler running, see MaxConcurrent
+
+	writeMu sync.Mutex // serializes writes to ws
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[string]chan *message // by ID
+	done    bool
+}
+
+// NewConn returns a peer on the WebSocket connection. The handler serves the
+// requests of the remote peer; if nil, requests are answered with method not
+// found errors.
+func NewConn(ws *websocket.Conn, handler Handler) *Conn {
+	if handler == nil {
+		handler = &Mux{}
+	}
+	return &Conn{ws: ws, handler: handler, pending: make(map[string]chan *message)}
+}
+
+// WebSocket returns the WebSocket connection of the peer.
+func (c *Conn) WebSocket() *websocket.Conn { return c.ws }
+
+// Close closes the WebSocket connection.
+func (c *Conn) Close() error { return c.ws.Close() }
+
+// Run reads and dispatches messages until reading fails or ctx is done, and
+// returns the error. Pending calls fail with ErrClosed when Run returns.
+func (c *Conn) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = c.ws.Close() })
+	defer stop()
+	defer c.shutdown()
+
+	n := c.MaxConcurrent
+	if n <= 0 {
+		n = defaultMaxConcurrent
+	}
+	c.sem = make(chan struct{}, n)
+	for {
+		_, p, err := c.ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		c.dispatch(ctx, p)
+	}
+}
+
+// shutdown fails the pending calls.
+func (c *Conn) shutdown() {
+	c.mu.Lock()
+	c.done = true
+	pending := c.pending
+	c.pending = make(map[string]chan *message)
+	c.mu.Unlock()
+	for _, ch := range pending {
+		close(ch)
+	}
+}
+
+// dispatch handles a message, a single object or a batch.
+func (c *Conn) dispatch(ctx context.Context, p []byte) {
+	p = bytes.TrimSpace(p)
+	if len(p) > 0 && p[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(p, &batch); err != nil {
+			c.write(&response{JSONRPC: "2.0", Error: errParse, ID: json.RawMessage("null")})
+			return
+		}
+		if len(batch) == 0 {
+			c.write(&response{JSONRPC: "2.0", Error: errInvalidRequest, ID: json.RawMessage("null")})
+			return
+		}
+		if !c.acquire() {
+			c.serveBatch(ctx, batch, true)
+			return
+		}
+		go func() {
+			defer c.release()
+			c.serveBatch(ctx, batch, false)
+		}()
+		return
+	}
+
+	var m message
+	if err := json.Unmarshal(p, &m); err != nil {
+		if !json.Valid(p) {
+			c.write(&response{JSONRPC: "2.0", Error: errParse, ID: json.RawMessage("null")})
+		} else {
+			c.write(&response{JSONRPC: "2.0", Error: errInvalidRequest, ID: json.RawMessage("null")})
+		}
+		return
+	}
+	if isResponse(&m) {
+		c.resolve(&m)
+		return
+	}
+	if !c.acquire() {
+		if m.ID != nil {
+			c.write(&response{JSONRPC: "2.0", Error: errServerBusy, ID: m.ID})
+		}
+		return
+	}
+	go func() {
+		defer c.release()
+		if r := c.serve(ctx, &m); r != nil {
+			c.write(r)
+		}
+	}()
+}
+
+// acquire takes a slot to serve a message, if one is available.
+func (c *Conn) acquire() bool {
+	select {
+	case c.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) release() { <-c.sem }
+
+// isResponse reports whether the message is a response.
+func isResponse(m *message) bool {
+	return m.Method == "" && (m.Result != nil || m.Error != nil)
+}
+
+// serveBatch serves the elements of a batch concurrently, as slots are
+// available, and writes the responses as one message. Responses to requests
+// of the remote peer's calls in the batch are resolved. If busy, the
+// requests of the batch are answered with server busy errors.
+func (c *Conn) serveBatch(ctx context.Context, batch []json.RawMessage, busy bool) {
+	responses := make([]*response, len(batch))
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		var m message
+		if err := json.Unmarshal(raw, &m); err != nil {
+			responses[i] = &response{JSONRPC: "2.0", Error: errInvalidRequest, ID: json.RawMessage("null")}
+			continue
+		}
+		if isResponse(&m) {
+			c.resolve(&m)
+			continue
+		}
+		switch {
+		case busy:
+			if m.ID != nil {
+				responses[i] = &response{JSONRPC: "2.0", Error: errServerBusy, ID: m.ID}
+			}
+		case c.acquire():
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer c.release()
+				responses[i] = c.serve(ctx, &m)
+			}()
+		default:
+			responses[i] = c.serve(ctx, &m)
+		}
+	}
+	wg.Wait()
+
+	var out []*response
+	for _, r := range responses {
+		if r != nil {
+			out = append(out, r)
+		}
+	}
+	if len(out) > 0 {
+		c.write(out)
+	}
+}
+
+// serve calls the handler for a request and returns the response, or nil for
+// a notification.
+func (c *Conn) serve(ctx context.Context, m *message) *response {
+	if m.JSONRPC != "2.0" || m.Method == "" || !validParams(m.Params) {
+		id := m.ID
+		if id == nil {
+			id = json.RawMessage("null")
+		}
+		return &response{JSONRPC: "2.0", Error: errInvalidRequest, ID: id}
+	}
+	req := &Request{Method: m.Method, Params: m.Params, ID: m.ID}
+	result, err := c.handler.ServeJSONRPC(ctx, c, req)
+	if req.IsNotification() {
+		return nil
+	}
+	r := &response{JSONRPC: "2.0", ID: m.ID}
+	if err != nil {
+		var e *Error
+		if !errors.As(err, &e) {
+			e = &Error{Code: CodeInternalError, Message: "Internal error", Data: errorData(err)}
+		}
+		r.Error = e
+		return r
+	}
+	p, err := json.Marshal(result)
+	if err != nil {
+		r.Error = &Error{Code: CodeInternalError, Message: "Internal error", Data: errorData(err)}
+		return r
+	}
+	r.Result = p
+	return r
+}
+
+// validParams reports whether the params are omitted, an array or an
+// object.
+func validParams(p json.RawMessage) bool {
+	p = bytes.TrimSpace(p)
+	return p == nil || len(p) > 0 && (p[0] == '[' || p[0] == '{')
+}
+
+func (c *Conn) write(v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteMessage(websocket.TextMessage, p)
+}
+
+// resolve delivers a response to the pending call with its ID.
+func (c *Conn) resolve(m *message) {
+	id := string(bytes.TrimSpace(m.ID))
+	c.mu.Lock()
+	ch := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if ch != nil {
+		ch <- m
+	}
+}
+
+// request returns a request with a new ID and registers the ID for the
+// response.
+func (c *Conn) request(method string, params interface{}) (*message, chan *message, error) {
+	m, err := newMessage(method, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return nil, nil, ErrClosed
+	}
+	c.seq++
+	m.ID = json.RawMessage(strconv.FormatUint(c.seq, 10))
+	ch := make(chan *message, 1)
+	c.pending[string(m.ID)] = ch
+	return m, ch, nil
+}
+
+func newMessage(method string, params interface{}) (*message, error) {
+	m := &message{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		if !validParams(p) {
+			return nil, fmt.Errorf("jsonrpc: params of %q must be an array or an object", method)
+		}
+		m.Params = p
+	}
+	return m, nil
+}
+
+func (c *Conn) cancel(id json.RawMessage) {
+	c.mu.Lock()
+	delete(c.pending, string(id))
+	c.mu.Unlock()
+}
+
+// wait waits for the response to a call and decodes its result.
+func wait(ctx context.Context, ch chan *message, result interface{}) error {
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return ErrClosed
+		}
+		if r.Error != nil {
+			return r.Error
+		}
+		if result != nil {
+			return json.Unmarshal(r.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Call calls the method with the params, which must encode to a JSON array or
+// object or be nil, and decodes the result into the value pointed to by
+// result unless result is nil. An error response is returned as an *Error.
+func (c *Conn) Call(ctx context.Context, method string, params, result interface{}) error {
+	m, ch, err := c.request(method, params)
+	if err != nil {
+		return err
+	}
+	defer c.cancel(m.ID)
+	if err := c.write(m); err != nil {
+		return err
+	}
+	return wait(ctx, ch, result)
+}
+
+// Notify sends a notification, a request without response.
+func (c *Conn) Notify(ctx context.Context, method string, params interface{}) error {
+	m, err := newMessage(method, params)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.write(m)
+}
+
+// BatchElem is an element of a batch call.
+type BatchElem struct {
+	Method string
+	Params interface{}
+
+	// Notification specifies that the element is a notification, which
+	// gets no response.
+	Notification bool
+
+	// Result is where the result of the call is decoded, if not nil.
+	Result interface{}
+
+	// Error is set to the error of the call.
+	Error error
+}
+
+// Batch sends the elements as a batch and waits for the responses. The
+// errors of the individual calls are stored in the elements; Batch returns
+// an error only if the batch could not be sent, or if ctx is done before
+// all responses are received.
+func (c *Conn) Batch(ctx context.Context, b []BatchElem) error {
+	msgs := make([]*message, len(b))
+	chans := make([]chan *message, len(b))
+	for i := range b {
+		var err error
+		if b[i].Notification {
+			msgs[i], err = newMessage(b[i].Method, b[i].Params)
+		} else {
+			msgs[i], chans[i], err = c.request(b[i].Method, b[i].Params)
+		}
+		if err != nil {
+			for _, m := range msgs[:i] {
+				if m.ID != nil {
+					c.cancel(m.ID)
+				}
+			}
+			return err
+		}
+	}
+	defer func() {
+		for _, m := range msgs {
+			if m.ID != nil {
+				c.cancel(m.ID)
+			}
+		}
+	}()
+	if err := c.write(msgs); err != nil {
+		return err
+	}
+	for i := range b {
+		if chans[i] == nil {
+			continue
+		}
+		err := wait(ctx, chans[i], b[i].Result)
+		if errors.Is(err, ctx.Err()) && ctx.Err() != nil {
+			return err
+		}
+		b[i].Error = err
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// serve starts a server running a Conn with the handler for each connection
+// and returns the WebSocket URL.
+func serve(t *testing.T, h Handler) string {
+	t.Helper()
+	var u websocket.Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = NewConn(ws, h).Run(r.Context())
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ws.Close() })
+	return ws
+}
+
+func testMux(notified chan<- string) *Mux {
+	var m Mux
+	m.Handle("subtract", Func(func(ctx context.Context, p [2]int) (int, error) {
+		return p[0] - p[1], nil
+	}))
+	m.Handle("sum", Func(func(ctx context.Context, p []int) (int, error) {
+		n := 0
+		for _, v := range p {
+			n += v
+		}
+		return n, nil
+	}))
+	m.HandleFunc("notify_hello", func(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+		if notified != nil {
+			notified <- string(req.Params)
+		}
+		return nil, nil
+	})
+	m.HandleFunc("fail", func(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	m.HandleFunc("callback", func(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+		var name string
+		err := c.Call(ctx, "name", nil, &name)
+		return "hello " + name, err
+	})
+	return &m
+}
+
+// TestSpecExamples checks the examples of the specification.
+func TestSpecExamples(t *testing.T) {
+	ws := dial(t, serve(t, testMux(nil)))
+	for _, tc := range []struct{ request, response string }{
+		{`{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": 1}`,
+			`{"jsonrpc":"2.0","result":19,"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "foobar", "id": "1"}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"1"}`},
+		{`{"jsonrpc": "2.0", "method": "foobar, "params": "bar", "baz]`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`{"jsonrpc": "2.0", "method": 1, "params": "bar"}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[{"jsonrpc": "2.0", "method": "sum", "params": [1,2,4], "id": "1"},{"jsonrpc": "2.0", "method"]`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[1,2]`,
+			`[{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`},
+		{`[
+			{"jsonrpc": "2.0", "method": "sum", "params": [1,2,4], "id": "1"},
+			{"jsonrpc": "2.0", "method": "notify_hello", "params": [7]},
+			{"jsonrpc": "2.0", "method": "subtract", "params": [42,23], "id": "2"},
+			{"foo": "boo"},
+			{"jsonrpc": "2.0", "method": "foo.get", "params": {"name": "myself"}, "id": "5"}
+		]`,
+			`[{"jsonrpc":"2.0","result":7,"id":"1"},{"jsonrpc":"2.0","result":19,"id":"2"},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"5"}]`},
+		{`{"jsonrpc": "2.0", "method": "subtract", "params": {"a": 1}, "id": 3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"json: cannot unmarshal object into Go value of type [2]int"},"id":3}`},
+	} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(tc.request)); err != nil {
+			t.Fatal(err)
+		}
+		_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, p, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != tc.response {
+			t.Errorf("request %s\ngot  %s\nwant %s", tc.request, p, tc.response)
+		}
+	}
+
+	// A batch of notifications gets no response.
+	_ = ws.WriteMessage(websocket.TextMessage, []byte(`[{"jsonrpc":"2.0","method":"notify_hello"}]`))
+	_ = ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"subtract","params":[1,1],"id":9}`))
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, p, err := ws.ReadMessage(); err != nil || string(p) != `{"jsonrpc":"2.0","result":0,"id":9}` {
+		t.Fatalf("got %s, %v", p, err)
+	}
+}
+
+func TestConn(t *testing.T) {
+	notified := make(chan string, 1)
+	ws := dial(t, serve(t, testMux(notified)))
+	var client Mux
+	client.Handle("name", Func(func(ctx context.Context, _ struct{}) (string, error) {
+		return "client", nil
+	}))
+	c := NewConn(ws, &client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	var n int
+	if err := c.Call(ctx, "subtract", []int{5, 3}, &n); err != nil || n != 2 {
+		t.Fatalf("subtract = %d, %v", n, err)
+	}
+	var e *Error
+	if err := c.Call(ctx, "nope", nil, nil); !errors.As(err, &e) || e.Code != CodeMethodNotFound {
+		t.Fatalf("nope returned %v", err)
+	}
+	if err := c.Call(ctx, "fail", nil, nil); !errors.As(err, &e) || e.Code != CodeInternalError || string(e.Data) != `"boom"` {
+		t.Fatalf("fail returned %v", err)
+	}
+	if err := c.Call(ctx, "subtract", 1, nil); err == nil {
+		t.Fatal("no error for scalar params")
+	}
+
+	// The server calls back the client while serving a request.
+	var greeting string
+	if err := c.Call(ctx, "callback", nil, &greeting); err != nil || greeting != "hello client" {
+		t.Fatalf("callback = %q, %v", greeting, err)
+	}
+
+	if err := c.Notify(ctx, "notify_hello", map[string]int{"x": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-notified; p != `{"x":1}` {
+		t.Fatalf("notification params %s", p)
+	}
+
+	var a, b int
+	batch := []BatchElem{
+		{Method: "sum", Params: []int{1, 2, 3}, Result: &a},
+		{Method: "notify_hello", Params: []int{}, Notification: true},
+		{Method: "nope"},
+		{Method: "subtract", Params: []int{10, 4}, Result: &b},
+	}
+	if err := c.Batch(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	if a != 6 || b != 6 || batch[0].Error != nil || !errors.As(batch[2].Error, &e) || e.Code != CodeMethodNotFound {
+		t.Fatalf("batch results %d %d, errors %v", a, b, batch)
+	}
+	<-notified
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Run returned %v", err)
+	}
+	if err := c.Call(context.Background(), "subtract", []int{1, 1}, nil); err != ErrClosed {
+		t.Fatalf("Call after Run returned %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestCallAbortedByClose(t *testing.T) {
+	var m Mux
+	m.HandleFunc("hang", func(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+		_ = c.Close()
+		return nil, nil
+	})
+	c := NewConn(dial(t, serve(t, &m)), nil)
+	go func() { _ = c.Run(context.Background()) }()
+	if err := c.Call(context.Background(), "hang", nil, nil); err != ErrClosed {
+		t.Fatalf("Call returned %v, want %v", err, ErrClosed)
+	}
+	var raw json.RawMessage
+	if err := c.Call(context.Background(), "hang", nil, &raw); err != ErrClosed {
+		t.Fatalf("Call after close returned %v", err)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	started := make(chan struct{}, 2)
+	unblock := make(chan struct{})
+	m := testMux(nil)
+	m.HandleFunc("block", func(ctx context.Context, c *Conn, req *Request) (interface{}, error) {
+		started <- struct{}{}
+		<-unblock
+		return "done", nil
+	})
+	var u websocket.Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := NewConn(ws, m)
+		c.MaxConcurrent = 2
+		_ = c.Run(r.Context())
+	}))
+	defer s.Close()
+	ws := dial(t, "ws"+strings.TrimPrefix(s.URL, "http"))
+
+	send := func(request string) {
+		t.Helper()
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want string) {
+		t.Helper()
+		_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+		if _, p, err := ws.ReadMessage(); err != nil || string(p) != want {
+			t.Fatalf("got %s, %v, want %s", p, err, want)
+		}
+	}
+	send(`{"jsonrpc":"2.0","method":"block","id":1}`)
+	send(`{"jsonrpc":"2.0","method":"block","id":2}`)
+	<-started
+	<-started
+
+	// Over the limit, requests are answered with server busy errors and
+	// notifications are dropped.
+	send(`{"jsonrpc":"2.0","method":"sum","params":[1],"id":3}`)
+	expect(`{"jsonrpc":"2.0","error":{"code":-32000,"message":"Server busy"},"id":3}`)
+	send(`[{"jsonrpc":"2.0","method":"sum","params":[1],"id":4},{"jsonrpc":"2.0","method":"notify_hello"}]`)
+	expect(`[{"jsonrpc":"2.0","error":{"code":-32000,"message":"Server busy"},"id":4}]`)
+
+	close(unblock)
+	var done []string
+	for i := 0; i < 2; i++ {
+		_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+		_, p, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = append(done, string(p))
+	}
+	if done[0] > done[1] {
+		done[0], done[1] = done[1], done[0]
+	}
+	if want := []string{`{"jsonrpc":"2.0","result":"done","id":1}`, `{"jsonrpc":"2.0","result":"done","id":2}`}; done[0] != want[0] || done[1] != want[1] {
+		t.Fatalf("got %s, want %s", done, want)
+	}
+
+	// A batch larger than the limit is served in full.
+	send(`[{"jsonrpc":"2.0","method":"sum","params":[1],"id":5},{"jsonrpc":"2.0","method":"sum","params":[2],"id":6},{"jsonrpc":"2.0","method":"sum","params":[3],"id":7}]`)
+	expect(`[{"jsonrpc":"2.0","result":1,"id":5},{"jsonrpc":"2.0","result":2,"id":6},{"jsonrpc":"2.0","result":3,"id":7}]`)
+}
@@ -0,0 +1,505 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema is a Validator validating JSON documents against a JSON Schema.
+//
+// JSONSchema supports the validation keywords of JSON Schema draft 2020-12
+// that apply to event payloads: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, uniqueItems, minLength,
+// maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// multipleOf, allOf, anyOf, oneOf, not and $ref to the definitions of the
+// schema, such as "#/$defs/user". Other keywords are ignored. Patterns use
+// the syntax of the regexp package.
+type JSONSchema struct {
+	root *schemaNode
+}
+
+var _ Validator = (*JSONSchema)(nil)
+
+// schemaNode is a compiled schema or subschema.
+type schemaNode struct {
+	boolean *bool // set for the schemas true and false
+
+	types      []string
+	enum       []any
+	hasConst   bool
+	constant   any
+	properties map[string]*schemaNode
+	required   []string
+	additional *schemaNode
+	items      *schemaNode
+
+	minItems, maxItems   int // -1 if unset
+	uniqueItems          bool
+	minLength, maxLength int // -1 if unset
+	pattern              *regexp.Regexp
+
+	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf *float64
+
+	allOf, anyOf, oneOf []*schemaNode
+	not                 *schemaNode
+	ref                 *schemaNode
+}
+
+// schemaCompiler compiles a schema document, resolving the references.
+type schemaCompiler struct {
+	doc  any
+	refs map[string]*schemaNode
+}
+
+// CompileJSONSchema parses a JSON Schema and returns a validator for it.
+func CompileJSONSchema(schema []byte) (*JSONSchema, error) {
+	doc, err := decodeJSON(schema)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: parsing JSON schema: %w", err)
+	}
+	sc := &schemaCompiler{doc: doc, refs: make(map[string]*schemaNode)}
+	root, err := sc.compile(doc, "#")
+	if err != nil {
+		return nil, fmt.Errorf("websocket: compiling JSON schema: %w", err)
+	}
+	return &JSONSchema{root: root}, nil
+}
+
+// MustCompileJSONSchema is like CompileJSONSchema but panics if the schema
+// cannot be compiled. It simplifies the initialization of global variables
+// holding schemas.
+func MustCompileJSONSchema(schema string) *JSONSchema {
+	s, err := CompileJSONSchema([]byte(schema))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Validate implements Validator. Empty data is validated as null.
+func (s *JSONSchema) Validate(data []byte) error {
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	return s.root.validate(v, "")
+}
+
+func (sc *schemaCompiler) compile(v any, loc string) (*schemaNode, error) {
+	if b, ok := v.(bool); ok {
+		return &schemaNode{boolean: &b}, nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", loc)
+	}
+	n := &schemaNode{minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+	var err error
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		n.types = []string{t}
+	case []any:
+		for _, e := range t {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: invalid type %v", loc, e)
+			}
+			n.types = append(n.types, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: invalid type %v", loc, t)
+	}
+	if e, ok := m["enum"]; ok {
+		if n.enum, ok = e.([]any); !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", loc)
+		}
+	}
+	n.constant, n.hasConst = m["const"]
+	if p, ok := m["properties"].(map[string]any); ok {
+		n.properties = make(map[string]*schemaNode, len(p))
+		for k, ps := range p {
+			if n.properties[k], err = sc.compile(ps, loc+"/properties/"+escapePointer(k)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r, ok := m["required"].([]any); ok {
+		for _, e := range r {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: invalid property %v", loc, e)
+			}
+			n.required = append(n.required, s)
+		}
+	}
+	if a, ok := m["additionalProperties"]; ok {
+		if n.additional, err = sc.compile(a, loc+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if i, ok := m["items"]; ok {
+		if n.items, err = sc.compile(i, loc+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	for _, kw := range []struct {
+		name string
+		dst  *int
+	}{
+		{"minItems", &n.minItems},
+		{"maxItems", &n.maxItems},
+		{"minLength", &n.minLength},
+		{"maxLength", &n.maxLength},
+	} {
+		if x, ok := m[kw.name]; ok {
+			f, ok := schemaNumber(x)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fmt.Errorf("%s/%s: must be a non-negative integer", loc, kw.name)
+			}
+			*kw.dst = int(f)
+		}
+	}
+	n.uniqueItems, _ = m["uniqueItems"].(bool)
+	if p, ok := m["pattern"]; ok {
+		s, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", loc)
+		}
+		if n.pattern, err = regexp.Compile(s); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", loc, err)
+		}
+	}
+	for _, kw := range []struct {
+		name string
+		dst  **float64
+	}{
+		{"minimum", &n.minimum},
+		{"maximum", &n.maximum},
+		{"exclusiveMinimum", &n.exclusiveMinimum},
+		{"exclusiveMaximum", &n.exclusiveMaximum},
+		{"multipleOf", &n.multipleOf},
+	} {
+		if x, ok := m[kw.name]; ok {
+			f, ok := schemaNumber(x)
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: must be a number", loc, kw.name)
+			}
+			*kw.dst = &f
+		}
+	}
+	if n.multipleOf != nil && *n.multipleOf <= 0 {
+		return nil, fmt.Errorf("%s/multipleOf: must be positive", loc)
+	}
+	for _, kw := range []struct {
+		name string
+		dst  *[]*schemaNode
+	}{
+		{"allOf", &n.allOf},
+		{"anyOf", &n.anyOf},
+		{"oneOf", &n.oneOf},
+	} {
+		x, ok := m[kw.name]
+		if !ok {
+			continue
+		}
+		list, ok := x.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty array", loc, kw.name)
+		}
+		for i, e := range list {
+			s, err := sc.compile(e, loc+"/"+kw.name+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			*kw.dst = append(*kw.dst, s)
+		}
+	}
+	if x, ok := m["not"]; ok {
+		if n.not, err = sc.compile(x, loc+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	if r, ok := m["$ref"]; ok {
+		s, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/$ref: must be a string", loc)
+		}
+		if n.ref, err = sc.resolve(s); err != nil {
+			return nil, fmt.Errorf("%s/$ref: %w", loc, err)
+		}
+	}
+	return n, nil
+}
+
+// resolve returns the schema referenced by ref, a JSON Pointer fragment
+// into the schema document.
+func (sc *schemaCompiler) resolve(ref string) (*schemaNode, error) {
+	if n, ok := sc.refs[ref]; ok {
+		return n, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %q", ref)
+	}
+	tokens, err := pointerTokens(ref[1:])
+	if err != nil {
+		return nil, err
+	}
+	v, err := pointerGet(sc.doc, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("reference %q not found", ref)
+	}
+	// Register the node before compiling it, so that recursive references
+	// resolve to it.
+	n := new(schemaNode)
+	sc.refs[ref] = n
+	compiled, err := sc.compile(v, ref)
+	if err != nil {
+		return nil, err
+	}
+	*n = *compiled
+	return n, nil
+}
+
+func schemaNumber(v any) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// schemaType returns the JSON Schema type of a decoded value.
+func schemaType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// validate validates v, found at the JSON Pointer path of the document.
+func (n *schemaNode) validate(v any, path string) error {
+	if n.boolean != nil {
+		if !*n.boolean {
+			return schemaError(path, "no value is allowed")
+		}
+		return nil
+	}
+	if n.ref != nil {
+		if err := n.ref.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if len(n.types) > 0 {
+		t := schemaType(v)
+		ok := false
+		for _, want := range n.types {
+			if want == t || (want == "number" && t == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return schemaError(path, fmt.Sprintf("got %s, want %s", t, strings.Join(n.types, " or ")))
+		}
+	}
+	if n.enum != nil {
+		ok := false
+		for _, e := range n.enum {
+			if jsonValuesEqual(v, e) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return schemaError(path, "value is not one of the enumerated values")
+		}
+	}
+	if n.hasConst && !jsonValuesEqual(v, n.constant) {
+		return schemaError(path, "value does not match the constant")
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, k := range n.required {
+			if _, ok := v[k]; !ok {
+				return schemaError(path, fmt.Sprintf("missing property %q", k))
+			}
+		}
+		for _, k := range sortedKeys(v) {
+			p := path + "/" + escapePointer(k)
+			if s, ok := n.properties[k]; ok {
+				if err := s.validate(v[k], p); err != nil {
+					return err
+				}
+			} else if n.additional != nil {
+				if n.additional.boolean != nil && !*n.additional.boolean {
+					return schemaError(path, fmt.Sprintf("property %q is not allowed", k))
+				}
+				if err := n.additional.validate(v[k], p); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if n.minItems >= 0 && len(v) < n.minItems {
+			return schemaError(path, fmt.Sprintf("got %d items, want at least %d", len(v), n.minItems))
+		}
+		if n.maxItems >= 0 && len(v) > n.maxItems {
+			return schemaError(path, fmt.Sprintf("got %d items, want at most %d", len(v), n.maxItems))
+		}
+		if n.uniqueItems {
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if jsonValuesEqual(v[i], v[j]) {
+						return schemaError(path, fmt.Sprintf("items %d and %d are equal", j, i))
+					}
+				}
+			}
+		}
+		if n.items != nil {
+			for i, e := range v {
+				if err := n.items.validate(e, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if n.minLength >= 0 || n.maxLength >= 0 {
+			l := utf8.RuneCountInString(v)
+			if n.minLength >= 0 && l < n.minLength {
+				return schemaError(path, fmt.Sprintf("got length %d, want at least %d", l, n.minLength))
+			}
+			if n.maxLength >= 0 && l > n.maxLength {
+				return schemaError(path, fmt.Sprintf("got length %d, want at most %d", l, n.maxLength))
+			}
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			return schemaError(path, fmt.Sprintf("does not match pattern %q", n.pattern))
+		}
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return schemaError(path, err.Error())
+		}
+		switch {
+		case n.minimum != nil && f < *n.minimum:
+			return schemaError(path, fmt.Sprintf("got %v, want at least %v", v, *n.minimum))
+		case n.maximum != nil && f > *n.maximum:
+			return schemaError(path, fmt.Sprintf("got %v, want at most %v", v, *n.maximum))
+		case n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum:
+			return schemaError(path, fmt.Sprintf("got %v, want more than %v", v, *n.exclusiveMinimum))
+		case n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum:
+			return schemaError(path, fmt.Sprintf("got %v, want less than %v", v, *n.exclusiveMaximum))
+		}
+		if n.multipleOf != nil {
+			if q := f / *n.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				return schemaError(path, fmt.Sprintf("%v is not a multiple of %v", v, *n.multipleOf))
+			}
+		}
+	}
+
+	for _, s := range n.allOf {
+		if err := s.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if n.anyOf != nil {
+		ok := false
+		for _, s := range n.anyOf {
+			if s.validate(v, path) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return schemaError(path, "value matches none of the schemas of anyOf")
+		}
+	}
+	if n.oneOf != nil {
+		matches := 0
+		for _, s := range n.oneOf {
+			if s.validate(v, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return schemaError(path, fmt.Sprintf("value matches %d schemas of oneOf, want 1", matches))
+		}
+	}
+	if n.not != nil && n.not.validate(v, path) == nil {
+		return schemaError(path, "value matches the schema of not")
+	}
+	return nil
+}
+
+func schemaError(path, msg string) error {
+	if path == "" {
+		path = "/"
+	}
+	return errors.New(path + ": " + msg)
+}
+
+// jsonValuesEqual reports whether two decoded values are equal as JSON
+// values: numbers are compared by value.
+func jsonValuesEqual(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fa, erra := a.Float64()
+		fb, errb := b.Float64()
+		return erra == nil && errb == nil && fa == fb
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonValuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			bv, ok := b[k]
+			if !ok || !jsonValuesEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
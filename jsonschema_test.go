@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"$defs": {
+		"node": {
+			"type": "object",
+			"properties": {
+				"name": {"type": "string"},
+				"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}
+			},
+			"required": ["name"]
+		}
+	},
+	"type": "object",
+	"properties": {
+		"text": {"type": "string", "minLength": 1, "maxLength": 5, "pattern": "^[a-z]+$"},
+		"count": {"type": "integer", "minimum": 1, "exclusiveMaximum": 10, "multipleOf": 2},
+		"kind": {"enum": ["a", "b", 3]},
+		"version": {"const": 2},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2, "uniqueItems": true},
+		"tree": {"$ref": "#/$defs/node"},
+		"id": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+		"either": {"anyOf": [{"type": "null"}, {"type": "boolean"}]},
+		"other": {"not": {"type": "string"}, "allOf": [{"type": ["number", "array", "string"]}]}
+	},
+	"required": ["text"],
+	"additionalProperties": false
+}`
+
+func TestJSONSchema(t *testing.T) {
+	s := MustCompileJSONSchema(testSchema)
+	for _, doc := range []string{
+		`{"text":"hi"}`,
+		`{"text":"hi","count":4,"kind":3,"version":2.0,"tags":["x","y"]}`,
+		`{"text":"hi","tree":{"name":"root","children":[{"name":"leaf","children":[]}]}}`,
+		`{"text":"hi","id":"7","either":null,"other":1.5}`,
+		`{"text":"hi","id":7,"either":true,"other":[]}`,
+	} {
+		if err := s.Validate([]byte(doc)); err != nil {
+			t.Errorf("Validate(%s) returned %v", doc, err)
+		}
+	}
+	for _, tt := range []struct {
+		doc, err string
+	}{
+		{`[]`, "/: got array, want object"},
+		{``, "/: got null, want object"},
+		{`{}`, `missing property "text"`},
+		{`{"text":""}`, "/text: got length 0"},
+		{`{"text":"abcdef"}`, "/text: got length 6"},
+		{`{"text":"A"}`, "/text: does not match pattern"},
+		{`{"text":"a","count":1.5}`, "/count: got number, want integer"},
+		{`{"text":"a","count":0}`, "/count: got 0, want at least 1"},
+		{`{"text":"a","count":10}`, "/count: got 10, want less than 10"},
+		{`{"text":"a","count":3}`, "/count: 3 is not a multiple of 2"},
+		{`{"text":"a","kind":"c"}`, "/kind: value is not one of"},
+		{`{"text":"a","version":3}`, "/version: value does not match"},
+		{`{"text":"a","tags":["x","x"]}`, "/tags: items 0 and 1 are equal"},
+		{`{"text":"a","tags":["x","y","z"]}`, "/tags: got 3 items"},
+		{`{"text":"a","tags":[1]}`, "/tags/0: got integer, want string"},
+		{`{"text":"a","tree":{"name":"r","children":[{}]}}`, `/tree/children/0: missing property "name"`},
+		{`{"text":"a","id":true}`, "/id: value matches 0 schemas of oneOf"},
+		{`{"text":"a","either":1}`, "/either: value matches none"},
+		{`{"text":"a","other":"s"}`, "/other: value matches the schema of not"},
+		{`{"text":"a","extra":1}`, `/: property "extra" is not allowed`},
+	} {
+		err := s.Validate([]byte(tt.doc))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Validate(%s) returned %v, want %q", tt.doc, err, tt.err)
+		}
+	}
+}
+
+func TestCompileJSONSchemaError(t *testing.T) {
+	for _, schema := range []string{
+		`"string"`,
+		`{"type": 1}`,
+		`{"pattern": "("}`,
+		`{"minLength": -1}`,
+		`{"anyOf": []}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"$ref": "other.json"}`,
+		`{"multipleOf": 0}`,
+	} {
+		if _, err := CompileJSONSchema([]byte(schema)); err == nil {
+			t.Errorf("CompileJSONSchema(%s) returned no error", schema)
+		}
+	}
+	if err := MustCompileJSONSchema(`false`).Validate([]byte(`1`)); err == nil {
+		t.Error("schema false accepted a value")
+	}
+}
@@ -0,0 +1,569 @@
+// Package jwtauth authenticates websocket connections with JSON Web Tokens.
+// An Authenticator validates the token of the opening handshake, attaches
+// its claims to the connection and keeps long-lived connections
+// authenticated: the client refreshes the token over the socket before it
+// expires, and the Authenticator closes or restricts connections whose token
+// expired.
+//
+//	auth := &jwtauth.Authenticator{Key: secret, OnAuthExpired: jwtauth.ExpiredRestrict}
+//	upgrader := websocket.Upgrader{Authenticate: auth.Authenticate}
+//
+//	c, err := upgrader.Upgrade(w, r, nil)
+//	if err != nil {
+//		return
+//	}
+//	auth.Watch(c)
+//	user := jwtauth.ClaimsOf(c).Subject()
+//
+// The client refreshes the token by sending the event
+//
+//	{"event":"auth.refresh","data":{"token":"<new token>"}}
+//
+// and receives an "auth.refreshed" event, or an "auth.error" event with the
+// reason the token was rejected.
+package jwtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+// Events exchanged with the client for the in-band token refresh.
+const (
+	// EventRefresh is sent by the client with a new token in the "token"
+	// field of the data.
+	EventRefresh = "auth.refresh"
+
+	// EventRefreshed acknowledges a refresh, with the new expiry in the
+	// "exp" field of the data.
+	EventRefreshed = "auth.refreshed"
+
+	// EventError rejects a refresh, with the reason in the "error" field of
+	// the data.
+	EventError = "auth.error"
+
+	// EventExpiring is sent Authenticator.Notice before the token expires.
+	EventExpiring = "auth.expiring"
+
+	// EventExpired is sent when the token of a restricted connection
+	// expired.
+	EventExpired = "auth.expired"
+)
+
+var (
+	// ErrNoToken is returned when the request carries no token.
+	ErrNoToken = errors.New("jwtauth: no token")
+
+	// ErrInvalidToken is returned when a token is malformed, its signature
+	// is invalid or its claims do not match the Authenticator.
+	ErrInvalidToken = errors.New("jwtauth: invalid token")
+
+	// ErrTokenExpired is returned when a token has expired.
+	ErrTokenExpired = errors.New("jwtauth: token expired")
+
+	// ErrSubjectMismatch is returned when a refreshed token is issued to
+	// another subject than the token of the connection.
+	ErrSubjectMismatch = errors.New("jwtauth: token subject mismatch")
+
+	// ErrNotWatched is returned by Refresh for connections not watched by
+	// the Authenticator.
+	ErrNotWatched = errors.New("jwtauth: connection not watched")
+)
+
+// ExpiryPolicy specifies what happens to a connection whose token expired.
+type ExpiryPolicy int
+
+const (
+	// ExpiredClose closes the connection.
+	ExpiredClose ExpiryPolicy = iota
+
+	// ExpiredRestrict keeps the connection open and drops the messages
+	// received until the client refreshes the token. The application can
+	// check Expired before sending to the connection.
+	ExpiredRestrict
+)
+
+// Claims are the claims of a token.
+type Claims map[string]interface{}
+
+// Subject returns the "sub" claim.
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// Issuer returns the "iss" claim.
+func (c Claims) Issuer() string {
+	s, _ := c["iss"].(string)
+	return s
+}
+
+// Audience returns the "aud" claim, a string or a list of strings.
+func (c Claims) Audience() []string {
+	switch v := c["aud"].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		aud := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+		return aud
+	}
+	return nil
+}
+
+// ExpiresAt returns the "exp" claim, or the zero time if the token does not
+// expire.
+func (c Claims) ExpiresAt() time.Time {
+	return c.time("exp")
+}
+
+func (c Claims) time(name string) time.Time {
+	if v, ok := c[name].(float64); ok {
+		return time.Unix(int64(v), 0)
+	}
+	return time.Time{}
+}
+
+// Authenticator validates tokens and enforces their expiry on connections.
+// The tokens are signed with HMAC (HS256, HS384, HS512), RSA PKCS #1 v1.5
+// (RS256, RS384, RS512) or ECDSA (ES256, ES384, ES512).
+type Authenticator struct {
+	// Key verifies the signatures: a []byte secret for HMAC, an
+	// *rsa.PublicKey or an *ecdsa.PublicKey. The algorithm of a token must
+	// match the type of the key.
+	Key interface{}
+
+	// Keyfunc, if not nil, returns the key for the algorithm and key ID
+	// ("kid" header) of a token, for key rotation. Keyfunc takes precedence
+	// over Key.
+	Keyfunc func(alg, kid string) (interface{}, error)
+
+	// Issuer, if not empty, is the required "iss" claim.
+	Issuer string
+
+	// Audience, if not empty, must be one of the "aud" claims.
+	Audience string
+
+	// Leeway is the clock skew tolerated when checking the expiry and the
+	// "nbf" claim.
+	Leeway time.Duration
+
+	// CookieName and QueryParam name the cookie and the query parameter
+	// holding the token of browser clients, which cannot set the
+	// Authorization header. See websocket.RequestToken.
+	CookieName string
+	QueryParam string
+
+	// OnAuthExpired specifies what happens to a watched connection whose
+	// token expired.
+	OnAuthExpired ExpiryPolicy
+
+	// Grace, if positive, is how long a restricted connection stays open
+	// without a refresh before it is closed.
+	Grace time.Duration
+
+	// Notice, if positive, is how long before the token expires the
+	// EventExpiring event is sent to the client.
+	Notice time.Duration
+
+	// CloseCode and CloseReason are sent when a connection is closed for an
+	// expired token. If zero, a default of 1008 (policy violation) is used.
+	// If empty, a default reason of "token expired" is used.
+	CloseCode   int
+	CloseReason string
+
+	// now returns the current time; tests replace it.
+	now func() time.Time
+}
+
+func (a *Authenticator) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// Authenticate validates the token of a handshake request and returns its
+// Claims. Authenticate is an Upgrader.Authenticate function; the errors are
+// *websocket.AuthError with a WWW-Authenticate header.
+func (a *Authenticator) Authenticate(r *http.Request) (interface{}, error) {
+	return a.authenticate(websocket.RequestToken(r, a.CookieName, a.QueryParam))
+}
+
+// AuthenticateFastHTTP is like Authenticate for a FastHTTPUpgrader.
+func (a *Authenticator) AuthenticateFastHTTP(ctx *fasthttp.RequestCtx) (interface{}, error) {
+	return a.authenticate(websocket.FastHTTPRequestToken(ctx, a.CookieName, a.QueryParam))
+}
+
+func (a *Authenticator) authenticate(token string) (interface{}, error) {
+	if token == "" {
+		return nil, &websocket.AuthError{Header: http.Header{"Www-Authenticate": {"Bearer"}}, Err: ErrNoToken}
+	}
+	claims, err := a.Parse(token)
+	if err != nil {
+		return nil, &websocket.AuthError{Header: http.Header{"Www-Authenticate": {`Bearer error="invalid_token"`}}, Err: err}
+	}
+	return claims, nil
+}
+
+// Parse verifies the signature and the claims of a token and returns the
+// claims. Parse returns ErrInvalidToken or ErrTokenExpired.
+func (a *Authenticator) Parse(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	key := a.Key
+	if a.Keyfunc != nil {
+		var err error
+		if key, err = a.Keyfunc(header.Alg, header.Kid); err != nil {
+			return nil, ErrInvalidToken
+		}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !verify(header.Alg, key, parts[0]+"."+parts[1], sig) {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, ErrInvalidToken
+	}
+	if err := a.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// check checks the registered claims.
+func (a *Authenticator) check(claims Claims) error {
+	now := a.clock()
+	if exp := claims.ExpiresAt(); !exp.IsZero() && !now.Before(exp.Add(a.Leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf := claims.time("nbf"); !nbf.IsZero() && now.Add(a.Leeway).Before(nbf) {
+		return ErrInvalidToken
+	}
+	if a.Issuer != "" && claims.Issuer() != a.Issuer {
+		return ErrInvalidToken
+	}
+	if a.Audience != "" {
+		for _, aud := range claims.Audience() {
+			if aud == a.Audience {
+				return nil
+			}
+		}
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	p, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// verify verifies the signature of the signed input with the algorithm.
+func verify(alg string, key interface{}, input string, sig []byte) bool {
+	var h crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	default:
+		return false
+	}
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(alg, "HS") {
+			return false
+		}
+		m := hmac.New(hashFunc(h), k)
+		m.Write([]byte(input))
+		return hmac.Equal(sig, m.Sum(nil))
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest(h, input), sig) == nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest(h, input), r, s)
+	}
+	return false
+}
+
+func hashFunc(h crypto.Hash) func() hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384
+	case crypto.SHA512:
+		return sha512.New
+	}
+	return sha256.New
+}
+
+func digest(h crypto.Hash, input string) []byte {
+	d := hashFunc(h)()
+	d.Write([]byte(input))
+	return d.Sum(nil)
+}
+
+const (
+	stateKey       = "jwtauth.state" // Conn metadata key of the watch state
+	writeQueueSize = 64              // of the write queues enabled by Watch
+)
+
+// state is the authentication state of a watched connection.
+type state struct {
+	mu      sync.Mutex
+	claims  Claims
+	expired bool
+	timer   *time.Timer
+	closed  bool
+}
+
+// ClaimsOf returns the claims of the connection's token: the claims of the
+// last refresh of a watched connection, or else the principal returned by
+// Authenticate.
+func ClaimsOf(c *websocket.Conn) Claims {
+	if st := stateOf(c); st != nil {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return st.claims
+	}
+	claims, _ := c.Value().(Claims)
+	return claims
+}
+
+// Expired reports whether the token of a watched connection expired without
+// a refresh.
+func Expired(c *websocket.Conn) bool {
+	st := stateOf(c)
+	if st == nil {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.expired
+}
+
+func stateOf(c *websocket.Conn) *state {
+	if v, ok := c.Get(stateKey); ok {
+		return v.(*state)
+	}
+	return nil
+}
+
+// Watch enforces the expiry of the token of a connection upgraded with
+// Authenticate and handles the refresh events of the client. The refresh
+// events are consumed by an inbound interceptor and are not returned by the
+// read methods. Watch does nothing if the connection is already watched or
+// was not authenticated by Authenticate.
+//
+// The events are written concurrently with the application, so Watch enables
+// the write queue of the connection unless it is enabled already, and must
+// be called before the connection is used for writing.
+func (a *Authenticator) Watch(c *websocket.Conn) {
+	claims, ok := c.Value().(Claims)
+	if !ok || stateOf(c) != nil {
+		return
+	}
+	_ = c.EnableWriteQueue(writeQueueSize, websocket.OverflowBlock)
+	st := &state{claims: claims}
+	c.Set(stateKey, st)
+	c.UseInbound(func(messageType int, data []byte) ([]byte, error) {
+		return a.intercept(c, st, messageType, data)
+	})
+	st.mu.Lock()
+	a.schedule(c, st)
+	st.mu.Unlock()
+	if done := c.Done(); done != nil {
+		go func() {
+			<-done
+			st.mu.Lock()
+			st.closed = true
+			if st.timer != nil {
+				st.timer.Stop()
+			}
+			st.mu.Unlock()
+		}()
+	}
+}
+
+// Refresh replaces the token of a watched connection, as the refresh events
+// of the client do. The token must be valid and issued to the subject of the
+// current token.
+func (a *Authenticator) Refresh(c *websocket.Conn, token string) (Claims, error) {
+	st := stateOf(c)
+	if st == nil {
+		return nil, ErrNotWatched
+	}
+	claims, err := a.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if claims.Subject() != st.claims.Subject() {
+		return nil, ErrSubjectMismatch
+	}
+	st.claims = claims
+	st.expired = false
+	c.SetValue(claims)
+	a.schedule(c, st)
+	return claims, nil
+}
+
+// schedule arms the timer for the next expiry step of the connection. The
+// caller must hold st.mu.
+func (a *Authenticator) schedule(c *websocket.Conn, st *state) {
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+	}
+	if st.closed {
+		return
+	}
+	exp := st.claims.ExpiresAt()
+	if exp.IsZero() {
+		return
+	}
+	now := a.clock()
+	exp = exp.Add(a.Leeway)
+	switch {
+	case st.expired:
+		if a.Grace > 0 {
+			st.timer = time.AfterFunc(a.Grace, func() { a.close(c, st) })
+		}
+	case a.Notice > 0 && now.Before(exp.Add(-a.Notice)):
+		st.timer = time.AfterFunc(exp.Add(-a.Notice).Sub(now), func() { a.notify(c, st, exp) })
+	default:
+		st.timer = time.AfterFunc(exp.Sub(now), func() { a.expire(c, st, exp) })
+	}
+}
+
+// notify sends the EventExpiring event for the token expiring at exp and
+// arms the expiry timer, unless the token was refreshed meanwhile.
+func (a *Authenticator) notify(c *websocket.Conn, st *state, exp time.Time) {
+	st.mu.Lock()
+	if st.closed || !st.claims.ExpiresAt().Add(a.Leeway).Equal(exp) {
+		st.mu.Unlock()
+		return
+	}
+	st.timer = time.AfterFunc(exp.Sub(a.clock()), func() { a.expire(c, st, exp) })
+	st.mu.Unlock()
+	_ = c.Emit(EventExpiring, map[string]int64{"exp": exp.Unix()})
+}
+
+// expire applies the expiry policy to a connection whose token expired at
+// exp, unless the token was refreshed meanwhile.
+func (a *Authenticator) expire(c *websocket.Conn, st *state, exp time.Time) {
+	st.mu.Lock()
+	if st.closed || st.expired || !st.claims.ExpiresAt().Add(a.Leeway).Equal(exp) {
+		st.mu.Unlock()
+		return
+	}
+	if a.OnAuthExpired != ExpiredRestrict {
+		st.mu.Unlock()
+		a.close(c, st)
+		return
+	}
+	st.expired = true
+	a.schedule(c, st)
+	st.mu.Unlock()
+	_ = c.Emit(EventExpired, nil)
+}
+
+// close closes a connection whose token expired, unless the token was
+// refreshed meanwhile.
+func (a *Authenticator) close(c *websocket.Conn, st *state) {
+	st.mu.Lock()
+	if st.closed || a.OnAuthExpired == ExpiredRestrict && !st.expired {
+		st.mu.Unlock()
+		return
+	}
+	st.mu.Unlock()
+	code, reason := a.CloseCode, a.CloseReason
+	if code == 0 {
+		code = websocket.ClosePolicyViolation
+	}
+	if reason == "" {
+		reason = "token expired"
+	}
+	_ = c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	_ = c.Close()
+}
+
+// refreshEvent is the quoted name of the refresh event, to spot refresh
+// events without decoding every message.
+var refreshEvent = []byte(`"` + EventRefresh + `"`)
+
+// intercept consumes the refresh events and drops the messages of a
+// restricted connection.
+func (a *Authenticator) intercept(c *websocket.Conn, st *state, messageType int, data []byte) ([]byte, error) {
+	if messageType == websocket.TextMessage && bytes.Contains(data, refreshEvent) {
+		var e struct {
+			Event string `json:"event"`
+			Data  struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Event == EventRefresh {
+			if claims, err := a.Refresh(c, e.Data.Token); err != nil {
+				_ = c.Emit(EventError, map[string]string{"error": err.Error()})
+			} else {
+				_ = c.Emit(EventRefreshed, map[string]int64{"exp": claims.ExpiresAt().Unix()})
+			}
+			return nil, websocket.ErrDropMessage
+		}
+	}
+	st.mu.Lock()
+	expired := st.expired
+	st.mu.Unlock()
+	if expired {
+		return nil, websocket.ErrDropMessage
+	}
+	return data, nil
+}
@@ -0,0 +1,247 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+var secret = []byte("secret")
+
+// sign returns a token with the claims signed by the signature function.
+func sign(t *testing.T, alg string, claims map[string]interface{}, sig func(input []byte) []byte) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	p, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig([]byte(input)))
+}
+
+func hs256(t *testing.T, claims map[string]interface{}) string {
+	return sign(t, "HS256", claims, func(input []byte) []byte {
+		m := hmac.New(sha256.New, secret)
+		m.Write(input)
+		return m.Sum(nil)
+	})
+}
+
+func TestParse(t *testing.T) {
+	a := &Authenticator{Key: secret, Issuer: "gfly", Audience: "chat"}
+	exp := time.Now().Add(time.Hour).Unix()
+	claims, err := a.Parse(hs256(t, map[string]interface{}{"sub": "alice", "iss": "gfly", "aud": []string{"api", "chat"}, "exp": exp}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject() != "alice" || claims.ExpiresAt().Unix() != exp {
+		t.Errorf("Parse returned %v", claims)
+	}
+
+	for name, tc := range map[string]struct {
+		token string
+		err   error
+	}{
+		"expired":      {hs256(t, map[string]interface{}{"iss": "gfly", "aud": "chat", "exp": time.Now().Add(-time.Minute).Unix()}), ErrTokenExpired},
+		"not before":   {hs256(t, map[string]interface{}{"iss": "gfly", "aud": "chat", "nbf": time.Now().Add(time.Minute).Unix()}), ErrInvalidToken},
+		"issuer":       {hs256(t, map[string]interface{}{"iss": "other", "aud": "chat"}), ErrInvalidToken},
+		"audience":     {hs256(t, map[string]interface{}{"iss": "gfly", "aud": "api"}), ErrInvalidToken},
+		"signature":    {hs256(t, map[string]interface{}{"iss": "gfly", "aud": "chat"}) + "x", ErrInvalidToken},
+		"malformed":    {"a.b", ErrInvalidToken},
+		"alg none":     {sign(t, "none", map[string]interface{}{"iss": "gfly", "aud": "chat"}, func([]byte) []byte { return nil }), ErrInvalidToken},
+		"alg mismatch": {sign(t, "RS256", map[string]interface{}{"iss": "gfly", "aud": "chat"}, func([]byte) []byte { return []byte("x") }), ErrInvalidToken},
+	} {
+		if _, err := a.Parse(tc.token); err != tc.err {
+			t.Errorf("%s: Parse returned %v, want %v", name, err, tc.err)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"sub": "bob"}
+	rs := sign(t, "RS256", claims, func(input []byte) []byte {
+		d := sha256.Sum256(input)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, d[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	})
+	es := sign(t, "ES256", claims, func(input []byte) []byte {
+		d := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, d[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	})
+
+	keys := map[string]interface{}{"RS256": &rsaKey.PublicKey, "ES256": &ecKey.PublicKey}
+	a := &Authenticator{Keyfunc: func(alg, kid string) (interface{}, error) {
+		if k, ok := keys[alg]; ok {
+			return k, nil
+		}
+		return nil, errors.New("unknown key")
+	}}
+	for _, token := range []string{rs, es} {
+		if claims, err := a.Parse(token); err != nil || claims.Subject() != "bob" {
+			t.Errorf("Parse returned %v, %v", claims, err)
+		}
+	}
+	if _, err := (&Authenticator{Key: secret}).Parse(rs); err != ErrInvalidToken {
+		t.Errorf("Parse with a secret of an RS256 token returned %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// serve returns the URL of a server echoing the messages of connections
+// watched by a.
+func serve(t *testing.T, a *Authenticator) string {
+	t.Helper()
+	u := websocket.Upgrader{Authenticate: a.Authenticate}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		a.Watch(c)
+		for {
+			_, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(websocket.TextMessage, []byte(ClaimsOf(c).Subject()+":"+string(p))); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func dial(t *testing.T, url, token string) *websocket.Conn {
+	t.Helper()
+	c, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func read(t *testing.T, c *websocket.Conn) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(p)
+}
+
+// expiringSoon returns a token for the subject and makes it expire in about
+// 50ms on the clock of a.
+func expiringSoon(t *testing.T, a *Authenticator, sub string) string {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	skew := time.Until(exp) - 50*time.Millisecond
+	a.now = func() time.Time { return time.Now().Add(skew) }
+	return hs256(t, map[string]interface{}{"sub": sub, "exp": exp.Unix()})
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := &Authenticator{Key: secret}
+	url := serve(t, a)
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("Www-Authenticate") != "Bearer" {
+		t.Fatalf("Dial without a token returned %v, %v, want status 401", resp, err)
+	}
+	c := dial(t, url, hs256(t, map[string]interface{}{"sub": "alice"}))
+	if err := c.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, c); got != "alice:hi" {
+		t.Fatalf("read %q, want alice:hi", got)
+	}
+}
+
+func TestExpiredClose(t *testing.T) {
+	a := &Authenticator{Key: secret}
+	c := dial(t, serve(t, a), expiringSoon(t, a, "alice"))
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := c.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "token expired" {
+		t.Fatalf("ReadMessage returned %v, want close 1008 token expired", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	a := &Authenticator{Key: secret, OnAuthExpired: ExpiredRestrict, Notice: 30 * time.Millisecond}
+	c := dial(t, serve(t, a), expiringSoon(t, a, "alice"))
+	if got := read(t, c); !strings.Contains(got, EventExpiring) {
+		t.Fatalf("read %q, want the expiring event", got)
+	}
+	if got := read(t, c); !strings.Contains(got, EventExpired) {
+		t.Fatalf("read %q, want the expired event", got)
+	}
+	// Dropped while restricted.
+	if err := c.WriteMessage(websocket.TextMessage, []byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(token string) string {
+		if err := c.WriteJSON(map[string]interface{}{"event": EventRefresh, "data": map[string]string{"token": token}}); err != nil {
+			t.Fatal(err)
+		}
+		return read(t, c)
+	}
+	if got := refresh(hs256(t, map[string]interface{}{"sub": "mallory"})); !strings.Contains(got, EventError) || !strings.Contains(got, "subject mismatch") {
+		t.Fatalf("refresh for another subject returned %q", got)
+	}
+	exp := time.Now().Add(2 * time.Hour).Unix()
+	if got := refresh(hs256(t, map[string]interface{}{"sub": "alice", "exp": exp, "role": "admin"})); !strings.Contains(got, EventRefreshed) {
+		t.Fatalf("refresh returned %q", got)
+	}
+	if err := c.WriteMessage(websocket.TextMessage, []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, c); got != "alice:back" {
+		t.Fatalf("read %q, want alice:back", got)
+	}
+}
+
+func TestRestrictGrace(t *testing.T) {
+	a := &Authenticator{Key: secret, OnAuthExpired: ExpiredRestrict, Grace: 20 * time.Millisecond, CloseCode: 4001}
+	c := dial(t, serve(t, a), expiringSoon(t, a, "alice"))
+	if got := read(t, c); !strings.Contains(got, EventExpired) {
+		t.Fatalf("read %q, want the expired event", got)
+	}
+	_, _, err := c.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 4001 {
+		t.Fatalf("ReadMessage returned %v, want close 4001", err)
+	}
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// errKeepaliveEnabled is returned when keepalive is enabled twice.
+var errKeepaliveEnabled = errors.New("websocket: keepalive already enabled")
+
+// keepalive sends pings on a connection and tracks the pongs sent in reply.
+type keepalive struct {
+	c        *Conn
+	interval time.Duration
+	timeout  time.Duration
+	pending  atomic.Int64 // send time of the outstanding ping in Unix nanoseconds
+	latency  atomic.Int64
+	pong     chan struct{}
+}
+
+// EnableKeepalive starts a goroutine that sends a ping to the peer every
+// interval and closes the connection if the peer does not reply with a pong
+// within timeout. The round trip time of the last ping is reported by
+// Latency.
+//
+// Pongs are processed by the read methods, so the application must read the
+// connection as described in the section on Control Messages in the package
+// documentation. Keepalive works alongside the handler set with
+// SetPongHandler. The goroutine exits when the connection is closed.
+//
+// EnableKeepalive must be called at most once for a connection.
+func (c *Conn) EnableKeepalive(interval, timeout time.Duration) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if c.keepalive != nil {
+		return errKeepaliveEnabled
+	}
+	if interval <= 0 || timeout <= 0 {
+		return errors.New("websocket: keepalive interval and timeout must be positive")
+	}
+	ka := &keepalive{
+		c:        c,
+		interval: interval,
+		timeout:  timeout,
+		pong:     make(chan struct{}, 1),
+	}
+	c.keepalive = ka
+	go ka.run()
+	return nil
+}
+
+// Latency returns the round trip time of the most recent keepalive ping, or
+// zero if keepalive is not enabled or no pong has been received.
+func (c *Conn) Latency() time.Duration {
+	if c == nil || c.keepalive == nil {
+		return 0
+	}
+	return time.Duration(c.keepalive.latency.Load())
+}
+
+func (ka *keepalive) run() {
+	ticker := time.NewTicker(ka.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ka.c.closed:
+			return
+		case <-ticker.C:
+		}
+
+		// Discard a late pong for the previous ping.
+		select {
+		case <-ka.pong:
+		default:
+		}
+
+		sent := time.Now()
+		var payload [8]byte
+		binary.BigEndian.PutUint64(payload[:], uint64(sent.UnixNano()))
+		ka.pending.Store(sent.UnixNano())
+		if err := ka.c.WriteControl(PingMessage, payload[:], sent.Add(ka.timeout)); err != nil {
+			if errors.Is(err, ErrWriteTimeout) {
+				ka.expire()
+			}
+			return
+		}
+
+		timer := time.NewTimer(ka.timeout)
+		select {
+		case <-ka.pong:
+			timer.Stop()
+		case <-ka.c.closed:
+			timer.Stop()
+			return
+		case <-timer.C:
+			ka.expire()
+			return
+		}
+	}
+}
+
+// receivePong is called by the read methods for every pong received.
+func (ka *keepalive) receivePong(payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+	sent := int64(binary.BigEndian.Uint64(payload))
+	if sent == 0 || !ka.pending.CompareAndSwap(sent, 0) {
+		// Not a reply to the outstanding ping.
+		return
+	}
+	rtt := time.Since(time.Unix(0, sent))
+	ka.latency.Store(int64(rtt))
+	if m := ka.c.metrics; m != nil {
+		m.PingRTT(rtt)
+	}
+	select {
+	case ka.pong <- struct{}{}:
+	default:
+	}
+}
+
+// expire closes a connection whose peer stopped replying to pings.
+func (ka *keepalive) expire() {
+	_ = ka.c.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, "keepalive timeout"), time.Now().Add(writeWait))
+	_ = ka.c.Close()
+}
@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	pongs := make(chan string, 10)
+	s.SetPongHandler(func(appData string) error {
+		pongs <- appData
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := s.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := s.EnableKeepalive(10*time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableKeepalive(10*time.Millisecond, time.Second); err != errKeepaliveEnabled {
+		t.Errorf("second EnableKeepalive returned %v, want %v", err, errKeepaliveEnabled)
+	}
+	select {
+	case <-pongs:
+	case <-time.After(time.Second):
+		t.Fatal("pong handler not called")
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.Latency() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s.Latency() <= 0 {
+		t.Errorf("Latency() = %v, want > 0", s.Latency())
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	s, c := newPipeConns()
+	defer c.Close()
+
+	// The client reads but never processes the ping because SetPingHandler
+	// discards it.
+	c.SetPingHandler(func(string) error { return nil })
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := s.ReadMessage()
+		readErr <- err
+	}()
+
+	if err := s.EnableKeepalive(10*time.Millisecond, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatal("ReadMessage returned nil error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed after keepalive timeout")
+	}
+}
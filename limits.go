@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"log/slog"
+	"time"
+)
+
+// ReadLimits are limits on the frames and messages read from the peer. They
+// protect against peers exhausting memory with huge frames or with messages
+// split in endless fragments. A zero field means no limit.
+type ReadLimits struct {
+	// MaxFrameSize is the maximum payload size in bytes of a data frame.
+	MaxFrameSize int64
+
+	// MaxMessageSize is the maximum size in bytes of a message, the limit
+	// set by SetReadLimit.
+	MaxMessageSize int64
+
+	// MaxFragments is the maximum number of frames of a message.
+	MaxFragments int
+}
+
+// SetReadLimits sets the limits on the frames and messages read from the
+// peer, replacing the limit set by SetReadLimit. The limits are checked as
+// each frame header is read, before the payload is read. If a limit is
+// exceeded, the connection sends a close message with the code
+// CloseMessageTooBig to the peer and returns ErrReadLimit to the
+// application, and all subsequent reads return the same error.
+func (c *Conn) SetReadLimits(limits ReadLimits) {
+	if c == nil {
+		return
+	}
+	c.readFrameMax = limits.MaxFrameSize
+	c.readLimit = limits.MaxMessageSize
+	c.readFramesMax = limits.MaxFragments
+}
+
+// ReadLimits returns the limits on the frames and messages read from the
+// peer.
+func (c *Conn) ReadLimits() ReadLimits {
+	if c == nil {
+		return ReadLimits{}
+	}
+	return ReadLimits{
+		MaxFrameSize:   c.readFrameMax,
+		MaxMessageSize: c.readLimit,
+		MaxFragments:   c.readFramesMax,
+	}
+}
+
+// handleReadLimit fails the connection for a message exceeding a read limit.
+func (c *Conn) handleReadLimit(reason string) error {
+	c.log(slog.LevelWarn, "websocket: read limit exceeded", "reason", reason)
+	c.audit(AuditViolation, "", CloseMessageTooBig, "read limit exceeded")
+	// Make a best effort to send a close message describing the problem.
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, reason), time.Now().Add(writeWait))
+	return ErrReadLimit
+}
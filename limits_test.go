@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadLimits(t *testing.T) {
+	emptyFragments := append(frame(TextMessage, true, nil), bytes.Repeat(frame(continuationFrame, true, nil), 100)...)
+	for _, tc := range []struct {
+		name   string
+		limits ReadLimits
+		input  []byte
+		fail   bool
+		reason string
+	}{
+		{"frame within limit", ReadLimits{MaxFrameSize: 4}, fragments("abcdefgh", 4), false, ""},
+		{"frame too large", ReadLimits{MaxFrameSize: 4}, fragments("abcdefgh", 5), true, "frame too large"},
+		{"message within limit", ReadLimits{MaxMessageSize: 8}, fragments("abcdefgh", 3), false, ""},
+		{"message too large", ReadLimits{MaxMessageSize: 7}, fragments("abcdefgh", 3), true, ""},
+		{"fragments within limit", ReadLimits{MaxFragments: 3}, fragments("abcdefgh", 3), false, ""},
+		{"too many fragments", ReadLimits{MaxFragments: 2}, fragments("abcdefgh", 3), true, "too many fragments"},
+		{"empty fragments", ReadLimits{MaxFragments: 100}, emptyFragments, true, "too many fragments"},
+	} {
+		var out bytes.Buffer
+		c := newTestConn(bytes.NewReader(tc.input), &out, true)
+		c.SetReadLimits(tc.limits)
+		if got := c.ReadLimits(); got != tc.limits {
+			t.Errorf("%s: ReadLimits() = %+v, want %+v", tc.name, got, tc.limits)
+		}
+		_, p, err := c.ReadMessage()
+		if !tc.fail {
+			if err != nil || string(p) != "abcdefgh" || out.Len() > 0 {
+				t.Errorf("%s: got %q, %v", tc.name, p, err)
+			}
+			continue
+		}
+		if err != ErrReadLimit || out.Len() < 4 {
+			t.Errorf("%s: got %q, %v, want %v", tc.name, p, err, ErrReadLimit)
+			continue
+		}
+		b := out.Bytes()
+		if code := binary.BigEndian.Uint16(b[2:]); code != CloseMessageTooBig || string(b[4:]) != tc.reason {
+			t.Errorf("%s: wrote close %d %q, want %d %q", tc.name, code, b[4:], CloseMessageTooBig, tc.reason)
+		}
+		if _, _, err := c.NextReader(); err != ErrReadLimit {
+			t.Errorf("%s: next read returned %v", tc.name, err)
+		}
+	}
+}
+
+func TestReadLimitsResetPerMessage(t *testing.T) {
+	input := append(fragments("abcd", 2), fragments("efgh", 2)...)
+	c := newTestConn(bytes.NewReader(input), nil, true)
+	c.SetReadLimits(ReadLimits{MaxFragments: 2, MaxMessageSize: 4})
+	for _, want := range []string{"abcd", "efgh"} {
+		if _, p, err := c.ReadMessage(); err != nil || string(p) != want {
+			t.Fatalf("got %q, %v, want %q", p, err, want)
+		}
+	}
+}
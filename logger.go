@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// Logger receives log records about connections: failed handshakes,
+// protocol errors, closed connections and evicted clients. Set the Logger
+// field of Upgrader, FastHTTPUpgrader, Dialer or Hub, or call Conn.SetLogger.
+//
+// The args are alternating keys and values as for log/slog, and include the
+// remote address of the connection under the key "remote_addr". Log is
+// called from the goroutines using the connections and must be safe to call
+// concurrently.
+type Logger interface {
+	Log(level slog.Level, msg string, args ...interface{})
+}
+
+// NewSlogLogger returns a Logger writing to l. If l is nil, slog.Default()
+// is used.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct{ l *slog.Logger }
+
+func (sl slogLogger) Log(level slog.Level, msg string, args ...interface{}) {
+	l := sl.l
+	if l == nil {
+		l = slog.Default()
+	}
+	l.Log(context.Background(), level, msg, args...)
+}
+
+// SetLogger sets the logger receiving the log records of the connection. A
+// nil logger disables logging.
+func (c *Conn) SetLogger(l Logger) {
+	if c == nil {
+		return
+	}
+	c.logger = l
+}
+
+// log writes a log record for the connection, if it has a logger.
+func (c *Conn) log(level slog.Level, msg string, args ...interface{}) {
+	if c.logger == nil {
+		return
+	}
+	c.logger.Log(level, msg, append(c.logArgs(), args...)...)
+}
+
+// logArgs returns the args identifying the connection in log records.
+func (c *Conn) logArgs() []interface{} {
+	args := make([]interface{}, 0, 8)
+	args = append(args, "conn_id", c.id)
+	if addr := c.RemoteAddr(); addr != nil {
+		args = append(args, "remote_addr", addr.String())
+	}
+	return args
+}
+
+// logDialError logs a failed opening handshake of the dialer.
+func (d *Dialer) logDialError(req *http.Request, resp *http.Response, err error) {
+	if d.Logger == nil {
+		return
+	}
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	d.Logger.Log(slog.LevelWarn, "websocket: dial failed", "url", req.URL.String(), "status", status, "error", err.Error())
+}
+
+// log writes a log record for the hub, if it has a logger.
+func (h *Hub) log(level slog.Level, msg string, args ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Log(level, msg, args...)
+	}
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLogger records log records as text.
+type testLogger struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (l *testLogger) Log(level slog.Level, msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := level.String() + " " + msg
+	for _, a := range args {
+		r += " " + fmt.Sprint(a)
+	}
+	l.records = append(l.records, r)
+}
+
+func (l *testLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.records, "\n")
+}
+
+func TestLoggerHandshakeFailed(t *testing.T) {
+	var l testLogger
+	u := Upgrader{Logger: &l}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = u.Upgrade(w, r, nil)
+	}))
+	defer s.Close()
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := l.String(); !strings.Contains(got, "WARN websocket: handshake failed remote_addr 127.0.0.1:") || !strings.Contains(got, "status 400") {
+		t.Fatalf("logged %q", got)
+	}
+
+	var dl testLogger
+	d := Dialer{Logger: &dl}
+	if _, _, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), http.Header{"Origin": {"http://other.example"}}); err == nil {
+		t.Fatal("dial succeeded")
+	}
+	if got := dl.String(); !strings.Contains(got, "WARN websocket: dial failed url http://127.0.0.1:") || !strings.Contains(got, "status 403") {
+		t.Fatalf("logged %q", got)
+	}
+}
+
+func TestLoggerConn(t *testing.T) {
+	var l testLogger
+	c := newTestConn(bytes.NewReader(frame(finalBit|rsv2Bit|TextMessage, true, nil)), io.Discard, true)
+	c.SetLogger(&l)
+	if _, _, err := c.ReadMessage(); err == nil {
+		t.Fatal("no protocol error")
+	}
+	c.Close()
+	want := "WARN websocket: protocol error conn_id " + c.ID() + " remote_addr str error RSV2 set\n" +
+		"INFO websocket: connection closed conn_id " + c.ID() + " remote_addr str code 1002"
+	if got := l.String(); got != want {
+		t.Fatalf("logged\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+	l.Log(slog.LevelWarn, "websocket: protocol error", "remote_addr", "10.0.0.1:1234", "error", "bad MASK")
+	want := `level=WARN msg="websocket: protocol error" remote_addr=10.0.0.1:1234 error="bad MASK"` + "\n"
+	if b.String() != want {
+		t.Fatalf("logged %q, want %q", b.String(), want)
+	}
+}
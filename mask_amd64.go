@@ -0,0 +1,13 @@
+//go:build !appengine && !purego
+
+package websocket
+
+import "golang.org/x/sys/cpu"
+
+// useAVX2 enables the masking of long buffers with AVX2 instructions.
+var useAVX2 = cpu.X86.HasAVX2
+
+// maskAVX2 XORs the n bytes at b, a multiple of 32, with the repeated key.
+//
+//go:noescape
+func maskAVX2(b *byte, n int, key uint32)
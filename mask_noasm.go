@@ -0,0 +1,10 @@
+//go:build (!amd64 && !appengine) || (purego && !appengine)
+
+package websocket
+
+// useAVX2 is false where the masking is not implemented in assembly.
+var useAVX2 = false
+
+func maskAVX2(b *byte, n int, key uint32) {
+	panic("websocket: maskAVX2 not implemented")
+}
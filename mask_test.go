@@ -2,7 +2,7 @@
 // this source code is governed by a BSD-style license that can be found in the
 // LICENSE file.
 
-// !appengine
+//go:build !appengine
 
 package websocket
 
@@ -28,16 +28,62 @@ func notzero(b []byte) int {
 	return -1
 }
 
+// maskPath is an implementation of maskBytes available on this machine.
+type maskPath struct {
+	name string
+	simd bool
+}
+
+func maskPaths() []maskPath {
+	paths := []maskPath{{"generic", false}}
+	if useAVX2 {
+		paths = append(paths, maskPath{"avx2", true})
+	}
+	return paths
+}
+
 func TestMaskBytes(t *testing.T) {
+	defer func(v bool) { useAVX2 = v }(useAVX2)
 	key := [4]byte{1, 2, 3, 4}
-	for size := 1; size <= 1024; size++ {
-		for align := 0; align < wordSize; align++ {
-			for pos := 0; pos < 4; pos++ {
-				b := make([]byte, size+align)[align:]
-				maskBytes(key, pos, b)
-				maskBytesByByte(key, pos, b)
-				if i := notzero(b); i >= 0 {
-					t.Errorf("size:%d, align:%d, pos:%d, offset:%d", size, align, pos, i)
+	for _, path := range maskPaths() {
+		useAVX2 = path.simd
+		for size := 1; size <= 1024; size++ {
+			for align := 0; align < wordSize; align++ {
+				for pos := 0; pos < 4; pos++ {
+					b := make([]byte, size+align)[align:]
+					if got, want := maskBytes(key, pos, b), (pos+size)&3; got != want {
+						t.Errorf("%s: size:%d, align:%d, pos:%d, returned position %d, want %d", path.name, size, align, pos, got, want)
+					}
+					maskBytesByByte(key, pos, b)
+					if i := notzero(b); i >= 0 {
+						t.Errorf("%s: size:%d, align:%d, pos:%d, offset:%d", path.name, size, align, pos, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMaskBytesBounds(t *testing.T) {
+	defer func(v bool) { useAVX2 = v }(useAVX2)
+	key := [4]byte{0x11, 0x22, 0x33, 0x44}
+	for _, path := range maskPaths() {
+		useAVX2 = path.simd
+		for _, size := range []int{avx2Threshold - 1, avx2Threshold, avx2Threshold + 1, 4093, 65536 + 7} {
+			for align := 0; align < 32; align++ {
+				// Mask the middle of a buffer filled with a pattern, so that
+				// writes past the bounds of the slice are detected.
+				buf := make([]byte, align+size+64)
+				for i := range buf {
+					buf[i] = byte(i * 7)
+				}
+				want := append([]byte(nil), buf...)
+				maskBytesByByte(key, 3, want[align:align+size])
+				maskBytes(key, 3, buf[align:align+size])
+				for i := range buf {
+					if buf[i] != want[i] {
+						t.Fatalf("%s: size:%d, align:%d, byte %d is %#x, want %#x", path.name, size, align, i, buf[i], want[i])
+					}
 				}
 			}
 		}
@@ -45,17 +91,28 @@ func TestMaskBytes(t *testing.T) {
 }
 
 func BenchmarkMaskBytes(b *testing.B) {
-	for _, size := range []int{2, 4, 8, 16, 32, 512, 1024} {
+	defer func(v bool) { useAVX2 = v }(useAVX2)
+	for _, size := range []int{2, 4, 8, 16, 32, 64, 512, 1024, 4096, 65536} {
 		b.Run(fmt.Sprintf("size-%d", size), func(b *testing.B) {
 			for _, align := range []int{wordSize / 2} {
 				b.Run(fmt.Sprintf("align-%d", align), func(b *testing.B) {
-					for _, fn := range []struct {
+					fns := []struct {
 						name string
 						fn   func(key [4]byte, pos int, b []byte) int
 					}{
 						{"byte", maskBytesByByte},
-						{"word", maskBytes},
-					} {
+					}
+					for _, path := range maskPaths() {
+						simd := path.simd
+						fns = append(fns, struct {
+							name string
+							fn   func(key [4]byte, pos int, b []byte) int
+						}{path.name, func(key [4]byte, pos int, b []byte) int {
+							useAVX2 = simd
+							return maskBytes(key, pos, b)
+						}})
+					}
+					for _, fn := range fns {
 						b.Run(fn.name, func(b *testing.B) {
 							key := newMaskKey()
 							data := make([]byte, size+align)[align:]
@@ -0,0 +1,280 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives events about connections for monitoring. Set the Metrics
+// field of Upgrader, FastHTTPUpgrader or Dialer to collect metrics for the
+// connections they create.
+//
+// The methods are called from the goroutines reading from and writing to the
+// connections and must be safe to call concurrently. Implementations should
+// return quickly.
+type Metrics interface {
+	// ConnOpened is called when a connection is established.
+	ConnOpened()
+
+	// ConnClosed is called when a connection is closed with the first close
+	// code sent or received on the connection, or CloseAbnormalClosure if no
+	// close message was exchanged.
+	ConnClosed(code int)
+
+	// MessageReceived and MessageSent are called for each data message with
+	// the message type, TextMessage or BinaryMessage.
+	MessageReceived(messageType int)
+	MessageSent(messageType int)
+
+	// BytesReceived and BytesSent are called for each data frame with the
+	// payload size on the wire, after compression.
+	BytesReceived(n int)
+	BytesSent(n int)
+
+	// HandshakeFailed is called when an opening handshake fails with the HTTP
+	// status code of the response, or zero if no response was received.
+	HandshakeFailed(status int)
+
+	// PingRTT is called with the round trip time of each keepalive ping. See
+	// Conn.EnableKeepalive.
+	PingRTT(rtt time.Duration)
+}
+
+// setMetrics attaches m to the connection and reports the connection as
+// opened.
+func (c *Conn) setMetrics(m Metrics) {
+	if m == nil {
+		return
+	}
+	c.metrics = m
+	m.ConnOpened()
+}
+
+// framePayloadLen returns the payload length of the encoded frame.
+func framePayloadLen(frame []byte) int {
+	if len(frame) < 2 {
+		return 0
+	}
+	n := 2
+	switch frame[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if frame[1]&maskBit != 0 {
+		n += 4
+	}
+	return max(len(frame)-n, 0)
+}
+
+// DefaultRTTBuckets are the upper bounds in seconds of the ping round trip
+// time histogram buckets used by PrometheusMetrics.
+var DefaultRTTBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics is a Metrics implementation that serves the collected
+// metrics in the Prometheus text exposition format. Register the value as an
+// HTTP handler on the endpoint scraped by Prometheus:
+//
+//	var metrics websocket.PrometheusMetrics
+//	upgrader := websocket.Upgrader{Metrics: &metrics}
+//	http.Handle("/metrics", &metrics)
+//
+// The zero value is ready to use. Set the fields before first use.
+type PrometheusMetrics struct {
+	// Namespace is the prefix of the metric names. If empty, "websocket" is
+	// used.
+	Namespace string
+
+	// RTTBuckets are the upper bounds in seconds of the ping round trip time
+	// histogram buckets in increasing order. If nil, DefaultRTTBuckets is
+	// used.
+	RTTBuckets []float64
+
+	opened, closed           atomic.Uint64
+	received, sent           [3]atomic.Uint64 // indexed by message type
+	bytesReceived, bytesSent atomic.Uint64
+
+	mu               sync.Mutex
+	closeCodes       map[int]uint64
+	handshakeFailure map[int]uint64
+	rttCounts        []uint64 // cumulative counts are computed on output
+	rttCount         uint64
+	rttSum           float64
+}
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+
+// ConnOpened implements Metrics.
+func (pm *PrometheusMetrics) ConnOpened() { pm.opened.Add(1) }
+
+// ConnClosed implements Metrics.
+func (pm *PrometheusMetrics) ConnClosed(code int) {
+	pm.closed.Add(1)
+	pm.mu.Lock()
+	if pm.closeCodes == nil {
+		pm.closeCodes = make(map[int]uint64)
+	}
+	pm.closeCodes[code]++
+	pm.mu.Unlock()
+}
+
+// MessageReceived implements Metrics.
+func (pm *PrometheusMetrics) MessageReceived(messageType int) {
+	if messageType == TextMessage || messageType == BinaryMessage {
+		pm.received[messageType].Add(1)
+	}
+}
+
+// MessageSent implements Metrics.
+func (pm *PrometheusMetrics) MessageSent(messageType int) {
+	if messageType == TextMessage || messageType == BinaryMessage {
+		pm.sent[messageType].Add(1)
+	}
+}
+
+// BytesReceived implements Metrics.
+func (pm *PrometheusMetrics) BytesReceived(n int) { pm.bytesReceived.Add(uint64(n)) }
+
+// BytesSent implements Metrics.
+func (pm *PrometheusMetrics) BytesSent(n int) { pm.bytesSent.Add(uint64(n)) }
+
+// HandshakeFailed implements Metrics.
+func (pm *PrometheusMetrics) HandshakeFailed(status int) {
+	pm.mu.Lock()
+	if pm.handshakeFailure == nil {
+		pm.handshakeFailure = make(map[int]uint64)
+	}
+	pm.handshakeFailure[status]++
+	pm.mu.Unlock()
+}
+
+// PingRTT implements Metrics.
+func (pm *PrometheusMetrics) PingRTT(rtt time.Duration) {
+	buckets := pm.buckets()
+	v := rtt.Seconds()
+	pm.mu.Lock()
+	if pm.rttCounts == nil {
+		pm.rttCounts = make([]uint64, len(buckets))
+	}
+	if i := sort.SearchFloat64s(buckets, v); i < len(buckets) {
+		pm.rttCounts[i]++
+	}
+	pm.rttCount++
+	pm.rttSum += v
+	pm.mu.Unlock()
+}
+
+func (pm *PrometheusMetrics) buckets() []float64 {
+	if pm.RTTBuckets != nil {
+		return pm.RTTBuckets
+	}
+	return DefaultRTTBuckets
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (pm *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = pm.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format to w.
+func (pm *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	ns := pm.Namespace
+	if ns == "" {
+		ns = "websocket"
+	}
+	ew := &errWriter{w: w}
+	metric := func(name, typ, help string) string {
+		name = ns + "_" + name
+		ew.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		return name
+	}
+
+	opened, closed := pm.opened.Load(), pm.closed.Load()
+	name := metric("connections_opened_total", "counter", "Total number of connections opened.")
+	ew.printf("%s %d\n", name, opened)
+	name = metric("connections_active", "gauge", "Number of open connections.")
+	ew.printf("%s %d\n", name, opened-min(closed, opened))
+
+	pm.mu.Lock()
+	closeCodes := sortedCounts(pm.closeCodes)
+	failures := sortedCounts(pm.handshakeFailure)
+	rttCounts := append([]uint64(nil), pm.rttCounts...)
+	rttCount, rttSum := pm.rttCount, pm.rttSum
+	pm.mu.Unlock()
+
+	name = metric("connections_closed_total", "counter", "Total number of connections closed by close code.")
+	for _, kv := range closeCodes {
+		ew.printf("%s{code=\"%d\"} %d\n", name, kv.key, kv.value)
+	}
+
+	name = metric("messages_total", "counter", "Total number of data messages by direction and type.")
+	for _, mt := range []int{TextMessage, BinaryMessage} {
+		typ := "text"
+		if mt == BinaryMessage {
+			typ = "binary"
+		}
+		ew.printf("%s{direction=\"received\",type=\"%s\"} %d\n", name, typ, pm.received[mt].Load())
+		ew.printf("%s{direction=\"sent\",type=\"%s\"} %d\n", name, typ, pm.sent[mt].Load())
+	}
+
+	name = metric("bytes_total", "counter", "Total number of data frame payload bytes by direction.")
+	ew.printf("%s{direction=\"received\"} %d\n", name, pm.bytesReceived.Load())
+	ew.printf("%s{direction=\"sent\"} %d\n", name, pm.bytesSent.Load())
+
+	name = metric("handshake_failures_total", "counter", "Total number of failed opening handshakes by HTTP status.")
+	for _, kv := range failures {
+		ew.printf("%s{status=\"%d\"} %d\n", name, kv.key, kv.value)
+	}
+
+	name = metric("ping_rtt_seconds", "histogram", "Round trip time of keepalive pings.")
+	var cumulative uint64
+	for i, le := range pm.buckets() {
+		if i < len(rttCounts) {
+			cumulative += rttCounts[i]
+		}
+		ew.printf("%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	ew.printf("%s_bucket{le=\"+Inf\"} %d\n", name, rttCount)
+	ew.printf("%s_sum %s\n", name, strconv.FormatFloat(rttSum, 'g', -1, 64))
+	ew.printf("%s_count %d\n", name, rttCount)
+	return ew.n, ew.err
+}
+
+type countPair struct {
+	key   int
+	value uint64
+}
+
+func sortedCounts(m map[int]uint64) []countPair {
+	pairs := make([]countPair, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, countPair{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+	return pairs
+}
+
+// errWriter is an io.Writer that remembers the first error and the number
+// of bytes written.
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(ew.w, format, args...)
+	ew.n += int64(n)
+	ew.err = err
+}
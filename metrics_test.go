@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	var serverMetrics, clientMetrics PrometheusMetrics
+	u := Upgrader{Metrics: &serverMetrics}
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer close(done)
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	d := Dialer{Metrics: &clientMetrics}
+	c, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c); got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.Close()
+	<-done
+
+	for _, tt := range []struct {
+		pm   *PrometheusMetrics
+		want []string
+	}{
+		{&serverMetrics, []string{
+			"websocket_connections_opened_total 1\n",
+			"websocket_connections_active 0\n",
+			`websocket_connections_closed_total{code="1000"} 1` + "\n",
+			`websocket_messages_total{direction="received",type="text"} 1` + "\n",
+			`websocket_messages_total{direction="sent",type="text"} 1` + "\n",
+			`websocket_bytes_total{direction="received"} 5` + "\n",
+			`websocket_bytes_total{direction="sent"} 5` + "\n",
+		}},
+		{&clientMetrics, []string{
+			"websocket_connections_opened_total 1\n",
+			`websocket_connections_closed_total{code="1000"} 1` + "\n",
+			`websocket_messages_total{direction="sent",type="text"} 1` + "\n",
+		}},
+	} {
+		var sb strings.Builder
+		if _, err := tt.pm.WriteTo(&sb); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(sb.String(), want) {
+				t.Errorf("metrics missing %q:\n%s", want, sb.String())
+			}
+		}
+	}
+
+	// A plain HTTP request is a failed handshake.
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var sb strings.Builder
+	_, _ = serverMetrics.WriteTo(&sb)
+	if want := `websocket_handshake_failures_total{status="400"} 1`; !strings.Contains(sb.String(), want) {
+		t.Errorf("metrics missing %q:\n%s", want, sb.String())
+	}
+}
+
+func TestPrometheusMetricsRTT(t *testing.T) {
+	pm := PrometheusMetrics{Namespace: "ws", RTTBuckets: []float64{.01, .1}}
+	pm.PingRTT(5 * time.Millisecond)
+	pm.PingRTT(50 * time.Millisecond)
+	pm.PingRTT(time.Second)
+	pm.HandshakeFailed(http.StatusForbidden)
+
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`ws_ping_rtt_seconds_bucket{le="0.01"} 1`,
+		`ws_ping_rtt_seconds_bucket{le="0.1"} 2`,
+		`ws_ping_rtt_seconds_bucket{le="+Inf"} 3`,
+		`ws_ping_rtt_seconds_count 3`,
+		`ws_handshake_failures_total{status="403"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want+"\n") {
+			t.Errorf("metrics missing %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	// ErrConnMigrated is returned by the read and write methods of a
+	// connection after Export.
+	ErrConnMigrated = errors.New("websocket: connection migrated")
+
+	// ErrMigrationUnsupported is returned by Export when the state of the
+	// connection cannot be carried to another process, such as a TLS
+	// connection or a decompressor with context takeover.
+	ErrMigrationUnsupported = errors.New("websocket: connection cannot be migrated")
+
+	// ErrMigrationBusy is returned by Export when the application is in
+	// the middle of reading a message or writing one with NextWriter.
+	ErrMigrationBusy = errors.New("websocket: connection busy writing a message")
+)
+
+// migrationPollInterval is the interval at which Export checks for the
+// reader and the write queue to become idle.
+const migrationPollInterval = time.Millisecond
+
+// ConnState is the protocol state of a connection exported by Export and
+// restored by Adopt in another process. ConnState is encoded as JSON by
+// HandOff.
+type ConnState struct {
+	// ID is the ID of the connection, kept across the migration.
+	ID string `json:"id"`
+
+	// Server is true for the server side of a connection.
+	Server bool `json:"server"`
+
+	// Subprotocol and Extensions are the negotiated subprotocol and
+	// extensions.
+	Subprotocol string `json:"subprotocol,omitempty"`
+	Extensions  string `json:"extensions,omitempty"`
+
+	// ReadLimit is the maximum size of a message read from the peer.
+	ReadLimit int64 `json:"read_limit,omitempty"`
+
+	// Buffered holds the bytes received from the peer but not yet read,
+	// such as the pending frames of a fragmented message.
+	Buffered []byte `json:"buffered,omitempty"`
+
+	// App is the application state of the connection, such as the
+	// sequence numbers of a resumable session. Export leaves App empty.
+	App []byte `json:"app,omitempty"`
+}
+
+// Export detaches the connection from this process so that another process
+// can adopt it with Adopt, as in a binary upgrade handing off live
+// connections. Export returns the protocol state of the connection and a
+// duplicate of its file descriptor; the caller must close the file once it
+// is passed on.
+//
+// A goroutine blocked in a read method waiting for the next message returns
+// ErrConnMigrated, and so do the read and write methods called after
+// Export. Export waits for a message being read to complete and for the
+// write queue to drain, until ctx is done. The connection is closed in this
+// process without a close message, while the peer stays connected to the
+// adopting process.
+//
+// Only connections over a TCP or Unix socket can be exported, and not when
+// receiving with context takeover compression, whose history cannot be
+// carried over. If Export fails after the reader stopped, the connection is
+// closed; otherwise it is left as it was.
+func (c *Conn) Export(ctx context.Context) (*ConnState, *os.File, error) {
+	if c == nil {
+		return nil, nil, ErrNilConn
+	}
+	fc, ok := c.conn.(interface{ File() (*os.File, error) })
+	if !ok || c.transport != "" || len(c.extTransforms) > 0 {
+		return nil, nil, ErrMigrationUnsupported
+	}
+	if c.extensions != "" {
+		if _, ok := parseDeflateExtensions(c.extensions); !ok {
+			return nil, nil, ErrMigrationUnsupported
+		}
+		if !c.readNoContextTakeover() {
+			return nil, nil, ErrMigrationUnsupported
+		}
+	}
+	if c.migrating.Swap(true) {
+		return nil, nil, ErrConnMigrated
+	}
+	if err := c.stopReader(ctx); err != nil {
+		c.migrating.Store(false)
+		return nil, nil, err
+	}
+	defer c.readMu.Unlock()
+
+	st, f, err := c.export(ctx, fc)
+	if err != nil {
+		if c.readErr == ErrConnMigrated {
+			_ = c.Close()
+		} else {
+			c.migrating.Store(false)
+		}
+		return nil, nil, err
+	}
+	return st, f, nil
+}
+
+// stopReader waits for the goroutine reading the connection, if any, to
+// return ErrConnMigrated at a message boundary, and returns with c.readMu
+// held. A reader waiting for the next frame is woken with an expired read
+// deadline; the frame header is not consumed until it is complete, so the
+// frame stays buffered.
+func (c *Conn) stopReader(ctx context.Context) error {
+	t := time.NewTicker(migrationPollInterval)
+	defer t.Stop()
+	for !c.readMu.TryLock() {
+		if c.awaitingFrame.Load() {
+			_ = c.conn.SetReadDeadline(time.Now())
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			if c.readMu.TryLock() {
+				return nil
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// export captures the state of the connection. The read lock must be held
+// and the reader stopped at a message boundary.
+func (c *Conn) export(ctx context.Context, fc interface{ File() (*os.File, error) }) (*ConnState, *os.File, error) {
+	if c.readErr == nil {
+		if c.readRemaining > 0 || !c.readFinal {
+			return nil, nil, ErrMigrationBusy
+		}
+		c.readErr = ErrConnMigrated
+	} else if c.readErr != ErrConnMigrated {
+		return nil, nil, c.readErr
+	}
+
+	// Wait for the queued messages to be written, then stop the writes.
+	t := time.NewTicker(migrationPollInterval)
+	defer t.Stop()
+	for c.WriteQueueLen() > 0 {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	select {
+	case <-c.mu:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	busy := c.writer != nil
+	if !busy {
+		c.writeErrMu.Lock()
+		if c.writeErr == nil {
+			c.writeErr = ErrConnMigrated
+		}
+		c.writeErrMu.Unlock()
+	}
+	c.mu <- struct{}{}
+	if busy {
+		return nil, nil, ErrMigrationBusy
+	}
+
+	st := &ConnState{
+		ID:          c.id,
+		Server:      c.isServer,
+		Subprotocol: c.subprotocol,
+		Extensions:  c.extensions,
+		ReadLimit:   c.readLimit,
+	}
+	if c.br != nil {
+		p, _ := c.br.Peek(c.br.Buffered())
+		st.Buffered = append(st.Buffered, p...)
+	}
+	if c.readPool != nil && c.readSource.pending {
+		st.Buffered = append(st.Buffered, c.readSource.b[0])
+	}
+
+	f, err := fc.File()
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = c.Close()
+	return st, f, nil
+}
+
+// awaitMessage waits for the header of the first frame of the next message
+// without consuming it, so that Export can stop the reader at a message
+// boundary. The caller must hold c.readMu.
+func (c *Conn) awaitMessage() error {
+	if err := c.skipRemainingFrame(); err != nil {
+		return err
+	}
+	_ = c.setReadRemaining(0) // will not fail because argument is >= 0
+	if !c.readFinal {
+		// The application abandoned a fragmented message.
+		return nil
+	}
+	if c.migrating.Load() {
+		return ErrConnMigrated
+	}
+	c.awaitingFrame.Store(true)
+	err := c.peekFrameHeader()
+	c.awaitingFrame.Store(false)
+	if c.migrating.Load() {
+		return ErrConnMigrated
+	}
+	return err
+}
+
+// peekFrameHeader waits until the header of the next frame is buffered.
+func (c *Conn) peekFrameHeader() error {
+	if c.readPool != nil {
+		if err := c.awaitReadBuffer(); err != nil {
+			return err
+		}
+	}
+	p, err := c.br.Peek(2)
+	if err == nil {
+		n := 2
+		switch p[1] & 0x7f {
+		case 126:
+			n += 2
+		case 127:
+			n += 8
+		}
+		if p[1]&maskBit != 0 {
+			n += 4
+		}
+		_, err = c.br.Peek(n)
+	}
+	if err == io.EOF {
+		err = errUnexpectedEOF
+	}
+	return err
+}
+
+// parseDeflateExtensions parses the negotiated permessage-deflate
+// extension.
+func parseDeflateExtensions(extensions string) (deflateParams, bool) {
+	for _, ext := range parseExtensions(http.Header{"Sec-Websocket-Extensions": {extensions}}) {
+		if ext[""] != "permessage-deflate" {
+			continue
+		}
+		if _, ok := ext[dictionaryParam]; ok {
+			// The dictionary is not part of the exported state.
+			return deflateParams{}, false
+		}
+		_, snct := ext["server_no_context_takeover"]
+		_, cnct := ext["client_no_context_takeover"]
+		return deflateParams{serverNoContextTakeover: snct, clientNoContextTakeover: cnct}, true
+	}
+	return deflateParams{}, false
+}
+
+// readNoContextTakeover reports whether the peer compresses each message
+// independently.
+func (c *Conn) readNoContextTakeover() bool {
+	p, _ := parseDeflateExtensions(c.extensions)
+	if c.isServer {
+		return p.clientNoContextTakeover
+	}
+	return p.serverNoContextTakeover
+}
+
+// Adopt returns a connection restored from the file descriptor and the
+// state exported by Export in another process. Adopt closes f. The
+// connection uses the default buffer sizes; the other options, such as the
+// handlers, limits other than the read limit and the registries, must be
+// set again on the adopted connection.
+func Adopt(f *os.File, st *ConnState) (*Conn, error) {
+	nc, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = nc
+	if len(st.Buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(st.Buffered), nc)
+	}
+	br := bufio.NewReaderSize(r, defaultReadBufferSize)
+	c := newConn(nc, st.Server, 0, 0, nil, br, nil)
+	if st.ID != "" {
+		c.id = st.ID
+	}
+	c.subprotocol = st.Subprotocol
+	if st.Extensions != "" {
+		p, ok := parseDeflateExtensions(st.Extensions)
+		if !ok {
+			nc.Close()
+			return nil, ErrMigrationUnsupported
+		}
+		c.setupDeflate(p)
+	}
+	c.SetReadLimit(st.ReadLimit)
+	return c, nil
+}
+
+// HandOffAll hands off every connection of the registry over the Unix
+// socket with HandOff. The state function, if not nil, returns the
+// application state sent with each connection. HandOffAll returns the
+// number of connections handed off and the errors of the others, which
+// stay open in this process unless their reader was already stopped.
+func HandOffAll(ctx context.Context, uc *net.UnixConn, r *Registry, state func(c *Conn) []byte) (int, error) {
+	var n int
+	var errs []error
+	r.Range(func(c *Conn) bool {
+		var app []byte
+		if state != nil {
+			app = state(c)
+		}
+		if err := HandOff(ctx, uc, c, app); err != nil {
+			errs = append(errs, err)
+		} else {
+			n++
+		}
+		return ctx.Err() == nil
+	})
+	return n, errors.Join(errs...)
+}
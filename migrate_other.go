@@ -0,0 +1,23 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package websocket
+
+import (
+	"context"
+	"net"
+)
+
+// HandOff is not supported on this platform.
+func HandOff(ctx context.Context, uc *net.UnixConn, c *Conn, app []byte) error {
+	return ErrMigrationUnsupported
+}
+
+// ReceiveConn is not supported on this platform.
+func ReceiveConn(uc *net.UnixConn) (*Conn, *ConnState, error) {
+	return nil, nil, ErrMigrationUnsupported
+}
+
+// ListenReusePort is not supported on this platform.
+func ListenReusePort(ctx context.Context, network, address string) (net.Listener, error) {
+	return nil, ErrMigrationUnsupported
+}
@@ -0,0 +1,164 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportAdopt(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage()
+		readErr <- err
+	}()
+	// Let the reader block waiting for a message.
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	st, f, err := server.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-readErr; err != ErrConnMigrated {
+		t.Fatalf("blocked ReadMessage returned %v, want %v", err, ErrConnMigrated)
+	}
+	if err := server.WriteMessage(TextMessage, []byte("old")); err != ErrConnMigrated {
+		t.Fatalf("WriteMessage after Export returned %v, want %v", err, ErrConnMigrated)
+	}
+
+	adopted, err := Adopt(f, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adopted.Close()
+	if adopted.ID() != server.ID() {
+		t.Errorf("adopted ID %q, want %q", adopted.ID(), server.ID())
+	}
+	if err := client.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if s := readString(t, adopted); s != "hello" {
+		t.Fatalf("adopted connection read %q", s)
+	}
+	if err := adopted.WriteMessage(TextMessage, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if s := readString(t, client); s != "world" {
+		t.Fatalf("client read %q from the adopted connection", s)
+	}
+}
+
+func TestExportBuffered(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	for _, s := range []string{"first", "second"} {
+		if err := client.WriteMessage(TextMessage, []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := readString(t, server); s != "first" {
+		t.Fatalf("read %q", s)
+	}
+	// Wait for the second message to be buffered with the first one.
+	for server.br.Buffered() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	st, f, err := server.Export(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Buffered) == 0 {
+		t.Fatal("Export did not carry the buffered message")
+	}
+	adopted, err := Adopt(f, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adopted.Close()
+	if s := readString(t, adopted); s != "second" {
+		t.Fatalf("adopted connection read %q, want the buffered message", s)
+	}
+}
+
+func TestExportBusy(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer server.Close()
+	defer client.Close()
+	w, err := server.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := server.Export(context.Background()); !errors.Is(err, ErrMigrationBusy) {
+		t.Fatalf("Export while writing returned %v, want %v", err, ErrMigrationBusy)
+	}
+	w.Close()
+
+	if _, _, err := newTestConn(nil, nil, true).Export(context.Background()); err != ErrMigrationUnsupported {
+		t.Fatalf("Export of a connection without a file returned %v, want %v", err, ErrMigrationUnsupported)
+	}
+}
+
+func TestHandOff(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "handoff.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan *Conn, 1)
+	go func() {
+		uc, err := l.AcceptUnix()
+		if err != nil {
+			t.Error(err)
+			close(received)
+			return
+		}
+		defer uc.Close()
+		c, st, err := ReceiveConn(uc)
+		if err != nil {
+			t.Error(err)
+			close(received)
+			return
+		}
+		if string(st.App) != "seq=42" {
+			t.Errorf("received application state %q", st.App)
+		}
+		received <- c
+	}()
+
+	var registry Registry
+	server, client := newTCPConns(t)
+	defer client.Close()
+	registry.Add(server)
+	uc, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	n, err := HandOffAll(context.Background(), uc, &registry, func(c *Conn) []byte { return []byte("seq=42") })
+	if n != 1 || err != nil {
+		t.Fatalf("HandOffAll() = %d, %v", n, err)
+	}
+	adopted := <-received
+	if adopted == nil {
+		t.FailNow()
+	}
+	defer adopted.Close()
+	if err := adopted.WriteMessage(TextMessage, []byte("moved")); err != nil {
+		t.Fatal(err)
+	}
+	if s := readString(t, client); s != "moved" {
+		t.Fatalf("client read %q from the received connection", s)
+	}
+}
@@ -0,0 +1,138 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxHandOffStateSize is the maximum size of the encoded state of a
+// connection received by ReceiveConn.
+const maxHandOffStateSize = 16 << 20
+
+// HandOff exports the connection with Export and sends its state, with the
+// application state app, and its file descriptor over the Unix socket to
+// the process calling ReceiveConn. The state is written as a 4 byte big
+// endian length followed by the JSON encoding of the ConnState, with the
+// descriptor attached as an SCM_RIGHTS control message.
+func HandOff(ctx context.Context, uc *net.UnixConn, c *Conn, app []byte) error {
+	st, f, err := c.Export(ctx)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st.App = app
+	p, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(p)), uint32(len(p)))
+	msg = append(msg, p...)
+	if d, ok := ctx.Deadline(); ok {
+		_ = uc.SetWriteDeadline(d)
+		defer uc.SetWriteDeadline(time.Time{})
+	}
+	// The descriptor is attached to the first byte of the message.
+	if _, _, err := uc.WriteMsgUnix(msg[:1], syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		return err
+	}
+	_, err = uc.Write(msg[1:])
+	return err
+}
+
+// ReceiveConn receives a connection sent with HandOff over the Unix socket
+// and adopts it with Adopt. ReceiveConn returns the connection and its
+// state, whose App field holds the application state sent with it.
+func ReceiveConn(uc *net.UnixConn) (*Conn, *ConnState, error) {
+	var hdr [4]byte
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(hdr[:1], oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n != 1 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	f, err := receivedFile(oob[:oobn])
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := readHandOffState(uc, hdr)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	c, err := Adopt(f, st)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, st, nil
+}
+
+// receivedFile returns the file descriptor of a SCM_RIGHTS control message.
+func receivedFile(oob []byte) (*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		fds, err := syscall.ParseUnixRights(&m)
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		for _, fd := range fds[1:] {
+			syscall.Close(fd)
+		}
+		return os.NewFile(uintptr(fds[0]), "websocket"), nil
+	}
+	return nil, errors.New("websocket: hand-off without a file descriptor")
+}
+
+func readHandOffState(r io.Reader, hdr [4]byte) (*ConnState, error) {
+	if _, err := io.ReadFull(r, hdr[1:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxHandOffStateSize {
+		return nil, errors.New("websocket: hand-off state too large")
+	}
+	p := make([]byte, size)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+	st := &ConnState{}
+	if err := json.Unmarshal(p, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// ListenReusePort listens on a TCP address with SO_REUSEPORT set, so that
+// the process taking over the connections can listen on the same address
+// before the old process stops accepting.
+func ListenReusePort(ctx context.Context, network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: func(network, address string, rc syscall.RawConn) error {
+		var serr error
+		err := rc.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			if serr == nil {
+				serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}}
+	return lc.Listen(ctx, network, address)
+}
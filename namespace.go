@@ -0,0 +1,451 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is matched by the errors returned when an operation on a
+// namespace would exceed one of its quotas.
+var ErrQuotaExceeded = errors.New("websocket: namespace quota exceeded")
+
+// QuotaError describes the quota of a namespace exceeded by Join.
+// QuotaError matches ErrQuotaExceeded with errors.Is.
+type QuotaError struct {
+	// Namespace is the name of the namespace.
+	Namespace string
+
+	// Resource is the limited resource, "connections" or "rooms".
+	Resource string
+
+	// Limit is the quota of the resource.
+	Limit int
+}
+
+func (e *QuotaError) Error() string {
+	return "websocket: namespace " + strconv.Quote(e.Namespace) + " exceeds its quota of " + strconv.Itoa(e.Limit) + " " + e.Resource
+}
+
+// Is reports whether target is ErrQuotaExceeded.
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Quota limits the resources used by a namespace. A zero field sets no
+// limit.
+type Quota struct {
+	// MaxConns is the maximum number of connections that are members of
+	// the namespace at once. A connection counts from its first Join until
+	// it is removed or closed.
+	MaxConns int
+
+	// MaxRooms is the maximum number of rooms with members in the
+	// namespace at once.
+	MaxRooms int
+
+	// Bandwidth limits the rate of messages and payload bytes delivered to
+	// the connections of the namespace by Send and the broadcast methods.
+	// A broadcast is charged one message and its payload for each local
+	// recipient. Under RateLimitDelay, the methods wait for the rate; under
+	// the other policies they return ErrRateLimited without queueing the
+	// message. Each node enforces the limit for its own connections.
+	Bandwidth RateLimit
+}
+
+// Namespaces isolates the tenants sharing a gateway. Each namespace has its
+// own Hub, so the rooms, connections, presence records and broadcasts of a
+// tenant are invisible to the others, and the quota of each namespace is
+// enforced by its Join, Send and broadcast methods.
+//
+// Namespaces are created on first use by Get. A Namespace must be used
+// instead of its hub so that the quotas apply.
+//
+// It is safe to call the methods of Namespaces concurrently. The zero value
+// is ready to use.
+type Namespaces struct {
+	// NewHub returns the hub of a new namespace, configured as for a
+	// single tenant. NewHub must return a new hub on each call and must
+	// not share a PresenceStore between namespaces. If nil, a zero Hub is
+	// used.
+	NewHub func(name string) *Hub
+
+	// Broker, if not nil, relays the broadcasts and presence updates of
+	// the namespaces to the processes using the same broker. The hub of
+	// each namespace gets a view of Broker carrying the messages of the
+	// namespace only, so its members never receive the broadcasts of
+	// another tenant. The Broker field of the hubs returned by NewHub must
+	// be nil.
+	Broker Broker
+
+	// Quota returns the quota of a new namespace. If nil, DefaultQuota is
+	// used for every namespace.
+	Quota func(name string) Quota
+
+	// DefaultQuota is the quota of the namespaces when Quota is nil.
+	DefaultQuota Quota
+
+	mu     sync.Mutex
+	spaces map[string]*Namespace
+	closed bool
+}
+
+// Get returns the named namespace, creating it if needed. Get returns
+// ErrHubClosed after Close.
+func (ns *Namespaces) Get(name string) (*Namespace, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.closed {
+		return nil, ErrHubClosed
+	}
+	if n, ok := ns.spaces[name]; ok {
+		return n, nil
+	}
+	var h *Hub
+	if ns.NewHub != nil {
+		h = ns.NewHub(name)
+	}
+	if h == nil {
+		h = &Hub{}
+	}
+	if ns.Broker != nil {
+		h.Broker = &namespaceBroker{b: ns.Broker, name: name}
+	}
+	q := ns.DefaultQuota
+	if ns.Quota != nil {
+		q = ns.Quota(name)
+	}
+	n := &Namespace{
+		name:   name,
+		parent: ns,
+		hub:    h,
+		conns:  make(map[*Conn]*namespaceClient),
+		rooms:  make(map[string]int),
+	}
+	n.setQuota(q)
+	if ns.spaces == nil {
+		ns.spaces = make(map[string]*Namespace)
+	}
+	ns.spaces[name] = n
+	return n, nil
+}
+
+// Lookup returns the named namespace, or nil if it does not exist.
+func (ns *Namespaces) Lookup(name string) *Namespace {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.spaces[name]
+}
+
+// Names returns the names of the namespaces in sorted order.
+func (ns *Namespaces) Names() []string {
+	ns.mu.Lock()
+	names := make([]string, 0, len(ns.spaces))
+	for name := range ns.spaces {
+		names = append(names, name)
+	}
+	ns.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// Close closes every namespace. Get returns ErrHubClosed after Close.
+func (ns *Namespaces) Close() error {
+	ns.mu.Lock()
+	ns.closed = true
+	spaces := ns.spaces
+	ns.spaces = nil
+	ns.mu.Unlock()
+	var errs []error
+	for _, n := range spaces {
+		errs = append(errs, n.close())
+	}
+	return errors.Join(errs...)
+}
+
+// forget removes n from the namespaces.
+func (ns *Namespaces) forget(n *Namespace) {
+	ns.mu.Lock()
+	if ns.spaces[n.name] == n {
+		delete(ns.spaces, n.name)
+	}
+	ns.mu.Unlock()
+}
+
+// Namespace is the hub of a tenant with quotas. Create namespaces with
+// Namespaces.Get.
+//
+// As with a Hub, the application must not write data messages to the
+// connections of a namespace directly, and should call Remove when the read
+// loop of a connection exits. A closed connection is removed automatically.
+type Namespace struct {
+	name   string
+	parent *Namespaces
+	hub    *Hub
+
+	mu    sync.Mutex // guards the fields below and serializes joins
+	quota Quota
+	conns map[*Conn]*namespaceClient
+	rooms map[string]int // number of members of each room
+
+	limitMu sync.Mutex
+	limiter *rateLimiter
+}
+
+// namespaceClient holds the namespace state for a connection.
+type namespaceClient struct {
+	rooms map[string]struct{}
+	stop  chan struct{} // stops watching the connection
+}
+
+// Name returns the name of the namespace.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Quota returns the quota of the namespace.
+func (n *Namespace) Quota() Quota {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.quota
+}
+
+// SetQuota changes the quota of the namespace. Connections and rooms above
+// a lowered quota are kept; the quota applies to later joins.
+func (n *Namespace) SetQuota(q Quota) {
+	n.mu.Lock()
+	n.setQuota(q)
+	n.mu.Unlock()
+}
+
+func (n *Namespace) setQuota(q Quota) {
+	n.quota = q
+	limit := q.Bandwidth
+	if limit.Policy == RateLimitClose {
+		limit.Policy = RateLimitDrop
+	}
+	n.limitMu.Lock()
+	n.limiter = newRateLimiter(limit)
+	n.limitMu.Unlock()
+}
+
+// Join adds the connection to the named room. Join returns a *QuotaError
+// if a new connection or a new room would exceed the quota of the
+// namespace.
+func (n *Namespace) Join(room string, c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	nc, member := n.conns[c]
+	if member {
+		if _, ok := nc.rooms[room]; ok {
+			return n.hub.Join(room, c)
+		}
+	} else if n.quota.MaxConns > 0 && len(n.conns) >= n.quota.MaxConns {
+		return &QuotaError{Namespace: n.name, Resource: "connections", Limit: n.quota.MaxConns}
+	}
+	if n.rooms[room] == 0 && n.quota.MaxRooms > 0 && len(n.rooms) >= n.quota.MaxRooms {
+		return &QuotaError{Namespace: n.name, Resource: "rooms", Limit: n.quota.MaxRooms}
+	}
+	if err := n.hub.Join(room, c); err != nil {
+		return err
+	}
+	if !member {
+		nc = &namespaceClient{rooms: make(map[string]struct{}), stop: make(chan struct{})}
+		n.conns[c] = nc
+		go n.watch(c, nc.stop)
+	}
+	nc.rooms[room] = struct{}{}
+	n.rooms[room]++
+	return nil
+}
+
+// watch removes the connection from the namespace when it is closed.
+func (n *Namespace) watch(c *Conn, stop chan struct{}) {
+	select {
+	case <-c.Done():
+		n.Remove(c)
+	case <-stop:
+	}
+}
+
+// Leave removes the connection from the named room. The connection stays a
+// member of the namespace until Remove is called.
+func (n *Namespace) Leave(room string, c *Conn) {
+	if c == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.hub.Leave(room, c)
+	if nc, ok := n.conns[c]; ok {
+		if _, ok := nc.rooms[room]; ok {
+			delete(nc.rooms, room)
+			n.leaveRoom(room)
+		}
+	}
+}
+
+// leaveRoom counts a member leaving room. The namespace lock must be held.
+func (n *Namespace) leaveRoom(room string) {
+	if n.rooms[room]--; n.rooms[room] <= 0 {
+		delete(n.rooms, room)
+	}
+}
+
+// Remove removes the connection from all rooms of the namespace and stops
+// writing queued messages to it. Remove does not close the connection.
+func (n *Namespace) Remove(c *Conn) {
+	if c == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.hub.Remove(c)
+	nc, ok := n.conns[c]
+	if !ok {
+		return
+	}
+	for room := range nc.rooms {
+		n.leaveRoom(room)
+	}
+	delete(n.conns, c)
+	close(nc.stop)
+}
+
+// charge applies the bandwidth quota to the delivery of a message of size
+// bytes to the given number of connections.
+func (n *Namespace) charge(recipients, size int) error {
+	if recipients == 0 {
+		return nil
+	}
+	n.limitMu.Lock()
+	rl := n.limiter
+	if rl == nil {
+		n.limitMu.Unlock()
+		return nil
+	}
+	d, allowed := rl.reserve(time.Now(), recipients, recipients*size)
+	n.limitMu.Unlock()
+	if !allowed {
+		return ErrRateLimited
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return nil
+}
+
+// Send queues a message for a single connection of the namespace.
+func (n *Namespace) Send(c *Conn, messageType int, data []byte) error {
+	n.mu.Lock()
+	_, ok := n.conns[c]
+	n.mu.Unlock()
+	if !ok {
+		return ErrNotHubMember
+	}
+	if err := n.charge(1, len(data)); err != nil {
+		return err
+	}
+	return n.hub.Send(c, messageType, data)
+}
+
+// Broadcast queues a message for every connection in the named room.
+func (n *Namespace) Broadcast(room string, messageType int, data []byte) error {
+	return n.BroadcastExcept(room, nil, messageType, data)
+}
+
+// BroadcastExcept queues a message for every connection in the named room
+// except the given connection.
+func (n *Namespace) BroadcastExcept(room string, except *Conn, messageType int, data []byte) error {
+	recipients := n.hub.Len(room)
+	if except != nil && recipients > 0 {
+		recipients--
+	}
+	if err := n.charge(recipients, len(data)); err != nil {
+		return err
+	}
+	return n.hub.BroadcastExcept(room, except, messageType, data)
+}
+
+// BroadcastAll queues a message for every connection of the namespace.
+func (n *Namespace) BroadcastAll(messageType int, data []byte) error {
+	if err := n.charge(n.hub.Count(), len(data)); err != nil {
+		return err
+	}
+	return n.hub.BroadcastAll(messageType, data)
+}
+
+// Rooms returns the names of the rooms with members in the namespace.
+func (n *Namespace) Rooms() []string {
+	return n.hub.Rooms()
+}
+
+// Len returns the number of connections in the named room.
+func (n *Namespace) Len(room string) int {
+	return n.hub.Len(room)
+}
+
+// Count returns the number of connections of the namespace.
+func (n *Namespace) Count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.conns)
+}
+
+// Presence returns the members of the named room of the namespace on all
+// nodes. Presence returns nil if the hub of the namespace has no
+// PresenceStore.
+func (n *Namespace) Presence(room string) ([]Member, error) {
+	return n.hub.Presence(room)
+}
+
+// Close closes the hub of the namespace and removes the namespace from its
+// Namespaces. A later Get creates a new, empty namespace with the same name.
+func (n *Namespace) Close() error {
+	n.parent.forget(n)
+	return n.close()
+}
+
+func (n *Namespace) close() error {
+	n.mu.Lock()
+	for c, nc := range n.conns {
+		delete(n.conns, c)
+		close(nc.stop)
+	}
+	clear(n.rooms)
+	n.mu.Unlock()
+	return n.hub.Close()
+}
+
+// namespaceBroker is the view of a broker shared by namespaces that carries
+// the messages of one namespace. The namespace is appended to the origin of
+// the messages; hub IDs do not contain a slash.
+type namespaceBroker struct {
+	b    Broker
+	name string
+}
+
+func (b *namespaceBroker) Publish(ctx context.Context, m *BrokerMessage) error {
+	tagged := *m
+	tagged.Origin = m.Origin + "/" + b.name
+	return b.b.Publish(ctx, &tagged)
+}
+
+func (b *namespaceBroker) Subscribe(ctx context.Context, f func(m *BrokerMessage)) error {
+	return b.b.Subscribe(ctx, func(m *BrokerMessage) {
+		origin, name, ok := strings.Cut(m.Origin, "/")
+		if !ok || name != b.name {
+			return
+		}
+		tagged := *m
+		tagged.Origin = origin
+		f(&tagged)
+	})
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNamespaceQuota(t *testing.T) {
+	ns := &Namespaces{DefaultQuota: Quota{MaxConns: 2, MaxRooms: 2}}
+	defer ns.Close()
+	n, err := ns.Get("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, _ := newPipeConns()
+	s2, _ := newPipeConns()
+	s3, _ := newPipeConns()
+	if err := n.Join("a", s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Join("b", s1); err != nil {
+		t.Fatal(err)
+	}
+	var qe *QuotaError
+	if err := n.Join("c", s1); !errors.As(err, &qe) || qe.Resource != "rooms" || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Join of a third room returned %v, want a room quota error", err)
+	}
+	if err := n.Join("a", s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Join("a", s3); !errors.As(err, &qe) || qe.Resource != "connections" || qe.Limit != 2 {
+		t.Fatalf("Join of a third connection returned %v, want a connection quota error", err)
+	}
+
+	n.Leave("b", s1)
+	if err := n.Join("c", s2); err != nil {
+		t.Fatalf("Join after a room was emptied returned %v", err)
+	}
+
+	// A closed connection frees its share of the quota.
+	s2.Close()
+	for deadline := time.Now().Add(time.Second); n.Count() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("closed connection not removed from the namespace")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := n.Join("a", s3); err != nil {
+		t.Fatalf("Join after a connection closed returned %v", err)
+	}
+	if got := n.Rooms(); !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("Rooms() = %v, want [a]", got)
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	b := newMemBroker()
+	node1 := &Namespaces{Broker: b}
+	defer node1.Close()
+	node2 := &Namespaces{Broker: b}
+	defer node2.Close()
+
+	join := func(ns *Namespaces, name string) *Conn {
+		t.Helper()
+		n, err := ns.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, c := newPipeConns()
+		if err := n.Join("lobby", s); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	a1 := join(node1, "acme")
+	a2 := join(node2, "acme")
+	b2 := join(node2, "globex")
+	b.waitReady(t, 3)
+
+	if err := node1.Lookup("acme").Broadcast("lobby", TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Conn{a1, a2} {
+		if got := readString(t, c); got != "hello" {
+			t.Fatalf("member of the namespace got %q", got)
+		}
+	}
+	_ = b2.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, p, err := b2.ReadMessage(); err == nil {
+		t.Fatalf("member of another namespace got %q", p)
+	}
+
+	if got := node2.Names(); !slices.Equal(got, []string{"acme", "globex"}) {
+		t.Fatalf("Names() = %v", got)
+	}
+	if err := node2.Lookup("globex").Close(); err != nil {
+		t.Fatal(err)
+	}
+	if node2.Lookup("globex") != nil {
+		t.Fatal("closed namespace still registered")
+	}
+}
+
+func TestNamespaceBandwidth(t *testing.T) {
+	ns := &Namespaces{Quota: func(name string) Quota {
+		return Quota{Bandwidth: RateLimit{Bytes: 1, ByteBurst: 10, Policy: RateLimitDrop}}
+	}}
+	defer ns.Close()
+	n, err := ns.Get("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, c1 := newPipeConns()
+	s2, c2 := newPipeConns()
+	for _, s := range []*Conn{s1, s2} {
+		if err := n.Join("lobby", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The payload is charged for each of the two members.
+	if err := n.Broadcast("lobby", TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Conn{c1, c2} {
+		if got := readString(t, c); got != "hello" {
+			t.Fatalf("got %q", got)
+		}
+	}
+	if err := n.Broadcast("lobby", TextMessage, []byte("again")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Broadcast over the bandwidth quota returned %v, want %v", err, ErrRateLimited)
+	}
+	if err := n.Send(s1, TextMessage, []byte("x")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Send over the bandwidth quota returned %v, want %v", err, ErrRateLimited)
+	}
+
+	n.SetQuota(Quota{})
+	if err := n.Send(s1, TextMessage, []byte("x")); err != nil {
+		t.Fatalf("Send after the quota was lifted returned %v", err)
+	}
+}
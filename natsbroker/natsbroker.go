@@ -0,0 +1,229 @@
+// Package natsbroker relays websocket hub broadcasts between processes with
+// NATS.
+//
+// Give every hub a broker using the same NATS cluster and subject prefix:
+//
+//	nc, err := nats.Connect(nats.DefaultURL, nats.MaxReconnects(-1))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	hub := &websocket.Hub{Broker: natsbroker.New(nc, "chat")}
+//
+// Broadcasts to a room are published on the subject <prefix>.room.<room>,
+// broadcasts to every connection on <prefix>.all and presence updates on
+// <prefix>.presence, so other NATS services can observe or publish to
+// individual rooms. Room names that are not valid subject tokens are
+// encoded; see RoomSubject.
+//
+// The NATS client reconnects and restores the subscription on its own. The
+// broker returns an error to the hub only after the connection is closed
+// for good, for example when the reconnect attempts are exhausted. Each
+// message carries a Nats-Msg-Id header, and the broker drops the messages
+// it has already delivered, such as broadcasts that a publisher retried
+// across a reconnect.
+package natsbroker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gflydev/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultSubject is the subject prefix used when Broker.Subject is empty.
+const DefaultSubject = "websocket"
+
+// defaultDedupWindow is the number of message IDs remembered for
+// de-duplication by default.
+const defaultDedupWindow = 1024
+
+// Broker is a websocket.Broker backed by NATS core publish/subscribe.
+type Broker struct {
+	// Conn is the NATS connection used to publish and subscribe.
+	Conn *nats.Conn
+
+	// Subject is the prefix of the subjects carrying the broadcasts. If
+	// empty, DefaultSubject is used. Hubs that should share broadcasts
+	// must use the same prefix.
+	Subject string
+
+	// DedupWindow is the number of recent message IDs remembered to drop
+	// duplicates. If zero, a default of 1024 is used. If negative,
+	// duplicates are not dropped.
+	DedupWindow int
+
+	idOnce sync.Once
+	id     string
+	seq    atomic.Uint64
+}
+
+var _ websocket.Broker = (*Broker)(nil)
+
+// New returns a broker publishing on subjects prefixed with subject.
+func New(conn *nats.Conn, subject string) *Broker {
+	return &Broker{Conn: conn, Subject: subject}
+}
+
+func (b *Broker) prefix() string {
+	if b.Subject == "" {
+		return DefaultSubject
+	}
+	return b.Subject
+}
+
+// RoomSubject returns the subject carrying the broadcasts to room. Room
+// names consisting of letters, digits, '-' and '_' are used as is. Other
+// names are encoded with unpadded base64url and prefixed with '~'.
+func (b *Broker) RoomSubject(room string) string {
+	return b.prefix() + ".room." + roomToken(room)
+}
+
+// AllSubject returns the subject carrying the broadcasts to every
+// connection.
+func (b *Broker) AllSubject() string {
+	return b.prefix() + ".all"
+}
+
+// PresenceSubject returns the subject carrying the presence updates of the
+// hubs.
+func (b *Broker) PresenceSubject() string {
+	return b.prefix() + ".presence"
+}
+
+func roomToken(room string) string {
+	if room == "" {
+		return "~"
+	}
+	for i := 0; i < len(room); i++ {
+		c := room[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return "~" + base64.RawURLEncoding.EncodeToString([]byte(room))
+		}
+	}
+	return room
+}
+
+// nextID returns a message ID unique to this broker.
+func (b *Broker) nextID() string {
+	b.idOnce.Do(func() {
+		var p [8]byte
+		_, _ = rand.Read(p[:])
+		b.id = hex.EncodeToString(p[:])
+	})
+	return b.id + "-" + strconv.FormatUint(b.seq.Add(1), 10)
+}
+
+// Publish publishes m on the subject of its room, on AllSubject if m.All is
+// set or on PresenceSubject if m.Presence is set.
+func (b *Broker) Publish(ctx context.Context, m *websocket.BrokerMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	var subject string
+	switch {
+	case m.Presence:
+		subject = b.PresenceSubject()
+	case m.All:
+		subject = b.AllSubject()
+	default:
+		subject = b.RoomSubject(m.Room)
+	}
+	msg := nats.NewMsg(subject)
+	msg.Header.Set(nats.MsgIdHdr, b.nextID())
+	msg.Data = p
+	return b.Conn.PublishMsg(msg)
+}
+
+// Subscribe subscribes to the subjects of all rooms and calls f for each
+// message until ctx is done or the NATS connection is closed. Messages that
+// cannot be decoded and duplicates are dropped.
+func (b *Broker) Subscribe(ctx context.Context, f func(m *websocket.BrokerMessage)) error {
+	status := b.Conn.StatusChanged(nats.CLOSED)
+	defer b.Conn.RemoveStatusListener(status)
+	if b.Conn.IsClosed() {
+		return nats.ErrConnectionClosed
+	}
+
+	// The messages are queued by the NATS client within the pending limits
+	// of the subscription. After Unsubscribe, a callback being delivered
+	// may still run: stopped keeps it from calling f once Subscribe
+	// returns.
+	var (
+		mu      sync.Mutex
+		stopped bool
+	)
+	d := newDedup(b.DedupWindow)
+	sub, err := b.Conn.Subscribe(b.prefix()+".>", func(msg *nats.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped || !d.add(msg.Header.Get(nats.MsgIdHdr)) {
+			return
+		}
+		var m websocket.BrokerMessage
+		if err := m.UnmarshalBinary(msg.Data); err != nil {
+			return
+		}
+		f(&m)
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = sub.Unsubscribe()
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-status:
+		return nats.ErrConnectionClosed
+	}
+}
+
+// dedup remembers the most recent message IDs.
+type dedup struct {
+	seen map[string]struct{}
+	ring []string
+	next int
+}
+
+func newDedup(window int) *dedup {
+	if window == 0 {
+		window = defaultDedupWindow
+	}
+	if window < 0 {
+		return nil
+	}
+	return &dedup{seen: make(map[string]struct{}, window), ring: make([]string, window)}
+}
+
+// add records id and reports whether it was not seen before. Messages
+// without an ID are never considered duplicates.
+func (d *dedup) add(id string) bool {
+	if d == nil || id == "" {
+		return true
+	}
+	if _, ok := d.seen[id]; ok {
+		return false
+	}
+	if old := d.ring[d.next]; old != "" {
+		delete(d.seen, old)
+	}
+	d.ring[d.next] = id
+	d.next = (d.next + 1) % len(d.ring)
+	d.seen[id] = struct{}{}
+	return true
+}
@@ -0,0 +1,322 @@
+package natsbroker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+	"github.com/gflydev/websocket/brokertest"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+func runServer(t *testing.T, port int) *server.Server {
+	t.Helper()
+	opts := natstest.DefaultTestOptions
+	opts.Port = port
+	s := natstest.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connect(t *testing.T, s *server.Server) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(s.ClientURL(), nats.MaxReconnects(-1), nats.ReconnectWait(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// serve returns the URL of a server adding its connections to room of hub.
+func serve(t *testing.T, hub *websocket.Hub, room string) string {
+	t.Helper()
+	var u websocket.Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		if err := hub.Join(room, c); err != nil {
+			_ = c.Close()
+			return
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				hub.Remove(c)
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func readString(t *testing.T, c *websocket.Conn) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	return string(p)
+}
+
+// waitSubscriptions waits for n client subscriptions on the subject
+// prefix "test".
+func waitSubscriptions(t *testing.T, s *server.Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sz, err := s.Subsz(&server.SubszOptions{Subscriptions: true, Test: "test.all"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sz.Subs) >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for subscriptions")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBroker(t *testing.T) {
+	s := runServer(t, -1)
+	newHub := func() *websocket.Hub {
+		h := &websocket.Hub{Broker: New(connect(t, s), "test")}
+		t.Cleanup(func() { _ = h.Close() })
+		return h
+	}
+	h1, h2 := newHub(), newHub()
+
+	c1 := dial(t, serve(t, h1, "a"))
+	c2 := dial(t, serve(t, h2, "a"))
+	c3 := dial(t, serve(t, h2, "b.c"))
+	for h1.Len("a") == 0 || h2.Len("a") == 0 || h2.Len("b.c") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waitSubscriptions(t, s, 2)
+
+	if err := h1.Broadcast("a", websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c1); got != "hello" {
+		t.Fatalf("local member got %q", got)
+	}
+	if got := readString(t, c2); got != "hello" {
+		t.Fatalf("remote member got %q", got)
+	}
+
+	if err := h1.Broadcast("b.c", websocket.TextMessage, []byte("dotted")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c3); got != "dotted" {
+		t.Fatalf("got %q", got)
+	}
+
+	if err := h2.BroadcastAll(websocket.TextMessage, []byte("all")); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*websocket.Conn{c1, c2, c3} {
+		if got := readString(t, c); got != "all" {
+			t.Fatalf("got %q", got)
+		}
+	}
+}
+
+func TestBrokerSubjects(t *testing.T) {
+	nc := connect(t, runServer(t, -1))
+	b := New(nc, "")
+	sub, err := nc.SubscribeSync("websocket.room.lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), &websocket.BrokerMessage{Room: "lobby", MessageType: websocket.TextMessage, Data: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sub.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get(nats.MsgIdHdr) == "" {
+		t.Fatal("message has no ID")
+	}
+
+	for room, want := range map[string]string{
+		"lobby":  "websocket.room.lobby",
+		"a_b-1":  "websocket.room.a_b-1",
+		"a.b":    "websocket.room.~YS5i",
+		"":       "websocket.room.~",
+		"* >":    "websocket.room.~KiA-",
+		"~lobby": "websocket.room.~fmxvYmJ5",
+	} {
+		if got := b.RoomSubject(room); got != want {
+			t.Errorf("RoomSubject(%q) = %q, want %q", room, got, want)
+		}
+	}
+	if got := b.AllSubject(); got != "websocket.all" {
+		t.Errorf("AllSubject() = %q", got)
+	}
+	if got := b.PresenceSubject(); got != "websocket.presence" {
+		t.Errorf("PresenceSubject() = %q", got)
+	}
+}
+
+func TestBrokerDedup(t *testing.T) {
+	s := runServer(t, -1)
+	nc := connect(t, s)
+	b := New(nc, "test")
+
+	got := make(chan string, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Subscribe(ctx, func(m *websocket.BrokerMessage) { got <- string(m.Data) })
+	}()
+	waitSubscriptions(t, s, 1)
+
+	for _, data := range []string{"one", "one", "two"} {
+		p, _ := (&websocket.BrokerMessage{Room: "a", Data: []byte(data)}).MarshalBinary()
+		msg := nats.NewMsg("test.room.a")
+		msg.Header.Set(nats.MsgIdHdr, "id-"+data)
+		msg.Data = p
+		if err := nc.PublishMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"one", "two"} {
+		select {
+		case s := <-got:
+			if s != want {
+				t.Fatalf("got %q, want %q", s, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Subscribe returned %v after cancel", err)
+	}
+}
+
+func TestBrokerReconnect(t *testing.T) {
+	s := runServer(t, -1)
+	port := s.Addr().(*net.TCPAddr).Port
+	nc := connect(t, s)
+	b := New(nc, "test")
+
+	got := make(chan string, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = b.Subscribe(ctx, func(m *websocket.BrokerMessage) { got <- string(m.Data) }) }()
+	waitSubscriptions(t, s, 1)
+
+	s.Shutdown()
+	s = runServer(t, port)
+	// The client restores the subscription after reconnecting.
+	waitSubscriptions(t, s, 1)
+
+	if err := b.Publish(context.Background(), &websocket.BrokerMessage{Room: "a", Data: []byte("again")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-got:
+		if s != "again" {
+			t.Fatalf("got %q", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message after reconnect")
+	}
+}
+
+func TestBrokerClosed(t *testing.T) {
+	nc := connect(t, runServer(t, -1))
+	b := New(nc, "test")
+	done := make(chan error, 1)
+	go func() { done <- b.Subscribe(context.Background(), func(*websocket.BrokerMessage) {}) }()
+	time.Sleep(10 * time.Millisecond)
+	nc.Close()
+	select {
+	case err := <-done:
+		if err != nats.ErrConnectionClosed {
+			t.Fatalf("Subscribe returned %v, want %v", err, nats.ErrConnectionClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe did not return after Close")
+	}
+}
+
+func TestBrokerBurst(t *testing.T) {
+	s := runServer(t, -1)
+	nc := connect(t, s)
+	b := New(nc, "test")
+
+	const n = 2000
+	var got atomic.Int64
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Subscribe(ctx, func(m *websocket.BrokerMessage) {
+			// A slow hub makes the messages pile up in the subscription.
+			time.Sleep(10 * time.Microsecond)
+			got.Add(1)
+		})
+	}()
+	waitSubscriptions(t, s, 1)
+
+	for i := 0; i < n; i++ {
+		if err := b.Publish(context.Background(), &websocket.BrokerMessage{Room: "a", Data: []byte("burst")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for got.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d messages delivered", got.Load(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, brokertest.Suite{
+		NewBrokers: func(t *testing.T, n int) ([]websocket.Broker, func()) {
+			s := runServer(t, -1)
+			port := s.Addr().(*net.TCPAddr).Port
+			brokers := make([]websocket.Broker, n)
+			for i := range brokers {
+				brokers[i] = New(connect(t, s), "test")
+			}
+			restart := func() {
+				s.Shutdown()
+				s = runServer(t, port)
+			}
+			return brokers, restart
+		},
+	})
+}
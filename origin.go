@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gflydev/core/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// OriginPolicy is an origin check for Upgrader and FastHTTPUpgrader based on
+// an allowlist of origin patterns.
+//
+// Each pattern in AllowedOrigins has the form [scheme://]host[:port]. The
+// host can be a name, a wildcard of the form *.example.com matching any
+// subdomain of example.com but not example.com itself, or * matching any
+// host. If the scheme is omitted, any scheme listed in Schemes is accepted.
+// If the port is omitted, any port is accepted. Matching is case
+// insensitive. The pattern "null" matches the opaque origin sent by sandboxed
+// documents and must be listed explicitly.
+//
+//	upgrader := websocket.Upgrader{
+//		OriginPolicy: &websocket.OriginPolicy{
+//			AllowedOrigins: []string{"https://example.com", "https://*.example.com"},
+//		},
+//	}
+type OriginPolicy struct {
+	// AllowedOrigins lists the allowed origin patterns.
+	AllowedOrigins []string
+
+	// AllowSameOrigin allows origins whose host equals the request Host
+	// header, as the default check does.
+	AllowSameOrigin bool
+
+	// Schemes lists the allowed origin schemes, for example "https". If
+	// empty, any scheme is allowed.
+	Schemes []string
+
+	// RequireOrigin rejects requests without an Origin header. Browsers
+	// always send the header; other clients usually do not.
+	RequireOrigin bool
+
+	// OnDeny is called with the request and the origin when a request is
+	// rejected.
+	OnDeny func(r *http.Request, origin string)
+
+	// OnDenyFastHTTP is called with the request context and the origin when a
+	// request is rejected by CheckFastHTTP.
+	OnDenyFastHTTP func(ctx *fasthttp.RequestCtx, origin string)
+}
+
+// Check reports whether the request origin is allowed. The signature of
+// Check matches Upgrader.CheckOrigin.
+func (p *OriginPolicy) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if p.allow(origin, r.Host) {
+		return true
+	}
+	if p.OnDeny != nil {
+		p.OnDeny(r, origin)
+	}
+	return false
+}
+
+// CheckFastHTTP reports whether the request origin is allowed. The signature
+// of CheckFastHTTP matches FastHTTPUpgrader.CheckOrigin.
+func (p *OriginPolicy) CheckFastHTTP(ctx *fasthttp.RequestCtx) bool {
+	origin := string(ctx.Request.Header.Peek("Origin"))
+	if p.allow(origin, utils.UnsafeStr(ctx.Host())) {
+		return true
+	}
+	if p.OnDenyFastHTTP != nil {
+		p.OnDenyFastHTTP(ctx, origin)
+	}
+	return false
+}
+
+// allow reports whether origin is allowed for a request to host.
+func (p *OriginPolicy) allow(origin, host string) bool {
+	if origin == "" {
+		return !p.RequireOrigin
+	}
+	if origin == "null" {
+		return containsString(p.AllowedOrigins, "null")
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	if len(p.Schemes) > 0 && !containsFold(p.Schemes, u.Scheme) {
+		return false
+	}
+	if p.AllowSameOrigin && equalASCIIFold(u.Host, host) {
+		return true
+	}
+	for _, pattern := range p.AllowedOrigins {
+		if matchOrigin(pattern, u.Scheme, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin reports whether the origin with the given scheme and host
+// matches the pattern.
+func matchOrigin(pattern, scheme, host string) bool {
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if !equalASCIIFold(pattern[:i], scheme) {
+			return false
+		}
+		pattern = pattern[i+len("://"):]
+	}
+	if pattern == "*" {
+		return true
+	}
+
+	patternHost, patternPort := splitHostPortLoose(pattern)
+	hostName, port := splitHostPortLoose(host)
+	if patternPort != "" && patternPort != port {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(patternHost, "*."); ok {
+		return len(hostName) > len(suffix)+1 &&
+			hostName[len(hostName)-len(suffix)-1] == '.' &&
+			equalASCIIFold(hostName[len(hostName)-len(suffix):], suffix)
+	}
+	return equalASCIIFold(patternHost, hostName)
+}
+
+// splitHostPortLoose splits hostport into host and port. The port is empty
+// if hostport has no port.
+func splitHostPortLoose(hostport string) (host, port string) {
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		return h, p
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if equalASCIIFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+var originPolicyTests = []struct {
+	policy OriginPolicy
+	origin string
+	host   string
+	ok     bool
+}{
+	{OriginPolicy{}, "", "example.com", true},
+	{OriginPolicy{RequireOrigin: true}, "", "example.com", false},
+	{OriginPolicy{}, "https://example.com", "example.com", false},
+	{OriginPolicy{AllowSameOrigin: true}, "https://Example.com", "example.com", true},
+	{OriginPolicy{AllowedOrigins: []string{"example.com"}}, "https://example.com", "other.com", true},
+	{OriginPolicy{AllowedOrigins: []string{"example.com"}}, "http://example.com:8080", "other.com", true},
+	{OriginPolicy{AllowedOrigins: []string{"example.com:443"}}, "https://example.com:8443", "other.com", false},
+	{OriginPolicy{AllowedOrigins: []string{"https://example.com"}}, "http://example.com", "other.com", false},
+	{OriginPolicy{AllowedOrigins: []string{"https://*.example.com"}}, "https://a.b.example.com", "other.com", true},
+	{OriginPolicy{AllowedOrigins: []string{"*.example.com"}}, "https://example.com", "other.com", false},
+	{OriginPolicy{AllowedOrigins: []string{"*.example.com"}}, "https://badexample.com", "other.com", false},
+	{OriginPolicy{AllowedOrigins: []string{"*"}, Schemes: []string{"https"}}, "http://example.com", "other.com", false},
+	{OriginPolicy{AllowedOrigins: []string{"*"}, Schemes: []string{"https"}}, "https://example.com", "other.com", true},
+	{OriginPolicy{AllowedOrigins: []string{"*"}}, "null", "other.com", false},
+	{OriginPolicy{AllowedOrigins: []string{"null"}}, "null", "other.com", true},
+	{OriginPolicy{AllowedOrigins: []string{"[::1]"}}, "http://[::1]:3000", "other.com", true},
+	{OriginPolicy{AllowedOrigins: []string{"*"}}, "not a url", "other.com", false},
+}
+
+func TestOriginPolicy(t *testing.T) {
+	for _, tt := range originPolicyTests {
+		var denied string
+		p := tt.policy
+		p.OnDeny = func(r *http.Request, origin string) { denied = origin }
+		r := &http.Request{Host: tt.host, Header: http.Header{}}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if ok := p.Check(r); ok != tt.ok {
+			t.Errorf("%+v.Check(%q, %q) = %v, want %v", tt.policy, tt.origin, tt.host, ok, tt.ok)
+		}
+		if !tt.ok && denied != tt.origin {
+			t.Errorf("OnDeny called with %q, want %q", denied, tt.origin)
+		}
+
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetHost(tt.host)
+		if tt.origin != "" {
+			ctx.Request.Header.Set("Origin", tt.origin)
+		}
+		if ok := p.CheckFastHTTP(&ctx); ok != tt.ok {
+			t.Errorf("%+v.CheckFastHTTP(%q, %q) = %v, want %v", tt.policy, tt.origin, tt.host, ok, tt.ok)
+		}
+	}
+}
+
+func TestUpgraderOriginPolicy(t *testing.T) {
+	u := Upgrader{
+		OriginPolicy: &OriginPolicy{AllowedOrigins: []string{"https://example.com"}},
+		Error:        func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
+	}
+	req := &http.Request{
+		Method: http.MethodGet,
+		Host:   "other.com",
+		Header: http.Header{
+			"Connection":            {"upgrade"},
+			"Upgrade":               {"websocket"},
+			"Sec-Websocket-Version": {"13"},
+			"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+			"Origin":                {"https://evil.com"},
+		},
+	}
+	_, err := u.Upgrade(nil, req, nil)
+	if err == nil || err.Error() != "websocket: request origin not allowed by Upgrader.CheckOrigin" {
+		t.Fatalf("Upgrade returned %v, want origin error", err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package otelws instruments WebSocket connections with OpenTelemetry
+// tracing.
+//
+// A Tracer records a span for each opening handshake and a span for the
+// lifetime of each connection, with an event or a child span for each data
+// message. The trace context is extracted from and injected into the
+// handshake request headers, and carried across the connection in the meta
+// object of the events routed by a websocket.Router:
+//
+//	t := &otelws.Tracer{}
+//	c, err := t.Upgrade(&upgrader, w, r, nil)
+//	...
+//	router.Handle("order.place", t.EventHandler(func(ctx context.Context, c *websocket.Conn, e *websocket.Event) error {
+//		// ctx carries the span of the event, a child of the span of the
+//		// sender.
+//		return t.Emit(ctx, c, "order.placed", order)
+//	}))
+package otelws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gflydev/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of the tracer.
+const ScopeName = "github.com/gflydev/websocket/otelws"
+
+const contextKey = "otelws.context" // Conn metadata key of the connection span context
+
+// Tracer instruments connections. The zero value uses the global tracer
+// provider and propagator and records an event for each message.
+type Tracer struct {
+	// TracerProvider creates the tracer. If nil, the global tracer provider
+	// is used.
+	TracerProvider trace.TracerProvider
+
+	// Propagator extracts and injects the trace context. If nil, the global
+	// propagator is used.
+	Propagator propagation.TextMapPropagator
+
+	// MessageSpans specifies whether each message is recorded as a span,
+	// a child of the connection span. If false, each message is recorded
+	// as an event of the connection span.
+	MessageSpans bool
+
+	// Sample reports whether a data message is recorded. If nil, all
+	// messages are recorded.
+	Sample func(messageType int, data []byte) bool
+}
+
+func (t *Tracer) tracer() trace.Tracer {
+	tp := t.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(ScopeName)
+}
+
+func (t *Tracer) propagator() propagation.TextMapPropagator {
+	if t.Propagator != nil {
+		return t.Propagator
+	}
+	return otel.GetTextMapPropagator()
+}
+
+// Upgrade upgrades the HTTP server connection with the upgrader and
+// instruments the connection. The handshake span is a child of the trace
+// context extracted from the request headers.
+func (t *Tracer) Upgrade(u *websocket.Upgrader, w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*websocket.Conn, error) {
+	ctx := t.propagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := t.tracer().Start(ctx, "websocket.handshake",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("network.peer.address", r.RemoteAddr),
+		))
+	c, err := u.Upgrade(w, r, responseHeader)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("websocket.subprotocol", c.Subprotocol()))
+	span.End()
+	t.Instrument(ctx, c)
+	return c, nil
+}
+
+// Dial dials the URL with the dialer and instruments the connection. The
+// trace context of ctx is injected into the handshake request headers.
+func (t *Tracer) Dial(ctx context.Context, d *websocket.Dialer, urlStr string, requestHeader http.Header) (*websocket.Conn, *http.Response, error) {
+	ctx, span := t.tracer().Start(ctx, "websocket.handshake",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", urlStr)))
+	header := requestHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	t.propagator().Inject(ctx, propagation.HeaderCarrier(header))
+	c, resp, err := d.DialContext(ctx, urlStr, header)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, resp, err
+	}
+	span.SetAttributes(attribute.String("websocket.subprotocol", c.Subprotocol()))
+	span.End()
+	t.Instrument(ctx, c)
+	return c, resp, nil
+}
+
+// Instrument starts the span of the connection, a child of the span in ctx,
+// and records the data messages read from and written to the connection.
+// The span ends when the connection is closed. Upgrade and Dial instrument
+// the connections they create; use Instrument for connections created
+// otherwise.
+func (t *Tracer) Instrument(ctx context.Context, c *websocket.Conn) {
+	tracer := t.tracer()
+	ctx, span := tracer.Start(ctx, "websocket.conn",
+		trace.WithAttributes(attribute.String("network.peer.address", c.RemoteAddr().String())))
+	c.Set(contextKey, ctx)
+	go func() {
+		<-c.Done()
+		span.End()
+	}()
+
+	record := func(name string) websocket.Interceptor {
+		return func(messageType int, data []byte) ([]byte, error) {
+			if t.Sample != nil && !t.Sample(messageType, data) {
+				return data, nil
+			}
+			attrs := trace.WithAttributes(
+				attribute.String("websocket.message.type", messageTypeName(messageType)),
+				attribute.Int("messaging.message.body.size", len(data)),
+			)
+			if t.MessageSpans {
+				_, s := tracer.Start(ctx, name, attrs)
+				s.End()
+			} else {
+				span.AddEvent(name, attrs)
+			}
+			return data, nil
+		}
+	}
+	c.UseInbound(record("websocket.receive"))
+	c.UseOutbound(record("websocket.send"))
+}
+
+// ConnContext returns a context with the span of the connection instrumented
+// by Instrument, or context.Background() if the connection is not
+// instrumented.
+func ConnContext(c *websocket.Conn) context.Context {
+	if v, ok := c.Get(contextKey); ok {
+		return v.(context.Context)
+	}
+	return context.Background()
+}
+
+// Emit writes an event like Conn.Emit, with the trace context of a new
+// producer span, a child of the span in ctx, injected into the meta of the
+// event.
+func (t *Tracer) Emit(ctx context.Context, c *websocket.Conn, event string, data interface{}) error {
+	ctx, span := t.tracer().Start(ctx, "websocket.emit "+event,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.operation.name", event)))
+	defer span.End()
+	e := websocket.Event{Event: event, Meta: make(map[string]string)}
+	if data != nil {
+		p, err := json.Marshal(data)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		e.Data = p
+	}
+	t.propagator().Inject(ctx, propagation.MapCarrier(e.Meta))
+	p, err := json.Marshal(&e)
+	if err == nil {
+		err = c.WriteMessage(websocket.TextMessage, p)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// EventHandler returns a handler for a websocket.Router that calls h with a
+// context carrying a consumer span for the event. The span is a child of the
+// trace context extracted from the meta of the event, and is linked to the
+// span of the connection. Events without trace context start a new trace
+// under the connection span.
+func (t *Tracer) EventHandler(h func(ctx context.Context, c *websocket.Conn, e *websocket.Event) error) websocket.EventHandler {
+	return func(c *websocket.Conn, e *websocket.Event) error {
+		connCtx := ConnContext(c)
+		ctx := connCtx
+		opts := []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attribute.String("messaging.operation.name", e.Event)),
+		}
+		if len(e.Meta) > 0 {
+			ctx = t.propagator().Extract(connCtx, propagation.MapCarrier(e.Meta))
+			if sc := trace.SpanContextFromContext(connCtx); sc.IsValid() && !sc.Equal(trace.SpanContextFromContext(ctx)) {
+				opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+			}
+		}
+		ctx, span := t.tracer().Start(ctx, "websocket.event "+e.Event, opts...)
+		defer span.End()
+		err := h(ctx, c, e)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+func messageTypeName(messageType int) string {
+	switch messageType {
+	case websocket.TextMessage:
+		return "text"
+	case websocket.BinaryMessage:
+		return "binary"
+	}
+	return "unknown"
+}
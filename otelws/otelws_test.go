@@ -0,0 +1,169 @@
+package otelws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracer(messageSpans bool) (*Tracer, *tracetest.SpanRecorder) {
+	sr := tracetest.NewSpanRecorder()
+	return &Tracer{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)),
+		Propagator:     propagation.TraceContext{},
+		MessageSpans:   messageSpans,
+	}, sr
+}
+
+func spanNamed(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, s := range spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// waitSpan waits for the span to end.
+func waitSpan(t *testing.T, sr *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if s := spanNamed(sr.Ended(), name); s != nil {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("span %q not ended", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	server, serverSpans := newTracer(false)
+	var router websocket.Router
+	router.Handle("ping", server.EventHandler(func(ctx context.Context, c *websocket.Conn, e *websocket.Event) error {
+		return server.Emit(ctx, c, "pong", nil)
+	}))
+	var upgrader websocket.Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := server.Upgrade(&upgrader, w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_ = router.Serve(c)
+	}))
+	defer s.Close()
+
+	client, clientSpans := newTracer(true)
+	ctx, root := client.TracerProvider.Tracer("test").Start(context.Background(), "root")
+	c, _, err := client.Dial(ctx, websocket.DefaultDialer, "ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Emit(ctx, c, "ping", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, p, err := c.ReadMessage(); err != nil || !strings.Contains(string(p), `"event":"pong"`) || !strings.Contains(string(p), "traceparent") {
+		t.Fatalf("read %s, %v", p, err)
+	}
+	c.Close()
+	root.End()
+
+	traceID := root.SpanContext().TraceID()
+	for _, name := range []string{"websocket.handshake", "websocket.conn", "websocket.event ping", "websocket.emit pong"} {
+		if got := waitSpan(t, serverSpans, name).SpanContext().TraceID(); got != traceID {
+			t.Errorf("server span %q has trace %s, want %s", name, got, traceID)
+		}
+	}
+	event := waitSpan(t, serverSpans, "websocket.event ping")
+	emit := waitSpan(t, clientSpans, "websocket.emit ping")
+	if event.Parent().SpanID() != emit.SpanContext().SpanID() || event.SpanKind() != trace.SpanKindConsumer {
+		t.Errorf("event span parent %s, kind %s; want parent %s", event.Parent().SpanID(), event.SpanKind(), emit.SpanContext().SpanID())
+	}
+	if len(event.Links()) != 1 {
+		t.Errorf("event span has %d links, want the connection span", len(event.Links()))
+	}
+
+	// The client records message spans, the server message events.
+	waitSpan(t, clientSpans, "websocket.send")
+	waitSpan(t, clientSpans, "websocket.receive")
+	conn := waitSpan(t, serverSpans, "websocket.conn")
+	var names []string
+	for _, e := range conn.Events() {
+		names = append(names, e.Name)
+	}
+	if strings.Join(names, ",") != "websocket.receive,websocket.send" {
+		t.Errorf("connection span events %v", names)
+	}
+}
+
+func TestHandshakeError(t *testing.T) {
+	tr, sr := newTracer(false)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = tr.Upgrade(&websocket.Upgrader{}, w, r, nil)
+	}))
+	defer s.Close()
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	span := waitSpan(t, sr, "websocket.handshake")
+	if span.Status().Code.String() != "Error" || len(span.Events()) == 0 {
+		t.Fatalf("handshake span status %v, events %v", span.Status(), span.Events())
+	}
+	if spanNamed(sr.Ended(), "websocket.conn") != nil {
+		t.Fatal("connection span for failed handshake")
+	}
+}
+
+func TestSample(t *testing.T) {
+	tr, sr := newTracer(true)
+	tr.Sample = func(messageType int, data []byte) bool { return string(data) != "skip" }
+	var upgrader websocket.Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+	c, _, err := tr.Dial(context.Background(), websocket.DefaultDialer, "ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"skip", "keep", "skip"} {
+		if err := c.WriteMessage(websocket.BinaryMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+	waitSpan(t, sr, "websocket.conn")
+	n := 0
+	for _, s := range sr.Ended() {
+		if s.Name() == "websocket.send" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("recorded %d messages, want 1", n)
+	}
+}
@@ -0,0 +1,480 @@
+package websocket
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrPollerUnsupported was returned by NewPoller on platforms without
+	// a readiness notification facility supported by the package. NewPoller
+	// now falls back to waiting in goroutines on these platforms, see
+	// PollerAvailable.
+	ErrPollerUnsupported = errors.New("websocket: poller not supported on this platform")
+
+	// ErrPollerClosed is returned when using a poller after Close was
+	// called.
+	ErrPollerClosed = errors.New("websocket: poller closed")
+
+	// ErrNoFileDescriptor is returned by Poller.Add for connections whose
+	// network connection does not expose a file descriptor, such as TLS
+	// connections and in-memory pipes, when the poller uses the readiness
+	// notification facility of the platform.
+	ErrNoFileDescriptor = errors.New("websocket: connection has no file descriptor")
+)
+
+// PollHandler handles a connection with data ready to be read. The handler
+// typically reads one message with ReadMessage or NextReader. A message
+// whose frames have not all arrived blocks the read, so servers should set
+// a read deadline. If the handler returns an error, the connection is
+// removed from the poller and closed.
+type PollHandler func(c *Conn) error
+
+// Poller calls a handler when connections have data to read, so that idle
+// connections do not hold a goroutine blocked in a read. A gateway holding
+// many mostly idle connections adds them to a poller after the upgrade and
+// returns from the HTTP handler; a small pool of workers reads the
+// connections as the operating system reports them readable.
+//
+// The handler of a connection is never called concurrently, and it is
+// called again without waiting for a notification while the connection has
+// buffered data.
+//
+// The poller uses epoll on Linux and kqueue on macOS and the BSDs, and needs
+// the file descriptor of the network connection, so TLS must be terminated
+// before the process, for example by a load balancer. On other platforms,
+// such as Windows, the poller waits for the data of each connection in a
+// goroutine instead, with the same API and handler semantics but without
+// the memory savings; PollerAvailable reports which is the case. Such a
+// poller accepts any connection, and clears the read deadline of the
+// connections while waiting for their data.
+//
+// Writes are not affected by the poller. Connections written from several
+// goroutines should use EnableWriteQueue or a Hub as usual.
+type Poller struct {
+	sys  *pollSys // nil when the poller waits in goroutines
+	work chan *pollEntry
+	quit chan struct{} // closed by Close
+	done chan struct{} // closed when the loop returns
+
+	mu     sync.Mutex
+	byFD   map[int]*pollEntry
+	byConn map[*Conn]*pollEntry
+	closed bool
+}
+
+// pollEntry is a connection registered with a poller.
+type pollEntry struct {
+	conn    *Conn
+	fd      int
+	handler PollHandler
+	busy    atomic.Bool // handed to a worker
+
+	// The state of the goroutine waiting for data when the poller has no
+	// readiness notification facility.
+	rearm   chan struct{} // the handler returned
+	removed chan struct{} // closed when the entry is unregistered
+	exited  chan struct{} // closed when the goroutine returns
+}
+
+// PollerAvailable reports whether the Poller uses a readiness notification
+// facility of the platform, epoll or kqueue, so that idle connections do
+// not hold a goroutine. Otherwise the Poller works the same, with a
+// goroutine waiting for the data of each connection.
+func PollerAvailable() bool {
+	return pollNative
+}
+
+// NewPoller returns a poller calling the handlers from the given number of
+// worker goroutines. If workers is zero or negative, runtime.GOMAXPROCS(0)
+// workers are used.
+func NewPoller(workers int) (*Poller, error) {
+	var sys *pollSys
+	if pollNative {
+		var err error
+		if sys, err = newPollSys(); err != nil {
+			return nil, err
+		}
+	}
+	return newPoller(workers, sys), nil
+}
+
+// newPoller returns a poller using sys, or waiting in goroutines if sys is
+// nil.
+func newPoller(workers int, sys *pollSys) *Poller {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &Poller{
+		sys:    sys,
+		work:   make(chan *pollEntry, workers),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+		byFD:   make(map[int]*pollEntry),
+		byConn: make(map[*Conn]*pollEntry),
+	}
+	if sys != nil {
+		go p.loop()
+	} else {
+		close(p.done)
+	}
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// connFD returns the file descriptor of the network connection of c.
+func connFD(c *Conn) (int, error) {
+	sc, ok := c.conn.(syscall.Conn)
+	if !ok {
+		return 0, ErrNoFileDescriptor
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	fd := -1
+	if err := rc.Control(func(s uintptr) { fd = int(s) }); err != nil {
+		return 0, err
+	}
+	if fd < 0 {
+		return 0, ErrNoFileDescriptor
+	}
+	return fd, nil
+}
+
+// Add registers the connection with the poller. The handler is called each
+// time the connection has data to read. Adding a registered connection has
+// no effect. The application must not read from the connection outside of
+// the handler, and should call Remove before closing the connection itself;
+// the connections closed by the poller after a handler error are removed.
+func (p *Poller) Add(c *Conn, h PollHandler) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if p.sys == nil {
+		return p.addWatched(c, h)
+	}
+	fd, err := connFD(c)
+	if err != nil {
+		return err
+	}
+	e := &pollEntry{conn: c, fd: fd, handler: h}
+	// Data read with the handshake raises no notification. The entry is
+	// handed to a worker directly and the notifications until the worker
+	// rearms it are dropped.
+	buffered := c.readBuffered()
+	e.busy.Store(buffered)
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPollerClosed
+	}
+	if _, ok := p.byConn[c]; ok {
+		p.mu.Unlock()
+		return nil
+	}
+	if old, ok := p.byFD[fd]; ok {
+		// The descriptor of a connection closed without Remove was
+		// reused; the kernel dropped its registration.
+		delete(p.byConn, old.conn)
+		delete(p.byFD, fd)
+	}
+	if err := p.sys.add(fd); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	p.byFD[fd] = e
+	p.byConn[c] = e
+	p.mu.Unlock()
+	if buffered {
+		p.hand(e)
+	}
+	return nil
+}
+
+// Remove unregisters the connection from the poller. Remove does not close
+// the connection. A handler running for the connection completes.
+//
+// A poller without readiness notification facility stops waiting for the
+// data of the connection with an expired read deadline; set a new read
+// deadline before reading the connection.
+func (p *Poller) Remove(c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	p.mu.Lock()
+	e, ok := p.byConn[c]
+	if !ok {
+		p.mu.Unlock()
+		return nil
+	}
+	p.unregister(e)
+	if p.sys == nil {
+		p.mu.Unlock()
+		e.stopWatch()
+		return nil
+	}
+	defer p.mu.Unlock()
+	return p.sys.del(e.fd)
+}
+
+// Len returns the number of connections registered with the poller.
+func (p *Poller) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.byConn)
+}
+
+// Close stops the poller. Close does not close the registered connections.
+// Running handlers complete.
+func (p *Poller) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	var watched []*pollEntry
+	if p.sys == nil {
+		for _, e := range p.byConn {
+			p.unregister(e)
+			watched = append(watched, e)
+		}
+	}
+	p.byFD = nil
+	p.byConn = nil
+	p.mu.Unlock()
+	close(p.quit)
+	if p.sys == nil {
+		for _, e := range watched {
+			e.stopWatch()
+		}
+		return nil
+	}
+	err := p.sys.wake()
+	<-p.done
+	if cerr := p.sys.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loop waits for notifications and hands the ready connections to the
+// workers.
+func (p *Poller) loop() {
+	defer close(p.done)
+	for {
+		err := p.sys.wait(func(fd int) {
+			p.mu.Lock()
+			e := p.byFD[fd]
+			p.mu.Unlock()
+			if e != nil {
+				p.dispatch(e)
+			}
+		})
+		if err != nil {
+			return
+		}
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+	}
+}
+
+// dispatch hands e to a worker unless a worker already has it. The
+// notification of a connection fires once until the worker rearms it, so
+// a notification is dropped only for a connection dispatched by Add.
+func (p *Poller) dispatch(e *pollEntry) {
+	if e.busy.CompareAndSwap(false, true) {
+		p.hand(e)
+	}
+}
+
+// hand passes e to a worker.
+func (p *Poller) hand(e *pollEntry) {
+	select {
+	case <-e.conn.closed:
+		// Closed without Remove.
+		p.forget(e)
+	case p.work <- e:
+	case <-e.removed:
+	case <-p.quit:
+	}
+}
+
+func (p *Poller) worker() {
+	for {
+		select {
+		case e := <-p.work:
+			p.serve(e)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// serve calls the handler until the connection has no buffered data and
+// rearms the notification for the next data. The connection is left alone
+// once a handler removed it, so that the application can read it.
+func (p *Poller) serve(e *pollEntry) {
+	for {
+		if err := e.handler(e.conn); err != nil {
+			p.forget(e)
+			_ = e.conn.Close()
+			return
+		}
+		if !p.registered(e) {
+			return
+		}
+		if !e.conn.readBuffered() {
+			break
+		}
+	}
+	e.busy.Store(false)
+	if p.sys == nil {
+		e.rearm <- struct{}{}
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.byFD[e.fd] == e {
+		if err := p.sys.rearm(e.fd); err != nil {
+			p.unregister(e)
+		}
+	}
+}
+
+// registered reports whether e is still registered.
+func (p *Poller) registered(e *pollEntry) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.byConn[e.conn] == e
+}
+
+// forget unregisters e if it is still registered.
+func (p *Poller) forget(e *pollEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sys == nil {
+		if p.byConn[e.conn] == e {
+			p.unregister(e)
+		}
+		return
+	}
+	if p.byFD[e.fd] == e {
+		p.unregister(e)
+		_ = p.sys.del(e.fd)
+	}
+}
+
+// unregister deletes e from the maps. The poller lock must be held.
+func (p *Poller) unregister(e *pollEntry) {
+	if p.sys == nil {
+		close(e.removed)
+	} else {
+		delete(p.byFD, e.fd)
+	}
+	delete(p.byConn, e.conn)
+}
+
+// readBuffered reports whether the connection has read data buffered.
+func (c *Conn) readBuffered() bool {
+	return c.br != nil && c.br.Buffered() > 0
+}
+
+// addWatched registers the connection with a goroutine waiting for its data,
+// for a poller without readiness notification facility.
+func (p *Poller) addWatched(c *Conn, h PollHandler) error {
+	e := &pollEntry{
+		conn:    c,
+		handler: h,
+		rearm:   make(chan struct{}, 1),
+		removed: make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	buffered := c.readBuffered()
+	e.busy.Store(buffered)
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPollerClosed
+	}
+	if _, ok := p.byConn[c]; ok {
+		p.mu.Unlock()
+		return nil
+	}
+	p.byConn[c] = e
+	p.mu.Unlock()
+	go p.watch(e, buffered)
+	return nil
+}
+
+// watch waits for the data of the connection and hands it to a worker, until
+// the entry is unregistered. The goroutine is the only reader of the
+// connection while no worker has it.
+func (p *Poller) watch(e *pollEntry, handed bool) {
+	defer close(e.exited)
+	if handed {
+		p.hand(e)
+	}
+	for {
+		if handed {
+			select {
+			case <-e.rearm:
+			case <-e.removed:
+				return
+			}
+		}
+		err := e.awaitData()
+		select {
+		case <-e.removed:
+			return
+		default:
+		}
+		e.busy.Store(true)
+		p.hand(e)
+		handed = true
+		if err != nil {
+			// The handler got the read error; a handler that does not
+			// fail on it would be called again forever.
+			select {
+			case <-e.rearm:
+				p.forget(e)
+			case <-e.removed:
+			}
+			return
+		}
+	}
+}
+
+// awaitData waits until the connection has data to read, or an error.
+func (e *pollEntry) awaitData() error {
+	c := e.conn
+	_ = c.conn.SetReadDeadline(time.Time{})
+	select {
+	case <-e.removed:
+		// Do not wait past the deadline set by stopWatch.
+		return nil
+	default:
+	}
+	if c.readPool != nil {
+		return c.awaitReadBuffer()
+	}
+	_, err := c.br.Peek(1)
+	return err
+}
+
+// stopWatch stops the goroutine watching an unregistered entry and waits for
+// it to return. A handler running for the connection is not waited for.
+func (e *pollEntry) stopWatch() {
+	_ = e.conn.conn.SetReadDeadline(time.Now())
+	<-e.exited
+}
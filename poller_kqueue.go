@@ -0,0 +1,106 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package websocket
+
+import (
+	"syscall"
+)
+
+// pollNative reports whether newPollSys is implemented, see PollerAvailable.
+const pollNative = true
+
+// pollSys is a kqueue instance. The connections are registered in one-shot
+// mode, so a ready connection is reported once until it is rearmed. The read
+// end of a pipe is registered to wake the wait on Close.
+type pollSys struct {
+	kq     int
+	wakeR  int
+	wakeW  int
+	events []syscall.Kevent_t
+}
+
+func newPollSys() (*pollSys, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(kq)
+	var pipe [2]int
+	if err := syscall.Pipe(pipe[:]); err != nil {
+		syscall.Close(kq)
+		return nil, err
+	}
+	s := &pollSys{kq: kq, wakeR: pipe[0], wakeW: pipe[1], events: make([]syscall.Kevent_t, 128)}
+	for _, fd := range pipe {
+		syscall.CloseOnExec(fd)
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+	if err := s.ctl(s.wakeR, syscall.EV_ADD); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ctl applies a change to the read filter of the descriptor.
+func (s *pollSys) ctl(fd int, flags int) error {
+	var ev [1]syscall.Kevent_t
+	syscall.SetKevent(&ev[0], fd, syscall.EVFILT_READ, flags)
+	for {
+		_, err := syscall.Kevent(s.kq, ev[:], nil, nil)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func (s *pollSys) add(fd int) error {
+	return s.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (s *pollSys) rearm(fd int) error {
+	return s.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (s *pollSys) del(fd int) error {
+	err := s.ctl(fd, syscall.EV_DELETE)
+	if err == syscall.ENOENT {
+		// The one-shot event fired and was not rearmed.
+		return nil
+	}
+	return err
+}
+
+// wait waits for notifications and calls ready with the descriptor of each
+// ready connection. It returns an error after wake was called.
+func (s *pollSys) wait(ready func(fd int)) error {
+	n, err := syscall.Kevent(s.kq, nil, s.events, nil)
+	if err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, ev := range s.events[:n] {
+		fd := int(ev.Ident)
+		if fd == s.wakeR {
+			return ErrPollerClosed
+		}
+		ready(fd)
+	}
+	return nil
+}
+
+func (s *pollSys) wake() error {
+	_, err := syscall.Write(s.wakeW, []byte{0})
+	return err
+}
+
+func (s *pollSys) close() error {
+	syscall.Close(s.wakeR)
+	syscall.Close(s.wakeW)
+	return syscall.Close(s.kq)
+}
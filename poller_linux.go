@@ -0,0 +1,85 @@
+//go:build linux
+
+package websocket
+
+import (
+	"syscall"
+)
+
+// pollNative reports whether newPollSys is implemented, see PollerAvailable.
+const pollNative = true
+
+// pollSys is an epoll instance. The connections are registered in one-shot
+// mode, so a ready connection is reported once until it is rearmed. The read
+// end of a pipe is registered to wake the wait on Close.
+type pollSys struct {
+	epfd   int
+	wakeR  int
+	wakeW  int
+	events []syscall.EpollEvent
+}
+
+const pollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+func newPollSys() (*pollSys, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	s := &pollSys{epfd: epfd, wakeR: pipe[0], wakeW: pipe[1], events: make([]syscall.EpollEvent, 128)}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(s.wakeR)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, s.wakeR, &ev); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *pollSys) add(fd int) error {
+	ev := syscall.EpollEvent{Events: pollEvents, Fd: int32(fd)}
+	return syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+func (s *pollSys) rearm(fd int) error {
+	ev := syscall.EpollEvent{Events: pollEvents, Fd: int32(fd)}
+	return syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_MOD, fd, &ev)
+}
+
+func (s *pollSys) del(fd int) error {
+	return syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait waits for notifications and calls ready with the descriptor of each
+// ready connection. It returns an error after wake was called.
+func (s *pollSys) wait(ready func(fd int)) error {
+	n, err := syscall.EpollWait(s.epfd, s.events, -1)
+	if err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, ev := range s.events[:n] {
+		if int(ev.Fd) == s.wakeR {
+			return ErrPollerClosed
+		}
+		ready(int(ev.Fd))
+	}
+	return nil
+}
+
+func (s *pollSys) wake() error {
+	_, err := syscall.Write(s.wakeW, []byte{0})
+	return err
+}
+
+func (s *pollSys) close() error {
+	syscall.Close(s.wakeR)
+	syscall.Close(s.wakeW)
+	return syscall.Close(s.epfd)
+}
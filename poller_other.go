@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package websocket
+
+// pollNative reports whether newPollSys is implemented, see PollerAvailable.
+// The poller waits for the connections in goroutines on this platform.
+const pollNative = false
+
+// pollSys is not implemented on this platform.
+type pollSys struct{}
+
+func newPollSys() (*pollSys, error) { return nil, ErrPollerUnsupported }
+
+func (s *pollSys) add(fd int) error              { return ErrPollerUnsupported }
+func (s *pollSys) rearm(fd int) error            { return ErrPollerUnsupported }
+func (s *pollSys) del(fd int) error              { return ErrPollerUnsupported }
+func (s *pollSys) wait(ready func(fd int)) error { return ErrPollerUnsupported }
+func (s *pollSys) wake() error                   { return nil }
+func (s *pollSys) close() error                  { return nil }
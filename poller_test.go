@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPoller(t *testing.T) *Poller {
+	t.Helper()
+	p, err := NewPoller(2)
+	if err == ErrPollerUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+// pollEcho echoes a message, and fails on the message "quit".
+func pollEcho(c *Conn) error {
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	mt, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if string(p) == "quit" {
+		return errors.New("quit")
+	}
+	return c.WriteMessage(mt, p)
+}
+
+func TestPoller(t *testing.T) {
+	if !PollerAvailable() {
+		t.Skip("no readiness notification facility")
+	}
+	testPoller(t, newTestPoller(t))
+}
+
+func TestPollerFallback(t *testing.T) {
+	p := newPoller(2, nil)
+	t.Cleanup(func() { _ = p.Close() })
+	testPoller(t, p)
+}
+
+func testPoller(t *testing.T, p *Poller) {
+	var upgrader Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		if err := p.Add(c, pollEcho); err != nil {
+			t.Error(err)
+			c.Close()
+		}
+	}))
+	defer s.Close()
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	const n = 20
+	clients := make([]*Conn, n)
+	for i := range clients {
+		c, _, err := DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+	for round := 0; round < 3; round++ {
+		for _, c := range clients {
+			if err := c.WriteMessage(TextMessage, []byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, c := range clients {
+			if got := readString(t, c); got != "ping" {
+				t.Fatalf("got %q, want ping", got)
+			}
+		}
+	}
+	if p.Len() != n {
+		t.Errorf("Len = %d, want %d", p.Len(), n)
+	}
+
+	// Messages sent together are handled without a notification each.
+	c := clients[0]
+	for _, m := range []string{"a", "b", "c"} {
+		_ = c.WriteMessage(TextMessage, []byte(m))
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if got := readString(t, c); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	// A handler error closes the connection.
+	_ = c.WriteMessage(TextMessage, []byte("quit"))
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := c.ReadMessage(); err == nil {
+		t.Fatal("read succeeded after the handler failed")
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.Len() != n-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if p.Len() != n-1 {
+		t.Errorf("Len = %d after a handler error, want %d", p.Len(), n-1)
+	}
+}
+
+func TestPollerErrors(t *testing.T) {
+	if !PollerAvailable() {
+		t.Skip("no readiness notification facility")
+	}
+	p := newTestPoller(t)
+	s, _ := newPipeConns()
+	if err := p.Add(s, pollEcho); err != ErrNoFileDescriptor {
+		t.Errorf("Add(pipe) returned %v, want %v", err, ErrNoFileDescriptor)
+	}
+	if err := p.Add(nil, pollEcho); err != ErrNilConn {
+		t.Errorf("Add(nil) returned %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+}
+
+func TestPollerFallbackRemove(t *testing.T) {
+	p := newPoller(1, nil)
+	defer p.Close()
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	removed := make(chan struct{})
+	handler := func(c *Conn) error {
+		_, msg, err := c.ReadMessage()
+		if err == nil && string(msg) == "remove" {
+			err = p.Remove(c)
+			close(removed)
+		}
+		return err
+	}
+	if err := p.Add(s, handler); err != nil {
+		t.Fatal(err)
+	}
+	writes := make(chan string)
+	defer close(writes)
+	go func() {
+		for msg := range writes {
+			_ = c.WriteMessage(TextMessage, []byte(msg))
+		}
+	}()
+
+	// After Remove, the application reads the connection itself.
+	writes <- "remove"
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+	if p.Len() != 0 {
+		t.Errorf("Len = %d after Remove, want 0", p.Len())
+	}
+	writes <- "direct"
+	if got := readString(t, s); got != "direct" {
+		t.Errorf("got %q after Remove, want direct", got)
+	}
+
+	// Close stops waiting for the registered connections.
+	if err := p.Add(s, pollEcho); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(s, pollEcho); err != ErrPollerClosed {
+		t.Errorf("Add after Close returned %v, want %v", err, ErrPollerClosed)
+	}
+}
@@ -0,0 +1,339 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPoolSize           = 4
+	defaultPoolPingInterval   = 15 * time.Second
+	defaultPoolPingTimeout    = 5 * time.Second
+	defaultPoolWriteQueueSize = 256
+)
+
+// ErrPoolClosed is returned when using a Pool after Close.
+var ErrPoolClosed = errors.New("websocket: pool closed")
+
+// Pool maintains a fixed number of client connections to a server for
+// service-to-service RPC. Calls and events are spread over the connected
+// connections in turn. Each connection is served by Router, health-checked
+// with keepalive pings and replaced with a new connection, dialed with
+// exponential backoff and jitter, when it fails.
+//
+// The methods of Pool may be called concurrently. Set the fields before
+// calling DialContext and do not modify them after.
+type Pool struct {
+	// URL is the URL of the WebSocket server.
+	URL string
+
+	// Header specifies the request headers for each handshake.
+	Header http.Header
+
+	// Dialer specifies the dialer used to connect. If nil, DefaultDialer is
+	// used.
+	Dialer *Dialer
+
+	// Size is the number of connections. If zero, a default of 4 is used.
+	Size int
+
+	// Router serves the connections, receiving the replies to calls and
+	// the events sent by the server. If nil, a Router without handlers that
+	// ignores the events it cannot dispatch is used.
+	Router *Router
+
+	// PingInterval and PingTimeout configure the keepalive of the
+	// connections, see Conn.EnableKeepalive. If zero, defaults of 15 and 5
+	// seconds are used.
+	PingInterval, PingTimeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay between attempts to replace
+	// a failed connection. If zero, defaults of 500 milliseconds and 30
+	// seconds are used.
+	MinBackoff, MaxBackoff time.Duration
+
+	// WriteQueueSize is the size of the write queue of each connection, see
+	// Conn.EnableWriteQueue. If zero, a default of 256 is used.
+	WriteQueueSize int
+
+	// OnConnect is called after each successful handshake, before the
+	// connection is used by the pool. If OnConnect returns an error, the
+	// connection is closed and the attempt is considered failed.
+	OnConnect func(c *Conn) error
+
+	// OnDisconnect is called with the error of a connection that failed.
+	OnDisconnect func(c *Conn, err error)
+
+	next atomic.Uint64
+
+	mu      sync.Mutex
+	conns   []*Conn       // nil for the connections being replaced
+	changed chan struct{} // closed when a connection is added
+	closed  bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// DialContext dials the connections of the pool. DialContext returns the
+// error of the first connection that failed; the other connections are
+// replaced in the background. The context only bounds the initial dials.
+func (p *Pool) DialContext(ctx context.Context) error {
+	size := p.Size
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	if p.ctx != nil {
+		p.mu.Unlock()
+		return errors.New("websocket: pool already dialed")
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.conns = make([]*Conn, size)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+
+	errs := make([]error, size)
+	var wg sync.WaitGroup
+	for i := range size {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := p.connect(ctx)
+			if err == nil {
+				err = p.setConn(i, c)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	var first error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if err == ErrPoolClosed {
+			return err
+		}
+		if first == nil {
+			first = err
+		}
+		p.wg.Add(1)
+		go p.replace(i)
+	}
+	return first
+}
+
+// connect dials the server and prepares the connection for the pool.
+func (p *Pool) connect(ctx context.Context) (*Conn, error) {
+	d := p.Dialer
+	if d == nil {
+		d = DefaultDialer
+	}
+	c, _, err := d.DialContext(ctx, p.URL, p.Header)
+	if err != nil {
+		return nil, err
+	}
+	queueSize := p.WriteQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultPoolWriteQueueSize
+	}
+	interval, timeout := p.PingInterval, p.PingTimeout
+	if interval <= 0 {
+		interval = defaultPoolPingInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultPoolPingTimeout
+	}
+	if err = c.EnableWriteQueue(queueSize, OverflowBlock); err == nil {
+		err = c.EnableKeepalive(interval, timeout)
+	}
+	if err == nil && p.OnConnect != nil {
+		err = p.OnConnect(c)
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// setConn makes c the connection of slot i and starts serving it.
+func (p *Pool) setConn(i int, c *Conn) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = c.Close()
+		return ErrPoolClosed
+	}
+	p.conns[i] = c
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.wg.Add(1)
+	p.mu.Unlock()
+	go p.serve(i, c)
+	return nil
+}
+
+// serve serves the connection of slot i until it fails, then replaces it.
+func (p *Pool) serve(i int, c *Conn) {
+	defer p.wg.Done()
+	r := p.Router
+	if r == nil {
+		r = &Router{OnError: func(*Conn, *Event, error) {}}
+	}
+	err := r.Serve(c)
+	_ = c.Close()
+
+	p.mu.Lock()
+	closed := p.closed
+	if !closed {
+		p.conns[i] = nil
+		p.wg.Add(1)
+	}
+	p.mu.Unlock()
+	if closed {
+		return
+	}
+	if p.OnDisconnect != nil {
+		p.OnDisconnect(c, err)
+	}
+	p.replace(i)
+}
+
+// replace dials a new connection for slot i until it succeeds or the pool
+// is closed.
+func (p *Pool) replace(i int) {
+	defer p.wg.Done()
+	minBackoff, maxBackoff := p.MinBackoff, p.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	backoff := minBackoff
+	for {
+		// Full jitter: sleep a random duration up to the current backoff.
+		t := time.NewTimer(rand.N(backoff) + 1)
+		select {
+		case <-t.C:
+		case <-p.ctx.Done():
+			t.Stop()
+			return
+		}
+		c, err := p.connect(p.ctx)
+		if err == nil {
+			_ = p.setConn(i, c)
+			return
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// Conn returns a connected connection of the pool, waiting until one is
+// available or ctx is done. Successive calls return the connections in
+// turn.
+func (p *Pool) Conn(ctx context.Context) (*Conn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if p.ctx == nil {
+			p.mu.Unlock()
+			return nil, ErrNotConnected
+		}
+		n := uint64(len(p.conns))
+		start := p.next.Add(1)
+		for j := uint64(0); j < n; j++ {
+			if c := p.conns[(start+j)%n]; c != nil {
+				p.mu.Unlock()
+				return c, nil
+			}
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Call calls the method on a connection of the pool, see Conn.Call. A call
+// aborted because its connection failed is not retried, since the server
+// may have received it.
+func (p *Pool) Call(ctx context.Context, method string, params, result interface{}) error {
+	if p == nil {
+		return ErrPoolClosed
+	}
+	c, err := p.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	return c.Call(ctx, method, params, result)
+}
+
+// Send emits the event on a connection of the pool, see Conn.Emit, waiting
+// until a connection is available or ctx is done.
+func (p *Pool) Send(ctx context.Context, event string, data interface{}) error {
+	if p == nil {
+		return ErrPoolClosed
+	}
+	c, err := p.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	return c.Emit(event, data)
+}
+
+// Len returns the number of connected connections.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, c := range p.conns {
+		if c != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Close closes the connections and stops replacing them. Close waits for
+// the goroutines of the pool to exit.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	conns := p.conns
+	p.conns = nil
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.mu.Unlock()
+	for _, c := range conns {
+		if c != nil {
+			_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(writeWait))
+			_ = c.Close()
+		}
+	}
+	p.wg.Wait()
+	return nil
+}
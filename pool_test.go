@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	var mu sync.Mutex
+	served := make(map[string]bool)
+	conns := make(chan *Conn, 8)
+	router := &Router{}
+	OnCall(router, "whoami", func(c *Conn, params struct{}) (string, error) {
+		mu.Lock()
+		served[c.ID()] = true
+		mu.Unlock()
+		return c.ID(), nil
+	})
+	var upgrader Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := c.EnableWriteQueue(16, OverflowBlock); err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- c
+		_ = router.Serve(c)
+	}))
+	defer s.Close()
+
+	disconnected := make(chan struct{}, 1)
+	p := &Pool{
+		URL:        makeWsProto(s.URL),
+		Size:       2,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+		OnDisconnect: func(c *Conn, err error) {
+			disconnected <- struct{}{}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.DialContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if n := p.Len(); n != 2 {
+		t.Fatalf("Len() = %d, want 2", n)
+	}
+	for i := 0; i < 4; i++ {
+		var id string
+		if err := p.Call(ctx, "whoami", nil, &id); err != nil || id == "" {
+			t.Fatalf("Call() = %q, %v", id, err)
+		}
+	}
+	mu.Lock()
+	if len(served) != 2 {
+		t.Errorf("calls served by %d connections, want 2", len(served))
+	}
+	mu.Unlock()
+
+	// A failed connection is replaced.
+	(<-conns).Close()
+	<-disconnected
+	<-conns
+	for p.Len() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Call(ctx, "whoami", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Close()
+	if err := p.Call(ctx, "whoami", nil, nil); err != ErrPoolClosed {
+		t.Fatalf("Call after Close returned %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestPoolWaitsForConn(t *testing.T) {
+	p := &Pool{URL: "ws://127.0.0.1:1", Size: 1, MinBackoff: time.Hour}
+	if err := p.DialContext(context.Background()); err == nil {
+		t.Fatal("DialContext to a closed port succeeded")
+	}
+	defer p.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Send(ctx, "event", nil); err != context.DeadlineExceeded {
+		t.Fatalf("Send without a connection returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,23 @@
+package websocket
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// The keys of the profiler labels set by the package, see
+// Router.ProfilerLabels and Hub.ProfilerLabels. The samples of CPU and
+// goroutine profiles carry the labels, so that a profile can be broken down
+// by connection, room or event:
+//
+//	go tool pprof -tagfocus websocket.room=lobby cpu.pprof
+const (
+	LabelConn  = "websocket.conn"  // the ID of the connection
+	LabelRoom  = "websocket.room"  // the room of a hub broadcast
+	LabelEvent = "websocket.event" // the name of an event handled by a router
+)
+
+// connLabelContext returns a context carrying the label of the connection.
+func connLabelContext(c *Conn) context.Context {
+	return pprof.WithLabels(context.Background(), pprof.Labels(LabelConn, c.ID()))
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goroutineLabels returns the goroutine profile with the labels of the
+// goroutines.
+func goroutineLabels(t *testing.T) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRouterProfilerLabels(t *testing.T) {
+	profiles := make(chan string, 1)
+	server := &Router{ProfilerLabels: true}
+	server.Handle("profile", func(c *Conn, e *Event) error {
+		profiles <- goroutineLabels(t)
+		return nil
+	})
+	s, c := newRPCConns(t, server, &Router{})
+
+	if err := c.Emit("profile", nil); err != nil {
+		t.Fatal(err)
+	}
+	var profile string
+	select {
+	case profile = <-profiles:
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+	want := `"websocket.conn":"` + s.ID() + `"`
+	if !strings.Contains(profile, want) || !strings.Contains(profile, `"websocket.event":"profile"`) {
+		t.Errorf("goroutine profile of the handler lacks the labels %s and the event", want)
+	}
+}
+
+func TestHubProfilerLabels(t *testing.T) {
+	h := &Hub{ProfilerLabels: true}
+	defer h.Close()
+	server, client := newPipeConns()
+	defer client.Close()
+	if err := h.Join("lobby", server); err != nil {
+		t.Fatal(err)
+	}
+	viewServer, viewClient := newPipeConns()
+	defer viewClient.Close()
+	upper := func(c *Conn, msg []byte) ([]byte, bool) { return bytes.ToUpper(msg), true }
+	if err := h.JoinWithView("lobby", viewServer, upper); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write blocks until the client reads, so the labeled write shows
+	// in the goroutine profile.
+	if err := h.Broadcast("lobby", TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// Both writes, including that of the view, are labeled with the room.
+	want := `"websocket.room":"lobby"`
+	deadline := time.Now().Add(time.Second)
+	for strings.Count(goroutineLabels(t), want) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("fewer than 2 goroutines labeled %s", want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := readString(t, client); got != "hello" {
+		t.Errorf("got %q", got)
+	}
+	if got := readString(t, viewClient); got != "HELLO" {
+		t.Errorf("view got %q", got)
+	}
+}
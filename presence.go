@@ -0,0 +1,340 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Member describes a connection present in a room.
+type Member struct {
+	// ID identifies the connection. IDs are generated by the hub and are
+	// unique across nodes.
+	ID string `json:"id"`
+
+	// User and Meta are the values returned by Hub.PresenceInfo for the
+	// connection.
+	User string            `json:"user,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Node identifies the hub the connection is registered with.
+	Node string `json:"node"`
+
+	// JoinedAt is the time the connection joined the room.
+	JoinedAt time.Time `json:"joined_at"`
+
+	// LastSeen is the time the member was last confirmed alive by its hub.
+	LastSeen time.Time `json:"-"`
+}
+
+// RoomMember is a member of a named room.
+type RoomMember struct {
+	Room   string `json:"room"`
+	Member Member `json:"member"`
+}
+
+// PresenceStore records the members of rooms for a Hub. The store of a hub
+// holds the members of every node sharing the hub's Broker.
+//
+// Implementations must be safe for concurrent use.
+type PresenceStore interface {
+	// Add adds or refreshes the member of room and reports whether the
+	// member is new.
+	Add(room string, m Member) (bool, error)
+
+	// Remove removes the member with the ID from room and reports whether
+	// the member was present.
+	Remove(room, id string) (bool, error)
+
+	// Members returns the members of room.
+	Members(room string) ([]Member, error)
+
+	// Expire removes and returns the members last seen before the given
+	// time.
+	Expire(before time.Time) ([]RoomMember, error)
+}
+
+// MemoryPresenceStore is a PresenceStore that keeps the members in memory.
+// The zero value is ready to use.
+type MemoryPresenceStore struct {
+	mu    sync.Mutex
+	rooms map[string]map[string]Member
+}
+
+var _ PresenceStore = (*MemoryPresenceStore)(nil)
+
+// Add implements PresenceStore.
+func (s *MemoryPresenceStore) Add(room string, m Member) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rooms == nil {
+		s.rooms = make(map[string]map[string]Member)
+	}
+	members := s.rooms[room]
+	if members == nil {
+		members = make(map[string]Member)
+		s.rooms[room] = members
+	}
+	_, ok := members[m.ID]
+	members[m.ID] = m
+	return !ok, nil
+}
+
+// Remove implements PresenceStore.
+func (s *MemoryPresenceStore) Remove(room, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := s.rooms[room]
+	if _, ok := members[id]; !ok {
+		return false, nil
+	}
+	delete(members, id)
+	if len(members) == 0 {
+		delete(s.rooms, room)
+	}
+	return true, nil
+}
+
+// Members implements PresenceStore.
+func (s *MemoryPresenceStore) Members(room string) ([]Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := make([]Member, 0, len(s.rooms[room]))
+	for _, m := range s.rooms[room] {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// Expire implements PresenceStore.
+func (s *MemoryPresenceStore) Expire(before time.Time) ([]RoomMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []RoomMember
+	for room, members := range s.rooms {
+		for id, m := range members {
+			if m.LastSeen.Before(before) {
+				delete(members, id)
+				expired = append(expired, RoomMember{Room: room, Member: m})
+			}
+		}
+		if len(members) == 0 {
+			delete(s.rooms, room)
+		}
+	}
+	return expired, nil
+}
+
+// Kinds of presence updates.
+const (
+	presenceJoin  = "join"
+	presenceLeave = "leave"
+	presenceSync  = "sync" // the complete list of a node's members
+)
+
+// presenceUpdate is a change to the presence store. Updates are exchanged
+// between hubs as JSON in broker messages.
+type presenceUpdate struct {
+	Kind    string       `json:"kind"`
+	Members []RoomMember `json:"members"`
+	remote  bool
+}
+
+// presenceState holds the presence updates of a hub waiting to be applied
+// to the store.
+type presenceState struct {
+	mu      sync.Mutex // guards pending
+	pending []presenceUpdate
+	applyMu sync.Mutex // serializes applying updates
+	cancel  func()     // stops the heartbeat, guarded by the hub lock
+}
+
+// presenceMember returns the presence record for a connection registered
+// with the hub.
+func (h *Hub) presenceMember(c *Conn) Member {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	m := Member{ID: hex.EncodeToString(id[:])}
+	if h.PresenceInfo != nil {
+		m.User, m.Meta = h.PresenceInfo(c)
+	}
+	return m
+}
+
+// queuePresence queues a presence update for the members of a room. The
+// shard lock of the connection must be held so that its updates are applied
+// in order.
+func (h *Hub) queuePresence(kind, room string, hc *hubClient) {
+	if h.PresenceStore == nil {
+		return
+	}
+	m := hc.member
+	m.Node = h.id
+	m.JoinedAt = hc.joined[room]
+	h.presence.mu.Lock()
+	h.presence.pending = append(h.presence.pending, presenceUpdate{Kind: kind, Members: []RoomMember{{Room: room, Member: m}}})
+	h.presence.mu.Unlock()
+}
+
+// applyPresence applies the queued presence updates to the store, calls the
+// event callbacks and publishes the local updates to the broker. It returns
+// after the updates queued before the call are applied.
+func (h *Hub) applyPresence() {
+	if h.PresenceStore == nil {
+		return
+	}
+	h.presence.applyMu.Lock()
+	defer h.presence.applyMu.Unlock()
+	for {
+		h.presence.mu.Lock()
+		pending := h.presence.pending
+		h.presence.pending = nil
+		h.presence.mu.Unlock()
+		if len(pending) == 0 {
+			return
+		}
+		for _, u := range pending {
+			h.applyPresenceUpdate(u)
+		}
+	}
+}
+
+// applyPresenceUpdate applies a single presence update. The presence apply
+// lock must be held.
+func (h *Hub) applyPresenceUpdate(u presenceUpdate) {
+	now := time.Now()
+	for _, rm := range u.Members {
+		rm.Member.LastSeen = now
+		switch u.Kind {
+		case presenceJoin, presenceSync:
+			added, err := h.PresenceStore.Add(rm.Room, rm.Member)
+			if err != nil {
+				h.presenceError(err)
+			} else if added && h.OnJoin != nil {
+				h.OnJoin(rm.Room, rm.Member)
+			}
+		case presenceLeave:
+			removed, err := h.PresenceStore.Remove(rm.Room, rm.Member.ID)
+			if err != nil {
+				h.presenceError(err)
+			} else if removed && h.OnLeave != nil {
+				h.OnLeave(rm.Room, rm.Member)
+			}
+		}
+	}
+	if u.remote || h.Broker == nil {
+		return
+	}
+	p, err := json.Marshal(u)
+	if err != nil {
+		h.presenceError(err)
+		return
+	}
+	if err := h.publishMessage(&BrokerMessage{Presence: true, Data: p}); err != nil {
+		h.presenceError(err)
+	}
+}
+
+// deliverPresence applies a presence update received from the broker.
+func (h *Hub) deliverPresence(m *BrokerMessage) {
+	if h.PresenceStore == nil {
+		return
+	}
+	var u presenceUpdate
+	if err := json.Unmarshal(m.Data, &u); err != nil {
+		h.presenceError(err)
+		return
+	}
+	u.remote = true
+	h.presence.mu.Lock()
+	h.presence.pending = append(h.presence.pending, u)
+	h.presence.mu.Unlock()
+	h.applyPresence()
+}
+
+func (h *Hub) presenceError(err error) {
+	h.log(slog.LevelError, "websocket: presence update failed", "error", err.Error())
+	if h.OnPresenceError != nil {
+		h.OnPresenceError(err)
+	}
+}
+
+// startPresence starts the presence heartbeat once. The hub lock must be
+// held.
+func (h *Hub) startPresence() {
+	if h.PresenceStore == nil || h.PresenceTTL <= 0 || h.presence.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.presence.cancel = cancel
+	go h.heartbeat(ctx)
+}
+
+// heartbeat refreshes the local members in the store, and in the stores of
+// the other nodes through the broker, and expires the members whose hub
+// stopped refreshing them.
+func (h *Hub) heartbeat(ctx context.Context) {
+	t := time.NewTicker(h.PresenceTTL / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		var members []RoomMember
+		for _, s := range h.shardList() {
+			s.mu.RLock()
+			for _, hc := range s.clients {
+				for room := range hc.rooms {
+					m := hc.member
+					m.Node = h.id
+					m.JoinedAt = hc.joined[room]
+					members = append(members, RoomMember{Room: room, Member: m})
+				}
+			}
+			s.mu.RUnlock()
+		}
+
+		h.presence.applyMu.Lock()
+		if len(members) > 0 {
+			h.applyPresenceUpdate(presenceUpdate{Kind: presenceSync, Members: members})
+		}
+		expired, err := h.PresenceStore.Expire(time.Now().Add(-h.PresenceTTL))
+		if err != nil {
+			h.presenceError(err)
+		}
+		if h.OnLeave != nil {
+			for _, rm := range expired {
+				h.OnLeave(rm.Room, rm.Member)
+			}
+		}
+		h.presence.applyMu.Unlock()
+	}
+}
+
+// Presence returns the members of the named room on all nodes sharing the
+// hub's broker, ordered by the time they joined the room. Presence returns
+// nil if the hub has no PresenceStore.
+func (h *Hub) Presence(room string) ([]Member, error) {
+	if h.PresenceStore == nil {
+		return nil, nil
+	}
+	members, err := h.PresenceStore.Members(room)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].JoinedAt.Before(members[j].JoinedAt)
+		}
+		return members[i].ID < members[j].ID
+	})
+	return members, nil
+}
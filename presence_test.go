@@ -0,0 +1,251 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// presenceEvents records the OnJoin and OnLeave calls of a hub.
+type presenceEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *presenceEvents) join(room string, m Member) {
+	e.mu.Lock()
+	e.events = append(e.events, "join "+room+" "+m.User)
+	e.mu.Unlock()
+}
+
+func (e *presenceEvents) leave(room string, m Member) {
+	e.mu.Lock()
+	e.events = append(e.events, "leave "+room+" "+m.User)
+	e.mu.Unlock()
+}
+
+func (e *presenceEvents) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.events...)
+}
+
+func users(t *testing.T, h *Hub, room string) []string {
+	t.Helper()
+	members, err := h.Presence(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []string
+	for _, m := range members {
+		users = append(users, m.User)
+	}
+	return users
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func presenceInfo(c *Conn) (string, map[string]string) {
+	user, _ := c.Get("user")
+	return user.(string), map[string]string{"status": "online"}
+}
+
+func TestHubPresence(t *testing.T) {
+	var ev presenceEvents
+	h := &Hub{
+		PresenceStore: &MemoryPresenceStore{},
+		PresenceInfo:  presenceInfo,
+		OnJoin:        ev.join,
+		OnLeave:       ev.leave,
+	}
+	defer h.Close()
+
+	s1, _ := newPipeConns()
+	s1.Set("user", "alice")
+	s2, _ := newPipeConns()
+	s2.Set("user", "bob")
+
+	for _, c := range []*Conn{s1, s2, s1} {
+		if err := h.Join("a", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Join("b", s1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := users(t, h, "a"), []string{"alice", "bob"}; !equalStrings(got, want) {
+		t.Fatalf("Presence(a) = %v, want %v", got, want)
+	}
+	members, _ := h.Presence("b")
+	if len(members) != 1 || members[0].Meta["status"] != "online" || members[0].JoinedAt.IsZero() {
+		t.Fatalf("Presence(b) = %+v", members)
+	}
+	a, _ := h.Presence("a")
+	if members[0].ID != a[0].ID {
+		t.Fatalf("member ID differs between rooms: %q, %q", members[0].ID, a[0].ID)
+	}
+
+	h.Leave("a", s1)
+	h.Remove(s2)
+	if got := users(t, h, "a"); len(got) != 0 {
+		t.Fatalf("Presence(a) = %v after leaving", got)
+	}
+	_ = h.Close()
+	if got := users(t, h, "b"); len(got) != 0 {
+		t.Fatalf("Presence(b) = %v after Close", got)
+	}
+
+	want := []string{"join a alice", "join a bob", "join b alice", "leave a alice", "leave a bob", "leave b alice"}
+	if got := ev.get(); !equalStrings(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestHubPresenceDisabled(t *testing.T) {
+	var h Hub
+	defer h.Close()
+	s, _ := newPipeConns()
+	if err := h.Join("a", s); err != nil {
+		t.Fatal(err)
+	}
+	if members, err := h.Presence("a"); members != nil || err != nil {
+		t.Fatalf("Presence() = %v, %v", members, err)
+	}
+}
+
+func TestHubPresenceBroker(t *testing.T) {
+	b := newMemBroker()
+	var ev1 presenceEvents
+	h1 := &Hub{Broker: b, PresenceStore: &MemoryPresenceStore{}, PresenceInfo: presenceInfo, OnJoin: ev1.join, OnLeave: ev1.leave}
+	defer h1.Close()
+	h2 := &Hub{Broker: b, PresenceStore: &MemoryPresenceStore{}, PresenceInfo: presenceInfo}
+	defer h2.Close()
+
+	s1, _ := newPipeConns()
+	s1.Set("user", "alice")
+	s2, _ := newPipeConns()
+	s2.Set("user", "bob")
+	if err := h1.Join("a", s1); err != nil {
+		t.Fatal(err)
+	}
+	b.waitReady(t, 1)
+	if err := h2.Join("a", s2); err != nil {
+		t.Fatal(err)
+	}
+	b.waitReady(t, 1)
+
+	// The memBroker delivers synchronously, so bob is known to h1. Alice
+	// joined before h2 subscribed and is unknown to h2 until a heartbeat.
+	if got, want := users(t, h1, "a"), []string{"alice", "bob"}; !equalStrings(got, want) {
+		t.Fatalf("h1 Presence(a) = %v, want %v", got, want)
+	}
+	if got, want := users(t, h2, "a"), []string{"bob"}; !equalStrings(got, want) {
+		t.Fatalf("h2 Presence(a) = %v, want %v", got, want)
+	}
+	members, _ := h1.Presence("a")
+	if members[0].Node == members[1].Node {
+		t.Fatalf("members of different hubs have node %q", members[0].Node)
+	}
+
+	h2.Remove(s2)
+	if got, want := users(t, h1, "a"), []string{"alice"}; !equalStrings(got, want) {
+		t.Fatalf("h1 Presence(a) = %v, want %v", got, want)
+	}
+	want := []string{"join a alice", "join a bob", "leave a bob"}
+	if got := ev1.get(); !equalStrings(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestHubPresenceHeartbeat(t *testing.T) {
+	b := newMemBroker()
+	var ev presenceEvents
+	h := &Hub{
+		Broker:        b,
+		PresenceStore: &MemoryPresenceStore{},
+		PresenceInfo:  presenceInfo,
+		PresenceTTL:   60 * time.Millisecond,
+		OnJoin:        ev.join,
+		OnLeave:       ev.leave,
+	}
+	defer h.Close()
+
+	s, _ := newPipeConns()
+	s.Set("user", "alice")
+	if err := h.Join("a", s); err != nil {
+		t.Fatal(err)
+	}
+	b.waitReady(t, 1)
+
+	// A member of a node that stops sending heartbeats expires.
+	p, err := json.Marshal(presenceUpdate{Kind: presenceJoin, Members: []RoomMember{{Room: "a", Member: Member{ID: "x", User: "carol", Node: "gone"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), &BrokerMessage{Origin: "gone", Presence: true, Data: p}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := users(t, h, "a"), []string{"carol", "alice"}; !equalStrings(got, want) {
+		t.Fatalf("Presence(a) = %v, want %v", got, want)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(users(t, h, "a")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("member of stopped node did not expire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// The local member is refreshed by the heartbeat.
+	time.Sleep(3 * h.PresenceTTL)
+	if got, want := users(t, h, "a"), []string{"alice"}; !equalStrings(got, want) {
+		t.Fatalf("Presence(a) = %v, want %v", got, want)
+	}
+	want := []string{"join a alice", "join a carol", "leave a carol"}
+	if got := ev.get(); !equalStrings(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestHubPresenceSync(t *testing.T) {
+	// A hub joining late learns the members of the other nodes from their
+	// heartbeats.
+	b := newMemBroker()
+	h1 := &Hub{Broker: b, PresenceStore: &MemoryPresenceStore{}, PresenceInfo: presenceInfo, PresenceTTL: 30 * time.Millisecond}
+	defer h1.Close()
+	s1, _ := newPipeConns()
+	s1.Set("user", "alice")
+	if err := h1.Join("a", s1); err != nil {
+		t.Fatal(err)
+	}
+	b.waitReady(t, 1)
+
+	h2 := &Hub{Broker: b, PresenceStore: &MemoryPresenceStore{}, PresenceInfo: presenceInfo, PresenceTTL: 30 * time.Millisecond}
+	defer h2.Close()
+	s2, _ := newPipeConns()
+	s2.Set("user", "bob")
+	if err := h2.Join("b", s2); err != nil {
+		t.Fatal(err)
+	}
+	b.waitReady(t, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !equalStrings(users(t, h2, "a"), []string{"alice"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("h2 Presence(a) = %v", users(t, h2, "a"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import "errors"
+
+// Priority is the priority of a message in the write queue.
+type Priority int
+
+const (
+	// PriorityLow messages are written when no other messages are queued,
+	// such as bulk snapshots. When the low priority queue is full, the oldest
+	// low priority message is discarded, whatever the overflow policy.
+	PriorityLow Priority = iota - 1
+
+	// PriorityNormal is the priority of the messages written with
+	// WriteMessage and the other write methods.
+	PriorityNormal
+
+	// PriorityHigh messages are written before the messages of lower
+	// priority, such as price ticks.
+	PriorityHigh
+)
+
+// WriteMessagePriority is like WriteMessage, but queues the message with the
+// priority. The write queue writes the queued messages of the highest
+// priority first, in the order they were queued; control messages written
+// with WriteControl, such as keepalive pings, are written ahead of all
+// queued messages. Each priority has its own queue of the size passed to
+// EnableWriteQueue.
+//
+// Without a write queue, WriteMessagePriority writes the message like
+// WriteMessage.
+func (c *Conn) WriteMessagePriority(messageType int, data []byte, priority Priority) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if priority < PriorityLow || priority > PriorityHigh {
+		return errors.New("websocket: invalid priority")
+	}
+	data, ok, err := c.interceptWrite(messageType, data)
+	if !ok {
+		return err
+	}
+	if q := c.writeQueue; q != nil {
+		return q.enqueue(queuedMessage{messageType: messageType, data: append([]byte(nil), data...), priority: priority})
+	}
+	return c.writeMessage(messageType, data)
+}
+
+func (q *writeQueue) chanFor(priority Priority) chan queuedMessage {
+	switch priority {
+	case PriorityHigh:
+		return q.high
+	case PriorityLow:
+		return q.low
+	default:
+		return q.ch
+	}
+}
+
+func (q *writeQueue) len() int {
+	return len(q.high) + len(q.ch) + len(q.low)
+}
+
+// next returns the queued message of the highest priority, waiting for a
+// message if the queue is empty. It returns false when the queue is stopped.
+func (q *writeQueue) next() (queuedMessage, bool) {
+	select {
+	case m := <-q.high:
+		return m, true
+	default:
+	}
+	select {
+	case m := <-q.high:
+		return m, true
+	case m := <-q.ch:
+		return m, true
+	default:
+	}
+	select {
+	case m := <-q.high:
+		return m, true
+	case m := <-q.ch:
+		return m, true
+	case m := <-q.low:
+		return m, true
+	case <-q.done:
+		return queuedMessage{}, false
+	}
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteMessagePriority(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	_ = s.EnableWriteQueue(8, OverflowBlock)
+
+	// The writer goroutine takes the first message and blocks on the pipe
+	// until the client reads.
+	_ = s.WriteMessagePriority(TextMessage, []byte("first"), PriorityNormal)
+	deadline := time.Now().Add(time.Second)
+	for s.WriteQueueLen() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for _, m := range []struct {
+		data     string
+		priority Priority
+	}{
+		{"low1", PriorityLow},
+		{"low2", PriorityLow},
+		{"normal", PriorityNormal},
+		{"high", PriorityHigh},
+	} {
+		if err := s.WriteMessagePriority(TextMessage, []byte(m.data), m.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.WriteQueueLen(); n != 4 {
+		t.Errorf("WriteQueueLen() = %d, want 4", n)
+	}
+	for _, want := range []string{"first", "high", "normal", "low1", "low2"} {
+		if got := readString(t, c); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWriteMessagePriorityLowDropsOldest(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	_ = s.EnableWriteQueue(2, OverflowClose)
+
+	_ = s.WriteMessagePriority(TextMessage, []byte("first"), PriorityNormal)
+	deadline := time.Now().Add(time.Second)
+	for s.WriteQueueLen() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for _, p := range []string{"a", "b", "c", "d"} {
+		if err := s.WriteMessagePriority(TextMessage, []byte(p), PriorityLow); err != nil {
+			t.Fatalf("WriteMessagePriority(%q) returned %v", p, err)
+		}
+	}
+	if n := s.WriteQueueDropped(); n != 2 {
+		t.Errorf("WriteQueueDropped() = %d, want 2", n)
+	}
+	for _, want := range []string{"first", "c", "d"} {
+		if got := readString(t, c); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWriteMessagePriorityInvalid(t *testing.T) {
+	s, _ := newPipeConns()
+	defer s.Close()
+	if err := s.WriteMessagePriority(TextMessage, nil, PriorityHigh+1); err == nil {
+		t.Error("invalid priority returned no error")
+	}
+	var nilConn *Conn
+	if err := nilConn.WriteMessagePriority(TextMessage, nil, PriorityNormal); err != ErrNilConn {
+		t.Errorf("nil Conn returned %v", err)
+	}
+}
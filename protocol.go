@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ProtocolHandler handles a connection upgraded by Upgrader.ServeHTTP. The
+// request is the client's handshake request.
+type ProtocolHandler func(c *Conn, r *http.Request)
+
+// Protocol registers the handler for the named subprotocol and appends the
+// name to u.Subprotocols if it is not already listed. Subprotocols registered
+// first are preferred when the client offers several. If name is empty, the
+// handler is used for clients that do not negotiate a registered
+// subprotocol.
+//
+// Protocol must not be called concurrently with ServeHTTP.
+func (u *Upgrader) Protocol(name string, handler ProtocolHandler) {
+	if u.protocols == nil {
+		u.protocols = make(map[string]ProtocolHandler)
+	}
+	u.protocols[name] = handler
+	if name != "" && !containsString(u.Subprotocols, name) {
+		u.Subprotocols = append(u.Subprotocols, name)
+	}
+}
+
+// ServeHTTP upgrades the request to the WebSocket protocol and calls the
+// handler registered with Protocol for the negotiated subprotocol. If no
+// handler matches, ServeHTTP responds with status 400 Bad Request without
+// upgrading the connection. The connection is closed when the handler
+// returns.
+func (u *Upgrader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := u.protocols[u.selectSubprotocol(r, nil)]
+	if !ok {
+		_, _ = u.returnError(w, r, http.StatusBadRequest, "websocket: no supported subprotocol offered by client")
+		return
+	}
+	c, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	handler(c, r)
+}
+
+// Protocol registers the handler for the named subprotocol and appends the
+// name to u.Subprotocols if it is not already listed. Subprotocols registered
+// first are preferred when the client offers several. If name is empty, the
+// handler is used for clients that do not negotiate a registered
+// subprotocol.
+//
+// Protocol must not be called concurrently with UpgradeProtocol.
+func (u *FastHTTPUpgrader) Protocol(name string, handler FastHTTPHandler) {
+	if u.protocols == nil {
+		u.protocols = make(map[string]FastHTTPHandler)
+	}
+	u.protocols[name] = handler
+	if name != "" && !containsString(u.Subprotocols, name) {
+		u.Subprotocols = append(u.Subprotocols, name)
+	}
+}
+
+// UpgradeProtocol upgrades the request to the WebSocket protocol and calls the
+// handler registered with Protocol for the negotiated subprotocol. If no
+// handler matches, UpgradeProtocol responds with status 400 Bad Request
+// without upgrading the connection.
+func (u *FastHTTPUpgrader) UpgradeProtocol(ctx *fasthttp.RequestCtx) error {
+	handler, ok := u.protocols[string(u.selectSubprotocol(ctx))]
+	if !ok {
+		return u.responseError(ctx, fasthttp.StatusBadRequest, "websocket: no supported subprotocol offered by client")
+	}
+	return u.Upgrade(ctx, handler)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
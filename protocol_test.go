@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpgraderProtocol(t *testing.T) {
+	var u Upgrader
+	for _, name := range []string{"p1", "p2"} {
+		name := name
+		u.Protocol(name, func(c *Conn, r *http.Request) {
+			_ = c.WriteMessage(TextMessage, []byte(name+":"+c.Subprotocol()))
+		})
+	}
+	if len(u.Subprotocols) != 2 {
+		t.Fatalf("Subprotocols = %v", u.Subprotocols)
+	}
+	s := httptest.NewServer(&u)
+	defer s.Close()
+
+	for _, tt := range []struct {
+		offer []string
+		want  string
+	}{
+		{[]string{"p2"}, "p2:p2"},
+		{[]string{"p3", "p1"}, "p1:p1"},
+	} {
+		d := Dialer{Subprotocols: tt.offer}
+		c, _, err := d.Dial(makeWsProto(s.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial(%v): %v", tt.offer, err)
+		}
+		if got := readString(t, c); got != tt.want {
+			t.Errorf("offer %v: got %q, want %q", tt.offer, got, tt.want)
+		}
+		c.Close()
+	}
+
+	_, resp, err := DefaultDialer.Dial(makeWsProto(s.URL), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Dial without subprotocol returned %v, %v", resp, err)
+	}
+
+	u.Protocol("", func(c *Conn, r *http.Request) {
+		_ = c.WriteMessage(TextMessage, []byte("default"))
+	})
+	c, _, err := DefaultDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if got := readString(t, c); got != "default" {
+		t.Fatalf("got %q, want %q", got, "default")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"net"
@@ -34,10 +35,17 @@ func (fn netDialerFunc) DialContext(ctx context.Context, network, addr string) (
 }
 
 // proxyFromURL creates a dialer that connects through the specified proxy.
-// It supports HTTP proxies and any proxy type supported by golang.org/x/net/proxy.
-func proxyFromURL(proxyURL *url.URL, forwardDial netDialerFunc) (netDialerFunc, error) {
-	if proxyURL.Scheme == "http" {
-		return (&httpProxyDialer{proxyURL: proxyURL, forwardDial: forwardDial}).DialContext, nil
+// It supports HTTP and HTTPS proxies and any proxy type supported by
+// golang.org/x/net/proxy. The TLS configuration is used to connect to HTTPS
+// proxies and the header is sent with CONNECT requests.
+func proxyFromURL(proxyURL *url.URL, forwardDial netDialerFunc, tlsConfig *tls.Config, header http.Header) (netDialerFunc, error) {
+	if proxyURL.Scheme == "http" || proxyURL.Scheme == "https" {
+		return (&httpProxyDialer{
+			proxyURL:    proxyURL,
+			forwardDial: forwardDial,
+			tlsConfig:   tlsConfig,
+			header:      header,
+		}).DialContext, nil
 	}
 
 	// Handle non-HTTP proxies using the golang.org/x/net/proxy package
@@ -61,6 +69,8 @@ func proxyFromURL(proxyURL *url.URL, forwardDial netDialerFunc) (netDialerFunc,
 type httpProxyDialer struct {
 	proxyURL    *url.URL
 	forwardDial netDialerFunc
+	tlsConfig   *tls.Config
+	header      http.Header
 }
 
 // DialContext establishes a connection to the address through the HTTP proxy.
@@ -92,15 +102,32 @@ func (hpd *httpProxyDialer) DialContext(ctx context.Context, network string, add
 	return conn, nil
 }
 
-// connectToProxy establishes a connection to the proxy server.
+// connectToProxy establishes a connection to the proxy server. Connections
+// to HTTPS proxies are secured with TLS.
 func (hpd *httpProxyDialer) connectToProxy(ctx context.Context, network string) (net.Conn, error) {
-	hostPort, _ := hostPortNoPort(hpd.proxyURL)
-	return hpd.forwardDial(ctx, network, hostPort)
+	hostPort, hostNoPort := hostPortNoPort(hpd.proxyURL)
+	conn, err := hpd.forwardDial(ctx, network, hostPort)
+	if err != nil || hpd.proxyURL.Scheme != "https" {
+		return conn, err
+	}
+	cfg := cloneTLSConfig(hpd.tlsConfig)
+	if cfg.ServerName == "" {
+		cfg.ServerName = hostNoPort
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
 }
 
 // createConnectRequest creates an HTTP CONNECT request for the target address.
 func (hpd *httpProxyDialer) createConnectRequest(addr string) (*http.Request, error) {
-	connectHeader := make(http.Header)
+	connectHeader := hpd.header.Clone()
+	if connectHeader == nil {
+		connectHeader = make(http.Header)
+	}
 
 	// Add proxy authentication if credentials are provided
 	if user := hpd.proxyURL.User; user != nil {
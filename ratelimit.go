@@ -0,0 +1,234 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ErrRateLimited is returned when a rate limit is exceeded and the policy is
+// RateLimitDrop or RateLimitClose.
+var ErrRateLimited = errors.New("websocket: rate limit exceeded")
+
+// RateLimitPolicy specifies what happens when a rate limit is exceeded.
+type RateLimitPolicy int
+
+const (
+	// RateLimitDelay waits until the rate allows the message. For inbound
+	// limits, the connection stops reading from the network, which applies
+	// backpressure to the peer through TCP flow control.
+	RateLimitDelay RateLimitPolicy = iota
+
+	// RateLimitDrop discards messages exceeding the rate.
+	RateLimitDrop
+
+	// RateLimitClose sends a close message with code ClosePolicyViolation
+	// and fails the connection.
+	RateLimitClose
+)
+
+// RateLimit specifies a token bucket rate limit on messages and payload
+// bytes. A zero rate is unlimited.
+type RateLimit struct {
+	// Messages is the sustained rate in messages per second.
+	Messages float64
+
+	// Bytes is the sustained rate in payload bytes per second.
+	Bytes float64
+
+	// MessageBurst and ByteBurst specify the number of messages and bytes
+	// that can be consumed at once. If zero, the burst is one second at the
+	// sustained rate.
+	MessageBurst, ByteBurst int
+
+	// Policy specifies the action taken when the limit is exceeded.
+	Policy RateLimitPolicy
+}
+
+// tokenBucket is a token bucket that can go into debt. Debt lets a large
+// message through when tokens are available and charges it to the following
+// messages.
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64 // maximum tokens
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = max(rate, 1)
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b}
+}
+
+// advance adds the tokens accumulated since the last call.
+func (b *tokenBucket) advance(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// delay returns the time to wait before n tokens are available.
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if b == nil || b.tokens >= n || b.tokens > 0 && n > b.burst {
+		// Oversized requests only need a positive balance.
+		return 0
+	}
+	return time.Duration((n - max(b.tokens, 0)) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+// rateLimiter applies a RateLimit. The methods are not safe for concurrent
+// use.
+type rateLimiter struct {
+	policy   RateLimitPolicy
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	rl := &rateLimiter{
+		policy:   l.Policy,
+		messages: newTokenBucket(l.Messages, l.MessageBurst),
+		bytes:    newTokenBucket(l.Bytes, l.ByteBurst),
+	}
+	if rl.messages == nil && rl.bytes == nil {
+		return nil
+	}
+	return rl
+}
+
+// reserve charges the limiter for messages and bytes. It returns the time to
+// wait under RateLimitDelay, or false if the limit is exceeded under the
+// other policies, in which case nothing is charged.
+func (rl *rateLimiter) reserve(now time.Time, messages, bytes int) (time.Duration, bool) {
+	if rl.messages != nil {
+		rl.messages.advance(now)
+	}
+	if rl.bytes != nil {
+		rl.bytes.advance(now)
+	}
+	d := max(rl.messages.delay(float64(messages)), rl.bytes.delay(float64(bytes)))
+	if d > 0 && rl.policy != RateLimitDelay {
+		return 0, false
+	}
+	rl.messages.take(float64(messages))
+	rl.bytes.take(float64(bytes))
+	return d, true
+}
+
+// SetReadRateLimit limits the rate of data messages and payload bytes read
+// from the peer. Messages are charged when the first frame is read and
+// payload bytes are charged for each frame. A message dropped by
+// RateLimitDrop is read and discarded without being returned to the
+// application. Under RateLimitClose, the read methods return ErrRateLimited
+// after the limit is exceeded. A zero RateLimit removes the limit.
+//
+// SetReadRateLimit must not be called concurrently with the read methods.
+func (c *Conn) SetReadRateLimit(limit RateLimit) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if limit.Messages < 0 || limit.Bytes < 0 || limit.MessageBurst < 0 || limit.ByteBurst < 0 {
+		return errors.New("websocket: invalid rate limit")
+	}
+	c.readLimiter = newRateLimiter(limit)
+	return nil
+}
+
+// admitMessage charges the read rate limiter for a new message whose first
+// frame has n payload bytes. It returns false if the message must be
+// dropped.
+func (c *Conn) admitMessage(n int) (bool, error) {
+	return c.chargeRead(1, n)
+}
+
+// chargeRead charges the read rate limiter and applies the policy.
+func (c *Conn) chargeRead(messages, bytes int) (bool, error) {
+	rl := c.readLimiter
+	d, ok := rl.reserve(time.Now(), messages, bytes)
+	if !ok {
+		if rl.policy == RateLimitDrop {
+			if messages > 0 {
+				return false, nil
+			}
+			// A message that was admitted is delivered in full. Charge its
+			// remaining frames to the following messages.
+			rl.bytes.take(float64(bytes))
+			return true, nil
+		}
+		c.audit(AuditViolation, "", ClosePolicyViolation, "rate limit exceeded")
+		_ = c.WriteControl(CloseMessage, FormatCloseMessage(ClosePolicyViolation, "rate limit exceeded"), time.Now().Add(writeWait))
+		return false, ErrRateLimited
+	}
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-c.closed:
+			return false, net.ErrClosed
+		}
+	}
+	return true, nil
+}
+
+// discardMessage reads and discards the current message.
+func (c *Conn) discardMessage(messageType int) error {
+	r := c.wrapExtensionReader(c.messageReader, messageType)
+	defer r.Close()
+	_, err := io.Copy(io.Discard, r)
+	c.messageReader = nil
+	c.readLength = 0
+	c.readFrames = 0
+	return err
+}
+
+// throttle applies the broadcast rate limit of the room.
+func (h *Hub) throttle(room string, n int) error {
+	h.limitMu.Lock()
+	limit := h.BroadcastRateLimit
+	if limit.Policy == RateLimitClose {
+		limit.Policy = RateLimitDrop
+	}
+	rl, ok := h.limiters[room]
+	if !ok {
+		rl = newRateLimiter(limit)
+		if rl == nil {
+			h.limitMu.Unlock()
+			return nil
+		}
+		if h.limiters == nil {
+			h.limiters = make(map[string]*rateLimiter)
+		}
+		h.limiters[room] = rl
+	}
+	d, allowed := rl.reserve(time.Now(), 1, n)
+	h.limitMu.Unlock()
+	if !allowed {
+		return ErrRateLimited
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return nil
+}
+
+// forgetRoom discards the rate limiter state of an empty room.
+func (h *Hub) forgetRoom(room string) {
+	h.limitMu.Lock()
+	delete(h.limiters, room)
+	h.limitMu.Unlock()
+}
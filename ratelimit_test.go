@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func rateLimitTestConn(t *testing.T, limit RateLimit, messages ...string) *Conn {
+	t.Helper()
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, false)
+	for _, m := range messages {
+		if err := wc.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc := newTestConn(&buf, io.Discard, true)
+	if err := rc.SetReadRateLimit(limit); err != nil {
+		t.Fatal(err)
+	}
+	return rc
+}
+
+func TestReadRateLimitDrop(t *testing.T) {
+	rc := rateLimitTestConn(t, RateLimit{Messages: 0.001, MessageBurst: 2, Policy: RateLimitDrop}, "a", "b", "c", "d")
+	for _, want := range []string{"a", "b"} {
+		_, p, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != want {
+			t.Fatalf("got %q, want %q", p, want)
+		}
+	}
+	if _, p, err := rc.ReadMessage(); err == nil {
+		t.Fatalf("got %q, want messages dropped", p)
+	}
+}
+
+func TestReadRateLimitBytesDrop(t *testing.T) {
+	rc := rateLimitTestConn(t, RateLimit{Bytes: 0.001, ByteBurst: 5, Policy: RateLimitDrop}, "abc", "defg", "h")
+	for _, want := range []string{"abc", "h"} {
+		_, p, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != want {
+			t.Fatalf("got %q, want %q", p, want)
+		}
+	}
+}
+
+func TestReadRateLimitDelay(t *testing.T) {
+	rc := rateLimitTestConn(t, RateLimit{Messages: 100, MessageBurst: 1}, "a", "b", "c")
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, _, err := rc.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Fatalf("read 3 messages in %v, want delay", d)
+	}
+}
+
+func TestReadRateLimitClose(t *testing.T) {
+	rc := rateLimitTestConn(t, RateLimit{Messages: 0.001, MessageBurst: 1, Policy: RateLimitClose}, "a", "b")
+	if _, _, err := rc.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rc.ReadMessage(); err != ErrRateLimited {
+		t.Fatalf("ReadMessage returned %v, want %v", err, ErrRateLimited)
+	}
+	if _, _, err := rc.ReadMessage(); err != ErrRateLimited {
+		t.Fatalf("second ReadMessage returned %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestHubBroadcastRateLimit(t *testing.T) {
+	h := Hub{BroadcastRateLimit: RateLimit{Messages: 0.001, MessageBurst: 2, Policy: RateLimitDrop}}
+	defer h.Close()
+
+	s1, c1 := newPipeConns()
+	s2, c2 := newPipeConns()
+	_ = h.Join("busy", s1)
+	_ = h.Join("quiet", s2)
+	for i := 0; i < 3; i++ {
+		err := h.Broadcast("busy", TextMessage, []byte(fmt.Sprint(i)))
+		if want := i >= 2; (err == ErrRateLimited) != want {
+			t.Fatalf("Broadcast %d returned %v", i, err)
+		}
+	}
+	if err := h.Broadcast("quiet", TextMessage, []byte("q")); err != nil {
+		t.Fatalf("Broadcast to other room returned %v", err)
+	}
+	for _, want := range []string{"0", "1"} {
+		if got := readString(t, c1); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	if got := readString(t, c2); got != "q" {
+		t.Fatalf("got %q, want %q", got, "q")
+	}
+}
@@ -0,0 +1,273 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdleTimeout     = 5 * time.Minute
+	defaultIdleCloseReason = "idle timeout"
+)
+
+// ErrReaperClosed is returned by IdleReaper.Add after Close was called.
+var ErrReaperClosed = errors.New("websocket: idle reaper closed")
+
+// IdlePolicy selects the activity that keeps a connection from being reaped
+// by an IdleReaper.
+type IdlePolicy int
+
+const (
+	// IdleAny counts the data messages read and written.
+	IdleAny IdlePolicy = iota
+
+	// IdleInbound counts the data messages read only, so that a connection
+	// receiving broadcasts without ever sending is reaped.
+	IdleInbound
+
+	// IdleOutbound counts the data messages written only.
+	IdleOutbound
+)
+
+// LastRead returns the time the last data frame was read from the
+// connection, or the time the connection was created. Control frames, such
+// as the pings and pongs of keepalive, are not counted.
+func (c *Conn) LastRead() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, c.lastRead.Load())
+}
+
+// LastWrite returns the time the last data frame was written to the
+// connection, or the time the connection was created. Control frames are not
+// counted.
+func (c *Conn) LastWrite() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, c.lastWrite.Load())
+}
+
+// OpenedAt returns the time the connection was created.
+func (c *Conn) OpenedAt() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	return c.opened
+}
+
+// IdleReaper closes the connections without data messages for longer than
+// IdleTimeout, to free the memory and file descriptors held by abandoned
+// connections on public endpoints. The reaper sends a close message with
+// CloseCode and CloseReason and closes the network connection; the read loop
+// of the application gets an error as for any closed connection.
+//
+// Keepalive pings keep a connection open but do not count as activity. Tag
+// the connections expected to stay quiet, such as those only subscribed to
+// rare notifications, with one of the ExemptTags metadata keys to keep them
+// from being reaped.
+//
+// Connections are added with Add and removed when they are closed or when
+// Remove is called. It is safe to call IdleReaper's methods concurrently.
+// The zero value is ready to use.
+type IdleReaper struct {
+	// IdleTimeout specifies how long a connection may go without activity.
+	// If zero, a default of 5 minutes is used.
+	IdleTimeout time.Duration
+
+	// Interval specifies how often the connections are checked. If zero, a
+	// tenth of IdleTimeout is used.
+	Interval time.Duration
+
+	// Policy selects the activity counted, IdleAny by default.
+	Policy IdlePolicy
+
+	// CloseCode specifies the close code sent to reaped connections,
+	// CloseNormalClosure or an application code in the range 4000-4999.
+	// If zero, CloseNormalClosure is used.
+	CloseCode int
+
+	// CloseReason specifies the close reason sent to reaped connections.
+	// If empty, "idle timeout" is used.
+	CloseReason string
+
+	// ExemptTags lists metadata keys exempting connections from reaping:
+	// a connection with any of the keys set with Conn.Set is not reaped.
+	ExemptTags []string
+
+	// OnReap, if not nil, is called after a connection is reaped with the
+	// time it was idle.
+	OnReap func(c *Conn, idle time.Duration)
+
+	// Logger, if not nil, receives a log record for each reaped connection.
+	Logger Logger
+
+	mu     sync.Mutex
+	conns  map[*Conn]chan struct{}
+	stop   chan struct{}
+	closed bool
+}
+
+// Add starts watching the connection. Add returns ErrReaperClosed if Close
+// was called.
+func (r *IdleReaper) Add(c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrReaperClosed
+	}
+	if _, ok := r.conns[c]; ok {
+		return nil
+	}
+	if r.conns == nil {
+		r.conns = make(map[*Conn]chan struct{})
+	}
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+		go r.run(r.stop)
+	}
+	removed := make(chan struct{})
+	r.conns[c] = removed
+	if c.closed != nil {
+		go func() {
+			select {
+			case <-c.closed:
+				r.Remove(c)
+			case <-removed:
+			}
+		}()
+	}
+	return nil
+}
+
+// Remove stops watching the connection. Remove does not close the
+// connection.
+func (r *IdleReaper) Remove(c *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if removed, ok := r.conns[c]; ok {
+		delete(r.conns, c)
+		close(removed)
+	}
+}
+
+// Len returns the number of watched connections.
+func (r *IdleReaper) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Close stops the reaper and the watching of all connections. Close does not
+// close the connections.
+func (r *IdleReaper) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	for c, removed := range r.conns {
+		delete(r.conns, c)
+		close(removed)
+	}
+	if r.stop != nil {
+		close(r.stop)
+	}
+	return nil
+}
+
+func (r *IdleReaper) timeout() time.Duration {
+	if r.IdleTimeout > 0 {
+		return r.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+// run checks the connections every interval until stop is closed.
+func (r *IdleReaper) run(stop chan struct{}) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = r.timeout() / 10
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			r.Reap(now)
+		}
+	}
+}
+
+// Reap closes the connections idle at time now for longer than IdleTimeout
+// and returns their number. The reaper calls Reap every Interval; call it
+// directly to reap on demand, such as when the number of file descriptors
+// runs high.
+func (r *IdleReaper) Reap(now time.Time) int {
+	timeout := r.timeout()
+	r.mu.Lock()
+	var idle []*Conn
+	for c := range r.conns {
+		if now.Sub(r.lastActive(c)) > timeout && !r.exempt(c) {
+			idle = append(idle, c)
+		}
+	}
+	r.mu.Unlock()
+
+	code := r.CloseCode
+	if code == 0 {
+		code = CloseNormalClosure
+	}
+	reason := r.CloseReason
+	if reason == "" {
+		reason = defaultIdleCloseReason
+	}
+	msg := FormatCloseMessage(code, reason)
+	for _, c := range idle {
+		r.Remove(c)
+		d := now.Sub(r.lastActive(c))
+		if r.Logger != nil {
+			r.Logger.Log(slog.LevelInfo, "websocket: idle connection reaped", append(c.logArgs(), "idle", d)...)
+		}
+		_ = c.WriteControl(CloseMessage, msg, time.Now().Add(writeWait))
+		_ = c.Close()
+		if r.OnReap != nil {
+			r.OnReap(c, d)
+		}
+	}
+	return len(idle)
+}
+
+// lastActive returns the time of the last activity of c under the policy.
+func (r *IdleReaper) lastActive(c *Conn) time.Time {
+	switch r.Policy {
+	case IdleInbound:
+		return c.LastRead()
+	case IdleOutbound:
+		return c.LastWrite()
+	}
+	read, write := c.LastRead(), c.LastWrite()
+	if write.After(read) {
+		return write
+	}
+	return read
+}
+
+// exempt reports whether c has one of the exemption tags.
+func (r *IdleReaper) exempt(c *Conn) bool {
+	for _, tag := range r.ExemptTags {
+		if _, ok := c.Get(tag); ok {
+			return true
+		}
+	}
+	return false
+}
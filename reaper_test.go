@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestConnActivity(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	start := time.Now()
+	go func() { _ = s.WriteMessage(TextMessage, []byte("hello")) }()
+	readString(t, c)
+	if c.LastRead().Before(start) {
+		t.Errorf("LastRead %v before the read", c.LastRead())
+	}
+	if c.LastWrite().After(start) {
+		t.Errorf("LastWrite %v after the read only", c.LastWrite())
+	}
+
+	go func() { _, _, _ = s.ReadMessage() }()
+	if err := c.WriteControl(PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if c.LastWrite().After(start) {
+		t.Error("LastWrite updated by a control message")
+	}
+}
+
+// idleConn returns a connection whose last read and write happened ago.
+func idleConn(read, write time.Duration) (*Conn, *bytes.Buffer) {
+	var out bytes.Buffer
+	c := newTestConn(nil, &out, true)
+	now := time.Now()
+	c.lastRead.Store(now.Add(-read).UnixNano())
+	c.lastWrite.Store(now.Add(-write).UnixNano())
+	return c, &out
+}
+
+func TestIdleReaper(t *testing.T) {
+	var reaped []*Conn
+	r := IdleReaper{
+		IdleTimeout: time.Minute,
+		Interval:    time.Hour,
+		CloseCode:   4000,
+		CloseReason: "bye",
+		ExemptTags:  []string{"quiet"},
+		OnReap:      func(c *Conn, idle time.Duration) { reaped = append(reaped, c) },
+	}
+	defer r.Close()
+
+	idle, out := idleConn(2*time.Minute, 2*time.Minute)
+	reading, _ := idleConn(time.Second, 2*time.Minute)
+	writing, _ := idleConn(2*time.Minute, time.Second)
+	quiet, _ := idleConn(2*time.Minute, 2*time.Minute)
+	quiet.Set("quiet", true)
+	for _, c := range []*Conn{idle, reading, writing, quiet} {
+		if err := r.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := r.Reap(time.Now()); n != 1 {
+		t.Fatalf("Reap returned %d, want 1", n)
+	}
+	if len(reaped) != 1 || reaped[0] != idle {
+		t.Fatalf("OnReap called for %v, want the idle connection", reaped)
+	}
+	if r.Len() != 3 {
+		t.Errorf("Len returned %d, want 3", r.Len())
+	}
+	rc := newTestConn(out, io.Discard, false)
+	var closeErr *CloseError
+	if _, _, err := rc.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Text != "bye" {
+		t.Fatalf("reaped connection received %v, want close 4000 bye", err)
+	}
+
+	// Under IdleInbound, writing does not keep the connection open.
+	r.Policy = IdleInbound
+	if n := r.Reap(time.Now()); n != 1 || reaped[1] != writing {
+		t.Fatalf("Reap under IdleInbound returned %d, want the writing connection", n)
+	}
+	r.Policy = IdleOutbound
+	if n := r.Reap(time.Now()); n != 1 || reaped[2] != reading {
+		t.Fatalf("Reap under IdleOutbound returned %d, want the reading connection", n)
+	}
+
+	quiet.Delete("quiet")
+	_ = quiet.Close()
+	deadline := time.Now().Add(time.Second)
+	for r.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if r.Len() != 0 {
+		t.Errorf("closed connection still watched")
+	}
+
+	_ = r.Close()
+	if err := r.Add(idle); err != ErrReaperClosed {
+		t.Errorf("Add after Close returned %v, want %v", err, ErrReaperClosed)
+	}
+}
+
+func TestIdleReaperInterval(t *testing.T) {
+	reaped := make(chan *Conn, 1)
+	r := IdleReaper{
+		IdleTimeout: 20 * time.Millisecond,
+		Interval:    5 * time.Millisecond,
+		OnReap:      func(c *Conn, idle time.Duration) { reaped <- c },
+	}
+	defer r.Close()
+	c := newTestConn(nil, io.Discard, true)
+	_ = r.Add(c)
+	select {
+	case got := <-reaped:
+		if got != c {
+			t.Fatal("OnReap called for an unknown connection")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle connection not reaped")
+	}
+}
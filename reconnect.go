@@ -0,0 +1,311 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+var (
+	// ErrReconnectingConnClosed is returned when using a ReconnectingConn
+	// after Close was called or after reconnection gave up.
+	ErrReconnectingConnClosed = errors.New("websocket: reconnecting connection closed")
+
+	// ErrNotConnected is returned by ReconnectingConn.WriteMessage when the
+	// connection is down and outbound buffering is disabled.
+	ErrNotConnected = errors.New("websocket: not connected")
+)
+
+// ReconnectingConn is a client connection that transparently redials the
+// server with exponential backoff and jitter when the connection is lost.
+//
+// The application must read from the connection with ReadMessage in a loop.
+// When a read fails, ReadMessage reconnects and resumes reading from the new
+// connection. WriteMessage may be called concurrently with ReadMessage and
+// with other calls to WriteMessage. While the connection is down, written
+// messages are buffered up to BufferSize and written after the connection is
+// reestablished.
+//
+// Set the fields before calling DialContext and do not modify them after.
+type ReconnectingConn struct {
+	// URL is the URL of the WebSocket server.
+	URL string
+
+	// Header specifies the request headers for each handshake.
+	Header http.Header
+
+	// Dialer specifies the dialer used to connect. If nil, DefaultDialer is
+	// used.
+	Dialer *Dialer
+
+	// MinBackoff and MaxBackoff bound the delay between reconnection
+	// attempts. The delay doubles after each failed attempt and is randomized
+	// with full jitter. If zero, defaults of 500 milliseconds and 30 seconds
+	// are used.
+	MinBackoff, MaxBackoff time.Duration
+
+	// MaxAttempts specifies the number of consecutive failed attempts after
+	// which the connection gives up. If zero, attempts are unlimited.
+	MaxAttempts int
+
+	// BufferSize specifies the number of outbound messages buffered while
+	// disconnected. If zero, WriteMessage returns ErrNotConnected while
+	// disconnected.
+	BufferSize int
+
+	// OnConnect is called after each successful handshake, including the
+	// first, before buffered messages are written. Use OnConnect to replay
+	// application handshakes such as authentication and subscriptions. If
+	// OnConnect returns an error, the connection is closed and the attempt is
+	// considered failed.
+	OnConnect func(c *Conn) error
+
+	// OnDisconnect is called with the read error when the connection is lost.
+	OnDisconnect func(err error)
+
+	// ShouldReconnect reports whether to reconnect after the read error. If
+	// nil, the connection always reconnects.
+	ShouldReconnect func(err error) bool
+
+	writeMu sync.Mutex // serializes writes to conn
+
+	mu     sync.Mutex
+	conn   *Conn
+	buffer []queuedMessage
+	closed bool
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// DialContext makes the first connection to the server. Unlike
+// reconnections, the first connection is attempted once so that
+// configuration errors are reported to the caller. The context only bounds
+// the first connection.
+func (rc *ReconnectingConn) DialContext(ctx context.Context) error {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return ErrReconnectingConnClosed
+	}
+	if rc.ctx == nil {
+		rc.ctx, rc.cancel = context.WithCancel(context.Background())
+	}
+	rc.mu.Unlock()
+
+	c, err := rc.connect(ctx)
+	if err != nil {
+		return err
+	}
+	return rc.setConn(c)
+}
+
+// connect dials the server and runs OnConnect.
+func (rc *ReconnectingConn) connect(ctx context.Context) (*Conn, error) {
+	d := rc.Dialer
+	if d == nil {
+		d = DefaultDialer
+	}
+	c, _, err := d.DialContext(ctx, rc.URL, rc.Header)
+	if err != nil {
+		return nil, err
+	}
+	if rc.OnConnect != nil {
+		if err := rc.OnConnect(c); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// setConn writes the buffered messages to c and makes c the current
+// connection.
+func (rc *ReconnectingConn) setConn(c *Conn) error {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+	rc.mu.Lock()
+	buffer := rc.buffer
+	rc.buffer = nil
+	closed := rc.closed
+	rc.mu.Unlock()
+	if closed {
+		_ = c.Close()
+		return ErrReconnectingConnClosed
+	}
+	for i, m := range buffer {
+		if err := c.WriteMessage(m.messageType, m.data); err != nil {
+			// Keep the unwritten messages for the next connection.
+			rc.mu.Lock()
+			rc.buffer = append(buffer[i:], rc.buffer...)
+			rc.mu.Unlock()
+			_ = c.Close()
+			return err
+		}
+	}
+	rc.mu.Lock()
+	rc.conn = c
+	rc.mu.Unlock()
+	return nil
+}
+
+// Conn returns the current connection or nil if disconnected.
+func (rc *ReconnectingConn) Conn() *Conn {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.conn
+}
+
+// ReadMessage reads the next message, reconnecting as needed. ReadMessage
+// returns an error when the connection is closed with Close, when
+// ShouldReconnect returns false or when MaxAttempts is exceeded.
+//
+// The application must not call ReadMessage concurrently.
+func (rc *ReconnectingConn) ReadMessage() (messageType int, p []byte, err error) {
+	for {
+		rc.mu.Lock()
+		c, closed := rc.conn, rc.closed
+		rc.mu.Unlock()
+		if closed {
+			return noFrame, nil, ErrReconnectingConnClosed
+		}
+		if c == nil {
+			if err := rc.reconnect(); err != nil {
+				return noFrame, nil, err
+			}
+			continue
+		}
+		messageType, p, err = c.ReadMessage()
+		if err == nil {
+			return messageType, p, nil
+		}
+
+		rc.mu.Lock()
+		if rc.conn == c {
+			rc.conn = nil
+		}
+		closed = rc.closed
+		rc.mu.Unlock()
+		_ = c.Close()
+		if closed {
+			return noFrame, nil, ErrReconnectingConnClosed
+		}
+		if rc.OnDisconnect != nil {
+			rc.OnDisconnect(err)
+		}
+		if rc.ShouldReconnect != nil && !rc.ShouldReconnect(err) {
+			_ = rc.Close()
+			return noFrame, nil, err
+		}
+	}
+}
+
+// reconnect dials the server until a connection is established.
+func (rc *ReconnectingConn) reconnect() error {
+	rc.mu.Lock()
+	ctx := rc.ctx
+	rc.mu.Unlock()
+	if ctx == nil {
+		return ErrNotConnected
+	}
+
+	minBackoff, maxBackoff := rc.MinBackoff, rc.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		// Full jitter: sleep a random duration up to the current backoff.
+		t := time.NewTimer(rand.N(backoff) + 1)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ErrReconnectingConnClosed
+		}
+
+		c, err := rc.connect(ctx)
+		if err == nil {
+			err = rc.setConn(c)
+			if err == nil || err == ErrReconnectingConnClosed {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return ErrReconnectingConnClosed
+		}
+		if rc.MaxAttempts > 0 && attempt >= rc.MaxAttempts {
+			_ = rc.Close()
+			return err
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// WriteMessage writes a message to the current connection. If the
+// connection is down, the message is buffered as described by BufferSize.
+// WriteMessage returns ErrWriteQueueFull if the buffer is full.
+func (rc *ReconnectingConn) WriteMessage(messageType int, data []byte) error {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+	rc.mu.Lock()
+	c, closed := rc.conn, rc.closed
+	rc.mu.Unlock()
+	if closed {
+		return ErrReconnectingConnClosed
+	}
+	if c != nil {
+		err := c.WriteMessage(messageType, data)
+		if err == nil {
+			return nil
+		}
+		// Closing the connection makes the reader reconnect.
+		_ = c.Close()
+		if rc.BufferSize <= 0 {
+			return err
+		}
+	}
+	if rc.BufferSize <= 0 {
+		return ErrNotConnected
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.buffer) >= rc.BufferSize {
+		return ErrWriteQueueFull
+	}
+	rc.buffer = append(rc.buffer, queuedMessage{messageType: messageType, data: append([]byte(nil), data...)})
+	return nil
+}
+
+// Close closes the current connection and stops reconnecting. Buffered
+// messages are discarded.
+func (rc *ReconnectingConn) Close() error {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return nil
+	}
+	rc.closed = true
+	c := rc.conn
+	rc.conn = nil
+	rc.buffer = nil
+	if rc.cancel != nil {
+		rc.cancel()
+	}
+	rc.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(writeWait))
+	return c.Close()
+}
@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectingConn(t *testing.T) {
+	var accepts atomic.Int32
+	var u Upgrader
+	drop := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if accepts.Add(1) == 1 {
+			// Drop the first connection when the test says so.
+			<-drop
+			return
+		}
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	var connects, disconnects atomic.Int32
+	rc := &ReconnectingConn{
+		URL:        makeWsProto(s.URL),
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+		BufferSize: 10,
+		OnConnect: func(c *Conn) error {
+			connects.Add(1)
+			return c.WriteMessage(TextMessage, []byte("hello"))
+		},
+		OnDisconnect: func(err error) { disconnects.Add(1) },
+	}
+	if err := rc.DialContext(context.Background()); err != nil {
+		t.Fatalf("DialContext: %v", err)
+	}
+	defer rc.Close()
+
+	close(drop)
+	// Wait for the reader to notice the dropped connection, then buffer a
+	// message.
+	read := make(chan string, 4)
+	go func() {
+		for {
+			_, p, err := rc.ReadMessage()
+			if err != nil {
+				close(read)
+				return
+			}
+			read <- string(p)
+		}
+	}()
+	for deadline := time.Now().Add(time.Second); disconnects.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("disconnect not observed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := rc.WriteMessage(TextMessage, []byte("buffered")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	// The server echoes the OnConnect handshake then the buffered message.
+	for _, want := range []string{"hello", "buffered"} {
+		select {
+		case got := <-read:
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+	if n := connects.Load(); n != 2 {
+		t.Fatalf("OnConnect called %d times, want 2", n)
+	}
+
+	rc.Close()
+	if _, ok := <-read; ok {
+		t.Fatal("ReadMessage did not return after Close")
+	}
+	if err := rc.WriteMessage(TextMessage, nil); err != ErrReconnectingConnClosed {
+		t.Fatalf("WriteMessage after Close returned %v", err)
+	}
+}
+
+func TestReconnectingConnMaxAttempts(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+
+	rc := &ReconnectingConn{
+		URL:         makeWsProto(s.URL),
+		MinBackoff:  time.Millisecond,
+		MaxAttempts: 3,
+	}
+	if err := rc.DialContext(context.Background()); err == nil {
+		t.Fatal("DialContext succeeded with closed server")
+	}
+	if _, _, err := rc.ReadMessage(); err == nil || err == ErrReconnectingConnClosed {
+		t.Fatalf("ReadMessage returned %v, want dial error", err)
+	}
+	if _, _, err := rc.ReadMessage(); err != ErrReconnectingConnClosed {
+		t.Fatalf("ReadMessage after giving up returned %v", err)
+	}
+}
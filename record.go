@@ -0,0 +1,319 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The directions of a Record.
+const (
+	RecordIn  = "in"  // received by the recorded connection
+	RecordOut = "out" // sent by the recorded connection
+)
+
+var errAlreadyRecorded = errors.New("websocket: connection is already recorded")
+
+// Record is a message captured by a Recorder.
+//
+// Records are encoded as JSON objects holding the time, the ID of the
+// connection, the direction, the message type and the payload. The payload of
+// a text message is encoded as a string in the "text" field, other payloads
+// are base64 encoded in the "data" field:
+//
+//	{"time":"2024-05-01T12:00:00.000001Z","conn":"0190a5c4-...","dir":"in","type":1,"text":"hello"}
+type Record struct {
+	Time      time.Time
+	Conn      string // the ID of the connection, see Conn.ID
+	Direction string // RecordIn or RecordOut
+	Type      int    // TextMessage, BinaryMessage or a control message type
+	Data      []byte
+}
+
+type recordJSON struct {
+	Time      time.Time `json:"time"`
+	Conn      string    `json:"conn,omitempty"`
+	Direction string    `json:"dir"`
+	Type      int       `json:"type"`
+	Text      *string   `json:"text,omitempty"`
+	Data      []byte    `json:"data,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r Record) MarshalJSON() ([]byte, error) {
+	j := recordJSON{Time: r.Time, Conn: r.Conn, Direction: r.Direction, Type: r.Type}
+	if r.Type == TextMessage && utf8.Valid(r.Data) {
+		text := string(r.Data)
+		j.Text = &text
+	} else {
+		j.Data = r.Data
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *Record) UnmarshalJSON(p []byte) error {
+	var j recordJSON
+	if err := json.Unmarshal(p, &j); err != nil {
+		return err
+	}
+	*r = Record{Time: j.Time, Conn: j.Conn, Direction: j.Direction, Type: j.Type, Data: j.Data}
+	if j.Text != nil {
+		r.Data = []byte(*j.Text)
+	}
+	return nil
+}
+
+// ReadRecords reads the records written by a Recorder.
+func ReadRecords(rd io.Reader) ([]Record, error) {
+	var records []Record
+	dec := json.NewDecoder(bufio.NewReader(rd))
+	for {
+		var r Record
+		if err := dec.Decode(&r); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		records = append(records, r)
+	}
+}
+
+// Recorder writes the messages sent and received by connections to a
+// capture, one JSON record per line. A capture can be replayed with a
+// Replayer to reproduce a session against a server.
+//
+// A Recorder is safe for concurrent use by multiple connections.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+	now func() time.Time
+}
+
+// NewRecorder returns a recorder that writes the capture to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Tap records the messages of the connection: the data messages read and
+// written, and the control messages received and sent. Data messages are
+// recorded by interceptors appended to the connection's chains, so that the
+// inbound interceptors registered before Tap and the outbound interceptors
+// registered after Tap see the messages as recorded. Tap before registering
+// other interceptors to record the messages as they are on the wire.
+//
+// As with UseInbound, the readers returned by NextReader buffer the entire
+// message. A connection can be tapped by one recorder.
+func (r *Recorder) Tap(c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if !c.recorder.CompareAndSwap(nil, r) {
+		return errAlreadyRecorded
+	}
+	c.UseInbound(func(messageType int, data []byte) ([]byte, error) {
+		r.record(c, RecordIn, messageType, data)
+		return data, nil
+	})
+	c.UseOutbound(func(messageType int, data []byte) ([]byte, error) {
+		r.record(c, RecordOut, messageType, data)
+		return data, nil
+	})
+	return nil
+}
+
+// Err returns the first error writing the capture. The recorder stops
+// recording after an error.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(c *Conn, direction string, messageType int, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(Record{
+		Time:      r.now(),
+		Conn:      c.ID(),
+		Direction: direction,
+		Type:      messageType,
+		Data:      data,
+	})
+}
+
+// Replayer sends the messages of a capture to a peer, preserving the time
+// between the messages.
+type Replayer struct {
+	// Direction selects the records sent. If empty, RecordIn is used, which
+	// replays a capture taken by a server against a server. Use RecordOut to
+	// replay a capture taken by a client.
+	Direction string
+
+	// Speed specifies how fast the capture is replayed relative to the
+	// original session: a speed of 10 replays a minute of capture in six
+	// seconds. Use math.Inf(1) to send the messages without delay. If zero,
+	// a default of 1 is used.
+	Speed float64
+
+	// OnMessage, if not nil, is called with the data messages read from the
+	// handler by ReplayHandler.
+	OnMessage func(messageType int, data []byte)
+}
+
+// Replay sends the selected records to the peer of c. Replay returns after
+// the last record is sent, the context is done or a write fails. Replay stops
+// after sending a close message.
+//
+// The application must read from the connection concurrently to process the
+// control messages of the peer.
+func (p *Replayer) Replay(ctx context.Context, c *Conn, records []Record) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	direction := p.Direction
+	if direction == "" {
+		direction = RecordIn
+	}
+	speed := p.Speed
+	if speed == 0 {
+		speed = 1
+	}
+
+	var first time.Time
+	start := time.Now()
+	for _, r := range records {
+		if r.Direction != direction {
+			continue
+		}
+		if first.IsZero() {
+			first = r.Time
+		}
+		if !math.IsInf(speed, 1) {
+			at := start.Add(time.Duration(float64(r.Time.Sub(first)) / speed))
+			if d := time.Until(at); d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return ctx.Err()
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var err error
+		if isControl(r.Type) {
+			err = c.WriteControl(r.Type, r.Data, time.Now().Add(writeWait))
+		} else {
+			err = c.WriteMessage(r.Type, r.Data)
+		}
+		if err != nil {
+			return err
+		}
+		if r.Type == CloseMessage {
+			return nil
+		}
+	}
+	return nil
+}
+
+// ReplayHandler replays the capture into the WebSocket handler h as a client
+// connected in memory, then closes the connection and waits for the handler
+// to close it or for the context to be done.
+func (p *Replayer) ReplayHandler(ctx context.Context, h http.Handler, records []Record) error {
+	l := newPipeListener()
+	srv := &http.Server{Handler: h}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	d := Dialer{NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return l.dial(ctx)
+	}}
+	c, _, err := d.DialContext(ctx, "ws://replay/", nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			messageType, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if p.OnMessage != nil {
+				p.OnMessage(messageType, data)
+			}
+		}
+	}()
+
+	err = p.Replay(ctx, c, records)
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(writeWait))
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return err
+}
+
+// pipeListener is a net.Listener accepting the in-memory connections of dial.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	s, c := net.Pipe()
+	select {
+	case l.conns <- s:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	var capture bytes.Buffer
+	rec := NewRecorder(&capture)
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	if err := rec.Tap(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Tap(s); err != errAlreadyRecorded {
+		t.Errorf("second Tap returned %v", err)
+	}
+
+	go func() {
+		_ = c.WriteMessage(TextMessage, []byte("hello"))
+		_ = c.WriteMessage(BinaryMessage, []byte{0xff, 0})
+		_ = c.WriteControl(PingMessage, []byte("p"), time.Now().Add(time.Second))
+		_ = c.WriteMessage(TextMessage, []byte("done"))
+	}()
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for range 3 {
+		mt, p, err := s.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = s.WriteMessage(mt, p)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(capture.Bytes(), []byte(`"dir":"in","type":1,"text":"hello"}`)) {
+		t.Errorf("text not in capture:\n%s", capture.Bytes())
+	}
+
+	records, err := ReadRecords(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type msg struct {
+		dir  string
+		typ  int
+		data string
+	}
+	var got []msg
+	for _, r := range records {
+		if r.Conn != s.ID() || r.Time.IsZero() {
+			t.Errorf("record %+v", r)
+		}
+		got = append(got, msg{r.Direction, r.Type, string(r.Data)})
+	}
+	want := []msg{
+		{RecordIn, TextMessage, "hello"},
+		{RecordOut, TextMessage, "hello"},
+		{RecordIn, BinaryMessage, "\xff\x00"},
+		{RecordOut, BinaryMessage, "\xff\x00"},
+		{RecordIn, PingMessage, "p"},
+		{RecordOut, PongMessage, "p"},
+		{RecordIn, TextMessage, "done"},
+		{RecordOut, TextMessage, "done"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records\n%v, want\n%v", got, want)
+	}
+}
+
+func TestReplayHandler(t *testing.T) {
+	now := time.Now()
+	records := []Record{
+		{Time: now, Direction: RecordIn, Type: TextMessage, Data: []byte("one")},
+		{Time: now.Add(time.Millisecond), Direction: RecordOut, Type: TextMessage, Data: []byte("reply")},
+		{Time: now.Add(time.Second), Direction: RecordIn, Type: BinaryMessage, Data: []byte("two")},
+		{Time: now.Add(2 * time.Second), Direction: RecordIn, Type: CloseMessage, Data: FormatCloseMessage(CloseNormalClosure, "")},
+		{Time: now.Add(3 * time.Second), Direction: RecordIn, Type: TextMessage, Data: []byte("after close")},
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var u Upgrader
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = c.WriteMessage(mt, append([]byte("echo "), p...))
+		}
+	})
+	p := Replayer{
+		Speed: 100,
+		OnMessage: func(messageType int, data []byte) {
+			mu.Lock()
+			got = append(got, string(data))
+			mu.Unlock()
+		},
+	}
+	start := time.Now()
+	if err := p.ReplayHandler(context.Background(), h, records); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond || d > time.Second {
+		t.Errorf("replay took %v at 100x speed", d)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"echo one", "echo two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handler replied %q, want %q", got, want)
+	}
+
+	p.Speed = math.Inf(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.ReplayHandler(ctx, h, records); err == nil {
+		t.Error("ReplayHandler with a canceled context returned no error")
+	}
+}
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// ErrTooManyRedirects is returned by Dial when the server redirects the
+// handshake more than Dialer.MaxRedirects times.
+var ErrTooManyRedirects = errors.New("websocket: stopped after too many redirects")
+
+const defaultMaxRedirects = 10
+
+// dialRedirects dials urlStr, following the redirect responses to the
+// handshakes.
+func (d *Dialer) dialRedirects(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	maxRedirects := d.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	var via []*http.Request
+	for {
+		conn, resp, err := d.dial(ctx, urlStr, requestHeader)
+		if conn != nil || resp == nil || resp.Request == nil {
+			return conn, resp, err
+		}
+		target := redirectURL(resp)
+		if target == nil {
+			return conn, resp, err
+		}
+		via = append(via, resp.Request)
+		if len(via) > maxRedirects {
+			return nil, resp, ErrTooManyRedirects
+		}
+
+		header := requestHeader.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		if target.Hostname() != resp.Request.URL.Hostname() {
+			// Do not leak credentials or a forced Host to another host.
+			for _, k := range []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2", "Host"} {
+				delete(header, k)
+			}
+		}
+		next := (&http.Request{
+			Method: http.MethodGet,
+			URL:    target,
+			Header: header,
+			Host:   target.Host,
+		}).WithContext(ctx)
+		if d.CheckRedirect != nil {
+			if cerr := d.CheckRedirect(next, via); cerr != nil {
+				if cerr == http.ErrUseLastResponse {
+					return nil, resp, err
+				}
+				return nil, resp, cerr
+			}
+		}
+		urlStr, requestHeader = next.URL.String(), next.Header
+	}
+}
+
+// redirectURL returns the WebSocket URL of the location of a redirect
+// response, or nil if resp is not a redirect to a WebSocket URL.
+func redirectURL(resp *http.Response) *url.URL {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil
+	}
+	target, err := resp.Request.URL.Parse(loc)
+	if err != nil {
+		return nil
+	}
+	switch target.Scheme {
+	case "http", "ws":
+		target.Scheme = "ws"
+	case "https", "wss":
+		target.Scheme = "wss"
+	default:
+		return nil
+	}
+	return target
+}
@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newRedirectServer returns a server redirecting /loop to itself and
+// /redirect to the location in the "to" query parameter, and upgrading the
+// other requests. The server reports the Authorization header of the
+// upgraded requests on auth.
+func newRedirectServer(t *testing.T, auth chan<- string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		case "/redirect":
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusTemporaryRedirect)
+			return
+		}
+		c, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		auth <- r.Header.Get("Authorization")
+		c.Close()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestDialFollowRedirects(t *testing.T) {
+	auth := make(chan string, 1)
+	s := newRedirectServer(t, auth)
+	base := "ws" + strings.TrimPrefix(s.URL, "http")
+	header := http.Header{"Authorization": {"Bearer token"}}
+
+	// Redirects are not followed by default.
+	_, resp, err := DefaultDialer.Dial(base+"/redirect?to=/ws", header)
+	if !errors.Is(err, ErrBadHandshake) || resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("Dial returned %v, %v", resp, err)
+	}
+
+	d := Dialer{FollowRedirects: true}
+	c, resp, err := d.Dial(base+"/redirect?to=/ws", header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	c.Close()
+	if resp.Request.URL.Path != "/ws" {
+		t.Errorf("upgraded at %s, want /ws", resp.Request.URL.Path)
+	}
+	if got := <-auth; got != "Bearer token" {
+		t.Errorf("Authorization %q on the same host", got)
+	}
+
+	// Credentials are not sent to another host.
+	other := strings.Replace(s.URL, "127.0.0.1", "localhost", 1)
+	c, _, err = d.Dial(base+"/redirect?to="+other+"/ws", header)
+	if err != nil {
+		t.Fatalf("Dial to another host: %v", err)
+	}
+	c.Close()
+	if got := <-auth; got != "" {
+		t.Errorf("Authorization %q sent to another host", got)
+	}
+}
+
+func TestDialRedirectLimits(t *testing.T) {
+	s := newRedirectServer(t, make(chan string, 1))
+	base := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	d := Dialer{FollowRedirects: true, MaxRedirects: 3}
+	if _, _, err := d.Dial(base+"/loop", nil); err != ErrTooManyRedirects {
+		t.Fatalf("Dial returned %v, want %v", err, ErrTooManyRedirects)
+	}
+
+	var hops int
+	d.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		hops = len(via)
+		return http.ErrUseLastResponse
+	}
+	_, resp, err := d.Dial(base+"/loop", nil)
+	if !errors.Is(err, ErrBadHandshake) || resp.StatusCode != http.StatusFound || hops != 1 {
+		t.Fatalf("Dial returned %v, %v after %d hops", resp, err, hops)
+	}
+
+	errPolicy := errors.New("redirect not allowed")
+	d.CheckRedirect = func(req *http.Request, via []*http.Request) error { return errPolicy }
+	if _, _, err := d.Dial(base+"/loop", nil); err != errPolicy {
+		t.Fatalf("Dial returned %v, want %v", err, errPolicy)
+	}
+}
+
+func TestDialerClientRedirect(t *testing.T) {
+	s := newRedirectServer(t, make(chan string, 1))
+	netConn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+
+	// The redirect is returned instead of being dialed over the closed
+	// network connection.
+	d := Dialer{FollowRedirects: true}
+	_, resp, err := d.Client(context.Background(), netConn, "ws"+strings.TrimPrefix(s.URL, "http")+"/redirect?to=/ws", nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("Client returned %v, %v, want the redirect response", resp, err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package redisbroker relays websocket hub broadcasts between processes with
+// Redis Pub/Sub.
+//
+// Give every hub a broker using the same Redis server and channel:
+//
+//	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	hub := &websocket.Hub{Broker: redisbroker.New(rdb, "chat")}
+//
+// A broadcast to a room is then delivered to the members of the room on
+// every node. Redis Pub/Sub does not store messages; broadcasts published
+// while a node is disconnected from Redis are lost for that node.
+package redisbroker
+
+import (
+	"context"
+
+	"github.com/gflydev/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultChannel is the Redis channel used when Broker.Channel is empty.
+const DefaultChannel = "websocket"
+
+// Broker is a websocket.Broker backed by a Redis Pub/Sub channel.
+type Broker struct {
+	// Client is the Redis client used to publish and subscribe.
+	Client redis.UniversalClient
+
+	// Channel is the Redis channel carrying the broadcasts. If empty,
+	// DefaultChannel is used. Hubs that should share broadcasts must use
+	// the same channel.
+	Channel string
+}
+
+var _ websocket.Broker = (*Broker)(nil)
+
+// New returns a broker publishing to channel with client.
+func New(client redis.UniversalClient, channel string) *Broker {
+	return &Broker{Client: client, Channel: channel}
+}
+
+func (b *Broker) channel() string {
+	if b.Channel == "" {
+		return DefaultChannel
+	}
+	return b.Channel
+}
+
+// Publish publishes m to the Redis channel.
+func (b *Broker) Publish(ctx context.Context, m *websocket.BrokerMessage) error {
+	p, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return b.Client.Publish(ctx, b.channel(), p).Err()
+}
+
+// Subscribe subscribes to the Redis channel and calls f for each message
+// until ctx is done. Messages that cannot be decoded are dropped.
+func (b *Broker) Subscribe(ctx context.Context, f func(m *websocket.BrokerMessage)) error {
+	ps := b.Client.Subscribe(ctx, b.channel())
+	defer ps.Close()
+
+	// Wait for the subscription to be confirmed so that connection errors
+	// are reported to the hub.
+	if _, err := ps.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	ch := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return redis.ErrClosed
+			}
+			var m websocket.BrokerMessage
+			if err := m.UnmarshalBinary([]byte(msg.Payload)); err != nil {
+				continue
+			}
+			f(&m)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package redisbroker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gflydev/websocket"
+	"github.com/gflydev/websocket/brokertest"
+	"github.com/redis/go-redis/v9"
+)
+
+// serve returns the URL of a server adding its connections to room of hub.
+func serve(t *testing.T, hub *websocket.Hub, room string) string {
+	t.Helper()
+	var u websocket.Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		if err := hub.Join(room, c); err != nil {
+			_ = c.Close()
+			return
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				hub.Remove(c)
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func readString(t *testing.T, c *websocket.Conn) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	return string(p)
+}
+
+func TestBroker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	newHub := func() *websocket.Hub {
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = rdb.Close() })
+		h := &websocket.Hub{Broker: New(rdb, "test")}
+		t.Cleanup(func() { _ = h.Close() })
+		return h
+	}
+	h1, h2 := newHub(), newHub()
+
+	c1 := dial(t, serve(t, h1, "a"))
+	c2 := dial(t, serve(t, h2, "a"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h1.Len("a") == 0 || h2.Len("a") == 0 || mr.PubSubNumSub("test")["test"] < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for subscriptions")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := h1.Broadcast("a", websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c1); got != "hello" {
+		t.Fatalf("local member got %q", got)
+	}
+	if got := readString(t, c2); got != "hello" {
+		t.Fatalf("remote member got %q", got)
+	}
+
+	if err := h2.BroadcastAll(websocket.BinaryMessage, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*websocket.Conn{c1, c2} {
+		if got := readString(t, c); got != "\x01\x02\x03" {
+			t.Fatalf("got %q", got)
+		}
+	}
+}
+
+func TestBrokerDefaultChannel(t *testing.T) {
+	b := &Broker{}
+	if got := b.channel(); got != DefaultChannel {
+		t.Fatalf("channel() = %q, want %q", got, DefaultChannel)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, brokertest.Suite{
+		NewBrokers: func(t *testing.T, n int) ([]websocket.Broker, func()) {
+			mr := miniredis.RunT(t)
+			brokers := make([]websocket.Broker, n)
+			for i := range brokers {
+				rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+				t.Cleanup(func() { _ = rdb.Close() })
+				brokers[i] = New(rdb, "test")
+			}
+			restart := func() {
+				mr.Close()
+				if err := mr.Restart(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return brokers, restart
+		},
+	})
+}
@@ -0,0 +1,236 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// newConnID returns a UUIDv7 string: the time in milliseconds followed by
+// random bits, so that IDs sort by creation time.
+func newConnID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // variant 10
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// ID returns the unique ID assigned to the connection when it was created, a
+// UUID version 7 string.
+func (c *Conn) ID() string {
+	if c == nil {
+		return ""
+	}
+	return c.id
+}
+
+// ErrNotRegistered is returned by Registry.Index when the connection was not
+// added to the registry.
+var ErrNotRegistered = errors.New("websocket: connection is not registered")
+
+// Registry looks up connections by ID and by index keys, such as the ID of
+// the authenticated user, so that HTTP handlers and other goroutines can
+// write to specific connections:
+//
+//	for _, c := range registry.Lookup("user:42") {
+//		c.WriteMessage(websocket.TextMessage, notification)
+//	}
+//
+// Writes from other goroutines are concurrent with the writes of the
+// goroutine serving the connection; use Conn.EnableWriteQueue to make them
+// safe.
+//
+// Connections are added with Add or by setting the Registry field of
+// Upgrader or FastHTTPUpgrader. A connection is removed with all its keys
+// when it is closed with Conn.Close or when Remove is called.
+//
+// It is safe to call Registry's methods concurrently. The zero value is
+// ready to use.
+type Registry struct {
+	mu    sync.RWMutex
+	conns map[string]*registryEntry     // by connection ID
+	index map[string]map[*Conn]struct{} // by key
+}
+
+type registryEntry struct {
+	conn    *Conn
+	keys    map[string]struct{}
+	removed chan struct{}
+}
+
+// Add adds the connection to the registry.
+func (r *Registry) Add(c *Conn) {
+	if c == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.conns[c.id]; ok {
+		return
+	}
+	if r.conns == nil {
+		r.conns = make(map[string]*registryEntry)
+		r.index = make(map[string]map[*Conn]struct{})
+	}
+	e := &registryEntry{conn: c, keys: make(map[string]struct{}), removed: make(chan struct{})}
+	r.conns[c.id] = e
+	if c.closed != nil {
+		go func() {
+			select {
+			case <-c.closed:
+				r.Remove(c)
+			case <-e.removed:
+			}
+		}()
+	}
+}
+
+// Remove removes the connection and its keys from the registry. Remove does
+// not close the connection.
+func (r *Registry) Remove(c *Conn) {
+	if c == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.conns[c.id]
+	if !ok {
+		return
+	}
+	for key := range e.keys {
+		r.unindex(c, key)
+	}
+	delete(r.conns, c.id)
+	close(e.removed)
+}
+
+// Get returns the connection with the ID, or nil if the registry has no
+// such connection.
+func (r *Registry) Get(id string) *Conn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if e, ok := r.conns[id]; ok {
+		return e.conn
+	}
+	return nil
+}
+
+// Index associates the key with the connection. A key can be associated
+// with any number of connections, and a connection with any number of
+// keys. Index returns ErrNotRegistered if the connection was not added to
+// the registry.
+func (r *Registry) Index(c *Conn, key string) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.conns[c.id]
+	if !ok {
+		return ErrNotRegistered
+	}
+	e.keys[key] = struct{}{}
+	conns := r.index[key]
+	if conns == nil {
+		conns = make(map[*Conn]struct{})
+		r.index[key] = conns
+	}
+	conns[c] = struct{}{}
+	return nil
+}
+
+// Unindex removes the association of the key with the connection.
+func (r *Registry) Unindex(c *Conn, key string) {
+	if c == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.conns[c.id]; ok {
+		delete(e.keys, key)
+		r.unindex(c, key)
+	}
+}
+
+// unindex removes c from the connections of the key. The registry lock must
+// be held.
+func (r *Registry) unindex(c *Conn, key string) {
+	conns := r.index[key]
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(r.index, key)
+	}
+}
+
+// Lookup returns the connections associated with the key, in no particular
+// order.
+func (r *Registry) Lookup(key string) []*Conn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	conns := make([]*Conn, 0, len(r.index[key]))
+	for c := range r.index[key] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Keys returns the keys associated with the connection, in no particular
+// order.
+func (r *Registry) Keys(c *Conn) []string {
+	if c == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.conns[c.id]
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(e.keys))
+	for key := range e.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Range calls f for each connection in the registry, in no particular
+// order. If f returns false, Range stops the iteration. Range iterates over a
+// snapshot of the registry, so f may call other Registry methods.
+func (r *Registry) Range(f func(c *Conn) bool) {
+	r.mu.RLock()
+	conns := make([]*Conn, 0, len(r.conns))
+	for _, e := range r.conns {
+		conns = append(conns, e.conn)
+	}
+	r.mu.RUnlock()
+	for _, c := range conns {
+		if !f(c) {
+			return
+		}
+	}
+}
+
+// Len returns the number of connections in the registry.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.conns)
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var uuidv7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestConnID(t *testing.T) {
+	seen := make(map[string]bool)
+	prev := ""
+	for i := 0; i < 100; i++ {
+		id := newConnID()
+		if !uuidv7.MatchString(id) {
+			t.Fatalf("ID %q is not a UUIDv7", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = true
+		if id[:13] < prev {
+			t.Fatalf("ID %q sorts before %q", id, prev)
+		}
+		prev = id[:13]
+	}
+
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	if s.ID() == "" || s.ID() == c.ID() {
+		t.Fatalf("IDs %q and %q", s.ID(), c.ID())
+	}
+	var nilConn *Conn
+	if nilConn.ID() != "" {
+		t.Fatal("nil Conn has an ID")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	a, pa := newPipeConns()
+	b, pb := newPipeConns()
+	defer pa.Close()
+	defer pb.Close()
+	defer b.Close()
+
+	if err := r.Index(a, "user:1"); err != ErrNotRegistered {
+		t.Fatalf("Index of unregistered connection returned %v", err)
+	}
+	r.Add(a)
+	r.Add(b)
+	r.Add(a)
+	if r.Len() != 2 || r.Get(a.ID()) != a || r.Get(b.ID()) != b || r.Get("nope") != nil {
+		t.Fatal("Get did not return the registered connections")
+	}
+	for _, k := range []struct {
+		c   *Conn
+		key string
+	}{{a, "user:1"}, {a, "room:x"}, {b, "user:1"}} {
+		if err := r.Index(k.c, k.key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := r.Lookup("user:1"); len(got) != 2 {
+		t.Fatalf("Lookup returned %d connections", len(got))
+	}
+	keys := r.Keys(a)
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "room:x,user:1" {
+		t.Fatalf("Keys returned %v", keys)
+	}
+	r.Unindex(a, "room:x")
+	if got := r.Lookup("room:x"); len(got) != 0 {
+		t.Fatalf("Lookup after Unindex returned %d connections", len(got))
+	}
+
+	// Closing a connection removes it with its keys.
+	a.Close()
+	deadline := time.Now().Add(time.Second)
+	for r.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("closed connection not removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := r.Lookup("user:1"); len(got) != 1 || got[0] != b || r.Get(a.ID()) != nil {
+		t.Fatal("closed connection still indexed")
+	}
+
+	r.Remove(b)
+	if r.Len() != 0 || len(r.Lookup("user:1")) != 0 || r.Keys(b) != nil {
+		t.Fatal("removed connection still registered")
+	}
+}
+
+func TestUpgraderRegistry(t *testing.T) {
+	var r Registry
+	u := Upgrader{Registry: &r}
+	ids := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c, err := u.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		_ = r.Index(c, "user:"+req.URL.Query().Get("user"))
+		ids <- c.ID()
+	}))
+	defer s.Close()
+	c, _, err := DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"?user=42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	id := <-ids
+	conns := r.Lookup("user:42")
+	if len(conns) != 1 || conns[0].ID() != id {
+		t.Fatalf("Lookup returned %v", conns)
+	}
+	if err := conns[0].WriteMessage(TextMessage, []byte("notify")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c); got != "notify" {
+		t.Fatalf("read %q", got)
+	}
+	conns[0].Close()
+}
@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ErrRemoteAddrDenied is returned by Upgrade when the client address is
+// rejected by the RemoteAddrPolicy of the Upgrader.
+var ErrRemoteAddrDenied = errors.New("websocket: client address not allowed")
+
+// RemoteAddrPolicy resolves the address of the client of a request behind
+// trusted reverse proxies and restricts the addresses allowed to connect.
+//
+// The client address is the address of the peer of the HTTP connection
+// unless the peer is a trusted proxy. Requests from a trusted proxy are
+// attributed to the address in the Forwarded, X-Forwarded-For or X-Real-IP
+// header, checked in that order. Addresses appended by trusted proxies are
+// skipped from the right so that a client cannot choose its address by
+// sending the headers itself.
+type RemoteAddrPolicy struct {
+	// TrustedProxies lists the networks of the proxies whose forwarding
+	// headers are trusted. If empty, the headers are ignored.
+	TrustedProxies []netip.Prefix
+
+	// Allow, if not empty, lists the networks of the client addresses
+	// allowed to connect. Other addresses are rejected.
+	Allow []netip.Prefix
+
+	// Deny lists the networks of the client addresses rejected. Deny takes
+	// precedence over Allow.
+	Deny []netip.Prefix
+}
+
+// ClientIP returns the address of the client of the request. It returns the
+// zero Addr if the address cannot be parsed.
+func (p *RemoteAddrPolicy) ClientIP(r *http.Request) netip.Addr {
+	peer := parseRemoteIP(r.RemoteAddr)
+	if p == nil || !peer.IsValid() || !containsAddr(p.TrustedProxies, peer) {
+		return peer
+	}
+	if chain := forwardedFor(r.Header.Values("Forwarded")); len(chain) > 0 {
+		return p.resolve(peer, chain)
+	}
+	if chain := splitList(r.Header.Values("X-Forwarded-For")); len(chain) > 0 {
+		return p.resolve(peer, chain)
+	}
+	if ip := parseForwardedIP(r.Header.Get("X-Real-IP")); ip.IsValid() {
+		return ip
+	}
+	return peer
+}
+
+// Allowed reports whether the client address ip may connect.
+func (p *RemoteAddrPolicy) Allowed(ip netip.Addr) bool {
+	if p == nil {
+		return true
+	}
+	if !ip.IsValid() {
+		return len(p.Allow) == 0 && len(p.Deny) == 0
+	}
+	if containsAddr(p.Deny, ip) {
+		return false
+	}
+	return len(p.Allow) == 0 || containsAddr(p.Allow, ip)
+}
+
+// resolve walks the chain of forwarded addresses from the right, starting at
+// the trusted peer, and returns the first address not of a trusted proxy.
+func (p *RemoteAddrPolicy) resolve(peer netip.Addr, chain []string) netip.Addr {
+	ip := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		next := parseForwardedIP(chain[i])
+		if !next.IsValid() {
+			// Stop at an address that cannot be parsed, such as an
+			// obfuscated identifier, and use the last address known.
+			break
+		}
+		ip = next
+		if !containsAddr(p.TrustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRemoteIP parses the IP address of a host:port or host address.
+func parseRemoteIP(addr string) netip.Addr {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// parseForwardedIP parses an address of a forwarding header, with or
+// without quotes, brackets and port.
+func parseForwardedIP(s string) netip.Addr {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap()
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// splitList returns the elements of comma-separated header values.
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+	}
+	return list
+}
+
+// forwardedFor returns the for= parameters of Forwarded header values
+// (RFC 7239), in order.
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, element := range splitList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(k, "for") {
+				chain = append(chain, v)
+			}
+		}
+	}
+	return chain
+}
+
+// RealIP returns the address of the client of the connection. For server
+// connections created by an Upgrader with a RemoteAddrPolicy, it is the
+// address resolved by the policy. Otherwise, it is the address of the peer
+// of the network connection. It returns the zero Addr if the address is not
+// an IP address.
+func (c *Conn) RealIP() netip.Addr {
+	if c == nil {
+		return netip.Addr{}
+	}
+	if c.realIP.IsValid() {
+		return c.realIP
+	}
+	if c.conn == nil || c.conn.RemoteAddr() == nil {
+		return netip.Addr{}
+	}
+	return parseRemoteIP(c.conn.RemoteAddr().String())
+}
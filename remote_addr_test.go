@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+var clientIPTests = []struct {
+	name       string
+	remoteAddr string
+	header     http.Header
+	want       string
+}{
+	{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+	{"untrusted peer", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1"},
+	{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	{"x-forwarded-for", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+	{"spoofed", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"203.0.113.9, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+	{"all trusted", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3", "10.0.0.2"}}, "10.0.0.3"},
+	{"invalid", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"unknown, 10.0.0.2"}}, "10.0.0.2"},
+	{"forwarded", "10.0.0.1:1234", http.Header{"Forwarded": {`for=198.51.100.1;proto=https, for="[2001:db8::1]:4711"`}}, "2001:db8::1"},
+	{"forwarded first", "10.0.0.1:1234", http.Header{"Forwarded": {"for=198.51.100.2"}, "X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.2"},
+	{"x-real-ip", "10.0.0.1:1234", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+	{"mapped", "[::ffff:192.0.2.1]:1234", nil, "192.0.2.1"},
+	{"not ip", "pipe", nil, "invalid IP"},
+}
+
+func TestRemoteAddrPolicyClientIP(t *testing.T) {
+	p := &RemoteAddrPolicy{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	for _, tt := range clientIPTests {
+		r := &http.Request{RemoteAddr: tt.remoteAddr, Header: tt.header}
+		if got := p.ClientIP(r).String(); got != tt.want {
+			t.Errorf("%s: ClientIP() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteAddrPolicyAllowed(t *testing.T) {
+	p := &RemoteAddrPolicy{
+		Allow: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("192.0.2.128/25")},
+	}
+	for _, tt := range []struct {
+		ip   netip.Addr
+		want bool
+	}{
+		{netip.MustParseAddr("192.0.2.1"), true},
+		{netip.MustParseAddr("192.0.2.200"), false},
+		{netip.MustParseAddr("198.51.100.1"), false},
+		{netip.Addr{}, false},
+	} {
+		if got := p.Allowed(tt.ip); got != tt.want {
+			t.Errorf("Allowed(%v) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	var nilPolicy *RemoteAddrPolicy
+	if !nilPolicy.Allowed(netip.Addr{}) {
+		t.Error("nil policy rejected an address")
+	}
+}
+
+func TestUpgradeRemoteAddrPolicy(t *testing.T) {
+	realIP := make(chan netip.Addr, 1)
+	u := Upgrader{RemoteAddrPolicy: &RemoteAddrPolicy{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		Deny:           []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+	}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		realIP <- c.RealIP()
+		c.Close()
+	}))
+	defer s.Close()
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	c, _, err := DefaultDialer.Dial(url, http.Header{"X-Forwarded-For": {"198.51.100.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if ip := <-realIP; ip != netip.MustParseAddr("198.51.100.1") {
+		t.Errorf("RealIP() = %v, want 198.51.100.1", ip)
+	}
+	if ip := c.RealIP(); ip != netip.MustParseAddr("127.0.0.1") {
+		t.Errorf("client RealIP() = %v, want 127.0.0.1", ip)
+	}
+
+	_, resp, err := DefaultDialer.Dial(url, http.Header{"X-Forwarded-For": {"203.0.113.1"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial from a denied address returned %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,546 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultResumeTTL     = 2 * time.Minute
+	defaultResumeTimeout = 10 * time.Second
+	resumeWriteQueueSize = 64
+)
+
+// ErrBadResumeRequest is returned by ResumeServer.Accept when the first
+// message of the client is not a resume request, and by ResumeClient when
+// the answer of the server is not a resume answer.
+var ErrBadResumeRequest = errors.New("websocket: bad resume request")
+
+// The resume protocol lets a client reconnect to its session after the loss
+// of its connection. The first message of the client on each connection is
+// the text message
+//
+//	resume:<token>:<last>
+//
+// where token identifies the session, empty for a new session, and last is
+// the sequence number of the last message received in the session. The
+// server answers with
+//
+//	resumed:<token>
+//
+// when it restores the session, followed by the messages of the session
+// after last, or with
+//
+//	fresh:<token>
+//
+// when it starts a new session. The messages of the session are then
+// delivered with the at-least-once delivery messages of Outbox and Inbox.
+var (
+	resumePrefix  = []byte("resume:")
+	resumedPrefix = []byte("resumed:")
+	freshPrefix   = []byte("fresh:")
+)
+
+// ResumeServer keeps the sessions of the clients using ResumeClient, so that
+// a client reconnecting after a network blip gets back its room memberships
+// and the messages sent while it was disconnected. The sessions are kept in
+// memory; route reconnecting clients to the same node, for example with
+// StickySessions.
+//
+//	rs := &websocket.ResumeServer{}
+//
+//	// In the handler, after Upgrade:
+//	session, resumed, err := rs.Accept(c)
+//	if err != nil {
+//		c.Close()
+//		return
+//	}
+//	if !resumed {
+//		session.Join("news")
+//	}
+//	// Read loop; the acknowledgments of the client are consumed.
+//
+//	// From any goroutine:
+//	rs.Broadcast("news", websocket.TextMessage, data)
+//
+// It is safe to call ResumeServer's methods concurrently. The zero value is
+// ready to use.
+type ResumeServer struct {
+	// TTL is how long the session of a disconnected client is kept. If zero,
+	// a default of 2 minutes is used.
+	TTL time.Duration
+
+	// OutboxSize is the maximum number of unacknowledged messages of a
+	// session, as Outbox.Size.
+	OutboxSize int
+
+	// Timeout is the maximum time to wait for the resume request of a
+	// client. If zero, a default of 10 seconds is used.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*ResumeSession
+	rooms    map[string]map[*ResumeSession]struct{}
+}
+
+// ResumeSession is the session of a client of a ResumeServer. It outlives
+// the connections of the client until the client stays disconnected longer
+// than ResumeServer.TTL.
+type ResumeSession struct {
+	id     string
+	server *ResumeServer
+	outbox Outbox
+
+	mu         sync.Mutex
+	conn       *Conn
+	rooms      map[string]struct{}
+	detachedAt time.Time
+	ended      bool
+}
+
+// Accept reads the resume request of the client from the connection, which
+// must not be read concurrently, and answers it. If the client presents the
+// token of a session kept by the server, Accept resumes the session on the
+// connection and retransmits the messages the client did not receive;
+// otherwise it starts a new session. Accept reports whether the session was
+// resumed.
+//
+// Accept enables the write queue of the connection, since the messages of
+// the session are written from the goroutines calling Send and Broadcast,
+// and consumes the acknowledgments in the read methods of the connection.
+func (s *ResumeServer) Accept(c *Conn) (session *ResumeSession, resumed bool, err error) {
+	if c == nil {
+		return nil, false, ErrNilConn
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultResumeTimeout
+	}
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	messageType, p, err := c.ReadMessage()
+	if err != nil {
+		return nil, false, err
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	token, last, ok := parseResumeRequest(messageType, p)
+	if !ok {
+		return nil, false, ErrBadResumeRequest
+	}
+
+	s.mu.Lock()
+	session = s.sessions[token]
+	resumed = session != nil
+	if !resumed {
+		session = &ResumeSession{id: newSessionID(), server: s, rooms: make(map[string]struct{})}
+		session.outbox.Size = s.OutboxSize
+		if s.sessions == nil {
+			s.sessions = make(map[string]*ResumeSession)
+		}
+		s.sessions[session.id] = session
+	}
+	s.mu.Unlock()
+
+	_ = c.EnableWriteQueue(resumeWriteQueueSize, OverflowBlock)
+	answer := freshPrefix
+	if resumed {
+		answer = resumedPrefix
+		session.outbox.Ack(last)
+	}
+	if err := c.WriteMessage(TextMessage, append(append([]byte(nil), answer...), session.id...)); err != nil {
+		if !resumed {
+			s.remove(session)
+		}
+		return nil, false, err
+	}
+	c.UseInbound(func(messageType int, data []byte) ([]byte, error) {
+		if session.outbox.HandleAck(messageType, data) {
+			return nil, ErrDropMessage
+		}
+		return data, nil
+	})
+	if err := session.attach(c); err != nil {
+		session.detach(c)
+		return nil, false, err
+	}
+	go func() {
+		<-c.Done()
+		session.detach(c)
+	}()
+	return session, resumed, nil
+}
+
+func parseResumeRequest(messageType int, p []byte) (token string, last uint64, ok bool) {
+	if messageType != TextMessage || !bytes.HasPrefix(p, resumePrefix) {
+		return "", 0, false
+	}
+	p = p[len(resumePrefix):]
+	i := bytes.LastIndexByte(p, ':')
+	if i < 0 {
+		return "", 0, false
+	}
+	last, err := strconv.ParseUint(string(p[i+1:]), 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return string(p[:i]), last, true
+}
+
+// Session returns the session with the ID, or nil if the server does not
+// keep it.
+func (s *ResumeServer) Session(id string) *ResumeSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+// Len returns the number of sessions kept by the server.
+func (s *ResumeServer) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Broadcast sends the message to the sessions that joined the room, and
+// returns the number of sessions. The message is retransmitted to the
+// clients that are disconnected when they resume their session. Sessions
+// whose replay buffer is full do not get the message.
+func (s *ResumeServer) Broadcast(room string, messageType int, data []byte) int {
+	s.mu.Lock()
+	members := make([]*ResumeSession, 0, len(s.rooms[room]))
+	for ss := range s.rooms[room] {
+		members = append(members, ss)
+	}
+	s.mu.Unlock()
+	for _, ss := range members {
+		_, _ = ss.Send(messageType, data)
+	}
+	return len(members)
+}
+
+// End ends the session: the server forgets it and closes its connection,
+// if any, with the code and reason. A ResumeClient does not resume a session
+// closed with CloseNormalClosure or ClosePolicyViolation.
+func (s *ResumeServer) End(ss *ResumeSession, code int, reason string) error {
+	if ss == nil {
+		return nil
+	}
+	s.remove(ss)
+	ss.mu.Lock()
+	ss.ended = true
+	c := ss.conn
+	ss.conn = nil
+	ss.mu.Unlock()
+	ss.outbox.Detach(c)
+	if c == nil {
+		return nil
+	}
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	return c.Close()
+}
+
+// remove forgets the session and its room memberships.
+func (s *ResumeServer) remove(ss *ResumeSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[ss.id] == ss {
+		delete(s.sessions, ss.id)
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for room := range ss.rooms {
+		s.leave(room, ss)
+	}
+}
+
+// leave removes the session from the room. The caller must hold s.mu.
+func (s *ResumeServer) leave(room string, ss *ResumeSession) {
+	members := s.rooms[room]
+	delete(members, ss)
+	if len(members) == 0 {
+		delete(s.rooms, room)
+	}
+}
+
+// ID returns the ID of the session, which is also the resume token of the
+// client.
+func (ss *ResumeSession) ID() string {
+	return ss.id
+}
+
+// Conn returns the connection of the session, or nil if the client is
+// disconnected.
+func (ss *ResumeSession) Conn() *Conn {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.conn
+}
+
+// Send sends the message to the client with at-least-once delivery. If the
+// client is disconnected, the message is written when the client resumes the
+// session. Send returns ErrOutboxFull if the replay buffer of the session is
+// full.
+func (ss *ResumeSession) Send(messageType int, data []byte) (seq uint64, err error) {
+	return ss.outbox.Send(messageType, data)
+}
+
+// Pending returns the number of messages not acknowledged by the client.
+func (ss *ResumeSession) Pending() int {
+	return ss.outbox.Pending()
+}
+
+// Join adds the session to the room. The membership is kept while the
+// client is disconnected.
+func (ss *ResumeSession) Join(room string) {
+	s := ss.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.ended {
+		return
+	}
+	ss.rooms[room] = struct{}{}
+	if s.rooms == nil {
+		s.rooms = make(map[string]map[*ResumeSession]struct{})
+	}
+	if s.rooms[room] == nil {
+		s.rooms[room] = make(map[*ResumeSession]struct{})
+	}
+	s.rooms[room][ss] = struct{}{}
+}
+
+// Leave removes the session from the room.
+func (ss *ResumeSession) Leave(room string) {
+	s := ss.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.rooms, room)
+	s.leave(room, ss)
+}
+
+// Rooms returns the rooms joined by the session.
+func (ss *ResumeSession) Rooms() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	rooms := make([]string, 0, len(ss.rooms))
+	for room := range ss.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// attach makes c the connection of the session and retransmits the
+// unacknowledged messages.
+func (ss *ResumeSession) attach(c *Conn) error {
+	ss.mu.Lock()
+	old := ss.conn
+	ss.conn = c
+	ss.mu.Unlock()
+	if old != nil && old != c {
+		// The client reconnected before the old connection timed out.
+		_ = old.Close()
+	}
+	return ss.outbox.Attach(c)
+}
+
+// detach detaches c from the session and forgets the session if the client
+// does not resume it within the TTL.
+func (ss *ResumeSession) detach(c *Conn) {
+	ss.outbox.Detach(c)
+	ss.mu.Lock()
+	if ss.conn != c || ss.ended {
+		ss.mu.Unlock()
+		return
+	}
+	ss.conn = nil
+	now := time.Now()
+	ss.detachedAt = now
+	ss.mu.Unlock()
+
+	ttl := ss.server.TTL
+	if ttl <= 0 {
+		ttl = defaultResumeTTL
+	}
+	time.AfterFunc(ttl, func() {
+		ss.mu.Lock()
+		expired := ss.conn == nil && ss.detachedAt.Equal(now)
+		ss.mu.Unlock()
+		if expired {
+			ss.server.remove(ss)
+		}
+	})
+}
+
+// ResumeClient resumes the session of a client of a ResumeServer on each
+// connection of a ReconnectingConn, so that the client survives network
+// blips without losing messages or subscriptions:
+//
+//	rc := &websocket.ReconnectingConn{URL: url}
+//	resume := &websocket.ResumeClient{
+//		OnFresh: func(c *websocket.Conn) error {
+//			// Subscribe again.
+//		},
+//	}
+//	resume.Attach(rc)
+//	err := rc.DialContext(ctx)
+//
+// ResumeClient drops the messages retransmitted by the server that were
+// already received, and acknowledges the messages in the read methods of the
+// connection.
+//
+// It is safe to call ResumeClient's methods concurrently. The zero value is
+// ready to use.
+type ResumeClient struct {
+	// OnFresh, if not nil, is called when the server starts a new session,
+	// including on the first connection, for example to restore the
+	// application state that was lost with the previous session.
+	OnFresh func(c *Conn) error
+
+	// Resumable reports whether the session may be resumed after the
+	// connection was closed with the close code. If nil, sessions are
+	// resumed unless closed with CloseNormalClosure or ClosePolicyViolation.
+	Resumable func(code int) bool
+
+	// Timeout is the maximum time to wait for the answer of the server. If
+	// zero, a default of 10 seconds is used.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	token   string
+	inbox   Inbox
+	resumed bool
+}
+
+// Attach makes the reconnecting connection resume the session on each
+// connection. Attach calls Handshake before the OnConnect function of rc and
+// Disconnected before its OnDisconnect function.
+func (r *ResumeClient) Attach(rc *ReconnectingConn) {
+	onConnect, onDisconnect := rc.OnConnect, rc.OnDisconnect
+	rc.OnConnect = func(c *Conn) error {
+		if err := r.Handshake(c); err != nil {
+			return err
+		}
+		if onConnect != nil {
+			return onConnect(c)
+		}
+		return nil
+	}
+	rc.OnDisconnect = func(err error) {
+		r.Disconnected(err)
+		if onDisconnect != nil {
+			onDisconnect(err)
+		}
+	}
+}
+
+// Handshake sends the resume request on a new connection, which must not be
+// read concurrently, and reads the answer of the server. Handshake enables
+// the write queue of the connection for the acknowledgments.
+func (r *ResumeClient) Handshake(c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	r.mu.Lock()
+	token := r.token
+	r.mu.Unlock()
+	req := append(append([]byte(nil), resumePrefix...), token...)
+	req = append(req, ':')
+	req = strconv.AppendUint(req, r.inbox.Last(), 10)
+	_ = c.EnableWriteQueue(resumeWriteQueueSize, OverflowBlock)
+	if err := c.WriteMessage(TextMessage, req); err != nil {
+		return err
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultResumeTimeout
+	}
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	messageType, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	var resumed bool
+	switch {
+	case messageType == TextMessage && bytes.HasPrefix(p, resumedPrefix):
+		resumed = true
+		p = p[len(resumedPrefix):]
+	case messageType == TextMessage && bytes.HasPrefix(p, freshPrefix):
+		p = p[len(freshPrefix):]
+	default:
+		return ErrBadResumeRequest
+	}
+
+	r.mu.Lock()
+	r.token = string(p)
+	r.resumed = resumed
+	r.mu.Unlock()
+	if !resumed {
+		// The sequence numbers of a new session start again.
+		r.inbox.reset()
+	}
+	c.UseInbound(func(messageType int, data []byte) ([]byte, error) {
+		return r.receive(c, data)
+	})
+	if !resumed && r.OnFresh != nil {
+		return r.OnFresh(c)
+	}
+	return nil
+}
+
+// receive drops the messages of the session received before and
+// acknowledges the others.
+func (r *ResumeClient) receive(c *Conn, p []byte) ([]byte, error) {
+	seq, payload, ok := parseDelivery(p)
+	if !ok {
+		return p, nil
+	}
+	dup, last := r.inbox.receive(seq)
+	// Acknowledge duplicates too: the ack of the original may be lost.
+	if err := c.WriteMessage(TextMessage, strconv.AppendUint(append([]byte(nil), ackPrefix...), last, 10)); err != nil {
+		return nil, err
+	}
+	if dup {
+		return nil, ErrDropMessage
+	}
+	return payload, nil
+}
+
+// Disconnected forgets the session if the connection was closed with a
+// close code for which the session must not be resumed.
+func (r *ResumeClient) Disconnected(err error) {
+	var ce *CloseError
+	if !errors.As(err, &ce) {
+		return
+	}
+	resumable := ce.Code != CloseNormalClosure && ce.Code != ClosePolicyViolation
+	if r.Resumable != nil {
+		resumable = r.Resumable(ce.Code)
+	}
+	if !resumable {
+		r.mu.Lock()
+		r.token = ""
+		r.mu.Unlock()
+	}
+}
+
+// Token returns the resume token of the session, empty before the first
+// handshake or after the session ended.
+func (r *ResumeClient) Token() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.token
+}
+
+// Resumed reports whether the last handshake resumed the session.
+func (r *ResumeClient) Resumed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resumed
+}
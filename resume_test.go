@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseResumeRequest(t *testing.T) {
+	for _, tt := range []struct {
+		p     string
+		token string
+		last  uint64
+		ok    bool
+	}{
+		{"resume::0", "", 0, true},
+		{"resume:abc:42", "abc", 42, true},
+		{"resume:abc", "", 0, false},
+		{"resume:abc:x", "", 0, false},
+		{"hello", "", 0, false},
+	} {
+		token, last, ok := parseResumeRequest(TextMessage, []byte(tt.p))
+		if token != tt.token || last != tt.last || ok != tt.ok {
+			t.Errorf("parseResumeRequest(%q) = %q, %d, %v, want %q, %d, %v", tt.p, token, last, ok, tt.token, tt.last, tt.ok)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	rs := &ResumeServer{}
+	sessions := make(chan *ResumeSession, 4)
+	var u Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		session, resumed, err := rs.Accept(c)
+		if err != nil {
+			return
+		}
+		if !resumed {
+			session.Join("news")
+		}
+		sessions <- session
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	var fresh atomic.Int32
+	client := &ResumeClient{OnFresh: func(c *Conn) error {
+		fresh.Add(1)
+		return nil
+	}}
+	rc := &ReconnectingConn{
+		URL:        makeWsProto(s.URL),
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}
+	client.Attach(rc)
+	if err := rc.DialContext(context.Background()); err != nil {
+		t.Fatalf("DialContext: %v", err)
+	}
+	defer rc.Close()
+	read := make(chan string, 8)
+	go func() {
+		for {
+			_, p, err := rc.ReadMessage()
+			if err != nil {
+				close(read)
+				return
+			}
+			read <- string(p)
+		}
+	}()
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-read:
+			if got != want {
+				t.Fatalf("read %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+
+	session := <-sessions
+	if client.Resumed() || fresh.Load() != 1 || client.Token() != session.ID() {
+		t.Fatalf("first handshake: resumed %v, fresh %d, token %q, want a fresh session %q", client.Resumed(), fresh.Load(), client.Token(), session.ID())
+	}
+	rs.Broadcast("news", TextMessage, []byte("one"))
+	expect("one")
+
+	// Drop the connection and send a message while the client is
+	// disconnected.
+	session.Conn().NetConn().Close()
+	rs.Broadcast("news", TextMessage, []byte("two"))
+	if resumed := <-sessions; resumed != session {
+		t.Fatal("reconnection started a new session")
+	}
+	expect("two")
+	if !client.Resumed() || fresh.Load() != 1 {
+		t.Fatalf("second handshake: resumed %v, fresh %d, want a resumed session", client.Resumed(), fresh.Load())
+	}
+	// The room membership is restored.
+	rs.Broadcast("news", TextMessage, []byte("three"))
+	expect("three")
+
+	// An ended session is not resumed.
+	if err := rs.End(session, CloseNormalClosure, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	next := <-sessions
+	if next == session || rs.Session(session.ID()) != nil {
+		t.Fatal("ended session resumed")
+	}
+	for deadline := time.Now().Add(time.Second); fresh.Load() != 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("OnFresh called %d times, want 2", fresh.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := next.Send(TextMessage, []byte("four")); err != nil {
+		t.Fatal(err)
+	}
+	expect("four")
+}
+
+func TestResumeSessionExpires(t *testing.T) {
+	rs := &ResumeServer{TTL: 10 * time.Millisecond}
+	server, client := newTCPConns(t)
+	defer client.Close()
+	accepted := make(chan *ResumeSession, 1)
+	go func() {
+		session, _, err := rs.Accept(server)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- session
+	}()
+	if err := client.WriteMessage(TextMessage, []byte("resume::0")); err != nil {
+		t.Fatal(err)
+	}
+	if p := readString(t, client); len(p) <= len(freshPrefix) || p[:len(freshPrefix)] != string(freshPrefix) {
+		t.Fatalf("read answer %q, want a fresh session", p)
+	}
+	session := <-accepted
+	session.Join("room")
+	server.Close()
+	for deadline := time.Now().Add(time.Second); rs.Len() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("session of a disconnected client not expired")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := rs.Broadcast("room", TextMessage, []byte("x")); n != 0 {
+		t.Fatalf("Broadcast sent to %d expired sessions", n)
+	}
+}
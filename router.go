@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// ErrUnknownEvent is returned by Router.Dispatch for events without a
+// handler when the router has no NotFound handler.
+var ErrUnknownEvent = errors.New("websocket: unknown event")
+
+// Event is the JSON envelope of the messages routed by a Router:
+//
+//	{"event": "chat.send", "id": "42", "data": {"text": "hi"}}
+//
+// The id is optional. Events sent by Conn.Call carry the ID of the call, and
+// the reply to a call has the same ID, the field "reply": true and either
+// the result as data or an error.
+//
+// The optional meta object carries string headers of the event, such as
+// the trace context propagated by the otelws package.
+type Event struct {
+	Event string            `json:"event"`
+	ID    string            `json:"id,omitempty"`
+	Data  json.RawMessage   `json:"data,omitempty"`
+	Reply bool              `json:"reply,omitempty"`
+	Error *CallError        `json:"error,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+}
+
+// EventHandler handles an event received on a connection.
+type EventHandler func(c *Conn, e *Event) error
+
+// Router dispatches the messages of connections to handlers by event name.
+// Register handlers with Handle or with On for typed payloads, then call
+// Serve with each connection.
+//
+// The zero value is a router without handlers. Handlers may be registered
+// while the router serves connections.
+type Router struct {
+	// NotFound, if not nil, handles the events without a handler.
+	NotFound EventHandler
+
+	// OnError, if not nil, is called by Serve with the errors returned by
+	// Dispatch. If OnError is nil, Serve returns the first such error.
+	OnError func(c *Conn, e *Event, err error)
+
+	// Validator, if not nil, validates the data of the events without a
+	// validator registered with Validate.
+	Validator Validator
+
+	// MaxViolations, if positive, is the number of events rejected by the
+	// validators after which Serve closes the connection with the code
+	// CloseInvalidFramePayloadData and returns the last *ValidationError.
+	MaxViolations int
+
+	// ProfilerLabels, if true, labels the goroutine calling Serve with
+	// LabelConn while it serves the connection, and each call of a handler
+	// registered for an event with LabelEvent, so that profiles attribute
+	// the reads and the handlers to connections and events. The events
+	// handled by NotFound are not labeled with their name, which comes from
+	// the peer. Serve resets the labels of the goroutine when it returns.
+	// Labeling costs a few allocations per event.
+	ProfilerLabels bool
+
+	mu         sync.RWMutex
+	handlers   map[string]EventHandler
+	validators map[string]Validator
+}
+
+// Handle registers the handler for the event name, replacing any previous
+// handler.
+func (r *Router) Handle(event string, h EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[string]EventHandler)
+	}
+	r.handlers[event] = h
+}
+
+// Validate registers the validator of the data of the event name, replacing
+// any previous validator and the Validator field for the event. The data is
+// validated before the handler is called, and is nil for events without
+// data. When the validator rejects the data, the handler is not called: the
+// peer gets the event back with the ID of a call, if any, and a CallError
+// of code CallInvalidParams, and Dispatch returns a *ValidationError. A nil
+// validator disables the validation of the event.
+func (r *Router) Validate(event string, v Validator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.validators == nil {
+		r.validators = make(map[string]Validator)
+	}
+	r.validators[event] = v
+}
+
+// On registers a handler for the event name that receives the data of the
+// event decoded into a value of type T. Events without data are handled
+// with the zero value of T. A decoding error is returned by Dispatch without
+// calling the handler.
+func On[T any](r *Router, event string, h func(c *Conn, msg T) error) {
+	r.Handle(event, func(c *Conn, e *Event) error {
+		var msg T
+		if len(e.Data) > 0 {
+			if err := json.Unmarshal(e.Data, &msg); err != nil {
+				return fmt.Errorf("websocket: decoding data of event %q: %w", e.Event, err)
+			}
+		}
+		return h(c, msg)
+	})
+}
+
+// Dispatch decodes the event in the message p and calls its handler.
+// Dispatch returns the error of the handler, the error decoding the message,
+// a *ValidationError, or an error wrapping ErrUnknownEvent.
+func (r *Router) Dispatch(c *Conn, p []byte) error {
+	e := new(Event)
+	if err := json.Unmarshal(p, e); err != nil {
+		return err
+	}
+	return r.dispatch(nil, c, e)
+}
+
+// dispatch calls the handler of e. If labels is not nil, the handler is
+// called with the goroutine labeled with the labels and the event, see
+// ProfilerLabels.
+func (r *Router) dispatch(labels context.Context, c *Conn, e *Event) error {
+	if e.Reply {
+		c.resolveCall(e)
+		return nil
+	}
+	if ch := c.channel(e.Event); ch != nil {
+		return ch.deliver(e)
+	}
+	r.mu.RLock()
+	h := r.handlers[e.Event]
+	r.mu.RUnlock()
+	if h == nil {
+		h = r.NotFound
+		labels = nil
+	}
+	if h == nil {
+		if e.ID != "" {
+			// Fail the call rather than let the caller time out.
+			_ = c.reply(e, nil, &CallError{Code: CallUnknownMethod, Message: "unknown method"})
+		}
+		return fmt.Errorf("%w %q", ErrUnknownEvent, e.Event)
+	}
+	if err := r.validate(c, e); err != nil {
+		return err
+	}
+	if labels != nil {
+		var err error
+		pprof.Do(labels, pprof.Labels(LabelEvent, e.Event), func(context.Context) {
+			err = h(c, e)
+		})
+		return err
+	}
+	return h(c, e)
+}
+
+// Serve reads messages from the connection and dispatches them until an
+// error occurs. Serve returns the read error, or the first dispatch error if
+// OnError is nil. Handlers are called from the goroutine calling Serve, one
+// message at a time. Replies to the calls made with Conn.Call are delivered
+// to the callers, and the events of the channels created with NewChannel to
+// the channels. The events of a batch, see Conn.SetEventBatching, are
+// dispatched in order.
+func (r *Router) Serve(c *Conn) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	var labels context.Context
+	if r.ProfilerLabels {
+		labels = connLabelContext(c)
+		pprof.SetGoroutineLabels(labels)
+		defer pprof.SetGoroutineLabels(context.Background())
+	}
+	var violations int
+	for {
+		_, p, err := c.ReadMessage()
+		if err != nil {
+			c.failCalls()
+			c.stopChannels(err)
+			return err
+		}
+		events, err := UnpackEvents(p)
+		if err != nil {
+			// Report the message that is not an event once.
+			events = []*Event{nil}
+		}
+		for _, e := range events {
+			if e != nil {
+				err = r.dispatch(labels, c, e)
+			}
+			if err == nil {
+				continue
+			}
+			if r.OnError == nil {
+				return err
+			}
+			r.OnError(c, e, err)
+			var ve *ValidationError
+			if r.MaxViolations > 0 && errors.As(err, &ve) {
+				if violations++; violations >= r.MaxViolations {
+					c.failCalls()
+					c.stopChannels(err)
+					_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseInvalidFramePayloadData, "too many invalid messages"), time.Now().Add(writeWait))
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Emit writes an event with the JSON encoding of data as a text message.
+func (c *Conn) Emit(event string, data interface{}) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	e := Event{Event: event}
+	if data != nil {
+		p, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		e.Data = p
+	}
+	p, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	return c.writeEvent(p)
+}
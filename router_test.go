@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type chatMessage struct {
+	Room string `json:"room"`
+	Text string `json:"text"`
+}
+
+func TestRouter(t *testing.T) {
+	var r Router
+	On(&r, "chat.send", func(c *Conn, msg chatMessage) error {
+		return c.Emit("chat.message", chatMessage{Room: msg.Room, Text: strings.ToUpper(msg.Text)})
+	})
+	On(&r, "ping", func(c *Conn, msg struct{}) error {
+		return c.Emit("pong", nil)
+	})
+	errFail := errors.New("fail")
+	r.Handle("fail", func(c *Conn, e *Event) error {
+		if e.ID != "7" {
+			t.Errorf("event ID %q", e.ID)
+		}
+		return errFail
+	})
+
+	var errs []string
+	r.OnError = func(c *Conn, e *Event, err error) {
+		name := "<nil>"
+		if e != nil {
+			name = e.Event
+		}
+		errs = append(errs, name+": "+err.Error())
+	}
+
+	s, c := newPipeConns()
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(s) }()
+
+	for _, m := range []string{
+		`{"event":"chat.send","data":{"room":"a","text":"hi"}}`,
+		`{"event":"ping"}`,
+		`{"event":"fail","id":"7"}`,
+		`{"event":"nope"}`,
+		`{"event":"chat.send","data":"bad"}`,
+		`not json`,
+	} {
+		if err := c.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.Contains(m, "chat.send") && !strings.Contains(m, "bad"):
+			if got := readString(t, c); got != `{"event":"chat.message","data":{"room":"a","text":"HI"}}` {
+				t.Fatalf("got %s", got)
+			}
+		case strings.Contains(m, "ping"):
+			if got := readString(t, c); got != `{"event":"pong"}` {
+				t.Fatalf("got %s", got)
+			}
+		}
+	}
+	c.Close()
+	if err := <-done; err == nil {
+		t.Fatal("Serve returned nil after the connection closed")
+	}
+
+	want := []string{
+		"fail: fail",
+		`nope: websocket: unknown event "nope"`,
+		`chat.send: websocket: decoding data of event "chat.send": json: cannot unmarshal string into Go value of type websocket.chatMessage`,
+		"<nil>: invalid character 'o' in literal null (expecting 'u')",
+	}
+	if strings.Join(errs, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("errors:\n%s\nwant:\n%s", strings.Join(errs, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := Router{NotFound: func(c *Conn, e *Event) error {
+		return c.Emit("unknown", e.Event)
+	}}
+	s, c := newPipeConns()
+	go func() { _ = r.Serve(s) }()
+	if err := c.WriteMessage(TextMessage, []byte(`{"event":"x"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c); got != `{"event":"unknown","data":"x"}` {
+		t.Fatalf("got %s", got)
+	}
+
+	// Without OnError, Serve returns the first error.
+	r = Router{}
+	s, c = newPipeConns()
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(s) }()
+	if err := c.WriteMessage(TextMessage, []byte(`{"event":"x"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, ErrUnknownEvent) {
+		t.Fatalf("Serve returned %v, want %v", err, ErrUnknownEvent)
+	}
+}
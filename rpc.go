@@ -0,0 +1,202 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DefaultCallTimeout is the timeout of Conn.Call when the context has no
+// deadline.
+const DefaultCallTimeout = 30 * time.Second
+
+// Error codes of the CallErrors replied by a Router.
+const (
+	CallUnknownMethod = 404 // no handler for the method
+	CallInvalidParams = 400 // the params cannot be decoded
+	CallInternalError = 500 // the handler returned an error other than a *CallError
+)
+
+// ErrCallAborted is returned by Conn.Call when the connection is closed or
+// stops being served before the reply is received.
+var ErrCallAborted = errors.New("websocket: call aborted")
+
+// CallError is the error replied to a call.
+type CallError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *CallError) Error() string {
+	return "websocket: call error " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+// OnCall registers a handler for calls of the method. The params of the call
+// are decoded into a value of type P, and the result or error returned by
+// the handler is replied to the caller. A *CallError is replied as is; other
+// errors are replied with the code CallInternalError and the error text.
+func OnCall[P, R any](r *Router, method string, h func(c *Conn, params P) (R, error)) {
+	r.Handle(method, func(c *Conn, e *Event) error {
+		var params P
+		if len(e.Data) > 0 {
+			if err := json.Unmarshal(e.Data, &params); err != nil {
+				return c.reply(e, nil, &CallError{Code: CallInvalidParams, Message: err.Error()})
+			}
+		}
+		result, err := h(c, params)
+		if err != nil {
+			var ce *CallError
+			if !errors.As(err, &ce) {
+				ce = &CallError{Code: CallInternalError, Message: err.Error()}
+			}
+			return c.reply(e, nil, ce)
+		}
+		return c.reply(e, result, nil)
+	})
+}
+
+// reply writes the reply to the call e. A notification, an event without ID,
+// gets no reply.
+func (c *Conn) reply(e *Event, result interface{}, callErr *CallError) error {
+	if e.ID == "" {
+		return nil
+	}
+	r := Event{Event: e.Event, ID: e.ID, Reply: true, Error: callErr}
+	if callErr == nil && result != nil {
+		p, err := json.Marshal(result)
+		if err != nil {
+			r.Error = &CallError{Code: CallInternalError, Message: err.Error()}
+		} else {
+			r.Data = p
+		}
+	}
+	p, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, p)
+}
+
+// Call calls the method on the peer and waits for the reply. The JSON
+// encoding of params is sent as the data of an event with a new correlation
+// ID, and the data of the reply is decoded into the value pointed to by
+// result unless result is nil. The error of a failed call is a *CallError.
+//
+// The replies are received by Router.Serve, so the connection must be
+// served by a Router. Call waits until ctx is done, or for
+// DefaultCallTimeout if ctx has no deadline.
+//
+// The results of the methods set with CacheCalls are answered from the
+// cache while they are fresh.
+//
+// Call writes the event with WriteMessage. Calls from goroutines other than
+// the one serving the connection write concurrently with the handlers; use
+// EnableWriteQueue to make the writes safe.
+func (c *Conn) Call(ctx context.Context, method string, params, result interface{}) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCallTimeout)
+		defer cancel()
+	}
+
+	e := Event{Event: method}
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		e.Data = p
+	}
+	var (
+		cache    *callCache
+		cacheKey callCacheKey
+	)
+	if cc := c.getCallCache(); cc != nil {
+		if k, ok := cc.key(method, e.Data); ok {
+			if data, ok := cc.get(k); ok {
+				return decodeCallResult(method, data, result)
+			}
+			cache, cacheKey = cc, k
+		}
+	}
+	ch := make(chan *Event, 1)
+	c.callMu.Lock()
+	c.callSeq++
+	e.ID = strconv.FormatUint(c.callSeq, 10)
+	if c.calls == nil {
+		c.calls = make(map[string]chan *Event)
+	}
+	c.calls[e.ID] = ch
+	c.callMu.Unlock()
+	defer func() {
+		c.callMu.Lock()
+		delete(c.calls, e.ID)
+		c.callMu.Unlock()
+	}()
+
+	p, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	if err := c.WriteMessage(TextMessage, p); err != nil {
+		return err
+	}
+
+	select {
+	case r := <-ch:
+		if r == nil {
+			return ErrCallAborted
+		}
+		if r.Error != nil {
+			return r.Error
+		}
+		if cache != nil {
+			cache.put(cacheKey, r.Data)
+		}
+		return decodeCallResult(method, r.Data, result)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+		return ErrCallAborted
+	}
+}
+
+// decodeCallResult decodes the data of the reply to a call into result.
+func decodeCallResult(method string, data json.RawMessage, result interface{}) error {
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("websocket: decoding result of %q: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// resolveCall delivers a reply to the pending call with its ID. Replies to
+// calls that timed out are dropped.
+func (c *Conn) resolveCall(e *Event) {
+	c.callMu.Lock()
+	ch := c.calls[e.ID]
+	delete(c.calls, e.ID)
+	c.callMu.Unlock()
+	if ch != nil {
+		ch <- e
+	}
+}
+
+// failCalls aborts the pending calls.
+func (c *Conn) failCalls() {
+	c.callMu.Lock()
+	calls := c.calls
+	c.calls = nil
+	c.callMu.Unlock()
+	for _, ch := range calls {
+		ch <- nil
+	}
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxCallCacheEntries bounds the number of results cached by a connection.
+// When the cache is full, the results of new calls are not cached until
+// cached results expire or are purged.
+const maxCallCacheEntries = 1024
+
+// callCache holds the results of the idempotent calls of a connection, see
+// CacheCalls.
+type callCache struct {
+	mu      sync.Mutex
+	ttls    map[string]time.Duration // TTL by method
+	entries map[callCacheKey]callCacheEntry
+}
+
+type callCacheKey struct {
+	method string
+	params string // JSON encoding of the params
+}
+
+type callCacheEntry struct {
+	data    json.RawMessage
+	expires time.Time
+}
+
+// CacheCalls caches for ttl the results of the calls of the method made with
+// Call on the connection. A call with the same params as a previous call
+// whose result is cached is answered locally, without a round trip to the
+// peer. The params are compared by their JSON encoding. Only successful
+// results are cached: failed calls are always sent again.
+//
+// Cache only the calls of methods that are idempotent and whose result can
+// be stale for ttl, such as metadata lookups. If ttl is zero, the calls of
+// the method are no longer cached and its cached results are dropped. The
+// cache holds up to 1024 results; the results of calls made while it is
+// full are not cached.
+func (c *Conn) CacheCalls(method string, ttl time.Duration) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if ttl < 0 {
+		return errors.New("websocket: negative call cache TTL")
+	}
+	c.callMu.Lock()
+	cc := c.callCache
+	if cc == nil {
+		if ttl == 0 {
+			c.callMu.Unlock()
+			return nil
+		}
+		cc = &callCache{
+			ttls:    make(map[string]time.Duration),
+			entries: make(map[callCacheKey]callCacheEntry),
+		}
+		c.callCache = cc
+	}
+	c.callMu.Unlock()
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if ttl == 0 {
+		delete(cc.ttls, method)
+		cc.purge(method)
+		return nil
+	}
+	cc.ttls[method] = ttl
+	return nil
+}
+
+// PurgeCallCache drops the cached results of the calls of the method, so that
+// the next calls are sent to the peer, for example after a notification that
+// the data changed. If method is empty, all the cached results are dropped.
+func (c *Conn) PurgeCallCache(method string) {
+	if c == nil {
+		return
+	}
+	if cc := c.getCallCache(); cc != nil {
+		cc.mu.Lock()
+		cc.purge(method)
+		cc.mu.Unlock()
+	}
+}
+
+func (c *Conn) getCallCache() *callCache {
+	c.callMu.Lock()
+	defer c.callMu.Unlock()
+	return c.callCache
+}
+
+// purge drops the entries of the method, or all entries if method is empty.
+// The lock must be held.
+func (cc *callCache) purge(method string) {
+	for k := range cc.entries {
+		if method == "" || k.method == method {
+			delete(cc.entries, k)
+		}
+	}
+}
+
+// key returns the cache key of a call and whether the calls of the method
+// are cached.
+func (cc *callCache) key(method string, params []byte) (callCacheKey, bool) {
+	cc.mu.Lock()
+	_, ok := cc.ttls[method]
+	cc.mu.Unlock()
+	return callCacheKey{method: method, params: string(params)}, ok
+}
+
+// get returns the cached result of a call.
+func (cc *callCache) get(k callCacheKey) (json.RawMessage, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	e, ok := cc.entries[k]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(e.expires) {
+		delete(cc.entries, k)
+		return nil, false
+	}
+	return e.data, true
+}
+
+// put caches the result of a call, unless the calls of the method stopped
+// being cached during the call.
+func (cc *callCache) put(k callCacheKey, data json.RawMessage) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	ttl, ok := cc.ttls[k.method]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if _, ok := cc.entries[k]; !ok && len(cc.entries) >= maxCallCacheEntries {
+		for k, e := range cc.entries {
+			if !now.Before(e.expires) {
+				delete(cc.entries, k)
+			}
+		}
+		if len(cc.entries) >= maxCallCacheEntries {
+			return
+		}
+	}
+	cc.entries[k] = callCacheEntry{data: data, expires: now.Add(ttl)}
+}
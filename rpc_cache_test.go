@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheCalls(t *testing.T) {
+	var lookups, fails atomic.Int32
+	server := &Router{OnError: func(*Conn, *Event, error) {}}
+	OnCall(server, "user", func(c *Conn, id int) (string, error) {
+		lookups.Add(1)
+		return "user" + string(rune('0'+id)), nil
+	})
+	OnCall(server, "flaky", func(c *Conn, params struct{}) (string, error) {
+		fails.Add(1)
+		return "", &CallError{Code: 503, Message: "unavailable"}
+	})
+	_, c := newRPCConns(t, server, &Router{})
+	ctx := context.Background()
+
+	if err := c.CacheCalls("user", 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CacheCalls("flaky", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	call := func(id int, want string) {
+		t.Helper()
+		var name string
+		if err := c.Call(ctx, "user", id, &name); err != nil || name != want {
+			t.Fatalf("user(%d) = %q, %v", id, name, err)
+		}
+	}
+	call(1, "user1")
+	call(1, "user1")
+	call(2, "user2")
+	if n := lookups.Load(); n != 2 {
+		t.Errorf("%d lookups for 2 distinct params, want 2", n)
+	}
+
+	// Failed calls are not cached.
+	for i := 0; i < 2; i++ {
+		if err := c.Call(ctx, "flaky", nil, nil); err == nil {
+			t.Fatal("flaky call succeeded")
+		}
+	}
+	if n := fails.Load(); n != 2 {
+		t.Errorf("flaky called %d times, want 2", n)
+	}
+
+	c.PurgeCallCache("user")
+	call(1, "user1")
+	if n := lookups.Load(); n != 3 {
+		t.Errorf("%d lookups after purge, want 3", n)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	call(1, "user1")
+	if n := lookups.Load(); n != 4 {
+		t.Errorf("%d lookups after the TTL, want 4", n)
+	}
+
+	if err := c.CacheCalls("user", 0); err != nil {
+		t.Fatal(err)
+	}
+	call(1, "user1")
+	if n := lookups.Load(); n != 5 {
+		t.Errorf("%d lookups after disabling the cache, want 5", n)
+	}
+	if err := c.CacheCalls("user", -time.Second); err == nil {
+		t.Error("negative TTL accepted")
+	}
+}
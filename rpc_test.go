@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newRPCConns(t *testing.T, server, client *Router) (s, c *Conn) {
+	s, c = newPipeConns()
+	for _, conn := range []*Conn{s, c} {
+		if err := conn.EnableWriteQueue(16, OverflowBlock); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go func() { _ = server.Serve(s) }()
+	go func() { _ = client.Serve(c) }()
+	t.Cleanup(func() {
+		s.Close()
+		c.Close()
+	})
+	return s, c
+}
+
+func TestCall(t *testing.T) {
+	server := &Router{OnError: func(*Conn, *Event, error) {}}
+	OnCall(server, "add", func(c *Conn, params [2]int) (int, error) {
+		return params[0] + params[1], nil
+	})
+	OnCall(server, "div", func(c *Conn, params [2]int) (int, error) {
+		if params[1] == 0 {
+			return 0, &CallError{Code: 422, Message: "division by zero"}
+		}
+		return params[0] / params[1], nil
+	})
+	OnCall(server, "fail", func(c *Conn, params struct{}) (struct{}, error) {
+		return struct{}{}, errors.New("boom")
+	})
+	server.Handle("ignore", func(c *Conn, e *Event) error { return nil })
+	client := &Router{}
+	OnCall(client, "whoami", func(c *Conn, params struct{}) (string, error) {
+		return "client", nil
+	})
+	s, c := newRPCConns(t, server, client)
+	ctx := context.Background()
+
+	var sum int
+	if err := c.Call(ctx, "add", [2]int{2, 3}, &sum); err != nil || sum != 5 {
+		t.Fatalf("add = %d, %v", sum, err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		params interface{}
+		code   int
+	}{
+		{"div", [2]int{1, 0}, 422},
+		{"fail", nil, CallInternalError},
+		{"add", "x", CallInvalidParams},
+		{"nope", nil, CallUnknownMethod},
+	} {
+		var ce *CallError
+		if err := c.Call(ctx, tc.method, tc.params, nil); !errors.As(err, &ce) || ce.Code != tc.code {
+			t.Errorf("%s: got %v, want code %d", tc.method, err, tc.code)
+		}
+	}
+
+	// The server calls the client.
+	var name string
+	if err := s.Call(ctx, "whoami", nil, &name); err != nil || name != "client" {
+		t.Fatalf("whoami = %q, %v", name, err)
+	}
+
+	// A call without reply times out.
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := c.Call(tctx, "ignore", nil, nil); err != context.DeadlineExceeded {
+		t.Fatalf("unanswered call returned %v", err)
+	}
+}
+
+func TestCallAborted(t *testing.T) {
+	server := &Router{}
+	server.Handle("hang", func(c *Conn, e *Event) error {
+		_ = c.Close()
+		return nil
+	})
+	_, c := newRPCConns(t, server, &Router{})
+	if err := c.Call(context.Background(), "hang", nil, nil); err != ErrCallAborted {
+		t.Fatalf("Call returned %v, want %v", err, ErrCallAborted)
+	}
+
+	var nilConn *Conn
+	if err := nilConn.Call(context.Background(), "x", nil, nil); err != ErrNilConn {
+		t.Fatalf("Call on nil Conn returned %v", err)
+	}
+}
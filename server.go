@@ -6,9 +6,12 @@ package websocket
 
 import (
 	"bufio"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,11 +19,38 @@ import (
 const badHandshake = "websocket: the client is not using the websocket protocol: "
 
 // HandshakeError describes an error with the handshake from the peer.
+//
+// Dial returns a HandshakeError when the server rejects the handshake or
+// responds with an invalid handshake. The error holds the status, headers
+// and the beginning of the body of the response, and matches
+// ErrBadHandshake with errors.Is.
 type HandshakeError struct {
 	message string
+
+	// StatusCode is the status code of the handshake response. It is zero
+	// for errors returned by Upgrade.
+	StatusCode int
+
+	// Header is the header of the handshake response.
+	Header http.Header
+
+	// Body holds up to the first 1024 bytes of the body of the handshake
+	// response.
+	Body []byte
+}
+
+func (e HandshakeError) Error() string {
+	if e.StatusCode == 0 {
+		return e.message
+	}
+	return e.message + " (" + strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode) + ")"
 }
 
-func (e HandshakeError) Error() string { return e.message }
+// Is reports whether target is ErrBadHandshake for an error returned by
+// Dial.
+func (e HandshakeError) Is(target error) bool {
+	return target == ErrBadHandshake && e.StatusCode != 0
+}
 
 // Upgrader specifies parameters for upgrading an HTTP connection to a
 // WebSocket connection.
@@ -28,8 +58,23 @@ func (e HandshakeError) Error() string { return e.message }
 // It is safe to call Upgrader's methods concurrently.
 type Upgrader struct {
 	// HandshakeTimeout specifies the duration for the handshake to complete.
+	// Upgrade bounds writing the response; UpgradeNetConn also bounds
+	// reading the request. The request read by an http.Server is bounded by
+	// its ReadHeaderTimeout.
 	HandshakeTimeout time.Duration
 
+	// MaxHeaderBytes limits the size in bytes of the request line and
+	// headers of the upgrade request. Larger requests are rejected with
+	// status 431 Request Header Fields Too Large. If zero, Upgrade does not
+	// check the size, leaving the limit to http.Server.MaxHeaderBytes, and
+	// UpgradeNetConn uses a default of http.DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+
+	// OnHandshakeLimit, if not nil, is called when a handshake exceeds
+	// HandshakeTimeout or MaxHeaderBytes, with the remote address of the
+	// client and ErrHandshakeTimeout or ErrRequestHeaderTooLarge.
+	OnHandshakeLimit func(remoteAddr string, err error)
+
 	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes in bytes. If a buffer
 	// size is zero, then buffers allocated by the HTTP server are used. The
 	// I/O buffer sizes do not limit the size of the messages that can be sent
@@ -47,6 +92,17 @@ type Upgrader struct {
 	// WriteBufferSize.
 	WriteBufferPool BufferPool
 
+	// ReadBufferPool is a pool of buffers for read operations. If the value
+	// is set, then the connection holds a read buffer only while a frame is
+	// available to read and returns the buffer to the pool while waiting for
+	// the next frame. If the value is not set, then read buffers are
+	// allocated to the connection for the lifetime of the connection.
+	//
+	// A pool greatly reduces memory use of a large number of mostly idle
+	// connections. Applications should use a single pool for each unique
+	// value of ReadBufferSize.
+	ReadBufferPool BufferPool
+
 	// Subprotocols specifies the server's supported protocols in order of
 	// preference. If this field is not nil, then the Upgrade method negotiates a
 	// subprotocol by selecting the first match in this list with a protocol
@@ -68,15 +124,148 @@ type Upgrader struct {
 	// prevent cross-site request forgery.
 	CheckOrigin func(r *http.Request) bool
 
+	// OriginPolicy specifies an allowlist based origin check. OriginPolicy is
+	// used when CheckOrigin is nil.
+	OriginPolicy *OriginPolicy
+
+	// Authenticate, if not nil, is called before the handshake response is
+	// written. If Authenticate returns an error, the handshake is rejected.
+	// An *AuthError specifies the status code, headers and body of the
+	// response; other errors are reported with status 401 Unauthorized. The
+	// returned principal is attached to the connection and is available with
+	// Conn.Value.
+	//
+	// Use RequestToken to extract a token from the request.
+	Authenticate func(r *http.Request) (principal interface{}, err error)
+
+	// LoadSession, if not nil, loads the HTTP session of the request, such
+	// as the session identified by a cookie, after Authenticate. The
+	// session is attached to the connection for Conn.Session and saved when
+	// the connection is closed. If LoadSession returns an error, the
+	// handshake is rejected with status 500 Internal Server Error.
+	LoadSession func(r *http.Request) (*Session, error)
+
 	// EnableCompression specify if the server should attempt to negotiate per
 	// message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
+
+	// CompressionLevel specifies the initial flate compression level for
+	// connections where compression is negotiated. If zero, a fast default
+	// level is used. See Conn.SetCompressionLevel.
+	CompressionLevel int
+
+	// CompressionThreshold specifies the minimum payload size in bytes for a
+	// message to be compressed. See Conn.SetCompressionThreshold.
+	CompressionThreshold int
+
+	// CompressionDictionaries are the preset dictionaries the server accepts
+	// when a client offers one with compression, see CompressionDictionary.
+	// Offers naming another dictionary are declined.
+	CompressionDictionaries []*CompressionDictionary
+
+	// Extensions are the extensions the server negotiates after
+	// permessage-deflate, in order. See Extension.
+	Extensions []Extension
+
+	// ServerContextTakeover specifies whether the server may retain the
+	// compression context across the messages it writes. If false, the server
+	// negotiates server_no_context_takeover. Context takeover improves the
+	// compression ratio of repetitive messages at the cost of holding a
+	// compressor for the lifetime of each connection.
+	ServerContextTakeover bool
+
+	// ClientContextTakeover specifies whether the server permits the client to
+	// retain the compression context across the messages it writes. If false,
+	// the server negotiates client_no_context_takeover. Context takeover
+	// requires the server to hold a 32 KiB window for the lifetime of each
+	// connection.
+	ClientContextTakeover bool
+
+	// StrictUTF8 specifies whether the connections validate that text
+	// messages are valid UTF-8. See Conn.SetStrictUTF8.
+	StrictUTF8 bool
+
+	// ReadLimits specifies the limits on the frames and messages read by the
+	// connections. See Conn.SetReadLimits.
+	ReadLimits ReadLimits
+
+	// TCP specifies the socket options of the TCP connections. The zero
+	// value keeps the defaults of the net package. The options are best
+	// effort: errors setting them are ignored. See Conn.SetTCPOptions.
+	TCP TCPOptions
+
+	// ConnManager, if not nil, tracks the connections created by Upgrade.
+	// After ConnManager.Shutdown is called, Upgrade rejects new connections
+	// with status 503 Service Unavailable.
+	ConnManager *ConnManager
+
+	// Registry, if not nil, registers the connections created by Upgrade
+	// for lookup by ID and index keys.
+	Registry *Registry
+
+	// MaxConnections, if positive, limits the number of open connections
+	// created by Upgrade, counting handshakes in progress. Upgrade rejects
+	// requests over the limit with status 503 Service Unavailable. A
+	// connection counts until it is closed with Conn.Close.
+	MaxConnections int
+
+	// MaxConnectionsPerIP, if positive, limits the number of open
+	// connections per client like MaxConnections. Clients are identified
+	// by ConnectionKey.
+	MaxConnectionsPerIP int
+
+	// ConnectionKey returns the key identifying the client of a request for
+	// MaxConnectionsPerIP. Requests with an empty key are not limited per
+	// client. If ConnectionKey is nil, the client address is used, as
+	// resolved by RemoteAddrPolicy. Behind a reverse proxy, set
+	// RemoteAddrPolicy.TrustedProxies or return the client address
+	// forwarded by the proxy, such as the address it appends to
+	// X-Forwarded-For.
+	ConnectionKey func(r *http.Request) string
+
+	// OnConnectionLimit, if not nil, is called when an upgrade is rejected
+	// with ErrTooManyConnections or ErrTooManyConnectionsPerIP.
+	OnConnectionLimit func(r *http.Request, err error)
+
+	// RemoteAddrPolicy, if not nil, resolves the client address of requests
+	// forwarded by trusted proxies and restricts the client addresses
+	// allowed to connect. Upgrade rejects requests from other addresses
+	// with status 403 Forbidden. The resolved address is available with
+	// Conn.RealIP.
+	RemoteAddrPolicy *RemoteAddrPolicy
+
+	// Throttle, if not nil, returns the throttle that shapes the traffic of
+	// the connection upgraded from the request, such as the throttle of the
+	// tenant of the request. The connection is not throttled if Throttle
+	// returns nil.
+	Throttle func(r *http.Request) *Throttle
+
+	// Metrics, if not nil, receives events about the connections created by
+	// Upgrade and about failed handshakes.
+	Metrics Metrics
+
+	// Logger, if not nil, receives log records about failed handshakes and
+	// about the connections created by Upgrade.
+	Logger Logger
+
+	// EventSink, if not nil, receives the audit events of the connections
+	// created by Upgrade and of the handshakes rejected by the
+	// authentication, origin or remote address checks.
+	EventSink EventSink
+
+	protocols map[string]ProtocolHandler
+	conns     *connCounter // see MaxConnections, guarded by counterMu
 }
 
 func (u *Upgrader) returnError(w http.ResponseWriter, r *http.Request, status int, reason string) (*Conn, error) {
-	err := HandshakeError{reason}
+	err := HandshakeError{message: reason}
+	if u.Metrics != nil {
+		u.Metrics.HandshakeFailed(status)
+	}
+	if u.Logger != nil {
+		u.Logger.Log(slog.LevelWarn, "websocket: handshake failed", "remote_addr", r.RemoteAddr, "status", status, "error", reason)
+	}
 	if u.Error != nil {
 		u.Error(w, r, status, err)
 	} else {
@@ -115,25 +304,25 @@ func (u *Upgrader) selectSubprotocol(r *http.Request, responseHeader http.Header
 	return ""
 }
 
-// negotiateCompression checks if compression is supported and enabled.
-func (u *Upgrader) negotiateCompression(r *http.Request) bool {
-	if !u.EnableCompression {
-		return false
-	}
-
-	for _, ext := range parseExtensions(r.Header) {
-		if ext[""] != "permessage-deflate" {
-			continue
-		}
-		return true
-	}
-	return false
+// negotiateExtensions selects the extensions of the connection from the
+// offers of the request, starting with permessage-deflate if compression is
+// enabled.
+func (u *Upgrader) negotiateExtensions(r *http.Request) []negotiatedExtension {
+	exts := u.Extensions
+	if u.EnableCompression {
+		exts = append([]Extension{&deflateExtension{
+			serverContextTakeover: u.ServerContextTakeover,
+			clientContextTakeover: u.ClientContextTakeover,
+			dicts:                 u.CompressionDictionaries,
+		}}, exts...)
+	}
+	return acceptExtensions(r.Header, exts)
 }
 
 // setupBufferedReader sets up the buffered reader for the connection.
 func (u *Upgrader) setupBufferedReader(netConn net.Conn, brw *bufio.ReadWriter) (net.Conn, *bufio.Reader) {
 	var br *bufio.Reader
-	if u.ReadBufferSize == 0 && brw.Reader.Size() > 256 {
+	if u.ReadBufferSize == 0 && u.ReadBufferPool == nil && brw.Reader.Size() > 256 {
 		// Use hijacked buffered reader as the connection reader.
 		br = brw.Reader
 	} else if brw.Reader.Buffered() > 0 {
@@ -146,6 +335,13 @@ func (u *Upgrader) setupBufferedReader(netConn net.Conn, brw *bufio.ReadWriter)
 	return netConn, br
 }
 
+func (u *Upgrader) throttle(r *http.Request) *Throttle {
+	if u.Throttle == nil {
+		return nil
+	}
+	return u.Throttle(r)
+}
+
 // setupWriteBuffer sets up the write buffer for the connection.
 func (u *Upgrader) setupWriteBuffer(buf []byte) []byte {
 	var writeBuf []byte
@@ -157,20 +353,26 @@ func (u *Upgrader) setupWriteBuffer(buf []byte) []byte {
 }
 
 // createWebSocketConnection creates a new WebSocket connection.
-func (u *Upgrader) createWebSocketConnection(netConn net.Conn, subprotocol string, compress bool, br *bufio.Reader, writeBuf []byte) *Conn {
+func (u *Upgrader) createWebSocketConnection(netConn net.Conn, subprotocol string, exts []negotiatedExtension, br *bufio.Reader, writeBuf []byte) *Conn {
 	c := newConn(netConn, true, u.ReadBufferSize, u.WriteBufferSize, u.WriteBufferPool, br, writeBuf)
+	if u.ReadBufferPool != nil {
+		c.setReadPool(u.ReadBufferPool)
+	}
 	c.subprotocol = subprotocol
+	c.strictUTF8 = u.StrictUTF8
+	c.SetReadLimits(u.ReadLimits)
+	c.applyTCPOptions(u.TCP)
 
-	if compress {
-		c.newCompressionWriter = compressNoContextTakeover
-		c.newDecompressionReader = decompressNoContextTakeover
+	c.setupExtensions(exts)
+	if c.newCompressionWriter != nil {
+		c.setCompressionOptions(u.CompressionLevel, u.CompressionThreshold)
 	}
 
 	return c
 }
 
 // generateUpgradeResponse generates the HTTP response for the WebSocket upgrade.
-func generateUpgradeResponse(c *Conn, challengeKey string, compress bool, responseHeader http.Header, buf []byte) []byte {
+func generateUpgradeResponse(c *Conn, challengeKey string, responseHeader http.Header, buf []byte) []byte {
 	// Use larger of hijacked buffer and connection write buffer for header.
 	p := buf
 	if len(c.writeBuf) > len(p) {
@@ -186,8 +388,10 @@ func generateUpgradeResponse(c *Conn, challengeKey string, compress bool, respon
 		p = append(p, c.subprotocol...)
 		p = append(p, "\r\n"...)
 	}
-	if compress {
-		p = append(p, "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n"...)
+	if c.extensions != "" {
+		p = append(p, "Sec-WebSocket-Extensions: "...)
+		p = append(p, c.extensions...)
+		p = append(p, "\r\n"...)
 	}
 	for k, vs := range responseHeader {
 		if k == "Sec-Websocket-Protocol" {
@@ -230,6 +434,164 @@ func (u *Upgrader) clearConnectionDeadline(netConn net.Conn) error {
 	return nil
 }
 
+// checkRequest validates the version, origin and extension headers shared by
+// the HTTP/1.1 and HTTP/2 handshakes.
+func (u *Upgrader) checkRequest(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if !tokenListContainsValue(r.Header, "Sec-Websocket-Version", "13") {
+		return u.returnError(w, r, http.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
+	}
+
+	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
+		return u.returnError(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
+	}
+
+	if !u.checkOrigin(r) {
+		u.auditRequest(r, AuditViolation, "origin not allowed")
+		return u.returnError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	}
+	return nil, nil
+}
+
+// checkOrigin validates the request origin with CheckOrigin, OriginPolicy or
+// the same origin default.
+func (u *Upgrader) checkOrigin(r *http.Request) bool {
+	switch {
+	case u.CheckOrigin != nil:
+		return u.CheckOrigin(r)
+	case u.OriginPolicy != nil:
+		return u.OriginPolicy.Check(r)
+	default:
+		return checkSameOrigin(r)
+	}
+}
+
+// admission holds the state of an admitted request for the connection
+// created by the upgrade.
+type admission struct {
+	principal interface{}
+	session   *Session
+	realIP    netip.Addr
+	release   func() // releases the connection slot, see MaxConnections
+}
+
+// attach attaches the principal, client address and connection slot to the
+// connection created for the request. The slot is released by Close, or by
+// cancel until hold is called; the release function runs once.
+func (a *admission) attach(c *Conn) {
+	c.value = a.principal
+	c.session = a.session
+	c.realIP = a.realIP
+	c.release = a.release
+}
+
+// hold transfers the connection slot to c once the upgrade has succeeded.
+func (a *admission) hold() {
+	a.release = nil
+}
+
+// upgradeSetup holds the options of an upgrader applied to the connections it
+// creates.
+type upgradeSetup struct {
+	logger        Logger
+	metrics       Metrics
+	eventSink     EventSink
+	connManager   *ConnManager
+	registry      *Registry
+	authenticated bool
+}
+
+func (u *Upgrader) upgradeSetup() upgradeSetup {
+	return upgradeSetup{
+		logger:        u.Logger,
+		metrics:       u.Metrics,
+		eventSink:     u.EventSink,
+		connManager:   u.ConnManager,
+		registry:      u.Registry,
+		authenticated: u.Authenticate != nil,
+	}
+}
+
+// complete completes the connection created by an upgrade: it attaches the
+// admission, adds the connection to the connection manager and registry,
+// transfers the connection slot and records the handshake. Every upgrade
+// path calls complete; on error, the caller closes the connection.
+func (s upgradeSetup) complete(c *Conn, adm *admission) error {
+	adm.attach(c)
+	c.logger = s.logger
+	c.setMetrics(s.metrics)
+	c.setEventSink(s.eventSink)
+	if s.connManager != nil {
+		if err := s.connManager.Add(c); err != nil {
+			return err
+		}
+	}
+	if s.registry != nil {
+		s.registry.Add(c)
+	}
+	adm.hold()
+	c.auditHandshake(s.authenticated)
+	return nil
+}
+
+// cancel releases the connection slot of a request that was not upgraded.
+// It does nothing after hold.
+func (a *admission) cancel() {
+	if a.release != nil {
+		a.release()
+		a.release = nil
+	}
+}
+
+// admit rejects the request while the connection manager is shutting down,
+// from a client address denied by the RemoteAddrPolicy or over the
+// connection limits, and authenticates the client. The caller must call
+// cancel or hold on the returned admission.
+func (u *Upgrader) admit(w http.ResponseWriter, r *http.Request) (*admission, error) {
+	// Reject connections while shutting down
+	if u.ConnManager != nil && u.ConnManager.isShutdown() {
+		_, err := u.returnError(w, r, http.StatusServiceUnavailable, "websocket: server shutting down")
+		return nil, err
+	}
+
+	// Check the client address
+	a := &admission{realIP: u.RemoteAddrPolicy.ClientIP(r)}
+	if !u.RemoteAddrPolicy.Allowed(a.realIP) {
+		u.auditRequest(r, AuditViolation, "remote address denied")
+		_, err := u.returnError(w, r, http.StatusForbidden, ErrRemoteAddrDenied.Error())
+		return nil, err
+	}
+
+	// Enforce the connection limits
+	release, err := u.reserveConn(r, a.realIP)
+	if err != nil {
+		if u.OnConnectionLimit != nil {
+			u.OnConnectionLimit(r, err)
+		}
+		_, err = u.returnError(w, r, http.StatusServiceUnavailable, err.Error())
+		return nil, err
+	}
+	a.release = release
+
+	// Authenticate the client
+	if u.Authenticate != nil {
+		a.principal, err = u.Authenticate(r)
+		if err != nil {
+			a.cancel()
+			_, err = u.rejectAuth(w, r, err)
+			return nil, err
+		}
+	}
+	if u.LoadSession != nil {
+		a.session, err = u.LoadSession(r)
+		if err != nil {
+			a.cancel()
+			_, err = u.returnError(w, r, http.StatusInternalServerError, "websocket: loading session failed: "+err.Error())
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
 // Upgrade upgrades the HTTP server connection to the WebSocket protocol.
 //
 // The responseHeader is included in the response to the client's upgrade
@@ -238,7 +600,23 @@ func (u *Upgrader) clearConnectionDeadline(netConn net.Conn) error {
 //
 // If the upgrade fails, then Upgrade replies to the client with an HTTP error
 // response.
+//
+// Upgrade also accepts WebSocket handshakes sent over HTTP/2 with the extended
+// CONNECT method (RFC 8441). Such a connection is carried by the request
+// stream rather than a hijacked network connection, so the handler must not
+// return until the application is done with the connection. The net/http
+// server advertises extended CONNECT support only when the GODEBUG setting
+// http2xconnect=1 is set.
 func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if isExtendedConnect(r) {
+		return u.upgradeHTTP2(w, r, responseHeader)
+	}
+
+	if u.MaxHeaderBytes > 0 && requestHeaderSize(r) > u.MaxHeaderBytes {
+		u.handshakeLimit(r.RemoteAddr, ErrRequestHeaderTooLarge)
+		return u.returnError(w, r, http.StatusRequestHeaderFieldsTooLarge, ErrRequestHeaderTooLarge.Error())
+	}
+
 	// Validate the upgrade request
 	if !tokenListContainsValue(r.Header, "Connection", "upgrade") {
 		return u.returnError(w, r, http.StatusBadRequest, badHandshake+"'upgrade' token not found in 'Connection' header")
@@ -253,21 +631,8 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		return u.returnError(w, r, http.StatusMethodNotAllowed, badHandshake+"request method is not GET")
 	}
 
-	if !tokenListContainsValue(r.Header, "Sec-Websocket-Version", "13") {
-		return u.returnError(w, r, http.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
-	}
-
-	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
-		return u.returnError(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
-	}
-
-	// Validate origin
-	checkOrigin := u.CheckOrigin
-	if checkOrigin == nil {
-		checkOrigin = checkSameOrigin
-	}
-	if !checkOrigin(r) {
-		return u.returnError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	if c, err := u.checkRequest(w, r, responseHeader); err != nil {
+		return c, err
 	}
 
 	// Validate challenge key
@@ -276,11 +641,17 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		return u.returnError(w, r, http.StatusBadRequest, "websocket: not a websocket handshake: 'Sec-WebSocket-Key' header must be Base64 encoded value of 16-byte in length")
 	}
 
+	adm, err := u.admit(w, r)
+	if err != nil {
+		return nil, err
+	}
+	defer adm.cancel()
+
 	// Select subprotocol
 	subprotocol := u.selectSubprotocol(r, responseHeader)
 
-	// Negotiate compression
-	compress := u.negotiateCompression(r)
+	// Negotiate extensions
+	exts := u.negotiateExtensions(r)
 
 	// Hijack the connection
 	netConn, brw, err := HijackResponse(r, w)
@@ -302,17 +673,26 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 	}()
 
 	// Setup buffered reader
-	netConn, br := u.setupBufferedReader(netConn, brw)
+	var br *bufio.Reader
+	if t := u.throttle(r); t != nil {
+		// Read through the throttle instead of the hijacked reader.
+		if brw.Reader.Buffered() > 0 {
+			netConn = &brNetConn{br: brw.Reader, Conn: netConn}
+		}
+		netConn = t.Conn(netConn)
+	} else {
+		netConn, br = u.setupBufferedReader(netConn, brw)
+	}
 
 	// Setup write buffer
 	buf := brw.Writer.AvailableBuffer()
 	writeBuf := u.setupWriteBuffer(buf)
 
 	// Create WebSocket connection
-	c := u.createWebSocketConnection(netConn, subprotocol, compress, br, writeBuf)
+	c := u.createWebSocketConnection(netConn, subprotocol, exts, br, writeBuf)
 
 	// Generate upgrade response
-	p := generateUpgradeResponse(c, challengeKey, compress, responseHeader, buf)
+	p := generateUpgradeResponse(c, challengeKey, responseHeader, buf)
 
 	// Set connection deadline
 	if err := u.setConnectionDeadline(netConn); err != nil {
@@ -321,6 +701,9 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 
 	// Write response
 	if _, err = netConn.Write(p); err != nil {
+		if isTimeout(err) {
+			u.handshakeLimit(r.RemoteAddr, ErrHandshakeTimeout)
+		}
 		return nil, err
 	}
 
@@ -329,6 +712,11 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		return nil, err
 	}
 
+	// Track the connection
+	if err := u.upgradeSetup().complete(c, adm); err != nil {
+		return nil, err
+	}
+
 	// Success! Set netConn to nil to stop the deferred function above from
 	// closing the network connection.
 	netConn = nil
@@ -395,8 +783,9 @@ func Subprotocols(r *http.Request) []string {
 // IsWebSocketUpgrade returns true if the client requested upgrade to the
 // WebSocket protocol.
 func IsWebSocketUpgrade(r *http.Request) bool {
-	return tokenListContainsValue(r.Header, "Connection", "upgrade") &&
-		tokenListContainsValue(r.Header, "Upgrade", "websocket")
+	return isExtendedConnect(r) ||
+		tokenListContainsValue(r.Header, "Connection", "upgrade") &&
+			tokenListContainsValue(r.Header, "Upgrade", "websocket")
 }
 
 type brNetConn struct {
@@ -5,10 +5,12 @@
 package websocket
 
 import (
-	"bytes"
+	"bufio"
 	"fmt"
 	"github.com/gflydev/core/utils"
+	"log/slog"
 	"net"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
@@ -16,8 +18,6 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
-var strPermessageDeflate = []byte("permessage-deflate")
-
 var poolWriteBuffer = sync.Pool{
 	New: func() interface{} {
 		return new(writePoolData)
@@ -51,6 +51,17 @@ type FastHTTPUpgrader struct {
 	// WriteBufferSize.
 	WriteBufferPool BufferPool
 
+	// ReadBufferPool is a pool of buffers for read operations. If the value
+	// is set, then the connection holds a read buffer only while a frame is
+	// available to read and returns the buffer to the pool while waiting for
+	// the next frame. If the value is not set, then read buffers are
+	// allocated to the connection for the lifetime of the connection.
+	//
+	// A pool greatly reduces memory use of a large number of mostly idle
+	// connections. Applications should use a single pool for each unique
+	// value of ReadBufferSize.
+	ReadBufferPool BufferPool
+
 	// Subprotocols specifies the server's supported protocols in order of
 	// preference. If this field is not nil, then the Upgrade method negotiates a
 	// subprotocol by selecting the first match in this list with a protocol
@@ -72,15 +83,111 @@ type FastHTTPUpgrader struct {
 	// prevent cross-site request forgery.
 	CheckOrigin func(ctx *fasthttp.RequestCtx) bool
 
+	// OriginPolicy specifies an allowlist based origin check. OriginPolicy is
+	// used when CheckOrigin is nil.
+	OriginPolicy *OriginPolicy
+
+	// Authenticate, if not nil, is called before the handshake response is
+	// written. If Authenticate returns an error, the handshake is rejected.
+	// An *AuthError specifies the status code, headers and body of the
+	// response; other errors are reported with status 401 Unauthorized. The
+	// returned principal is attached to the connection and is available with
+	// Conn.Value.
+	//
+	// Use FastHTTPRequestToken to extract a token from the request.
+	Authenticate func(ctx *fasthttp.RequestCtx) (principal interface{}, err error)
+
+	// LoadSession, if not nil, loads the HTTP session of the request, such
+	// as the session identified by a cookie, after Authenticate. The
+	// session is attached to the connection for Conn.Session and saved when
+	// the connection is closed. If LoadSession returns an error, the
+	// handshake is rejected with status 500 Internal Server Error. See the
+	// gflyws package for the sessions of gFly applications.
+	LoadSession func(ctx *fasthttp.RequestCtx) (*Session, error)
+
 	// EnableCompression specify if the server should attempt to negotiate per
 	// message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
+
+	// CompressionLevel specifies the initial flate compression level for
+	// connections where compression is negotiated. If zero, a fast default
+	// level is used. See Conn.SetCompressionLevel.
+	CompressionLevel int
+
+	// CompressionThreshold specifies the minimum payload size in bytes for a
+	// message to be compressed. See Conn.SetCompressionThreshold.
+	CompressionThreshold int
+
+	// CompressionDictionaries are the preset dictionaries the server accepts
+	// when a client offers one with compression, see CompressionDictionary.
+	// Offers naming another dictionary are declined.
+	CompressionDictionaries []*CompressionDictionary
+
+	// Extensions are the extensions the server negotiates after
+	// permessage-deflate, in order. See Extension.
+	Extensions []Extension
+
+	// StrictUTF8 specifies whether the connections validate that text
+	// messages are valid UTF-8. See Conn.SetStrictUTF8.
+	StrictUTF8 bool
+
+	// ReadLimits specifies the limits on the frames and messages read by the
+	// connections. See Conn.SetReadLimits.
+	ReadLimits ReadLimits
+
+	// TCP specifies the socket options of the TCP connections. The zero
+	// value keeps the defaults of the net package. The options are best
+	// effort: errors setting them are ignored. See Conn.SetTCPOptions.
+	TCP TCPOptions
+
+	// ServerContextTakeover specifies whether the server may retain the
+	// compression context across the messages it writes. If false, the server
+	// negotiates server_no_context_takeover. Context takeover improves the
+	// compression ratio of repetitive messages at the cost of holding a
+	// compressor for the lifetime of each connection.
+	ServerContextTakeover bool
+
+	// ClientContextTakeover specifies whether the server permits the client to
+	// retain the compression context across the messages it writes. If false,
+	// the server negotiates client_no_context_takeover. Context takeover
+	// requires the server to hold a 32 KiB window for the lifetime of each
+	// connection.
+	ClientContextTakeover bool
+
+	// ConnManager, if not nil, tracks the connections created by Upgrade.
+	// After ConnManager.Shutdown is called, Upgrade rejects new connections
+	// with status 503 Service Unavailable.
+	ConnManager *ConnManager
+
+	// Registry, if not nil, registers the connections created by Upgrade
+	// for lookup by ID and index keys.
+	Registry *Registry
+
+	// Metrics, if not nil, receives events about the connections created by
+	// Upgrade and about failed handshakes.
+	Metrics Metrics
+
+	// Logger, if not nil, receives log records about failed handshakes and
+	// about the connections created by Upgrade.
+	Logger Logger
+
+	// EventSink, if not nil, receives the audit events of the connections
+	// created by Upgrade and of the handshakes rejected by the
+	// authentication, origin or remote address checks.
+	EventSink EventSink
+
+	protocols map[string]FastHTTPHandler
 }
 
 func (u *FastHTTPUpgrader) responseError(ctx *fasthttp.RequestCtx, status int, reason string) error {
-	err := HandshakeError{reason}
+	err := HandshakeError{message: reason}
+	if u.Metrics != nil {
+		u.Metrics.HandshakeFailed(status)
+	}
+	if u.Logger != nil {
+		u.Logger.Log(slog.LevelWarn, "websocket: handshake failed", "remote_addr", ctx.RemoteAddr().String(), "status", status, "error", reason)
+	}
 	if u.Error != nil {
 		u.Error(ctx, status, err)
 	} else {
@@ -109,96 +216,181 @@ func (u *FastHTTPUpgrader) selectSubprotocol(ctx *fasthttp.RequestCtx) []byte {
 	return nil
 }
 
-func (u *FastHTTPUpgrader) isCompressionEnable(ctx *fasthttp.RequestCtx) bool {
-	extensions := parseDataHeader(ctx.Request.Header.Peek("Sec-WebSocket-Extensions"))
-
-	// Negotiate PMCE
+// negotiateExtensions selects the extensions of the connection from the
+// offers of the request, starting with permessage-deflate if compression is
+// enabled.
+func (u *FastHTTPUpgrader) negotiateExtensions(ctx *fasthttp.RequestCtx) []negotiatedExtension {
+	exts := u.Extensions
 	if u.EnableCompression {
-		for _, ext := range extensions {
-			if bytes.HasPrefix(ext, strPermessageDeflate) {
-				return true
-			}
-		}
+		exts = append([]Extension{&deflateExtension{
+			serverContextTakeover: u.ServerContextTakeover,
+			clientContextTakeover: u.ClientContextTakeover,
+			dicts:                 u.CompressionDictionaries,
+		}}, exts...)
+	}
+	if len(exts) == 0 {
+		return nil
 	}
+	header := http.Header{"Sec-Websocket-Extensions": {string(ctx.Request.Header.Peek("Sec-WebSocket-Extensions"))}}
+	return acceptExtensions(header, exts)
+}
 
-	return false
+// fastHTTPHandshake holds the parameters negotiated for an upgrade request.
+type fastHTTPHandshake struct {
+	principal   interface{}
+	session     *Session
+	subprotocol []byte
+	extensions  []negotiatedExtension
 }
 
-// Upgrade upgrades the HTTP server connection to the WebSocket protocol.
-//
-// The responseHeader is included in the response to the client's upgrade
-// request. Use the responseHeader to specify cookies (Set-Cookie) and the
-// application negotiated subprotocol (Sec-WebSocket-Protocol).
-//
-// If the upgrade fails, then Upgrade replies to the client with an HTTP error
-// response.
-func (u *FastHTTPUpgrader) Upgrade(ctx *fasthttp.RequestCtx, handler FastHTTPHandler) error {
+// handshake validates the upgrade request, negotiates the connection
+// parameters and sets the handshake response on ctx.
+func (u *FastHTTPUpgrader) handshake(ctx *fasthttp.RequestCtx) (*fastHTTPHandshake, error) {
 	if !ctx.IsGet() {
-		return u.responseError(ctx, fasthttp.StatusMethodNotAllowed, fmt.Sprintf("%s request method is not GET", badHandshake))
+		return nil, u.responseError(ctx, fasthttp.StatusMethodNotAllowed, fmt.Sprintf("%s request method is not GET", badHandshake))
 	}
 
 	if !tokenContainsValue(utils.UnsafeStr(ctx.Request.Header.Peek("Connection")), "Upgrade") {
-		return u.responseError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("%s 'upgrade' token not found in 'Connection' header", badHandshake))
+		return nil, u.responseError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("%s 'upgrade' token not found in 'Connection' header", badHandshake))
 	}
 
 	if !tokenContainsValue(utils.UnsafeStr(ctx.Request.Header.Peek("Upgrade")), "Websocket") {
-		return u.responseError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("%s 'websocket' token not found in 'Upgrade' header", badHandshake))
+		return nil, u.responseError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("%s 'websocket' token not found in 'Upgrade' header", badHandshake))
 	}
 
 	if !tokenContainsValue(utils.UnsafeStr(ctx.Request.Header.Peek("Sec-Websocket-Version")), "13") {
-		return u.responseError(ctx, fasthttp.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
+		return nil, u.responseError(ctx, fasthttp.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
 	}
 
 	if len(ctx.Response.Header.Peek("Sec-Websocket-Extensions")) > 0 {
-		return u.responseError(ctx, fasthttp.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
+		return nil, u.responseError(ctx, fasthttp.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
 	}
 
 	checkOrigin := u.CheckOrigin
 	if checkOrigin == nil {
-		checkOrigin = fastHTTPCheckSameOrigin
+		if u.OriginPolicy != nil {
+			checkOrigin = u.OriginPolicy.CheckFastHTTP
+		} else {
+			checkOrigin = fastHTTPCheckSameOrigin
+		}
 	}
 	if !checkOrigin(ctx) {
-		return u.responseError(ctx, fasthttp.StatusForbidden, "websocket: request origin not allowed by FastHTTPUpgrader.CheckOrigin")
+		auditRequest(u.EventSink, ctx.RemoteAddr().String(), AuditViolation, "origin not allowed")
+		return nil, u.responseError(ctx, fasthttp.StatusForbidden, "websocket: request origin not allowed by FastHTTPUpgrader.CheckOrigin")
 	}
 
 	challengeKey := ctx.Request.Header.Peek("Sec-Websocket-Key")
 	if len(challengeKey) == 0 {
-		return u.responseError(ctx, fasthttp.StatusBadRequest, "websocket: not a websocket handshake: `Sec-WebSocket-Key' header is missing or blank")
+		return nil, u.responseError(ctx, fasthttp.StatusBadRequest, "websocket: not a websocket handshake: `Sec-WebSocket-Key' header is missing or blank")
 	}
 
-	subprotocol := u.selectSubprotocol(ctx)
-	compress := u.isCompressionEnable(ctx)
+	if u.ConnManager != nil && u.ConnManager.isShutdown() {
+		return nil, u.responseError(ctx, fasthttp.StatusServiceUnavailable, "websocket: server shutting down")
+	}
+
+	hs := &fastHTTPHandshake{}
+	if u.Authenticate != nil {
+		var err error
+		if hs.principal, err = u.Authenticate(ctx); err != nil {
+			return nil, u.rejectAuth(ctx, err)
+		}
+	}
+	if u.LoadSession != nil {
+		var err error
+		if hs.session, err = u.LoadSession(ctx); err != nil {
+			return nil, u.responseError(ctx, fasthttp.StatusInternalServerError, "websocket: loading session failed: "+err.Error())
+		}
+	}
+
+	hs.subprotocol = u.selectSubprotocol(ctx)
+	hs.extensions = u.negotiateExtensions(ctx)
 
 	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
 	ctx.Response.Header.Set("Upgrade", "websocket")
 	ctx.Response.Header.Set("Connection", "Upgrade")
 	ctx.Response.Header.Set("Sec-WebSocket-Accept", computeAcceptKeyBytes(challengeKey))
-	if compress {
-		ctx.Response.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	if len(hs.extensions) > 0 {
+		ctx.Response.Header.Set("Sec-WebSocket-Extensions", joinExtensionHeaders(hs.extensions))
+	}
+	if hs.subprotocol != nil {
+		ctx.Response.Header.SetBytesV("Sec-WebSocket-Protocol", hs.subprotocol)
 	}
-	if subprotocol != nil {
-		ctx.Response.Header.SetBytesV("Sec-WebSocket-Protocol", subprotocol)
+	return hs, nil
+}
+
+// newConn creates the connection for a completed handshake and adds it to
+// the connection manager.
+func (u *FastHTTPUpgrader) newConn(netConn net.Conn, hs *fastHTTPHandshake, writeBuf []byte) (*Conn, error) {
+	c := newConn(netConn, true, u.ReadBufferSize, u.WriteBufferSize, u.WriteBufferPool, nil, writeBuf)
+	if u.ReadBufferPool != nil {
+		c.setReadPool(u.ReadBufferPool)
+	}
+	if hs.subprotocol != nil {
+		c.subprotocol = string(hs.subprotocol)
+	}
+	c.strictUTF8 = u.StrictUTF8
+	c.SetReadLimits(u.ReadLimits)
+	c.applyTCPOptions(u.TCP)
+
+	c.setupExtensions(hs.extensions)
+	if c.newCompressionWriter != nil {
+		c.setCompressionOptions(u.CompressionLevel, u.CompressionThreshold)
+	}
+
+	s := upgradeSetup{
+		logger:        u.Logger,
+		metrics:       u.Metrics,
+		eventSink:     u.EventSink,
+		connManager:   u.ConnManager,
+		registry:      u.Registry,
+		authenticated: u.Authenticate != nil,
+	}
+	if err := s.complete(c, &admission{principal: hs.principal, session: hs.session}); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Upgrade upgrades the HTTP server connection to the WebSocket protocol.
+//
+// The responseHeader is included in the response to the client's upgrade
+// request. Use the responseHeader to specify cookies (Set-Cookie) and the
+// application negotiated subprotocol (Sec-WebSocket-Protocol).
+//
+// If the upgrade fails, then Upgrade replies to the client with an HTTP error
+// response.
+func (u *FastHTTPUpgrader) Upgrade(ctx *fasthttp.RequestCtx, handler FastHTTPHandler) error {
+	hs, err := u.handshake(ctx)
+	if err != nil {
+		return err
 	}
 
 	ctx.Hijack(func(netConn net.Conn) {
 		// var br *bufio.Reader  // Always nil
 		writeBuf := poolWriteBuffer.Get().(*writePoolData)
 
-		c := newConn(netConn, true, u.ReadBufferSize, u.WriteBufferSize, u.WriteBufferPool, nil, writeBuf.buf)
-		if subprotocol != nil {
-			c.subprotocol = utils.UnsafeStr(subprotocol)
-		}
-
-		if compress {
-			c.newCompressionWriter = compressNoContextTakeover
-			c.newDecompressionReader = decompressNoContextTakeover
-		}
-
 		// Clear deadlines set by HTTP server.
 		_ = netConn.SetDeadline(time.Time{})
 
+		c, err := u.newConn(netConn, hs, writeBuf.buf)
+		if err != nil {
+			return
+		}
+		if u.ConnManager != nil {
+			defer u.ConnManager.Remove(c)
+		}
+		if u.Registry != nil {
+			defer u.Registry.Remove(c)
+		}
+
 		handler(c)
 
+		// The network connection is closed by fasthttp when the handler
+		// returns. Close the websocket connection to stop its background
+		// goroutines.
+		_ = c.Close()
+
 		writeBuf.buf = writeBuf.buf[0:0]
 		poolWriteBuffer.Put(writeBuf)
 	})
@@ -206,6 +398,91 @@ func (u *FastHTTPUpgrader) Upgrade(ctx *fasthttp.RequestCtx, handler FastHTTPHan
 	return nil
 }
 
+// UpgradeConn upgrades the HTTP server connection to the WebSocket protocol
+// and returns the connection, like Upgrader.Upgrade does for net/http. Unlike
+// Upgrade, UpgradeConn writes the handshake response before returning, so
+// the request handler can start goroutines that use the connection and
+// return.
+//
+// The connection is taken over from the server with ctx.Hijack. The
+// application must close the connection when done with it; the server
+// releases ctx only after the connection is closed. The application must not
+// use ctx after UpgradeConn returns the connection.
+//
+// If the upgrade fails, then UpgradeConn replies to the client with an HTTP
+// error response.
+func (u *FastHTTPUpgrader) UpgradeConn(ctx *fasthttp.RequestCtx) (*Conn, error) {
+	hs, err := u.handshake(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	netConn := &fastHTTPConn{Conn: ctx.Conn()}
+	if u.HandshakeTimeout > 0 {
+		_ = netConn.Conn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	bw := bufio.NewWriter(netConn.Conn)
+	if err := ctx.Response.Write(bw); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	_ = netConn.Conn.SetDeadline(time.Time{})
+
+	c, err := u.newConn(netConn, hs, nil)
+	if err != nil {
+		ctx.SetConnectionClose()
+		return nil, err
+	}
+
+	// The response was written above. Keep the network connection open
+	// until the application closes the websocket connection.
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(net.Conn) {
+		netConn.restoreDeadlines()
+		<-c.closed
+	})
+	return c, nil
+}
+
+// fastHTTPConn records the deadlines set on a connection returned by
+// UpgradeConn. The server clears the deadlines of the connection when the
+// request handler returns; the hijack handler restores them.
+type fastHTTPConn struct {
+	net.Conn
+	mu     sync.Mutex
+	rd, wd time.Time
+}
+
+func (c *fastHTTPConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rd, c.wd = t, t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *fastHTTPConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rd = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *fastHTTPConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wd = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *fastHTTPConn) restoreDeadlines() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.Conn.SetReadDeadline(c.rd)
+	_ = c.Conn.SetWriteDeadline(c.wd)
+}
+
 // fastHTTPCheckSameOrigin returns true if the origin is not set or is equal to the request host.
 func fastHTTPCheckSameOrigin(ctx *fasthttp.RequestCtx) bool {
 	origin := ctx.Request.Header.Peek("Origin")
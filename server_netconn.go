@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// UpgradeNetConn reads an HTTP upgrade request from the pre-established
+// network connection netConn and upgrades the connection to the WebSocket
+// protocol. Use UpgradeNetConn to accept WebSocket connections from
+// listeners not served by net/http, such as unix sockets or tunnels. The
+// request is returned so that the application can inspect the URL and
+// headers.
+//
+// If HandshakeTimeout is set, it bounds reading the request and writing the
+// response; a request not read in time fails with ErrHandshakeTimeout. A
+// request larger than MaxHeaderBytes fails with ErrRequestHeaderTooLarge.
+// If the upgrade fails, then UpgradeNetConn replies to the client with an
+// HTTP error response and closes netConn.
+func (u *Upgrader) UpgradeNetConn(netConn net.Conn, responseHeader http.Header) (*Conn, *http.Request, error) {
+	if u.HandshakeTimeout > 0 {
+		_ = netConn.SetDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	limit := u.MaxHeaderBytes
+	if limit <= 0 {
+		limit = http.DefaultMaxHeaderBytes
+	}
+	lr := &limitedHeaderReader{r: netConn, n: int64(limit)}
+	brw := bufio.NewReadWriter(bufio.NewReader(lr), bufio.NewWriter(netConn))
+	r, err := http.ReadRequest(brw.Reader)
+	if err != nil {
+		remoteAddr := netConn.RemoteAddr().String()
+		switch {
+		case lr.exceeded:
+			err = ErrRequestHeaderTooLarge
+			u.handshakeLimit(remoteAddr, err)
+			if u.Metrics != nil {
+				u.Metrics.HandshakeFailed(http.StatusRequestHeaderFieldsTooLarge)
+			}
+			_, _ = io.WriteString(netConn, "HTTP/1.1 431 Request Header Fields Too Large\r\nConnection: close\r\n\r\n")
+		case u.HandshakeTimeout > 0 && isTimeout(err):
+			err = ErrHandshakeTimeout
+			u.handshakeLimit(remoteAddr, err)
+			if u.Metrics != nil {
+				u.Metrics.HandshakeFailed(http.StatusRequestTimeout)
+			}
+		}
+		_ = netConn.Close()
+		return nil, nil, err
+	}
+	lr.n = -1
+	r.RemoteAddr = netConn.RemoteAddr().String()
+
+	w := &netConnResponseWriter{conn: netConn, brw: brw, header: make(http.Header)}
+	c, err := u.Upgrade(w, r, responseHeader)
+	if err != nil {
+		w.finish(r)
+		_ = netConn.Close()
+		return nil, r, err
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	return c, r, nil
+}
+
+// netConnResponseWriter is the http.ResponseWriter passed to Upgrade by
+// UpgradeNetConn. Error responses are buffered and written by finish.
+type netConnResponseWriter struct {
+	conn     net.Conn
+	brw      *bufio.ReadWriter
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	hijacked bool
+}
+
+func (w *netConnResponseWriter) Header() http.Header { return w.header }
+
+func (w *netConnResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *netConnResponseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+func (w *netConnResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	w.hijacked = true
+	return w.conn, w.brw, nil
+}
+
+// finish writes the buffered response if the connection was not hijacked.
+func (w *netConnResponseWriter) finish(r *http.Request) {
+	if w.hijacked || w.status == 0 {
+		return
+	}
+	w.header.Set("Connection", "close")
+	resp := &http.Response{
+		StatusCode:    w.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Request:       r,
+	}
+	_ = resp.Write(w.conn)
+}
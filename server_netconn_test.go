@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// serveNetConns upgrades the connections accepted from ln and echoes one
+// message on each.
+func serveNetConns(t *testing.T, ln net.Listener, u *Upgrader) {
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, r, err := u.UpgradeNetConn(nc, nil)
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				if r.URL.Path != "/ws" {
+					t.Errorf("request path is %q, want /ws", r.URL.Path)
+				}
+				mt, p, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				_ = c.WriteMessage(mt, p)
+			}()
+		}
+	}()
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ws.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	defer ln.Close()
+	serveNetConns(t, ln, &Upgrader{})
+
+	d := Dialer{UnixSocket: path}
+	ws, resp, err := d.Dial("ws://example.com/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	sendRecv(t, ws)
+}
+
+func TestClientOverNetConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serveNetConns(t, ln, &Upgrader{})
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, _, err := Client(nc, "ws://example.com/ws", nil)
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+	defer ws.Close()
+	if ws.NetConn() != nc {
+		t.Fatal("connection does not use the given net.Conn")
+	}
+	sendRecv(t, ws)
+}
+
+func TestUpgradeNetConnError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serveNetConns(t, ln, &Upgrader{CheckOrigin: func(*http.Request) bool { return false }})
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, resp, err := Client(nc, "ws://example.com/ws", nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Client() returned %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// Session is the HTTP session of the request that opened a connection, such
+// as the values of a cookie session loaded by Upgrader.LoadSession. The
+// handlers of the connection read and change the values, and the changed
+// session is saved when the connection is closed.
+//
+// It is safe to call Session's methods concurrently.
+type Session struct {
+	id   string
+	save func(s *Session) error
+
+	mu       sync.RWMutex
+	values   map[string]interface{}
+	modified bool
+}
+
+// NewSession returns a session with the ID and a copy of the values. The
+// save function, if not nil, persists the session; it is called by Save and
+// when a connection holding the modified session is closed.
+func NewSession(id string, values map[string]interface{}, save func(s *Session) error) *Session {
+	s := &Session{id: id, save: save, values: make(map[string]interface{}, len(values))}
+	for k, v := range values {
+		s.values[k] = v
+	}
+	return s
+}
+
+// ID returns the ID of the session.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Get returns the value stored under the key and whether the key is present.
+func (s *Session) Get(key string) (value interface{}, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok = s.values[key]
+	return value, ok
+}
+
+// Set stores a value under the key and marks the session modified.
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	s.values[key] = value
+	s.modified = true
+	s.mu.Unlock()
+}
+
+// Delete removes the key and marks the session modified.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+	s.mu.Unlock()
+}
+
+// Values returns a copy of the values of the session.
+func (s *Session) Values() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := make(map[string]interface{}, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return values
+}
+
+// Modified reports whether the values changed since the session was loaded
+// or last saved.
+func (s *Session) Modified() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.modified
+}
+
+// Save persists the session with the save function of the session if the
+// values changed since the session was loaded or last saved.
+func (s *Session) Save() error {
+	s.mu.Lock()
+	modified := s.modified
+	s.modified = false
+	s.mu.Unlock()
+	if !modified || s.save == nil {
+		return nil
+	}
+	if err := s.save(s); err != nil {
+		s.mu.Lock()
+		s.modified = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Session returns the HTTP session attached to the connection by the
+// upgrader's LoadSession function or with SetSession, or nil.
+func (c *Conn) Session() *Session {
+	if c == nil {
+		return nil
+	}
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.session
+}
+
+// SetSession attaches an HTTP session to the connection, for integrations
+// loading the session outside of the upgrader. The session is saved when the
+// connection is closed.
+func (c *Conn) SetSession(s *Session) {
+	if c == nil {
+		return
+	}
+	c.metaMu.Lock()
+	c.session = s
+	c.metaMu.Unlock()
+}
+
+// saveSession saves the session of a closed connection.
+func (c *Conn) saveSession() {
+	s := c.Session()
+	if s == nil {
+		return
+	}
+	if err := s.Save(); err != nil {
+		c.log(slog.LevelError, "websocket: saving session failed", "error", err.Error())
+	}
+}
@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	var saved []map[string]interface{}
+	errSave := errors.New("store down")
+	var fail bool
+	s := NewSession("abc", map[string]interface{}{"user": "alice"}, func(s *Session) error {
+		if fail {
+			return errSave
+		}
+		saved = append(saved, s.Values())
+		return nil
+	})
+	if s.ID() != "abc" {
+		t.Errorf("ID returned %q, want abc", s.ID())
+	}
+	if v, ok := s.Get("user"); !ok || v != "alice" {
+		t.Errorf("Get returned %v, %v, want alice", v, ok)
+	}
+	if s.Modified() {
+		t.Error("new session modified")
+	}
+	_ = s.Save()
+	if len(saved) != 0 {
+		t.Error("unmodified session saved")
+	}
+
+	s.Set("cart", 3)
+	s.Delete("user")
+	s.Delete("missing")
+	fail = true
+	if err := s.Save(); err != errSave {
+		t.Fatalf("Save returned %v, want %v", err, errSave)
+	}
+	if !s.Modified() {
+		t.Error("session not modified after a failed save")
+	}
+	fail = false
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || len(saved[0]) != 1 || saved[0]["cart"] != 3 {
+		t.Fatalf("saved %v, want cart only", saved)
+	}
+	if s.Modified() {
+		t.Error("session modified after Save")
+	}
+}
+
+func TestUpgraderLoadSession(t *testing.T) {
+	saved := make(chan *Session, 1)
+	u := Upgrader{
+		LoadSession: func(r *http.Request) (*Session, error) {
+			cookie, err := r.Cookie("sid")
+			if err != nil {
+				return nil, errors.New("no session cookie")
+			}
+			return NewSession(cookie.Value, map[string]interface{}{"visits": 1}, func(s *Session) error {
+				saved <- s
+				return nil
+			}), nil
+		},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		sess := c.Session()
+		v, _ := sess.Get("visits")
+		sess.Set("visits", v.(int)+1)
+		_ = c.WriteMessage(TextMessage, []byte(sess.ID()))
+	}))
+	defer s.Close()
+	wsURL := makeWsProto(s.URL)
+
+	c, _, err := DefaultDialer.Dial(wsURL, http.Header{"Cookie": {"sid=s1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := readString(t, c); got != "s1" {
+		t.Fatalf("session ID %q, want s1", got)
+	}
+	select {
+	case sess := <-saved:
+		if v, _ := sess.Get("visits"); v != 2 {
+			t.Errorf("saved visits %v, want 2", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session not saved on close")
+	}
+
+	_, resp, err := DefaultDialer.Dial(wsURL, nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Dial without session returned %v, %v, want status 500", resp, err)
+	}
+}
@@ -0,0 +1,316 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSessionTTL        = 24 * time.Hour
+	defaultSessionCookieName = "ws_session"
+	defaultSessionQueryParam = "session"
+)
+
+var (
+	// ErrNoSessionToken is returned by StickySessions.Token when the request
+	// carries no session token.
+	ErrNoSessionToken = errors.New("websocket: no session token")
+
+	// ErrInvalidSessionToken is returned when a session token is malformed
+	// or its signature is invalid.
+	ErrInvalidSessionToken = errors.New("websocket: invalid session token")
+
+	// ErrSessionTokenExpired is returned when a session token has expired.
+	ErrSessionTokenExpired = errors.New("websocket: session token expired")
+)
+
+// SessionToken is the content of a signed session token.
+type SessionToken struct {
+	// ID identifies the session across the connections of a client.
+	ID string
+
+	// Node is the node that issued the token, for session affinity.
+	Node string
+
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// SessionTokenSigner encodes session tokens into signed strings and parses
+// them back. HMACTokenSigner and JWTTokenSigner are the implementations
+// provided by this package.
+type SessionTokenSigner interface {
+	// Sign returns the signed encoding of the token.
+	Sign(t *SessionToken) (string, error)
+
+	// Parse verifies the signature of the encoded token and returns its
+	// content. Parse does not check the expiry.
+	Parse(s string) (*SessionToken, error)
+}
+
+// sessionClaims is the JSON encoding of a SessionToken, with the claim names
+// registered for JWT.
+type sessionClaims struct {
+	ID        string `json:"sid"`
+	Node      string `json:"node,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (t *SessionToken) claims() sessionClaims {
+	return sessionClaims{ID: t.ID, Node: t.Node, IssuedAt: t.IssuedAt.Unix(), ExpiresAt: t.ExpiresAt.Unix()}
+}
+
+func (sc *sessionClaims) token() *SessionToken {
+	return &SessionToken{ID: sc.ID, Node: sc.Node, IssuedAt: time.Unix(sc.IssuedAt, 0), ExpiresAt: time.Unix(sc.ExpiresAt, 0)}
+}
+
+var tokenEncoding = base64.RawURLEncoding
+
+func hmacSHA256(key []byte, parts ...string) []byte {
+	m := hmac.New(sha256.New, key)
+	for i, p := range parts {
+		if i > 0 {
+			m.Write([]byte{'.'})
+		}
+		m.Write([]byte(p))
+	}
+	return m.Sum(nil)
+}
+
+// HMACTokenSigner signs session tokens with HMAC-SHA256. A token is the
+// base64url encoding of its JSON claims and of the signature, separated by a
+// dot.
+type HMACTokenSigner struct {
+	Key []byte
+}
+
+// Sign implements SessionTokenSigner.
+func (s HMACTokenSigner) Sign(t *SessionToken) (string, error) {
+	p, err := json.Marshal(t.claims())
+	if err != nil {
+		return "", err
+	}
+	payload := tokenEncoding.EncodeToString(p)
+	return payload + "." + tokenEncoding.EncodeToString(hmacSHA256(s.Key, payload)), nil
+}
+
+// Parse implements SessionTokenSigner.
+func (s HMACTokenSigner) Parse(token string) (*SessionToken, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidSessionToken
+	}
+	return parseSigned(s.Key, sig, payload)
+}
+
+// JWTTokenSigner signs session tokens as JSON Web Tokens with the HS256
+// algorithm. The claims are "sid", "node", "iat" and "exp".
+type JWTTokenSigner struct {
+	Key []byte
+}
+
+// jwtHeader is the encoded JOSE header of the tokens signed by
+// JWTTokenSigner.
+var jwtHeader = tokenEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Sign implements SessionTokenSigner.
+func (s JWTTokenSigner) Sign(t *SessionToken) (string, error) {
+	p, err := json.Marshal(t.claims())
+	if err != nil {
+		return "", err
+	}
+	payload := tokenEncoding.EncodeToString(p)
+	return jwtHeader + "." + payload + "." + tokenEncoding.EncodeToString(hmacSHA256(s.Key, jwtHeader, payload)), nil
+}
+
+// Parse implements SessionTokenSigner. Only tokens with the HS256
+// algorithm are accepted.
+func (s JWTTokenSigner) Parse(token string) (*SessionToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidSessionToken
+	}
+	h, err := tokenEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(h, &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidSessionToken
+	}
+	return parseSigned(s.Key, parts[2], parts[0], parts[1])
+}
+
+// parseSigned verifies the base64url signature of the parts and decodes the
+// claims in the last part.
+func parseSigned(key []byte, sig string, parts ...string) (*SessionToken, error) {
+	mac, err := tokenEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, hmacSHA256(key, parts...)) {
+		return nil, ErrInvalidSessionToken
+	}
+	p, err := tokenEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	var sc sessionClaims
+	if err := json.Unmarshal(p, &sc); err != nil || sc.ID == "" {
+		return nil, ErrInvalidSessionToken
+	}
+	return sc.token(), nil
+}
+
+// StickySessions issues and validates signed session tokens during the
+// opening handshake. The token is returned to the client in a cookie and
+// identifies the session when the client reconnects, from the cookie or from
+// a query parameter, so that load balancers can route the client to the
+// same node and the application can resume the state of the session.
+//
+// Clients using a Dialer with a cookie jar, such as a ReconnectingConn, send
+// the cookie back on each reconnection.
+type StickySessions struct {
+	// Signer signs and verifies the tokens. Signer must not be nil.
+	Signer SessionTokenSigner
+
+	// Node is recorded in the tokens issued by this node.
+	Node string
+
+	// TTL is the lifetime of the tokens. Resuming a session issues a token
+	// with a new expiry. If zero, a default of 24 hours is used.
+	TTL time.Duration
+
+	// CookieName is the name of the session cookie. If empty, a default of
+	// "ws_session" is used.
+	CookieName string
+
+	// QueryParam is the name of the query parameter carrying the token for
+	// clients that cannot send cookies. If empty, a default of "session" is
+	// used.
+	QueryParam string
+
+	// CookiePath is the path of the session cookie. If empty, the cookie
+	// applies to all paths.
+	CookiePath string
+
+	// now returns the current time; tests replace it.
+	now func() time.Time
+}
+
+func (s *StickySessions) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *StickySessions) cookieName() string {
+	if s.CookieName != "" {
+		return s.CookieName
+	}
+	return defaultSessionCookieName
+}
+
+// Token returns the valid session token of the request, read from the query
+// parameter or else from the cookie. Token returns ErrNoSessionToken,
+// ErrInvalidSessionToken or ErrSessionTokenExpired if the request has no
+// valid token.
+func (s *StickySessions) Token(r *http.Request) (*SessionToken, error) {
+	param := s.QueryParam
+	if param == "" {
+		param = defaultSessionQueryParam
+	}
+	raw := r.URL.Query().Get(param)
+	if raw == "" {
+		if c, err := r.Cookie(s.cookieName()); err == nil {
+			raw = c.Value
+		}
+	}
+	if raw == "" {
+		return nil, ErrNoSessionToken
+	}
+	t, err := s.Signer.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !s.clock().Before(t.ExpiresAt) {
+		return nil, ErrSessionTokenExpired
+	}
+	return t, nil
+}
+
+// Issue returns a token for the session ID with a new expiry. Issue starts a
+// new session if id is empty.
+func (s *StickySessions) Issue(id string) (*SessionToken, string, error) {
+	if id == "" {
+		id = newSessionID()
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	now := s.clock()
+	t := &SessionToken{ID: id, Node: s.Node, IssuedAt: now, ExpiresAt: now.Add(ttl)}
+	signed, err := s.Signer.Sign(t)
+	if err != nil {
+		return nil, "", err
+	}
+	return t, signed, nil
+}
+
+// Upgrade upgrades the HTTP server connection like u.Upgrade with the
+// session of the request. A request with a valid token resumes its session;
+// other requests start a new session. The response sets the cookie with a
+// token for the session, and the token is available from SessionTokenOf.
+// The returned bool reports whether the session was resumed.
+func (s *StickySessions) Upgrade(u *Upgrader, w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, bool, error) {
+	var id string
+	old, err := s.Token(r)
+	if err == nil {
+		id = old.ID
+	}
+	t, signed, err := s.Issue(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if responseHeader == nil {
+		responseHeader = make(http.Header)
+	}
+	cookie := &http.Cookie{
+		Name:     s.cookieName(),
+		Value:    signed,
+		Path:     s.CookiePath,
+		Expires:  t.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	responseHeader.Add("Set-Cookie", cookie.String())
+	c, err := u.Upgrade(w, r, responseHeader)
+	if err != nil {
+		return nil, false, err
+	}
+	c.Set(sessionTokenKey, t)
+	return c, old != nil, nil
+}
+
+const sessionTokenKey = "websocket.session" // Conn metadata key of the session token
+
+// SessionTokenOf returns the session token of a connection upgraded by
+// StickySessions.Upgrade, or nil.
+func SessionTokenOf(c *Conn) *SessionToken {
+	if v, ok := c.Get(sessionTokenKey); ok {
+		return v.(*SessionToken)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionTokenSigners(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tok := &SessionToken{ID: "abc", Node: "node-1", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+	for _, s := range []SessionTokenSigner{HMACTokenSigner{Key: []byte("k")}, JWTTokenSigner{Key: []byte("k")}} {
+		signed, err := s.Sign(tok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Parse(signed)
+		if err != nil || *got != *tok {
+			t.Errorf("%T: parsed %+v, %v; want %+v", s, got, err, tok)
+		}
+		for _, bad := range []string{"", "x", signed + "x", strings.Replace(signed, ".", "x.", 1)} {
+			if _, err := s.Parse(bad); err != ErrInvalidSessionToken {
+				t.Errorf("%T: Parse(%q) returned %v", s, bad, err)
+			}
+		}
+	}
+
+	// The other key or the other format is rejected.
+	signed, _ := HMACTokenSigner{Key: []byte("k")}.Sign(tok)
+	if _, err := (HMACTokenSigner{Key: []byte("other")}).Parse(signed); err != ErrInvalidSessionToken {
+		t.Errorf("token with other key: %v", err)
+	}
+	if _, err := (JWTTokenSigner{Key: []byte("k")}).Parse(signed); err != ErrInvalidSessionToken {
+		t.Errorf("HMAC token parsed as JWT: %v", err)
+	}
+
+	// A JWT with another algorithm is rejected.
+	jwt, _ := JWTTokenSigner{Key: []byte("k")}.Sign(tok)
+	none := tokenEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + jwt[strings.Index(jwt, "."):]
+	if _, err := (JWTTokenSigner{Key: []byte("k")}).Parse(none); err != ErrInvalidSessionToken {
+		t.Errorf("JWT with alg none: %v", err)
+	}
+}
+
+func TestStickySessionsToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := &StickySessions{Signer: HMACTokenSigner{Key: []byte("k")}, Node: "n1", TTL: time.Minute, now: func() time.Time { return now }}
+	tok, signed, err := s.Issue("")
+	if err != nil || tok.ID == "" || tok.Node != "n1" || !tok.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Issue returned %+v, %v", tok, err)
+	}
+
+	r := httptest.NewRequest("GET", "/ws?session="+url.QueryEscape(signed), nil)
+	if got, err := s.Token(r); err != nil || got.ID != tok.ID {
+		t.Fatalf("token from query: %+v, %v", got, err)
+	}
+	r = httptest.NewRequest("GET", "/ws", nil)
+	r.AddCookie(&http.Cookie{Name: "ws_session", Value: signed})
+	if got, err := s.Token(r); err != nil || got.ID != tok.ID {
+		t.Fatalf("token from cookie: %+v, %v", got, err)
+	}
+	if _, err := s.Token(httptest.NewRequest("GET", "/ws", nil)); err != ErrNoSessionToken {
+		t.Fatalf("request without token: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := s.Token(r); err != ErrSessionTokenExpired {
+		t.Fatalf("expired token: %v", err)
+	}
+}
+
+func TestStickySessionsUpgrade(t *testing.T) {
+	s := &StickySessions{Signer: JWTTokenSigner{Key: []byte("k")}, Node: "n1"}
+	type result struct {
+		id      string
+		resumed bool
+	}
+	results := make(chan result, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, resumed, err := s.Upgrade(&Upgrader{}, w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		results <- result{SessionTokenOf(c).ID, resumed}
+	}))
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	d := Dialer{Jar: jar}
+	u := "ws" + strings.TrimPrefix(srv.URL, "http")
+	var first result
+	for i := 0; i < 2; i++ {
+		c, resp, err := d.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if len(resp.Cookies()) != 1 || !resp.Cookies()[0].HttpOnly {
+			t.Fatalf("response cookies %v", resp.Cookies())
+		}
+		r := <-results
+		switch {
+		case i == 0 && r.resumed:
+			t.Fatal("first connection resumed a session")
+		case i == 0:
+			first = r
+		case !r.resumed || r.id != first.id:
+			t.Fatalf("reconnection got session %+v, want %s resumed", r, first.id)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature is returned when reading a data message that is
+	// not signed or whose signature does not verify.
+	ErrInvalidSignature = errors.New("websocket: invalid message signature")
+
+	errUnsignableText = errors.New("websocket: signed text message is not JSON")
+)
+
+// MessageSigner signs the payloads of data messages.
+type MessageSigner interface {
+	Sign(messageType int, payload []byte) (signature []byte, err error)
+}
+
+// MessageVerifier verifies the signatures of data messages. Verify returns
+// a non-nil error if the signature is invalid.
+type MessageVerifier interface {
+	Verify(messageType int, payload, signature []byte) error
+}
+
+// HMACMessageSigner signs and verifies messages with HMAC-SHA256. The
+// signature covers the message type and the payload.
+type HMACMessageSigner struct {
+	Key []byte
+}
+
+func (s HMACMessageSigner) mac(messageType int, payload []byte) []byte {
+	m := hmac.New(sha256.New, s.Key)
+	m.Write([]byte{byte(messageType)})
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+// Sign implements MessageSigner.
+func (s HMACMessageSigner) Sign(messageType int, payload []byte) ([]byte, error) {
+	return s.mac(messageType, payload), nil
+}
+
+// Verify implements MessageVerifier.
+func (s HMACMessageSigner) Verify(messageType int, payload, signature []byte) error {
+	if !hmac.Equal(signature, s.mac(messageType, payload)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SignatureOptions specifies how the messages of a connection are signed
+// and verified.
+//
+// Text messages are sent in a JSON envelope holding the payload, which must
+// be JSON, and the base64 encoding of the signature:
+//
+//	{"payload":{"event":"chat"},"sig":"q83vEjRWeJA..."}
+//
+// Binary messages are sent as the payload followed by the signature and a
+// byte holding the length of the signature.
+type SignatureOptions struct {
+	// Signer, if not nil, signs the data messages written.
+	Signer MessageSigner
+
+	// Verifier, if not nil, verifies the data messages read.
+	Verifier MessageVerifier
+
+	// PayloadField and SignatureField are the names of the fields of the
+	// envelope of text messages. If empty, "payload" and "sig" are used.
+	PayloadField   string
+	SignatureField string
+
+	// CloseOnFailure specifies whether the connection sends a close message
+	// with ClosePolicyViolation when a message fails verification. In both
+	// cases, the message is dropped and the read methods return
+	// ErrInvalidSignature.
+	CloseOnFailure bool
+}
+
+func (o *SignatureOptions) fields() (payload, signature string) {
+	payload, signature = o.PayloadField, o.SignatureField
+	if payload == "" {
+		payload = "payload"
+	}
+	if signature == "" {
+		signature = "sig"
+	}
+	return payload, signature
+}
+
+// UseSignatures signs the data messages written to the connection and
+// verifies the data messages read, as specified by the options. Signing and
+// verification are interceptors appended to the outbound and inbound
+// chains: the signature covers the payload as rewritten by the outbound
+// interceptors registered before, and the inbound interceptors registered
+// before see the signed messages. Call UseSignatures before UseInbound so
+// that the inbound interceptors see verified payloads.
+//
+// UseSignatures is safe to call concurrently with all other methods.
+func (c *Conn) UseSignatures(o SignatureOptions) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	payloadField, signatureField := o.fields()
+	if o.Signer != nil {
+		c.UseOutbound(func(messageType int, data []byte) ([]byte, error) {
+			return signMessage(o.Signer, payloadField, signatureField, messageType, data)
+		})
+	}
+	if o.Verifier != nil {
+		c.UseInbound(func(messageType int, data []byte) ([]byte, error) {
+			payload, err := verifyMessage(o.Verifier, payloadField, signatureField, messageType, data)
+			if err != nil {
+				if o.CloseOnFailure {
+					_ = c.WriteControl(CloseMessage, FormatCloseMessage(ClosePolicyViolation, "invalid signature"), time.Now().Add(writeWait))
+				}
+				return nil, ErrInvalidSignature
+			}
+			return payload, nil
+		})
+	}
+	return nil
+}
+
+func signMessage(s MessageSigner, payloadField, signatureField string, messageType int, data []byte) ([]byte, error) {
+	if messageType == TextMessage {
+		if !json.Valid(data) {
+			return nil, errUnsignableText
+		}
+		// The envelope field holds the value without surrounding space.
+		data = bytes.TrimSpace(data)
+	}
+	sig, err := s.Sign(messageType, data)
+	if err != nil {
+		return nil, err
+	}
+	if messageType == BinaryMessage {
+		if len(sig) > 255 {
+			return nil, errors.New("websocket: message signature longer than 255 bytes")
+		}
+		out := make([]byte, 0, len(data)+len(sig)+1)
+		out = append(out, data...)
+		out = append(out, sig...)
+		return append(out, byte(len(sig))), nil
+	}
+	// The envelope is built by hand because encoding/json compacts raw
+	// messages, which would change the signed bytes.
+	pf, _ := json.Marshal(payloadField)
+	sf, _ := json.Marshal(signatureField)
+	out := make([]byte, 0, len(data)+len(pf)+len(sf)+base64.StdEncoding.EncodedLen(len(sig))+8)
+	out = append(out, '{')
+	out = append(out, pf...)
+	out = append(out, ':')
+	out = append(out, data...)
+	out = append(out, ',')
+	out = append(out, sf...)
+	out = append(out, ':', '"')
+	out = base64.StdEncoding.AppendEncode(out, sig)
+	return append(out, '"', '}'), nil
+}
+
+func verifyMessage(v MessageVerifier, payloadField, signatureField string, messageType int, data []byte) ([]byte, error) {
+	var payload, sig []byte
+	if messageType == BinaryMessage {
+		if len(data) == 0 || int(data[len(data)-1]) > len(data)-1 {
+			return nil, ErrInvalidSignature
+		}
+		n := int(data[len(data)-1])
+		payload, sig = data[:len(data)-1-n], data[len(data)-1-n:len(data)-1]
+	} else {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, ErrInvalidSignature
+		}
+		var encoded string
+		if err := json.Unmarshal(envelope[signatureField], &encoded); err != nil {
+			return nil, ErrInvalidSignature
+		}
+		var err error
+		if sig, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, ErrInvalidSignature
+		}
+		payload = envelope[payloadField]
+		if payload == nil {
+			return nil, ErrInvalidSignature
+		}
+	}
+	if err := v.Verify(messageType, payload, sig); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestUseSignatures(t *testing.T) {
+	key := HMACMessageSigner{Key: []byte("secret")}
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	_ = s.UseSignatures(SignatureOptions{Signer: key, SignatureField: "signature"})
+	_ = c.UseSignatures(SignatureOptions{Verifier: key, SignatureField: "signature"})
+
+	text := `{"event": "chat", "data": {"text": "hi"}}`
+	go func() {
+		_ = s.WriteMessage(TextMessage, []byte(" "+text+"\n"))
+		_ = s.WriteMessage(BinaryMessage, []byte{1, 2, 3})
+		if err := s.WriteMessage(TextMessage, []byte("not json")); err != errUnsignableText {
+			t.Errorf("WriteMessage of text returned %v, want %v", err, errUnsignableText)
+		}
+	}()
+	if got := readString(t, c); got != text {
+		t.Errorf("text payload %q, want %q", got, text)
+	}
+	if got := readString(t, c); got != "\x01\x02\x03" {
+		t.Errorf("binary payload %q", got)
+	}
+}
+
+func TestSignatureEnvelope(t *testing.T) {
+	key := HMACMessageSigner{Key: []byte("secret")}
+	p, err := signMessage(key, "payload", "sig", TextMessage, []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope struct {
+		Payload json.RawMessage `json:"payload"`
+		Sig     []byte          `json:"sig"`
+	}
+	if err := json.Unmarshal(p, &envelope); err != nil || string(envelope.Payload) != `{"a":1}` {
+		t.Fatalf("envelope %s, %v", p, err)
+	}
+	if err := key.Verify(TextMessage, envelope.Payload, envelope.Sig); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := key.Verify(BinaryMessage, envelope.Payload, envelope.Sig); err != ErrInvalidSignature {
+		t.Errorf("Verify with another message type returned %v", err)
+	}
+
+	for _, tt := range []struct {
+		messageType int
+		data        []byte
+	}{
+		{TextMessage, []byte(`{"payload":{"a":2},"sig":"` + string(envelope.Sig) + `"}`)},
+		{TextMessage, []byte(`{"a":1}`)},
+		{TextMessage, bytes.Replace(p, []byte(`"a":1`), []byte(`"a":2`), 1)},
+		{BinaryMessage, nil},
+		{BinaryMessage, []byte{1, 2, 200}},
+	} {
+		if _, err := verifyMessage(key, "payload", "sig", tt.messageType, tt.data); err != ErrInvalidSignature {
+			t.Errorf("verifyMessage(%q) returned %v", tt.data, err)
+		}
+	}
+}
+
+func TestSignatureFailure(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	_ = s.UseSignatures(SignatureOptions{Signer: HMACMessageSigner{Key: []byte("other")}})
+	_ = c.UseSignatures(SignatureOptions{Verifier: HMACMessageSigner{Key: []byte("secret")}, CloseOnFailure: true})
+
+	closed := make(chan error, 1)
+	go func() {
+		_ = s.WriteMessage(BinaryMessage, []byte("forged"))
+		_, _, err := s.ReadMessage()
+		closed <- err
+	}()
+	if _, _, err := c.ReadMessage(); err != ErrInvalidSignature {
+		t.Fatalf("ReadMessage returned %v, want %v", err, ErrInvalidSignature)
+	}
+	go func() { _, _, _ = c.ReadMessage() }() // receive the close reply
+	var ce *CloseError
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != ClosePolicyViolation {
+		t.Fatalf("peer read %v, want close %d", err, ClosePolicyViolation)
+	}
+
+	var nilConn *Conn
+	if err := nilConn.UseSignatures(SignatureOptions{}); err != ErrNilConn {
+		t.Errorf("nil Conn returned %v", err)
+	}
+}
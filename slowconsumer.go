@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+const defaultSlowConsumerDuration = 5 * time.Second
+
+// ErrWriteQueueDisabled is returned by SetSlowConsumerPolicy when the write
+// queue is not enabled on the connection.
+var ErrWriteQueueDisabled = errors.New("websocket: write queue not enabled")
+
+// SlowConsumerAction specifies what is done with a connection whose outbound
+// queue stays above the threshold of its SlowConsumerPolicy.
+type SlowConsumerAction int
+
+const (
+	// SlowConsumerNotify only reports the connection to OnSlowConsumer.
+	SlowConsumerNotify SlowConsumerAction = iota
+
+	// SlowConsumerDrop discards the queued messages.
+	SlowConsumerDrop
+
+	// SlowConsumerWarn discards the queued messages and queues the
+	// LagMessage of the policy, so that the peer knows it missed messages.
+	SlowConsumerWarn
+
+	// SlowConsumerClose sends a close message with the code
+	// CloseTryAgainLater and closes the connection.
+	SlowConsumerClose
+)
+
+func (a SlowConsumerAction) String() string {
+	switch a {
+	case SlowConsumerNotify:
+		return "notify"
+	case SlowConsumerDrop:
+		return "drop"
+	case SlowConsumerWarn:
+		return "warn"
+	case SlowConsumerClose:
+		return "close"
+	}
+	return "SlowConsumerAction(" + strconv.Itoa(int(a)) + ")"
+}
+
+// SlowConsumerPolicy detects the consumers that cannot keep up with the
+// messages written to them. A consumer is slow when the number of messages
+// in its outbound queue stays above Threshold for Duration. The queue length
+// is sampled four times per Duration.
+type SlowConsumerPolicy struct {
+	// Threshold is the number of queued messages above which the consumer
+	// is lagging. If zero, a default of half the queue size is used.
+	Threshold int
+
+	// Duration is how long the queue must stay above Threshold. If zero, a
+	// default of 5 seconds is used.
+	Duration time.Duration
+
+	// Action is taken on the slow consumers.
+	Action SlowConsumerAction
+
+	// LagMessage is the text message queued by SlowConsumerWarn. If nil, the
+	// event {"event":"lagging"} is queued.
+	LagMessage []byte
+
+	// OnSlowConsumer, if not nil, is called with the connection and the
+	// length of its queue before the action is taken.
+	OnSlowConsumer func(c *Conn, queued int, action SlowConsumerAction)
+}
+
+// slowMonitor samples an outbound queue and applies a SlowConsumerPolicy.
+type slowMonitor struct {
+	policy SlowConsumerPolicy
+	conn   *Conn
+	queued func() int
+	drop   func()          // discards the queued messages
+	send   func(p []byte)  // queues a text message
+	close  func()          // closes the slow connection
+	done   <-chan struct{} // closed when the queue stops
+	stop   chan struct{}   // closed to stop monitoring
+}
+
+// newSlowMonitor returns a monitor of a queue of the given size with the
+// defaults of the policy applied.
+func newSlowMonitor(p *SlowConsumerPolicy, c *Conn, size int, done <-chan struct{}) *slowMonitor {
+	m := &slowMonitor{policy: *p, conn: c, done: done, stop: make(chan struct{})}
+	if m.policy.Threshold <= 0 {
+		m.policy.Threshold = size / 2
+	}
+	if m.policy.Duration <= 0 {
+		m.policy.Duration = defaultSlowConsumerDuration
+	}
+	if m.policy.LagMessage == nil {
+		m.policy.LagMessage = []byte(`{"event":"lagging"}`)
+	}
+	return m
+}
+
+func (m *slowMonitor) run() {
+	ticker := time.NewTicker(m.policy.Duration / 4)
+	defer ticker.Stop()
+	var since time.Time // when the queue went above the threshold
+	for {
+		select {
+		case now := <-ticker.C:
+			n := m.queued()
+			if n <= m.policy.Threshold {
+				since = time.Time{}
+				continue
+			}
+			if since.IsZero() {
+				since = now
+			}
+			if now.Sub(since) < m.policy.Duration {
+				continue
+			}
+			since = time.Time{}
+			m.conn.log(slog.LevelWarn, "websocket: slow consumer", "queued", n, "action", m.policy.Action.String())
+			if m.policy.OnSlowConsumer != nil {
+				m.policy.OnSlowConsumer(m.conn, n, m.policy.Action)
+			}
+			switch m.policy.Action {
+			case SlowConsumerDrop:
+				m.drop()
+			case SlowConsumerWarn:
+				m.drop()
+				m.send(m.policy.LagMessage)
+			case SlowConsumerClose:
+				m.close()
+				return
+			}
+		case <-m.done:
+			return
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// SetSlowConsumerPolicy monitors the write queue of the connection with the
+// policy, replacing any previous policy. A nil policy stops the monitoring.
+// The write queue must be enabled with EnableWriteQueue; the threshold
+// defaults to half its size.
+func (c *Conn) SetSlowConsumerPolicy(p *SlowConsumerPolicy) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	q := c.writeQueue
+	if q == nil {
+		return ErrWriteQueueDisabled
+	}
+	q.monitorMu.Lock()
+	defer q.monitorMu.Unlock()
+	if q.monitor != nil {
+		close(q.monitor.stop)
+		q.monitor = nil
+	}
+	if p == nil {
+		return nil
+	}
+	m := newSlowMonitor(p, c, cap(q.ch), q.done)
+	m.queued = q.len
+	m.drop = q.drain
+	m.send = func(p []byte) {
+		_ = q.enqueue(queuedMessage{messageType: TextMessage, data: p})
+	}
+	m.close = func() {
+		_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseTryAgainLater, "slow consumer"), time.Now().Add(writeWait))
+		_ = c.Close()
+	}
+	q.monitor = m
+	go m.run()
+	return nil
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newSlowConn returns a connection with a write queue of size 8 and its
+// peer, which does not read until the test does.
+func newSlowConn(t *testing.T, p *SlowConsumerPolicy) (s, c *Conn) {
+	t.Helper()
+	s, c = newPipeConns()
+	t.Cleanup(func() {
+		s.Close()
+		c.Close()
+	})
+	if err := s.EnableWriteQueue(8, OverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSlowConsumerPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := s.WriteMessage(TextMessage, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, c
+}
+
+func TestSlowConsumerDrop(t *testing.T) {
+	slow := make(chan int, 1)
+	p := &SlowConsumerPolicy{
+		Threshold: 2,
+		Duration:  40 * time.Millisecond,
+		Action:    SlowConsumerDrop,
+		OnSlowConsumer: func(c *Conn, queued int, action SlowConsumerAction) {
+			select {
+			case slow <- queued:
+			default:
+			}
+		},
+	}
+	s, c := newSlowConn(t, p)
+	select {
+	case n := <-slow:
+		if n <= 2 {
+			t.Fatalf("slow consumer reported with %d queued messages", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer not reported")
+	}
+	if got := readString(t, c); got != "0" {
+		t.Fatalf("read %q, want the message being written", got)
+	}
+	if s.WriteQueueLen() != 0 || s.WriteQueueDropped() == 0 {
+		t.Fatalf("queue length %d, dropped %d after drop", s.WriteQueueLen(), s.WriteQueueDropped())
+	}
+
+	// The connection remains usable.
+	if err := s.WriteMessage(TextMessage, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, c); got != "next" {
+		t.Fatalf("read %q, want next", got)
+	}
+}
+
+func TestSlowConsumerWarn(t *testing.T) {
+	p := &SlowConsumerPolicy{Threshold: 2, Duration: 40 * time.Millisecond, Action: SlowConsumerWarn}
+	s, c := newSlowConn(t, p)
+	deadline := time.Now().Add(time.Second)
+	for s.WriteQueueDropped() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("queued messages not dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	readString(t, c)
+	if got := readString(t, c); got != `{"event":"lagging"}` {
+		t.Fatalf("read %q, want the lag message", got)
+	}
+}
+
+func TestSlowConsumerClose(t *testing.T) {
+	slow := make(chan SlowConsumerAction, 1)
+	p := &SlowConsumerPolicy{
+		Threshold: 2,
+		Duration:  40 * time.Millisecond,
+		Action:    SlowConsumerClose,
+		OnSlowConsumer: func(c *Conn, queued int, action SlowConsumerAction) {
+			slow <- action
+		},
+	}
+	s, _ := newSlowConn(t, p)
+	select {
+	case action := <-slow:
+		if action != SlowConsumerClose {
+			t.Fatalf("reported action %d", action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer not reported")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.WriteMessage(TextMessage, []byte("x")) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("slow consumer not closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSlowConsumerPolicyRequiresQueue(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	if err := s.SetSlowConsumerPolicy(&SlowConsumerPolicy{}); err != ErrWriteQueueDisabled {
+		t.Fatalf("SetSlowConsumerPolicy returned %v, want %v", err, ErrWriteQueueDisabled)
+	}
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	evicted := make(chan *Conn, 1)
+	h := &Hub{
+		SendBufferSize: 8,
+		SlowConsumer:   &SlowConsumerPolicy{Threshold: 2, Duration: 40 * time.Millisecond, Action: SlowConsumerClose},
+		OnSlowClient:   func(c *Conn) { evicted <- c },
+	}
+	defer h.Close()
+	s, c := newPipeConns()
+	defer c.Close()
+	if err := h.Join("room", s); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := h.Broadcast("room", TextMessage, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case got := <-evicted:
+		if got != s {
+			t.Fatal("evicted another connection")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow consumer not evicted")
+	}
+	if h.Len("room") != 0 {
+		t.Fatalf("hub has %d connections after eviction", h.Len("room"))
+	}
+}
@@ -0,0 +1,205 @@
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Engine.IO packet types. Each packet is a WebSocket text message starting
+// with the type digit; binary payloads are sent as binary messages.
+const (
+	engineOpen    = '0'
+	engineClose   = '1'
+	enginePing    = '2'
+	enginePong    = '3'
+	engineMessage = '4'
+	engineUpgrade = '5'
+	engineNoop    = '6'
+)
+
+// Socket.IO packet types, carried in Engine.IO message packets.
+const (
+	packetConnect      = 0
+	packetDisconnect   = 1
+	packetEvent        = 2
+	packetAck          = 3
+	packetConnectError = 4
+	packetBinaryEvent  = 5
+	packetBinaryAck    = 6
+)
+
+var errInvalidPacket = errors.New("socketio: invalid packet")
+
+// packet is a decoded Socket.IO packet.
+type packet struct {
+	typ         int
+	namespace   string
+	id          uint64
+	hasID       bool
+	attachments int
+	data        json.RawMessage
+}
+
+// encode returns the text encoding of p without the Engine.IO message
+// prefix.
+func (p *packet) encode() string {
+	var b strings.Builder
+	b.WriteByte(byte('0' + p.typ))
+	if p.typ == packetBinaryEvent || p.typ == packetBinaryAck {
+		b.WriteString(strconv.Itoa(p.attachments))
+		b.WriteByte('-')
+	}
+	if p.namespace != "" && p.namespace != "/" {
+		b.WriteString(p.namespace)
+		b.WriteByte(',')
+	}
+	if p.hasID {
+		b.WriteString(strconv.FormatUint(p.id, 10))
+	}
+	b.Write(p.data)
+	return b.String()
+}
+
+// decodePacket decodes the text encoding of a Socket.IO packet.
+func decodePacket(s string) (*packet, error) {
+	if s == "" || s[0] < '0' || s[0] > '0'+packetBinaryAck {
+		return nil, errInvalidPacket
+	}
+	p := &packet{typ: int(s[0] - '0'), namespace: "/"}
+	s = s[1:]
+
+	if p.typ == packetBinaryEvent || p.typ == packetBinaryAck {
+		i := strings.IndexByte(s, '-')
+		if i <= 0 {
+			return nil, errInvalidPacket
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n < 0 {
+			return nil, errInvalidPacket
+		}
+		p.attachments = n
+		s = s[i+1:]
+	}
+
+	if strings.HasPrefix(s, "/") {
+		i := strings.IndexByte(s, ',')
+		if i < 0 {
+			p.namespace, s = s, ""
+		} else {
+			p.namespace, s = s[:i], s[i+1:]
+		}
+	}
+
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		id, err := strconv.ParseUint(s[:i], 10, 64)
+		if err != nil {
+			return nil, errInvalidPacket
+		}
+		p.id, p.hasID = id, true
+		s = s[i:]
+	}
+
+	if s != "" {
+		if !json.Valid([]byte(s)) {
+			return nil, errInvalidPacket
+		}
+		p.data = json.RawMessage(s)
+	}
+	return p, nil
+}
+
+// placeholder replaces a binary attachment in the JSON data of a packet.
+type placeholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+// deconstruct replaces the []byte values in v, including those nested in
+// []interface{} and map[string]interface{} values, with placeholders and
+// appends them to attachments.
+func deconstruct(v interface{}, attachments *[][]byte) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		*attachments = append(*attachments, v)
+		return placeholder{Placeholder: true, Num: len(*attachments) - 1}
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = deconstruct(e, attachments)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = deconstruct(e, attachments)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// reconstruct replaces the placeholders in the decoded JSON value v with the
+// binary attachments.
+func reconstruct(v interface{}, attachments [][]byte) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		for i, e := range v {
+			r, err := reconstruct(e, attachments)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = r
+		}
+		return v, nil
+	case map[string]interface{}:
+		if ph, ok := v["_placeholder"].(bool); ok && ph {
+			num, ok := v["num"].(float64)
+			if !ok || num < 0 || int(num) >= len(attachments) || float64(int(num)) != num {
+				return nil, errInvalidPacket
+			}
+			return attachments[int(num)], nil
+		}
+		for k, e := range v {
+			r, err := reconstruct(e, attachments)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = r
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// encodeArgs encodes a JSON array of values, extracting the binary
+// attachments.
+func encodeArgs(values []interface{}) (json.RawMessage, [][]byte, error) {
+	var attachments [][]byte
+	v := deconstruct(values, &attachments)
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, attachments, nil
+}
+
+// decodeArgs decodes a JSON array of values, restoring the binary
+// attachments.
+func decodeArgs(data json.RawMessage, attachments [][]byte) ([]interface{}, error) {
+	var values []interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, errInvalidPacket
+	}
+	if _, err := reconstruct(values, attachments); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
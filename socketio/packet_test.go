@@ -0,0 +1,70 @@
+package socketio
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var packetTests = []struct {
+	s string
+	p packet
+}{
+	{`0`, packet{typ: packetConnect, namespace: "/"}},
+	{`0{"token":"x"}`, packet{typ: packetConnect, namespace: "/", data: []byte(`{"token":"x"}`)}},
+	{`0/admin,{"sid":"abc"}`, packet{typ: packetConnect, namespace: "/admin", data: []byte(`{"sid":"abc"}`)}},
+	{`1/admin,`, packet{typ: packetDisconnect, namespace: "/admin"}},
+	{`2["hello",1]`, packet{typ: packetEvent, namespace: "/", data: []byte(`["hello",1]`)}},
+	{`2/admin,12["hello"]`, packet{typ: packetEvent, namespace: "/admin", id: 12, hasID: true, data: []byte(`["hello"]`)}},
+	{`30["ok"]`, packet{typ: packetAck, namespace: "/", id: 0, hasID: true, data: []byte(`["ok"]`)}},
+	{`4{"message":"no"}`, packet{typ: packetConnectError, namespace: "/", data: []byte(`{"message":"no"}`)}},
+	{`52-["file",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`, packet{typ: packetBinaryEvent, namespace: "/", attachments: 2, data: []byte(`["file",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`)}},
+	{`61-/admin,3[{"_placeholder":true,"num":0}]`, packet{typ: packetBinaryAck, namespace: "/admin", attachments: 1, id: 3, hasID: true, data: []byte(`[{"_placeholder":true,"num":0}]`)}},
+}
+
+func TestPacketEncoding(t *testing.T) {
+	for _, tt := range packetTests {
+		p, err := decodePacket(tt.s)
+		if err != nil {
+			t.Errorf("decodePacket(%q) returned %v", tt.s, err)
+			continue
+		}
+		if p.typ != tt.p.typ || p.namespace != tt.p.namespace || p.id != tt.p.id || p.hasID != tt.p.hasID ||
+			p.attachments != tt.p.attachments || !bytes.Equal(p.data, tt.p.data) {
+			t.Errorf("decodePacket(%q) = %+v, want %+v", tt.s, *p, tt.p)
+		}
+		if s := p.encode(); s != tt.s {
+			t.Errorf("encode() = %q, want %q", s, tt.s)
+		}
+	}
+}
+
+func TestDecodePacketInvalid(t *testing.T) {
+	for _, s := range []string{``, `7`, `a`, `5["x"]`, `5x-["x"]`, `2[`, `2/admin,{`} {
+		if _, err := decodePacket(s); err == nil {
+			t.Errorf("decodePacket(%q) returned nil error", s)
+		}
+	}
+}
+
+func TestBinaryArgs(t *testing.T) {
+	args := []interface{}{"file", []byte{1, 2}, map[string]interface{}{"thumb": []byte{3}, "name": "a"}}
+	data, attachments, err := encodeArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 {
+		t.Fatalf("got %d attachments, want 2", len(attachments))
+	}
+	got, err := decodeArgs(data, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, args) {
+		t.Fatalf("decodeArgs() = %#v, want %#v", got, args)
+	}
+
+	if _, err := decodeArgs([]byte(`[{"_placeholder":true,"num":1}]`), [][]byte{{1}}); err == nil {
+		t.Fatal("decodeArgs accepted a placeholder without attachment")
+	}
+}
@@ -0,0 +1,546 @@
+package socketio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gflydev/websocket"
+)
+
+var (
+	// ErrDisconnected is returned when emitting to a socket that is
+	// disconnected.
+	ErrDisconnected = errors.New("socketio: socket disconnected")
+
+	// ErrNoAck is returned by Event.Ack when the client did not request an
+	// acknowledgement or the event was already acknowledged.
+	ErrNoAck = errors.New("socketio: event does not expect an acknowledgement")
+)
+
+// ConnectError rejects a connection to a namespace. The client receives the
+// message and data in its connect_error event.
+type ConnectError struct {
+	Message string
+	Data    interface{}
+}
+
+func (e *ConnectError) Error() string { return e.Message }
+
+// Middleware is called when a client connects to a namespace, with the auth
+// payload sent by the client. Returning an error rejects the connection.
+type Middleware func(s *Socket, auth map[string]interface{}) error
+
+// EventHandler handles an event received from a client.
+type EventHandler func(s *Socket, e *Event)
+
+// Namespace is a communication channel multiplexed over the Engine.IO
+// connections of the server. Handlers must be registered before clients
+// connect.
+type Namespace struct {
+	name string
+
+	mu           sync.RWMutex
+	middleware   []Middleware
+	onConnect    []func(s *Socket)
+	onDisconnect []func(s *Socket, reason string)
+	handlers     map[string]EventHandler
+	onAny        EventHandler
+	sockets      map[string]*Socket
+	rooms        map[string]map[*Socket]struct{}
+}
+
+func newNamespace(name string) *Namespace {
+	return &Namespace{
+		name:     name,
+		handlers: make(map[string]EventHandler),
+		sockets:  make(map[string]*Socket),
+		rooms:    make(map[string]map[*Socket]struct{}),
+	}
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string { return ns.name }
+
+// Use adds a middleware called for each connection before OnConnect.
+func (ns *Namespace) Use(m Middleware) {
+	ns.mu.Lock()
+	ns.middleware = append(ns.middleware, m)
+	ns.mu.Unlock()
+}
+
+// OnConnect registers a function called after a client connected to the
+// namespace.
+func (ns *Namespace) OnConnect(f func(s *Socket)) {
+	ns.mu.Lock()
+	ns.onConnect = append(ns.onConnect, f)
+	ns.mu.Unlock()
+}
+
+// OnDisconnect registers a function called after a client disconnected
+// from the namespace. The reason follows the Socket.IO disconnection
+// reasons, such as "client namespace disconnect" or "ping timeout".
+func (ns *Namespace) OnDisconnect(f func(s *Socket, reason string)) {
+	ns.mu.Lock()
+	ns.onDisconnect = append(ns.onDisconnect, f)
+	ns.mu.Unlock()
+}
+
+// On registers the handler for the named event.
+//
+// Handlers are called sequentially from the read loop of the connection, in
+// the order the events were received. A handler must not wait for the
+// acknowledgement of an event emitted to the same connection; call
+// EmitWithAck from another goroutine.
+func (ns *Namespace) On(event string, h EventHandler) {
+	ns.mu.Lock()
+	ns.handlers[event] = h
+	ns.mu.Unlock()
+}
+
+// OnAny registers the handler for the events without a handler registered
+// with On.
+func (ns *Namespace) OnAny(h EventHandler) {
+	ns.mu.Lock()
+	ns.onAny = h
+	ns.mu.Unlock()
+}
+
+// connect runs the middleware for a new socket.
+func (ns *Namespace) connect(s *Socket, auth map[string]interface{}) error {
+	ns.mu.RLock()
+	middleware := ns.middleware
+	ns.mu.RUnlock()
+	for _, m := range middleware {
+		if err := m(s, auth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connected registers a socket and calls the OnConnect functions.
+func (ns *Namespace) connected(s *Socket) {
+	ns.mu.Lock()
+	ns.sockets[s.id] = s
+	ns.mu.Unlock()
+	s.Join(s.id)
+
+	ns.mu.RLock()
+	onConnect := ns.onConnect
+	ns.mu.RUnlock()
+	for _, f := range onConnect {
+		f(s)
+	}
+}
+
+// disconnected unregisters a socket and calls the OnDisconnect functions.
+func (ns *Namespace) disconnected(s *Socket, reason string) {
+	ns.mu.Lock()
+	if _, ok := ns.sockets[s.id]; !ok {
+		ns.mu.Unlock()
+		return
+	}
+	delete(ns.sockets, s.id)
+	for room := range s.rooms {
+		ns.leave(room, s)
+	}
+	onDisconnect := ns.onDisconnect
+	ns.mu.Unlock()
+	for _, f := range onDisconnect {
+		f(s, reason)
+	}
+}
+
+func (ns *Namespace) handle(s *Socket, e *Event) {
+	ns.mu.RLock()
+	h, ok := ns.handlers[e.Name]
+	if !ok {
+		h = ns.onAny
+	}
+	ns.mu.RUnlock()
+	if h != nil {
+		h(s, e)
+	}
+}
+
+// leave removes s from room. The namespace lock must be held.
+func (ns *Namespace) leave(room string, s *Socket) {
+	delete(s.rooms, room)
+	if members := ns.rooms[room]; members != nil {
+		delete(members, s)
+		if len(members) == 0 {
+			delete(ns.rooms, room)
+		}
+	}
+}
+
+// Sockets returns the sockets connected to the namespace.
+func (ns *Namespace) Sockets() []*Socket {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	sockets := make([]*Socket, 0, len(ns.sockets))
+	for _, s := range ns.sockets {
+		sockets = append(sockets, s)
+	}
+	return sockets
+}
+
+// Rooms returns the names of the rooms with at least one member in sorted
+// order. Each socket is a member of the room named after its ID.
+func (ns *Namespace) Rooms() []string {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	rooms := make([]string, 0, len(ns.rooms))
+	for room := range ns.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Emit sends an event to every socket connected to the namespace.
+func (ns *Namespace) Emit(event string, args ...interface{}) error {
+	return ns.To().Emit(event, args...)
+}
+
+// To returns a broadcast to the members of the given rooms. With no rooms,
+// the broadcast targets every socket of the namespace.
+func (ns *Namespace) To(rooms ...string) *Broadcast {
+	return &Broadcast{ns: ns, rooms: rooms}
+}
+
+// Broadcast is a set of sockets events are emitted to.
+type Broadcast struct {
+	ns     *Namespace
+	rooms  []string
+	except []string
+}
+
+// To adds the members of the rooms to the broadcast.
+func (b *Broadcast) To(rooms ...string) *Broadcast {
+	return &Broadcast{ns: b.ns, rooms: append(append([]string(nil), b.rooms...), rooms...), except: b.except}
+}
+
+// Except excludes the members of the rooms from the broadcast.
+func (b *Broadcast) Except(rooms ...string) *Broadcast {
+	return &Broadcast{ns: b.ns, rooms: b.rooms, except: append(append([]string(nil), b.except...), rooms...)}
+}
+
+// sockets returns the targets of the broadcast.
+func (b *Broadcast) sockets() []*Socket {
+	ns := b.ns
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	excluded := func(s *Socket) bool {
+		for _, room := range b.except {
+			if _, ok := s.rooms[room]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	var sockets []*Socket
+	if len(b.rooms) == 0 {
+		for _, s := range ns.sockets {
+			if !excluded(s) {
+				sockets = append(sockets, s)
+			}
+		}
+		return sockets
+	}
+	seen := make(map[*Socket]struct{})
+	for _, room := range b.rooms {
+		for s := range ns.rooms[room] {
+			if _, ok := seen[s]; ok || excluded(s) {
+				continue
+			}
+			seen[s] = struct{}{}
+			sockets = append(sockets, s)
+		}
+	}
+	return sockets
+}
+
+// Emit sends an event to the sockets of the broadcast. The event is encoded
+// once. Emit returns the first error writing to a socket after trying all
+// sockets.
+func (b *Broadcast) Emit(event string, args ...interface{}) error {
+	p, attachments, err := eventPacket(b.ns.name, event, args)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, s := range b.sockets() {
+		if err := s.sess.writePacket(p, attachments); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// eventPacket encodes an event.
+func eventPacket(namespace, event string, args []interface{}) (*packet, [][]byte, error) {
+	data, attachments, err := encodeArgs(append([]interface{}{event}, args...))
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &packet{typ: packetEvent, namespace: namespace, data: data}
+	if len(attachments) > 0 {
+		p.typ = packetBinaryEvent
+		p.attachments = len(attachments)
+	}
+	return p, attachments, nil
+}
+
+// Socket is the connection of a client to a namespace.
+type Socket struct {
+	id   string
+	sess *session
+	ns   *Namespace
+
+	rooms map[string]struct{} // guarded by ns.mu
+
+	ackMu   sync.Mutex
+	nextAck uint64
+	acks    map[uint64]chan []interface{}
+	closed  bool
+
+	dataMu sync.RWMutex
+	data   map[string]interface{}
+}
+
+func newSocket(sess *session, ns *Namespace) *Socket {
+	return &Socket{
+		id:    newID(),
+		sess:  sess,
+		ns:    ns,
+		rooms: make(map[string]struct{}),
+		acks:  make(map[uint64]chan []interface{}),
+	}
+}
+
+// ID returns the ID of the socket, unique to its namespace.
+func (s *Socket) ID() string { return s.id }
+
+// Namespace returns the namespace of the socket.
+func (s *Socket) Namespace() *Namespace { return s.ns }
+
+// Request returns the HTTP request of the Engine.IO handshake.
+func (s *Socket) Request() *http.Request { return s.sess.request }
+
+// Conn returns the WebSocket connection carrying the socket. The connection
+// is shared by the sockets of the client in all namespaces; the application
+// must not read from or write data messages to it.
+func (s *Socket) Conn() *websocket.Conn { return s.sess.conn }
+
+// Set stores a value under the key in the socket's data.
+func (s *Socket) Set(key string, value interface{}) {
+	s.dataMu.Lock()
+	if s.data == nil {
+		s.data = make(map[string]interface{})
+	}
+	s.data[key] = value
+	s.dataMu.Unlock()
+}
+
+// Get returns the value stored under the key and whether the key is present.
+func (s *Socket) Get(key string) (interface{}, bool) {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Join adds the socket to the named room of its namespace.
+func (s *Socket) Join(room string) {
+	ns := s.ns
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if _, ok := ns.sockets[s.id]; !ok {
+		return
+	}
+	members := ns.rooms[room]
+	if members == nil {
+		members = make(map[*Socket]struct{})
+		ns.rooms[room] = members
+	}
+	members[s] = struct{}{}
+	s.rooms[room] = struct{}{}
+}
+
+// Leave removes the socket from the named room.
+func (s *Socket) Leave(room string) {
+	s.ns.mu.Lock()
+	s.ns.leave(room, s)
+	s.ns.mu.Unlock()
+}
+
+// Rooms returns the rooms of the socket in sorted order.
+func (s *Socket) Rooms() []string {
+	s.ns.mu.RLock()
+	defer s.ns.mu.RUnlock()
+	rooms := make([]string, 0, len(s.rooms))
+	for room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Broadcast returns a broadcast to the other sockets of the namespace. Use
+// To and Except on the result to select rooms.
+func (s *Socket) Broadcast() *Broadcast {
+	return s.ns.To().Except(s.id)
+}
+
+// Emit sends an event to the client.
+func (s *Socket) Emit(event string, args ...interface{}) error {
+	if s.isClosed() {
+		return ErrDisconnected
+	}
+	p, attachments, err := eventPacket(s.ns.name, event, args)
+	if err != nil {
+		return err
+	}
+	return s.sess.writePacket(p, attachments)
+}
+
+// EmitWithAck sends an event to the client and waits for the client to
+// acknowledge it. EmitWithAck returns the arguments of the acknowledgement,
+// ctx.Err() if ctx is done first or ErrDisconnected if the socket is
+// disconnected first.
+func (s *Socket) EmitWithAck(ctx context.Context, event string, args ...interface{}) ([]interface{}, error) {
+	p, attachments, err := eventPacket(s.ns.name, event, args)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []interface{}, 1)
+	s.ackMu.Lock()
+	if s.closed {
+		s.ackMu.Unlock()
+		return nil, ErrDisconnected
+	}
+	id := s.nextAck
+	s.nextAck++
+	s.acks[id] = ch
+	s.ackMu.Unlock()
+	defer func() {
+		s.ackMu.Lock()
+		delete(s.acks, id)
+		s.ackMu.Unlock()
+	}()
+
+	p.id, p.hasID = id, true
+	if err := s.sess.writePacket(p, attachments); err != nil {
+		return nil, err
+	}
+	select {
+	case args, ok := <-ch:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		return args, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// receiveAck delivers an acknowledgement received from the client.
+func (s *Socket) receiveAck(id uint64, args []interface{}) {
+	s.ackMu.Lock()
+	ch, ok := s.acks[id]
+	delete(s.acks, id)
+	s.ackMu.Unlock()
+	if ok {
+		ch <- args
+	}
+}
+
+func (s *Socket) isClosed() bool {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+	return s.closed
+}
+
+// Disconnect disconnects the client from the namespace. The Engine.IO
+// connection stays open for the other namespaces of the client.
+func (s *Socket) Disconnect() error {
+	if s.isClosed() {
+		return nil
+	}
+	err := s.sess.writePacket(&packet{typ: packetDisconnect, namespace: s.ns.name}, nil)
+	s.remove("server namespace disconnect")
+	return err
+}
+
+// remove unregisters the socket from its session and namespace.
+func (s *Socket) remove(reason string) {
+	s.ackMu.Lock()
+	if s.closed {
+		s.ackMu.Unlock()
+		return
+	}
+	s.closed = true
+	for id, ch := range s.acks {
+		close(ch)
+		delete(s.acks, id)
+	}
+	s.ackMu.Unlock()
+
+	s.sess.mu.Lock()
+	if s.sess.sockets[s.ns.name] == s {
+		delete(s.sess.sockets, s.ns.name)
+	}
+	s.sess.mu.Unlock()
+	s.ns.disconnected(s, reason)
+}
+
+// Event is an event received from a client.
+type Event struct {
+	// Name is the name of the event.
+	Name string
+
+	// Args are the arguments of the event decoded from JSON. Binary
+	// attachments are []byte values.
+	Args []interface{}
+
+	socket *Socket
+	id     uint64
+	hasID  bool
+	mu     sync.Mutex
+	acked  bool
+}
+
+// WantsAck reports whether the client waits for an acknowledgement of the
+// event.
+func (e *Event) WantsAck() bool { return e.hasID }
+
+// Ack sends the acknowledgement of the event with the given arguments. Ack
+// may be called once, from any goroutine. It returns ErrNoAck if the client
+// did not request an acknowledgement.
+func (e *Event) Ack(args ...interface{}) error {
+	e.mu.Lock()
+	if !e.hasID || e.acked {
+		e.mu.Unlock()
+		return ErrNoAck
+	}
+	e.acked = true
+	e.mu.Unlock()
+
+	if args == nil {
+		args = []interface{}{}
+	}
+	data, attachments, err := encodeArgs(args)
+	if err != nil {
+		return err
+	}
+	p := &packet{typ: packetAck, namespace: e.socket.ns.name, id: e.id, hasID: true, data: data}
+	if len(attachments) > 0 {
+		p.typ = packetBinaryAck
+		p.attachments = len(attachments)
+	}
+	return e.socket.sess.writePacket(p, attachments)
+}
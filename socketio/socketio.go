@@ -0,0 +1,453 @@
+// Package socketio implements the Socket.IO protocol (revision 5) over the
+// Engine.IO protocol (revision 4) on top of websocket.Conn, so that Socket.IO
+// clients can connect to a Go server without switching client libraries.
+//
+// Only the WebSocket transport is supported. Configure the JavaScript client
+// to skip HTTP long-polling:
+//
+//	const socket = io("https://example.com", { transports: ["websocket"] });
+//
+// Mount a Server on the Socket.IO path and register event handlers on its
+// namespaces:
+//
+//	var srv socketio.Server
+//	chat := srv.Of("/")
+//	chat.Use(func(s *socketio.Socket, auth map[string]interface{}) error {
+//		if auth["token"] != "secret" {
+//			return &socketio.ConnectError{Message: "not authorized"}
+//		}
+//		return nil
+//	})
+//	chat.OnConnect(func(s *socketio.Socket) {
+//		s.Join("lobby")
+//	})
+//	chat.On("chat", func(s *socketio.Socket, e *socketio.Event) {
+//		chat.To("lobby").Emit("chat", e.Args...)
+//		e.Ack("ok")
+//	})
+//	http.Handle("/socket.io/", &srv)
+//
+// Event arguments are decoded from JSON into interface{} values. Binary
+// attachments are received as []byte values. Arguments of type []byte,
+// including those nested in []interface{} and map[string]interface{}
+// values, are sent as binary attachments.
+package socketio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+const (
+	defaultPingInterval = 25 * time.Second
+	defaultPingTimeout  = 20 * time.Second
+	defaultMaxPayload   = 1000000
+	writeWait           = 10 * time.Second
+)
+
+// Server serves Socket.IO clients. The zero value is ready to use.
+type Server struct {
+	// Upgrader upgrades the Engine.IO requests to WebSocket connections.
+	Upgrader websocket.Upgrader
+
+	// PingInterval specifies how often the server pings the client. If
+	// zero, a default of 25 seconds is used.
+	PingInterval time.Duration
+
+	// PingTimeout specifies how long the server waits for the client to
+	// answer a ping before closing the connection. If zero, a default of
+	// 20 seconds is used.
+	PingTimeout time.Duration
+
+	// MaxPayload is the maximum size in bytes of a message received from
+	// the client. If zero, a default of 1000000 is used.
+	MaxPayload int64
+
+	mu         sync.Mutex
+	namespaces map[string]*Namespace
+}
+
+// Of returns the namespace with the given name, creating it if needed.
+// Namespace names start with a slash; the main namespace is "/".
+func (s *Server) Of(name string) *Namespace {
+	if name == "" {
+		name = "/"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.namespaces == nil {
+		s.namespaces = make(map[string]*Namespace)
+	}
+	ns, ok := s.namespaces[name]
+	if !ok {
+		ns = newNamespace(name)
+		s.namespaces[name] = ns
+	}
+	return ns
+}
+
+func (s *Server) namespace(name string) *Namespace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.namespaces[name]
+}
+
+// engineError is the body of an Engine.IO handshake error response.
+type engineError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeEngineError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(engineError{Code: code, Message: message})
+}
+
+// openPacket is the payload of the Engine.IO open packet.
+type openPacket struct {
+	SID          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int64    `json:"pingInterval"`
+	PingTimeout  int64    `json:"pingTimeout"`
+	MaxPayload   int64    `json:"maxPayload"`
+}
+
+// ServeHTTP upgrades an Engine.IO WebSocket request and serves the Socket.IO
+// connection until it closes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("EIO") != "4" {
+		writeEngineError(w, 5, "Unsupported protocol version")
+		return
+	}
+	if q.Get("transport") != "websocket" {
+		writeEngineError(w, 0, "Transport unknown")
+		return
+	}
+	if q.Get("sid") != "" {
+		// Upgrades of polling sessions are not supported.
+		writeEngineError(w, 1, "Session ID unknown")
+		return
+	}
+
+	c, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	sess := newSession(s, c, r)
+	sess.serve()
+}
+
+func (s *Server) durations() (interval, timeout time.Duration) {
+	interval, timeout = s.PingInterval, s.PingTimeout
+	if interval <= 0 {
+		interval = defaultPingInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+	return interval, timeout
+}
+
+// newID returns a random session or socket ID.
+func newID() string {
+	var p [15]byte
+	_, _ = rand.Read(p[:])
+	return base64.RawURLEncoding.EncodeToString(p[:])
+}
+
+// session is an Engine.IO connection. A session carries a socket for each
+// namespace the client connected to.
+type session struct {
+	server  *Server
+	conn    *websocket.Conn
+	request *http.Request
+	sid     string
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	sockets map[string]*Socket
+	closed  bool
+	done    chan struct{}
+
+	// The binary packet waiting for its attachments, accessed by the read
+	// loop only.
+	pending     *packet
+	attachments [][]byte
+}
+
+func newSession(s *Server, c *websocket.Conn, r *http.Request) *session {
+	return &session{
+		server:  s,
+		conn:    c,
+		request: r,
+		sid:     newID(),
+		sockets: make(map[string]*Socket),
+		done:    make(chan struct{}),
+	}
+}
+
+// write writes an Engine.IO packet followed by binary attachments.
+func (sess *session) write(text string, attachments [][]byte) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	_ = sess.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := sess.conn.WriteMessage(websocket.TextMessage, []byte(text)); err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if err := sess.conn.WriteMessage(websocket.BinaryMessage, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePacket writes a Socket.IO packet and its attachments.
+func (sess *session) writePacket(p *packet, attachments [][]byte) error {
+	return sess.write(string(rune(engineMessage))+p.encode(), attachments)
+}
+
+func (sess *session) serve() {
+	interval, timeout := sess.server.durations()
+	maxPayload := sess.server.MaxPayload
+	if maxPayload <= 0 {
+		maxPayload = defaultMaxPayload
+	}
+	sess.conn.SetReadLimit(maxPayload)
+
+	open, _ := json.Marshal(openPacket{
+		SID:          sess.sid,
+		Upgrades:     []string{},
+		PingInterval: interval.Milliseconds(),
+		PingTimeout:  timeout.Milliseconds(),
+		MaxPayload:   maxPayload,
+	})
+	if err := sess.write(string(rune(engineOpen))+string(open), nil); err != nil {
+		_ = sess.conn.Close()
+		return
+	}
+
+	go sess.ping(interval)
+	sess.close(sess.readLoop(interval + timeout))
+}
+
+// readLoop handles the packets received from the client until the
+// connection fails, and returns the reason of the disconnection. The client
+// must send a packet, usually a pong, within the wait time.
+func (sess *session) readLoop(wait time.Duration) string {
+	for {
+		_ = sess.conn.SetReadDeadline(time.Now().Add(wait))
+		mt, p, err := sess.conn.ReadMessage()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return "ping timeout"
+			}
+			return "transport close"
+		}
+		if mt == websocket.BinaryMessage {
+			if err := sess.receiveAttachment(p); err != nil {
+				return "parse error"
+			}
+			continue
+		}
+		if len(p) == 0 {
+			return "parse error"
+		}
+		switch p[0] {
+		case enginePong, engineNoop, engineUpgrade:
+		case enginePing:
+			_ = sess.write(string(rune(enginePong))+string(p[1:]), nil)
+		case engineClose:
+			return "transport close"
+		case engineMessage:
+			if err := sess.receive(string(p[1:])); err != nil {
+				return "parse error"
+			}
+		default:
+			return "parse error"
+		}
+	}
+}
+
+// ping sends Engine.IO pings until the session is closed.
+func (sess *session) ping(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-sess.done:
+			return
+		case <-t.C:
+			if err := sess.write(string(rune(enginePing)), nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// receive handles a Socket.IO packet.
+func (sess *session) receive(s string) error {
+	if sess.pending != nil {
+		return errInvalidPacket
+	}
+	p, err := decodePacket(s)
+	if err != nil {
+		return err
+	}
+	if p.attachments > 0 {
+		sess.pending = p
+		sess.attachments = make([][]byte, 0, p.attachments)
+		return nil
+	}
+	return sess.dispatch(p, nil)
+}
+
+// receiveAttachment adds a binary attachment to the pending packet.
+func (sess *session) receiveAttachment(data []byte) error {
+	p := sess.pending
+	if p == nil {
+		return errInvalidPacket
+	}
+	sess.attachments = append(sess.attachments, data)
+	if len(sess.attachments) < p.attachments {
+		return nil
+	}
+	attachments := sess.attachments
+	sess.pending, sess.attachments = nil, nil
+	return sess.dispatch(p, attachments)
+}
+
+func (sess *session) dispatch(p *packet, attachments [][]byte) error {
+	switch p.typ {
+	case packetConnect:
+		sess.connect(p)
+		return nil
+	case packetConnectError:
+		return errInvalidPacket
+	}
+
+	sess.mu.Lock()
+	sock := sess.sockets[p.namespace]
+	sess.mu.Unlock()
+	if sock == nil {
+		// Packets for namespaces the client is not connected to are
+		// ignored.
+		return nil
+	}
+
+	switch p.typ {
+	case packetDisconnect:
+		sock.remove("client namespace disconnect")
+	case packetEvent, packetBinaryEvent:
+		args, err := decodeArgs(p.data, attachments)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return errInvalidPacket
+		}
+		name, ok := args[0].(string)
+		if !ok {
+			return errInvalidPacket
+		}
+		e := &Event{Name: name, Args: args[1:], socket: sock, id: p.id, hasID: p.hasID}
+		sock.ns.handle(sock, e)
+	case packetAck, packetBinaryAck:
+		args, err := decodeArgs(p.data, attachments)
+		if err != nil {
+			return err
+		}
+		if p.hasID {
+			sock.receiveAck(p.id, args)
+		}
+	}
+	return nil
+}
+
+// connectError is the payload of a CONNECT_ERROR packet.
+type connectError struct {
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// connect handles a CONNECT packet.
+func (sess *session) connect(p *packet) {
+	fail := func(message string, data interface{}) {
+		payload, _ := json.Marshal(connectError{Message: message, Data: data})
+		_ = sess.writePacket(&packet{typ: packetConnectError, namespace: p.namespace, data: payload}, nil)
+	}
+
+	ns := sess.server.namespace(p.namespace)
+	if ns == nil {
+		fail("Invalid namespace", nil)
+		return
+	}
+	var auth map[string]interface{}
+	if len(p.data) > 0 {
+		if err := json.Unmarshal(p.data, &auth); err != nil {
+			fail("Invalid auth payload", nil)
+			return
+		}
+	}
+
+	sess.mu.Lock()
+	if sess.closed || sess.sockets[p.namespace] != nil {
+		sess.mu.Unlock()
+		return
+	}
+	sock := newSocket(sess, ns)
+	sess.sockets[p.namespace] = sock
+	sess.mu.Unlock()
+
+	if err := ns.connect(sock, auth); err != nil {
+		sess.mu.Lock()
+		delete(sess.sockets, p.namespace)
+		sess.mu.Unlock()
+		var data interface{}
+		var ce *ConnectError
+		if errors.As(err, &ce) {
+			data = ce.Data
+		}
+		fail(err.Error(), data)
+		return
+	}
+
+	payload, _ := json.Marshal(struct {
+		SID string `json:"sid"`
+	}{sock.id})
+	_ = sess.writePacket(&packet{typ: packetConnect, namespace: p.namespace, data: payload}, nil)
+	ns.connected(sock)
+}
+
+// close disconnects all sockets and closes the connection.
+func (sess *session) close(reason string) {
+	sess.mu.Lock()
+	if sess.closed {
+		sess.mu.Unlock()
+		return
+	}
+	sess.closed = true
+	close(sess.done)
+	sockets := make([]*Socket, 0, len(sess.sockets))
+	for _, sock := range sess.sockets {
+		sockets = append(sockets, sock)
+	}
+	sess.mu.Unlock()
+
+	for _, sock := range sockets {
+		sock.remove(reason)
+	}
+	_ = sess.conn.Close()
+}
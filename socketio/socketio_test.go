@@ -0,0 +1,313 @@
+package socketio
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// client speaks the Engine.IO and Socket.IO protocols like the JavaScript
+// client with the websocket transport.
+type client struct {
+	t *testing.T
+	c *websocket.Conn
+}
+
+func dial(t *testing.T, srv *Server, query string) *client {
+	t.Helper()
+	s := httptest.NewServer(srv)
+	t.Cleanup(s.Close)
+	u := "ws" + strings.TrimPrefix(s.URL, "http") + "/socket.io/?EIO=4&transport=websocket" + query
+	c, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	cl := &client{t: t, c: c}
+	if open := cl.read(); !strings.HasPrefix(open, `0{"sid":`) {
+		t.Fatalf("open packet %q", open)
+	}
+	return cl
+}
+
+func (cl *client) read() string {
+	cl.t.Helper()
+	_ = cl.c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	mt, p, err := cl.c.ReadMessage()
+	if err != nil {
+		cl.t.Fatalf("ReadMessage: %v", err)
+	}
+	if mt != websocket.TextMessage {
+		cl.t.Fatalf("got binary message %v, want text", p)
+	}
+	return string(p)
+}
+
+func (cl *client) readBinary() []byte {
+	cl.t.Helper()
+	_ = cl.c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	mt, p, err := cl.c.ReadMessage()
+	if err != nil {
+		cl.t.Fatalf("ReadMessage: %v", err)
+	}
+	if mt != websocket.BinaryMessage {
+		cl.t.Fatalf("got text message %q, want binary", p)
+	}
+	return p
+}
+
+func (cl *client) write(s string) {
+	cl.t.Helper()
+	if err := cl.c.WriteMessage(websocket.TextMessage, []byte(s)); err != nil {
+		cl.t.Fatal(err)
+	}
+}
+
+func (cl *client) writeBinary(p []byte) {
+	cl.t.Helper()
+	if err := cl.c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		cl.t.Fatal(err)
+	}
+}
+
+func (cl *client) expect(want string) {
+	cl.t.Helper()
+	if got := cl.read(); got != want {
+		cl.t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// connect connects to the namespace and returns the socket ID.
+func (cl *client) connect(prefix string) string {
+	cl.t.Helper()
+	cl.write("40" + prefix)
+	p := cl.read()
+	if !strings.HasPrefix(p, "40"+prefix+`{"sid":`) {
+		cl.t.Fatalf("connect response %q", p)
+	}
+	var v struct{ SID string }
+	if err := json.Unmarshal([]byte(p[len("40"+prefix):]), &v); err != nil {
+		cl.t.Fatal(err)
+	}
+	return v.SID
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	var srv Server
+	s := httptest.NewServer(&srv)
+	defer s.Close()
+	for query, want := range map[string]string{
+		"EIO=3&transport=websocket": `{"code":5,"message":"Unsupported protocol version"}`,
+		"EIO=4&transport=polling":   `{"code":0,"message":"Transport unknown"}`,
+	} {
+		resp, err := http.Get(s.URL + "/socket.io/?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || strings.TrimSpace(string(b)) != want {
+			t.Errorf("%s: got %d %s, want 400 %s", query, resp.StatusCode, b, want)
+		}
+	}
+}
+
+func TestEventsAndAcks(t *testing.T) {
+	var srv Server
+	connected := make(chan *Socket, 1)
+	srv.Of("/").OnConnect(func(s *Socket) { connected <- s })
+	srv.Of("/").On("echo", func(s *Socket, e *Event) {
+		if e.WantsAck() {
+			_ = e.Ack(e.Args...)
+		} else {
+			_ = s.Emit("echo", e.Args...)
+		}
+	})
+
+	cl := dial(t, &srv, "")
+	sid := cl.connect("")
+	s := <-connected
+	if s.ID() != sid {
+		t.Fatalf("socket ID %q, client got %q", s.ID(), sid)
+	}
+
+	cl.write(`42["echo","hi",{"n":1}]`)
+	cl.expect(`42["echo","hi",{"n":1}]`)
+	cl.write(`427["echo","hi"]`)
+	cl.expect(`437["hi"]`)
+
+	// Server to client acknowledgement.
+	done := make(chan []interface{}, 1)
+	go func() {
+		args, err := s.EmitWithAck(context.Background(), "question", "?")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- args
+	}()
+	cl.expect(`420["question","?"]`)
+	cl.write(`430["yes",42]`)
+	if args := <-done; len(args) != 2 || args[0] != "yes" || args[1] != 42.0 {
+		t.Fatalf("EmitWithAck returned %v", args)
+	}
+}
+
+func TestBinaryAttachments(t *testing.T) {
+	var srv Server
+	srv.Of("/").On("upload", func(s *Socket, e *Event) {
+		_ = e.Ack(e.Args[0], len(e.Args[0].([]byte)))
+	})
+	cl := dial(t, &srv, "")
+	cl.connect("")
+
+	cl.write(`451-1["upload",{"_placeholder":true,"num":0}]`)
+	cl.writeBinary([]byte{1, 2, 3})
+	cl.expect(`461-1[{"_placeholder":true,"num":0},3]`)
+	if p := cl.readBinary(); string(p) != "\x01\x02\x03" {
+		t.Fatalf("attachment %v", p)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	var srv Server
+	admin := srv.Of("/admin")
+	admin.Use(func(s *Socket, auth map[string]interface{}) error {
+		if auth["token"] != "secret" {
+			return &ConnectError{Message: "not authorized", Data: map[string]interface{}{"code": 1}}
+		}
+		return nil
+	})
+	disconnected := make(chan string, 1)
+	admin.OnDisconnect(func(s *Socket, reason string) { disconnected <- reason })
+	admin.On("ping", func(s *Socket, e *Event) { _ = s.Emit("pong") })
+	srv.Of("/")
+
+	cl := dial(t, &srv, "")
+	cl.write(`40/nope,`)
+	cl.expect(`44/nope,{"message":"Invalid namespace"}`)
+	cl.write(`40/admin,{"token":"wrong"}`)
+	cl.expect(`44/admin,{"message":"not authorized","data":{"code":1}}`)
+
+	cl.write(`40/admin,{"token":"secret"}`)
+	if p := cl.read(); !strings.HasPrefix(p, `40/admin,{"sid":`) {
+		t.Fatalf("connect response %q", p)
+	}
+	cl.connect("")
+
+	// Events for the main namespace do not reach /admin handlers.
+	cl.write(`42["ping"]`)
+	cl.write(`42/admin,["ping"]`)
+	cl.expect(`42/admin,["pong"]`)
+
+	cl.write(`41/admin,`)
+	if reason := <-disconnected; reason != "client namespace disconnect" {
+		t.Fatalf("disconnect reason %q", reason)
+	}
+	if n := len(admin.Sockets()); n != 0 {
+		t.Fatalf("%d sockets after disconnect", n)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	var srv Server
+	ns := srv.Of("/")
+	sockets := make(chan *Socket, 3)
+	ns.OnConnect(func(s *Socket) {
+		s.Join("lobby")
+		sockets <- s
+	})
+	ns.On("say", func(s *Socket, e *Event) {
+		_ = s.Broadcast().To("lobby").Emit("said", e.Args...)
+	})
+
+	c1 := dial(t, &srv, "")
+	c1.connect("")
+	<-sockets
+	c2 := dial(t, &srv, "")
+	c2.connect("")
+	s2 := <-sockets
+	c3 := dial(t, &srv, "")
+	c3.connect("")
+	s3 := <-sockets
+	s3.Leave("lobby")
+
+	c1.write(`42["say","hello"]`)
+	c2.expect(`42["said","hello"]`)
+
+	if err := ns.To("lobby").Except(s2.ID()).Emit("news", []byte{9}); err != nil {
+		t.Fatal(err)
+	}
+	c1.expect(`451-["news",{"_placeholder":true,"num":0}]`)
+	if p := c1.readBinary(); len(p) != 1 || p[0] != 9 {
+		t.Fatalf("attachment %v", p)
+	}
+
+	if err := ns.Emit("all"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*client{c1, c2, c3} {
+		c.expect(`42["all"]`)
+	}
+	if got := s3.Rooms(); len(got) != 1 || got[0] != s3.ID() {
+		t.Fatalf("Rooms() = %v", got)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	srv := Server{PingInterval: 20 * time.Millisecond, PingTimeout: 20 * time.Millisecond}
+	disconnected := make(chan string, 1)
+	srv.Of("/").OnDisconnect(func(s *Socket, reason string) { disconnected <- reason })
+	cl := dial(t, &srv, "")
+	cl.write("40")
+	for {
+		p := cl.read()
+		if p == "2" {
+			cl.write("3")
+			break
+		}
+	}
+	// Stop answering pings.
+	select {
+	case reason := <-disconnected:
+		if reason != "ping timeout" {
+			t.Fatalf("disconnect reason %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no disconnect after missed pongs")
+	}
+}
+
+func TestServerDisconnect(t *testing.T) {
+	var srv Server
+	sockets := make(chan *Socket, 1)
+	srv.Of("/").OnConnect(func(s *Socket) { sockets <- s })
+	cl := dial(t, &srv, "")
+	cl.connect("")
+	s := <-sockets
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s.EmitWithAck(ctx, "q")
+		errc <- err
+	}()
+	cl.expect(`420["q"]`)
+	if err := s.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	cl.expect(`41`)
+	if err := <-errc; err != ErrDisconnected {
+		t.Fatalf("EmitWithAck returned %v, want %v", err, ErrDisconnected)
+	}
+	if err := s.Emit("x"); err != ErrDisconnected {
+		t.Fatalf("Emit returned %v, want %v", err, ErrDisconnected)
+	}
+}
@@ -0,0 +1,281 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolTTL         = 24 * time.Hour
+	defaultSpoolMaxMessages = 1000
+)
+
+// ErrSpoolMessageTooLarge is returned by Spool.Send when a message for an
+// offline user is larger than Spool.MaxMessageSize.
+var ErrSpoolMessageTooLarge = errors.New("websocket: message too large to spool")
+
+// SpooledMessage is a message kept by a MessageStore for an offline user.
+type SpooledMessage struct {
+	// Seq orders the messages of a user. It is assigned by the store.
+	Seq uint64
+
+	Type      int
+	Data      []byte
+	ExpiresAt time.Time
+}
+
+// MessageStore keeps the messages of offline users for a Spool.
+// MemoryStore is the implementation provided by this package; the boltstore
+// and sqlitestore packages persist the messages in files.
+//
+// A store must be safe to use concurrently.
+type MessageStore interface {
+	// Push appends the message to the messages of the user, assigning it a
+	// sequence number greater than those of the messages of the user. If max
+	// is positive, Push removes the oldest messages of the user beyond max.
+	Push(ctx context.Context, user string, m SpooledMessage, max int) error
+
+	// Fetch returns the messages of the user not expired at now, in order.
+	Fetch(ctx context.Context, user string, now time.Time) ([]SpooledMessage, error)
+
+	// Remove removes the messages of the user up to the sequence number.
+	Remove(ctx context.Context, user string, seq uint64) error
+
+	// Purge removes the messages of all users expired at now.
+	Purge(ctx context.Context, now time.Time) error
+}
+
+// Spool delivers the messages addressed to identified users, such as
+// notifications, to their connections, and keeps the messages for the users
+// without a connection until they connect again, within a lifetime and a
+// maximum number of messages per user.
+//
+// The connections of a user are looked up in Registry under the user key,
+// which Connect indexes:
+//
+//	spool := &websocket.Spool{Registry: registry, Store: boltstore.New(db)}
+//
+//	// When a user connects:
+//	spool.Connect(ctx, "user:42", c)
+//
+//	// From any goroutine:
+//	spool.Send(ctx, "user:42", websocket.TextMessage, notification)
+//
+// The spool writes to the connections from the goroutines calling Send;
+// use Conn.EnableWriteQueue to make the writes safe.
+//
+// It is safe to call Spool's methods concurrently.
+type Spool struct {
+	// Registry indexes the connections of the users. Registry must not be
+	// nil.
+	Registry *Registry
+
+	// Store keeps the messages of offline users. If nil, a MemoryStore is
+	// used.
+	Store MessageStore
+
+	// TTL is how long a message is kept for an offline user. If zero, a
+	// default of 24 hours is used.
+	TTL time.Duration
+
+	// MaxMessages is the maximum number of messages kept per user. The
+	// oldest messages are dropped first. If zero, a default of 1000 is used.
+	MaxMessages int
+
+	// MaxMessageSize, if positive, is the maximum size of a message kept for
+	// an offline user.
+	MaxMessageSize int
+
+	once  sync.Once
+	store MessageStore
+	locks [64]sync.Mutex // serialize Send and Connect per user
+}
+
+var spoolSeed = maphash.MakeSeed()
+
+func (s *Spool) messageStore() MessageStore {
+	s.once.Do(func() {
+		s.store = s.Store
+		if s.store == nil {
+			s.store = &MemoryStore{}
+		}
+	})
+	return s.store
+}
+
+func (s *Spool) lock(user string) *sync.Mutex {
+	return &s.locks[maphash.String(spoolSeed, user)%uint64(len(s.locks))]
+}
+
+// Send writes the message to the connections of the user, or keeps it for
+// the next connection of the user if the user has no connection or the
+// writes to all its connections fail. Send reports whether the message was
+// written to a connection.
+func (s *Spool) Send(ctx context.Context, user string, messageType int, data []byte) (bool, error) {
+	mu := s.lock(user)
+	mu.Lock()
+	defer mu.Unlock()
+	delivered := false
+	for _, c := range s.Registry.Lookup(user) {
+		if c.WriteMessage(messageType, data) == nil {
+			delivered = true
+		}
+	}
+	if delivered {
+		return true, nil
+	}
+	if s.MaxMessageSize > 0 && len(data) > s.MaxMessageSize {
+		return false, ErrSpoolMessageTooLarge
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultSpoolTTL
+	}
+	max := s.MaxMessages
+	if max <= 0 {
+		max = defaultSpoolMaxMessages
+	}
+	m := SpooledMessage{Type: messageType, Data: data, ExpiresAt: time.Now().Add(ttl)}
+	return false, s.messageStore().Push(ctx, user, m, max)
+}
+
+// Connect writes the messages kept for the user to the connection, then
+// adds the connection to the registry and indexes it under the user key, so
+// that the kept messages are written before the messages sent afterwards.
+// Connect returns the number of messages written. The messages are removed
+// from the store once written; if a write fails, the messages not written
+// are kept for the next connection and the connection is not indexed.
+func (s *Spool) Connect(ctx context.Context, user string, c *Conn) (int, error) {
+	if c == nil {
+		return 0, ErrNilConn
+	}
+	mu := s.lock(user)
+	mu.Lock()
+	defer mu.Unlock()
+	n, err := s.deliver(ctx, user, c)
+	if err != nil {
+		return n, err
+	}
+	s.Registry.Add(c)
+	return n, s.Registry.Index(c, user)
+}
+
+// deliver writes the messages kept for the user to the connection.
+func (s *Spool) deliver(ctx context.Context, user string, c *Conn) (int, error) {
+	store := s.messageStore()
+	msgs, err := store.Fetch(ctx, user, time.Now())
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+	n := 0
+	for _, m := range msgs {
+		if err = c.WriteMessage(m.Type, m.Data); err != nil {
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		if rerr := store.Remove(ctx, user, msgs[n-1].Seq); err == nil {
+			err = rerr
+		}
+	}
+	return n, err
+}
+
+// Purge removes the expired messages from the store.
+func (s *Spool) Purge(ctx context.Context) error {
+	return s.messageStore().Purge(ctx, time.Now())
+}
+
+// MemoryStore is a MessageStore keeping the messages in memory. The zero
+// value is ready to use.
+type MemoryStore struct {
+	mu    sync.Mutex
+	users map[string]*memoryQueue
+}
+
+type memoryQueue struct {
+	seq  uint64
+	msgs []SpooledMessage
+}
+
+// Push implements MessageStore.
+func (s *MemoryStore) Push(ctx context.Context, user string, m SpooledMessage, max int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = make(map[string]*memoryQueue)
+	}
+	q := s.users[user]
+	if q == nil {
+		q = &memoryQueue{}
+		s.users[user] = q
+	}
+	q.seq++
+	m.Seq = q.seq
+	m.Data = append([]byte(nil), m.Data...)
+	q.msgs = append(q.msgs, m)
+	if max > 0 && len(q.msgs) > max {
+		q.msgs = append(q.msgs[:0], q.msgs[len(q.msgs)-max:]...)
+	}
+	return nil
+}
+
+// Fetch implements MessageStore.
+func (s *MemoryStore) Fetch(ctx context.Context, user string, now time.Time) ([]SpooledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.users[user]
+	if q == nil {
+		return nil, nil
+	}
+	var msgs []SpooledMessage
+	for _, m := range q.msgs {
+		if now.Before(m.ExpiresAt) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+// Remove implements MessageStore.
+func (s *MemoryStore) Remove(ctx context.Context, user string, seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.users[user]
+	if q == nil {
+		return nil
+	}
+	i := 0
+	for i < len(q.msgs) && q.msgs[i].Seq <= seq {
+		i++
+	}
+	q.msgs = append(q.msgs[:0], q.msgs[i:]...)
+	if len(q.msgs) == 0 {
+		delete(s.users, user)
+	}
+	return nil
+}
+
+// Purge implements MessageStore.
+func (s *MemoryStore) Purge(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, q := range s.users {
+		msgs := q.msgs[:0]
+		for _, m := range q.msgs {
+			if now.Before(m.ExpiresAt) {
+				msgs = append(msgs, m)
+			}
+		}
+		clear(q.msgs[len(msgs):])
+		q.msgs = msgs
+		if len(q.msgs) == 0 {
+			delete(s.users, user)
+		}
+	}
+	return nil
+}
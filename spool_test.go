@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	var s MemoryStore
+	now := time.Now()
+	for i, d := range []string{"a", "b", "c", "d"} {
+		expires := now.Add(time.Hour)
+		if i == 1 {
+			expires = now.Add(-time.Second)
+		}
+		if err := s.Push(ctx, "u", SpooledMessage{Type: TextMessage, Data: []byte(d), ExpiresAt: expires}, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := s.Fetch(ctx, "u", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || string(msgs[0].Data) != "c" || string(msgs[1].Data) != "d" || msgs[0].Seq >= msgs[1].Seq {
+		t.Fatalf("Fetch returned %v, want c and d", msgs)
+	}
+	if err := s.Remove(ctx, "u", msgs[0].Seq); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := s.Fetch(ctx, "u", now); len(msgs) != 1 || string(msgs[0].Data) != "d" {
+		t.Fatalf("Fetch after Remove returned %v, want d", msgs)
+	}
+	if err := s.Purge(ctx, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := s.Fetch(ctx, "u", now); len(msgs) != 0 {
+		t.Fatalf("Fetch after Purge returned %v", msgs)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+	spool := &Spool{Registry: &Registry{}, MaxMessageSize: 16}
+	for _, m := range []string{"one", "two"} {
+		if delivered, err := spool.Send(ctx, "user:1", TextMessage, []byte(m)); delivered || err != nil {
+			t.Fatalf("Send to an offline user returned %v, %v", delivered, err)
+		}
+	}
+	if _, err := spool.Send(ctx, "user:1", TextMessage, make([]byte, 17)); err != ErrSpoolMessageTooLarge {
+		t.Fatalf("Send of a large message returned %v, want %v", err, ErrSpoolMessageTooLarge)
+	}
+
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	got := make(chan string, 3)
+	go func() {
+		for range 3 {
+			_, p, _ := c.ReadMessage()
+			got <- string(p)
+		}
+	}()
+	if n, err := spool.Connect(ctx, "user:1", s); n != 2 || err != nil {
+		t.Fatalf("Connect returned %d, %v, want 2 messages", n, err)
+	}
+	if delivered, err := spool.Send(ctx, "user:1", TextMessage, []byte("three")); !delivered || err != nil {
+		t.Fatalf("Send to an online user returned %v, %v", delivered, err)
+	}
+	for _, want := range []string{"one", "two", "three"} {
+		if m := <-got; m != want {
+			t.Fatalf("read %q, want %q", m, want)
+		}
+	}
+	if msgs, _ := spool.messageStore().Fetch(ctx, "user:1", time.Now()); len(msgs) != 0 {
+		t.Fatalf("delivered messages still stored: %v", msgs)
+	}
+}
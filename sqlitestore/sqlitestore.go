@@ -0,0 +1,112 @@
+// Package sqlitestore persists the messages of a websocket Spool in a SQLite
+// database accessed with database/sql, so that the messages kept for offline
+// users survive restarts. The package does not import a driver; open the
+// database with the driver of your choice, such as modernc.org/sqlite or
+// github.com/mattn/go-sqlite3:
+//
+//	db, err := sql.Open("sqlite", "spool.db")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	store, err := sqlitestore.New(ctx, db)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	spool := &websocket.Spool{Registry: registry, Store: store}
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// DefaultTable is the table used by New.
+const DefaultTable = "websocket_spool"
+
+// Store is a websocket.MessageStore backed by a SQLite table.
+type Store struct {
+	db    *sql.DB
+	table string
+}
+
+// New returns a store keeping the messages in the DefaultTable table of
+// the database, creating the table if it does not exist.
+func New(ctx context.Context, db *sql.DB) (*Store, error) {
+	return NewTable(ctx, db, DefaultTable)
+}
+
+// NewTable is like New with the name of the table.
+func NewTable(ctx context.Context, db *sql.DB, table string) (*Store, error) {
+	s := &Store{db: db, table: `"` + table + `"`}
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	user TEXT NOT NULL,
+	type INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS "`+table+`_user" ON `+s.table+` (user, seq);
+CREATE INDEX IF NOT EXISTS "`+table+`_expires_at" ON `+s.table+` (expires_at);`)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Push implements websocket.MessageStore.
+func (s *Store) Push(ctx context.Context, user string, m websocket.SpooledMessage, max int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+s.table+` (user, type, data, expires_at) VALUES (?, ?, ?, ?)`,
+		user, m.Type, m.Data, m.ExpiresAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	if max > 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE user = ? AND seq NOT IN (
+	SELECT seq FROM `+s.table+` WHERE user = ? ORDER BY seq DESC LIMIT ?)`, user, user, max)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Fetch implements websocket.MessageStore.
+func (s *Store) Fetch(ctx context.Context, user string, now time.Time) ([]websocket.SpooledMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT seq, type, data, expires_at FROM `+s.table+`
+WHERE user = ? AND expires_at > ? ORDER BY seq`, user, now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []websocket.SpooledMessage
+	for rows.Next() {
+		var m websocket.SpooledMessage
+		var expires int64
+		if err := rows.Scan(&m.Seq, &m.Type, &m.Data, &expires); err != nil {
+			return nil, err
+		}
+		m.ExpiresAt = time.Unix(0, expires)
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// Remove implements websocket.MessageStore.
+func (s *Store) Remove(ctx context.Context, user string, seq uint64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE user = ? AND seq <= ?`, user, int64(seq))
+	return err
+}
+
+// Purge implements websocket.MessageStore.
+func (s *Store) Purge(ctx context.Context, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires_at <= ?`, now.UnixNano())
+	return err
+}
@@ -0,0 +1,70 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+	_ "modernc.org/sqlite"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "spool.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, d := range []string{"a", "b", "c", "d"} {
+		expires := now.Add(time.Hour)
+		if i == 1 {
+			expires = now.Add(-time.Second)
+		}
+		if err := s.Push(ctx, "u", websocket.SpooledMessage{Type: websocket.BinaryMessage, Data: []byte(d), ExpiresAt: expires}, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.Push(ctx, "other", websocket.SpooledMessage{Type: websocket.TextMessage, Data: []byte("x"), ExpiresAt: now.Add(time.Hour)}, 0)
+
+	// The messages survive reopening the database.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = sql.Open("sqlite", path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if s, err = New(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := s.Fetch(ctx, "u", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || string(msgs[0].Data) != "c" || string(msgs[1].Data) != "d" || msgs[0].Type != websocket.BinaryMessage {
+		t.Fatalf("Fetch returned %v, want c and d", msgs)
+	}
+	if err := s.Remove(ctx, "u", msgs[0].Seq); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := s.Fetch(ctx, "u", now); len(msgs) != 1 || string(msgs[0].Data) != "d" {
+		t.Fatalf("Fetch after Remove returned %v, want d", msgs)
+	}
+	if err := s.Purge(ctx, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"u", "other"} {
+		if msgs, _ := s.Fetch(ctx, user, now); len(msgs) != 0 {
+			t.Fatalf("Fetch for %s after Purge returned %v", user, msgs)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Package stomp implements STOMP 1.2 over WebSocket: a frame codec usable
+// with any STOMP peer, and a Server with minimal broker semantics for STOMP
+// clients such as stomp.js.
+//
+// Each WebSocket message carries one frame. A message consisting of end of
+// line characters only is a heartbeat.
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gflydev/websocket"
+)
+
+// Subprotocol names of the STOMP versions.
+const (
+	V12 = "v12.stomp"
+	V11 = "v11.stomp"
+	V10 = "v10.stomp"
+)
+
+// Frame commands.
+const (
+	CONNECT     = "CONNECT"
+	STOMP       = "STOMP"
+	CONNECTED   = "CONNECTED"
+	SEND        = "SEND"
+	SUBSCRIBE   = "SUBSCRIBE"
+	UNSUBSCRIBE = "UNSUBSCRIBE"
+	ACK         = "ACK"
+	NACK        = "NACK"
+	BEGIN       = "BEGIN"
+	COMMIT      = "COMMIT"
+	ABORT       = "ABORT"
+	DISCONNECT  = "DISCONNECT"
+	MESSAGE     = "MESSAGE"
+	RECEIPT     = "RECEIPT"
+	ERROR       = "ERROR"
+)
+
+var errInvalidFrame = errors.New("stomp: invalid frame")
+
+// Header is the ordered list of headers of a frame. If a header is repeated,
+// the first value is used.
+type Header [][2]string
+
+// Get returns the first value of the header with the given name.
+func (h Header) Get(name string) string {
+	v, _ := h.Lookup(name)
+	return v
+}
+
+// Lookup returns the first value of the header with the given name and
+// whether the header is present.
+func (h Header) Lookup(name string) (string, bool) {
+	for _, f := range h {
+		if f[0] == name {
+			return f[1], true
+		}
+	}
+	return "", false
+}
+
+// Add appends a header.
+func (h *Header) Add(name, value string) {
+	*h = append(*h, [2]string{name, value})
+}
+
+// Set replaces the values of the header with a single value.
+func (h *Header) Set(name, value string) {
+	h.Del(name)
+	h.Add(name, value)
+}
+
+// Del removes all values of the header.
+func (h *Header) Del(name string) {
+	fields := (*h)[:0]
+	for _, f := range *h {
+		if f[0] != name {
+			fields = append(fields, f)
+		}
+	}
+	*h = fields
+}
+
+// Frame is a STOMP frame.
+type Frame struct {
+	Command string
+	Header  Header
+	Body    []byte
+}
+
+// NewFrame returns a frame with the command and alternating header names and
+// values.
+func NewFrame(command string, header ...string) *Frame {
+	f := &Frame{Command: command}
+	for i := 0; i+1 < len(header); i += 2 {
+		f.Header.Add(header[i], header[i+1])
+	}
+	return f
+}
+
+// escapes reports whether the header of frames with the command are
+// escaped. CONNECT and CONNECTED frames are not escaped for compatibility
+// with STOMP 1.0.
+func escapes(command string) bool {
+	return command != CONNECT && command != CONNECTED
+}
+
+var (
+	headerEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	headerUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// MarshalBinary encodes the frame. A content-length header is added to
+// frames with a body if the header is missing.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	if f.Command == "" || strings.ContainsAny(f.Command, "\r\n\x00") {
+		return nil, errInvalidFrame
+	}
+	var b bytes.Buffer
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	esc := escapes(f.Command)
+	for _, h := range f.Header {
+		if esc {
+			headerEscaper.WriteString(&b, h[0])
+			b.WriteByte(':')
+			headerEscaper.WriteString(&b, h[1])
+		} else {
+			if strings.ContainsAny(h[0], ":\r\n") || strings.ContainsAny(h[1], "\r\n") {
+				return nil, errInvalidFrame
+			}
+			b.WriteString(h[0])
+			b.WriteByte(':')
+			b.WriteString(h[1])
+		}
+		b.WriteByte('\n')
+	}
+	if _, ok := f.Header.Lookup("content-length"); !ok && len(f.Body) > 0 {
+		b.WriteString("content-length:")
+		b.WriteString(strconv.Itoa(len(f.Body)))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.Write(f.Body)
+	b.WriteByte(0)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a frame. The body of the frame shares the memory
+// of p.
+func (f *Frame) UnmarshalBinary(p []byte) error {
+	line := func() (string, bool) {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			return "", false
+		}
+		l := p[:i]
+		p = p[i+1:]
+		return string(bytes.TrimSuffix(l, []byte{'\r'})), true
+	}
+
+	// Skip heartbeats preceding the frame.
+	var command string
+	for {
+		l, ok := line()
+		if !ok {
+			return errInvalidFrame
+		}
+		if l != "" {
+			command = l
+			break
+		}
+	}
+	f.Command = command
+	f.Header = nil
+	esc := escapes(command)
+	for {
+		l, ok := line()
+		if !ok {
+			return errInvalidFrame
+		}
+		if l == "" {
+			break
+		}
+		i := strings.IndexByte(l, ':')
+		if i < 0 {
+			return errInvalidFrame
+		}
+		name, value := l[:i], l[i+1:]
+		if esc {
+			if !validEscapes(name) || !validEscapes(value) {
+				return errInvalidFrame
+			}
+			name, value = headerUnescaper.Replace(name), headerUnescaper.Replace(value)
+		}
+		f.Header.Add(name, value)
+	}
+
+	if cl, ok := f.Header.Lookup("content-length"); ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 || n >= len(p) || p[n] != 0 {
+			return errInvalidFrame
+		}
+		f.Body, p = p[:n], p[n+1:]
+	} else {
+		i := bytes.IndexByte(p, 0)
+		if i < 0 {
+			return errInvalidFrame
+		}
+		f.Body, p = p[:i], p[i+1:]
+	}
+	if len(bytes.Trim(p, "\r\n")) != 0 {
+		return errInvalidFrame
+	}
+	return nil
+}
+
+// validEscapes reports whether s contains only the escape sequences defined
+// by STOMP 1.2.
+func validEscapes(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 == len(s) {
+			return false
+		}
+		switch s[i+1] {
+		case '\\', 'r', 'n', 'c':
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isHeartbeat reports whether the message is a heartbeat.
+func isHeartbeat(p []byte) bool {
+	return len(bytes.Trim(p, "\r\n")) == 0
+}
+
+// ReadFrame reads the next frame from the connection, skipping heartbeats.
+func ReadFrame(c *websocket.Conn) (*Frame, error) {
+	for {
+		_, p, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if isHeartbeat(p) {
+			continue
+		}
+		f := new(Frame)
+		if err := f.UnmarshalBinary(p); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+}
+
+// WriteFrame writes the frame to the connection as a text message, or as a
+// binary message if the body is not valid UTF-8.
+func WriteFrame(c *websocket.Conn, f *Frame) error {
+	p, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	mt := websocket.TextMessage
+	if !utf8.Valid(f.Body) {
+		mt = websocket.BinaryMessage
+	}
+	return c.WriteMessage(mt, p)
+}
+
+// WriteHeartbeat writes a heartbeat to the connection.
+func WriteHeartbeat(c *websocket.Conn) error {
+	return c.WriteMessage(websocket.TextMessage, []byte{'\n'})
+}
@@ -0,0 +1,98 @@
+package stomp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, f := range []*Frame{
+		NewFrame(SEND, "destination", "/queue/a", "x", "a:b\nc\\d\r"),
+		{Command: SEND, Header: Header{{"destination", "/q"}, {"content-length", "3"}}, Body: []byte{'a', 0, 'b'}},
+		NewFrame(CONNECT, "accept-version", "1.2", "host", "h"),
+		NewFrame(DISCONNECT),
+	} {
+		p, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var g Frame
+		if err := g.UnmarshalBinary(p); err != nil {
+			t.Fatalf("%q: %v", p, err)
+		}
+		if len(g.Body) == 0 {
+			g.Body = nil
+		}
+		if g.Command != f.Command || !bytes.Equal(g.Body, f.Body) || !reflect.DeepEqual(g.Header, f.Header) {
+			t.Errorf("round trip of %q: got %+v, want %+v", p, g, *f)
+		}
+	}
+}
+
+func TestFrameMarshal(t *testing.T) {
+	f := NewFrame(MESSAGE, "a:b", "c\nd")
+	f.Body = []byte("hi")
+	p, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "MESSAGE\na\\cb:c\\nd\ncontent-length:2\n\nhi\x00"; string(p) != want {
+		t.Fatalf("got %q, want %q", p, want)
+	}
+
+	if _, err := NewFrame(CONNECTED, "a", "b\nc").MarshalBinary(); err == nil {
+		t.Fatal("no error for newline in unescaped header")
+	}
+	if _, err := NewFrame("").MarshalBinary(); err == nil {
+		t.Fatal("no error for empty command")
+	}
+}
+
+func TestFrameUnmarshal(t *testing.T) {
+	var f Frame
+	if err := f.UnmarshalBinary([]byte("\r\n\nSEND\r\ndestination:/q\r\nx:1\nx:2\n\nbody\x00\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	if f.Command != SEND || f.Header.Get("x") != "1" || string(f.Body) != "body" {
+		t.Fatalf("got %+v", f)
+	}
+
+	if err := f.UnmarshalBinary([]byte("CONNECT\nlogin:a\\c\n\n\x00")); err != nil || f.Header.Get("login") != `a\c` {
+		t.Fatalf("CONNECT header escaped: %v %+v", err, f)
+	}
+
+	for _, p := range []string{
+		"",
+		"\n\n",
+		"SEND\n",
+		"SEND\nno colon\n\n\x00",
+		"SEND\na:\\t\n\n\x00",
+		"SEND\n\nno nul",
+		"SEND\ncontent-length:5\n\nab\x00",
+		"SEND\ncontent-length:x\n\nab\x00",
+		"SEND\n\nab\x00junk",
+	} {
+		if err := f.UnmarshalBinary([]byte(p)); err == nil {
+			t.Errorf("no error for %q", p)
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	var h Header
+	h.Add("a", "1")
+	h.Add("b", "2")
+	h.Add("a", "3")
+	if h.Get("a") != "1" {
+		t.Fatalf("Get(a) = %q", h.Get("a"))
+	}
+	h.Set("a", "4")
+	if !reflect.DeepEqual(h, Header{{"b", "2"}, {"a", "4"}}) {
+		t.Fatalf("after Set: %v", h)
+	}
+	h.Del("b")
+	if _, ok := h.Lookup("b"); ok || len(h) != 1 {
+		t.Fatalf("after Del: %v", h)
+	}
+}
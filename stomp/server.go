@@ -0,0 +1,160 @@
+package stomp
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+// Server is a minimal STOMP broker. Messages sent to a destination are
+// delivered to every subscription of the destination at the time of the
+// send; messages are not stored. Transactions are not supported.
+//
+// The zero value is ready to use.
+type Server struct {
+	// Upgrader upgrades the HTTP requests to WebSocket connections. If
+	// Upgrader.Subprotocols is empty, the subprotocols of the STOMP
+	// versions are offered, with V12 preferred.
+	Upgrader websocket.Upgrader
+
+	// HeartBeat is the smallest interval at which the server can send
+	// heartbeats and the desired interval between heartbeats from the
+	// client, as sent in the heart-beat header of the CONNECTED frame. If
+	// zero, the server does not send or expect heartbeats.
+	HeartBeat [2]time.Duration
+
+	// Authenticate, if not nil, is called with the login and passcode
+	// headers of the CONNECT frame. Returning an error rejects the
+	// connection with an ERROR frame.
+	Authenticate func(r *http.Request, login, passcode string) error
+
+	// OnSend, if not nil, is called for each SEND frame before the message
+	// is delivered to the subscribers. Returning an error rejects the
+	// frame with an ERROR frame and closes the connection.
+	OnSend func(s *Session, f *Frame) error
+
+	// OnNack, if not nil, is called for each message rejected with NACK,
+	// and for each unacknowledged message of a session that disconnected.
+	OnNack func(s *Session, m *Frame)
+
+	mu   sync.RWMutex
+	subs map[string]map[*subscription]struct{}
+
+	nextID atomic.Uint64
+}
+
+// subscription is a SUBSCRIBE of a session.
+type subscription struct {
+	id          string
+	destination string
+	ack         string // "auto", "client" or "client-individual"
+	session     *Session
+}
+
+// pending is a message waiting for an acknowledgement.
+type pending struct {
+	sub   *subscription
+	msg   *Frame
+	order uint64
+}
+
+// ServeHTTP upgrades the request and serves the STOMP session until the
+// connection closes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := s.Upgrader
+	if len(u.Subprotocols) == 0 {
+		u.Subprotocols = []string{V12, V11, V10}
+	}
+	c, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.ServeConn(c, r)
+}
+
+// ServeConn serves a STOMP session on an upgraded connection until the
+// connection closes, then closes it. The request is passed to Authenticate
+// and may be nil.
+func (s *Server) ServeConn(c *websocket.Conn, r *http.Request) {
+	sess := &Session{
+		server:  s,
+		conn:    c,
+		request: r,
+		subs:    make(map[string]*subscription),
+		pending: make(map[string]*pending),
+		done:    make(chan struct{}),
+	}
+	sess.serve()
+}
+
+// Publish delivers a message to the subscriptions of the destination, as if
+// a client had sent it. Publish returns the number of subscriptions the
+// message was delivered to.
+func (s *Server) Publish(destination string, header Header, body []byte) int {
+	f := &Frame{Command: SEND, Header: append(Header(nil), header...), Body: body}
+	f.Header.Set("destination", destination)
+	return s.deliver(f)
+}
+
+// deliver sends a MESSAGE frame for the SEND frame f to the subscriptions
+// of its destination.
+func (s *Server) deliver(f *Frame) int {
+	destination := f.Header.Get("destination")
+	s.mu.RLock()
+	subs := make([]*subscription, 0, len(s.subs[destination]))
+	for sub := range s.subs[destination] {
+		subs = append(subs, sub)
+	}
+	s.mu.RUnlock()
+
+	for _, sub := range subs {
+		id := strconv.FormatUint(s.nextID.Add(1), 10)
+		m := &Frame{Command: MESSAGE, Body: f.Body}
+		m.Header.Add("subscription", sub.id)
+		m.Header.Add("message-id", id)
+		m.Header.Add("destination", destination)
+		if sub.ack != "auto" {
+			m.Header.Add("ack", id)
+		}
+		for _, h := range f.Header {
+			switch h[0] {
+			case "destination", "receipt", "transaction", "subscription", "message-id", "ack":
+				continue
+			}
+			m.Header.Add(h[0], h[1])
+		}
+		sub.session.send(sub, id, m)
+	}
+	return len(subs)
+}
+
+func (s *Server) subscribe(sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[string]map[*subscription]struct{})
+	}
+	subs := s.subs[sub.destination]
+	if subs == nil {
+		subs = make(map[*subscription]struct{})
+		s.subs[sub.destination] = subs
+	}
+	subs[sub] = struct{}{}
+}
+
+func (s *Server) unsubscribe(sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subs := s.subs[sub.destination]; subs != nil {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(s.subs, sub.destination)
+		}
+	}
+}
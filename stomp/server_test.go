@@ -0,0 +1,214 @@
+package stomp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+func dial(t *testing.T, srv *Server) *websocket.Conn {
+	t.Helper()
+	s := httptest.NewServer(srv)
+	t.Cleanup(s.Close)
+	d := websocket.Dialer{Subprotocols: []string{V12}}
+	c, _, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if c.Subprotocol() != V12 {
+		t.Fatalf("subprotocol %q", c.Subprotocol())
+	}
+	return c
+}
+
+func send(t *testing.T, c *websocket.Conn, f *Frame) {
+	t.Helper()
+	if err := WriteFrame(c, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expect(t *testing.T, c *websocket.Conn, command string, header ...string) *Frame {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := ReadFrame(c)
+	if err != nil {
+		t.Fatalf("ReadFrame: %v, want %s", err, command)
+	}
+	if f.Command != command {
+		t.Fatalf("got %s %v %q, want %s", f.Command, f.Header, f.Body, command)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		if v := f.Header.Get(header[i]); v != header[i+1] {
+			t.Fatalf("%s header %s = %q, want %q", command, header[i], v, header[i+1])
+		}
+	}
+	return f
+}
+
+func connect(t *testing.T, srv *Server, header ...string) *websocket.Conn {
+	t.Helper()
+	c := dial(t, srv)
+	send(t, c, NewFrame(CONNECT, append([]string{"accept-version", "1.1,1.2", "host", "test"}, header...)...))
+	expect(t, c, CONNECTED, "version", "1.2")
+	return c
+}
+
+func TestSendSubscribe(t *testing.T) {
+	var srv Server
+	c1 := connect(t, &srv)
+	c2 := connect(t, &srv)
+
+	send(t, c1, NewFrame(SUBSCRIBE, "id", "0", "destination", "/topic/a", "receipt", "r1"))
+	expect(t, c1, RECEIPT, "receipt-id", "r1")
+	send(t, c2, NewFrame(SUBSCRIBE, "id", "s", "destination", "/topic/a", "receipt", "r2"))
+	expect(t, c2, RECEIPT, "receipt-id", "r2")
+
+	f := NewFrame(SEND, "destination", "/topic/a", "content-type", "text/plain", "receipt", "r3")
+	f.Body = []byte("hello")
+	send(t, c1, f)
+	m := expect(t, c1, MESSAGE, "subscription", "0", "destination", "/topic/a", "content-type", "text/plain", "receipt", "")
+	if string(m.Body) != "hello" || m.Header.Get("message-id") == "" {
+		t.Fatalf("MESSAGE %v %q", m.Header, m.Body)
+	}
+	expect(t, c1, RECEIPT, "receipt-id", "r3")
+	expect(t, c2, MESSAGE, "subscription", "s")
+
+	send(t, c2, NewFrame(UNSUBSCRIBE, "id", "s", "receipt", "r4"))
+	expect(t, c2, RECEIPT, "receipt-id", "r4")
+	if n := srv.Publish("/topic/a", Header{{"x", "1"}}, []byte("again")); n != 1 {
+		t.Fatalf("Publish delivered to %d subscriptions, want 1", n)
+	}
+	expect(t, c1, MESSAGE, "x", "1")
+
+	send(t, c1, NewFrame(DISCONNECT, "receipt", "bye"))
+	expect(t, c1, RECEIPT, "receipt-id", "bye")
+	if _, err := ReadFrame(c1); err == nil {
+		t.Fatal("connection open after DISCONNECT")
+	}
+}
+
+func TestAck(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		nacked []string
+	)
+	srv := Server{OnNack: func(s *Session, m *Frame) {
+		mu.Lock()
+		nacked = append(nacked, string(m.Body))
+		mu.Unlock()
+	}}
+	c := connect(t, &srv)
+	send(t, c, NewFrame(SUBSCRIBE, "id", "c", "destination", "/q/c", "ack", "client"))
+	send(t, c, NewFrame(SUBSCRIBE, "id", "i", "destination", "/q/i", "ack", "client-individual", "receipt", "r"))
+	expect(t, c, RECEIPT)
+
+	var ids []string
+	for _, body := range []string{"1", "2", "3"} {
+		srv.Publish("/q/c", nil, []byte(body))
+		m := expect(t, c, MESSAGE, "subscription", "c")
+		ids = append(ids, m.Header.Get("ack"))
+	}
+	// Cumulative: NACK of the second message rejects the first two.
+	send(t, c, NewFrame(NACK, "id", ids[1], "receipt", "n"))
+	expect(t, c, RECEIPT, "receipt-id", "n")
+	send(t, c, NewFrame(ACK, "id", ids[0]))
+	expect(t, c, ERROR, "message", `unknown message "`+ids[0]+`"`)
+
+	c = connect(t, &srv)
+	send(t, c, NewFrame(SUBSCRIBE, "id", "i", "destination", "/q/i", "ack", "client-individual", "receipt", "r"))
+	expect(t, c, RECEIPT)
+	ids = nil
+	for _, body := range []string{"a", "b", "c"} {
+		srv.Publish("/q/i", nil, []byte(body))
+		m := expect(t, c, MESSAGE, "subscription", "i")
+		ids = append(ids, m.Header.Get("ack"))
+	}
+	send(t, c, NewFrame(NACK, "id", ids[1]))
+	send(t, c, NewFrame(ACK, "id", ids[0], "receipt", "a"))
+	expect(t, c, RECEIPT, "receipt-id", "a")
+	_ = c.Close()
+
+	want := []string{"1", "2", "3", "b", "c"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		got := strings.Join(nacked, "")
+		mu.Unlock()
+		if got == strings.Join(want, "") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nacked %q, want %q", got, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectErrors(t *testing.T) {
+	srv := Server{Authenticate: func(r *http.Request, login, passcode string) error {
+		if login != "guest" || passcode != "guest" {
+			return errors.New("access denied")
+		}
+		return nil
+	}}
+
+	c := dial(t, &srv)
+	send(t, c, NewFrame(SEND, "destination", "/q"))
+	expect(t, c, ERROR, "message", "expected CONNECT frame")
+
+	c = dial(t, &srv)
+	send(t, c, NewFrame(CONNECT, "accept-version", "2.0"))
+	expect(t, c, ERROR, "version", "1.2,1.1,1.0")
+
+	c = dial(t, &srv)
+	send(t, c, NewFrame(STOMP, "accept-version", "1.2", "login", "guest", "passcode", "x"))
+	expect(t, c, ERROR, "message", "access denied")
+
+	c = connect(t, &srv, "login", "guest", "passcode", "guest")
+	send(t, c, NewFrame(BEGIN, "transaction", "tx", "receipt", "r"))
+	expect(t, c, ERROR, "message", "transactions not supported", "receipt-id", "r")
+
+	c = connect(t, &srv, "login", "guest", "passcode", "guest")
+	if err := c.WriteMessage(websocket.TextMessage, []byte("SEND\n")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, ERROR, "message", "malformed frame")
+
+	// A 1.0 client without accept-version.
+	c = dial(t, &srv)
+	send(t, c, NewFrame(CONNECT, "login", "guest", "passcode", "guest"))
+	expect(t, c, CONNECTED, "version", "1.0")
+}
+
+func TestHeartBeat(t *testing.T) {
+	srv := Server{HeartBeat: [2]time.Duration{10 * time.Millisecond, 20 * time.Millisecond}}
+	c := dial(t, &srv)
+	send(t, c, NewFrame(CONNECT, "accept-version", "1.2", "heart-beat", "20,5"))
+	expect(t, c, CONNECTED, "heart-beat", "10,20")
+
+	// The server sends heartbeats every 10ms.
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, p, err := c.ReadMessage()
+	if err != nil || !isHeartbeat(p) {
+		t.Fatalf("got %q, %v, want heartbeat", p, err)
+	}
+	if err := WriteHeartbeat(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server closes the connection when the client stops sending
+	// heartbeats for longer than 20ms.
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package stomp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// versions are the supported STOMP versions, preferred first.
+var versions = []string{"1.2", "1.1", "1.0"}
+
+// Session is the STOMP session of a client.
+type Session struct {
+	server  *Server
+	conn    *websocket.Conn
+	request *http.Request
+
+	writeMu sync.Mutex
+
+	// Set by CONNECT, read by the read loop only.
+	connected    bool
+	recvInterval time.Duration
+
+	mu      sync.Mutex
+	version string
+	subs    map[string]*subscription // by subscription id
+	pending map[string]*pending      // by ack id
+	order   uint64
+	done    chan struct{}
+	closed  bool
+}
+
+// Conn returns the WebSocket connection of the session.
+func (sess *Session) Conn() *websocket.Conn { return sess.conn }
+
+// Request returns the HTTP request of the WebSocket handshake, or nil if
+// the session was started by ServeConn without a request.
+func (sess *Session) Request() *http.Request { return sess.request }
+
+// Version returns the STOMP version negotiated with the client, or the
+// empty string before the client connected.
+func (sess *Session) Version() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.version
+}
+
+func (sess *Session) write(f *Frame) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	_ = sess.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return WriteFrame(sess.conn, f)
+}
+
+// send delivers a message for a subscription of the session.
+func (sess *Session) send(sub *subscription, id string, m *Frame) {
+	sess.mu.Lock()
+	if sess.closed || sess.subs[sub.id] != sub {
+		sess.mu.Unlock()
+		return
+	}
+	if sub.ack != "auto" {
+		sess.order++
+		sess.pending[id] = &pending{sub: sub, msg: m, order: sess.order}
+	}
+	sess.mu.Unlock()
+	if err := sess.write(m); err != nil {
+		_ = sess.conn.Close()
+	}
+}
+
+// fail sends an ERROR frame for the frame f, which may be nil.
+func (sess *Session) fail(f *Frame, message string) {
+	e := NewFrame(ERROR, "message", message, "content-type", "text/plain")
+	e.Body = []byte(message)
+	if f != nil {
+		if r, ok := f.Header.Lookup("receipt"); ok {
+			e.Header.Add("receipt-id", r)
+		}
+	}
+	_ = sess.write(e)
+}
+
+// serve reads and handles frames until the connection closes, the client
+// disconnects or a frame is rejected.
+func (sess *Session) serve() {
+	defer sess.close()
+	for {
+		if sess.recvInterval > 0 {
+			_ = sess.conn.SetReadDeadline(time.Now().Add(sess.recvInterval))
+		}
+		_, p, err := sess.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if isHeartbeat(p) {
+			continue
+		}
+		f := new(Frame)
+		if err := f.UnmarshalBinary(p); err != nil {
+			sess.fail(nil, "malformed frame")
+			return
+		}
+		if !sess.handle(f) {
+			return
+		}
+	}
+}
+
+// close unsubscribes the session, stops the heartbeats and closes the
+// connection. Unacknowledged messages are passed to OnNack.
+func (sess *Session) close() {
+	sess.mu.Lock()
+	sess.closed = true
+	close(sess.done)
+	subs := sess.subs
+	pending := sess.unacked(func(*pending) bool { return true })
+	sess.subs = nil
+	sess.mu.Unlock()
+
+	for _, sub := range subs {
+		sess.server.unsubscribe(sub)
+	}
+	if h := sess.server.OnNack; h != nil {
+		for _, p := range pending {
+			h(sess, p.msg)
+		}
+	}
+	_ = sess.conn.Close()
+}
+
+// unacked removes and returns the pending messages matching the predicate,
+// in delivery order. The caller must hold mu.
+func (sess *Session) unacked(match func(*pending) bool) []*pending {
+	var ps []*pending
+	for id, p := range sess.pending {
+		if match(p) {
+			ps = append(ps, p)
+			delete(sess.pending, id)
+		}
+	}
+	for i := 1; i < len(ps); i++ {
+		for j := i; j > 0 && ps[j].order < ps[j-1].order; j-- {
+			ps[j], ps[j-1] = ps[j-1], ps[j]
+		}
+	}
+	return ps
+}
+
+// handle handles a frame and reports whether the session continues.
+func (sess *Session) handle(f *Frame) bool {
+	if !sess.connected {
+		if f.Command != CONNECT && f.Command != STOMP {
+			sess.fail(f, "expected CONNECT frame")
+			return false
+		}
+		return sess.connect(f)
+	}
+
+	if _, ok := f.Header.Lookup("transaction"); ok {
+		sess.fail(f, "transactions not supported")
+		return false
+	}
+
+	switch f.Command {
+	case SEND:
+		if f.Header.Get("destination") == "" {
+			sess.fail(f, "missing destination header")
+			return false
+		}
+		if h := sess.server.OnSend; h != nil {
+			if err := h(sess, f); err != nil {
+				sess.fail(f, err.Error())
+				return false
+			}
+		}
+		sess.server.deliver(f)
+	case SUBSCRIBE:
+		if !sess.subscribe(f) {
+			return false
+		}
+	case UNSUBSCRIBE:
+		id := f.Header.Get("id")
+		if id == "" && sess.Version() == "1.0" {
+			id = f.Header.Get("destination")
+		}
+		sess.mu.Lock()
+		sub := sess.subs[id]
+		if sub != nil {
+			delete(sess.subs, id)
+			sess.unacked(func(p *pending) bool { return p.sub == sub })
+		}
+		sess.mu.Unlock()
+		if sub == nil {
+			sess.fail(f, "unknown subscription")
+			return false
+		}
+		sess.server.unsubscribe(sub)
+	case ACK, NACK:
+		if !sess.ack(f) {
+			return false
+		}
+	case BEGIN, COMMIT, ABORT:
+		sess.fail(f, "transactions not supported")
+		return false
+	case DISCONNECT:
+		sess.receipt(f)
+		return false
+	default:
+		sess.fail(f, "unknown command "+f.Command)
+		return false
+	}
+	return sess.receipt(f)
+}
+
+// receipt sends a RECEIPT frame if the frame requests one.
+func (sess *Session) receipt(f *Frame) bool {
+	r, ok := f.Header.Lookup("receipt")
+	if !ok {
+		return true
+	}
+	return sess.write(NewFrame(RECEIPT, "receipt-id", r)) == nil
+}
+
+// connect handles the CONNECT or STOMP frame.
+func (sess *Session) connect(f *Frame) bool {
+	accept := strings.Split(f.Header.Get("accept-version"), ",")
+	if accept[0] == "" {
+		accept = []string{"1.0"}
+	}
+	var version string
+	for _, v := range versions {
+		for _, a := range accept {
+			if strings.TrimSpace(a) == v {
+				version = v
+				break
+			}
+		}
+		if version != "" {
+			break
+		}
+	}
+	if version == "" {
+		e := NewFrame(ERROR, "version", strings.Join(versions, ","), "message", "unsupported protocol version")
+		_ = sess.write(e)
+		return false
+	}
+
+	if auth := sess.server.Authenticate; auth != nil {
+		if err := auth(sess.request, f.Header.Get("login"), f.Header.Get("passcode")); err != nil {
+			sess.fail(f, err.Error())
+			return false
+		}
+	}
+
+	var cx, cy time.Duration
+	if hb := f.Header.Get("heart-beat"); hb != "" {
+		var ok bool
+		if cx, cy, ok = parseHeartBeat(hb); !ok {
+			sess.fail(f, "invalid heart-beat header")
+			return false
+		}
+	}
+	sx, sy := sess.server.HeartBeat[0], sess.server.HeartBeat[1]
+
+	sess.mu.Lock()
+	sess.version = version
+	sess.mu.Unlock()
+	sess.connected = true
+
+	c := NewFrame(CONNECTED, "version", version, "heart-beat", formatHeartBeat(sx, sy))
+	if err := sess.write(c); err != nil {
+		return false
+	}
+	if sx > 0 && cy > 0 {
+		go sess.heartbeat(max(sx, cy))
+	}
+	if cx > 0 && sy > 0 {
+		// Allow for network latency, as suggested by the specification.
+		d := max(cx, sy)
+		sess.recvInterval = d + d/2
+	}
+	return true
+}
+
+// heartbeat sends a heartbeat at the interval until the session closes.
+func (sess *Session) heartbeat(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-sess.done:
+			return
+		case <-t.C:
+			sess.writeMu.Lock()
+			_ = sess.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := WriteHeartbeat(sess.conn)
+			sess.writeMu.Unlock()
+			if err != nil {
+				_ = sess.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// subscribe handles the SUBSCRIBE frame.
+func (sess *Session) subscribe(f *Frame) bool {
+	destination := f.Header.Get("destination")
+	if destination == "" {
+		sess.fail(f, "missing destination header")
+		return false
+	}
+	id := f.Header.Get("id")
+	if id == "" {
+		if sess.Version() != "1.0" {
+			sess.fail(f, "missing id header")
+			return false
+		}
+		id = destination
+	}
+	ack := f.Header.Get("ack")
+	switch ack {
+	case "":
+		ack = "auto"
+	case "auto", "client", "client-individual":
+	default:
+		sess.fail(f, "invalid ack header")
+		return false
+	}
+
+	sub := &subscription{id: id, destination: destination, ack: ack, session: sess}
+	sess.mu.Lock()
+	_, dup := sess.subs[id]
+	if !dup {
+		sess.subs[id] = sub
+	}
+	sess.mu.Unlock()
+	if dup {
+		sess.fail(f, "duplicate subscription id")
+		return false
+	}
+	sess.server.subscribe(sub)
+	return true
+}
+
+// ack handles the ACK or NACK frame. In the client ack mode, the frame
+// applies to the message and all earlier messages of the subscription.
+func (sess *Session) ack(f *Frame) bool {
+	id, ok := f.Header.Lookup("id")
+	if !ok {
+		// STOMP 1.1 acknowledges by message-id.
+		id = f.Header.Get("message-id")
+	}
+	sess.mu.Lock()
+	p := sess.pending[id]
+	var ps []*pending
+	if p != nil {
+		if p.sub.ack == "client" {
+			ps = sess.unacked(func(q *pending) bool { return q.sub == p.sub && q.order <= p.order })
+		} else {
+			delete(sess.pending, id)
+			ps = []*pending{p}
+		}
+	}
+	sess.mu.Unlock()
+	if p == nil {
+		sess.fail(f, "unknown message "+strconv.Quote(id))
+		return false
+	}
+	if h := sess.server.OnNack; h != nil && f.Command == NACK {
+		for _, p := range ps {
+			h(sess, p.msg)
+		}
+	}
+	return true
+}
+
+// parseHeartBeat parses a heart-beat header.
+func parseHeartBeat(s string) (x, y time.Duration, ok bool) {
+	a, b, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, false
+	}
+	ax, err1 := strconv.ParseUint(strings.TrimSpace(a), 10, 31)
+	by, err2 := strconv.ParseUint(strings.TrimSpace(b), 10, 31)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return time.Duration(ax) * time.Millisecond, time.Duration(by) * time.Millisecond, true
+}
+
+// formatHeartBeat formats a heart-beat header.
+func formatHeartBeat(x, y time.Duration) string {
+	return strconv.FormatInt(x.Milliseconds(), 10) + "," + strconv.FormatInt(y.Milliseconds(), 10)
+}
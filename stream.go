@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+)
+
+// SetWriteFrameSize sets the maximum payload size in bytes of the data frames
+// written by the connection. Messages larger than the frame size are
+// fragmented into multiple frames. If n is zero, the frame size is bounded by
+// the write buffer size only. A frame size larger than the write buffer has
+// no effect.
+//
+// Smaller frames let the peer process a large message incrementally and let
+// control messages written with WriteControl interleave with the fragments.
+func (c *Conn) SetWriteFrameSize(n int) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if n < 0 {
+		return errors.New("websocket: invalid write frame size")
+	}
+	c.writeFrameSize = n
+	return nil
+}
+
+// WriteFrom writes a message of the given type with the data read from r
+// until EOF. The data is streamed to the network in fragments as it is read,
+// so the message is never held in memory. WriteFrom returns the number of
+// bytes read from r.
+func (c *Conn) WriteFrom(messageType int, r io.Reader) (int64, error) {
+	w, err := c.NextWriter(messageType)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		// There is no way to abandon a partially written message without
+		// failing the connection. Close releases the writer without sending
+		// the final frame.
+		_ = c.writeFatal(err)
+		_ = w.Close()
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// ReadMessageTo copies the next data message received from the peer to w as
+// it is read from the network, so the message is never held in memory.
+// ReadMessageTo returns the message type and the number of bytes written to
+// w.
+func (c *Conn) ReadMessageTo(w io.Writer) (messageType int, n int64, err error) {
+	messageType, r, err := c.NextReader()
+	if err != nil {
+		return messageType, 0, err
+	}
+	n, err = io.Copy(w, r)
+	return messageType, n, err
+}
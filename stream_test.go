@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteFromFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	if err := wc.SetWriteFrameSize(100); err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("0123456789", 100)
+	n, err := wc.WriteFrom(BinaryMessage, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("WriteFrom returned %d, want %d", n, len(data))
+	}
+
+	// Count the frames. Server frames are not masked and the payloads are
+	// small enough to use the 7 bit length.
+	p := buf.Bytes()
+	frames := 0
+	for len(p) > 0 {
+		length := int(p[1] & 0x7f)
+		if length > 100 {
+			t.Fatalf("frame %d has payload length %d", frames, length)
+		}
+		fin := p[0]&finalBit != 0
+		if fin != (len(p) == 2+length) {
+			t.Fatalf("frame %d has final bit %v", frames, fin)
+		}
+		p = p[2+length:]
+		frames++
+	}
+	if frames != 10 {
+		t.Fatalf("got %d frames, want 10", frames)
+	}
+
+	rc := newTestConn(&buf, nil, false)
+	buf.Reset()
+	_, _ = wc.WriteFrom(BinaryMessage, strings.NewReader(data))
+	var out bytes.Buffer
+	mt, n, err := rc.ReadMessageTo(&out)
+	if err != nil {
+		t.Fatalf("ReadMessageTo: %v", err)
+	}
+	if mt != BinaryMessage || n != int64(len(data)) || out.String() != data {
+		t.Fatalf("ReadMessageTo returned %d, %d, %q", mt, n, out.String())
+	}
+}
+
+func TestWriteFrameSizeLargeWrite(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	_ = wc.SetWriteFrameSize(64)
+	data := bytes.Repeat([]byte{'x'}, 4096)
+	if err := wc.WriteMessage(TextMessage, data); err != nil {
+		t.Fatal(err)
+	}
+	if want := len(data) / 64 * (2 + 64); buf.Len() != want {
+		t.Fatalf("wrote %d bytes, want %d for 64 byte frames", buf.Len(), want)
+	}
+	rc := newTestConn(&buf, nil, false)
+	_, p, err := rc.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatal("message mismatch")
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+func TestWriteFromReadError(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newTestConn(nil, &buf, true)
+	errTest := errors.New("test")
+	r := io.MultiReader(strings.NewReader("hello"), errReader{errTest})
+	if _, err := wc.WriteFrom(TextMessage, r); err != errTest {
+		t.Fatalf("WriteFrom returned %v, want %v", err, errTest)
+	}
+	if err := wc.WriteMessage(TextMessage, []byte("x")); err != errTest {
+		t.Fatalf("WriteMessage after failed WriteFrom returned %v, want %v", err, errTest)
+	}
+}
@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrNotTCP is returned by the TCP tuning methods of Conn when the network
+// connection is not a TCP connection, such as an HTTP/2 stream.
+var ErrNotTCP = errors.New("websocket: network connection is not TCP")
+
+// TCPOptions specifies the socket options of the TCP connection of a
+// WebSocket connection. The zero value keeps the options set by the net
+// package: Nagle's algorithm disabled and keep-alive probes enabled.
+type TCPOptions struct {
+	// DelayWrites enables Nagle's algorithm, which delays small writes to
+	// send fewer packets at the cost of latency. See Conn.SetNoDelay.
+	DelayWrites bool
+
+	// KeepAlive specifies the idle time before keep-alive probes are sent.
+	// If negative, keep-alive probes are disabled. If zero, the keep-alive
+	// configuration of the connection is kept unless KeepAliveInterval or
+	// KeepAliveCount is set, in which case a default of 15 seconds is used.
+	KeepAlive time.Duration
+
+	// KeepAliveInterval specifies the time between keep-alive probes. If
+	// zero, a default of 15 seconds is used.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveCount specifies the number of unanswered probes after which
+	// the connection is dropped. If zero, a default of 9 is used.
+	KeepAliveCount int
+
+	// SendBufferSize and ReceiveBufferSize specify the sizes in bytes of
+	// the socket buffers of the operating system. If zero, the system
+	// defaults are used. These are unrelated to the buffer sizes of the
+	// Upgrader and Dialer, which size the buffers of the WebSocket
+	// connection in the process.
+	SendBufferSize    int
+	ReceiveBufferSize int
+}
+
+// tcpConn returns the TCP connection under the network connection of c,
+// unwrapping connections such as TLS connections that expose the
+// connection they wrap.
+func (c *Conn) tcpConn() (*net.TCPConn, error) {
+	if c == nil {
+		return nil, ErrNilConn
+	}
+	conn := c.conn
+	for conn != nil {
+		switch nc := conn.(type) {
+		case *net.TCPConn:
+			return nc, nil
+		case interface{ NetConn() net.Conn }:
+			conn = nc.NetConn()
+		default:
+			return nil, ErrNotTCP
+		}
+	}
+	return nil, ErrNilNetConn
+}
+
+// SetNoDelay controls whether the operating system delays small writes
+// to the TCP connection (Nagle's algorithm). The default is true: writes
+// are sent as soon as possible. See net.TCPConn.SetNoDelay.
+func (c *Conn) SetNoDelay(noDelay bool) error {
+	tc, err := c.tcpConn()
+	if err != nil {
+		return err
+	}
+	return tc.SetNoDelay(noDelay)
+}
+
+// SetKeepAlive configures the keep-alive probes of the TCP connection. See
+// net.TCPConn.SetKeepAliveConfig.
+func (c *Conn) SetKeepAlive(config net.KeepAliveConfig) error {
+	tc, err := c.tcpConn()
+	if err != nil {
+		return err
+	}
+	return tc.SetKeepAliveConfig(config)
+}
+
+// SetSocketBuffers sets the sizes in bytes of the send and receive buffers
+// of the operating system for the TCP connection. A size of zero leaves the
+// buffer unchanged.
+func (c *Conn) SetSocketBuffers(send, receive int) error {
+	tc, err := c.tcpConn()
+	if err != nil {
+		return err
+	}
+	if send > 0 {
+		if err := tc.SetWriteBuffer(send); err != nil {
+			return err
+		}
+	}
+	if receive > 0 {
+		if err := tc.SetReadBuffer(receive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTCPOptions applies the options to the TCP connection. Fields with the
+// zero value leave the corresponding option unchanged.
+func (c *Conn) SetTCPOptions(o TCPOptions) error {
+	tc, err := c.tcpConn()
+	if err != nil {
+		return err
+	}
+	if o.DelayWrites {
+		if err := tc.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if o.KeepAlive != 0 || o.KeepAliveInterval != 0 || o.KeepAliveCount != 0 {
+		config := net.KeepAliveConfig{
+			Enable:   o.KeepAlive >= 0,
+			Idle:     o.KeepAlive,
+			Interval: o.KeepAliveInterval,
+			Count:    o.KeepAliveCount,
+		}
+		if config.Idle < 0 {
+			config.Idle = 0
+		}
+		if err := tc.SetKeepAliveConfig(config); err != nil {
+			return err
+		}
+	}
+	return c.SetSocketBuffers(o.SendBufferSize, o.ReceiveBufferSize)
+}
+
+// applyTCPOptions applies the options of an Upgrader or Dialer. The options
+// are best effort: connections that are not TCP and errors setting the
+// socket options are ignored.
+func (c *Conn) applyTCPOptions(o TCPOptions) {
+	if o != (TCPOptions{}) {
+		_ = c.SetTCPOptions(o)
+	}
+}
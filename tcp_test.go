@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPOptions(t *testing.T) {
+	servers, clients := newTCPConnSet(t, 1)
+	for _, c := range []*Conn{servers[0], clients[0]} {
+		if err := c.SetNoDelay(false); err != nil {
+			t.Errorf("SetNoDelay: %v", err)
+		}
+		if err := c.SetKeepAlive(net.KeepAliveConfig{Enable: true, Idle: time.Minute}); err != nil {
+			t.Errorf("SetKeepAlive: %v", err)
+		}
+		if err := c.SetSocketBuffers(64<<10, 64<<10); err != nil {
+			t.Errorf("SetSocketBuffers: %v", err)
+		}
+		o := TCPOptions{DelayWrites: true, KeepAlive: -1, SendBufferSize: 32 << 10}
+		if err := c.SetTCPOptions(o); err != nil {
+			t.Errorf("SetTCPOptions: %v", err)
+		}
+	}
+	if err := clients[0].WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, servers[0]); got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+
+	s, _ := newPipeConns()
+	if err := s.SetNoDelay(true); err != ErrNotTCP {
+		t.Errorf("SetNoDelay on a pipe returned %v, want %v", err, ErrNotTCP)
+	}
+	var nilConn *Conn
+	if err := nilConn.SetTCPOptions(TCPOptions{}); err != ErrNilConn {
+		t.Errorf("SetTCPOptions on nil Conn returned %v", err)
+	}
+}
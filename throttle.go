@@ -0,0 +1,261 @@
+package websocket
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bandwidth specifies the bandwidth and the latency of a Throttle.
+type Bandwidth struct {
+	// Upload and Download are the rates in bytes per second of the data
+	// written to and read from the network connections. A zero rate is
+	// unlimited.
+	Upload, Download float64
+
+	// Burst is the number of bytes that can be transferred at once in each
+	// direction. If zero, the burst is one second at the rate.
+	Burst int
+
+	// Latency delays the data written before it is sent to the network,
+	// which adds to the round trip time of the connections.
+	Latency time.Duration
+}
+
+// Throttle shapes the traffic of network connections with token buckets.
+// The connections wrapped by a Throttle share its bandwidth: use a Throttle
+// per connection to limit each connection, or a Throttle per tenant to cap
+// the throughput of all connections of the tenant. Set Upgrader.Throttle to
+// throttle the connections created by Upgrade, and wrap the connections
+// returned by Dialer.NetDialContext to throttle clients. A Throttle is safe
+// for concurrent use.
+type Throttle struct {
+	latency  time.Duration
+	upload   *bandwidthBucket
+	download *bandwidthBucket
+}
+
+// bandwidthBucket is a token bucket shared by concurrent connections.
+type bandwidthBucket struct {
+	mu     sync.Mutex
+	bucket *tokenBucket
+	chunk  int // the largest transfer charged at once
+}
+
+func newBandwidthBucket(rate float64, burst int) *bandwidthBucket {
+	b := newTokenBucket(rate, burst)
+	if b == nil {
+		return nil
+	}
+	return &bandwidthBucket{bucket: b, chunk: max(int(b.burst), 1)}
+}
+
+// reserve charges n bytes and returns the time to wait before transferring
+// them. If the wait ends after the deadline, reserve charges nothing and
+// returns false.
+func (b *bandwidthBucket) reserve(n int, deadline time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.bucket.advance(now)
+	d := b.bucket.delay(float64(n))
+	if !deadline.IsZero() && now.Add(d).After(deadline) {
+		return 0, false
+	}
+	b.bucket.take(float64(n))
+	return d, true
+}
+
+// NewThrottle returns a throttle with the bandwidth.
+func NewThrottle(b Bandwidth) *Throttle {
+	return &Throttle{
+		latency:  b.Latency,
+		upload:   newBandwidthBucket(b.Upload, b.Burst),
+		download: newBandwidthBucket(b.Download, b.Burst),
+	}
+}
+
+// Conn returns conn throttled by t.
+func (t *Throttle) Conn(conn net.Conn) net.Conn {
+	tc := &throttledConn{Conn: conn, t: t, done: make(chan struct{})}
+	if t.latency > 0 {
+		tc.queue = make(chan delayedWrite, 64)
+		tc.flushed = make(chan struct{})
+		go tc.sendDelayed()
+	}
+	return tc
+}
+
+type delayedWrite struct {
+	p   []byte
+	due time.Time
+}
+
+// throttledConn is a net.Conn returned by Throttle.Conn.
+type throttledConn struct {
+	net.Conn
+	t *Throttle
+
+	writeDeadline atomic.Pointer[time.Time]
+
+	queue     chan delayedWrite // written data waiting for the latency
+	err       atomic.Pointer[error]
+	done      chan struct{} // closed by Close
+	flushed   chan struct{} // closed when the queue is flushed
+	closeOnce sync.Once
+}
+
+// wait sleeps for d or until the connection is closed.
+func (c *throttledConn) wait(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-c.done:
+		return net.ErrClosed
+	}
+}
+
+func deadlineOf(p *atomic.Pointer[time.Time]) time.Time {
+	if t := p.Load(); t != nil {
+		return *t
+	}
+	return time.Time{}
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	b := c.t.download
+	if b == nil {
+		return c.Conn.Read(p)
+	}
+	if len(p) > b.chunk {
+		p = p[:b.chunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		// The data is held until the bucket allows it, even past the read
+		// deadline: it has been read from the network.
+		d, _ := b.reserve(n, time.Time{})
+		if werr := c.wait(d); werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		chunk := p[n:]
+		if b := c.t.upload; b != nil {
+			if len(chunk) > b.chunk {
+				chunk = chunk[:b.chunk]
+			}
+			d, ok := b.reserve(len(chunk), deadlineOf(&c.writeDeadline))
+			if !ok {
+				return n, os.ErrDeadlineExceeded
+			}
+			if err := c.wait(d); err != nil {
+				return n, err
+			}
+		}
+		if err := c.send(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// send writes p to the network, after the latency if set.
+func (c *throttledConn) send(p []byte) error {
+	if c.queue == nil {
+		_, err := c.Conn.Write(p)
+		return err
+	}
+	select {
+	case <-c.done:
+		return net.ErrClosed
+	default:
+	}
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+	w := delayedWrite{p: append([]byte(nil), p...), due: time.Now().Add(c.t.latency)}
+	select {
+	case c.queue <- w:
+		return nil
+	case <-c.done:
+		return net.ErrClosed
+	}
+}
+
+// sendDelayed writes the queued data when it is due. After Close, it writes
+// the data queued before the connection is closed.
+func (c *throttledConn) sendDelayed() {
+	defer close(c.flushed)
+	write := func(w delayedWrite) {
+		if c.err.Load() != nil {
+			return
+		}
+		time.Sleep(time.Until(w.due))
+		if _, err := c.Conn.Write(w.p); err != nil {
+			c.err.Store(&err)
+		}
+	}
+	for {
+		select {
+		case w := <-c.queue:
+			write(w)
+		case <-c.done:
+			for {
+				select {
+				case w := <-c.queue:
+					write(w)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close closes the connection after sending the data delayed by the latency,
+// such as a close message.
+func (c *throttledConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.flushed != nil {
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(c.t.latency + writeWait))
+			<-c.flushed
+		}
+	})
+	return c.Conn.Close()
+}
+
+func (c *throttledConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of the writes. With a latency, the
+// deadline applies to the wait for the bandwidth: the delayed data is written
+// to the network in the background.
+func (c *throttledConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(&t)
+	if c.queue != nil {
+		return nil
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// NetConn returns the wrapped connection.
+func (c *throttledConn) NetConn() net.Conn { return c.Conn }
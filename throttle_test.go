@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTCPPair returns the ends of a loopback TCP connection.
+func newTCPPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestThrottleUpload(t *testing.T) {
+	a, b := newTCPPair(t)
+	// The burst is sent at once and the rest at 64 KiB/s.
+	w := NewThrottle(Bandwidth{Upload: 64 << 10, Burst: 8 << 10}).Conn(a)
+	read := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, b)
+		read <- int(n)
+	}()
+	start := time.Now()
+	if n, err := w.Write(make([]byte, 40<<10)); n != 40<<10 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 2*time.Second {
+		t.Errorf("40 KiB written in %v", d)
+	}
+
+	// A write that cannot complete before the deadline fails at once.
+	_ = w.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	start = time.Now()
+	if _, err := w.Write(make([]byte, 8<<10)); !isTimeout(err) {
+		t.Errorf("Write returned %v, want timeout", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Write failed after %v", d)
+	}
+	_ = w.Close()
+	if n := <-read; n != 40<<10 {
+		t.Errorf("peer read %d bytes", n)
+	}
+}
+
+func TestThrottleLatency(t *testing.T) {
+	a, b := newTCPPair(t)
+	w := NewThrottle(Bandwidth{Latency: 50 * time.Millisecond}).Conn(a)
+	start := time.Now()
+	_, _ = w.Write([]byte("hello"))
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Errorf("Write returned after %v", d)
+	}
+	p := make([]byte, 5)
+	if _, err := io.ReadFull(b, p); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("data arrived after %v", d)
+	}
+
+	// Close sends the delayed data first.
+	_, _ = w.Write([]byte("bye"))
+	_ = w.Close()
+	if got, _ := io.ReadAll(b); string(got) != "bye" {
+		t.Errorf("read %q after Close", got)
+	}
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("Write after Close returned no error")
+	}
+}
+
+func TestUpgraderThrottle(t *testing.T) {
+	tenant := NewThrottle(Bandwidth{Download: 32 << 10, Burst: 4 << 10})
+	u := Upgrader{Throttle: func(r *http.Request) *Throttle {
+		if r.URL.Query().Get("tenant") == "" {
+			return nil
+		}
+		return tenant
+	}}
+	received := make(chan time.Duration, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		if _, ok := c.UnderlyingConn().(*throttledConn); ok != (r.URL.Query().Get("tenant") != "") {
+			t.Errorf("throttled %v for %s", ok, r.URL)
+		}
+		start := time.Now()
+		_, p, err := c.ReadMessage()
+		if err != nil || !bytes.Equal(p, make([]byte, 20<<10)) {
+			t.Errorf("ReadMessage = %d bytes, %v", len(p), err)
+		}
+		received <- time.Since(start)
+	}))
+	defer s.Close()
+
+	for _, query := range []string{"", "?tenant=a"} {
+		c, _, err := DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = c.WriteMessage(BinaryMessage, make([]byte, 20<<10))
+		d := <-received
+		c.Close()
+		if query != "" && d < 400*time.Millisecond {
+			t.Errorf("20 KiB received in %v at 32 KiB/s", d)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errTimeSyncEnabled is returned when time synchronization is enabled twice.
+var errTimeSyncEnabled = errors.New("websocket: time sync already enabled")
+
+// timeSyncMagic starts the payload of the pings and pongs of the time
+// synchronization exchange.
+var timeSyncMagic = []byte("wsts")
+
+// timeSyncPayloadLen is the length of the payloads: the magic followed by
+// three timestamps or durations in nanoseconds.
+const timeSyncPayloadLen = 4 + 3*8
+
+// timeSyncSamples is the number of recent samples from which the offset of
+// the sample with the smallest round trip time is selected.
+const timeSyncSamples = 8
+
+// timeSync estimates the offset of the peer clock with an NTP-like exchange
+// of pings and pongs.
+//
+// The initiator sends a ping holding its transmit time t1 and its current
+// estimate, and the responder replies with a pong holding t1, its receive
+// time t2 and its transmit time t3. With the receive time t4 of the pong,
+// the initiator computes the round trip time (t4-t1)-(t3-t2) and the offset
+// ((t2-t1)+(t3-t4))/2. A responder that does not initiate the exchange
+// itself adopts the estimate carried by the pings of the peer.
+type timeSync struct {
+	c        *Conn
+	interval time.Duration
+	pending  atomic.Int64 // t1 of the outstanding ping in Unix nanoseconds
+
+	mu      sync.Mutex
+	samples [timeSyncSamples]timeSample
+	n       int // number of samples received
+
+	offset atomic.Int64
+	rtt    atomic.Int64
+}
+
+type timeSample struct {
+	offset, rtt time.Duration
+}
+
+// EnableTimeSync enables the estimation of the offset between the clocks of
+// the connection and the peer, as needed by games and other applications
+// compensating for lag. The connection replies to the time synchronization
+// pings of the peer and, if interval is positive, sends one to the peer
+// every interval, starting immediately. The estimate is reported by
+// TimeSync.
+//
+// Both ends of the connection must enable time synchronization. It is enough
+// for one end, typically the client, to send pings: the other end adopts the
+// estimate carried by these pings, one interval late. A peer that does not
+// enable time synchronization replies to the pings with ordinary pongs,
+// which are ignored.
+//
+// Pings and pongs are processed by the read methods, so the application
+// must read the connection as described in the section on Control Messages
+// in the package documentation. The time synchronization pings of the peer
+// are not passed to the ping handler.
+//
+// EnableTimeSync must be called at most once for a connection.
+func (c *Conn) EnableTimeSync(interval time.Duration) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if c.timeSync != nil {
+		return errTimeSyncEnabled
+	}
+	ts := &timeSync{c: c, interval: interval}
+	c.timeSync = ts
+	if interval > 0 {
+		go ts.run()
+	}
+	return nil
+}
+
+// TimeSync returns the estimated offset of the peer clock, which is ahead of
+// the local clock by offset, and the round trip time of the last exchange.
+// The offset is taken from the recent exchange with the smallest round trip
+// time, which is the least affected by queuing delays. TimeSync returns ok
+// false until an exchange completes.
+//
+// A time t of the peer corresponds to the local time t.Add(-offset).
+func (c *Conn) TimeSync() (offset, rtt time.Duration, ok bool) {
+	if c == nil || c.timeSync == nil {
+		return 0, 0, false
+	}
+	rtt = time.Duration(c.timeSync.rtt.Load())
+	if rtt == 0 {
+		return 0, 0, false
+	}
+	return time.Duration(c.timeSync.offset.Load()), rtt, true
+}
+
+func (ts *timeSync) run() {
+	ticker := time.NewTicker(ts.interval)
+	defer ticker.Stop()
+
+	for {
+		t1 := time.Now().UnixNano()
+		var payload [timeSyncPayloadLen]byte
+		copy(payload[:], timeSyncMagic)
+		binary.BigEndian.PutUint64(payload[4:], uint64(t1))
+		binary.BigEndian.PutUint64(payload[12:], uint64(ts.offset.Load()))
+		binary.BigEndian.PutUint64(payload[20:], uint64(ts.rtt.Load()))
+		ts.pending.Store(t1)
+		if err := ts.c.WriteControl(PingMessage, payload[:], time.Now().Add(writeWait)); err != nil {
+			return
+		}
+
+		select {
+		case <-ts.c.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// receivePing replies to a time synchronization ping and reports whether
+// the payload was one.
+func (ts *timeSync) receivePing(payload []byte) bool {
+	if len(payload) != timeSyncPayloadLen || !bytes.HasPrefix(payload, timeSyncMagic) {
+		return false
+	}
+	t2 := time.Now().UnixNano()
+	if ts.interval <= 0 {
+		// Adopt the estimate of the peer, from the other side.
+		if rtt := int64(binary.BigEndian.Uint64(payload[20:])); rtt > 0 {
+			ts.offset.Store(-int64(binary.BigEndian.Uint64(payload[12:])))
+			ts.rtt.Store(rtt)
+		}
+	}
+	var reply [timeSyncPayloadLen]byte
+	copy(reply[:], payload[:12])
+	binary.BigEndian.PutUint64(reply[12:], uint64(t2))
+	binary.BigEndian.PutUint64(reply[20:], uint64(time.Now().UnixNano()))
+	// Make a best effort to send the pong message.
+	_ = ts.c.WriteControl(PongMessage, reply[:], time.Now().Add(writeWait))
+	return true
+}
+
+// receivePong is called by the read methods for every pong received.
+func (ts *timeSync) receivePong(payload []byte) {
+	if len(payload) != timeSyncPayloadLen || !bytes.HasPrefix(payload, timeSyncMagic) {
+		return
+	}
+	t4 := time.Now().UnixNano()
+	t1 := int64(binary.BigEndian.Uint64(payload[4:]))
+	if t1 == 0 || !ts.pending.CompareAndSwap(t1, 0) {
+		// Not a reply to the outstanding ping.
+		return
+	}
+	t2 := int64(binary.BigEndian.Uint64(payload[12:]))
+	t3 := int64(binary.BigEndian.Uint64(payload[20:]))
+	rtt := max(time.Duration((t4-t1)-(t3-t2)), 1)
+	offset := time.Duration(((t2 - t1) + (t3 - t4)) / 2)
+
+	ts.mu.Lock()
+	ts.samples[ts.n%timeSyncSamples] = timeSample{offset: offset, rtt: rtt}
+	ts.n++
+	best := ts.samples[0]
+	for _, s := range ts.samples[1:min(ts.n, timeSyncSamples)] {
+		if s.rtt < best.rtt {
+			best = s
+		}
+	}
+	ts.offset.Store(int64(best.offset))
+	ts.rtt.Store(int64(rtt))
+	ts.mu.Unlock()
+}
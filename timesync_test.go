@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeSync(t *testing.T) {
+	s, c := newTCPConns(t)
+	defer s.Close()
+	defer c.Close()
+
+	var pings atomic.Int32
+	s.SetPingHandler(func(string) error {
+		pings.Add(1)
+		return nil
+	})
+	for _, conn := range []*Conn{s, c} {
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	if err := s.EnableTimeSync(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableTimeSync(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableTimeSync(time.Second); err != errTimeSyncEnabled {
+		t.Errorf("second EnableTimeSync returned %v, want %v", err, errTimeSyncEnabled)
+	}
+
+	// The server adopts the estimate of the client from the next ping.
+	deadline := time.Now().Add(time.Second)
+	for _, conn := range []*Conn{c, s} {
+		for {
+			if _, _, ok := conn.TimeSync(); ok || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		offset, rtt, ok := conn.TimeSync()
+		if !ok || rtt <= 0 || offset < -rtt || offset > rtt {
+			t.Errorf("TimeSync() of server %v = %v, %v, %v, want an offset within the round trip time", conn.isServer, offset, rtt, ok)
+		}
+	}
+	if n := pings.Load(); n != 0 {
+		t.Errorf("ping handler called %d times for time sync pings", n)
+	}
+}
+
+func TestTimeSyncOffset(t *testing.T) {
+	c := newTestConn(nil, nil, false)
+	ts := &timeSync{c: c}
+	c.timeSync = ts
+	if _, _, ok := c.TimeSync(); ok {
+		t.Fatal("TimeSync() ok before an exchange")
+	}
+
+	// The peer clock is 5 seconds ahead, with 10ms of delay each way and 2ms
+	// spent by the peer.
+	now := time.Now()
+	pong := func(rtt time.Duration) []byte {
+		t1 := now.Add(-rtt - 2*time.Millisecond)
+		t2 := t1.Add(5*time.Second + rtt/2)
+		t3 := t2.Add(2 * time.Millisecond)
+		ts.pending.Store(t1.UnixNano())
+		var p [timeSyncPayloadLen]byte
+		copy(p[:], timeSyncMagic)
+		binary.BigEndian.PutUint64(p[4:], uint64(t1.UnixNano()))
+		binary.BigEndian.PutUint64(p[12:], uint64(t2.UnixNano()))
+		binary.BigEndian.PutUint64(p[20:], uint64(t3.UnixNano()))
+		return p[:]
+	}
+	ts.receivePong(pong(20 * time.Millisecond))
+	offset, rtt, ok := c.TimeSync()
+	if !ok || offset < 4990*time.Millisecond || offset > 5010*time.Millisecond || rtt < 20*time.Millisecond {
+		t.Errorf("TimeSync() = %v, %v, %v, want an offset of 5s and a round trip time of 20ms", offset, rtt, ok)
+	}
+
+	// A reply that is not to the outstanding ping is ignored.
+	p := pong(time.Millisecond)
+	ts.pending.Store(0)
+	ts.receivePong(p)
+	if _, rtt, _ := c.TimeSync(); rtt < 20*time.Millisecond {
+		t.Errorf("TimeSync() reports a round trip time of %v after an unexpected pong", rtt)
+	}
+}
@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Names of the transports served by TransportServer.
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+	TransportPolling   = "polling"
+)
+
+// Transport is a way of carrying messages between a client and a
+// TransportServer. The WebSocket transport upgrades the request; the
+// fallback transports emulate a WebSocket connection over plain HTTP
+// requests for clients behind proxies that do not support WebSocket.
+//
+// The connections of all transports are served by the same handler as a
+// *Conn, so hubs, codecs and other code written for WebSocket connections
+// work with every transport.
+type Transport interface {
+	// Name returns the name used to select the transport with the
+	// "transport" query parameter.
+	Name() string
+
+	// ServeTransport handles a request of the transport. If the request
+	// opens a connection, ServeTransport accepts it with u and calls
+	// handler with the connection. The connection is closed when handler
+	// returns.
+	ServeTransport(w http.ResponseWriter, r *http.Request, u *Upgrader, handler func(*Conn))
+}
+
+// TransportServer serves connections over WebSocket and fallback
+// transports with a single handler.
+//
+// The client selects the transport with the "transport" query parameter. A
+// WebSocket upgrade request without the parameter uses the WebSocket
+// transport. Other requests without the parameter are answered with the
+// JSON object {"transports": [...]} listing the transport names in order of
+// preference, so that a client whose upgrade failed can negotiate a
+// fallback.
+type TransportServer struct {
+	// Upgrader accepts the connections of all transports. The fallback
+	// transports apply the origin check, Authenticate, ConnManager and
+	// Metrics of the Upgrader; the other fields are used by the WebSocket
+	// transport only.
+	Upgrader Upgrader
+
+	// Handler serves a connection. The connection is closed when Handler
+	// returns.
+	Handler func(c *Conn)
+
+	// Transports lists the transports in order of preference. If nil, the
+	// WebSocket, SSE and long-polling transports are used.
+	Transports []Transport
+
+	once     sync.Once
+	defaults []Transport
+}
+
+func (s *TransportServer) transports() []Transport {
+	if s.Transports != nil {
+		return s.Transports
+	}
+	s.once.Do(func() {
+		s.defaults = []Transport{WebSocketTransport{}, &SSETransport{}, &PollingTransport{}}
+	})
+	return s.defaults
+}
+
+// ServeHTTP dispatches the request to the selected transport.
+func (s *TransportServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts := s.transports()
+	name := r.URL.Query().Get("transport")
+	if name == "" {
+		if IsWebSocketUpgrade(r) {
+			name = TransportWebSocket
+		} else if isWebTransportRequest(r) {
+			name = TransportWebTransport
+		} else {
+			names := make([]string, len(ts))
+			for i, t := range ts {
+				names[i] = t.Name()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(map[string][]string{"transports": names})
+			return
+		}
+	}
+	for _, t := range ts {
+		if t.Name() == name {
+			t.ServeTransport(w, r, &s.Upgrader, s.serve)
+			return
+		}
+	}
+	http.Error(w, "websocket: unknown transport", http.StatusBadRequest)
+}
+
+func (s *TransportServer) serve(c *Conn) {
+	defer c.Close()
+	if s.Handler != nil {
+		s.Handler(c)
+	}
+}
+
+// Transport returns the name of the transport of the connection. The name is
+// TransportWebSocket unless the connection was accepted by a fallback
+// transport of a TransportServer or carried by WebTransport.
+func (c *Conn) Transport() string {
+	if c == nil || c.transport == "" {
+		return TransportWebSocket
+	}
+	return c.transport
+}
+
+// WebSocketTransport is the Transport that upgrades requests to the
+// WebSocket protocol.
+type WebSocketTransport struct{}
+
+// Name returns TransportWebSocket.
+func (WebSocketTransport) Name() string { return TransportWebSocket }
+
+// ServeTransport upgrades the request and calls handler with the
+// connection.
+func (WebSocketTransport) ServeTransport(w http.ResponseWriter, r *http.Request, u *Upgrader, handler func(*Conn)) {
+	c, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	handler(c)
+}
+
+// acceptVirtual checks the origin and admits a request opening a connection
+// of a fallback transport. On success, it returns the server side of a
+// WebSocket connection over an in-memory pipe and the client side that the
+// transport bridges to the HTTP requests of the client. On failure, the
+// error response has been written.
+func (u *Upgrader) acceptVirtual(w http.ResponseWriter, r *http.Request, transport string) (server, client *Conn, err error) {
+	if !u.checkOrigin(r) {
+		_, err = u.returnError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+		return nil, nil, err
+	}
+	adm, err := u.admit(w, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer adm.cancel()
+
+	sp, cp := net.Pipe()
+	local := net.Addr(streamAddr(r.Host))
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		local = addr
+	}
+	remote := streamAddr(r.RemoteAddr)
+	sc := &virtualConn{Conn: sp, local: local, remote: remote}
+	cc := &virtualConn{Conn: cp, local: remote, remote: local}
+
+	server = u.createWebSocketConnection(sc, "", nil, nil, nil)
+	server.transport = transport
+	client = newConn(cc, false, u.ReadBufferSize, u.WriteBufferSize, nil, nil, nil)
+
+	if err := u.upgradeSetup().complete(server, adm); err != nil {
+		_ = sp.Close()
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return nil, nil, err
+	}
+	return server, client, nil
+}
+
+// virtualConn is an end of the in-memory pipe of a fallback transport
+// connection, reporting the addresses of the HTTP connection.
+type virtualConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *virtualConn) LocalAddr() net.Addr  { return c.local }
+func (c *virtualConn) RemoteAddr() net.Addr { return c.remote }
+
+// newSessionID returns a random ID for a session of a fallback transport.
+// The ID authorizes the requests of the session.
+func newSessionID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,293 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollTimeout    = 25 * time.Second
+	defaultSessionTimeout = time.Minute
+	defaultPollQueueSize  = 1024
+)
+
+// PollingTransport is the Transport that carries messages over XHR long
+// polling, for clients behind middleboxes that break WebSocket and event
+// streams. The messages from the server are queued per session and
+// delivered until the client acknowledges them, so a poll response lost in
+// transit does not lose messages.
+//
+// A POST request with the query parameter transport=polling opens a
+// connection and is answered with the JSON object {"sid": "<session ID>"}.
+// The other requests of the session carry the query parameters
+// transport=polling and sid=<session ID>:
+//
+//   - A GET request polls for messages. The response is a JSON array of the
+//     queued messages, waiting up to PollTimeout for a message if the queue
+//     is empty. Each message is an object with the fields "seq", the
+//     sequence number, and "type": "text" and "binary" messages have the
+//     field "data", base64 encoded for binary messages; the final "close"
+//     message has the fields "code" and "reason". The query parameter
+//     ack=<seq> acknowledges the messages up to seq, which are not sent
+//     again.
+//   - A POST request sends the request body as a message: binary if the
+//     Content-Type is application/octet-stream, text otherwise. The response
+//     status is 204 No Content.
+//   - A DELETE request closes the connection with CloseGoingAway.
+//
+// Requests for a session that does not exist or is closed are answered with
+// status 404 Not Found. The connection is closed with CloseGoingAway when the
+// client does not poll for SessionTimeout, and the session ends when the
+// client acknowledges the close message.
+type PollingTransport struct {
+	// PollTimeout specifies how long a poll waits for messages. If zero, a
+	// default of 25 seconds is used.
+	PollTimeout time.Duration
+
+	// SessionTimeout specifies how long a session lives without a poll. If
+	// zero, a default of one minute is used.
+	SessionTimeout time.Duration
+
+	// QueueSize specifies the maximum number of unacknowledged messages of a
+	// session. When the queue is full, writes of the server side of the
+	// connection block until the client acknowledges messages. If zero, a
+	// default of 1024 is used.
+	QueueSize int
+
+	// MaxMessageSize specifies the maximum size in bytes of a message sent by
+	// the client. Larger messages are rejected with status 413 Request Entity
+	// Too Large. If zero, a default of 1 MiB is used.
+	MaxMessageSize int64
+
+	mu       sync.Mutex
+	sessions map[string]*pollSession
+}
+
+// pollMessage is a message of a poll response.
+type pollMessage struct {
+	Seq    uint64 `json:"seq"`
+	Type   string `json:"type"`
+	Data   string `json:"data,omitempty"`
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// pollSession is a long-polling session.
+type pollSession struct {
+	sid     string
+	client  *Conn      // client side of the connection
+	writeMu sync.Mutex // serializes writes to client
+	expiry  *time.Timer
+
+	mu      sync.Mutex
+	queue   []pollMessage // unacknowledged messages
+	seq     uint64        // sequence number of the last queued message
+	wake    chan struct{} // closed when the queue changes
+	polls   int           // number of active polls
+	closing bool          // close message queued
+	done    bool          // session ended
+}
+
+// Name returns TransportPolling.
+func (t *PollingTransport) Name() string { return TransportPolling }
+
+// ServeTransport opens sessions and serves the requests of a session.
+func (t *PollingTransport) ServeTransport(w http.ResponseWriter, r *http.Request, u *Upgrader, handler func(*Conn)) {
+	sid := r.URL.Query().Get("sid")
+	if sid == "" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		t.open(w, r, u, handler)
+		return
+	}
+
+	t.mu.Lock()
+	s := t.sessions[sid]
+	t.mu.Unlock()
+	if s == nil {
+		http.Error(w, "websocket: unknown session", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		t.poll(w, r, s)
+	case http.MethodPost:
+		postMessage(w, r, u, t.MaxMessageSize, &s.writeMu, s.client)
+	case http.MethodDelete:
+		if !u.checkOrigin(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		t.end(s)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (t *PollingTransport) open(w http.ResponseWriter, r *http.Request, u *Upgrader, handler func(*Conn)) {
+	server, client, err := u.acceptVirtual(w, r, TransportPolling)
+	if err != nil {
+		return
+	}
+	s := &pollSession{sid: newSessionID(), client: client, wake: make(chan struct{})}
+	s.expiry = time.AfterFunc(t.sessionTimeout(), func() { t.end(s) })
+	t.mu.Lock()
+	if t.sessions == nil {
+		t.sessions = make(map[string]*pollSession)
+	}
+	t.sessions[s.sid] = s
+	t.mu.Unlock()
+
+	go handler(server)
+	go t.pump(s)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]string{"sid": s.sid})
+}
+
+func (t *PollingTransport) sessionTimeout() time.Duration {
+	if t.SessionTimeout > 0 {
+		return t.SessionTimeout
+	}
+	return defaultSessionTimeout
+}
+
+// pump queues the messages written by the server side of the connection.
+func (t *PollingTransport) pump(s *pollSession) {
+	size := t.QueueSize
+	if size <= 0 {
+		size = defaultPollQueueSize
+	}
+	for {
+		mt, p, err := s.client.ReadMessage()
+		s.mu.Lock()
+		for !s.done && len(s.queue) >= size {
+			wake := s.wake
+			s.mu.Unlock()
+			<-wake
+			s.mu.Lock()
+		}
+		if s.done {
+			s.mu.Unlock()
+			return
+		}
+		s.seq++
+		m := pollMessage{Seq: s.seq}
+		switch {
+		case err != nil:
+			m.Type, m.Code, m.Reason = "close", CloseAbnormalClosure, ""
+			var ce *CloseError
+			if errors.As(err, &ce) {
+				m.Code, m.Reason = ce.Code, ce.Text
+			}
+			s.closing = true
+		case mt == BinaryMessage:
+			m.Type, m.Data = "binary", base64.StdEncoding.EncodeToString(p)
+		default:
+			m.Type, m.Data = "text", string(p)
+		}
+		s.queue = append(s.queue, m)
+		s.signal()
+		s.mu.Unlock()
+		if err != nil {
+			_ = s.client.Close()
+			return
+		}
+	}
+}
+
+// signal wakes the goroutines waiting for a change of the queue. The caller
+// must hold mu.
+func (s *pollSession) signal() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+func (t *PollingTransport) poll(w http.ResponseWriter, r *http.Request, s *pollSession) {
+	ack, _ := strconv.ParseUint(r.URL.Query().Get("ack"), 10, 64)
+	timeout := t.PollTimeout
+	if timeout <= 0 {
+		timeout = defaultPollTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	s.mu.Lock()
+	s.polls++
+	s.expiry.Stop()
+	n := 0
+	for n < len(s.queue) && s.queue[n].Seq <= ack {
+		n++
+	}
+	if n > 0 {
+		s.queue = append(s.queue[:0], s.queue[n:]...)
+		s.signal()
+	}
+	closed := s.closing && len(s.queue) == 0
+	for expired := false; !closed && !expired && !s.done && len(s.queue) == 0; {
+		wake := s.wake
+		s.mu.Unlock()
+		select {
+		case <-wake:
+		case <-timer.C:
+			expired = true
+		case <-r.Context().Done():
+			expired = true
+		}
+		s.mu.Lock()
+	}
+	msgs := append([]pollMessage{}, s.queue...)
+	s.polls--
+	if s.polls == 0 && !s.done {
+		s.expiry.Reset(t.sessionTimeout())
+	}
+	done := s.done
+	s.mu.Unlock()
+
+	if closed {
+		// The client acknowledged the close message.
+		t.end(s)
+	}
+	if closed || done {
+		http.Error(w, "websocket: session closed", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(msgs)
+}
+
+// end ends the session, closing the connection with CloseGoingAway unless it
+// is already closing.
+func (t *PollingTransport) end(s *pollSession) {
+	t.mu.Lock()
+	delete(t.sessions, s.sid)
+	t.mu.Unlock()
+
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.expiry.Stop()
+	closing := s.closing
+	s.signal()
+	s.mu.Unlock()
+
+	if !closing {
+		_ = s.client.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(time.Second))
+	}
+	_ = s.client.Close()
+}
@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pollClient is a long-polling client.
+type pollClient struct {
+	t   *testing.T
+	url string
+	ack uint64
+}
+
+func openPolling(t *testing.T, url string) *pollClient {
+	t.Helper()
+	resp, err := http.Post(url+"?transport=polling", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v struct{ SID string }
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil || v.SID == "" {
+		t.Fatalf("open response %v, %v", v, err)
+	}
+	return &pollClient{t: t, url: url + "?transport=polling&sid=" + v.SID}
+}
+
+// poll polls without acknowledging the messages received.
+func (c *pollClient) poll() ([]pollMessage, int) {
+	c.t.Helper()
+	resp, err := http.Get(c.url + "&ack=" + strconv.FormatUint(c.ack, 10))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode
+	}
+	var msgs []pollMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msgs); err != nil {
+		c.t.Fatal(err)
+	}
+	return msgs, resp.StatusCode
+}
+
+// expect polls until the messages are received and acknowledges them.
+func (c *pollClient) expect(want ...string) {
+	c.t.Helper()
+	var got []string
+	for len(got) < len(want) {
+		msgs, code := c.poll()
+		if code != http.StatusOK {
+			c.t.Fatalf("poll returned %d, want %q", code, want)
+		}
+		for _, m := range msgs {
+			got = append(got, m.Type+" "+m.Data+m.Reason)
+			c.ack = m.Seq
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		c.t.Fatalf("poll returned %q, want %q", got, want)
+	}
+}
+
+func (c *pollClient) send(contentType, body string) {
+	c.t.Helper()
+	if code := post(c.t, c.url, contentType, body); code != http.StatusNoContent {
+		c.t.Fatalf("POST status %d", code)
+	}
+}
+
+func TestPollingTransport(t *testing.T) {
+	s := echoTransportServer(t, &TransportServer{
+		Transports: []Transport{&PollingTransport{PollTimeout: 50 * time.Millisecond}},
+	})
+	c := openPolling(t, s.URL)
+
+	// A poll times out with no messages.
+	if msgs, code := c.poll(); code != http.StatusOK || len(msgs) != 0 {
+		t.Fatalf("empty poll returned %d %v", code, msgs)
+	}
+
+	c.send("text/plain", "a")
+	c.send("application/octet-stream", "\x00")
+	var msgs []pollMessage
+	for len(msgs) < 2 {
+		msgs, _ = c.poll()
+	}
+
+	// Unacknowledged messages are delivered again.
+	if again, _ := c.poll(); len(again) != 2 || again[0] != msgs[0] || again[1] != msgs[1] {
+		t.Fatalf("poll returned %v, then %v", msgs, again)
+	}
+	c.expect("text polling:a", "binary cG9sbGluZzoA")
+	if msgs, _ := c.poll(); len(msgs) != 0 {
+		t.Fatalf("acknowledged messages delivered again: %v", msgs)
+	}
+}
+
+func TestPollingTransportClose(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	closed := make(chan error, 1)
+	s := echoTransportServer(t, &TransportServer{
+		Transports: []Transport{&PollingTransport{PollTimeout: time.Second, SessionTimeout: 50 * time.Millisecond}},
+		Handler: func(c *Conn) {
+			conns <- c
+			_, _, err := c.ReadMessage()
+			closed <- err
+		},
+	})
+	expectClosed := func(code int) {
+		t.Helper()
+		select {
+		case err := <-closed:
+			if !IsCloseError(err, code) {
+				t.Fatalf("handler read %v, want close %d", err, code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection not closed")
+		}
+	}
+
+	// Server close.
+	c := openPolling(t, s.URL)
+	sc := <-conns
+	if sc.Transport() != TransportPolling {
+		t.Fatalf("transport %q", sc.Transport())
+	}
+	if err := sc.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, "bye"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	c.expect("close bye")
+	expectClosed(CloseNormalClosure)
+	if _, code := c.poll(); code != http.StatusNotFound {
+		t.Fatalf("poll after close acknowledged: status %d", code)
+	}
+
+	// Client close.
+	c = openPolling(t, s.URL)
+	<-conns
+	req, _ := http.NewRequest(http.MethodDelete, c.url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	expectClosed(CloseGoingAway)
+	if _, code := c.poll(); code != http.StatusNotFound {
+		t.Fatalf("poll after DELETE: status %d", code)
+	}
+
+	// Session timeout.
+	openPolling(t, s.URL)
+	<-conns
+	expectClosed(CloseGoingAway)
+}
+
+func TestPollingTransportQueueSize(t *testing.T) {
+	wrote := make(chan int, 4)
+	s := echoTransportServer(t, &TransportServer{
+		Transports: []Transport{&PollingTransport{QueueSize: 2}},
+		Handler: func(c *Conn) {
+			for i := 0; i < 4; i++ {
+				if err := c.WriteMessage(TextMessage, []byte(strconv.Itoa(i))); err != nil {
+					return
+				}
+				wrote <- i
+			}
+			_, _, _ = c.ReadMessage()
+		},
+	})
+	c := openPolling(t, s.URL)
+	for i := 0; i < 2; i++ {
+		<-wrote
+	}
+	select {
+	case i := <-wrote:
+		// The pump holds one message while waiting for room.
+		if i != 2 {
+			t.Fatalf("wrote message %d", i)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pump did not read a message")
+	}
+	select {
+	case i := <-wrote:
+		t.Fatalf("wrote message %d to a full queue", i)
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.expect("text 0", "text 1")
+	c.expect("text 2", "text 3")
+}
@@ -0,0 +1,233 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSSEPingInterval    = 25 * time.Second
+	defaultFallbackMaxMessage = 1 << 20
+)
+
+// SSETransport is the Transport that carries messages from the server to the
+// client as Server-Sent Events and from the client to the server as POST
+// requests. Browsers implement the client with EventSource and fetch.
+//
+// A GET request with the query parameter transport=sse opens a connection.
+// The response is an event stream that starts with an "open" event whose
+// data is the JSON object {"sid": "<session ID>"}. Text messages are sent as
+// "message" events, one data line per line of the message. Binary messages
+// are sent as "binary" events with base64 data, and text messages containing
+// carriage returns, which events cannot carry, as "text" events with base64
+// data. When the connection closes, a "close" event with the JSON data
+// {"code": <close code>, "reason": "<reason>"} ends the stream. The
+// connection is closed with CloseGoingAway when the client ends the request.
+//
+// A POST request with the query parameters transport=sse and
+// sid=<session ID> sends the request body as a message: binary if the
+// Content-Type is application/octet-stream, text otherwise. The response
+// status is 204 No Content, or 404 Not Found if the session does not exist or
+// is closed.
+type SSETransport struct {
+	// PingInterval specifies the interval of the comments sent to keep the
+	// event stream open through proxies. If zero, a default of 25 seconds is
+	// used.
+	PingInterval time.Duration
+
+	// MaxMessageSize specifies the maximum size in bytes of a message sent by
+	// the client. Larger messages are rejected with status 413 Request Entity
+	// Too Large. If zero, a default of 1 MiB is used.
+	MaxMessageSize int64
+
+	mu       sync.Mutex
+	sessions map[string]*sseSession
+}
+
+// sseSession is an open event stream.
+type sseSession struct {
+	client *Conn      // client side of the connection
+	mu     sync.Mutex // serializes writes to client
+}
+
+// Name returns TransportSSE.
+func (t *SSETransport) Name() string { return TransportSSE }
+
+// ServeTransport opens an event stream for GET requests and delivers the
+// messages of POST requests.
+func (t *SSETransport) ServeTransport(w http.ResponseWriter, r *http.Request, u *Upgrader, handler func(*Conn)) {
+	switch r.Method {
+	case http.MethodGet:
+		t.open(w, r, u, handler)
+	case http.MethodPost:
+		t.mu.Lock()
+		s := t.sessions[r.URL.Query().Get("sid")]
+		t.mu.Unlock()
+		if s == nil {
+			http.Error(w, "websocket: unknown session", http.StatusNotFound)
+			return
+		}
+		postMessage(w, r, u, t.MaxMessageSize, &s.mu, s.client)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (t *SSETransport) open(w http.ResponseWriter, r *http.Request, u *Upgrader, handler func(*Conn)) {
+	server, client, err := u.acceptVirtual(w, r, TransportSSE)
+	if err != nil {
+		return
+	}
+	sid := newSessionID()
+	t.mu.Lock()
+	if t.sessions == nil {
+		t.sessions = make(map[string]*sseSession)
+	}
+	t.sessions[sid] = &sseSession{client: client}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.sessions, sid)
+		t.mu.Unlock()
+	}()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// The stream outlives the write timeout of the server.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	var mu sync.Mutex // serializes writes to w
+	write := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = io.WriteString(w, s)
+		_ = rc.Flush()
+	}
+
+	open, _ := json.Marshal(map[string]string{"sid": sid})
+	write(sseEvent("open", string(open)))
+
+	go handler(server)
+
+	stop := context.AfterFunc(r.Context(), func() {
+		_ = client.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(time.Second))
+		_ = client.Close()
+	})
+	defer stop()
+
+	interval := t.PingInterval
+	if interval <= 0 {
+		interval = defaultSSEPingInterval
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				write(":\n\n")
+			}
+		}
+	}()
+
+	for {
+		mt, p, err := client.ReadMessage()
+		if err != nil {
+			write(sseEvent("close", closeEventData(err)))
+			_ = client.Close()
+			return
+		}
+		switch {
+		case mt == BinaryMessage:
+			write(sseEvent("binary", base64.StdEncoding.EncodeToString(p)))
+		case strings.IndexByte(string(p), '\r') >= 0:
+			write(sseEvent("text", base64.StdEncoding.EncodeToString(p)))
+		default:
+			write(sseEvent("message", string(p)))
+		}
+	}
+}
+
+// sseEvent formats an event. The data must not contain carriage returns.
+func sseEvent(event, data string) string {
+	var b strings.Builder
+	if event != "message" {
+		b.WriteString("event: ")
+		b.WriteString(event)
+		b.WriteByte('\n')
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// closeEventData returns the JSON data of the close event for the error
+// that ended the connection.
+func closeEventData(err error) string {
+	code, reason := CloseAbnormalClosure, ""
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		code, reason = ce.Code, ce.Text
+	}
+	p, _ := json.Marshal(struct {
+		Code   int    `json:"code"`
+		Reason string `json:"reason"`
+	}{code, reason})
+	return string(p)
+}
+
+// postMessage writes the body of a POST request of a fallback transport as
+// a message to the client side of the connection.
+func postMessage(w http.ResponseWriter, r *http.Request, u *Upgrader, limit int64, mu *sync.Mutex, client *Conn) {
+	if !u.checkOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if limit <= 0 {
+		limit = defaultFallbackMaxMessage
+	}
+	p, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		}
+		return
+	}
+	mt := TextMessage
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/octet-stream" {
+		mt = BinaryMessage
+	}
+
+	mu.Lock()
+	err = client.WriteMessageContext(r.Context(), mt, p)
+	mu.Unlock()
+	if err != nil {
+		http.Error(w, "websocket: session closed", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
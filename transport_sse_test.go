@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseClient reads an event stream.
+type sseClient struct {
+	t    *testing.T
+	resp *http.Response
+	br   *bufio.Reader
+}
+
+func openSSE(t *testing.T, url string) *sseClient {
+	t.Helper()
+	resp, err := http.Get(url + "?transport=sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return &sseClient{t: t, resp: resp, br: bufio.NewReader(resp.Body)}
+}
+
+// next returns the next event, skipping comments.
+func (c *sseClient) next() (event, data string) {
+	c.t.Helper()
+	var lines []string
+	event = "message"
+	for {
+		line, err := c.br.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if lines == nil {
+				continue
+			}
+			return event, strings.Join(lines, "\n")
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event: "):
+			event = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			lines = append(lines, line[len("data: "):])
+		default:
+			c.t.Fatalf("unexpected line %q", line)
+		}
+	}
+}
+
+func (c *sseClient) expect(event, data string) {
+	c.t.Helper()
+	if e, d := c.next(); e != event || d != data {
+		c.t.Fatalf("got event %q data %q, want %q %q", e, d, event, data)
+	}
+}
+
+func post(t *testing.T, url, contentType, body string) int {
+	t.Helper()
+	resp, err := http.Post(url, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSSETransport(t *testing.T) {
+	s := echoTransportServer(t, &TransportServer{})
+	c := openSSE(t, s.URL)
+	event, data := c.next()
+	var open struct{ SID string }
+	if err := json.Unmarshal([]byte(data), &open); event != "open" || err != nil || open.SID == "" {
+		t.Fatalf("open event %q %q", event, data)
+	}
+	postURL := s.URL + "?transport=sse&sid=" + open.SID
+
+	if code := post(t, postURL, "text/plain", "a\nb"); code != http.StatusNoContent {
+		t.Fatalf("POST status %d", code)
+	}
+	c.expect("message", "sse:a\nb")
+	post(t, postURL, "text/plain", "c\r\n")
+	c.expect("text", "c3NlOmMNCg==")
+	post(t, postURL, "application/octet-stream", "\x00\x01")
+	c.expect("binary", "c3NlOgAB")
+
+	if code := post(t, s.URL+"?transport=sse&sid=nope", "text/plain", "x"); code != http.StatusNotFound {
+		t.Fatalf("POST to unknown session: status %d", code)
+	}
+	if code := post(t, postURL, "text/plain", strings.Repeat("x", defaultFallbackMaxMessage+1)); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("POST of large message: status %d", code)
+	}
+}
+
+func TestSSETransportClose(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	closed := make(chan error, 1)
+	s := echoTransportServer(t, &TransportServer{
+		Transports: []Transport{&SSETransport{PingInterval: 10 * time.Millisecond}},
+		Handler: func(c *Conn) {
+			conns <- c
+			_, _, err := c.ReadMessage()
+			closed <- err
+		},
+	})
+
+	// Server close.
+	c := openSSE(t, s.URL)
+	c.next()
+	sc := <-conns
+	if sc.Transport() != TransportSSE || sc.RemoteAddr().String() == "pipe" {
+		t.Fatalf("transport %q, remote address %v", sc.Transport(), sc.RemoteAddr())
+	}
+	if err := sc.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, "bye"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	c.expect("close", `{"code":1000,"reason":"bye"}`)
+	if err := <-closed; !IsCloseError(err, CloseNormalClosure) {
+		t.Fatalf("handler read %v, want close error", err)
+	}
+
+	// Client close.
+	c = openSSE(t, s.URL)
+	c.next()
+	<-conns
+	c.resp.Body.Close()
+	select {
+	case err := <-closed:
+		if !IsCloseError(err, CloseGoingAway) {
+			t.Fatalf("handler read %v, want going away", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after the event stream ended")
+	}
+}
+
+func TestSSETransportOrigin(t *testing.T) {
+	s := echoTransportServer(t, &TransportServer{})
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"?transport=sse", nil)
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross origin request: status %d", resp.StatusCode)
+	}
+}
+
+func TestSSETransportAudit(t *testing.T) {
+	var sink eventRecorder
+	conns := make(chan *Conn, 1)
+	s := echoTransportServer(t, &TransportServer{
+		Upgrader: Upgrader{
+			EventSink:    &sink,
+			Authenticate: func(r *http.Request) (interface{}, error) { return "alice", nil },
+		},
+		Handler: func(c *Conn) {
+			conns <- c
+			c.ReadMessage()
+		},
+	})
+	c := openSSE(t, s.URL)
+	c.next()
+	<-conns
+	if got := strings.Join(sink.kinds(), ","); got != "authenticate,connect" {
+		t.Fatalf("events %s, want authenticate,connect", got)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, e := range sink.events {
+		if e.User != "alice" {
+			t.Errorf("%s event of user %q, want alice", e.Kind, e.User)
+		}
+	}
+}
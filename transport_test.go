@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoTransportServer starts the server. Unless the server has a handler, it
+// echoes the messages of all transports, prefixed with the transport name.
+func echoTransportServer(t *testing.T, srv *TransportServer) *httptest.Server {
+	if srv.Handler != nil {
+		s := httptest.NewServer(srv)
+		t.Cleanup(s.Close)
+		return s
+	}
+	srv.Handler = func(c *Conn) {
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, append([]byte(c.Transport()+":"), p...)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+	s := httptest.NewServer(srv)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestTransportServerNegotiation(t *testing.T) {
+	s := echoTransportServer(t, &TransportServer{})
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ Transports []string }
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	resp.Body.Close()
+	if err != nil || strings.Join(v.Transports, ",") != "websocket,sse,polling" {
+		t.Fatalf("negotiation response %v, %v", v, err)
+	}
+
+	resp, err = http.Get(s.URL + "?transport=carrier-pigeon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown transport: status %d", resp.StatusCode)
+	}
+}
+
+func TestTransportServerWebSocket(t *testing.T) {
+	s := echoTransportServer(t, &TransportServer{})
+	for _, query := range []string{"", "?transport=websocket"} {
+		c, _, err := DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WriteMessage(TextMessage, []byte("hi")); err != nil {
+			t.Fatal(err)
+		}
+		if got := readString(t, c); got != "websocket:hi" {
+			t.Fatalf("got %q", got)
+		}
+		c.Close()
+	}
+}
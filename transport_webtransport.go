@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TransportWebTransport is the name of the WebTransport transport.
+const TransportWebTransport = "webtransport"
+
+// ErrDatagramsUnsupported is returned by SendDatagram and ReceiveDatagram
+// for connections not accepted or dialed over WebTransport.
+var ErrDatagramsUnsupported = errors.New("websocket: datagrams not supported by the transport")
+
+// WebTransportSession is a WebTransport session over HTTP/3. The package
+// does not implement HTTP/3: adapt the sessions of a WebTransport library,
+// such as webtransport-go, to this interface.
+type WebTransportSession interface {
+	// AcceptStream waits for the peer to open a bidirectional stream.
+	AcceptStream(ctx context.Context) (WebTransportStream, error)
+
+	// OpenStreamSync opens a bidirectional stream, waiting until the peer
+	// allows it.
+	OpenStreamSync(ctx context.Context) (WebTransportStream, error)
+
+	// SendDatagram sends an unreliable datagram.
+	SendDatagram(p []byte) error
+
+	// ReceiveDatagram waits for the next datagram from the peer.
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+
+	// CloseWithError closes the session with an application error code.
+	CloseWithError(code uint32, msg string) error
+
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// WebTransportStream is a bidirectional stream of a WebTransportSession.
+type WebTransportStream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// WebTransportTransport is the Transport that serves connections over
+// WebTransport sessions. The client opens a session and a bidirectional
+// stream carrying the frames of a WebSocket connection, without the opening
+// handshake; NewWebTransportConn creates the client side of such a
+// connection. The connection also carries the datagrams of the session, see
+// Conn.SendDatagram.
+//
+// Mount the TransportServer on an HTTP/3 server as well as on the HTTP/1
+// server, and list WebTransportTransport first in Transports: clients
+// supporting WebTransport connect with it, the others negotiate down to
+// WebSocket and the fallback transports. The TransportServer selects
+// WebTransportTransport for the extended CONNECT requests of WebTransport
+// without a "transport" query parameter.
+type WebTransportTransport struct {
+	// Upgrade accepts the WebTransport request and returns the session,
+	// for example with the Upgrade method of the webtransport-go server.
+	// Upgrade writes the error response if the request is not accepted.
+	Upgrade func(w http.ResponseWriter, r *http.Request) (WebTransportSession, error)
+}
+
+// Name returns TransportWebTransport.
+func (t *WebTransportTransport) Name() string { return TransportWebTransport }
+
+// isWebTransportRequest reports whether r opens a WebTransport session, as
+// reported by HTTP/3 servers for extended CONNECT requests.
+func isWebTransportRequest(r *http.Request) bool {
+	return r.Method == http.MethodConnect && (r.Proto == TransportWebTransport || r.Header.Get(":protocol") == TransportWebTransport)
+}
+
+// ServeTransport accepts the session and its first bidirectional stream, and
+// calls handler with the connection carried by the stream.
+func (t *WebTransportTransport) ServeTransport(w http.ResponseWriter, r *http.Request, u *Upgrader, handler func(*Conn)) {
+	if t.Upgrade == nil {
+		http.Error(w, "websocket: WebTransport not configured", http.StatusNotImplemented)
+		return
+	}
+	if !u.checkOrigin(r) {
+		_, _ = u.returnError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+		return
+	}
+	adm, err := u.admit(w, r)
+	if err != nil {
+		return
+	}
+	defer adm.cancel()
+
+	session, err := t.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	ctx := r.Context()
+	if u.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.HandshakeTimeout)
+		defer cancel()
+	}
+	stream, err := session.AcceptStream(ctx)
+	if err != nil {
+		_ = session.CloseWithError(0, "")
+		return
+	}
+
+	c := u.createWebSocketConnection(newWebTransportConn(session, stream), "", nil, nil, nil)
+	c.transport = TransportWebTransport
+	c.datagrams = session
+	if err := u.upgradeSetup().complete(c, adm); err != nil {
+		_ = session.CloseWithError(0, "server shutting down")
+		return
+	}
+	handler(c)
+}
+
+// NewWebTransportConn opens a bidirectional stream on a client WebTransport
+// session and returns the client side of the connection carried by the
+// stream, to connect to a WebTransportTransport. Closing the connection
+// closes the session.
+func NewWebTransportConn(ctx context.Context, session WebTransportSession, readBufferSize, writeBufferSize int) (*Conn, error) {
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(newWebTransportConn(session, stream), false, readBufferSize, writeBufferSize, nil, nil, nil)
+	c.transport = TransportWebTransport
+	c.datagrams = session
+	return c, nil
+}
+
+// webTransportConn is a net.Conn carried by a WebTransport stream.
+type webTransportConn struct {
+	WebTransportStream
+	session   WebTransportSession
+	closeOnce sync.Once
+}
+
+func newWebTransportConn(s WebTransportSession, stream WebTransportStream) *webTransportConn {
+	return &webTransportConn{WebTransportStream: stream, session: s}
+}
+
+// Close closes the stream and the session.
+func (c *webTransportConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		err = c.WebTransportStream.Close()
+		_ = c.session.CloseWithError(0, "")
+	})
+	return err
+}
+
+func (c *webTransportConn) LocalAddr() net.Addr  { return c.session.LocalAddr() }
+func (c *webTransportConn) RemoteAddr() net.Addr { return c.session.RemoteAddr() }
+
+// SendDatagram sends p as an unreliable datagram of the WebTransport session
+// of the connection. Datagrams are not ordered with the messages and may be
+// lost; they suit data such as positions in a game, where a late update is
+// worthless. SendDatagram returns ErrDatagramsUnsupported for connections of
+// other transports.
+func (c *Conn) SendDatagram(p []byte) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if c.datagrams == nil {
+		return ErrDatagramsUnsupported
+	}
+	return c.datagrams.SendDatagram(p)
+}
+
+// ReceiveDatagram waits for the next datagram of the WebTransport session
+// of the connection. ReceiveDatagram returns ErrDatagramsUnsupported for
+// connections of other transports.
+func (c *Conn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	if c == nil {
+		return nil, ErrNilConn
+	}
+	if c.datagrams == nil {
+		return nil, ErrDatagramsUnsupported
+	}
+	return c.datagrams.ReceiveDatagram(ctx)
+}
@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeWebTransportSession is an end of an in-memory WebTransport session.
+type fakeWebTransportSession struct {
+	peer      *fakeWebTransportSession
+	streams   chan WebTransportStream
+	datagrams chan []byte
+	closed    chan struct{}
+}
+
+func newFakeWebTransportSessions() (client, server *fakeWebTransportSession) {
+	client = &fakeWebTransportSession{streams: make(chan WebTransportStream, 1), datagrams: make(chan []byte, 4), closed: make(chan struct{})}
+	server = &fakeWebTransportSession{streams: make(chan WebTransportStream, 1), datagrams: make(chan []byte, 4), closed: make(chan struct{})}
+	client.peer, server.peer = server, client
+	return client, server
+}
+
+func (s *fakeWebTransportSession) AcceptStream(ctx context.Context) (WebTransportStream, error) {
+	select {
+	case st := <-s.streams:
+		return st, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *fakeWebTransportSession) OpenStreamSync(ctx context.Context) (WebTransportStream, error) {
+	a, b := net.Pipe()
+	s.peer.streams <- b
+	return a, nil
+}
+
+func (s *fakeWebTransportSession) SendDatagram(p []byte) error {
+	s.peer.datagrams <- append([]byte(nil), p...)
+	return nil
+}
+
+func (s *fakeWebTransportSession) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	select {
+	case p := <-s.datagrams:
+		return p, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *fakeWebTransportSession) CloseWithError(code uint32, msg string) error {
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+func (s *fakeWebTransportSession) LocalAddr() net.Addr  { return streamAddr("local:443") }
+func (s *fakeWebTransportSession) RemoteAddr() net.Addr { return streamAddr("remote:1234") }
+
+func TestWebTransportTransport(t *testing.T) {
+	client, server := newFakeWebTransportSessions()
+	srv := &TransportServer{
+		Transports: []Transport{
+			&WebTransportTransport{Upgrade: func(w http.ResponseWriter, r *http.Request) (WebTransportSession, error) {
+				w.WriteHeader(http.StatusOK)
+				return server, nil
+			}},
+			WebSocketTransport{},
+		},
+		Handler: func(c *Conn) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if p, err := c.ReceiveDatagram(ctx); err != nil || c.SendDatagram(append(p, '!')) != nil {
+				t.Errorf("ReceiveDatagram = %q, %v", p, err)
+			}
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = c.WriteMessage(mt, append([]byte(c.Transport()+":"+c.RemoteAddr().String()+":"), p...))
+		},
+	}
+	r := httptest.NewRequest(http.MethodConnect, "https://example.com/ws", nil)
+	r.Proto = "webtransport"
+	done := make(chan struct{})
+	go func() {
+		srv.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+
+	c, err := NewWebTransportConn(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.SendDatagram([]byte("pos")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if p, err := c.ReceiveDatagram(ctx); err != nil || string(p) != "pos!" {
+		t.Errorf("ReceiveDatagram = %q, %v", p, err)
+	}
+	_ = c.WriteMessage(TextMessage, []byte("hi"))
+	if got := readString(t, c); got != "webtransport:remote:1234:hi" {
+		t.Errorf("got %q", got)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return")
+	}
+	select {
+	case <-server.closed:
+	default:
+		t.Error("session not closed with the connection")
+	}
+}
+
+func TestWebTransportUnsupported(t *testing.T) {
+	s, _ := newPipeConns()
+	if err := s.SendDatagram(nil); err != ErrDatagramsUnsupported {
+		t.Errorf("SendDatagram returned %v", err)
+	}
+	if _, err := s.ReceiveDatagram(context.Background()); err != ErrDatagramsUnsupported {
+		t.Errorf("ReceiveDatagram returned %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	(&WebTransportTransport{}).ServeTransport(w, httptest.NewRequest(http.MethodConnect, "/", nil), &Upgrader{}, func(*Conn) {
+		t.Error("handler called")
+	})
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("status %d", w.Code)
+	}
+}
@@ -0,0 +1,224 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// tryReadWait is how long TryReadMessage waits for data on connections
+	// without a file descriptor.
+	tryReadWait = 100 * time.Microsecond
+
+	// maxTryPeek is the most data TryReadMessage inspects for the end of a
+	// message. A longer message is read blocking until its frames arrive.
+	maxTryPeek = 1 << 20
+)
+
+var (
+	errPeekUnsupported = errors.New("websocket: peeking at received data not supported")
+	errWouldBlock      = errors.New("websocket: no data received")
+)
+
+// Message is a message read from a connection.
+type Message struct {
+	Type int
+	Data []byte
+}
+
+// TryReadMessage reads the next message if all of its frames have arrived,
+// without blocking, so that a server can poll its connections once per tick
+// of a game loop instead of blocking a goroutine in a read per connection.
+// TryReadMessage returns ok false and a nil error when no complete message
+// is available. The errors are those of ReadMessage.
+//
+// TryReadMessage inspects the data received by the operating system without
+// consuming it on Linux. For other platforms, TLS connections and other
+// connections without a file descriptor, TryReadMessage waits briefly for
+// data with a read deadline and clears the read deadline set with
+// SetReadDeadline. A message longer than 1 MiB, or dropped by a read limiter
+// or an inbound interceptor, may block TryReadMessage until the frames of
+// the message, or of the next message, arrive.
+func (c *Conn) TryReadMessage() (messageType int, p []byte, ok bool, err error) {
+	if c == nil {
+		return noFrame, nil, false, ErrNilConn
+	}
+	if c.conn == nil {
+		return noFrame, nil, false, ErrNilNetConn
+	}
+	if c.readErr == nil {
+		ready, err := c.messageReady()
+		if err != nil || !ready {
+			return noFrame, nil, false, err
+		}
+	}
+	messageType, p, err = c.ReadMessage()
+	return messageType, p, err == nil, err
+}
+
+// ReadBatch reads the messages whose frames have all arrived, up to max if
+// max is positive, and appends them to dst. ReadBatch returns the messages
+// read before an error with the error.
+func (c *Conn) ReadBatch(dst []Message, max int) ([]Message, error) {
+	for n := 0; max <= 0 || n < max; n++ {
+		messageType, p, ok, err := c.TryReadMessage()
+		if err != nil {
+			return dst, err
+		}
+		if !ok {
+			break
+		}
+		dst = append(dst, Message{Type: messageType, Data: p})
+	}
+	return dst, nil
+}
+
+// messageReady reports whether the frames of the next message, or a close
+// frame, have arrived.
+func (c *Conn) messageReady() (bool, error) {
+	for {
+		buf := c.bufferedInput()
+		if c.messageEnd(buf) {
+			return true, nil
+		}
+		if ready, err := c.peekMessage(buf); err != errPeekUnsupported {
+			return ready, err
+		}
+		if c.br != nil && c.br.Buffered() == c.br.Size() {
+			return true, nil
+		}
+		conn := c.conn
+		if err := conn.SetReadDeadline(time.Now().Add(tryReadWait)); err != nil {
+			return false, err
+		}
+		var err error
+		if c.br == nil {
+			err = c.awaitReadBuffer()
+		} else {
+			_, err = c.br.Peek(c.br.Buffered() + 1)
+		}
+		_ = conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return false, nil
+			}
+			// Let ReadMessage report the error.
+			return true, nil
+		}
+	}
+}
+
+// bufferedInput returns the data read from the network connection and not
+// yet consumed by the connection.
+func (c *Conn) bufferedInput() []byte {
+	if c.br == nil {
+		return nil
+	}
+	buf, _ := c.br.Peek(c.br.Buffered())
+	if c.readPool != nil && c.readSource.pending {
+		return append([]byte{c.readSource.b[0]}, buf...)
+	}
+	return buf
+}
+
+// peekMessage reports whether the data buffered by the connection followed
+// by the data received by the operating system holds the next message. It
+// returns errPeekUnsupported if the data received cannot be inspected.
+func (c *Conn) peekMessage(buf []byte) (bool, error) {
+	size := max(2*c.readBufSize, len(buf)+4096)
+	for {
+		if cap(c.tryBuf) < size {
+			c.tryBuf = make([]byte, size)
+		}
+		p := c.tryBuf[:size]
+		copy(p, buf)
+		n, err := peekSocket(c, p[len(buf):])
+		switch {
+		case err == errPeekUnsupported:
+			return false, err
+		case err == errWouldBlock:
+			return false, nil
+		case err != nil:
+			// Let ReadMessage report the error.
+			return true, nil
+		}
+		if n == 0 {
+			// The peer closed the connection.
+			return true, nil
+		}
+		if c.messageEnd(p[:len(buf)+n]) {
+			return true, nil
+		}
+		if len(buf)+n < size {
+			return false, nil
+		}
+		if size >= maxTryPeek {
+			return true, nil
+		}
+		size = min(2*size, maxTryPeek)
+	}
+}
+
+// messageEnd reports whether p, the data following the frame being read,
+// holds the rest of the current message and the next data message, or a
+// close frame or a frame ReadMessage fails on without reading further.
+func (c *Conn) messageEnd(p []byte) bool {
+	skip := c.readRemaining
+	if skip > int64(len(p)) {
+		return false
+	}
+	p = p[skip:]
+	inMessage := !c.readFinal
+	for {
+		if len(p) < 2 {
+			return false
+		}
+		frameType := int(p[0] & 0xf)
+		final := p[0]&finalBit != 0
+		n := int64(p[1] & 0x7f)
+		header := 2
+		switch n {
+		case 126:
+			if len(p) < 4 {
+				return false
+			}
+			n = int64(binary.BigEndian.Uint16(p[2:]))
+			header = 4
+		case 127:
+			if len(p) < 10 {
+				return false
+			}
+			n = int64(binary.BigEndian.Uint64(p[2:]))
+			header = 10
+		}
+		if p[1]&maskBit != 0 {
+			header += 4
+		}
+		switch frameType {
+		case TextMessage, BinaryMessage, continuationFrame:
+			if n < 0 || c.readLimit > 0 && n > c.readLimit {
+				return true
+			}
+		case PingMessage, PongMessage:
+		default:
+			// A close frame or a protocol error.
+			return true
+		}
+		if int64(len(p)-header) < n {
+			return false
+		}
+		p = p[int64(header)+n:]
+		if isControl(frameType) {
+			continue
+		}
+		if final && !inMessage {
+			return true
+		}
+		if final {
+			inMessage = false
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package websocket
+
+import "syscall"
+
+// peekSocket copies the data received on the socket of the connection to p
+// without consuming it and without blocking.
+func peekSocket(c *Conn, p []byte) (int, error) {
+	sc, ok := c.conn.(syscall.Conn)
+	if !ok {
+		return 0, errPeekUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, errPeekUnsupported
+	}
+	var n int
+	var serr error
+	err = rc.Read(func(fd uintptr) bool {
+		n, _, serr = syscall.Recvfrom(int(fd), p, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		return true
+	})
+	switch {
+	case err != nil:
+		return 0, err
+	case serr == syscall.EAGAIN:
+		return 0, errWouldBlock
+	case serr != nil:
+		return 0, serr
+	}
+	return n, nil
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package websocket
+
+// peekSocket is not implemented on this platform.
+func peekSocket(c *Conn, p []byte) (int, error) { return 0, errPeekUnsupported }
@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// tryRead calls TryReadMessage until a message is read or a second passes.
+func tryRead(t *testing.T, c *Conn) (int, []byte, error) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		messageType, p, ok, err := c.TryReadMessage()
+		if ok || err != nil {
+			return messageType, p, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no message available")
+	return 0, nil, nil
+}
+
+func TestTryReadMessage(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer server.Close()
+	defer client.Close()
+
+	start := time.Now()
+	for range 100 {
+		if _, _, ok, err := server.TryReadMessage(); ok || err != nil {
+			t.Fatalf("TryReadMessage returned %v, %v without data", ok, err)
+		}
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("100 polls without data took %v", d)
+	}
+
+	for _, m := range []string{"a", "b", "c"} {
+		if err := client.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, p, err := tryRead(t, server)
+	if err != nil || string(p) != "a" {
+		t.Fatalf("TryReadMessage returned %q, %v, want a", p, err)
+	}
+	var batch []Message
+	deadline := time.Now().Add(time.Second)
+	for len(batch) < 2 && time.Now().Before(deadline) {
+		if batch, err = server.ReadBatch(batch, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(batch) != 2 || string(batch[0].Data) != "b" || string(batch[1].Data) != "c" || batch[1].Type != TextMessage {
+		t.Fatalf("ReadBatch returned %v, want b and c", batch)
+	}
+
+	if err := client.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	var closeErr *CloseError
+	if _, _, err := tryRead(t, server); !errors.As(err, &closeErr) {
+		t.Fatalf("TryReadMessage returned %v, want a close error", err)
+	}
+}
+
+func TestTryReadMessageFragmented(t *testing.T) {
+	a, b := newTCPPair(t)
+	server := newConn(b, true, 1024, 1024, nil, nil, nil)
+
+	// Frames masked with a zero key, so that the payload is unchanged.
+	first := append([]byte{TextMessage, maskBit | 5, 0, 0, 0, 0}, "hello"...)
+	ping := append([]byte{finalBit | PingMessage, maskBit | 1, 0, 0, 0, 0}, "p"...)
+	last := append([]byte{finalBit | continuationFrame, maskBit | 6, 0, 0, 0, 0}, " world"...)
+	if _, err := a.Write(append(first, ping...)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, _, ok, err := server.TryReadMessage(); ok || err != nil {
+		t.Fatalf("TryReadMessage returned %v, %v for a partial message", ok, err)
+	}
+	if _, err := a.Write(last); err != nil {
+		t.Fatal(err)
+	}
+	if _, p, err := tryRead(t, server); err != nil || string(p) != "hello world" {
+		t.Fatalf("TryReadMessage returned %q, %v, want hello world", p, err)
+	}
+}
+
+func TestTryReadMessagePipe(t *testing.T) {
+	sc, cc := net.Pipe()
+	server := newConn(sc, true, 1024, 1024, nil, nil, nil)
+	client := newConn(cc, false, 1024, 1024, nil, nil, nil)
+	defer server.Close()
+	defer client.Close()
+
+	if _, _, ok, err := server.TryReadMessage(); ok || err != nil {
+		t.Fatalf("TryReadMessage returned %v, %v without data", ok, err)
+	}
+	go func() { _ = client.WriteMessage(BinaryMessage, []byte("hello")) }()
+	messageType, p, err := tryRead(t, server)
+	if err != nil || messageType != BinaryMessage || string(p) != "hello" {
+		t.Fatalf("TryReadMessage returned %d, %q, %v", messageType, p, err)
+	}
+}
@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+)
+
+// WriteMessageTTL is like WriteMessagePriority, but the message expires ttl
+// after the call: a message still in the write queue when it expires is
+// discarded instead of being written, so that stale data such as market
+// ticks or typing indicators is never delivered late. A message whose write
+// has started is written in full. The expired messages are counted by
+// WriteQueueExpired and reported to the handler set with SetExpireHandler.
+//
+// Without a write queue, WriteMessageTTL writes the message like
+// WriteMessage.
+func (c *Conn) WriteMessageTTL(messageType int, data []byte, priority Priority, ttl time.Duration) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if ttl <= 0 {
+		return errors.New("websocket: message TTL must be positive")
+	}
+	q := c.writeQueue
+	if q == nil {
+		return c.WriteMessagePriority(messageType, data, priority)
+	}
+	if priority < PriorityLow || priority > PriorityHigh {
+		return errors.New("websocket: invalid priority")
+	}
+	data, ok, err := c.interceptWrite(messageType, data)
+	if !ok {
+		return err
+	}
+	return q.enqueue(queuedMessage{
+		messageType: messageType,
+		data:        append([]byte(nil), data...),
+		priority:    priority,
+		expires:     time.Now().Add(ttl),
+	})
+}
+
+// SetExpireHandler sets the handler called with each message discarded from
+// the write queue because it expired, see WriteMessageTTL. The handler is
+// called from the goroutine of the write queue and must not write to the
+// connection or block. The handler must not retain data. If h is nil,
+// expired messages are discarded silently.
+func (c *Conn) SetExpireHandler(h func(messageType int, data []byte)) {
+	if c == nil || c.writeQueue == nil {
+		return
+	}
+	if h == nil {
+		c.writeQueue.expireHandler.Store(nil)
+		return
+	}
+	c.writeQueue.expireHandler.Store(&h)
+}
+
+// WriteQueueExpired returns the number of messages discarded from the write
+// queue because they expired.
+func (c *Conn) WriteQueueExpired() uint64 {
+	if c == nil || c.writeQueue == nil {
+		return 0
+	}
+	return c.writeQueue.expired.Load()
+}
+
+// expire reports whether m expired, counting it and calling the expire
+// handler if it did.
+func (q *writeQueue) expire(m *queuedMessage) bool {
+	if m.expires.IsZero() || time.Now().Before(m.expires) {
+		return false
+	}
+	q.expired.Add(1)
+	if h := q.expireHandler.Load(); h != nil {
+		(*h)(m.messageType, m.data)
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteMessageTTL(t *testing.T) {
+	server, client := newPipeConns()
+	defer server.Close()
+	defer client.Close()
+	if err := server.EnableWriteQueue(8, OverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var expired []string
+	server.SetExpireHandler(func(messageType int, data []byte) {
+		mu.Lock()
+		expired = append(expired, string(data))
+		mu.Unlock()
+	})
+
+	// The pipe blocks the write of the first message until the client
+	// reads. Once the writer took it from the queue, the
+	// following messages wait in the queue.
+	if err := server.WriteMessage(TextMessage, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	for server.WriteQueueLen() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := server.WriteMessageTTL(TextMessage, []byte("stale"), PriorityHigh, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WriteMessageTTL(TextMessage, []byte("fresh"), PriorityNormal, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for _, want := range []string{"first", "fresh"} {
+		if s := readString(t, client); s != want {
+			t.Fatalf("read %q, want %q", s, want)
+		}
+	}
+	if n := server.WriteQueueExpired(); n != 1 {
+		t.Errorf("WriteQueueExpired() = %d, want 1", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 1 || expired[0] != "stale" {
+		t.Errorf("expire handler called with %q, want the stale message", expired)
+	}
+}
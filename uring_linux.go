@@ -0,0 +1,342 @@
+//go:build linux && wsiouring
+
+package websocket
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	sysIOURingSetup = 425
+	sysIOURingEnter = 426
+
+	ioringOpSend         = 26
+	ioringEnterGetEvents = 1
+
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	// uringEntries is the size of the submission queue and the maximum
+	// number of writes submitted with one system call.
+	uringEntries = 256
+)
+
+// The layouts of struct io_uring_params, io_uring_sqe and io_uring_cqe.
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uring is an io_uring instance used to send frames to many sockets with
+// one system call. Submissions are serialized by mu.
+type uring struct {
+	mu     sync.Mutex
+	fd     int
+	mems   [][]byte
+	broken bool // a submission failed; the ring is not used again
+
+	sqHead, sqTail *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	sqes           []uringSQE
+
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []uringCQE
+}
+
+var (
+	sharedRingOnce sync.Once
+	sharedRing     *uring
+	sharedRingErr  error
+)
+
+// sharedURing returns the ring of the process, creating it on first use.
+// It returns an error if io_uring is not available, for example when it is
+// disabled by the kernel or a seccomp filter.
+func sharedURing() (*uring, error) {
+	sharedRingOnce.Do(func() {
+		sharedRing, sharedRingErr = newURing(uringEntries)
+	})
+	return sharedRing, sharedRingErr
+}
+
+func newURing(entries uint32) (*uring, error) {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r := &uring{fd: int(fd)}
+	mmap := func(offset int64, size uint32) ([]byte, error) {
+		b, err := syscall.Mmap(r.fd, offset, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		if err != nil {
+			return nil, os.NewSyscallError("mmap", err)
+		}
+		r.mems = append(r.mems, b)
+		return b, nil
+	}
+	sq, err := mmap(ioringOffSQRing, p.sqOff.array+p.sqEntries*4)
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	cq, err := mmap(ioringOffCQRing, p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	sqes, err := mmap(ioringOffSQEs, p.sqEntries*uint32(unsafe.Sizeof(uringSQE{})))
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	r.sqHead = (*uint32)(unsafe.Pointer(&sq[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&sq[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&sq[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&sq[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&sqes[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&cq[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&cq[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&cq[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&cq[p.cqOff.cqes])), p.cqEntries)
+	return r, nil
+}
+
+func (r *uring) close() {
+	for _, b := range r.mems {
+		_ = syscall.Munmap(b)
+	}
+	_ = syscall.Close(r.fd)
+}
+
+// send submits the writes of the batch and waits for their completion. The
+// result of each write, the number of bytes sent or a negated errno, is
+// stored in its res field. The batch must not be larger than the ring.
+func (r *uring) send(batch []uringWrite) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tail := atomic.LoadUint32(r.sqTail)
+	for i := range batch {
+		w := &batch[i]
+		idx := tail & r.sqMask
+		r.sqes[idx] = uringSQE{
+			opcode:   ioringOpSend,
+			fd:       int32(w.fd),
+			addr:     uint64(uintptr(unsafe.Pointer(&w.frame[0]))),
+			len:      uint32(len(w.frame)),
+			opFlags:  syscall.MSG_DONTWAIT | syscall.MSG_NOSIGNAL,
+			userData: uint64(i),
+		}
+		r.sqArray[idx] = idx
+		tail++
+	}
+	atomic.StoreUint32(r.sqTail, tail)
+
+	submit, reaped := len(batch), 0
+	for reaped < len(batch) {
+		n, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), uintptr(submit), 1, ioringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != syscall.EINTR && errno != syscall.EAGAIN && errno != syscall.EBUSY {
+			// The ring is broken; fail the writes without a completion.
+			r.broken = true
+			for i := range batch {
+				if !batch[i].done {
+					batch[i].res = -int32(errno)
+				}
+			}
+			return
+		}
+		if errno == 0 {
+			submit -= int(n)
+		}
+		head := atomic.LoadUint32(r.cqHead)
+		for ; head != atomic.LoadUint32(r.cqTail); head++ {
+			cqe := r.cqes[head&r.cqMask]
+			w := &batch[cqe.userData]
+			w.res, w.done = cqe.res, true
+			reaped++
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+}
+
+// uringWrite is a frame written to a connection through the ring. The
+// write lock of the connection is held from beginURingWrite to finish.
+type uringWrite struct {
+	c         *Conn
+	index     int // in the conns argument of WriteBroadcast
+	fd        int
+	frameType int
+	frame     []byte
+	res       int32
+	done      bool
+}
+
+// usable reports whether the ring can be used.
+func (r *uring) usable() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.broken
+}
+
+func writeBroadcast(conns []*Conn, pm *PreparedMessage, errs []error) {
+	r, err := sharedURing()
+	if err != nil || !r.usable() {
+		writeEach(conns, pm, errs)
+		return
+	}
+	batch := make([]uringWrite, 0, min(len(conns), uringEntries))
+	locked := make(map[*Conn]bool)
+	flush := func() {
+		if len(batch) > 0 {
+			r.send(batch)
+		}
+		for i := range batch {
+			errs[batch[i].index] = batch[i].finish(pm)
+		}
+		batch = batch[:0]
+		clear(locked)
+	}
+	for i, c := range conns {
+		if locked[c] {
+			// The connection is listed twice; its lock is held.
+			flush()
+		}
+		w, ok, err := beginURingWrite(c, pm)
+		switch {
+		case err != nil:
+			errs[i] = err
+		case !ok:
+			errs[i] = c.WritePreparedMessage(pm)
+		default:
+			w.index = i
+			batch = append(batch, w)
+			locked[c] = true
+			if len(batch) == uringEntries {
+				flush()
+			}
+		}
+	}
+	flush()
+}
+
+// beginURingWrite acquires the write lock of c for a write of pm through
+// the ring. It returns ok false if c must be written with
+// WritePreparedMessage.
+func beginURingWrite(c *Conn, pm *PreparedMessage) (w uringWrite, ok bool, err error) {
+	if c == nil {
+		return w, false, ErrNilConn
+	}
+	if c.outbound.enabled() || c.writeQueue != nil || c.coalescer.Load() != nil || c.inspector.Load() != nil || c.faults.Load() != nil || c.conn == nil {
+		return w, false, nil
+	}
+	if d := c.writeDeadline; !d.IsZero() && !time.Now().Before(d) {
+		// Let the regular write report the timeout.
+		return w, false, nil
+	}
+	frameType, frame, ok, err := c.preparedFrame(pm)
+	if err != nil || !ok || len(frame) == 0 {
+		return w, false, err
+	}
+	fd, err := connFD(c)
+	if err != nil {
+		return w, false, nil
+	}
+
+	<-c.mu
+	c.writeErrMu.Lock()
+	err = c.writeErr
+	c.writeErrMu.Unlock()
+	if err != nil {
+		c.mu <- struct{}{}
+		return w, false, err
+	}
+	if c.isWriting {
+		panic("concurrent write to websocket connection")
+	}
+	c.isWriting = true
+	return uringWrite{c: c, fd: fd, frameType: frameType, frame: frame}, true, nil
+}
+
+// finish completes the write with a regular write if the socket did not
+// accept the whole frame, and releases the write lock of the connection.
+func (w *uringWrite) finish(pm *PreparedMessage) error {
+	c := w.c
+	err := w.complete()
+	if !c.isWriting {
+		panic("concurrent write to websocket connection")
+	}
+	c.isWriting = false
+	c.mu <- struct{}{}
+	if m := c.metrics; m != nil && err == nil {
+		m.MessageSent(pm.messageType)
+		m.BytesSent(framePayloadLen(w.frame))
+	}
+	return err
+}
+
+func (w *uringWrite) complete() error {
+	c := w.c
+	n := 0
+	switch {
+	case w.res >= 0:
+		n = int(w.res)
+	case w.res == -int32(syscall.EAGAIN):
+	default:
+		return c.writeFatal(os.NewSyscallError("write", syscall.Errno(-w.res)))
+	}
+	if n < len(w.frame) {
+		if err := c.conn.SetWriteDeadline(c.writeDeadline); err != nil {
+			return c.writeFatal(err)
+		}
+		if _, err := c.conn.Write(w.frame[n:]); err != nil {
+			return c.writeFatal(err)
+		}
+	}
+	if w.frameType == CloseMessage {
+		_ = c.writeFatal(ErrCloseSent)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux || !wsiouring
+
+package websocket
+
+func writeBroadcast(conns []*Conn, pm *PreparedMessage, errs []error) {
+	writeEach(conns, pm, errs)
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned when reading a text message that is not valid
+// UTF-8 from a connection with strict UTF-8 validation. See
+// Conn.SetStrictUTF8.
+var ErrInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text message")
+
+// SetStrictUTF8 sets whether text messages read from the peer are validated
+// as UTF-8, as required by RFC 6455. The payload is validated as it is read,
+// across fragments and after decompression. On the first invalid sequence the
+// connection sends a close message with the code CloseInvalidFramePayloadData
+// to the peer and fails: the reader and all subsequent reads return
+// ErrInvalidUTF8.
+//
+// By default text messages are not validated. The default for the
+// connections created by an Upgrader or a Dialer is set by their StrictUTF8
+// field. The setting applies to the messages returned by subsequent calls to
+// NextReader.
+func (c *Conn) SetStrictUTF8(strict bool) {
+	if c == nil {
+		return
+	}
+	c.strictUTF8 = strict
+}
+
+// utf8Reader validates the UTF-8 encoding of a text message.
+type utf8Reader struct {
+	c       *Conn
+	r       io.ReadCloser
+	pending [utf8.UTFMax]byte // the incomplete rune at the end of the last read
+	n       int               // the length of pending
+}
+
+func (u *utf8Reader) Read(b []byte) (int, error) {
+	n, err := u.r.Read(b)
+	if !u.valid(b[:n]) || (err == io.EOF && u.n > 0) {
+		return 0, u.fail()
+	}
+	return n, err
+}
+
+func (u *utf8Reader) Close() error {
+	return u.r.Close()
+}
+
+// valid reports whether p is a valid continuation of the message read so
+// far. An incomplete rune at the end of p is kept for the next read.
+func (u *utf8Reader) valid(p []byte) bool {
+	if u.n > 0 {
+		for len(p) > 0 && !utf8.FullRune(u.pending[:u.n]) {
+			u.pending[u.n] = p[0]
+			u.n++
+			p = p[1:]
+		}
+		if !utf8.FullRune(u.pending[:u.n]) {
+			return true
+		}
+		if r, size := utf8.DecodeRune(u.pending[:u.n]); (r == utf8.RuneError && size == 1) || size != u.n {
+			return false
+		}
+		u.n = 0
+	}
+
+	// Hold back a rune start near the end of p that needs more bytes. A
+	// sequence that cannot start a valid rune is a full rune and fails the
+	// validation immediately.
+	tail := 0
+	for i := len(p) - 1; i >= 0 && i > len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				tail = len(p) - i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(p[:len(p)-tail]) {
+		return false
+	}
+	u.n = copy(u.pending[:], p[len(p)-tail:])
+	return true
+}
+
+// fail fails the connection with the close code CloseInvalidFramePayloadData.
+func (u *utf8Reader) fail() error {
+	c := u.c
+	c.log(slog.LevelWarn, "websocket: invalid UTF-8 in text message")
+	// Make a best effort to send a close message describing the problem.
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseInvalidFramePayloadData, ""), time.Now().Add(writeWait))
+	c.readErr = ErrInvalidUTF8
+	u.n = 0
+	return ErrInvalidUTF8
+}
@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var utf8Tests = []struct {
+	p     string
+	valid bool
+}{
+	{"", true},
+	{"hello", true},
+	{"κόσμε", true},
+	{"\xf0\x90\x80\x80\xf4\x8f\xbf\xbf", true},
+	{"κόσμε\xed\xa0\x80", false},
+	{"\xed\xa0\x80", false},     // surrogate
+	{"\xf4\x90\x80\x80", false}, // above U+10FFFF
+	{"\xc0\xaf", false},         // overlong
+	{"\xff", false},
+	{"abc\xe2\x82", false}, // truncated at the end of the message
+	{"\xe2\x82\xacabc\x80", false},
+}
+
+// fragments returns the frames of a text message with the payload p split
+// in fragments of size n.
+func fragments(p string, n int) []byte {
+	var b []byte
+	op := byte(TextMessage)
+	for {
+		m := len(p)
+		if m > n {
+			m = n
+		}
+		var fin byte
+		if m == len(p) {
+			fin = finalBit
+		}
+		b = append(b, frame(fin|op, true, []byte(p[:m]))...)
+		op = continuationFrame
+		p = p[m:]
+		if fin != 0 {
+			return b
+		}
+	}
+}
+
+func TestStrictUTF8(t *testing.T) {
+	for _, tt := range utf8Tests {
+		for n := 1; n <= len(tt.p)+1; n++ {
+			var out bytes.Buffer
+			c := newTestConn(bytes.NewReader(fragments(tt.p, n)), &out, true)
+			c.SetStrictUTF8(true)
+			_, p, err := c.ReadMessage()
+			if tt.valid {
+				if err != nil || string(p) != tt.p {
+					t.Errorf("%q in fragments of %d: got %q, %v", tt.p, n, p, err)
+				}
+				continue
+			}
+			if err != ErrInvalidUTF8 {
+				t.Errorf("%q in fragments of %d: got error %v, want %v", tt.p, n, err, ErrInvalidUTF8)
+				continue
+			}
+			if b := out.Bytes(); len(b) < 4 || binary.BigEndian.Uint16(b[2:]) != CloseInvalidFramePayloadData {
+				t.Errorf("%q in fragments of %d: wrote % x, want close %d", tt.p, n, b, CloseInvalidFramePayloadData)
+			}
+			if _, _, err := c.NextReader(); err != ErrInvalidUTF8 {
+				t.Errorf("%q in fragments of %d: next read returned %v", tt.p, n, err)
+			}
+		}
+	}
+}
+
+func TestStrictUTF8Disabled(t *testing.T) {
+	c := newTestConn(bytes.NewReader(frame(finalBit|TextMessage, true, []byte("\xff"))), io.Discard, true)
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "\xff" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+
+	// Binary messages are not validated.
+	c = newTestConn(bytes.NewReader(frame(finalBit|BinaryMessage, true, []byte("\xff"))), io.Discard, true)
+	c.SetStrictUTF8(true)
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "\xff" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+}
+
+func TestStrictUTF8Options(t *testing.T) {
+	upgrader := Upgrader{StrictUTF8: true, EnableCompression: true}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	d := Dialer{StrictUTF8: true, EnableCompression: true}
+	c, _, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// The compressed message is validated after decompression.
+	if err := c.WriteMessage(TextMessage, []byte("κόσμε")); err != nil {
+		t.Fatal(err)
+	}
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "κόσμε" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("\xce\xba\xce")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ReadMessage(); !IsCloseError(err, CloseInvalidFramePayloadData) {
+		t.Fatalf("got %v, want close %d", err, CloseInvalidFramePayloadData)
+	}
+}
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidMessage is wrapped by the errors returned for inbound messages
+// rejected by a Validator.
+var ErrInvalidMessage = errors.New("websocket: invalid message")
+
+// Validator validates the payload of an inbound message before it is
+// handled, for example against a JSON Schema or the layout of a binary
+// protocol.
+//
+// Implementations must be safe for concurrent use.
+type Validator interface {
+	// Validate returns an error describing why data is invalid, or nil.
+	Validate(data []byte) error
+}
+
+// ValidatorFunc is an adapter to use a function as a Validator.
+type ValidatorFunc func(data []byte) error
+
+// Validate calls f(data).
+func (f ValidatorFunc) Validate(data []byte) error { return f(data) }
+
+// ValidationError is the error returned for an inbound message rejected by
+// a Validator. It wraps ErrInvalidMessage and the error of the validator.
+type ValidationError struct {
+	// Event is the name of the rejected event, or empty for a message
+	// rejected by an interceptor returned by ValidateInbound.
+	Event string
+
+	// Err is the error returned by the validator.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Event == "" {
+		return "websocket: invalid message: " + e.Err.Error()
+	}
+	return fmt.Sprintf("websocket: invalid data of event %q: %v", e.Event, e.Err)
+}
+
+// Unwrap returns ErrInvalidMessage and the error of the validator.
+func (e *ValidationError) Unwrap() []error { return []error{ErrInvalidMessage, e.Err} }
+
+// ValidateInbound returns an inbound Interceptor that validates the data
+// messages read from a connection, for applications not using a Router.
+// Register it with Conn.UseInbound; the read methods return a
+// *ValidationError for an invalid message, and the next read continues with
+// the next message.
+func ValidateInbound(v Validator) Interceptor {
+	return func(messageType int, data []byte) ([]byte, error) {
+		if err := v.Validate(data); err != nil {
+			return nil, &ValidationError{Err: err}
+		}
+		return data, nil
+	}
+}
+
+// validate validates the data of the event with the validator of the event,
+// or the default validator. The peer gets an event with a CallError of code
+// CallInvalidParams when the data is invalid.
+func (r *Router) validate(c *Conn, e *Event) error {
+	r.mu.RLock()
+	v, ok := r.validators[e.Event]
+	r.mu.RUnlock()
+	if !ok {
+		v = r.Validator
+	}
+	if v == nil {
+		return nil
+	}
+	err := v.Validate(e.Data)
+	if err == nil {
+		return nil
+	}
+	reply := Event{Event: e.Event, ID: e.ID, Reply: e.ID != "", Error: &CallError{Code: CallInvalidParams, Message: err.Error()}}
+	if p, merr := json.Marshal(&reply); merr == nil {
+		_ = c.WriteMessage(TextMessage, p)
+	}
+	return &ValidationError{Event: e.Event, Err: err}
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRouterValidate(t *testing.T) {
+	var r Router
+	var handled []string
+	On(&r, "chat.send", func(c *Conn, msg chatMessage) error {
+		handled = append(handled, msg.Text)
+		return nil
+	})
+	r.Validate("chat.send", MustCompileJSONSchema(`{"type":"object","required":["text"]}`))
+	var errs []error
+	r.OnError = func(c *Conn, e *Event, err error) { errs = append(errs, err) }
+	r.MaxViolations = 2
+
+	s, c := newPipeConns()
+	// Serve does not read the reply to its close message.
+	c.SetCloseHandler(func(int, string) error { return nil })
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(s) }()
+
+	go func() {
+		for _, m := range []string{
+			`{"event":"chat.send","data":{"text":"hi"}}`,
+			`{"event":"chat.send","id":"1","data":{"room":"a"}}`,
+			`{"event":"chat.send"}`,
+		} {
+			if err := c.WriteMessage(TextMessage, []byte(m)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for _, want := range []string{
+		`{"event":"chat.send","id":"1","reply":true,"error":{"code":400,"message":"/: missing property \"text\""}}`,
+		`{"event":"chat.send","error":{"code":400,"message":"/: got null, want object"}}`,
+	} {
+		if got := readString(t, c); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+	_, _, err := c.ReadMessage()
+	if !IsCloseError(err, CloseInvalidFramePayloadData) {
+		t.Errorf("ReadMessage returned %v, want close", err)
+	}
+	var ve *ValidationError
+	if err := <-done; !errors.As(err, &ve) || ve.Event != "chat.send" || !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("Serve returned %v", err)
+	}
+	if len(handled) != 1 || handled[0] != "hi" || len(errs) != 2 {
+		t.Errorf("handled %q, errors %v", handled, errs)
+	}
+}
+
+func TestRouterDefaultValidator(t *testing.T) {
+	var r Router
+	r.Handle("a", func(c *Conn, e *Event) error { return nil })
+	r.Handle("b", func(c *Conn, e *Event) error { return nil })
+	r.Validator = ValidatorFunc(func(data []byte) error { return errors.New("rejected") })
+	r.Validate("b", nil)
+
+	s := newTestConn(nil, io.Discard, true)
+	if err := r.Dispatch(s, []byte(`{"event":"a"}`)); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("Dispatch(a) returned %v", err)
+	}
+	if err := r.Dispatch(s, []byte(`{"event":"b"}`)); err != nil {
+		t.Errorf("Dispatch(b) returned %v", err)
+	}
+}
+
+func TestValidateInbound(t *testing.T) {
+	var wire bytes.Buffer
+	wc := newTestConn(nil, &wire, false)
+	rc := newTestConn(&wire, nil, true)
+	rc.UseInbound(ValidateInbound(ValidatorFunc(func(data []byte) error {
+		if len(data) < 2 || data[0] != 0x01 {
+			return errors.New("bad header")
+		}
+		return nil
+	})))
+	_ = wc.WriteMessage(BinaryMessage, []byte{0x02, 0x00})
+	_ = wc.WriteMessage(BinaryMessage, []byte{0x01, 0x00})
+	var ve *ValidationError
+	if _, _, err := rc.ReadMessage(); !errors.As(err, &ve) || ve.Err.Error() != "bad header" {
+		t.Errorf("ReadMessage returned %v, want validation error", err)
+	}
+	if _, p, err := rc.ReadMessage(); err != nil || !bytes.Equal(p, []byte{0x01, 0x00}) {
+		t.Errorf("ReadMessage = %v, %v", p, err)
+	}
+}
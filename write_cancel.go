@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrMessageAborted is returned by the writer of a message after
+	// AbortMessage.
+	ErrMessageAborted = errors.New("websocket: message aborted")
+
+	// ErrTruncateUnsupported is returned by TruncateMessage when the message
+	// cannot be ended after the data already sent, see TruncateMessage.
+	ErrTruncateUnsupported = errors.New("websocket: message cannot be truncated")
+)
+
+// WriteMessageWithDeadline is like WriteMessage, but the write of the message
+// times out at deadline instead of the deadline set with SetWriteDeadline,
+// so that a stuck peer cannot block the writing goroutine past it. As with
+// other write timeouts, the connection state is corrupt after a timeout and
+// all future writes return an error matching ErrWriteTimeout.
+//
+// If the write queue is enabled, the message is discarded if it is still
+// queued at deadline, as with WriteMessageTTL, and its write times out at
+// deadline.
+func (c *Conn) WriteMessageWithDeadline(messageType int, data []byte, deadline time.Time) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if deadline.IsZero() {
+		return c.WriteMessage(messageType, data)
+	}
+	data, ok, err := c.interceptWrite(messageType, data)
+	if !ok {
+		return err
+	}
+	if q := c.writeQueue; q != nil {
+		return q.enqueue(queuedMessage{
+			messageType: messageType,
+			data:        append([]byte(nil), data...),
+			expires:     deadline,
+			deadline:    deadline,
+		})
+	}
+	if !time.Now().Before(deadline) {
+		return ErrWriteTimeout
+	}
+	prevDeadline := c.writeDeadline
+	c.writeDeadline = deadline
+	defer func() { c.writeDeadline = prevDeadline }()
+	return c.writeMessage(messageType, data)
+}
+
+// AbortMessage abandons the message being written with NextWriter. The
+// writes and the Close of the writer then return ErrMessageAborted.
+// AbortMessage does nothing if no message is being written.
+//
+// If no frame of the message has been sent yet, its buffered data is
+// discarded and the connection stays usable. Otherwise the peer has
+// received part of the message, which cannot be taken back: as RFC 6455
+// requires, AbortMessage fails the connection by sending a close message
+// with code and text and closing the network connection. If code is zero,
+// CloseInternalServerErr is used. A message compressed with context
+// takeover is always aborted by closing, since the compressor saw its data.
+//
+// AbortMessage is a write method: call it from the goroutine writing the
+// message, for example after a write of the message timed out. To interrupt
+// a write blocked on a stuck peer from another goroutine, use a write
+// deadline or Close.
+func (c *Conn) AbortMessage(code int, text string) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	mw := c.msgWriter
+	if mw == nil {
+		return nil
+	}
+	sent := mw.frameType == continuationFrame
+	contextTakeover := mw.rsv&rsv1Bit != 0 && c.writeContextTakeover
+	_ = mw.endMessage(ErrMessageAborted)
+	if !sent && !contextTakeover {
+		return nil
+	}
+	if code == 0 {
+		code = CloseInternalServerErr
+	}
+	err := c.WriteControl(CloseMessage, FormatCloseMessage(code, text), time.Now().Add(writeWait))
+	if errors.Is(err, ErrWriteTimeout) || errors.Is(err, ErrCloseSent) {
+		// The close message cannot be sent after a failed write.
+		err = nil
+	}
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// TruncateMessage ends the message being written with NextWriter after the
+// data already sent, by sending an empty final frame. The data buffered but
+// not sent yet is discarded, and the writer then returns an error as after
+// Close. The peer receives a complete, shorter message, so use
+// TruncateMessage only for messages whose prefix makes sense on its own,
+// such as a stream of records cut at a record boundary. If no frame of the
+// message has been sent yet, the message is discarded as by AbortMessage.
+// TruncateMessage does nothing if no message is being written.
+//
+// TruncateMessage returns ErrTruncateUnsupported, leaving the message as
+// is, when ending the message early would produce an invalid message: for a
+// message compressed, encrypted or transformed by an extension, whose
+// writers hold data not sent yet, and for a text message whose data sent so
+// far ends in the middle of a UTF-8 sequence. Use AbortMessage for these.
+//
+// TruncateMessage is a write method: call it from the goroutine writing the
+// message.
+func (c *Conn) TruncateMessage() error {
+	if c == nil {
+		return ErrNilConn
+	}
+	mw := c.msgWriter
+	if mw == nil {
+		return nil
+	}
+	if mw.frameType != continuationFrame {
+		if mw.rsv&rsv1Bit != 0 && c.writeContextTakeover {
+			return ErrTruncateUnsupported
+		}
+		_ = mw.endMessage(ErrMessageAborted)
+		return nil
+	}
+	if c.writer != mw || mw.cutRune() {
+		return ErrTruncateUnsupported
+	}
+	mw.pos = maxFrameHeaderSize
+	return mw.flushFrame(true, nil)
+}
+
+// trackTail records the last bytes of the payload sent so far, given the
+// payload of the frame being sent, p followed by extra.
+func (w *messageWriter) trackTail(p, extra []byte) {
+	for _, q := range [][]byte{p, extra} {
+		if len(q) >= len(w.tail) {
+			w.ntail = copy(w.tail[:], q[len(q)-len(w.tail):])
+			continue
+		}
+		if keep := len(w.tail) - len(q); w.ntail > keep {
+			copy(w.tail[:], w.tail[w.ntail-keep:w.ntail])
+			w.ntail = keep
+		}
+		w.ntail += copy(w.tail[w.ntail:], q)
+	}
+}
+
+// cutRune reports whether the text sent so far ends in the middle of a UTF-8
+// sequence.
+func (w *messageWriter) cutRune() bool {
+	tail := w.tail[:w.ntail]
+	for i := len(tail) - 1; i >= 0; i-- {
+		if utf8.RuneStart(tail[i]) {
+			return !utf8.FullRune(tail[i:])
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteMessageWithDeadline(t *testing.T) {
+	// The peer of the pipe does not read, so the write blocks.
+	server, client := newPipeConns()
+	defer client.Close()
+	defer server.Close()
+
+	start := time.Now()
+	err := server.WriteMessageWithDeadline(TextMessage, []byte("stuck"), time.Now().Add(20*time.Millisecond))
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf("got %v, want ErrWriteTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("write returned after %v", d)
+	}
+	if err := server.WriteMessageWithDeadline(TextMessage, []byte("late"), time.Now().Add(-time.Second)); !errors.Is(err, ErrWriteTimeout) {
+		t.Errorf("got %v for a past deadline, want ErrWriteTimeout", err)
+	}
+}
+
+func TestWriteMessageWithDeadlineQueued(t *testing.T) {
+	server, client := newPipeConns()
+	defer client.Close()
+	defer server.Close()
+	if err := server.EnableWriteQueue(4, OverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WriteMessageWithDeadline(TextMessage, []byte("queued"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, client); got != "queued" {
+		t.Errorf("got %q", got)
+	}
+
+	// The deadline of the message does not apply to the later messages.
+	if err := server.WriteMessageWithDeadline(TextMessage, []byte("short"), time.Now().Add(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, client); got != "short" {
+		t.Errorf("got %q", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := server.WriteMessage(TextMessage, []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, client); got != "plain" {
+		t.Errorf("got %q after the deadline of the previous message", got)
+	}
+}
+
+func TestAbortMessageBeforeFrame(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	defer server.Close()
+
+	w, err := server.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("abandoned")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AbortMessage(0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("more")); err != ErrMessageAborted {
+		t.Errorf("write after abort returned %v, want ErrMessageAborted", err)
+	}
+	if err := server.WriteMessage(TextMessage, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, client); got != "next" {
+		t.Errorf("got %q, want the message after the aborted one", got)
+	}
+	if err := server.AbortMessage(0, ""); err != nil {
+		t.Errorf("abort without a message returned %v", err)
+	}
+}
+
+func TestAbortMessageAfterFrames(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	defer server.Close()
+	if err := server.SetWriteFrameSize(8); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := server.NextWriter(BinaryMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("partial message data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AbortMessage(CloseTryAgainLater, "aborted"); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = client.ReadMessage()
+	if !IsCloseError(err, CloseTryAgainLater) {
+		t.Fatalf("got %v, want a close error with the abort code", err)
+	}
+	if err := server.WriteMessage(TextMessage, []byte("after")); err == nil {
+		t.Error("write succeeded after the connection was failed")
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	defer server.Close()
+	if err := server.SetWriteFrameSize(4); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := server.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("record;record;rec")); err != nil {
+		t.Fatal(err)
+	}
+	// The frames of 4 bytes sent are "reco", "rd;r", "ecor" and "d;re".
+	if err := server.TruncateMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("close of the truncated writer succeeded")
+	}
+	if got := readString(t, client); got != "record;record;re" {
+		t.Errorf("got %q", got)
+	}
+	if err := server.WriteMessage(TextMessage, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, client); got != "next" {
+		t.Errorf("got %q after the truncated message", got)
+	}
+}
+
+func TestTruncateMessageMidRune(t *testing.T) {
+	server, client := newTCPConns(t)
+	defer client.Close()
+	defer server.Close()
+	if err := server.SetWriteFrameSize(4); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := server.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first frame ends after the first byte of "é".
+	if _, err := w.Write([]byte("abcéx")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.TruncateMessage(); err != ErrTruncateUnsupported {
+		t.Fatalf("got %v, want ErrTruncateUnsupported", err)
+	}
+	// The message is left as is and can be completed.
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, client); got != "abcéx" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMessageWriterTrackTail(t *testing.T) {
+	for _, tt := range []struct {
+		frames []string
+		cut    bool
+	}{
+		{[]string{"abc"}, false},
+		{[]string{"ab\xe2"}, true},
+		{[]string{"ab\xe2", "\x82"}, true},
+		{[]string{"ab\xe2", "\x82\xac"}, false},
+		{[]string{"\xf0", "\x9f", "\x98"}, true},
+		{[]string{"\xf0", "\x9f", "\x98", "\x80"}, false},
+		{[]string{"long prefix \xf0\x9f"}, true},
+	} {
+		var w messageWriter
+		for _, f := range tt.frames {
+			w.trackTail([]byte(f), nil)
+		}
+		if got := w.cutRune(); got != tt.cut {
+			t.Errorf("frames %q: cutRune() = %v, want %v", tt.frames, got, tt.cut)
+		}
+	}
+}
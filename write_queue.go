@@ -0,0 +1,251 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy specifies what a connection does when a message is written
+// while the write queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the writer until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest discards the oldest queued message to make room for
+	// the new message.
+	OverflowDropOldest
+
+	// OverflowClose fails the connection. The write returns ErrWriteQueueFull
+	// and the network connection is closed.
+	OverflowClose
+)
+
+var (
+	// ErrWriteQueueFull is returned when a message is written to a
+	// connection with the OverflowClose policy and a full write queue.
+	ErrWriteQueueFull = errors.New("websocket: write queue full")
+
+	// ErrWriteQueueEnabled is returned by NextWriter and EnableWriteQueue
+	// when the write queue is already enabled on the connection.
+	ErrWriteQueueEnabled = errors.New("websocket: write queue enabled")
+)
+
+// queuedMessage is a message waiting in the write queue.
+type queuedMessage struct {
+	messageType int
+	data        []byte
+	pm          *PreparedMessage
+	priority    Priority
+	expires     time.Time // zero if the message does not expire, see WriteMessageTTL
+	deadline    time.Time // write deadline of the message, see WriteMessageWithDeadline
+}
+
+// writeQueue serializes writes from multiple goroutines through a single
+// writer goroutine.
+type writeQueue struct {
+	c        *Conn
+	ch       chan queuedMessage // PriorityNormal messages
+	high     chan queuedMessage // PriorityHigh messages, written first
+	low      chan queuedMessage // PriorityLow messages, written last
+	policy   OverflowPolicy
+	mu       sync.Mutex // serializes producers for OverflowDropOldest
+	done     chan struct{}
+	stopOnce sync.Once
+	deadline atomic.Pointer[time.Time]
+	dropped  atomic.Uint64
+	expired  atomic.Uint64
+
+	expireHandler atomic.Pointer[func(messageType int, data []byte)]
+
+	monitorMu sync.Mutex
+	monitor   *slowMonitor // see SetSlowConsumerPolicy
+}
+
+// EnableWriteQueue makes the write methods of the connection safe to call
+// from multiple goroutines. Messages written with WriteMessage, WriteJSON and
+// WritePreparedMessage are copied to a queue of the given size and written to
+// the network by a goroutine owned by the connection. The policy specifies
+// what happens when the queue is full.
+//
+// EnableWriteQueue must be called before the connection is used for writing
+// and at most once. NextWriter returns ErrWriteQueueEnabled once the queue is
+// enabled because a streamed message cannot be queued.
+//
+// Errors from writing queued messages are returned by subsequent writes.
+// Messages still queued when the connection is closed are discarded. Write
+// deadlines set with SetWriteDeadline apply to the queued messages written
+// after the call.
+func (c *Conn) EnableWriteQueue(size int, policy OverflowPolicy) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if c.writeQueue != nil {
+		return ErrWriteQueueEnabled
+	}
+	if size <= 0 {
+		size = 1
+	}
+	q := &writeQueue{
+		c:      c,
+		ch:     make(chan queuedMessage, size),
+		high:   make(chan queuedMessage, size),
+		low:    make(chan queuedMessage, size),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	// The queue keeps its own copy: the writes of the queue goroutine set
+	// c.writeDeadline to the deadline of each message.
+	d := c.writeDeadline
+	q.deadline.Store(&d)
+	c.writeQueue = q
+	go q.run()
+	return nil
+}
+
+// WriteQueueLen returns the number of messages waiting in the write queue.
+func (c *Conn) WriteQueueLen() int {
+	if c == nil || c.writeQueue == nil {
+		return 0
+	}
+	return c.writeQueue.len()
+}
+
+// WriteQueueDropped returns the number of messages discarded by the
+// OverflowDropOldest policy, by the slow consumer policy and to make room
+// for PriorityLow messages.
+func (c *Conn) WriteQueueDropped() uint64 {
+	if c == nil || c.writeQueue == nil {
+		return 0
+	}
+	return c.writeQueue.dropped.Load()
+}
+
+func (q *writeQueue) setDeadline(t time.Time) {
+	q.deadline.Store(&t)
+}
+
+func (q *writeQueue) err() error {
+	q.c.writeErrMu.Lock()
+	defer q.c.writeErrMu.Unlock()
+	return q.c.writeErr
+}
+
+// closedErr returns the error for a write to a stopped queue.
+func (q *writeQueue) closedErr() error {
+	if err := q.err(); err != nil {
+		return err
+	}
+	return errWriteClosed
+}
+
+func (q *writeQueue) enqueue(m queuedMessage) error {
+	return q.enqueueContext(context.Background(), m)
+}
+
+// enqueueContext is like enqueue, but stops waiting for room in the queue
+// when ctx is done.
+func (q *writeQueue) enqueueContext(ctx context.Context, m queuedMessage) error {
+	if err := q.err(); err != nil {
+		return err
+	}
+	ch := q.chanFor(m.priority)
+	select {
+	case ch <- m:
+		return nil
+	case <-q.done:
+		return q.closedErr()
+	default:
+	}
+
+	policy := q.policy
+	if m.priority == PriorityLow {
+		policy = OverflowDropOldest
+	}
+	switch policy {
+	case OverflowDropOldest:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for {
+			select {
+			case ch <- m:
+				return nil
+			case <-q.done:
+				return q.closedErr()
+			default:
+			}
+			select {
+			case <-ch:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	case OverflowClose:
+		q.c.log(slog.LevelWarn, "websocket: write queue full")
+		err := q.c.writeFatal(ErrWriteQueueFull)
+		_ = q.c.Close()
+		return err
+	default:
+		select {
+		case ch <- m:
+			return nil
+		case <-q.done:
+			return q.closedErr()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// drain discards the queued messages.
+func (q *writeQueue) drain() {
+	for _, ch := range []chan queuedMessage{q.high, q.ch, q.low} {
+		for len(ch) > 0 {
+			select {
+			case <-ch:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+func (q *writeQueue) stop() {
+	q.stopOnce.Do(func() { close(q.done) })
+}
+
+// run writes queued messages until the connection is closed or a write
+// fails.
+func (q *writeQueue) run() {
+	c := q.c
+	for {
+		m, ok := q.next()
+		if !ok {
+			return
+		}
+		if q.expire(&m) {
+			continue
+		}
+		deadline := *q.deadline.Load()
+		if !m.deadline.IsZero() {
+			deadline = m.deadline
+		}
+		c.writeDeadline = deadline
+		var err error
+		if m.pm != nil {
+			err = c.writePreparedMessage(m.pm)
+		} else {
+			err = c.writeMessage(m.messageType, m.data)
+		}
+		if err != nil {
+			_ = c.writeFatal(err)
+			q.stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestWriteQueueConcurrent(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	if err := s.EnableWriteQueue(16, OverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableWriteQueue(16, OverflowBlock); err != ErrWriteQueueEnabled {
+		t.Errorf("second EnableWriteQueue returned %v, want %v", err, ErrWriteQueueEnabled)
+	}
+	if _, err := s.NextWriter(TextMessage); err != ErrWriteQueueEnabled {
+		t.Errorf("NextWriter returned %v, want %v", err, ErrWriteQueueEnabled)
+	}
+
+	const writers, messages = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				if err := s.WriteMessage(TextMessage, []byte(fmt.Sprintf("%d/%d", i, j))); err != nil {
+					t.Errorf("WriteMessage: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	next := make([]int, writers)
+	for n := 0; n < writers*messages; n++ {
+		var i, j int
+		if _, err := fmt.Sscanf(readString(t, c), "%d/%d", &i, &j); err != nil {
+			t.Fatal(err)
+		}
+		if next[i] != j {
+			t.Fatalf("writer %d: got message %d, want %d", i, j, next[i])
+		}
+		next[i]++
+	}
+	wg.Wait()
+}
+
+func TestWriteQueueDropOldest(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	_ = s.EnableWriteQueue(2, OverflowDropOldest)
+
+	// The first message is taken by the writer goroutine, which blocks on the
+	// pipe until the client reads.
+	for i := 0; i < 10; i++ {
+		if err := s.WriteMessage(BinaryMessage, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.WriteQueueDropped() == 0 {
+		t.Error("no messages dropped")
+	}
+	var got []byte
+	for len(got) == 0 || got[len(got)-1] != 9 {
+		_, p, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p...)
+	}
+	if len(got) > 4 {
+		t.Errorf("got %d messages, want at most 4", len(got))
+	}
+}
+
+func TestWriteQueueOverflowClose(t *testing.T) {
+	s, _ := newPipeConns()
+	_ = s.EnableWriteQueue(1, OverflowClose)
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = s.WriteMessage(TextMessage, bytes.Repeat([]byte{'x'}, 10))
+	}
+	if err != ErrWriteQueueFull {
+		t.Fatalf("WriteMessage returned %v, want %v", err, ErrWriteQueueFull)
+	}
+	if err := s.WriteMessage(TextMessage, nil); err != ErrWriteQueueFull {
+		t.Errorf("WriteMessage after overflow returned %v, want %v", err, ErrWriteQueueFull)
+	}
+}
@@ -0,0 +1,74 @@
+// Command wsbench load tests a WebSocket server and prints a report of the
+// throughput, latency and errors. For example, to send 10 messages per second
+// on each of 1000 connections for a minute and verify the echoes:
+//
+//	go run ./wsbench/cmd/wsbench -c 1000 -rate 10 -size 256 -echo -d 1m ws://localhost:8080/echo
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/gflydev/websocket"
+	"github.com/gflydev/websocket/wsbench"
+)
+
+type headers http.Header
+
+func (h headers) String() string { return "" }
+
+func (h headers) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("header %q is not name: value", s)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+func main() {
+	var config wsbench.Config
+	header := make(headers)
+	flag.IntVar(&config.Connections, "c", 1, "number of concurrent connections")
+	flag.DurationVar(&config.Duration, "d", 0, "duration of the test (default 10s without -n)")
+	flag.IntVar(&config.Messages, "n", 0, "number of messages per connection")
+	flag.Float64Var(&config.Rate, "rate", 0, "messages per second per connection (default as fast as possible)")
+	flag.IntVar(&config.Size, "size", 64, "message payload size in bytes")
+	text := flag.Bool("text", false, "send text messages instead of binary messages")
+	flag.BoolVar(&config.Echo, "echo", false, "wait for and verify the echo of each message")
+	flag.DurationVar(&config.Timeout, "timeout", 0, "handshake and echo timeout (default 10s)")
+	compress := flag.Bool("compress", false, "negotiate permessage-deflate")
+	flag.Var(header, "H", "add a handshake request header, name: value (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: wsbench [flags] url\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config.URL = flag.Arg(0)
+	config.Header = http.Header(header)
+	if *text {
+		config.MessageType = websocket.TextMessage
+	}
+	d := *websocket.DefaultDialer
+	d.EnableCompression = *compress
+	config.Dialer = &d
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := wsbench.Run(ctx, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(res)
+}
@@ -0,0 +1,435 @@
+// Package wsbench load tests WebSocket servers with the clients of this
+// module.
+//
+// Run opens concurrent connections to a server, sends messages of a
+// configured size and rate on each connection and reports the throughput,
+// the round trip latency of echoed messages and the errors by kind:
+//
+//	res, err := wsbench.Run(ctx, wsbench.Config{
+//		URL:         "ws://localhost:8080/echo",
+//		Connections: 1000,
+//		Rate:        10,
+//		Size:        256,
+//		Echo:        true,
+//		Duration:    time.Minute,
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Print(res)
+//
+// The wsbench command in cmd/wsbench runs a load test from the command line.
+package wsbench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// The kinds of errors counted in Result.Errors.
+const (
+	ErrorDial      = "dial"      // the connection could not be established
+	ErrorHandshake = "handshake" // the server rejected the opening handshake
+	ErrorWrite     = "write"     // writing a message failed
+	ErrorRead      = "read"      // reading a message failed
+	ErrorClosed    = "closed"    // the server closed the connection
+	ErrorTimeout   = "timeout"   // an echo did not arrive within the timeout
+	ErrorMismatch  = "mismatch"  // an echo differed from the message sent
+)
+
+// Config specifies a load test.
+type Config struct {
+	// URL is the WebSocket URL of the server.
+	URL string
+
+	// Dialer dials the connections. If nil, websocket.DefaultDialer is used.
+	Dialer *websocket.Dialer
+
+	// Header is sent with the opening handshake of each connection.
+	Header http.Header
+
+	// Connections is the number of concurrent connections. If zero, a
+	// default of 1 is used.
+	Connections int
+
+	// Duration limits the time messages are sent. If zero and Messages is
+	// zero, a default of 10 seconds is used.
+	Duration time.Duration
+
+	// Messages limits the number of messages sent on each connection. If
+	// zero, messages are sent until the duration elapses.
+	Messages int
+
+	// Rate is the number of messages per second sent on each connection. If
+	// zero, messages are sent as fast as possible.
+	Rate float64
+
+	// Size is the payload size of the messages. If zero, a default of 64
+	// bytes is used.
+	Size int
+
+	// MessageType is TextMessage or BinaryMessage. If zero, BinaryMessage is
+	// used.
+	MessageType int
+
+	// Echo specifies that the server echoes the messages. Each connection
+	// waits for the echo of a message before sending the next one, verifies
+	// the echo and records the round trip latency. When the server is slower
+	// than the rate, the rate drops accordingly.
+	Echo bool
+
+	// Timeout limits the opening handshake and the wait for each echo. If
+	// zero, a default of 10 seconds is used.
+	Timeout time.Duration
+}
+
+// Latency summarizes the round trip latencies of echoed messages.
+type Latency struct {
+	Min, Mean, P50, P90, P99, Max time.Duration
+}
+
+// Result reports the outcome of a load test.
+type Result struct {
+	// Connections is the number of connections established.
+	Connections int
+
+	// Sent and Received count the data messages sent and received on all
+	// connections, and BytesSent and BytesReceived their payload bytes.
+	Sent, Received           int64
+	BytesSent, BytesReceived int64
+
+	// Elapsed is the time from the start of sending to the end of the test.
+	Elapsed time.Duration
+
+	// Latency summarizes the round trip latencies when Config.Echo is set.
+	Latency Latency
+
+	// Errors counts the errors by kind, such as ErrorDial. A connection
+	// stops at its first error other than ErrorMismatch.
+	Errors map[string]int
+}
+
+// Throughput returns the messages sent per second.
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Sent) / r.Elapsed.Seconds()
+}
+
+// String returns a human-readable report of the result.
+func (r *Result) String() string {
+	var b strings.Builder
+	secs := r.Elapsed.Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+	fmt.Fprintf(&b, "connections: %d\n", r.Connections)
+	fmt.Fprintf(&b, "messages:    sent %d (%.1f/s), received %d (%.1f/s) in %v\n",
+		r.Sent, float64(r.Sent)/secs, r.Received, float64(r.Received)/secs, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "bytes:       sent %d (%.1f KiB/s), received %d (%.1f KiB/s)\n",
+		r.BytesSent, float64(r.BytesSent)/secs/1024, r.BytesReceived, float64(r.BytesReceived)/secs/1024)
+	if l := r.Latency; l.Max > 0 {
+		fmt.Fprintf(&b, "latency:     min %v, mean %v, p50 %v, p90 %v, p99 %v, max %v\n",
+			l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+	}
+	if len(r.Errors) > 0 {
+		kinds := make([]string, 0, len(r.Errors))
+		for kind := range r.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for i, kind := range kinds {
+			kinds[i] = kind + " " + strconv.Itoa(r.Errors[kind])
+		}
+		fmt.Fprintf(&b, "errors:      %s\n", strings.Join(kinds, ", "))
+	}
+	return b.String()
+}
+
+// client holds the counters of a connection.
+type client struct {
+	sent, received           int64
+	bytesSent, bytesReceived int64
+	latencies                []time.Duration
+	err                      string
+	mismatches               int
+}
+
+// Run runs the load test and returns the result when the duration elapses,
+// every connection has sent its messages or stopped at an error, or the
+// context is done. Run returns an error only if the configuration is
+// invalid.
+func Run(ctx context.Context, config Config) (*Result, error) {
+	if config.URL == "" {
+		return nil, errors.New("wsbench: no URL")
+	}
+	if config.MessageType == 0 {
+		config.MessageType = websocket.BinaryMessage
+	}
+	if config.MessageType != websocket.TextMessage && config.MessageType != websocket.BinaryMessage {
+		return nil, errors.New("wsbench: invalid message type")
+	}
+	if config.Connections <= 0 {
+		config.Connections = 1
+	}
+	if config.Size <= 0 {
+		config.Size = 64
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Duration <= 0 && config.Messages <= 0 {
+		config.Duration = 10 * time.Second
+	}
+	d := config.Dialer
+	if d == nil {
+		d = websocket.DefaultDialer
+	}
+
+	clients := make([]client, config.Connections)
+	conns := make([]*websocket.Conn, config.Connections)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dctx, cancel := context.WithTimeout(ctx, config.Timeout)
+			defer cancel()
+			c, _, err := d.DialContext(dctx, config.URL, config.Header)
+			switch {
+			case errors.Is(err, websocket.ErrBadHandshake):
+				clients[i].err = ErrorHandshake
+			case err != nil:
+				clients[i].err = ErrorDial
+			default:
+				conns[i] = c
+			}
+		}()
+	}
+	wg.Wait()
+
+	start := time.Now()
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(config.Duration))
+		defer cancel()
+	}
+	for i, c := range conns {
+		if c == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[i].run(ctx, c, &config)
+		}()
+	}
+	wg.Wait()
+
+	res := &Result{Elapsed: time.Since(start), Errors: make(map[string]int)}
+	var latencies []time.Duration
+	for i, cl := range clients {
+		if conns[i] != nil {
+			res.Connections++
+		}
+		res.Sent += cl.sent
+		res.Received += cl.received
+		res.BytesSent += cl.bytesSent
+		res.BytesReceived += cl.bytesReceived
+		latencies = append(latencies, cl.latencies...)
+		if cl.err != "" {
+			res.Errors[cl.err]++
+		}
+		if cl.mismatches > 0 {
+			res.Errors[ErrorMismatch] += cl.mismatches
+		}
+	}
+	res.Latency = summarize(latencies)
+	return res, nil
+}
+
+// run sends the messages of a connection until the context is done, the
+// messages are sent or an error occurs, then closes the connection.
+func (cl *client) run(ctx context.Context, c *websocket.Conn, config *Config) {
+	var interval time.Duration
+	if config.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / config.Rate)
+	}
+
+	// When the context is done, the closing handshake ends blocked reads;
+	// closing the connection ends blocked writes.
+	done := make(chan struct{})
+	var readWG sync.WaitGroup
+	defer func() {
+		close(done)
+		_ = c.Close()
+		readWG.Wait()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			sendClose(c)
+			t := time.NewTimer(closeTimeout)
+			defer t.Stop()
+			select {
+			case <-t.C:
+				_ = c.Close()
+			case <-done:
+			}
+		case <-done:
+		}
+	}()
+
+	// Without echo, a goroutine counts the messages received.
+	var mu sync.Mutex
+	if !config.Echo {
+		readWG.Add(1)
+		go func() {
+			defer readWG.Done()
+			for {
+				_, p, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				cl.received++
+				cl.bytesReceived += int64(len(p))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	p := make([]byte, config.Size)
+	next := time.Now()
+	for seq := 0; config.Messages <= 0 || seq < config.Messages; seq++ {
+		if interval > 0 {
+			if d := time.Until(next); d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+				}
+			}
+			next = next.Add(interval)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		payload(p, seq)
+		sentAt := time.Now()
+		if err := c.WriteMessage(config.MessageType, p); err != nil {
+			if ctx.Err() == nil {
+				cl.err = ErrorWrite
+			}
+			return
+		}
+		mu.Lock()
+		cl.sent++
+		cl.bytesSent += int64(len(p))
+		mu.Unlock()
+		if !config.Echo {
+			continue
+		}
+
+		_ = c.SetReadDeadline(sentAt.Add(config.Timeout))
+		_, echo, err := c.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				cl.err = readError(err)
+			}
+			return
+		}
+		cl.latencies = append(cl.latencies, time.Since(sentAt))
+		cl.received++
+		cl.bytesReceived += int64(len(echo))
+		if !bytes.Equal(echo, p) {
+			cl.mismatches++
+		}
+	}
+
+	// All messages are sent: wait for the messages in flight and the close
+	// reply of the server.
+	sendClose(c)
+	_ = c.SetReadDeadline(time.Now().Add(closeTimeout))
+	if config.Echo {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+	}
+	readWG.Wait()
+}
+
+// closeTimeout limits the closing handshake.
+const closeTimeout = time.Second
+
+func sendClose(c *websocket.Conn) {
+	_ = c.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(closeTimeout))
+}
+
+// readError returns the kind of a read error.
+func readError(err error) string {
+	var ne net.Error
+	var ce *websocket.CloseError
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &ce):
+		return ErrorClosed
+	default:
+		return ErrorRead
+	}
+}
+
+// payload fills p with the sequence number of the message followed by
+// printable filler, so that echoes of other messages are detected.
+func payload(p []byte, seq int) {
+	var num [20]byte
+	n := copy(p, strconv.AppendInt(num[:0], int64(seq), 10))
+	for i := n; i < len(p); i++ {
+		p[i] = 'a' + byte(i%26)
+	}
+	if n < len(p) {
+		p[n] = ' '
+	}
+}
+
+// summarize returns the summary of the latencies.
+func summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	slices.Sort(latencies)
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	at := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+	return Latency{
+		Min:  latencies[0],
+		Mean: sum / time.Duration(len(latencies)),
+		P50:  at(.5),
+		P90:  at(.9),
+		P99:  at(.99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
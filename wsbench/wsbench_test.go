@@ -0,0 +1,115 @@
+package wsbench
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// newServer returns the URL of a server running the handler on each
+// connection.
+func newServer(t *testing.T, handle func(c *websocket.Conn)) string {
+	t.Helper()
+	var u websocket.Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Reject") != "" {
+			http.Error(w, "rejected", http.StatusForbidden)
+			return
+		}
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		handle(c)
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func echo(c *websocket.Conn) {
+	for {
+		mt, p, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(mt, p); err != nil {
+			return
+		}
+	}
+}
+
+func TestRunEcho(t *testing.T) {
+	url := newServer(t, echo)
+	res, err := Run(context.Background(), Config{
+		URL:         url,
+		Connections: 4,
+		Messages:    50,
+		Size:        100,
+		MessageType: websocket.TextMessage,
+		Echo:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Connections != 4 || res.Sent != 200 || res.Received != 200 || res.BytesSent != 20000 || len(res.Errors) != 0 {
+		t.Fatalf("result:\n%v", res)
+	}
+	if l := res.Latency; l.Min <= 0 || l.Min > l.P50 || l.P50 > l.P99 || l.P99 > l.Max {
+		t.Errorf("latency %+v", l)
+	}
+	if !strings.Contains(res.String(), "latency:") || res.Throughput() <= 0 {
+		t.Errorf("report:\n%v", res)
+	}
+}
+
+func TestRunRate(t *testing.T) {
+	url := newServer(t, func(c *websocket.Conn) {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	res, err := Run(context.Background(), Config{URL: url, Connections: 2, Rate: 100, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each connection sends a message every 10ms.
+	if res.Sent < 20 || res.Sent > 50 || res.Received != 0 || len(res.Errors) != 0 {
+		t.Fatalf("result:\n%v", res)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	url := newServer(t, func(c *websocket.Conn) {
+		mt, p, _ := c.ReadMessage()
+		_ = c.WriteMessage(mt, append(p, 'x'))
+		_, _, _ = c.ReadMessage()
+		time.Sleep(100 * time.Millisecond)
+	})
+	res, err := Run(context.Background(), Config{URL: url, Messages: 3, Echo: true, Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Errors[ErrorMismatch] != 1 || res.Errors[ErrorTimeout] != 1 {
+		t.Errorf("errors %v", res.Errors)
+	}
+
+	res, _ = Run(context.Background(), Config{URL: url, Connections: 2, Header: http.Header{"Reject": {"1"}}})
+	if res.Connections != 0 || res.Errors[ErrorHandshake] != 2 {
+		t.Errorf("rejected handshakes: %v", res)
+	}
+	res, _ = Run(context.Background(), Config{URL: "ws://127.0.0.1:1"})
+	if res.Errors[ErrorDial] != 1 {
+		t.Errorf("failed dial: %v", res)
+	}
+	if _, err := Run(context.Background(), Config{}); err == nil {
+		t.Error("Run without URL returned no error")
+	}
+}
@@ -0,0 +1,332 @@
+// Package wsmux multiplexes streams over a single WebSocket connection.
+//
+// A Session carries any number of streams in both directions. Each stream is
+// a net.Conn with its own flow control and close, so that many channels
+// share one connection through proxies that limit the number of
+// connections. The application opens streams with Session.Open and accepts
+// the streams opened by the peer with Session.Accept; a Session is a
+// net.Listener.
+//
+// Each WebSocket binary message carries one frame: a type byte, the 32-bit
+// big-endian ID of the stream and the payload. Streams opened by the client
+// have odd IDs and streams opened by the server have even IDs. The peer of
+// a stream may send 256 KiB ahead of the reader; the reader extends the
+// window as the application consumes the data.
+package wsmux
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// Subprotocol is the subprotocol name of the multiplexing protocol.
+const Subprotocol = "wsmux"
+
+var (
+	// ErrSessionClosed is returned by the methods of a closed session and
+	// of its streams.
+	ErrSessionClosed = errors.New("wsmux: session closed")
+
+	// ErrStreamReset is returned by the methods of a stream reset by the
+	// peer, or reset because the peer sent data after Close.
+	ErrStreamReset = errors.New("wsmux: stream reset")
+
+	errProtocol = errors.New("wsmux: protocol error")
+)
+
+// Frame types.
+const (
+	frameOpen   = 1 // opens a stream
+	frameData   = 2 // carries stream data
+	frameWindow = 3 // extends the send window of the peer by a uint32
+	frameClose  = 4 // ends the data sent on a stream
+	frameReset  = 5 // aborts a stream
+)
+
+const (
+	headerSize          = 5
+	windowSize          = 256 << 10
+	defaultBacklog      = 256
+	defaultMaxFrameSize = 32 << 10
+	closeWait           = time.Second
+)
+
+// Config specifies the parameters of a session. The zero value is a valid
+// configuration.
+type Config struct {
+	// AcceptBacklog specifies the number of streams opened by the peer that
+	// wait for Accept. Streams opened beyond the backlog are reset. If
+	// zero, a default of 256 is used.
+	AcceptBacklog int
+
+	// MaxFrameSize specifies the maximum data payload of the frames
+	// written. Larger writes are split into several frames so that
+	// streams share the connection fairly. If zero, a default of 32 KiB is
+	// used.
+	MaxFrameSize int
+}
+
+// Session multiplexes streams over a WebSocket connection. The session owns
+// the connection: the application must not read from or write to the
+// connection while the session is open.
+type Session struct {
+	conn         *websocket.Conn
+	maxFrameSize int
+	parity       uint32 // of the IDs of the streams opened locally
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error // set when the session is closed
+
+	accept    chan *Stream
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*Session)(nil)
+
+// Client starts a session on the client side of a connection.
+func Client(c *websocket.Conn, config *Config) *Session {
+	return newSession(c, config, 1)
+}
+
+// Server starts a session on the server side of a connection.
+func Server(c *websocket.Conn, config *Config) *Session {
+	return newSession(c, config, 2)
+}
+
+func newSession(c *websocket.Conn, config *Config, firstID uint32) *Session {
+	var cfg Config
+	if config != nil {
+		cfg = *config
+	}
+	if cfg.AcceptBacklog <= 0 {
+		cfg.AcceptBacklog = defaultBacklog
+	}
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = defaultMaxFrameSize
+	}
+	s := &Session{
+		conn:         c,
+		maxFrameSize: cfg.MaxFrameSize,
+		streams:      make(map[uint32]*Stream),
+		nextID:       firstID,
+		parity:       firstID % 2,
+		accept:       make(chan *Stream, cfg.AcceptBacklog),
+		done:         make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+// Open opens a stream to the peer. The stream is usable immediately: Open
+// does not wait for the peer to accept it.
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(frameOpen, id, nil); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, s.err
+	}
+}
+
+// Accept waits for and returns the next stream opened by the peer. Accept
+// implements net.Listener.
+func (s *Session) Accept() (net.Conn, error) {
+	st, err := s.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Addr returns the local address of the connection.
+func (s *Session) Addr() net.Addr { return s.conn.LocalAddr() }
+
+// Close sends a close message to the peer and closes the session, its
+// streams and the connection.
+func (s *Session) Close() error {
+	_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(closeWait))
+	s.shutdown(ErrSessionClosed)
+	return nil
+}
+
+// Done returns a channel that is closed when the session is closed.
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+// Err returns the reason the session was closed, or nil if it is open.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// NumStreams returns the number of open streams.
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// shutdown closes the session with err, failing the open streams.
+func (s *Session) shutdown(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+		close(s.done)
+		_ = s.conn.Close()
+		for _, st := range streams {
+			st.fail(err)
+		}
+	})
+}
+
+func (s *Session) readLoop() {
+	for {
+		mt, p, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				err = ErrSessionClosed
+			}
+			s.shutdown(err)
+			return
+		}
+		if mt != websocket.BinaryMessage || len(p) < headerSize {
+			s.protocolError()
+			return
+		}
+		if !s.handle(p[0], binary.BigEndian.Uint32(p[1:]), p[headerSize:]) {
+			s.protocolError()
+			return
+		}
+	}
+}
+
+// protocolError closes the session after an invalid frame.
+func (s *Session) protocolError() {
+	_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, errProtocol.Error()), time.Now().Add(closeWait))
+	s.shutdown(errProtocol)
+}
+
+// handle processes a frame. It returns false for an invalid frame.
+func (s *Session) handle(typ byte, id uint32, payload []byte) bool {
+	if typ == frameOpen {
+		return s.handleOpen(id)
+	}
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	if st == nil {
+		// Frames may arrive for a stream reset or fully closed before
+		// the peer learned about it.
+		return typ >= frameData && typ <= frameReset
+	}
+	switch typ {
+	case frameData:
+		st.receive(payload)
+	case frameWindow:
+		if len(payload) != 4 {
+			return false
+		}
+		st.grow(int(binary.BigEndian.Uint32(payload)))
+	case frameClose:
+		st.remoteClose()
+	case frameReset:
+		st.fail(ErrStreamReset)
+		s.remove(id)
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *Session) handleOpen(id uint32) bool {
+	if id == 0 || id%2 == s.parity {
+		return false
+	}
+	s.mu.Lock()
+	if _, ok := s.streams[id]; ok || s.err != nil {
+		s.mu.Unlock()
+		return s.err != nil
+	}
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+	select {
+	case s.accept <- st:
+	default:
+		s.remove(id)
+		_ = s.writeFrame(frameReset, id, nil)
+	}
+	return true
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// writeFrame writes a frame to the connection. An error writing to the
+// connection closes the session.
+func (s *Session) writeFrame(typ byte, id uint32, payload []byte) error {
+	var hdr [headerSize]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint32(hdr[1:], id)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	err := func() error {
+		w, err := s.conn.NextWriter(websocket.BinaryMessage)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(hdr[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+		return w.Close()
+	}()
+	if err != nil {
+		s.shutdown(err)
+	}
+	return err
+}
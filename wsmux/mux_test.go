@@ -0,0 +1,216 @@
+package wsmux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// newSessions returns a client and a server session over a WebSocket
+// connection.
+func newSessions(t *testing.T, config *Config) (client, server *Session) {
+	t.Helper()
+	servers := make(chan *Session, 1)
+	u := websocket.Upgrader{Subprotocols: []string{Subprotocol}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		servers <- Server(c, config)
+	}))
+	t.Cleanup(s.Close)
+	d := websocket.Dialer{Subprotocols: []string{Subprotocol}}
+	c, _, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = Client(c, config)
+	server = <-servers
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// echo copies the data of the streams accepted by s back to the peer.
+func echo(s *Session) {
+	for {
+		st, err := s.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, _ = io.Copy(st, st)
+			_ = st.CloseWrite()
+		}()
+	}
+}
+
+func TestStreams(t *testing.T) {
+	client, server := newSessions(t, &Config{MaxFrameSize: 1000})
+	go echo(server)
+
+	// The streams exceed the window, so the data flows only if the
+	// windows are extended as the data is read.
+	want := bytes.Repeat([]byte("0123456789"), 100000)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, err := client.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer st.Close()
+			go func() {
+				_, _ = st.Write(want)
+				_ = st.CloseWrite()
+			}()
+			got, err := io.ReadAll(st)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("stream %d: read %d bytes, %v", st.ID(), len(got), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Both peers open streams.
+	go echo(client)
+	st, err := server.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ID()%2 != 0 {
+		t.Errorf("server stream ID %d is odd", st.ID())
+	}
+	if _, err := st.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_ = st.CloseWrite()
+	if got, err := io.ReadAll(st); err != nil || string(got) != "hello" {
+		t.Fatalf("ReadAll = %q, %v", got, err)
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	client, server := newSessions(t, nil)
+
+	st, _ := client.Open()
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	_ = st.Close()
+	if _, err := st.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read after Close returned %v", err)
+	}
+	if got, err := io.ReadAll(peer); err != nil || string(got) != "a" {
+		t.Fatalf("peer ReadAll = %q, %v", got, err)
+	}
+
+	// Writing to a closed stream resets it.
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := peer.Write([]byte("b"))
+		if err == ErrStreamReset {
+			break
+		}
+		if err != nil || time.Now().After(deadline) {
+			t.Fatalf("peer Write returned %v, want %v", err, ErrStreamReset)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for client.NumStreams() != 0 || server.NumStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d and %d streams left", client.NumStreams(), server.NumStreams())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Reset aborts both directions.
+	st, _ = client.Open()
+	peer, _ = server.Accept()
+	_ = peer.(*Stream).Reset()
+	if _, err := st.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Errorf("Read of reset stream returned %v", err)
+	}
+}
+
+func TestStreamDeadline(t *testing.T) {
+	client, server := newSessions(t, nil)
+	st, _ := client.Open()
+	peer, _ := server.AcceptStream()
+
+	_ = st.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := st.Read(make([]byte, 1))
+	var ne net.Error
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("Read returned %v, want timeout", err)
+	}
+
+	// The stream is usable after a timeout.
+	_ = st.SetReadDeadline(time.Time{})
+	go func() { _, _ = peer.Write([]byte("x")) }()
+	if p := make([]byte, 1); func() error { _, err := io.ReadFull(st, p); return err }() != nil || p[0] != 'x' {
+		t.Fatal("Read after timeout failed")
+	}
+
+	// Write times out when the peer does not read.
+	_ = st.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := st.Write(make([]byte, 2*windowSize)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write returned %v, want timeout", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := newSessions(t, nil)
+	st, _ := client.Open()
+	_, _ = server.AcceptStream()
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := client.Accept()
+		accepted <- err
+	}()
+	_ = server.Close()
+	if err := <-accepted; err != ErrSessionClosed {
+		t.Errorf("Accept returned %v, want %v", err, ErrSessionClosed)
+	}
+	if _, err := st.Read(make([]byte, 1)); err != ErrSessionClosed {
+		t.Errorf("Read returned %v, want %v", err, ErrSessionClosed)
+	}
+	if _, err := client.Open(); err != ErrSessionClosed {
+		t.Errorf("Open returned %v, want %v", err, ErrSessionClosed)
+	}
+	<-client.Done()
+}
+
+func TestAcceptBacklog(t *testing.T) {
+	client, server := newSessions(t, &Config{AcceptBacklog: 1})
+	_, _ = client.Open()
+	st, _ := client.Open()
+	if _, err := st.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Errorf("Read of stream over the backlog returned %v, want %v", err, ErrStreamReset)
+	}
+	if server.NumStreams() != 1 {
+		t.Errorf("%d server streams, want 1", server.NumStreams())
+	}
+}
@@ -0,0 +1,311 @@
+package wsmux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var errWriteClosed = errors.New("wsmux: write after CloseWrite")
+
+// Stream is a stream of a session. A Stream is a net.Conn: it supports one
+// concurrent reader and any number of concurrent writers, and its deadlines
+// are not permanent.
+type Stream struct {
+	id uint32
+	s  *Session
+
+	writeMu sync.Mutex // serializes Write
+
+	mu            sync.Mutex
+	buf           bytes.Buffer // received data not yet read
+	recvWindow    int          // bytes the peer may send
+	consumed      int          // bytes read since the last window update
+	sendWindow    int          // bytes that may be sent
+	readClosed    bool         // the peer ended its data
+	writeClosed   bool         // the data sent has been ended
+	closed        bool         // Close was called
+	err           error        // reset or session error
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readReady  chan struct{} // signaled when Read may progress
+	writeReady chan struct{} // signaled when Write may progress
+}
+
+var _ net.Conn = (*Stream)(nil)
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		s:          s,
+		recvWindow: windowSize,
+		sendWindow: windowSize,
+		readReady:  make(chan struct{}, 1),
+		writeReady: make(chan struct{}, 1),
+	}
+}
+
+// ID returns the ID of the stream.
+func (st *Stream) ID() uint32 { return st.id }
+
+// Read reads data sent by the peer. It returns io.EOF after the peer
+// called Close or CloseWrite and the data has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		switch {
+		case st.closed:
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		case st.buf.Len() > 0:
+			n, _ := st.buf.Read(p)
+			st.consumed += n
+			grant := 0
+			if st.consumed >= windowSize/2 && !st.readClosed && st.err == nil {
+				grant = st.consumed
+				st.recvWindow += grant
+				st.consumed = 0
+			}
+			st.mu.Unlock()
+			if grant > 0 {
+				var b [4]byte
+				binary.BigEndian.PutUint32(b[:], uint32(grant))
+				_ = st.s.writeFrame(frameWindow, st.id, b[:])
+			}
+			return n, nil
+		case st.err != nil:
+			st.mu.Unlock()
+			return 0, st.err
+		case st.readClosed:
+			st.mu.Unlock()
+			return 0, io.EOF
+		case len(p) == 0:
+			st.mu.Unlock()
+			return 0, nil
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+		if err := wait(st.readReady, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes data to the stream, waiting for the peer to extend the
+// window when the peer does not read.
+func (st *Stream) Write(p []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+	n := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		var err error
+		switch {
+		case st.closed:
+			err = net.ErrClosed
+		case st.err != nil:
+			err = st.err
+		case st.writeClosed:
+			err = errWriteClosed
+		}
+		if err != nil {
+			st.mu.Unlock()
+			return n, err
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := wait(st.writeReady, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		chunk := min(len(p), st.sendWindow, st.s.maxFrameSize)
+		st.sendWindow -= chunk
+		st.mu.Unlock()
+		if err := st.s.writeFrame(frameData, st.id, p[:chunk]); err != nil {
+			return n, err
+		}
+		n += chunk
+		p = p[chunk:]
+	}
+	return n, nil
+}
+
+// CloseWrite ends the data sent on the stream. The peer reads io.EOF after
+// the data; the stream remains readable.
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.closed || st.writeClosed || st.err != nil {
+		err := st.err
+		if st.closed {
+			err = net.ErrClosed
+		}
+		st.mu.Unlock()
+		return err
+	}
+	st.writeClosed = true
+	done := st.readClosed
+	st.mu.Unlock()
+	st.notify(st.writeReady)
+	if done {
+		st.s.remove(st.id)
+	}
+	return st.s.writeFrame(frameClose, st.id, nil)
+}
+
+// Close ends the data sent on the stream and discards the data received.
+// The stream is reset if the peer sends more data.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	sendClose := !st.writeClosed && st.err == nil
+	st.writeClosed = true
+	done := st.readClosed || st.err != nil
+	st.buf.Reset()
+	st.mu.Unlock()
+	st.notify(st.readReady)
+	st.notify(st.writeReady)
+	if done {
+		st.s.remove(st.id)
+	}
+	if sendClose {
+		return st.s.writeFrame(frameClose, st.id, nil)
+	}
+	return nil
+}
+
+// Reset aborts the stream in both directions.
+func (st *Stream) Reset() error {
+	st.mu.Lock()
+	if st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.mu.Unlock()
+	st.fail(net.ErrClosed)
+	st.s.remove(st.id)
+	return st.s.writeFrame(frameReset, st.id, nil)
+}
+
+// LocalAddr returns the local address of the connection of the session.
+func (st *Stream) LocalAddr() net.Addr { return st.s.conn.LocalAddr() }
+
+// RemoteAddr returns the remote address of the connection of the session.
+func (st *Stream) RemoteAddr() net.Addr { return st.s.conn.RemoteAddr() }
+
+// SetDeadline sets the read and write deadlines of the stream.
+func (st *Stream) SetDeadline(t time.Time) error {
+	_ = st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of Read. A Read past the deadline
+// returns an error wrapping os.ErrDeadlineExceeded. Unlike the deadlines of
+// websocket.Conn, the stream remains usable after a timeout.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	st.notify(st.readReady)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of Write for waiting on the window of
+// the peer.
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	st.notify(st.writeReady)
+	return nil
+}
+
+// receive buffers data sent by the peer. Data beyond the window, or sent
+// after Close or CloseWrite by the peer, resets the stream.
+func (st *Stream) receive(p []byte) {
+	st.mu.Lock()
+	if st.err != nil {
+		st.mu.Unlock()
+		return
+	}
+	if st.closed || st.readClosed || len(p) > st.recvWindow {
+		st.mu.Unlock()
+		st.fail(ErrStreamReset)
+		st.s.remove(st.id)
+		_ = st.s.writeFrame(frameReset, st.id, nil)
+		return
+	}
+	st.recvWindow -= len(p)
+	st.buf.Write(p)
+	st.mu.Unlock()
+	st.notify(st.readReady)
+}
+
+// grow extends the send window.
+func (st *Stream) grow(n int) {
+	st.mu.Lock()
+	st.sendWindow += n
+	st.mu.Unlock()
+	st.notify(st.writeReady)
+}
+
+// remoteClose records the end of the data sent by the peer.
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.readClosed = true
+	done := st.writeClosed
+	st.mu.Unlock()
+	st.notify(st.readReady)
+	if done {
+		st.s.remove(st.id)
+	}
+}
+
+// fail aborts the stream with err.
+func (st *Stream) fail(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.mu.Unlock()
+	st.notify(st.readReady)
+	st.notify(st.writeReady)
+}
+
+func (st *Stream) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits for a signal on ch until the deadline.
+func wait(ch <-chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-t.C:
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,330 @@
+// Package wstest provides in-memory connections for testing WebSocket
+// handlers and the code built on connections, such as hubs and routers,
+// without servers and sockets.
+//
+// NewPipe returns a client and a server connection connected in memory:
+//
+//	client, server := wstest.NewPipe()
+//	defer client.Close()
+//	defer server.Close()
+//	go handle(server)
+//	client.WriteMessage(websocket.TextMessage, []byte("ping"))
+//
+// The deadlines of the connections follow a Clock. With a FakeClock, tests
+// expire read and write deadlines by advancing the clock instead of sleeping.
+//
+// NewServer runs a handler in an httptest.Server and returns a connected
+// client, and the Expect functions assert the messages the client receives:
+//
+//	c, _ := wstest.NewServer(t, wstest.Handler(echo))
+//	c.WriteMessage(websocket.TextMessage, []byte("hello"))
+//	wstest.ExpectMessage(t, c, websocket.TextMessage, "hello")
+package wstest
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// Config configures the connections returned by NewPipeConfig.
+type Config struct {
+	// Clock is the time source of the deadlines of the connections. If nil,
+	// the real time is used.
+	Clock Clock
+
+	// Upgrader upgrades the server connection. If nil, the zero Upgrader is
+	// used.
+	Upgrader *websocket.Upgrader
+
+	// Dialer performs the client side of the opening handshake. Its network
+	// dial functions are ignored. If nil, a Dialer without options is used.
+	Dialer *websocket.Dialer
+
+	// RequestHeader is sent with the opening handshake.
+	RequestHeader http.Header
+
+	// BufferSize is the number of bytes buffered in each direction before
+	// writes block. If zero, a default of 1 MiB is used.
+	BufferSize int
+}
+
+// NewPipe returns a client and a server connection connected in memory. The
+// connections buffer up to 1 MiB in each direction, so tests can write
+// messages and read them afterwards in the same goroutine.
+func NewPipe() (client, server *websocket.Conn) {
+	client, server, err := NewPipeConfig(Config{})
+	if err != nil {
+		panic("wstest: " + err.Error())
+	}
+	return client, server
+}
+
+// NewPipeConfig returns a client and a server connection connected in memory,
+// as configured. It returns an error if the opening handshake fails.
+func NewPipeConfig(config Config) (client, server *websocket.Conn, err error) {
+	clientConn, serverConn := NetPipe(config.Clock, config.BufferSize)
+
+	u := config.Upgrader
+	if u == nil {
+		u = &websocket.Upgrader{}
+	}
+	type result struct {
+		c   *websocket.Conn
+		err error
+	}
+	upgraded := make(chan result, 1)
+	go func() {
+		c, _, err := u.UpgradeNetConn(serverConn, nil)
+		upgraded <- result{c, err}
+	}()
+
+	var d websocket.Dialer
+	if config.Dialer != nil {
+		d = *config.Dialer
+	}
+	d.NetDial = nil
+	d.NetDialTLSContext = nil
+	d.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return clientConn, nil
+	}
+	client, _, err = d.Dial("ws://wstest/", config.RequestHeader)
+	res := <-upgraded
+	if err != nil || res.err != nil {
+		if res.c != nil {
+			res.c.Close()
+		}
+		if client != nil {
+			client.Close()
+		}
+		_ = clientConn.Close()
+		if err == nil {
+			err = res.err
+		}
+		return nil, nil, err
+	}
+	return client, res.c, nil
+}
+
+const defaultBufferSize = 1 << 20
+
+// NetPipe returns the ends of a buffered, in-memory, full duplex network
+// connection. Unlike net.Pipe, writes return once the data is buffered; a
+// write blocks only while bufferSize bytes wait to be read. Deadlines follow
+// the clock; if the clock is nil, the real time is used. If bufferSize is
+// zero, a default of 1 MiB is used.
+func NetPipe(clock Clock, bufferSize int) (net.Conn, net.Conn) {
+	if clock == nil {
+		clock = realClock{}
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	ab := newPipeBuffer(bufferSize)
+	ba := newPipeBuffer(bufferSize)
+	a := newPipeConn(clock, ba, ab, pipeAddr("client"), pipeAddr("server"))
+	b := newPipeConn(clock, ab, ba, pipeAddr("server"), pipeAddr("client"))
+	return a, b
+}
+
+// pipeBuffer holds the data in flight in one direction.
+type pipeBuffer struct {
+	mu           sync.Mutex
+	data         []byte
+	size         int
+	writerClosed bool          // reads return io.EOF once data is drained
+	readerClosed bool          // writes fail
+	changed      chan struct{} // closed and replaced when the state changes
+}
+
+func newPipeBuffer(size int) *pipeBuffer {
+	return &pipeBuffer{size: size, changed: make(chan struct{})}
+}
+
+// notify wakes the waiting readers and writers. The caller must hold b.mu.
+func (b *pipeBuffer) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// pipeDeadline is a deadline that closes a channel when it expires.
+type pipeDeadline struct {
+	mu      sync.Mutex
+	clock   Clock
+	stop    func() bool
+	expired chan struct{}
+}
+
+func newPipeDeadline(clock Clock) *pipeDeadline {
+	return &pipeDeadline{clock: clock, expired: make(chan struct{})}
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop != nil && !d.stop() {
+		// The deadline expired or is expiring: wait on a new channel.
+		d.expired = make(chan struct{})
+	}
+	d.stop = nil
+	select {
+	case <-d.expired:
+		d.expired = make(chan struct{})
+	default:
+	}
+	if t.IsZero() {
+		return
+	}
+	expired := d.expired
+	if dur := t.Sub(d.clock.Now()); dur > 0 {
+		var once sync.Once
+		d.stop = d.clock.AfterFunc(dur, func() { once.Do(func() { close(expired) }) })
+	} else {
+		close(expired)
+	}
+}
+
+func (d *pipeDeadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "wstest" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is an end of a connection returned by NetPipe.
+type pipeConn struct {
+	rb, wb        *pipeBuffer // the buffers read from and written to
+	local, remote net.Addr
+
+	readDeadline, writeDeadline *pipeDeadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newPipeConn(clock Clock, rb, wb *pipeBuffer, local, remote net.Addr) *pipeConn {
+	return &pipeConn{
+		rb:            rb,
+		wb:            wb,
+		local:         local,
+		remote:        remote,
+		readDeadline:  newPipeDeadline(clock),
+		writeDeadline: newPipeDeadline(clock),
+		closed:        make(chan struct{}),
+	}
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	for {
+		select {
+		case <-c.closed:
+			return 0, io.ErrClosedPipe
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		default:
+		}
+		b := c.rb
+		b.mu.Lock()
+		if len(b.data) > 0 || len(p) == 0 {
+			n := copy(p, b.data)
+			b.data = b.data[n:]
+			b.notify()
+			b.mu.Unlock()
+			return n, nil
+		}
+		if b.writerClosed {
+			b.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-c.closed:
+		case <-c.readDeadline.wait():
+		}
+	}
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	var n int
+	for {
+		select {
+		case <-c.closed:
+			return n, io.ErrClosedPipe
+		case <-c.writeDeadline.wait():
+			return n, os.ErrDeadlineExceeded
+		default:
+		}
+		b := c.wb
+		b.mu.Lock()
+		if b.readerClosed {
+			b.mu.Unlock()
+			return n, io.ErrClosedPipe
+		}
+		if free := b.size - len(b.data); free > 0 {
+			m := min(free, len(p)-n)
+			b.data = append(b.data, p[n:n+m]...)
+			n += m
+			b.notify()
+		}
+		if n == len(p) {
+			b.mu.Unlock()
+			return n, nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-c.closed:
+		case <-c.writeDeadline.wait():
+		}
+	}
+}
+
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.wb.mu.Lock()
+		c.wb.writerClosed = true
+		c.wb.notify()
+		c.wb.mu.Unlock()
+		c.rb.mu.Lock()
+		c.rb.readerClosed = true
+		c.rb.data = nil
+		c.rb.notify()
+		c.rb.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
@@ -0,0 +1,134 @@
+package wstest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+func TestNewPipe(t *testing.T) {
+	client, server := NewPipe()
+	defer client.Close()
+	defer server.Close()
+
+	// Writes are buffered, so one goroutine writes and reads.
+	big := bytes.Repeat([]byte("x"), 100000)
+	for _, c := range []*websocket.Conn{client, server} {
+		if err := c.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WriteMessage(websocket.BinaryMessage, big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []*websocket.Conn{server, client} {
+		if mt, p, err := c.ReadMessage(); err != nil || mt != websocket.TextMessage || string(p) != "hello" {
+			t.Fatalf("ReadMessage = %d, %q, %v", mt, p, err)
+		}
+		if _, p, err := c.ReadMessage(); err != nil || !bytes.Equal(p, big) {
+			t.Fatalf("ReadMessage = %d bytes, %v", len(p), err)
+		}
+	}
+
+	_ = client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"))
+	if _, _, err := server.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("ReadMessage returned %v, want close", err)
+	}
+}
+
+func TestNewPipeConfig(t *testing.T) {
+	u := websocket.Upgrader{Subprotocols: []string{"chat"}}
+	d := websocket.Dialer{Subprotocols: []string{"chat"}}
+	client, server, err := NewPipeConfig(Config{
+		Upgrader:      &u,
+		Dialer:        &d,
+		RequestHeader: http.Header{"Origin": {"http://wstest"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+	if client.Subprotocol() != "chat" || server.Subprotocol() != "chat" {
+		t.Errorf("subprotocols %q and %q", client.Subprotocol(), server.Subprotocol())
+	}
+
+	u.CheckOrigin = func(*http.Request) bool { return false }
+	if _, _, err := NewPipeConfig(Config{Upgrader: &u}); !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Errorf("rejected handshake returned %v", err)
+	}
+}
+
+func TestFakeClockDeadline(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1e9, 0))
+	client, server, err := NewPipeConfig(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+
+	_ = server.SetReadDeadline(clock.Now().Add(time.Minute))
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage()
+		read <- err
+	}()
+	clock.Advance(59 * time.Second)
+	select {
+	case err := <-read:
+		t.Fatalf("ReadMessage returned %v before the deadline", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	var ne net.Error
+	if err := <-read; !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("ReadMessage returned %v, want timeout", err)
+	}
+}
+
+func TestNetPipe(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1e9, 0))
+	a, b := NetPipe(clock, 4)
+
+	// Writes block when the buffer is full until the data is read or the
+	// deadline expires.
+	if n, err := a.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	_ = a.SetWriteDeadline(clock.Now().Add(time.Second))
+	written := make(chan error, 1)
+	go func() {
+		_, err := a.Write([]byte("defghijk"))
+		written <- err
+	}()
+	p := make([]byte, 6)
+	if n, _ := io.ReadFull(b, p); n != 6 || string(p) != "abcdef" {
+		t.Fatalf("Read %q", p[:n])
+	}
+	clock.Advance(time.Second)
+	if err := <-written; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write returned %v, want deadline exceeded", err)
+	}
+
+	// Data written before Close is read before io.EOF.
+	_ = a.Close()
+	got, err := io.ReadAll(b)
+	if err != nil || !strings.HasPrefix("ghij", string(got)) {
+		t.Fatalf("ReadAll = %q, %v", got, err)
+	}
+	if _, err := b.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("Write to closed peer returned %v", err)
+	}
+	if _, err := a.Read(p); err != io.ErrClosedPipe {
+		t.Errorf("Read after Close returned %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package wstest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gflydev/websocket"
+)
+
+// expectTimeout bounds the wait of the Expect functions.
+const expectTimeout = 5 * time.Second
+
+// Handler returns an HTTP handler that upgrades each request with the zero
+// Upgrader, calls f with the connection and closes the connection when f
+// returns.
+func Handler(f func(c *websocket.Conn)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var u websocket.Upgrader
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		f(c)
+	})
+}
+
+// NewServer starts an httptest.Server running h, dials it and returns the
+// client connection and the server. The connection and the server are
+// closed when the test ends. Use Dial to connect more clients to the server.
+func NewServer(tb testing.TB, h http.Handler) (*websocket.Conn, *httptest.Server) {
+	tb.Helper()
+	s := httptest.NewServer(h)
+	tb.Cleanup(s.Close)
+	return Dial(tb, s.URL, nil), s
+}
+
+// Dial connects to the WebSocket server at url, which may have an http or
+// https scheme, and returns the connection. The test fails if the dial
+// fails. The connection is closed when the test ends.
+func Dial(tb testing.TB, url string, header http.Header) *websocket.Conn {
+	tb.Helper()
+	if rest, ok := strings.CutPrefix(url, "http"); ok {
+		url = "ws" + rest
+	}
+	c, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		tb.Fatalf("wstest: dial %s: %v", url, err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
+
+// read reads the next message, failing the test if no message arrives
+// within expectTimeout.
+func read(tb testing.TB, c *websocket.Conn) (int, []byte, error) {
+	tb.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(expectTimeout))
+	mt, p, err := c.ReadMessage()
+	var ce *websocket.CloseError
+	if err != nil && !errors.As(err, &ce) {
+		tb.Fatalf("wstest: read: %v", err)
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	return mt, p, err
+}
+
+func messageName(messageType int) string {
+	switch messageType {
+	case websocket.TextMessage:
+		return "text"
+	case websocket.BinaryMessage:
+		return "binary"
+	}
+	return fmt.Sprintf("type %d", messageType)
+}
+
+// ExpectMessage reads the next message from c and fails the test unless it
+// has the message type and the payload want. ExpectMessage fails the test if
+// no message arrives within five seconds or the connection is closed.
+func ExpectMessage(tb testing.TB, c *websocket.Conn, messageType int, want string) {
+	tb.Helper()
+	mt, p, err := read(tb, c)
+	if err != nil {
+		tb.Fatalf("wstest: got %v, want %s message %q", err, messageName(messageType), want)
+	}
+	if mt != messageType || string(p) != want {
+		tb.Fatalf("wstest: got %s message %q, want %s message %q", messageName(mt), p, messageName(messageType), want)
+	}
+}
+
+// ExpectJSON reads the next message from c, decodes it as JSON into a value
+// of the type of want and fails the test unless the value equals want, as
+// reported by reflect.DeepEqual. Want must not be nil.
+func ExpectJSON(tb testing.TB, c *websocket.Conn, want interface{}) {
+	tb.Helper()
+	_, p, err := read(tb, c)
+	if err != nil {
+		tb.Fatalf("wstest: got %v, want JSON message %+v", err, want)
+	}
+	got := reflect.New(reflect.TypeOf(want))
+	if err := json.Unmarshal(p, got.Interface()); err != nil {
+		tb.Fatalf("wstest: decode message %q: %v", p, err)
+	}
+	if !reflect.DeepEqual(got.Elem().Interface(), want) {
+		tb.Fatalf("wstest: got JSON message %s, want %+v", p, want)
+	}
+}
+
+// ExpectClose reads the next message from c and fails the test unless it is
+// a close message with the close code. Use CloseNoStatusReceived for a close
+// message without a code.
+func ExpectClose(tb testing.TB, c *websocket.Conn, code int) {
+	tb.Helper()
+	mt, p, err := read(tb, c)
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) {
+		tb.Fatalf("wstest: got %s message %q, want close %d", messageName(mt), p, code)
+	}
+	if ce.Code != code {
+		tb.Fatalf("wstest: got close %d %q, want close %d", ce.Code, ce.Text, code)
+	}
+}
@@ -0,0 +1,81 @@
+package wstest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gflydev/websocket"
+)
+
+func echo(c *websocket.Conn) {
+	for {
+		mt, p, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if string(p) == "quit" {
+			_ = c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"))
+			return
+		}
+		_ = c.WriteMessage(mt, p)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	c, s := NewServer(t, Handler(echo))
+	_ = c.WriteMessage(websocket.TextMessage, []byte(`{"n":1,"s":"a"}`))
+	ExpectJSON(t, c, map[string]interface{}{"n": 1.0, "s": "a"})
+	_ = c.WriteMessage(websocket.BinaryMessage, []byte{1, 2})
+	ExpectMessage(t, c, websocket.BinaryMessage, "\x01\x02")
+
+	other := Dial(t, s.URL, nil)
+	_ = other.WriteMessage(websocket.TextMessage, []byte("quit"))
+	ExpectClose(t, other, websocket.CloseGoingAway)
+}
+
+// failTB records the failure of a test helper.
+type failTB struct {
+	testing.TB
+	msg string
+}
+
+func (tb *failTB) Helper() {}
+
+func (tb *failTB) Fatalf(format string, args ...interface{}) {
+	tb.msg = fmt.Sprintf(format, args...)
+	panic(tb)
+}
+
+// failure returns the failure message of f.
+func failure(t *testing.T, f func(tb testing.TB)) (msg string) {
+	tb := &failTB{TB: t}
+	defer func() {
+		if r := recover(); r != nil && r != tb {
+			panic(r)
+		}
+		msg = tb.msg
+	}()
+	f(tb)
+	return ""
+}
+
+func TestExpectFailures(t *testing.T) {
+	c, _ := NewServer(t, Handler(echo))
+	for _, tt := range []struct {
+		send   string
+		expect func(tb testing.TB)
+		want   string
+	}{
+		{"a", func(tb testing.TB) { ExpectMessage(tb, c, websocket.TextMessage, "b") }, `got text message "a", want text message "b"`},
+		{"a", func(tb testing.TB) { ExpectMessage(tb, c, websocket.BinaryMessage, "a") }, `got text message "a", want binary message "a"`},
+		{`{"n":2}`, func(tb testing.TB) { ExpectJSON(tb, c, struct{ N int }{1}) }, `got JSON message {"n":2}, want {N:1}`},
+		{"a", func(tb testing.TB) { ExpectClose(tb, c, websocket.CloseGoingAway) }, `got text message "a", want close 1001`},
+		{"quit", func(tb testing.TB) { ExpectClose(tb, c, websocket.CloseNormalClosure) }, `got close 1001 "bye", want close 1000`},
+	} {
+		_ = c.WriteMessage(websocket.TextMessage, []byte(tt.send))
+		if got := failure(t, tt.expect); !strings.HasSuffix(got, tt.want) {
+			t.Errorf("failure %q, want %q", got, tt.want)
+		}
+	}
+}